@@ -0,0 +1,6 @@
+fn main() {
+    // The gRPC stubs need protoc; only generate them when asked for.
+    #[cfg(feature = "grpc")]
+    tonic_build::compile_protos("proto/nfs_gaze.proto")
+        .expect("failed to compile proto/nfs_gaze.proto");
+}
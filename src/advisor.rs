@@ -0,0 +1,227 @@
+//! Heuristic findings over a monitoring session: patterns worth a human
+//! look, each with a suggested next step.
+
+use crate::session::{MountSession, Session};
+use serde::Serialize;
+use std::fmt;
+
+/// GETATTR share of all operations, in percent, that counts as a storm.
+const GETATTR_STORM_PCT: f64 = 40.0;
+/// Below this many ops/s a high GETATTR share is not worth reporting.
+const MIN_STORM_IOPS: f64 = 50.0;
+/// Average READ/WRITE size, in KB, below which I/O counts as small.
+const SMALL_IO_KB: f64 = 16.0;
+/// Data operations needed before the average size is meaningful.
+const MIN_DATA_OPS: i64 = 1000;
+/// Average data-op RTT, in ms, considered slow.
+const SLOW_DATA_RTT_MS: f64 = 20.0;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Severity {
+    Info,
+    Warning,
+    Critical,
+}
+
+impl fmt::Display for Severity {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            Severity::Info => "INFO",
+            Severity::Warning => "WARNING",
+            Severity::Critical => "CRITICAL",
+        })
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Finding {
+    pub severity: Severity,
+    pub mount_point: String,
+    pub title: String,
+    pub detail: String,
+    pub recommendation: &'static str,
+}
+
+/// Families of findings, so callers can run only the checks that matter
+/// for the scenario at hand.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Check {
+    Stalls,
+    Retrans,
+    GetattrStorm,
+    SmallIo,
+    SlowIo,
+}
+
+pub const ALL_CHECKS: &[Check] = &[
+    Check::Stalls,
+    Check::Retrans,
+    Check::GetattrStorm,
+    Check::SmallIo,
+    Check::SlowIo,
+];
+
+fn analyze_mount(mount: &MountSession, checks: &[Check], findings: &mut Vec<Finding>) {
+    let mut add = |severity, title: &str, detail: String, recommendation| {
+        findings.push(Finding {
+            severity,
+            mount_point: mount.mount_point.clone(),
+            title: title.to_string(),
+            detail,
+            recommendation,
+        })
+    };
+
+    if checks.contains(&Check::Stalls) && mount.stalled_intervals > 0 {
+        add(
+            Severity::Critical,
+            "Server not responding",
+            format!(
+                "{} interval(s) where RPCs were sent and no replies came back",
+                mount.stalled_intervals
+            ),
+            "Check server health and the network path; look for 'server not responding' in dmesg.",
+        );
+    }
+
+    let retrans = mount.total_retrans();
+    if checks.contains(&Check::Retrans) && (retrans > 0 || mount.bad_xids > 0) {
+        let mut detail = format!(
+            "{} retransmission(s) across {} of {} intervals",
+            retrans, mount.retrans_intervals, mount.intervals
+        );
+        if mount.bad_xids > 0 {
+            detail.push_str(&format!(
+                ", {} late or duplicate replies (bad XIDs)",
+                mount.bad_xids
+            ));
+        }
+        add(
+            Severity::Warning,
+            "Retransmissions",
+            detail,
+            "Look for packet loss or congestion between client and server, and review timeo=/retrans=.",
+        );
+    }
+
+    let getattr_share = mount.op_share("GETATTR");
+    if checks.contains(&Check::GetattrStorm)
+        && getattr_share >= GETATTR_STORM_PCT
+        && mount.avg_iops() >= MIN_STORM_IOPS
+    {
+        add(
+            Severity::Warning,
+            "GETATTR storm",
+            format!(
+                "GETATTR is {:.0}% of all operations ({:.0} ops/s overall)",
+                getattr_share,
+                mount.avg_iops()
+            ),
+            "Attribute cache revalidation dominates; check for noac/actimeo=0 and applications polling stat().",
+        );
+    }
+
+    for name in ["READ", "WRITE"] {
+        let Some(op) = mount.ops.get(name) else {
+            continue;
+        };
+        if checks.contains(&Check::SmallIo)
+            && op.ops >= MIN_DATA_OPS
+            && op.kb_per_op() < SMALL_IO_KB
+        {
+            add(
+                Severity::Info,
+                &format!("Small {}s", name.to_lowercase()),
+                format!(
+                    "{} ops averaged {:.1} KB, well below rsize/wsize",
+                    name,
+                    op.kb_per_op()
+                ),
+                "The application issues small or random I/O; larger buffers or read-ahead tuning may help.",
+            );
+        }
+        if checks.contains(&Check::SlowIo) && op.ops > 0 && op.avg_rtt() > SLOW_DATA_RTT_MS {
+            add(
+                Severity::Warning,
+                &format!("Slow {}s", name.to_lowercase()),
+                format!(
+                    "{} averaged {:.1} ms RTT (peak interval {:.1} ms)",
+                    name,
+                    op.avg_rtt(),
+                    op.peak_avg_rtt
+                ),
+                "Server-side latency is high; check server load and storage back-end.",
+            );
+        }
+    }
+}
+
+/// All findings for the session, most severe first.
+pub fn analyze(session: &Session) -> Vec<Finding> {
+    analyze_checks(session, ALL_CHECKS)
+}
+
+/// Like [`analyze`], restricted to `checks`.
+pub fn analyze_checks(session: &Session, checks: &[Check]) -> Vec<Finding> {
+    let mut findings = Vec::new();
+    for mount in session.mounts.values() {
+        analyze_mount(mount, checks, &mut findings);
+    }
+    findings.sort_by(|a, b| {
+        b.severity
+            .cmp(&a.severity)
+            .then_with(|| a.mount_point.cmp(&b.mount_point))
+    });
+    findings
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::xprt::NFSTransport;
+    use chrono::Utc;
+
+    #[test]
+    fn test_findings() {
+        let now = Utc::now();
+        let mut session = Session::new(now);
+
+        let mut read = stat("READ", 2000, 30.0);
+        read.delta_bytes = 2000 * 4096;
+        read.delta_retrans = 5;
+        session.record("/mnt/a", now, 1.0, &[read, stat("GETATTR", 3000, 0.5)]);
+        session.record("/mnt/b", now, 1.0, &[stat("READ", 10, 1.0)]);
+
+        let findings = analyze(&session);
+        let titles: Vec<&str> = findings.iter().map(|f| f.title.as_str()).collect();
+        assert!(titles.contains(&"Retransmissions"));
+        assert!(titles.contains(&"GETATTR storm"));
+        assert!(titles.contains(&"Small reads"));
+        assert!(titles.contains(&"Slow reads"));
+        assert!(findings.iter().all(|f| f.mount_point == "/mnt/a"));
+        assert_eq!(findings[0].severity, Severity::Warning);
+
+        let only_retrans = analyze_checks(&session, &[Check::Retrans]);
+        assert_eq!(only_retrans.len(), 1);
+        assert_eq!(only_retrans[0].title, "Retransmissions");
+    }
+
+    #[test]
+    fn test_stall_is_critical() {
+        let now = Utc::now();
+        let mut session = Session::new(now);
+        // No operation completes while the server is silent, so the op
+        // rows are all zero; only the transport shows requests going out.
+        session.record("/mnt", now, 1.0, &[stat("WRITE", 0, 0.0)]);
+        let before = NFSTransport::parse("tcp 869 1 1 0 0 500 500 0 900 0").unwrap();
+        let after = NFSTransport::parse("tcp 869 1 1 0 0 508 500 0 940 16").unwrap();
+        session.record_transport("/mnt", &after.delta(&before));
+
+        let findings = analyze(&session);
+        assert_eq!(findings[0].severity, Severity::Critical);
+        assert_eq!(findings[0].title, "Server not responding");
+        assert_eq!(findings.len(), 1);
+    }
+}
@@ -0,0 +1,222 @@
+//! `nfs-gaze agent --collector URL`: sample locally and push each
+//! interval to a central `nfs-gaze collector`, for fleet-wide dashboards
+//! without scraping every host.
+//!
+//! Intervals are posted as JSON over plain HTTP, in the `/intervals/latest`
+//! schema of `nfs-gaze serve` plus the pushing host's name.
+
+use crate::hostmeta::HostMetadata;
+use crate::labels::Labels;
+use crate::output::interval_json;
+use crate::sampling::Interval;
+use crate::selection::MountSelector;
+use crate::types::{NfsGazeError, Result};
+use crate::watcher::{IntervalStats, Watcher};
+use chrono::SecondsFormat;
+use clap::Args;
+use serde_json::{json, Value};
+use std::io::{self, Read, Write};
+use std::net::{TcpStream, ToSocketAddrs};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::Duration;
+
+const PUSH_TIMEOUT: Duration = Duration::from_secs(5);
+
+#[derive(Args, Debug, Clone)]
+pub struct AgentArgs {
+    /// Collector to push to, e.g. http://collector:9097
+    #[arg(long = "collector", value_name = "URL", value_parser = parse_collector_url)]
+    pub collector: CollectorUrl,
+
+    /// Time between pushes: seconds, or with a unit (500ms, 2s, 1m)
+    #[arg(short = 'i', long = "interval", default_value = "10")]
+    pub interval: Interval,
+
+    /// Name this host reports as (default: the kernel hostname)
+    #[arg(long = "host-name", value_name = "NAME")]
+    pub host_name: Option<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CollectorUrl {
+    /// `host:port`, also sent as the Host header.
+    pub authority: String,
+    pub path: String,
+}
+
+/// Accept `http://host[:port][/path]`; the port defaults to 9097 and the
+/// path to `/push`.
+pub fn parse_collector_url(s: &str) -> std::result::Result<CollectorUrl, String> {
+    let rest = s
+        .strip_prefix("http://")
+        .ok_or_else(|| format!("collector URL must start with http://: '{}'", s))?;
+    let (authority, path) = match rest.find('/') {
+        Some(i) => (&rest[..i], &rest[i..]),
+        None => (rest, "/push"),
+    };
+    if authority.is_empty() {
+        return Err(format!("collector URL has no host: '{}'", s));
+    }
+    let has_port = authority
+        .rsplit_once(':')
+        .is_some_and(|(_, port)| port.parse::<u16>().is_ok());
+    Ok(CollectorUrl {
+        authority: if has_port {
+            authority.to_string()
+        } else {
+            format!("{}:9097", authority)
+        },
+        path: if path == "/" { "/push" } else { path }.to_string(),
+    })
+}
+
+/// The document pushed for one interval.
+pub fn push_payload(host: &str, interval: &IntervalStats, labels: &Labels) -> Value {
+    let mounts: Vec<Value> = interval
+        .mounts
+        .iter()
+        .map(|m| {
+            interval_json(
+                &m.mount,
+                interval.timestamp,
+                interval.interval_secs,
+                &m.stats,
+                labels,
+            )
+        })
+        .collect();
+    json!({
+        "host": host,
+        "timestamp": interval.timestamp.to_rfc3339_opts(SecondsFormat::Millis, true),
+        "interval_secs": interval.interval_secs,
+        "mounts": mounts,
+    })
+}
+
+/// POST `body` as JSON and fail unless the collector answers 2xx.
+pub fn post_json(url: &CollectorUrl, body: &Value) -> io::Result<()> {
+    let addr = url
+        .authority
+        .to_socket_addrs()?
+        .next()
+        .ok_or_else(|| io::Error::other(format!("cannot resolve {}", url.authority)))?;
+    let mut stream = TcpStream::connect_timeout(&addr, PUSH_TIMEOUT)?;
+    stream.set_read_timeout(Some(PUSH_TIMEOUT))?;
+    stream.set_write_timeout(Some(PUSH_TIMEOUT))?;
+
+    let body = body.to_string();
+    write!(
+        stream,
+        "POST {} HTTP/1.1\r\nHost: {}\r\nContent-Type: application/json\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{}",
+        url.path,
+        url.authority,
+        body.len(),
+        body
+    )?;
+    stream.flush()?;
+
+    // Read the whole response: closing early would reset the connection
+    // while the collector is still writing it.
+    let mut response = String::new();
+    stream.read_to_string(&mut response)?;
+    let status = response.lines().next().unwrap_or("");
+    match status.split_whitespace().nth(1) {
+        Some(code) if code.starts_with('2') => Ok(()),
+        _ => Err(io::Error::other(format!("collector: {}", status.trim()))),
+    }
+}
+
+/// Push every interval until `running` is cleared. A failed push is
+/// reported and that interval dropped; the next one is tried as usual.
+pub fn run_agent(
+    path: &str,
+    args: &AgentArgs,
+    selector: MountSelector,
+    labels: &Labels,
+    running: &AtomicBool,
+) -> Result<()> {
+    let host = args
+        .host_name
+        .clone()
+        .unwrap_or_else(|| HostMetadata::collect_local("/").hostname);
+    if host.is_empty() {
+        return Err(NfsGazeError::ParseError(
+            "agent: cannot determine the host name; use --host-name".to_string(),
+        ));
+    }
+    let watch = Watcher::new(path, args.interval.as_duration())
+        .selector(selector)
+        .watch();
+    while running.load(Ordering::SeqCst) {
+        let Some(item) = watch.recv_timeout(Duration::from_millis(100)) else {
+            continue;
+        };
+        let interval = item?;
+        if let Err(e) = post_json(&args.collector, &push_payload(&host, &interval, labels)) {
+            eprintln!(
+                "Warning: push to {} failed: {}",
+                args.collector.authority, e
+            );
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::exporter::{read_request, respond};
+    use crate::testutil::mount;
+    use crate::tracker::MountInterval;
+    use chrono::Utc;
+    use std::net::TcpListener;
+    use std::thread;
+
+    #[test]
+    fn test_parse_collector_url() {
+        let url = parse_collector_url("http://collector").unwrap();
+        assert_eq!(url.authority, "collector:9097");
+        assert_eq!(url.path, "/push");
+        let url = parse_collector_url("http://10.0.0.1:8080/nfs/push").unwrap();
+        assert_eq!(url.authority, "10.0.0.1:8080");
+        assert_eq!(url.path, "/nfs/push");
+        assert!(parse_collector_url("https://collector").is_err());
+        assert!(parse_collector_url("http:///push").is_err());
+    }
+
+    #[test]
+    fn test_push() {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let url = CollectorUrl {
+            authority: listener.local_addr().unwrap().to_string(),
+            path: "/push".to_string(),
+        };
+        let server = thread::spawn(move || {
+            let (mut stream, _) = listener.accept().unwrap();
+            let request = read_request(&stream).unwrap();
+            respond(&mut stream, "204 No Content", "text/plain", "").unwrap();
+            request
+        });
+
+        let interval = IntervalStats {
+            timestamp: Utc::now(),
+            interval_secs: 10.0,
+            mounts: vec![MountInterval {
+                mount: mount("/mnt"),
+                stats: vec![stat("READ", 100, 2.0)],
+            }],
+            events: Vec::new(),
+        };
+        let payload = push_payload("node01", &interval, &Labels::new());
+        post_json(&url, &payload).unwrap();
+
+        let request = server.join().unwrap();
+        assert_eq!(request.method, "POST");
+        assert_eq!(request.target, "/push");
+        let received: Value = serde_json::from_slice(&request.body).unwrap();
+        assert_eq!(received["host"], "node01");
+        assert_eq!(received["mounts"][0]["mount"]["mount_point"], "/mnt");
+        assert_eq!(received["mounts"][0]["operations"][0]["ops"], 100);
+    }
+}
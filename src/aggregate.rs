@@ -0,0 +1,133 @@
+//! Combining DeltaStats from several mounts or operations.
+
+use crate::types::DeltaStats;
+use std::collections::BTreeMap;
+
+fn empty(operation: &str) -> DeltaStats {
+    DeltaStats {
+        operation: operation.to_string(),
+        delta_ops: 0,
+        delta_bytes: 0,
+        delta_sent: 0,
+        delta_recv: 0,
+        delta_rtt: 0,
+        delta_exec: 0,
+        delta_queue: 0,
+        delta_errors: 0,
+        delta_retrans: 0,
+        avg_rtt: 0.0,
+        avg_exec: 0.0,
+        avg_queue: 0.0,
+        kb_per_op: 0.0,
+        kb_per_sec: 0.0,
+        iops: 0.0,
+    }
+}
+
+fn accumulate(total: &mut DeltaStats, stat: &DeltaStats) {
+    total.delta_ops += stat.delta_ops;
+    total.delta_bytes += stat.delta_bytes;
+    total.delta_sent += stat.delta_sent;
+    total.delta_recv += stat.delta_recv;
+    total.delta_rtt += stat.delta_rtt;
+    total.delta_exec += stat.delta_exec;
+    total.delta_queue += stat.delta_queue;
+    total.delta_errors += stat.delta_errors;
+    total.delta_retrans += stat.delta_retrans;
+    total.kb_per_sec += stat.kb_per_sec;
+    total.iops += stat.iops;
+}
+
+/// Recompute the per-op averages from the summed totals, so averages are
+/// weighted by operation count rather than averaged naively.
+fn finish(total: &mut DeltaStats) {
+    if total.delta_ops > 0 {
+        let ops = total.delta_ops as f64;
+        total.avg_rtt = total.delta_rtt as f64 / ops;
+        total.avg_exec = total.delta_exec as f64 / ops;
+        total.avg_queue = total.delta_queue as f64 / ops;
+        total.kb_per_op = total.delta_bytes as f64 / 1024.0 / ops;
+    }
+}
+
+/// Collapse a set of per-op stats into one row labelled `label`.
+pub fn total_stats<'a, I>(label: &str, stats: I) -> DeltaStats
+where
+    I: IntoIterator<Item = &'a DeltaStats>,
+{
+    let mut total = empty(label);
+    for stat in stats {
+        accumulate(&mut total, stat);
+    }
+    finish(&mut total);
+    total
+}
+
+/// Merge per-op stats from several sources, summing rows with the same
+/// operation name. The result is ordered by operation name.
+pub fn merge_by_operation<'a, I>(sources: I) -> Vec<DeltaStats>
+where
+    I: IntoIterator<Item = &'a [DeltaStats]>,
+{
+    let mut merged: BTreeMap<String, DeltaStats> = BTreeMap::new();
+    for stats in sources {
+        for stat in stats {
+            let total = merged
+                .entry(stat.operation.clone())
+                .or_insert_with(|| empty(&stat.operation));
+            accumulate(total, stat);
+        }
+    }
+    merged
+        .into_values()
+        .map(|mut total| {
+            finish(&mut total);
+            total
+        })
+        .collect()
+}
+
+#[cfg(test)]
+pub(crate) mod tests {
+    use super::*;
+
+    /// DeltaStats with consistent totals for `ops` operations at `rtt` ms.
+    pub(crate) fn stat(operation: &str, ops: i64, rtt: f64) -> DeltaStats {
+        DeltaStats {
+            delta_ops: ops,
+            delta_rtt: (rtt * ops as f64) as i64,
+            delta_exec: (rtt * ops as f64) as i64,
+            delta_bytes: ops * 4096,
+            avg_rtt: rtt,
+            avg_exec: rtt,
+            kb_per_op: 4.0,
+            kb_per_sec: ops as f64 * 4.0,
+            iops: ops as f64,
+            ..empty(operation)
+        }
+    }
+
+    #[test]
+    fn test_total_stats_weights_averages() {
+        let stats = vec![stat("READ", 100, 1.0), stat("WRITE", 300, 5.0)];
+        let total = total_stats("TOTAL", &stats);
+
+        assert_eq!(total.operation, "TOTAL");
+        assert_eq!(total.delta_ops, 400);
+        assert!((total.avg_rtt - 4.0).abs() < 1e-9);
+        assert!((total.iops - 400.0).abs() < 1e-9);
+        assert!((total.kb_per_op - 4.0).abs() < 1e-9);
+    }
+
+    #[test]
+    fn test_merge_by_operation() {
+        let a = vec![stat("READ", 10, 2.0), stat("GETATTR", 5, 1.0)];
+        let b = vec![stat("READ", 30, 6.0)];
+        let merged = merge_by_operation([a.as_slice(), b.as_slice()]);
+
+        assert_eq!(merged.len(), 2);
+        assert_eq!(merged[0].operation, "GETATTR");
+        assert_eq!(merged[1].delta_ops, 40);
+        assert!((merged[1].avg_rtt - 5.0).abs() < 1e-9);
+    }
+}
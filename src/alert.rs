@@ -0,0 +1,272 @@
+//! Threshold alerts (`--alert-rtt-ms`, `--alert-retrans-pct`,
+//! `--alert-error-pct`): an ALERT line for every breach in an interval and,
+//! with `--alert-exit`, a non-zero exit status for scripted guards.
+
+use crate::aggregate::total_stats;
+use crate::config::Thresholds;
+use crate::types::DeltaStats;
+use clap::Args;
+use std::fmt;
+use std::io::{self, Write};
+
+/// Exit status when `--alert-exit` is given and any alert fired.
+pub const ALERT_EXIT_CODE: i32 = 3;
+
+#[derive(Args, Debug, Clone, Default)]
+pub struct AlertArgs {
+    /// Alert when an operation's average RTT exceeds MS in an interval
+    #[arg(long = "alert-rtt-ms", value_name = "MS")]
+    pub alert_rtt_ms: Option<f64>,
+
+    /// Alert when a mount's retransmissions exceed PCT percent of its operations
+    #[arg(long = "alert-retrans-pct", value_name = "PCT")]
+    pub alert_retrans_pct: Option<f64>,
+
+    /// Alert when a mount's errors exceed PCT percent of its operations
+    #[arg(long = "alert-error-pct", value_name = "PCT")]
+    pub alert_error_pct: Option<f64>,
+
+    /// Exit with status 3 if any alert fired during the run
+    #[arg(long = "alert-exit")]
+    pub alert_exit: bool,
+}
+
+/// Active thresholds; `None` is not checked.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct AlertRules {
+    pub rtt_ms: Option<f64>,
+    pub exec_ms: Option<f64>,
+    pub retrans_pct: Option<f64>,
+    pub error_pct: Option<f64>,
+    /// Errors per interval, from the config file's `errors` threshold.
+    pub errors: Option<i64>,
+}
+
+impl AlertRules {
+    /// Flags take precedence over the config file's `[thresholds]`.
+    pub fn new(args: &AlertArgs, thresholds: &Thresholds) -> Self {
+        Self {
+            rtt_ms: args.alert_rtt_ms.or(thresholds.rtt_ms),
+            exec_ms: thresholds.exec_ms,
+            retrans_pct: args.alert_retrans_pct.or(thresholds.retrans_pct),
+            error_pct: args.alert_error_pct,
+            errors: thresholds.errors,
+        }
+    }
+
+    pub fn is_empty(&self) -> bool {
+        *self == Self::default()
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum AlertKind {
+    Rtt,
+    Exec,
+    Retrans,
+    ErrorRate,
+    Errors,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Alert {
+    pub mount_point: String,
+    /// The operation for latency alerts; rate alerts cover the mount.
+    pub operation: Option<String>,
+    pub kind: AlertKind,
+    pub value: f64,
+    pub threshold: f64,
+}
+
+impl fmt::Display for Alert {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}", self.mount_point)?;
+        if let Some(op) = &self.operation {
+            write!(f, " {}", op)?;
+        }
+        match self.kind {
+            AlertKind::Rtt => write!(
+                f,
+                " avg RTT {:.2}ms exceeds {}ms",
+                self.value, self.threshold
+            ),
+            AlertKind::Exec => write!(
+                f,
+                " avg exec {:.2}ms exceeds {}ms",
+                self.value, self.threshold
+            ),
+            AlertKind::Retrans => write!(
+                f,
+                " retransmissions {:.1}% exceed {}%",
+                self.value, self.threshold
+            ),
+            AlertKind::ErrorRate => {
+                write!(f, " errors {:.1}% exceed {}%", self.value, self.threshold)
+            }
+            AlertKind::Errors => write!(f, " {} errors exceed {}", self.value, self.threshold),
+        }
+    }
+}
+
+/// Percentage of `ops`. mountstats only counts retransmissions and errors
+/// of calls that completed, so an interval with no operations has
+/// nothing to rate.
+fn pct(part: i64, ops: i64) -> f64 {
+    if ops <= 0 {
+        return 0.0;
+    }
+    part.max(0) as f64 * 100.0 / ops as f64
+}
+
+/// Alerts raised by one mount's interval.
+pub fn check_interval(rules: &AlertRules, mount_point: &str, stats: &[DeltaStats]) -> Vec<Alert> {
+    let mut alerts = Vec::new();
+    let mut raise = |operation: Option<&str>, kind, value: f64, threshold: f64| {
+        if value > threshold {
+            alerts.push(Alert {
+                mount_point: mount_point.to_string(),
+                operation: operation.map(str::to_string),
+                kind,
+                value,
+                threshold,
+            });
+        }
+    };
+
+    for stat in stats.iter().filter(|s| s.delta_ops > 0) {
+        if let Some(limit) = rules.rtt_ms {
+            raise(Some(&stat.operation), AlertKind::Rtt, stat.avg_rtt, limit);
+        }
+        if let Some(limit) = rules.exec_ms {
+            raise(Some(&stat.operation), AlertKind::Exec, stat.avg_exec, limit);
+        }
+    }
+    let total = total_stats("total", stats);
+    if let Some(limit) = rules.retrans_pct {
+        let value = pct(total.delta_retrans, total.delta_ops);
+        raise(None, AlertKind::Retrans, value, limit);
+    }
+    if let Some(limit) = rules.error_pct {
+        let value = pct(total.delta_errors, total.delta_ops);
+        raise(None, AlertKind::ErrorRate, value, limit);
+    }
+    if let Some(limit) = rules.errors {
+        raise(
+            None,
+            AlertKind::Errors,
+            total.delta_errors as f64,
+            limit as f64,
+        );
+    }
+    alerts
+}
+
+/// Print one ALERT line per alert, in bold red when `color` is set.
+pub fn display_alerts<W: Write>(writer: &mut W, alerts: &[Alert], color: bool) -> io::Result<()> {
+    for alert in alerts {
+        if color {
+            writeln!(writer, "\x1b[1;31mALERT: {}\x1b[0m", alert)?;
+        } else {
+            writeln!(writer, "ALERT: {}", alert)?;
+        }
+    }
+    Ok(())
+}
+
+/// Remembers whether any alert fired, for `--alert-exit`.
+#[derive(Debug, Clone, Default)]
+pub struct AlertState {
+    fired: u64,
+}
+
+impl AlertState {
+    pub fn record(&mut self, alerts: &[Alert]) {
+        self.fired += alerts.len() as u64;
+    }
+
+    pub fn fired(&self) -> u64 {
+        self.fired
+    }
+
+    /// Exit status for the run.
+    pub fn exit_code(&self, args: &AlertArgs) -> i32 {
+        if args.alert_exit && self.fired > 0 {
+            ALERT_EXIT_CODE
+        } else {
+            0
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_rules_from_flags_and_config() {
+        let args = AlertArgs {
+            alert_rtt_ms: Some(50.0),
+            ..Default::default()
+        };
+        let thresholds = Thresholds {
+            rtt_ms: Some(100.0),
+            retrans_pct: Some(1.0),
+            ..Default::default()
+        };
+        let rules = AlertRules::new(&args, &thresholds);
+        assert_eq!(rules.rtt_ms, Some(50.0));
+        assert_eq!(rules.retrans_pct, Some(1.0));
+        assert!(AlertRules::new(&AlertArgs::default(), &Thresholds::default()).is_empty());
+    }
+
+    #[test]
+    fn test_check_interval() {
+        let rules = AlertRules {
+            rtt_ms: Some(10.0),
+            retrans_pct: Some(5.0),
+            error_pct: Some(1.0),
+            ..Default::default()
+        };
+        let mut read = stat("READ", 100, 20.0);
+        read.delta_retrans = 10;
+        read.delta_errors = 1;
+        let alerts = check_interval(&rules, "/mnt", &[read, stat("GETATTR", 100, 1.0)]);
+        let kinds: Vec<AlertKind> = alerts.iter().map(|a| a.kind).collect();
+        // 10 retrans in 200 ops is 5%: at the threshold, not over it.
+        assert_eq!(kinds, [AlertKind::Rtt]);
+        assert_eq!(
+            alerts[0].to_string(),
+            "/mnt READ avg RTT 20.00ms exceeds 10ms"
+        );
+
+        let mut lossy = stat("READ", 100, 1.0);
+        lossy.delta_retrans = 20;
+        let alerts = check_interval(&rules, "/mnt", &[lossy]);
+        assert_eq!(alerts.len(), 1);
+        assert_eq!(
+            alerts[0].to_string(),
+            "/mnt retransmissions 20.0% exceed 5%"
+        );
+        // No completed operations, nothing to rate.
+        assert!(check_interval(&rules, "/mnt", &[stat("READ", 0, 0.0)]).is_empty());
+
+        let mut out = Vec::new();
+        display_alerts(&mut out, &alerts, false).unwrap();
+        assert!(String::from_utf8(out).unwrap().starts_with("ALERT: /mnt"));
+        let mut out = Vec::new();
+        display_alerts(&mut out, &alerts, true).unwrap();
+        assert!(out.starts_with(b"\x1b[1;31mALERT:"));
+
+        let mut state = AlertState::default();
+        let args = AlertArgs::default();
+        assert_eq!(state.exit_code(&args), 0);
+        state.record(&alerts);
+        assert_eq!(state.exit_code(&args), 0);
+        let args = AlertArgs {
+            alert_exit: true,
+            ..args
+        };
+        assert_eq!(state.exit_code(&args), ALERT_EXIT_CODE);
+    }
+}
@@ -0,0 +1,146 @@
+//! `--all-namespaces`: NFS mounts from every mount namespace on the host,
+//! covering systemd services with private mounts and containers, merged
+//! into one view and tagged with the namespace they were found in.
+
+use crate::cgroups::{discover_namespaces, snapshot, MountNamespace};
+use crate::labels::Labels;
+use crate::selection::MountSelector;
+use crate::types::NFSMount;
+use clap::Args;
+use std::collections::HashMap;
+use std::io;
+
+#[derive(Args, Debug, Clone)]
+pub struct AllNamespacesArgs {
+    /// Monitor NFS mounts in every mount namespace, not just our own
+    #[arg(long = "all-namespaces")]
+    pub all_namespaces: bool,
+}
+
+/// Label of the namespace PID 1 lives in.
+pub const HOST: &str = "host";
+
+#[derive(Debug, Clone)]
+pub struct NamespacedMount {
+    /// Label of the first namespace the mount was found in.
+    pub namespace: String,
+    /// Every namespace that shows the same mount.
+    pub seen_in: Vec<String>,
+    pub mount: NFSMount,
+}
+
+impl NamespacedMount {
+    /// The mount with its mount point tagged by namespace, e.g.
+    /// `/data [backup.service]`, so mounts of the same path in different
+    /// namespaces stay apart. Host mounts keep their plain path.
+    pub fn tagged(&self) -> NFSMount {
+        let mut mount = self.mount.clone();
+        if self.namespace != HOST {
+            mount.mount_point = format!("{} [{}]", mount.mount_point, self.namespace);
+        }
+        mount
+    }
+}
+
+fn label(ns: &MountNamespace) -> String {
+    if ns.pids.first() == Some(&1) {
+        HOST.to_string()
+    } else {
+        ns.label()
+    }
+}
+
+/// Merge per-namespace mounts. A mount that propagated into several
+/// namespaces shares one superblock and one set of counters, so it is
+/// kept once, under the host if the host sees it. Same device, mount
+/// point and age identifies it.
+pub fn merge(
+    namespaces: &[MountNamespace],
+    mounts: &HashMap<u64, Vec<NFSMount>>,
+) -> Vec<NamespacedMount> {
+    let mut ordered: Vec<&MountNamespace> = namespaces.iter().collect();
+    ordered.sort_by_key(|ns| (ns.pids.first() != Some(&1), ns.inode));
+
+    let mut merged: Vec<NamespacedMount> = Vec::new();
+    let mut index: HashMap<(String, String, i64), usize> = HashMap::new();
+    for ns in ordered {
+        let Some(ns_mounts) = mounts.get(&ns.inode) else {
+            continue;
+        };
+        let name = label(ns);
+        for mount in ns_mounts {
+            let key = (mount.device.clone(), mount.mount_point.clone(), mount.age);
+            match index.get(&key) {
+                Some(&i) => merged[i].seen_in.push(name.clone()),
+                None => {
+                    index.insert(key, merged.len());
+                    merged.push(NamespacedMount {
+                        namespace: name.clone(),
+                        seen_in: vec![name.clone()],
+                        mount: mount.clone(),
+                    });
+                }
+            }
+        }
+    }
+    merged
+}
+
+/// Walk `proc_root` and read every namespace's mountstats.
+pub fn scan(proc_root: &str) -> io::Result<Vec<NamespacedMount>> {
+    let namespaces = discover_namespaces(proc_root)?;
+    Ok(merge(&namespaces, &snapshot(proc_root, &namespaces)))
+}
+
+/// Selected tagged mounts from every namespace, each carrying `labels`
+/// plus a `mount_namespace` label.
+pub fn labelled_mounts(
+    proc_root: &str,
+    selector: &MountSelector,
+    labels: &Labels,
+) -> io::Result<Vec<(NFSMount, Labels)>> {
+    Ok(scan(proc_root)?
+        .iter()
+        .filter(|m| selector.matches(&m.mount.mount_point))
+        .map(|m| {
+            let mut labels = labels.clone();
+            labels.insert("mount_namespace", &m.namespace);
+            (m.tagged(), labels)
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil::mount;
+    use std::collections::BTreeSet;
+
+    fn ns(inode: u64, pids: Vec<u32>, unit: &str) -> MountNamespace {
+        MountNamespace {
+            inode,
+            pids,
+            units: BTreeSet::from([unit.to_string()]),
+        }
+    }
+
+    #[test]
+    fn test_merge() {
+        let namespaces = vec![
+            ns(5, vec![300], "backup.service"),
+            ns(9, vec![1, 2], "init.scope"),
+        ];
+        let shared = mount("/data");
+        let mut private = mount("/data");
+        private.device = "filer:/backup".to_string();
+        let mounts = HashMap::from([(5, vec![shared.clone(), private]), (9, vec![shared])]);
+
+        let merged = merge(&namespaces, &mounts);
+        assert_eq!(merged.len(), 2);
+        assert_eq!(merged[0].namespace, HOST);
+        assert_eq!(merged[0].seen_in, vec![HOST, "backup.service"]);
+        assert_eq!(merged[0].tagged().mount_point, "/data");
+        assert_eq!(merged[1].namespace, "backup.service");
+        assert_eq!(merged[1].tagged().mount_point, "/data [backup.service]");
+    }
+}
@@ -0,0 +1,393 @@
+//! Per-process NFS latency attribution.
+//!
+//! Kernel probes on `nfs_file_read` and `nfs_file_write` are registered
+//! through tracefs, so no BPF toolchain is needed at runtime. Entry and
+//! return events are paired per thread to time each call, and the results
+//! are grouped by process (thread group) and command name.
+//!
+//! The time measured is the wall time of the VFS read/write call. That
+//! includes page-cache hits and buffered writes that never reach the
+//! server, so it is not the RPC latency that mountstats reports.
+
+use crate::census::mount_for_path;
+use crate::tracefs::{parse_record, TraceRecord};
+use clap::Args;
+use std::collections::HashMap;
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, Write};
+use std::path::{Path, PathBuf};
+
+/// Probe group registered in tracefs; everything under it belongs to us.
+const PROBE_GROUP: &str = "nfs_gaze";
+
+/// (probe name, kernel symbol, operation, is return probe)
+const PROBES: &[(&str, &str, &str, bool)] = &[
+    ("read_entry", "nfs_file_read", "READ", false),
+    ("read_exit", "nfs_file_read", "READ", true),
+    ("write_entry", "nfs_file_write", "WRITE", false),
+    ("write_exit", "nfs_file_write", "WRITE", true),
+];
+
+#[derive(Args, Debug, Clone)]
+pub struct AttributionArgs {
+    /// Time nfs_file_read/nfs_file_write calls per process: VFS wall
+    /// time including page-cache hits, not RPC latency (requires root)
+    #[arg(long = "by-process")]
+    pub by_process: bool,
+
+    /// Number of processes to show per interval
+    #[arg(long = "proc-top", default_value = "10")]
+    pub proc_top: usize,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ProbeKind {
+    Entry,
+    Exit,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct TraceEvent {
+    pub comm: String,
+    /// The thread that made the call; trace_pipe prints thread ids.
+    pub tid: u32,
+    pub timestamp_us: f64,
+    pub operation: &'static str,
+    pub kind: ProbeKind,
+}
+
+/// Convert a tracefs record from one of our probes into a [`TraceEvent`].
+pub fn event_from_record(record: TraceRecord) -> Option<TraceEvent> {
+    let &(_, _, operation, is_return) = PROBES.iter().find(|(name, ..)| *name == record.event)?;
+    Some(TraceEvent {
+        comm: record.comm,
+        tid: record.pid,
+        timestamp_us: record.timestamp_us,
+        operation,
+        kind: if is_return {
+            ProbeKind::Exit
+        } else {
+            ProbeKind::Entry
+        },
+    })
+}
+
+pub fn parse_trace_line(line: &str) -> Option<TraceEvent> {
+    parse_record(line).and_then(event_from_record)
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct OpLatency {
+    pub count: u64,
+    pub total_us: f64,
+    pub max_us: f64,
+}
+
+impl OpLatency {
+    pub fn avg_ms(&self) -> f64 {
+        if self.count == 0 {
+            0.0
+        } else {
+            self.total_us / self.count as f64 / 1000.0
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct ProcessStats {
+    /// Thread group id, so every thread of a process shares one row.
+    pub pid: u32,
+    pub comm: String,
+    pub operations: HashMap<&'static str, OpLatency>,
+}
+
+impl ProcessStats {
+    pub fn total_us(&self) -> f64 {
+        self.operations.values().map(|o| o.total_us).sum()
+    }
+}
+
+/// The thread group of `tid`, from the `Tgid:` line of its status file.
+pub fn read_tgid(proc_root: &Path, tid: u32) -> Option<u32> {
+    let status = fs::read_to_string(proc_root.join(tid.to_string()).join("status")).ok()?;
+    status
+        .lines()
+        .find_map(|line| line.strip_prefix("Tgid:"))
+        .and_then(|tgid| tgid.trim().parse().ok())
+}
+
+/// Pairs entry/exit events per thread and accumulates latency per
+/// process.
+#[derive(Debug)]
+pub struct Attributor {
+    proc_root: PathBuf,
+    pending: HashMap<(u32, &'static str), f64>,
+    processes: HashMap<u32, ProcessStats>,
+    /// Thread to thread group, cleared every interval since thread ids
+    /// are reused.
+    tgids: HashMap<u32, u32>,
+}
+
+impl Default for Attributor {
+    fn default() -> Self {
+        Self::with_proc_root("/proc")
+    }
+}
+
+impl Attributor {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// An attributor that looks thread groups up under `proc_root`.
+    pub fn with_proc_root(proc_root: impl Into<PathBuf>) -> Self {
+        Self {
+            proc_root: proc_root.into(),
+            pending: HashMap::new(),
+            processes: HashMap::new(),
+            tgids: HashMap::new(),
+        }
+    }
+
+    /// The process `tid` belongs to. A thread that already exited is
+    /// kept as its own row.
+    fn tgid(&mut self, tid: u32) -> u32 {
+        let proc_root = &self.proc_root;
+        *self
+            .tgids
+            .entry(tid)
+            .or_insert_with(|| read_tgid(proc_root, tid).unwrap_or(tid))
+    }
+
+    pub fn record(&mut self, event: &TraceEvent) {
+        let key = (event.tid, event.operation);
+        match event.kind {
+            ProbeKind::Entry => {
+                self.pending.insert(key, event.timestamp_us);
+            }
+            ProbeKind::Exit => {
+                let Some(start) = self.pending.remove(&key) else {
+                    return;
+                };
+                let elapsed = (event.timestamp_us - start).max(0.0);
+                let pid = self.tgid(event.tid);
+                let process = self.processes.entry(pid).or_insert_with(|| ProcessStats {
+                    pid,
+                    comm: event.comm.clone(),
+                    operations: HashMap::new(),
+                });
+                let op = process.operations.entry(event.operation).or_default();
+                op.count += 1;
+                op.total_us += elapsed;
+                op.max_us = op.max_us.max(elapsed);
+            }
+        }
+    }
+
+    /// Return the interval's processes ordered by total time spent in NFS,
+    /// and start a new interval. In-flight calls carry over.
+    pub fn take_interval(&mut self) -> Vec<ProcessStats> {
+        self.tgids.clear();
+        let mut processes: Vec<ProcessStats> = self.processes.drain().map(|(_, p)| p).collect();
+        processes.sort_by(|a, b| {
+            b.total_us()
+                .partial_cmp(&a.total_us())
+                .unwrap_or(std::cmp::Ordering::Equal)
+                .then(a.pid.cmp(&b.pid))
+        });
+        processes
+    }
+}
+
+/// Mount points from `mount_points` on which `pid` currently holds open
+/// files, used to merge process rows into the per-mount view.
+pub fn mounts_for_pid(pid: u32, mount_points: &[String]) -> Vec<String> {
+    let Ok(entries) = fs::read_dir(format!("/proc/{}/fd", pid)) else {
+        return Vec::new();
+    };
+    let mut found: Vec<String> = entries
+        .flatten()
+        .filter_map(|e| fs::read_link(e.path()).ok())
+        .filter_map(|target| mount_for_path(&target, mount_points).map(str::to_string))
+        .collect();
+    found.sort();
+    found.dedup();
+    found
+}
+
+/// Registered kprobes; removed again when dropped. The tracefs files
+/// stay open, so removal still works after `--sandbox` has dropped root.
+pub struct KprobeTracer {
+    kprobe_events: File,
+    enable: Option<File>,
+}
+
+impl KprobeTracer {
+    pub fn attach(tracefs: &str) -> io::Result<Self> {
+        let root = Path::new(tracefs);
+        let kprobe_events = OpenOptions::new()
+            .append(true)
+            .open(root.join("kprobe_events"))?;
+        let mut tracer = Self {
+            kprobe_events,
+            enable: None,
+        };
+
+        for (name, symbol, _, is_return) in PROBES {
+            let kind = if *is_return { 'r' } else { 'p' };
+            writeln!(
+                tracer.kprobe_events,
+                "{}:{}/{} {}",
+                kind, PROBE_GROUP, name, symbol
+            )?;
+        }
+        let mut enable = OpenOptions::new()
+            .write(true)
+            .open(root.join("events").join(PROBE_GROUP).join("enable"))?;
+        enable.write_all(b"1")?;
+        tracer.enable = Some(enable);
+        Ok(tracer)
+    }
+}
+
+impl Drop for KprobeTracer {
+    fn drop(&mut self) {
+        // Closed before the probes go, which the kernel refuses while
+        // their files are open.
+        if let Some(mut enable) = self.enable.take() {
+            let _ = enable.write_all(b"0");
+        }
+        for (name, ..) in PROBES {
+            let _ = writeln!(self.kprobe_events, "-:{}/{}", PROBE_GROUP, name);
+        }
+    }
+}
+
+/// Whether tracefs is mounted and writable at `tracefs`.
+pub fn tracing_available(tracefs: &str) -> bool {
+    let path = Path::new(tracefs).join("kprobe_events");
+    OpenOptions::new().append(true).open(path).is_ok()
+}
+
+pub fn display_process_stats<W: Write>(
+    writer: &mut W,
+    processes: &[ProcessStats],
+    top: usize,
+    mount_points: &[String],
+) -> io::Result<()> {
+    if processes.is_empty() {
+        return Ok(());
+    }
+
+    writeln!(
+        writer,
+        "{:<8} {:<16} {:<6} {:>8} {:>10} {:>10}  MOUNTS",
+        "PID", "COMM", "OP", "CALLS", "AVG(ms)", "MAX(ms)"
+    )?;
+    writeln!(writer, "{}", "-".repeat(72))?;
+
+    for process in processes.iter().take(top) {
+        let mounts = mounts_for_pid(process.pid, mount_points).join(",");
+        let mut ops: Vec<_> = process.operations.iter().collect();
+        ops.sort_by_key(|(name, _)| **name);
+        for (name, op) in ops {
+            writeln!(
+                writer,
+                "{:<8} {:<16} {:<6} {:>8} {:>10.2} {:>10.2}  {}",
+                process.pid,
+                process.comm,
+                name,
+                op.count,
+                op.avg_ms(),
+                op.max_us / 1000.0,
+                mounts
+            )?;
+        }
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_trace_line() {
+        let line = "    my-app-1234 [001] d.... 8123.500000: read_entry: (nfs_file_read+0x0/0x130)";
+        let event = parse_trace_line(line).expect("should parse");
+        assert_eq!(event.comm, "my-app");
+        assert_eq!(event.tid, 1234);
+        assert_eq!(event.operation, "READ");
+        assert_eq!(event.kind, ProbeKind::Entry);
+        assert!((event.timestamp_us - 8_123_500_000.0).abs() < 1.0);
+
+        assert!(parse_trace_line("garbage").is_none());
+        assert!(parse_trace_line("  cat-1 [000] ..... 1.0: sched_switch: x").is_none());
+    }
+
+    #[test]
+    fn test_attributor_pairs_events() {
+        let event = |tid, ts, kind| TraceEvent {
+            comm: format!("proc{}", tid),
+            tid,
+            timestamp_us: ts,
+            operation: "WRITE",
+            kind,
+        };
+
+        let mut attributor = Attributor::with_proc_root("/nonexistent");
+        attributor.record(&event(10, 0.0, ProbeKind::Entry));
+        attributor.record(&event(20, 0.0, ProbeKind::Entry));
+        attributor.record(&event(10, 3000.0, ProbeKind::Exit));
+        attributor.record(&event(20, 500.0, ProbeKind::Exit));
+        attributor.record(&event(10, 4000.0, ProbeKind::Entry));
+        attributor.record(&event(10, 5000.0, ProbeKind::Exit));
+        // Unmatched exit is ignored.
+        attributor.record(&event(30, 100.0, ProbeKind::Exit));
+
+        let processes = attributor.take_interval();
+        assert_eq!(processes.len(), 2);
+        assert_eq!(processes[0].pid, 10);
+        let write = &processes[0].operations["WRITE"];
+        assert_eq!(write.count, 2);
+        assert!((write.avg_ms() - 2.0).abs() < 1e-9);
+        assert!((write.max_us - 3000.0).abs() < 1e-9);
+
+        assert!(attributor.take_interval().is_empty());
+    }
+
+    #[test]
+    fn test_threads_share_their_process_row() {
+        let dir = tempfile::tempdir().unwrap();
+        for tid in [100, 101, 102] {
+            let task = dir.path().join(tid.to_string());
+            fs::create_dir(&task).unwrap();
+            let status = format!("Name:\tworker\nTgid:\t100\nPid:\t{}\n", tid);
+            fs::write(task.join("status"), status).unwrap();
+        }
+        let event = |tid, ts, kind| TraceEvent {
+            comm: "worker".to_string(),
+            tid,
+            timestamp_us: ts,
+            operation: "READ",
+            kind,
+        };
+
+        let mut attributor = Attributor::with_proc_root(dir.path());
+        for tid in [101, 102] {
+            attributor.record(&event(tid, 0.0, ProbeKind::Entry));
+        }
+        attributor.record(&event(101, 1000.0, ProbeKind::Exit));
+        attributor.record(&event(102, 3000.0, ProbeKind::Exit));
+        // Gone before it could be looked up: a row of its own.
+        attributor.record(&event(555, 0.0, ProbeKind::Entry));
+        attributor.record(&event(555, 500.0, ProbeKind::Exit));
+
+        let processes = attributor.take_interval();
+        assert_eq!(processes.len(), 2);
+        assert_eq!(processes[0].pid, 100);
+        assert_eq!(processes[0].operations["READ"].count, 2);
+        assert_eq!(processes[1].pid, 555);
+    }
+}
@@ -0,0 +1,354 @@
+//! `nfs-gaze baseline record FILE` / `baseline compare FILE`: capture what
+//! a mount's operations normally look like, then flag intervals that
+//! stray from it by more than a factor.
+
+use crate::selection::MountSelector;
+use crate::session::Session;
+use crate::types::{DeltaStats, NfsGazeError, Result};
+use crate::watcher::Watcher;
+use chrono::{DateTime, Utc};
+use clap::{Args, Subcommand};
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::fs;
+use std::io::{self, Write};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant};
+
+#[derive(Args, Debug, Clone)]
+pub struct BaselineArgs {
+    #[command(subcommand)]
+    pub command: BaselineCommand,
+}
+
+#[derive(Subcommand, Debug, Clone)]
+pub enum BaselineCommand {
+    /// Sample mounts and save their typical per-op rates and latencies
+    Record(BaselineRecordArgs),
+    /// Monitor mounts and flag intervals that deviate from a baseline
+    Compare(BaselineCompareArgs),
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct BaselineRecordArgs {
+    /// Baseline file to write
+    pub file: String,
+
+    /// Total sampling time in seconds
+    #[arg(long = "duration", default_value = "300")]
+    pub duration: u64,
+
+    /// Seconds between samples
+    #[arg(short = 'i', long = "interval", default_value = "1")]
+    pub interval: u64,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct BaselineCompareArgs {
+    /// Baseline file written by `baseline record`
+    pub file: String,
+
+    /// Flag values more than FACTOR times above or below the baseline
+    #[arg(long = "factor", default_value = "2.0")]
+    pub factor: f64,
+
+    /// Ignore operations with fewer completed ops than this in an interval
+    #[arg(long = "min-ops", default_value = "10")]
+    pub min_ops: i64,
+
+    /// Seconds between samples
+    #[arg(short = 'i', long = "interval", default_value = "1")]
+    pub interval: u64,
+}
+
+/// Typical figures for one operation over the recording.
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct OpBaseline {
+    pub iops: f64,
+    pub avg_rtt: f64,
+    pub avg_exec: f64,
+    pub kb_per_op: f64,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Baseline {
+    pub recorded: DateTime<Utc>,
+    pub duration_secs: f64,
+    /// Operations by mount point, then by name.
+    pub mounts: BTreeMap<String, BTreeMap<String, OpBaseline>>,
+}
+
+impl Baseline {
+    /// Means over the session; operations that never ran are left out.
+    pub fn from_session(session: &Session) -> Self {
+        let mounts = session
+            .mounts
+            .values()
+            .map(|mount| {
+                let ops = mount
+                    .ops
+                    .iter()
+                    .filter(|(_, op)| op.ops > 0)
+                    .map(|(name, op)| {
+                        let iops = if mount.elapsed_secs > 0.0 {
+                            op.ops as f64 / mount.elapsed_secs
+                        } else {
+                            0.0
+                        };
+                        let baseline = OpBaseline {
+                            iops,
+                            avg_rtt: op.avg_rtt(),
+                            avg_exec: op.avg_exec(),
+                            kb_per_op: op.kb_per_op(),
+                        };
+                        (name.clone(), baseline)
+                    })
+                    .collect();
+                (mount.mount_point.clone(), ops)
+            })
+            .collect();
+        Self {
+            recorded: session.started,
+            duration_secs: session.duration_secs(),
+            mounts,
+        }
+    }
+
+    pub fn load(path: &str) -> Result<Self> {
+        let contents = fs::read_to_string(path).map_err(NfsGazeError::MountstatsRead)?;
+        serde_json::from_str(&contents)
+            .map_err(|e| NfsGazeError::ParseError(format!("baseline {}: {}", path, e)))
+    }
+
+    pub fn save(&self, path: &str) -> io::Result<()> {
+        let mut contents = serde_json::to_string_pretty(self)?;
+        contents.push('\n');
+        fs::write(path, contents)
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Deviation {
+    pub mount_point: String,
+    pub operation: String,
+    pub metric: &'static str,
+    pub value: f64,
+    pub baseline: f64,
+}
+
+impl Deviation {
+    /// How many times larger (positive) or smaller (negative) than the
+    /// baseline the value is.
+    pub fn ratio(&self) -> f64 {
+        if self.value >= self.baseline {
+            self.value / self.baseline
+        } else {
+            -self.baseline / self.value.max(f64::MIN_POSITIVE)
+        }
+    }
+}
+
+/// Metrics of one interval that differ from the baseline by more than
+/// `factor` in either direction. Operations below `min_ops` in the
+/// interval are skipped; a handful of calls says little about latency.
+pub fn deviations(
+    baseline: &Baseline,
+    mount_point: &str,
+    stats: &[DeltaStats],
+    factor: f64,
+    min_ops: i64,
+) -> Vec<Deviation> {
+    let Some(ops) = baseline.mounts.get(mount_point) else {
+        return Vec::new();
+    };
+    let mut found = Vec::new();
+    for stat in stats.iter().filter(|s| s.delta_ops >= min_ops.max(1)) {
+        let Some(base) = ops.get(&stat.operation) else {
+            continue;
+        };
+        let metrics = [
+            ("ops/s", stat.iops, base.iops),
+            ("rtt ms", stat.avg_rtt, base.avg_rtt),
+            ("exec ms", stat.avg_exec, base.avg_exec),
+        ];
+        for (metric, value, typical) in metrics {
+            if typical <= 0.0 {
+                continue;
+            }
+            if value > typical * factor || value < typical / factor {
+                found.push(Deviation {
+                    mount_point: mount_point.to_string(),
+                    operation: stat.operation.clone(),
+                    metric,
+                    value,
+                    baseline: typical,
+                });
+            }
+        }
+    }
+    found
+}
+
+pub fn display_deviations<W: Write>(
+    writer: &mut W,
+    timestamp: &DateTime<Utc>,
+    deviations: &[Deviation],
+) -> io::Result<()> {
+    for d in deviations {
+        let ratio = d.ratio();
+        let direction = if ratio >= 0.0 { "above" } else { "below" };
+        writeln!(
+            writer,
+            "{} ANOMALY: {} {} {} {:.2} is {:.1}x {} baseline {:.2}",
+            timestamp.format("%H:%M:%S"),
+            d.mount_point,
+            d.operation,
+            d.metric,
+            d.value,
+            ratio.abs(),
+            direction,
+            d.baseline
+        )?;
+    }
+    Ok(())
+}
+
+fn watch_interval(secs: u64) -> Duration {
+    Duration::from_secs(secs.max(1))
+}
+
+/// Sample `mount_points` (every NFS mount if empty) until `args.duration`
+/// elapses or `running` is cleared, then write the baseline file.
+pub fn run_record(
+    path: &str,
+    mount_points: &[String],
+    args: &BaselineRecordArgs,
+    running: &AtomicBool,
+) -> Result<Baseline> {
+    let watch = Watcher::new(path, watch_interval(args.interval))
+        .selector(MountSelector::new(mount_points))
+        .watch();
+    let mut session = Session::new(Utc::now());
+    let deadline = Instant::now() + Duration::from_secs(args.duration);
+    while running.load(Ordering::SeqCst) && Instant::now() < deadline {
+        let Some(item) = watch.recv_timeout(Duration::from_millis(100)) else {
+            continue;
+        };
+        let interval = item?;
+        for mount in &interval.mounts {
+            session.record(
+                &mount.mount.mount_point,
+                interval.timestamp,
+                interval.interval_secs,
+                &mount.stats,
+            );
+        }
+    }
+    if session.mounts.is_empty() {
+        return Err(NfsGazeError::ParseError(
+            "no intervals recorded; baseline not written".to_string(),
+        ));
+    }
+    let baseline = Baseline::from_session(&session);
+    baseline.save(&args.file)?;
+    Ok(baseline)
+}
+
+/// Monitor `mount_points` (every mount in the baseline if empty) until
+/// `running` is cleared, printing deviations as they occur.
+pub fn run_compare<W: Write>(
+    writer: &mut W,
+    path: &str,
+    mount_points: &[String],
+    args: &BaselineCompareArgs,
+    running: &AtomicBool,
+) -> Result<()> {
+    let baseline = Baseline::load(&args.file)?;
+    let mount_points: Vec<&String> = if mount_points.is_empty() {
+        baseline.mounts.keys().collect()
+    } else {
+        mount_points.iter().collect()
+    };
+    let watch = Watcher::new(path, watch_interval(args.interval))
+        .selector(MountSelector::new(&mount_points[..]))
+        .watch();
+    while running.load(Ordering::SeqCst) {
+        let Some(item) = watch.recv_timeout(Duration::from_millis(100)) else {
+            continue;
+        };
+        let interval = item?;
+        for mount in &interval.mounts {
+            let found = deviations(
+                &baseline,
+                &mount.mount.mount_point,
+                &mount.stats,
+                args.factor,
+                args.min_ops,
+            );
+            display_deviations(writer, &interval.timestamp, &found)?;
+        }
+        writer.flush()?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use chrono::TimeZone;
+
+    fn baseline() -> Baseline {
+        let start = Utc.with_ymd_and_hms(2024, 5, 1, 0, 0, 0).unwrap();
+        let mut session = Session::new(start);
+        for i in 1..=2 {
+            session.record(
+                "/mnt",
+                start + chrono::Duration::seconds(i),
+                1.0,
+                &[stat("READ", 100, 2.0), stat("NULL", 0, 0.0)],
+            );
+        }
+        Baseline::from_session(&session)
+    }
+
+    #[test]
+    fn test_from_session_and_round_trip() {
+        let baseline = baseline();
+        let read = &baseline.mounts["/mnt"]["READ"];
+        assert!((read.iops - 100.0).abs() < 1e-9);
+        assert!((read.avg_rtt - 2.0).abs() < 1e-9);
+        assert!(!baseline.mounts["/mnt"].contains_key("NULL"));
+
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("baseline.json");
+        let path = path.to_str().unwrap();
+        baseline.save(path).unwrap();
+        assert_eq!(Baseline::load(path).unwrap(), baseline);
+        assert!(Baseline::load("/nonexistent/baseline.json").is_err());
+    }
+
+    #[test]
+    fn test_deviations() {
+        let baseline = baseline();
+        assert!(deviations(&baseline, "/mnt", &[stat("READ", 150, 3.0)], 2.0, 10).is_empty());
+
+        let found = deviations(&baseline, "/mnt", &[stat("READ", 40, 9.0)], 2.0, 10);
+        let metrics: Vec<&str> = found.iter().map(|d| d.metric).collect();
+        assert_eq!(metrics, ["ops/s", "rtt ms", "exec ms"]);
+        assert!((found[0].ratio() + 2.5).abs() < 1e-9);
+        assert!((found[1].ratio() - 4.5).abs() < 1e-9);
+
+        // Too few calls to judge, and unknown mounts are ignored.
+        assert!(deviations(&baseline, "/mnt", &[stat("READ", 5, 9.0)], 2.0, 10).is_empty());
+        assert!(deviations(&baseline, "/other", &[stat("READ", 40, 9.0)], 2.0, 10).is_empty());
+
+        let ts = Utc.with_ymd_and_hms(2024, 5, 1, 12, 0, 0).unwrap();
+        let mut out = Vec::new();
+        display_deviations(&mut out, &ts, &found[1..2]).unwrap();
+        assert_eq!(
+            String::from_utf8(out).unwrap(),
+            "12:00:00 ANOMALY: /mnt READ rtt ms 9.00 is 4.5x above baseline 2.00\n"
+        );
+    }
+}
@@ -0,0 +1,462 @@
+//! `nfs-gaze bench <mount>`: generate controlled load on a mount while
+//! sampling its mountstats, so one run shows both what the application
+//! saw and what the NFS client did to deliver it.
+//!
+//! Each worker thread gets its own scratch file (or directory, for the
+//! metadata workload) under the mount, removed at the end. Reads drop the
+//! client page cache for the file before each pass so they reach the
+//! server.
+
+use crate::delta::mount_delta;
+use crate::parser::parse_mountstats;
+use crate::session::Session;
+use crate::types::{NFSMount, NfsGazeError, Result};
+use chrono::Utc;
+use clap::{Args, ValueEnum};
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, Read, Seek, SeekFrom, Write};
+use std::os::fd::AsRawFd;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::thread;
+use std::time::{Duration, Instant};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum Workload {
+    /// Sequential reads of a pre-written file
+    Read,
+    /// Sequential writes with a periodic fsync
+    Write,
+    /// Create, stat and remove small files
+    Meta,
+    /// Workers alternate between read, write and meta
+    Mixed,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct BenchArgs {
+    /// Mount point to exercise
+    pub mount: String,
+
+    /// Workload to generate
+    #[arg(long = "workload", value_enum, default_value_t = Workload::Mixed)]
+    pub workload: Workload,
+
+    /// I/O size per read or write, e.g. 4k, 64k, 1m
+    #[arg(long = "block-size", default_value = "64k", value_parser = parse_size)]
+    pub block_size: u64,
+
+    /// Size of each worker's data file
+    #[arg(long = "file-size", default_value = "64m", value_parser = parse_size)]
+    pub file_size: u64,
+
+    /// Number of concurrent workers
+    #[arg(long = "threads", default_value = "4")]
+    pub threads: usize,
+
+    /// Seconds of load to generate
+    #[arg(long = "duration", default_value = "30")]
+    pub duration: u64,
+
+    /// Seconds between mountstats samples
+    #[arg(short = 'i', long = "interval", default_value = "1")]
+    pub interval: u64,
+}
+
+/// Parse a byte size with an optional k/m/g suffix (powers of 1024).
+pub fn parse_size(s: &str) -> std::result::Result<u64, String> {
+    let lower = s.to_ascii_lowercase();
+    let trimmed = lower.trim_end_matches('b');
+    let (num, scale) = match trimmed.chars().last() {
+        Some('k') => (&trimmed[..trimmed.len() - 1], 1 << 10),
+        Some('m') => (&trimmed[..trimmed.len() - 1], 1 << 20),
+        Some('g') => (&trimmed[..trimmed.len() - 1], 1 << 30),
+        _ => (trimmed, 1),
+    };
+    match num.parse::<u64>() {
+        Ok(n) if n > 0 => n
+            .checked_mul(scale)
+            .ok_or_else(|| format!("size '{}' is too large", s)),
+        _ => Err(format!("invalid size '{}'", s)),
+    }
+}
+
+/// Application-side latency of one kind of operation, in milliseconds.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct ClientLatency {
+    pub kind: &'static str,
+    pub ops: u64,
+    pub bytes: u64,
+    pub mean: f64,
+    pub p50: f64,
+    pub p99: f64,
+    pub max: f64,
+}
+
+impl ClientLatency {
+    fn from_samples(kind: &'static str, mut samples: Vec<f64>, bytes: u64) -> Self {
+        if samples.is_empty() {
+            return Self {
+                kind,
+                ..Self::default()
+            };
+        }
+        samples.sort_by(f64::total_cmp);
+        let rank = |p: f64| samples[((samples.len() - 1) as f64 * p).round() as usize];
+        Self {
+            kind,
+            ops: samples.len() as u64,
+            bytes,
+            mean: samples.iter().sum::<f64>() / samples.len() as f64,
+            p50: rank(0.50),
+            p99: rank(0.99),
+            max: samples[samples.len() - 1],
+        }
+    }
+}
+
+#[derive(Debug, Default)]
+struct WorkerResult {
+    read_ms: Vec<f64>,
+    read_bytes: u64,
+    write_ms: Vec<f64>,
+    write_bytes: u64,
+    meta_ms: Vec<f64>,
+}
+
+impl WorkerResult {
+    fn merge(&mut self, other: WorkerResult) {
+        self.read_ms.extend(other.read_ms);
+        self.read_bytes += other.read_bytes;
+        self.write_ms.extend(other.write_ms);
+        self.write_bytes += other.write_bytes;
+        self.meta_ms.extend(other.meta_ms);
+    }
+}
+
+fn timed<T>(samples: &mut Vec<f64>, f: impl FnOnce() -> io::Result<T>) -> io::Result<T> {
+    let start = Instant::now();
+    let result = f()?;
+    samples.push(start.elapsed().as_secs_f64() * 1000.0);
+    Ok(result)
+}
+
+fn drop_cache(file: &File) {
+    // SAFETY: advisory call on a descriptor we own; failure is harmless.
+    unsafe {
+        libc::posix_fadvise(file.as_raw_fd(), 0, 0, libc::POSIX_FADV_DONTNEED);
+    }
+}
+
+/// Writes between fsyncs, so writes reach the server during the run
+/// rather than all at close.
+const WRITES_PER_SYNC: u64 = 16;
+
+fn write_pass(
+    path: &Path,
+    args: &BenchArgs,
+    stop: &AtomicBool,
+    out: &mut WorkerResult,
+) -> io::Result<()> {
+    let mut file = OpenOptions::new()
+        .create(true)
+        .write(true)
+        .truncate(true)
+        .open(path)?;
+    let block = vec![0xa5u8; args.block_size as usize];
+    let mut written = 0;
+    let mut n = 0;
+    while written < args.file_size && !stop.load(Ordering::Relaxed) {
+        timed(&mut out.write_ms, || file.write_all(&block))?;
+        written += args.block_size;
+        out.write_bytes += args.block_size;
+        n += 1;
+        if n % WRITES_PER_SYNC == 0 {
+            file.sync_data()?;
+        }
+    }
+    file.sync_data()
+}
+
+fn read_pass(
+    path: &Path,
+    args: &BenchArgs,
+    stop: &AtomicBool,
+    out: &mut WorkerResult,
+) -> io::Result<()> {
+    let mut file = File::open(path)?;
+    drop_cache(&file);
+    file.seek(SeekFrom::Start(0))?;
+    let mut block = vec![0u8; args.block_size as usize];
+    while !stop.load(Ordering::Relaxed) {
+        let n = timed(&mut out.read_ms, || file.read(&mut block))?;
+        if n == 0 {
+            break;
+        }
+        out.read_bytes += n as u64;
+    }
+    Ok(())
+}
+
+/// Files created per metadata pass.
+const META_FILES: usize = 32;
+
+fn meta_pass(dir: &Path, stop: &AtomicBool, out: &mut WorkerResult) -> io::Result<()> {
+    fs::create_dir_all(dir)?;
+    for i in 0..META_FILES {
+        if stop.load(Ordering::Relaxed) {
+            break;
+        }
+        let path = dir.join(format!("f{}", i));
+        timed(&mut out.meta_ms, || File::create(&path).map(drop))?;
+        timed(&mut out.meta_ms, || fs::metadata(&path).map(drop))?;
+        timed(&mut out.meta_ms, || fs::remove_file(&path))?;
+    }
+    Ok(())
+}
+
+fn worker(
+    id: usize,
+    root: PathBuf,
+    args: BenchArgs,
+    stop: Arc<AtomicBool>,
+) -> io::Result<WorkerResult> {
+    let data = root.join(format!("data{}", id));
+    let meta = root.join(format!("meta{}", id));
+    let mut out = WorkerResult::default();
+
+    let workloads: &[Workload] = match args.workload {
+        Workload::Mixed => &[Workload::Write, Workload::Read, Workload::Meta],
+        Workload::Read => &[Workload::Read],
+        Workload::Write => &[Workload::Write],
+        Workload::Meta => &[Workload::Meta],
+    };
+    if args.workload == Workload::Read {
+        // Lay the file down first; this pass is not part of the profile.
+        write_pass(&data, &args, &stop, &mut WorkerResult::default())?;
+    }
+
+    // Stagger mixed workers so the phases overlap.
+    let mut next = id;
+    while !stop.load(Ordering::Relaxed) {
+        match workloads[next % workloads.len()] {
+            Workload::Write => write_pass(&data, &args, &stop, &mut out)?,
+            Workload::Read if data.exists() => read_pass(&data, &args, &stop, &mut out)?,
+            Workload::Read => write_pass(&data, &args, &stop, &mut out)?,
+            Workload::Meta => meta_pass(&meta, &stop, &mut out)?,
+            Workload::Mixed => unreachable!(),
+        }
+        next += 1;
+    }
+    let _ = fs::remove_file(&data);
+    let _ = fs::remove_dir_all(&meta);
+    Ok(out)
+}
+
+/// Outcome of a benchmark run.
+pub struct BenchReport {
+    pub elapsed: Duration,
+    pub client: Vec<ClientLatency>,
+    /// mountstats deltas for the mount over the run.
+    pub session: Session,
+}
+
+/// Run the benchmark until `args.duration` elapses or `running` is cleared.
+pub fn run_bench(path: &str, args: &BenchArgs, running: &AtomicBool) -> Result<BenchReport> {
+    let pick = |mounts: Vec<NFSMount>| {
+        mounts
+            .into_iter()
+            .find(|m| m.mount_point == args.mount)
+            .ok_or_else(|| NfsGazeError::MountNotFound(args.mount.clone()))
+    };
+    let mut prev = pick(parse_mountstats(path)?)?;
+
+    let root = Path::new(&args.mount).join(format!(".nfs-gaze-bench-{}", std::process::id()));
+    fs::create_dir_all(&root)?;
+
+    let stop = Arc::new(AtomicBool::new(false));
+    let workers: Vec<_> = (0..args.threads.max(1))
+        .map(|id| {
+            let (root, args, stop) = (root.clone(), args.clone(), stop.clone());
+            thread::spawn(move || worker(id, root, args, stop))
+        })
+        .collect();
+
+    let mut session = Session::new(Utc::now());
+    let start = Instant::now();
+    let deadline = start + Duration::from_secs(args.duration);
+    let interval = Duration::from_secs(args.interval.max(1));
+    let mut last = Instant::now();
+    let mut sample = || -> Result<()> {
+        let cur = pick(parse_mountstats(path)?)?;
+        let secs = last.elapsed().as_secs_f64();
+        last = Instant::now();
+        session.record(
+            &args.mount,
+            Utc::now(),
+            secs,
+            &mount_delta(&prev, &cur, secs),
+        );
+        prev = cur;
+        Ok(())
+    };
+    let mut result = Ok(());
+    while running.load(Ordering::SeqCst) && Instant::now() < deadline && result.is_ok() {
+        thread::sleep(interval.min(deadline.saturating_duration_since(Instant::now())));
+        result = sample();
+    }
+    stop.store(true, Ordering::SeqCst);
+
+    let mut totals = WorkerResult::default();
+    let mut worker_error = None;
+    for handle in workers {
+        match handle.join() {
+            Ok(Ok(out)) => totals.merge(out),
+            Ok(Err(e)) => worker_error = Some(e),
+            Err(_) => worker_error = Some(io::Error::other("benchmark worker panicked")),
+        }
+    }
+    let elapsed = start.elapsed();
+    // Catch the writes flushed as workers wound down.
+    if result.is_ok() {
+        result = sample();
+    }
+    let _ = fs::remove_dir_all(&root);
+    result?;
+    if let Some(e) = worker_error {
+        return Err(e.into());
+    }
+
+    let client = vec![
+        ClientLatency::from_samples("read", totals.read_ms, totals.read_bytes),
+        ClientLatency::from_samples("write", totals.write_ms, totals.write_bytes),
+        ClientLatency::from_samples("meta", totals.meta_ms, 0),
+    ];
+    Ok(BenchReport {
+        elapsed,
+        client: client.into_iter().filter(|c| c.ops > 0).collect(),
+        session,
+    })
+}
+
+pub fn display_bench<W: Write>(
+    writer: &mut W,
+    args: &BenchArgs,
+    report: &BenchReport,
+) -> io::Result<()> {
+    let secs = report.elapsed.as_secs_f64().max(f64::EPSILON);
+    writeln!(
+        writer,
+        "Benchmark: {} workload on {}, {} thread(s), {} KB blocks, {:.1}s",
+        format!("{:?}", args.workload).to_lowercase(),
+        args.mount,
+        args.threads.max(1),
+        args.block_size / 1024,
+        secs
+    )?;
+    writeln!(writer)?;
+
+    writeln!(writer, "Application view")?;
+    writeln!(
+        writer,
+        "{:<8} {:>10} {:>10} {:>10} {:>10} {:>10} {:>10}",
+        "KIND", "OPS/s", "MB/s", "MEAN ms", "P50 ms", "P99 ms", "MAX ms"
+    )?;
+    writeln!(writer, "{}", "-".repeat(74))?;
+    for c in &report.client {
+        writeln!(
+            writer,
+            "{:<8} {:>10.1} {:>10.2} {:>10.3} {:>10.3} {:>10.3} {:>10.3}",
+            c.kind,
+            c.ops as f64 / secs,
+            c.bytes as f64 / secs / (1024.0 * 1024.0),
+            c.mean,
+            c.p50,
+            c.p99,
+            c.max
+        )?;
+    }
+    writeln!(writer)?;
+
+    writeln!(writer, "NFS client view")?;
+    writeln!(
+        writer,
+        "{:<14} {:>10} {:>10} {:>10} {:>10} {:>8}",
+        "OP", "OPS/s", "KB/op", "RTT ms", "EXEC ms", "RETRANS"
+    )?;
+    writeln!(writer, "{}", "-".repeat(67))?;
+    if let Some(mount) = report.session.mounts.get(&args.mount) {
+        let elapsed = mount.elapsed_secs.max(f64::EPSILON);
+        for (name, op) in mount.ops.iter().filter(|(_, op)| op.ops > 0) {
+            writeln!(
+                writer,
+                "{:<14} {:>10.1} {:>10.1} {:>10.3} {:>10.3} {:>8}",
+                name,
+                op.ops as f64 / elapsed,
+                op.kb_per_op(),
+                op.avg_rtt(),
+                op.avg_exec(),
+                op.retrans
+            )?;
+        }
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_size() {
+        assert_eq!(parse_size("4096"), Ok(4096));
+        assert_eq!(parse_size("64k"), Ok(65536));
+        assert_eq!(parse_size("1M"), Ok(1 << 20));
+        assert_eq!(parse_size("2GB"), Ok(2 << 30));
+        assert!(parse_size("0").is_err());
+        assert!(parse_size("lots").is_err());
+        assert!(parse_size("18446744073709551615k").is_err());
+        assert_eq!(parse_size("16777216g"), Ok(1 << 54));
+    }
+
+    #[test]
+    fn test_client_latency_percentiles() {
+        let samples: Vec<f64> = (1..=100).map(f64::from).collect();
+        let lat = ClientLatency::from_samples("read", samples, 100 * 4096);
+        assert_eq!(lat.ops, 100);
+        assert_eq!(lat.p50, 51.0);
+        assert_eq!(lat.p99, 99.0);
+        assert_eq!(lat.max, 100.0);
+        assert!((lat.mean - 50.5).abs() < 1e-9);
+        assert_eq!(ClientLatency::from_samples("meta", Vec::new(), 0).ops, 0);
+    }
+
+    #[test]
+    fn test_worker_runs_each_workload() {
+        let root = std::env::temp_dir().join(format!("nfs-gaze-bench-test-{}", std::process::id()));
+        fs::create_dir_all(&root).unwrap();
+        let args = BenchArgs {
+            mount: root.display().to_string(),
+            workload: Workload::Mixed,
+            block_size: 4096,
+            file_size: 64 * 1024,
+            threads: 1,
+            duration: 1,
+            interval: 1,
+        };
+        let stop = Arc::new(AtomicBool::new(false));
+        let handle = {
+            let (root, args, stop) = (root.clone(), args.clone(), stop.clone());
+            thread::spawn(move || worker(0, root, args, stop))
+        };
+        thread::sleep(Duration::from_millis(100));
+        stop.store(true, Ordering::SeqCst);
+        let out = handle.join().unwrap().unwrap();
+        assert!(!out.write_ms.is_empty());
+        assert!(out.write_bytes >= 4096);
+        assert!(!root.join("data0").exists());
+        fs::remove_dir_all(&root).unwrap();
+    }
+}
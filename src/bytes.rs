@@ -0,0 +1,184 @@
+//! All eight counters of the `bytes:` line, and the buffered versus
+//! direct I/O split they allow.
+//!
+//! Field order: normal read, normal write, direct read, direct write,
+//! server read, server write, pages read, pages written. "Normal" bytes
+//! went through the page cache; "server" bytes actually crossed the wire.
+
+use crate::sections::MountSection;
+use serde::Serialize;
+use std::io::{self, Write};
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize)]
+pub struct NFSBytes {
+    pub normal_read: u64,
+    pub normal_write: u64,
+    pub direct_read: u64,
+    pub direct_write: u64,
+    pub server_read: u64,
+    pub server_write: u64,
+    pub pages_read: u64,
+    pub pages_written: u64,
+}
+
+impl NFSBytes {
+    pub fn parse(value: &str) -> Option<Self> {
+        let n: Vec<u64> = value
+            .split_whitespace()
+            .map(|f| f.parse().ok())
+            .collect::<Option<_>>()?;
+        if n.len() < 8 {
+            return None;
+        }
+        Some(Self {
+            normal_read: n[0],
+            normal_write: n[1],
+            direct_read: n[2],
+            direct_write: n[3],
+            server_read: n[4],
+            server_write: n[5],
+            pages_read: n[6],
+            pages_written: n[7],
+        })
+    }
+
+    pub fn from_section(section: &MountSection) -> Option<Self> {
+        section.value("bytes").and_then(Self::parse)
+    }
+
+    /// Counters accumulated since `prev`.
+    pub fn delta(&self, prev: &NFSBytes) -> NFSBytes {
+        NFSBytes {
+            normal_read: self.normal_read.saturating_sub(prev.normal_read),
+            normal_write: self.normal_write.saturating_sub(prev.normal_write),
+            direct_read: self.direct_read.saturating_sub(prev.direct_read),
+            direct_write: self.direct_write.saturating_sub(prev.direct_write),
+            server_read: self.server_read.saturating_sub(prev.server_read),
+            server_write: self.server_write.saturating_sub(prev.server_write),
+            pages_read: self.pages_read.saturating_sub(prev.pages_read),
+            pages_written: self.pages_written.saturating_sub(prev.pages_written),
+        }
+    }
+}
+
+/// Per-second rates, in KB/s, for one interval's [`NFSBytes`] delta.
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct IoSplit {
+    pub buffered_read: f64,
+    pub direct_read: f64,
+    pub server_read: f64,
+    pub buffered_write: f64,
+    pub direct_write: f64,
+    pub server_write: f64,
+}
+
+impl IoSplit {
+    pub fn from_delta(delta: &NFSBytes, interval_secs: f64) -> Self {
+        if interval_secs <= 0.0 {
+            return Self::default();
+        }
+        let kbs = |b: u64| b as f64 / 1024.0 / interval_secs;
+        Self {
+            buffered_read: kbs(delta.normal_read),
+            direct_read: kbs(delta.direct_read),
+            server_read: kbs(delta.server_read),
+            buffered_write: kbs(delta.normal_write),
+            direct_write: kbs(delta.direct_write),
+            server_write: kbs(delta.server_write),
+        }
+    }
+
+    /// Share of application reads that bypassed the page cache.
+    pub fn direct_read_pct(&self) -> f64 {
+        pct(self.direct_read, self.buffered_read + self.direct_read)
+    }
+
+    pub fn direct_write_pct(&self) -> f64 {
+        pct(self.direct_write, self.buffered_write + self.direct_write)
+    }
+}
+
+fn pct(part: f64, whole: f64) -> f64 {
+    if whole > 0.0 {
+        part * 100.0 / whole
+    } else {
+        0.0
+    }
+}
+
+pub fn display_io_split<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    split: &IoSplit,
+) -> io::Result<()> {
+    writeln!(writer, "Bandwidth for {} (KB/s)", mount_point)?;
+    writeln!(
+        writer,
+        "{:<6} {:>12} {:>12} {:>8} {:>12}",
+        "", "BUFFERED", "DIRECT", "DIRECT%", "SERVER"
+    )?;
+    writeln!(writer, "{}", "-".repeat(54))?;
+    writeln!(
+        writer,
+        "{:<6} {:>12.2} {:>12.2} {:>7.1}% {:>12.2}",
+        "read",
+        split.buffered_read,
+        split.direct_read,
+        split.direct_read_pct(),
+        split.server_read
+    )?;
+    writeln!(
+        writer,
+        "{:<6} {:>12.2} {:>12.2} {:>7.1}% {:>12.2}",
+        "write",
+        split.buffered_write,
+        split.direct_write,
+        split.direct_write_pct(),
+        split.server_write
+    )?;
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::{parse_sections, tests::MOUNTSTATS};
+
+    #[test]
+    fn test_parse_bytes() {
+        let sections = parse_sections(MOUNTSTATS);
+        let bytes = NFSBytes::from_section(&sections[0]).unwrap();
+        assert_eq!(
+            bytes,
+            NFSBytes {
+                normal_read: 1000,
+                normal_write: 2000,
+                direct_read: 300,
+                direct_write: 400,
+                server_read: 5000,
+                server_write: 6000,
+                pages_read: 70,
+                pages_written: 80,
+            }
+        );
+        assert!(NFSBytes::from_section(&sections[1]).is_none());
+        assert!(NFSBytes::parse("1 2 3").is_none());
+    }
+
+    #[test]
+    fn test_io_split() {
+        let prev = NFSBytes::parse("0 0 0 0 0 0 0 0").unwrap();
+        let cur = NFSBytes::parse("3072 1024 1024 3072 4096 4096 1 1").unwrap();
+        let split = IoSplit::from_delta(&cur.delta(&prev), 1.0);
+        assert_eq!(split.buffered_read, 3.0);
+        assert_eq!(split.direct_read, 1.0);
+        assert_eq!(split.direct_read_pct(), 25.0);
+        assert_eq!(split.direct_write_pct(), 75.0);
+        assert_eq!(split.server_write, 4.0);
+
+        let mut out = Vec::new();
+        display_io_split(&mut out, "/mnt/nfs", &split).unwrap();
+        assert!(String::from_utf8(out).unwrap().contains("25.0%"));
+    }
+}
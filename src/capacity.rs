@@ -0,0 +1,157 @@
+//! df-style capacity columns via statvfs(2).
+
+use crate::units::human_bytes;
+use clap::Args;
+use std::ffi::CString;
+use std::io::{self, Write};
+use std::mem::MaybeUninit;
+use std::os::unix::ffi::OsStrExt;
+use std::path::Path;
+use std::sync::mpsc;
+use std::thread;
+use std::time::Duration;
+
+#[derive(Args, Debug, Clone)]
+pub struct CapacityArgs {
+    /// Show size/used/avail/%used for each mount
+    #[arg(long = "df")]
+    pub df: bool,
+
+    /// Alert when a mount is at least this full (percent)
+    #[arg(long = "alert-full-pct", default_value = "90")]
+    pub alert_full_pct: f64,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct Capacity {
+    pub size: u64,
+    pub used: u64,
+    pub avail: u64,
+}
+
+impl Capacity {
+    // Field widths differ between targets (u32 on some 32-bit libcs).
+    #[allow(clippy::unnecessary_cast)]
+    pub fn from_statvfs(st: &libc::statvfs) -> Self {
+        let frsize = st.f_frsize as u64;
+        let size = st.f_blocks as u64 * frsize;
+        let free = st.f_bfree as u64 * frsize;
+        Self {
+            size,
+            used: size.saturating_sub(free),
+            avail: st.f_bavail as u64 * frsize,
+        }
+    }
+
+    /// Percentage used as df computes it: used / (used + avail), so
+    /// root-reserved blocks count against the user.
+    pub fn used_pct(&self) -> f64 {
+        let total = self.used + self.avail;
+        if total == 0 {
+            0.0
+        } else {
+            self.used as f64 * 100.0 / total as f64
+        }
+    }
+
+    pub fn near_full(&self, threshold_pct: f64) -> bool {
+        self.used_pct() >= threshold_pct
+    }
+}
+
+pub fn statvfs(path: &Path) -> io::Result<Capacity> {
+    let c_path = CString::new(path.as_os_str().as_bytes())
+        .map_err(|e| io::Error::new(io::ErrorKind::InvalidInput, e))?;
+    let mut st = MaybeUninit::<libc::statvfs>::uninit();
+    // SAFETY: c_path is NUL-terminated and st is a valid out-pointer.
+    let rc = unsafe { libc::statvfs(c_path.as_ptr(), st.as_mut_ptr()) };
+    if rc != 0 {
+        return Err(io::Error::last_os_error());
+    }
+    // SAFETY: statvfs returned success, so st is initialised.
+    Ok(Capacity::from_statvfs(unsafe { &st.assume_init() }))
+}
+
+/// statvfs on a hard mount whose server is down blocks indefinitely, so the
+/// call runs on a helper thread. On timeout the thread is abandoned and the
+/// mount reported as unresponsive rather than stalling the sampling loop.
+pub fn statvfs_with_timeout(path: &Path, timeout: Duration) -> io::Result<Capacity> {
+    let (tx, rx) = mpsc::channel();
+    let owned = path.to_path_buf();
+    thread::spawn(move || {
+        let _ = tx.send(statvfs(&owned));
+    });
+    rx.recv_timeout(timeout).unwrap_or_else(|_| {
+        Err(io::Error::new(
+            io::ErrorKind::TimedOut,
+            format!("statfs on {} timed out", path.display()),
+        ))
+    })
+}
+
+/// Format a byte count with a binary unit suffix, df -h style.
+pub fn format_size(bytes: u64) -> String {
+    human_bytes(bytes as f64)
+}
+
+pub fn display_capacity<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    capacity: &io::Result<Capacity>,
+    alert_full_pct: f64,
+) -> io::Result<()> {
+    match capacity {
+        Ok(c) => {
+            writeln!(
+                writer,
+                "Capacity: {} size, {} used, {} avail, {:.0}% used",
+                format_size(c.size),
+                format_size(c.used),
+                format_size(c.avail),
+                c.used_pct()
+            )?;
+            if c.near_full(alert_full_pct) {
+                writeln!(
+                    writer,
+                    "ALERT: {} is {:.0}% full (threshold {:.0}%)",
+                    mount_point,
+                    c.used_pct(),
+                    alert_full_pct
+                )?;
+            }
+        }
+        Err(e) => writeln!(writer, "Capacity: unavailable ({})", e)?,
+    }
+    writeln!(writer)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_used_pct() {
+        let c = Capacity {
+            size: 1000,
+            used: 850,
+            avail: 50,
+        };
+        assert!((c.used_pct() - 94.444).abs() < 0.01);
+        assert!(c.near_full(90.0));
+        assert!(!Capacity::default().near_full(90.0));
+    }
+
+    #[test]
+    fn test_format_size() {
+        assert_eq!(format_size(512), "512B");
+        assert_eq!(format_size(1536), "1.5K");
+        assert_eq!(format_size(5 * 1024 * 1024 * 1024), "5.0G");
+    }
+
+    #[test]
+    fn test_statvfs_local() {
+        let c = statvfs_with_timeout(Path::new("/"), Duration::from_secs(5)).unwrap();
+        assert!(c.size > 0);
+        assert!(statvfs(Path::new("/does/not/exist")).is_err());
+    }
+}
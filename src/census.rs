@@ -0,0 +1,212 @@
+//! Open-file census: who holds files open on each monitored NFS mount.
+//!
+//! Deleted-but-open files are what the client silly-renames to `.nfsXXXX`
+//! and what makes `umount` report the mount as busy, so they are counted
+//! separately.
+
+use clap::Args;
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::io::{self, Write};
+use std::path::Path;
+
+#[derive(Args, Debug, Clone)]
+pub struct CensusArgs {
+    /// Show open files and owning processes per mount
+    #[arg(long = "open-files")]
+    pub open_files: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ProcessFiles {
+    pub pid: u32,
+    pub comm: String,
+    pub files: usize,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct MountCensus {
+    pub mount_point: String,
+    pub open_files: usize,
+    /// Open files whose name has been unlinked (`(deleted)` or `.nfs*`).
+    pub deleted: usize,
+    /// Busiest processes first.
+    pub processes: Vec<ProcessFiles>,
+}
+
+/// The most specific mount point containing `path`, so nested mounts are
+/// attributed to the inner one.
+pub fn mount_for_path<'a>(path: &Path, mount_points: &'a [String]) -> Option<&'a str> {
+    mount_points
+        .iter()
+        .filter(|mp| path.starts_with(mp.as_str()))
+        .max_by_key(|mp| mp.len())
+        .map(String::as_str)
+}
+
+/// Whether a /proc/<pid>/fd link target refers to an unlinked file.
+pub fn is_deleted(target: &str) -> bool {
+    if target.ends_with(" (deleted)") {
+        return true;
+    }
+    Path::new(target)
+        .file_name()
+        .and_then(|n| n.to_str())
+        .is_some_and(|n| n.starts_with(".nfs"))
+}
+
+/// Scan every process's descriptor table under `proc_root`.
+pub fn take_census(proc_root: &str, mount_points: &[String]) -> io::Result<Vec<MountCensus>> {
+    let mut census: BTreeMap<&str, MountCensus> = mount_points
+        .iter()
+        .map(|mp| {
+            (
+                mp.as_str(),
+                MountCensus {
+                    mount_point: mp.clone(),
+                    ..Default::default()
+                },
+            )
+        })
+        .collect();
+    let mut per_process: HashMap<(&str, u32), usize> = HashMap::new();
+    let mut comms: HashMap<u32, String> = HashMap::new();
+
+    for entry in fs::read_dir(proc_root)?.flatten() {
+        let Some(pid) = entry
+            .file_name()
+            .to_str()
+            .and_then(|s| s.parse::<u32>().ok())
+        else {
+            continue;
+        };
+        // Permission errors and exited processes are expected; skip them.
+        let Ok(fds) = fs::read_dir(entry.path().join("fd")) else {
+            continue;
+        };
+        for fd in fds.flatten() {
+            let Ok(target) = fs::read_link(fd.path()) else {
+                continue;
+            };
+            let target = target.to_string_lossy();
+            let Some(mp) = mount_for_path(Path::new(target.as_ref()), mount_points) else {
+                continue;
+            };
+            let Some(mount) = census.get_mut(mp) else {
+                continue;
+            };
+            mount.open_files += 1;
+            if is_deleted(&target) {
+                mount.deleted += 1;
+            }
+            *per_process.entry((mp, pid)).or_insert(0) += 1;
+            comms.entry(pid).or_insert_with(|| {
+                fs::read_to_string(entry.path().join("comm"))
+                    .map(|c| c.trim().to_string())
+                    .unwrap_or_default()
+            });
+        }
+    }
+
+    for ((mp, pid), files) in per_process {
+        if let Some(mount) = census.get_mut(mp) {
+            mount.processes.push(ProcessFiles {
+                pid,
+                comm: comms.get(&pid).cloned().unwrap_or_default(),
+                files,
+            });
+        }
+    }
+
+    let mut result: Vec<MountCensus> = census.into_values().collect();
+    for mount in &mut result {
+        mount
+            .processes
+            .sort_by(|a, b| b.files.cmp(&a.files).then(a.pid.cmp(&b.pid)));
+    }
+    Ok(result)
+}
+
+pub fn display_census<W: Write>(
+    writer: &mut W,
+    census: &[MountCensus],
+    top: usize,
+) -> io::Result<()> {
+    for mount in census {
+        writeln!(
+            writer,
+            "{}: {} open files ({} deleted) by {} processes",
+            mount.mount_point,
+            mount.open_files,
+            mount.deleted,
+            mount.processes.len()
+        )?;
+        for process in mount.processes.iter().take(top) {
+            writeln!(
+                writer,
+                "  {:<8} {:<16} {:>6}",
+                process.pid, process.comm, process.files
+            )?;
+        }
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::os::unix::fs::symlink;
+
+    #[test]
+    fn test_mount_for_path_prefers_nested() {
+        let mounts = vec!["/mnt".to_string(), "/mnt/data".to_string()];
+        assert_eq!(
+            mount_for_path(Path::new("/mnt/data/file"), &mounts),
+            Some("/mnt/data")
+        );
+        assert_eq!(
+            mount_for_path(Path::new("/mnt/other"), &mounts),
+            Some("/mnt")
+        );
+        assert_eq!(mount_for_path(Path::new("/mntx/file"), &mounts), None);
+    }
+
+    #[test]
+    fn test_is_deleted() {
+        assert!(is_deleted("/mnt/data/log.txt (deleted)"));
+        assert!(is_deleted("/mnt/data/.nfs000000000123abcd00000001"));
+        assert!(!is_deleted("/mnt/data/report.nfs"));
+    }
+
+    #[test]
+    fn test_take_census() {
+        let proc_root = tempfile::tempdir().unwrap();
+        for (pid, comm, targets) in [
+            (
+                100,
+                "rsync",
+                vec!["/mnt/data/a", "/mnt/data/b", "/etc/passwd"],
+            ),
+            (200, "tail", vec!["/mnt/data/.nfs0001"]),
+        ] {
+            let dir = proc_root.path().join(pid.to_string());
+            fs::create_dir_all(dir.join("fd")).unwrap();
+            fs::write(dir.join("comm"), format!("{}\n", comm)).unwrap();
+            for (fd, target) in targets.iter().enumerate() {
+                symlink(target, dir.join("fd").join(fd.to_string())).unwrap();
+            }
+        }
+
+        let mounts = vec!["/mnt/data".to_string(), "/mnt/idle".to_string()];
+        let census = take_census(proc_root.path().to_str().unwrap(), &mounts).unwrap();
+
+        assert_eq!(census.len(), 2);
+        let data = &census[0];
+        assert_eq!(data.open_files, 3);
+        assert_eq!(data.deleted, 1);
+        assert_eq!(data.processes[0].comm, "rsync");
+        assert_eq!(data.processes[0].files, 2);
+        assert_eq!(census[1].open_files, 0);
+    }
+}
@@ -0,0 +1,327 @@
+//! Group NFS activity by systemd unit / container cgroup.
+//!
+//! Processes are bucketed by mount namespace; each namespace's mountstats
+//! is read through one of its members, and the resulting traffic is
+//! attributed to the cgroup v2 units living in that namespace.
+
+use crate::parser::parse_mountstats;
+use crate::types::NFSMount;
+use clap::Args;
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::fs;
+use std::io::{self, Write};
+use std::path::Path;
+
+#[derive(Args, Debug, Clone)]
+pub struct CgroupArgs {
+    /// Group NFS traffic by systemd unit / container cgroup
+    #[arg(long = "by-cgroup")]
+    pub by_cgroup: bool,
+
+    /// Root of the proc filesystem
+    #[arg(long = "proc-root", default_value = "/proc")]
+    pub proc_root: String,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct MountNamespace {
+    pub inode: u64,
+    /// Member processes, lowest PID first.
+    pub pids: Vec<u32>,
+    /// Unit labels of every member's cgroup.
+    pub units: BTreeSet<String>,
+}
+
+impl MountNamespace {
+    /// Path of this namespace's mountstats, read through its first member.
+    pub fn mountstats_path(&self, proc_root: &str) -> String {
+        format!("{}/{}/mountstats", proc_root, self.pids[0])
+    }
+
+    /// Label used when grouping: the units sharing this namespace.
+    pub fn label(&self) -> String {
+        if self.units.is_empty() {
+            format!("mnt:[{}]", self.inode)
+        } else {
+            self.units.iter().cloned().collect::<Vec<_>>().join(",")
+        }
+    }
+}
+
+/// Parse the `mnt:[4026531840]` target of /proc/<pid>/ns/mnt.
+pub fn parse_ns_link(link: &str) -> Option<u64> {
+    link.strip_prefix("mnt:[")?.strip_suffix(']')?.parse().ok()
+}
+
+/// The cgroup v2 path from /proc/<pid>/cgroup (the `0::` entry).
+pub fn parse_cgroup_file(contents: &str) -> Option<&str> {
+    contents.lines().find_map(|line| line.strip_prefix("0::"))
+}
+
+/// The container ID in a cgroup path: the leaf of a systemd-driver scope
+/// such as `docker-<id>.scope`, or a bare 64-hex-digit leaf as the
+/// cgroupfs driver writes (`/docker/<id>`).
+pub fn container_id(cgroup_path: &str) -> Option<&str> {
+    let leaf = cgroup_path.rsplit('/').find(|c| !c.is_empty())?;
+    for prefix in ["docker-", "cri-containerd-", "crio-", "libpod-"] {
+        if let Some(id) = leaf
+            .strip_prefix(prefix)
+            .and_then(|rest| rest.strip_suffix(".scope"))
+        {
+            return Some(id);
+        }
+    }
+    (leaf.len() == 64 && leaf.bytes().all(|b| b.is_ascii_hexdigit())).then_some(leaf)
+}
+
+/// Reduce a cgroup path to a readable unit label: systemd units keep their
+/// name, container scopes become `container:<short id>`.
+pub fn unit_label(cgroup_path: &str) -> String {
+    if let Some(id) = container_id(cgroup_path) {
+        return format!("container:{}", &id[..id.len().min(12)]);
+    }
+    cgroup_path
+        .rsplit('/')
+        .find(|c| !c.is_empty())
+        .unwrap_or("/")
+        .to_string()
+}
+
+/// Walk `proc_root` and bucket processes by mount namespace.
+pub fn discover_namespaces(proc_root: &str) -> io::Result<Vec<MountNamespace>> {
+    let mut by_inode: BTreeMap<u64, MountNamespace> = BTreeMap::new();
+
+    for entry in fs::read_dir(proc_root)?.flatten() {
+        let Some(pid) = entry
+            .file_name()
+            .to_str()
+            .and_then(|s| s.parse::<u32>().ok())
+        else {
+            continue;
+        };
+        let base = entry.path();
+        // Processes can exit mid-scan; skip anything we cannot read.
+        let Some(inode) = fs::read_link(base.join("ns/mnt"))
+            .ok()
+            .and_then(|l| parse_ns_link(&l.to_string_lossy()))
+        else {
+            continue;
+        };
+
+        let ns = by_inode.entry(inode).or_insert_with(|| MountNamespace {
+            inode,
+            pids: Vec::new(),
+            units: BTreeSet::new(),
+        });
+        ns.pids.push(pid);
+        if let Some(unit) = fs::read_to_string(base.join("cgroup"))
+            .ok()
+            .as_deref()
+            .and_then(parse_cgroup_file)
+            .map(unit_label)
+        {
+            ns.units.insert(unit);
+        }
+    }
+
+    let mut namespaces: Vec<MountNamespace> = by_inode.into_values().collect();
+    for ns in &mut namespaces {
+        ns.pids.sort_unstable();
+    }
+    Ok(namespaces)
+}
+
+/// NFS mounts visible in each namespace, keyed by namespace inode.
+/// Namespaces without NFS mounts are omitted.
+pub fn snapshot(proc_root: &str, namespaces: &[MountNamespace]) -> HashMap<u64, Vec<NFSMount>> {
+    namespaces
+        .iter()
+        .filter(|ns| !ns.pids.is_empty())
+        .filter_map(|ns| {
+            let path = ns.mountstats_path(proc_root);
+            let mounts = parse_mountstats(&path).ok()?;
+            (!mounts.is_empty()).then_some((ns.inode, mounts))
+        })
+        .collect()
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct CgroupUsage {
+    pub group: String,
+    pub mounts: Vec<String>,
+    pub ops: i64,
+    pub bytes_read: i64,
+    pub bytes_write: i64,
+}
+
+fn total_ops(mount: &NFSMount) -> i64 {
+    mount.operations.values().map(|op| op.ops).sum()
+}
+
+/// Attribute the traffic between two snapshots to namespace groups,
+/// busiest first. Namespaces whose label matches are merged.
+pub fn attribute(
+    namespaces: &[MountNamespace],
+    before: &HashMap<u64, Vec<NFSMount>>,
+    after: &HashMap<u64, Vec<NFSMount>>,
+) -> Vec<CgroupUsage> {
+    let mut groups: BTreeMap<String, CgroupUsage> = BTreeMap::new();
+
+    for ns in namespaces {
+        let (Some(prev), Some(cur)) = (before.get(&ns.inode), after.get(&ns.inode)) else {
+            continue;
+        };
+        let label = ns.label();
+        let usage = groups.entry(label.clone()).or_insert_with(|| CgroupUsage {
+            group: label,
+            ..Default::default()
+        });
+
+        for mount in cur {
+            let Some(old) = prev.iter().find(|m| m.mount_point == mount.mount_point) else {
+                continue;
+            };
+            let ops = total_ops(mount) - total_ops(old);
+            if ops < 0 {
+                continue;
+            }
+            usage.ops += ops;
+            usage.bytes_read += (mount.bytes_read - old.bytes_read).max(0);
+            usage.bytes_write += (mount.bytes_write - old.bytes_write).max(0);
+            if ops > 0 && !usage.mounts.contains(&mount.mount_point) {
+                usage.mounts.push(mount.mount_point.clone());
+            }
+        }
+    }
+
+    let mut usage: Vec<CgroupUsage> = groups.into_values().filter(|u| u.ops > 0).collect();
+    usage.sort_by(|a, b| b.ops.cmp(&a.ops).then_with(|| a.group.cmp(&b.group)));
+    usage
+}
+
+pub fn display_cgroup_usage<W: Write>(
+    writer: &mut W,
+    usage: &[CgroupUsage],
+    interval_secs: f64,
+) -> io::Result<()> {
+    if usage.is_empty() {
+        return Ok(());
+    }
+    let interval = interval_secs.max(f64::EPSILON);
+
+    writeln!(
+        writer,
+        "{:<40} {:>10} {:>10} {:>10}  MOUNTS",
+        "CGROUP", "IOPS", "READ MB/s", "WRITE MB/s"
+    )?;
+    writeln!(writer, "{}", "-".repeat(84))?;
+    for group in usage {
+        writeln!(
+            writer,
+            "{:<40} {:>10.1} {:>10.2} {:>10.2}  {}",
+            group.group,
+            group.ops as f64 / interval,
+            group.bytes_read as f64 / interval / 1_048_576.0,
+            group.bytes_write as f64 / interval / 1_048_576.0,
+            group.mounts.join(",")
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+/// Whether `proc_root` looks like a cgroup v2 (unified) host.
+pub fn cgroup_v2_available(proc_root: &str) -> bool {
+    fs::read_to_string(Path::new(proc_root).join("self/cgroup"))
+        .map(|c| parse_cgroup_file(&c).is_some())
+        .unwrap_or(false)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::NFSOperation;
+    use std::os::unix::fs::symlink;
+
+    #[test]
+    fn test_unit_label() {
+        assert_eq!(unit_label("/system.slice/nginx.service"), "nginx.service");
+        assert_eq!(
+            unit_label("/system.slice/docker-0123456789abcdef0123.scope"),
+            "container:0123456789ab"
+        );
+        assert_eq!(
+            unit_label("/kubepods.slice/kubepods-pod1.slice/cri-containerd-deadbeef.scope"),
+            "container:deadbeef"
+        );
+        assert_eq!(unit_label("/"), "/");
+        let hex = "ab".repeat(32);
+        assert_eq!(
+            container_id(&format!("/docker/{}", hex)),
+            Some(hex.as_str())
+        );
+        assert_eq!(container_id("/system.slice/nginx.service"), None);
+        assert_eq!(parse_ns_link("mnt:[4026531840]"), Some(4026531840));
+        assert_eq!(parse_cgroup_file("0::/user.slice\n"), Some("/user.slice"));
+    }
+
+    #[test]
+    fn test_discover_namespaces() {
+        let proc_root = tempfile::tempdir().unwrap();
+        for (pid, ns, cgroup) in [
+            (10, 100, "/system.slice/backup.service"),
+            (11, 100, "/system.slice/backup.service"),
+            (20, 200, "/system.slice/docker-abc.scope"),
+        ] {
+            let dir = proc_root.path().join(pid.to_string());
+            fs::create_dir_all(dir.join("ns")).unwrap();
+            symlink(format!("mnt:[{}]", ns), dir.join("ns/mnt")).unwrap();
+            fs::write(dir.join("cgroup"), format!("0::{}\n", cgroup)).unwrap();
+        }
+        fs::create_dir_all(proc_root.path().join("self")).unwrap();
+
+        let namespaces = discover_namespaces(proc_root.path().to_str().unwrap()).unwrap();
+        assert_eq!(namespaces.len(), 2);
+        assert_eq!(namespaces[0].pids, vec![10, 11]);
+        assert_eq!(namespaces[0].label(), "backup.service");
+        assert_eq!(namespaces[1].label(), "container:abc");
+    }
+
+    #[test]
+    fn test_attribute() {
+        let mount = |ops, bytes_read| NFSMount {
+            device: "srv:/vol".to_string(),
+            mount_point: "/data".to_string(),
+            server: "srv".to_string(),
+            export: "/vol".to_string(),
+            age: 5,
+            operations: [(
+                "READ".to_string(),
+                NFSOperation {
+                    name: "READ".to_string(),
+                    ops,
+                    ..Default::default()
+                },
+            )]
+            .into_iter()
+            .collect(),
+            events: None,
+            bytes_read,
+            bytes_write: 0,
+        };
+        let ns = MountNamespace {
+            inode: 7,
+            pids: vec![1],
+            units: ["db.service".to_string()].into_iter().collect(),
+        };
+        let before = HashMap::from([(7, vec![mount(10, 1000)])]);
+        let after = HashMap::from([(7, vec![mount(60, 9000)])]);
+
+        let usage = attribute(&[ns], &before, &after);
+        assert_eq!(usage.len(), 1);
+        assert_eq!(usage[0].group, "db.service");
+        assert_eq!(usage[0].ops, 50);
+        assert_eq!(usage[0].bytes_read, 8000);
+        assert_eq!(usage[0].mounts, vec!["/data".to_string()]);
+    }
+}
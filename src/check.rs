@@ -0,0 +1,494 @@
+//! `nfs-gaze check`: a quick per-mount health grade built from a short
+//! sampling window.
+
+use crate::delta::{checked_mount_delta, delta};
+use crate::parser::parse_mountstats_str;
+use crate::sections::parse_sections;
+use crate::selection::MountSelector;
+use crate::types::{NFSMount, Result};
+use crate::xprt::{delta_transports, total_transport, transports_by_mount, NFSTransport};
+use clap::Args;
+use serde::Serialize;
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::io::{self, Write};
+use std::thread;
+use std::time::{Duration, Instant};
+
+/// Operations whose latency is judged against the data-op thresholds.
+const DATA_OPS: &[&str] = &["READ", "WRITE", "COMMIT"];
+
+#[derive(Args, Debug, Clone)]
+pub struct CheckArgs {
+    /// Sampling window in seconds
+    #[arg(long = "duration", default_value = "2")]
+    pub duration: u64,
+
+    /// Emit the report as JSON
+    #[arg(long = "json")]
+    pub json: bool,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Status {
+    Ok,
+    Warning,
+    Critical,
+}
+
+impl Status {
+    /// Nagios-style exit code so the command can be used from scripts.
+    pub fn exit_code(self) -> i32 {
+        match self {
+            Status::Ok => 0,
+            Status::Warning => 1,
+            Status::Critical => 2,
+        }
+    }
+
+    fn label(self) -> &'static str {
+        match self {
+            Status::Ok => "OK",
+            Status::Warning => "WARNING",
+            Status::Critical => "CRITICAL",
+        }
+    }
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct MountHealth {
+    pub mount_point: String,
+    pub server: String,
+    pub export: String,
+    pub score: u32,
+    pub status: Status,
+    pub ops: i64,
+    pub metadata_rtt_ms: f64,
+    pub data_rtt_ms: f64,
+    pub retrans_pct: f64,
+    pub error_pct: f64,
+    pub timeouts: i64,
+    pub hung: bool,
+    pub findings: Vec<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct CheckReport {
+    pub duration_secs: f64,
+    pub status: Status,
+    pub mounts: Vec<MountHealth>,
+}
+
+/// One read of mountstats: the parsed mounts and their transports.
+#[derive(Debug, Clone, Default)]
+pub struct Sample {
+    pub mounts: Vec<NFSMount>,
+    pub transports: BTreeMap<String, Vec<NFSTransport>>,
+}
+
+impl Sample {
+    pub fn read(path: &str) -> Result<Self> {
+        let contents = fs::read_to_string(path)?;
+        Ok(Self {
+            mounts: parse_mountstats_str(&contents)?,
+            transports: transports_by_mount(&parse_sections(&contents)),
+        })
+    }
+}
+
+/// Grade a mount from two consecutive snapshots. `xprt` is the window's
+/// transport delta summed over the mount's connections.
+pub fn evaluate_mount(before: &NFSMount, after: &NFSMount, xprt: &NFSTransport) -> MountHealth {
+    // Only counter deltas are graded, so the window length is not needed.
+    let (stats, resets) = checked_mount_delta(before, after, 1.0);
+    let mut ops = 0i64;
+    let mut retrans = 0i64;
+    let mut timeouts = 0i64;
+    let mut errors = 0i64;
+    let (mut meta_ops, mut meta_rtt) = (0i64, 0i64);
+    let (mut data_ops, mut data_rtt) = (0i64, 0i64);
+
+    for stat in &stats {
+        ops += stat.delta_ops;
+        retrans += stat.delta_retrans;
+        errors += stat.delta_errors;
+        let name = stat.operation.as_str();
+        if let (Some(prev), Some(cur)) = (before.operations.get(name), after.operations.get(name)) {
+            timeouts += delta(cur.timeouts, prev.timeouts);
+        }
+        if DATA_OPS.contains(&name) {
+            data_ops += stat.delta_ops;
+            data_rtt += stat.delta_rtt;
+        } else {
+            meta_ops += stat.delta_ops;
+            meta_rtt += stat.delta_rtt;
+        }
+    }
+
+    let avg = |total: i64, count: i64| {
+        if count > 0 {
+            total as f64 / count as f64
+        } else {
+            0.0
+        }
+    };
+    let pct = |part: i64, whole: i64| {
+        if whole > 0 {
+            part.max(0) as f64 * 100.0 / whole as f64
+        } else {
+            0.0
+        }
+    };
+
+    let metadata_rtt_ms = avg(meta_rtt, meta_ops);
+    let data_rtt_ms = avg(data_rtt, data_ops);
+    let retrans_pct = pct(retrans, ops);
+    let error_pct = pct(errors, ops);
+    // Per-op counters only move when a request completes, so a server
+    // that stopped answering shows up on the transport: requests going
+    // out with no replies coming back, or requests queueing behind a
+    // full slot table while none of them complete.
+    let stalled = xprt.is_stalled();
+    let queueing = xprt.bklog_u > 0 || xprt.pending_u.unwrap_or(0) > 0;
+    let backed_up = !stalled && ops == 0 && queueing;
+    let hung = stalled || backed_up;
+
+    let mut score: i64 = 100;
+    let mut findings = Vec::new();
+
+    score -= latency_penalty(metadata_rtt_ms, [1.0, 5.0, 10.0]);
+    score -= latency_penalty(data_rtt_ms, [5.0, 20.0, 50.0]);
+    if metadata_rtt_ms > 5.0 {
+        findings.push(format!("metadata RTT {:.1}ms", metadata_rtt_ms));
+    }
+    if data_rtt_ms > 20.0 {
+        findings.push(format!("data RTT {:.1}ms", data_rtt_ms));
+    }
+    if retrans_pct > 0.0 {
+        score -= (retrans_pct * 5.0).min(40.0) as i64;
+        findings.push(format!("{:.2}% retransmissions", retrans_pct));
+    }
+    if error_pct > 0.0 {
+        score -= (error_pct * 10.0).min(40.0) as i64;
+        findings.push(format!("{:.2}% errors", error_pct));
+    }
+    if timeouts > 0 {
+        findings.push(format!("{} major timeouts", timeouts));
+    }
+    if xprt.bad_xids > 0 {
+        findings.push(format!("{} replies with unknown XIDs", xprt.bad_xids));
+    }
+    if stalled {
+        findings.push(format!(
+            "{} RPC transmissions with no replies (server not responding)",
+            xprt.sends
+        ));
+    }
+    if backed_up {
+        findings.push(
+            "RPC backlog growing with no operations completing (server not responding)".to_string(),
+        );
+    }
+    if hung {
+        score = 0;
+    }
+    for reset in &resets {
+        findings.push(format!(
+            "{} {} counter reset ({} -> {}); not graded",
+            reset.operation, reset.counter, reset.previous, reset.current
+        ));
+    }
+
+    let score = score.clamp(0, 100) as u32;
+    let status = if hung || score < 60 {
+        Status::Critical
+    } else if score < 85 {
+        Status::Warning
+    } else {
+        Status::Ok
+    };
+
+    MountHealth {
+        mount_point: after.mount_point.clone(),
+        server: after.server.clone(),
+        export: after.export.clone(),
+        score,
+        status,
+        ops,
+        metadata_rtt_ms,
+        data_rtt_ms,
+        retrans_pct,
+        error_pct,
+        timeouts,
+        hung,
+        findings,
+    }
+}
+
+/// Score deduction for an average latency against good/acceptable/poor
+/// thresholds in milliseconds.
+fn latency_penalty(rtt_ms: f64, thresholds: [f64; 3]) -> i64 {
+    if rtt_ms <= thresholds[0] {
+        0
+    } else if rtt_ms <= thresholds[1] {
+        5
+    } else if rtt_ms <= thresholds[2] {
+        15
+    } else {
+        35
+    }
+}
+
+/// Grade every mount present in both samples, ordered by mount point.
+pub fn evaluate(before: &Sample, after: &Sample, duration_secs: f64) -> CheckReport {
+    let previous: HashMap<&str, &NFSMount> = before
+        .mounts
+        .iter()
+        .map(|m| (m.mount_point.as_str(), m))
+        .collect();
+    let transports = |sample: &'_ Sample, mount_point: &str| -> Vec<NFSTransport> {
+        sample
+            .transports
+            .get(mount_point)
+            .cloned()
+            .unwrap_or_default()
+    };
+
+    let mut mounts: Vec<MountHealth> = after
+        .mounts
+        .iter()
+        .filter_map(|m| {
+            let prev = previous.get(m.mount_point.as_str())?;
+            // A shrinking age means the mount was replaced mid-sample.
+            if m.age < prev.age {
+                return None;
+            }
+            let xprt = total_transport(&delta_transports(
+                &transports(before, &m.mount_point),
+                &transports(after, &m.mount_point),
+            ));
+            Some(evaluate_mount(prev, m, &xprt))
+        })
+        .collect();
+    mounts.sort_by(|a, b| a.mount_point.cmp(&b.mount_point));
+
+    let status = mounts.iter().map(|m| m.status).max().unwrap_or(Status::Ok);
+
+    CheckReport {
+        duration_secs,
+        status,
+        mounts,
+    }
+}
+
+/// Sample `path` twice, `args.duration` seconds apart, and grade the result.
+pub fn run_check(path: &str, selector: &MountSelector, args: &CheckArgs) -> Result<CheckReport> {
+    let keep = |mut sample: Sample| -> Sample {
+        sample.mounts.retain(|m| selector.matches(&m.mount_point));
+        sample
+    };
+
+    let start = Instant::now();
+    let before = keep(Sample::read(path)?);
+    thread::sleep(Duration::from_secs(args.duration.max(1)));
+    let after = keep(Sample::read(path)?);
+
+    Ok(evaluate(&before, &after, start.elapsed().as_secs_f64()))
+}
+
+pub fn write_summary<W: Write>(writer: &mut W, report: &CheckReport) -> io::Result<()> {
+    if report.mounts.is_empty() {
+        writeln!(writer, "No NFS mounts found")?;
+        return Ok(());
+    }
+
+    for mount in &report.mounts {
+        writeln!(
+            writer,
+            "{:<8} {:>3}/100  {} ({}:{})",
+            mount.status.label(),
+            mount.score,
+            mount.mount_point,
+            mount.server,
+            mount.export
+        )?;
+        for finding in &mount.findings {
+            writeln!(writer, "         - {}", finding)?;
+        }
+    }
+    writeln!(
+        writer,
+        "\nOverall: {} ({} mounts, {:.1}s sample)",
+        report.status.label(),
+        report.mounts.len(),
+        report.duration_secs
+    )?;
+    Ok(())
+}
+
+pub fn write_json<W: Write>(writer: &mut W, report: &CheckReport) -> io::Result<()> {
+    serde_json::to_writer_pretty(&mut *writer, report)?;
+    writeln!(writer)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil;
+    use crate::types::NFSOperation;
+
+    fn mount(ops: &[(&str, i64, i64, i64, i64, i64)]) -> NFSMount {
+        let ops = ops.iter().map(
+            |&(name, count, ntrans, timeouts, rtt, errors)| NFSOperation {
+                ops: count,
+                ntrans,
+                timeouts,
+                rtt,
+                errors,
+                ..testutil::op(name)
+            },
+        );
+        NFSMount {
+            age: 10,
+            ..testutil::with_ops(testutil::mount_of("server", "/export", "/mnt/nfs"), ops)
+        }
+    }
+
+    /// A tcp transport delta with `sends` transmissions and `recvs` replies.
+    fn xprt(sends: u64, recvs: u64) -> NFSTransport {
+        NFSTransport {
+            protocol: "tcp".to_string(),
+            sends,
+            recvs,
+            ..Default::default()
+        }
+    }
+
+    fn sample(m: NFSMount, transport: &str) -> Sample {
+        let transports = [(
+            m.mount_point.clone(),
+            vec![NFSTransport::parse(transport).unwrap()],
+        )]
+        .into_iter()
+        .collect();
+        Sample {
+            mounts: vec![m],
+            transports,
+        }
+    }
+
+    #[test]
+    fn test_healthy_mount() {
+        let before = mount(&[("READ", 0, 0, 0, 0, 0), ("GETATTR", 0, 0, 0, 0, 0)]);
+        let after = mount(&[("READ", 100, 100, 0, 200, 0), ("GETATTR", 50, 50, 0, 25, 0)]);
+        let health = evaluate_mount(&before, &after, &xprt(150, 150));
+
+        assert_eq!(health.status, Status::Ok);
+        assert_eq!(health.score, 100);
+        assert_eq!(health.ops, 150);
+        assert!(!health.hung);
+        assert!(health.findings.is_empty());
+    }
+
+    #[test]
+    fn test_retrans_and_errors_degrade() {
+        let before = mount(&[("WRITE", 0, 0, 0, 0, 0)]);
+        let after = mount(&[("WRITE", 100, 110, 2, 500, 3)]);
+        let health = evaluate_mount(&before, &after, &xprt(110, 100));
+
+        assert!((health.retrans_pct - 10.0).abs() < 1e-9);
+        assert!((health.error_pct - 3.0).abs() < 1e-9);
+        assert!(!health.hung);
+        assert_eq!(health.status, Status::Critical);
+    }
+
+    #[test]
+    fn test_hang_detection() {
+        // The server stopped answering: per-op counters are frozen because
+        // nothing completes, while the client keeps retransmitting.
+        let before = mount(&[("READ", 10, 10, 0, 10, 0)]);
+        let after = before.clone();
+        let report = evaluate(
+            &sample(before, "tcp 869 1 1 0 0 500 500 0 900 0 65536 40 10"),
+            &sample(after, "tcp 869 1 1 0 0 503 500 0 960 3 65536 43 16"),
+            2.0,
+        );
+        let health = &report.mounts[0];
+
+        assert!(health.hung);
+        assert_eq!(health.score, 0);
+        assert_eq!(health.status, Status::Critical);
+        assert!(health.findings[0].contains("3 RPC transmissions with no replies"));
+
+        // An idle mount is not hung.
+        let idle = mount(&[("READ", 10, 10, 0, 10, 0)]);
+        assert!(!evaluate_mount(&idle, &idle, &xprt(0, 0)).hung);
+    }
+
+    #[test]
+    fn test_backlog_without_progress_is_hang() {
+        // A few late replies still arrive, but requests pile up behind the
+        // slot table and no operation completes.
+        let frozen = mount(&[("READ", 10, 10, 0, 10, 0)]);
+        let backed_up = NFSTransport {
+            bklog_u: 40,
+            pending_u: Some(12),
+            ..xprt(8, 2)
+        };
+        let health = evaluate_mount(&frozen, &frozen, &backed_up);
+        assert!(health.hung);
+        assert_eq!(health.status, Status::Critical);
+        assert!(health.findings[0].contains("RPC backlog growing"));
+
+        // The same queueing while operations complete is only load.
+        let after = mount(&[("READ", 60, 60, 0, 60, 0)]);
+        assert!(!evaluate_mount(&frozen, &after, &backed_up).hung);
+    }
+
+    #[test]
+    fn test_counter_reset_is_not_graded() {
+        let before = mount(&[("READ", 1000, 1000, 0, 1000, 0), ("GETATTR", 0, 0, 0, 0, 0)]);
+        let after = mount(&[("READ", 5, 5, 0, 5, 0), ("GETATTR", 50, 50, 0, 25, 0)]);
+        let health = evaluate_mount(&before, &after, &xprt(55, 55));
+
+        assert_eq!(health.ops, 50);
+        assert!(health.findings[0].contains("READ ops counter reset"));
+    }
+
+    #[test]
+    fn test_evaluate_skips_remounted() {
+        let before = mount(&[("READ", 10, 10, 0, 10, 0)]);
+        let mut after = before.clone();
+        after.age = 1;
+
+        let before = Sample {
+            mounts: vec![before],
+            ..Default::default()
+        };
+        let after = Sample {
+            mounts: vec![after],
+            ..Default::default()
+        };
+        let report = evaluate(&before, &after, 2.0);
+        assert!(report.mounts.is_empty());
+        assert_eq!(report.status, Status::Ok);
+    }
+
+    #[test]
+    fn test_json_output() {
+        let before = mount(&[("READ", 0, 0, 0, 0, 0)]);
+        let after = mount(&[("READ", 10, 10, 0, 10, 0)]);
+        let report = evaluate(
+            &sample(before, "tcp 869 1 1 0 0 0 0 0 0 0 65536 0 0"),
+            &sample(after, "tcp 869 1 1 0 0 10 10 0 10 0 65536 10 10"),
+            2.0,
+        );
+
+        let mut out = Vec::new();
+        write_json(&mut out, &report).unwrap();
+        let value: serde_json::Value = serde_json::from_slice(&out).unwrap();
+        assert_eq!(value["status"], "ok");
+        assert_eq!(value["mounts"][0]["mount_point"], "/mnt/nfs");
+    }
+}
@@ -0,0 +1,484 @@
+//! Command-line interface. Monitoring flags live on [`Args`]; modes that
+//! do something other than watch mountstats are subcommands.
+
+use crate::agent::AgentArgs;
+use crate::alert::AlertArgs;
+use crate::allns::AllNamespacesArgs;
+use crate::attribution::AttributionArgs;
+use crate::baseline::BaselineArgs;
+use crate::bench::BenchArgs;
+use crate::capacity::CapacityArgs;
+use crate::census::CensusArgs;
+use crate::cgroups::CgroupArgs;
+use crate::check::CheckArgs;
+use crate::collector::CollectorArgs;
+#[cfg(feature = "parquet")]
+use crate::columnar::ParquetArgs;
+use crate::columns::ColumnArgs;
+use crate::compare::CompareArgs;
+use crate::config::ConfigArgs;
+use crate::cumulative::CumulativeArgs;
+use crate::deepdebug::DeepDebugArgs;
+use crate::delegation::DelegationArgs;
+use crate::diff::DiffArgs;
+use crate::errcodes::ErrorCodeArgs;
+use crate::exitsummary::ExitSummaryArgs;
+use crate::exporter::ExporterArgs;
+use crate::firstreport::FirstReportArgs;
+use crate::fleet::FleetArgs;
+use crate::gnuplot::GnuplotArgs;
+use crate::graph::GraphArgs;
+use crate::graphite::GraphiteArgs;
+#[cfg(feature = "grpc")]
+use crate::grpc::GrpcArgs;
+use crate::hostmeta::HostMetaArgs;
+use crate::identity::IdentityArgs;
+use crate::idle::IdleArgs;
+use crate::k8s::K8sArgs;
+use crate::labels::LabelArgs;
+use crate::latency::LatencyArgs;
+use crate::nfsd::ServerArgs;
+use crate::notify::NotifyArgs;
+use crate::once::OnceArgs;
+use crate::options::OptionWarningArgs;
+use crate::ordering::{SortArgs, TopArgs};
+use crate::output::OutputArgs;
+use crate::pnfs::PnfsArgs;
+use crate::presets::PresetArgs;
+use crate::quiet::QuietArgs;
+use crate::readahead::ReadaheadArgs;
+use crate::record::RecordArgs;
+use crate::recovery::RecoveryArgs;
+use crate::redact::RedactArgs;
+use crate::replay::ReplayArgs;
+use crate::report::ReportArgs;
+use crate::resolve::ResolveArgs;
+use crate::rollup::RollupArgs;
+use crate::sampling::{Interval, SamplingArgs};
+use crate::sandbox::SandboxArgs;
+use crate::security::SecurityArgs;
+use crate::selection::MountPatternArgs;
+use crate::serve::ServeArgs;
+use crate::servergroups::ServerGroupArgs;
+use crate::sink::SinkArgs;
+use crate::slab::SlabArgs;
+use crate::slots::SlotArgs;
+use crate::smooth::SmoothArgs;
+use crate::spans::SpanArgs;
+use crate::spark::SparkArgs;
+use crate::statsd::StatsdArgs;
+#[cfg(feature = "sqlite")]
+use crate::store::StoreArgs;
+use crate::summary::SummaryArgs;
+use crate::sunrpc::SunrpcArgs;
+use crate::talkers::TalkerArgs;
+use crate::target::TargetArgs;
+use crate::tls::TlsArgs;
+use crate::totals::TotalsArgs;
+use crate::tracefs::TracefsArgs;
+use crate::tui::TuiArgs;
+use crate::units::HumanArgs;
+use crate::watchop::WatchOpArgs;
+use crate::wide::WideEventArgs;
+use crate::writeback::WritebackArgs;
+use crate::xprt::TransportArgs;
+use crate::zabbix::ZabbixArgs;
+use clap::{Parser, Subcommand};
+use std::collections::HashSet;
+
+#[derive(Parser, Debug, Clone)]
+#[command(name = "nfs-gaze", version)]
+#[command(about = "NFS I/O Statistics Monitor")]
+pub struct Args {
+    /// Mount point to monitor; repeat or comma-separate for several
+    #[arg(short = 'm', long, global = true)]
+    pub mount_point: Vec<String>,
+
+    /// Comma-separated list of operations to monitor
+    #[arg(long = "ops")]
+    pub operations: Option<String>,
+
+    /// Update interval: seconds, or with a unit (200ms, 2s, 1m)
+    #[arg(short = 'i', long, default_value = "1")]
+    pub interval: Interval,
+
+    /// Number of iterations (0 = infinite)
+    #[arg(short = 'c', long, default_value = "0")]
+    pub count: usize,
+
+    /// Show attribute cache statistics
+    #[arg(long = "attr")]
+    pub show_attr: bool,
+
+    /// Show bandwidth statistics
+    #[arg(long = "bw")]
+    pub show_bandwidth: bool,
+
+    /// Clear screen between iterations
+    #[arg(long = "clear")]
+    pub clear_screen: bool,
+
+    /// Path to mountstats file
+    #[arg(
+        short = 'f',
+        long,
+        default_value = "/proc/self/mountstats",
+        global = true
+    )]
+    pub mountstats_path: String,
+
+    #[command(flatten)]
+    pub attribution: AttributionArgs,
+
+    #[command(flatten)]
+    pub error_codes: ErrorCodeArgs,
+
+    #[command(flatten)]
+    pub tracefs: TracefsArgs,
+
+    #[command(flatten)]
+    pub deep_debug: DeepDebugArgs,
+
+    #[command(flatten)]
+    pub cgroups: CgroupArgs,
+
+    #[command(flatten)]
+    pub census: CensusArgs,
+
+    #[command(flatten)]
+    pub slab: SlabArgs,
+
+    #[command(flatten)]
+    pub writeback: WritebackArgs,
+
+    #[command(flatten)]
+    pub delegation: DelegationArgs,
+
+    #[command(flatten)]
+    pub capacity: CapacityArgs,
+
+    #[command(flatten)]
+    pub resolve: ResolveArgs,
+
+    #[command(flatten)]
+    pub server_groups: ServerGroupArgs,
+
+    #[command(flatten)]
+    pub option_warnings: OptionWarningArgs,
+
+    #[command(flatten)]
+    pub tls: TlsArgs,
+
+    #[command(flatten)]
+    pub slots: SlotArgs,
+
+    #[command(flatten)]
+    pub recovery: RecoveryArgs,
+
+    #[command(flatten)]
+    pub identity: IdentityArgs,
+
+    #[command(flatten)]
+    pub report: ReportArgs,
+
+    #[command(flatten)]
+    pub rollup: RollupArgs,
+
+    #[command(flatten)]
+    pub talkers: TalkerArgs,
+
+    #[command(flatten)]
+    pub watch_op: WatchOpArgs,
+
+    #[command(flatten)]
+    pub gnuplot: GnuplotArgs,
+
+    #[command(flatten)]
+    pub graph: GraphArgs,
+
+    #[cfg(feature = "parquet")]
+    #[command(flatten)]
+    pub parquet: ParquetArgs,
+
+    #[cfg(feature = "grpc")]
+    #[command(flatten)]
+    pub grpc: GrpcArgs,
+
+    #[command(flatten)]
+    pub spans: SpanArgs,
+
+    #[command(flatten)]
+    pub wide: WideEventArgs,
+
+    #[command(flatten)]
+    pub notify: NotifyArgs,
+
+    #[command(flatten)]
+    pub idle: IdleArgs,
+
+    #[command(flatten)]
+    pub first_report: FirstReportArgs,
+
+    #[command(flatten)]
+    pub cumulative: CumulativeArgs,
+
+    #[command(flatten)]
+    pub sampling: SamplingArgs,
+
+    #[command(flatten)]
+    pub labels: LabelArgs,
+
+    #[command(flatten)]
+    pub host_meta: HostMetaArgs,
+
+    #[command(flatten)]
+    pub redact: RedactArgs,
+
+    #[command(flatten)]
+    pub preset: PresetArgs,
+
+    #[command(flatten)]
+    pub sandbox: SandboxArgs,
+
+    #[command(flatten)]
+    pub output: OutputArgs,
+
+    #[command(flatten)]
+    pub exporter: ExporterArgs,
+
+    #[command(flatten)]
+    pub graphite: GraphiteArgs,
+
+    #[command(flatten)]
+    pub statsd: StatsdArgs,
+
+    #[cfg(feature = "sqlite")]
+    #[command(flatten)]
+    pub store: StoreArgs,
+
+    #[command(flatten)]
+    pub zabbix: ZabbixArgs,
+
+    #[command(flatten)]
+    pub transports: TransportArgs,
+
+    #[command(flatten)]
+    pub sunrpc: SunrpcArgs,
+
+    #[command(flatten)]
+    pub pnfs: PnfsArgs,
+
+    #[command(flatten)]
+    pub readahead: ReadaheadArgs,
+
+    #[command(flatten)]
+    pub security: SecurityArgs,
+
+    #[command(flatten)]
+    pub patterns: MountPatternArgs,
+
+    #[command(flatten)]
+    pub tui: TuiArgs,
+
+    #[command(flatten)]
+    pub sort: SortArgs,
+
+    #[command(flatten)]
+    pub top: TopArgs,
+
+    #[command(flatten)]
+    pub totals: TotalsArgs,
+
+    #[command(flatten)]
+    pub summary: SummaryArgs,
+
+    #[command(flatten)]
+    pub human: HumanArgs,
+
+    #[command(flatten)]
+    pub columns: ColumnArgs,
+
+    #[command(flatten)]
+    pub record: RecordArgs,
+
+    #[command(flatten)]
+    pub replay: ReplayArgs,
+
+    #[command(flatten)]
+    pub once: OnceArgs,
+
+    #[command(flatten)]
+    pub config: ConfigArgs,
+
+    #[command(flatten)]
+    pub sink: SinkArgs,
+
+    #[command(flatten)]
+    pub latency: LatencyArgs,
+
+    #[command(flatten)]
+    pub smooth: SmoothArgs,
+
+    #[command(flatten)]
+    pub spark: SparkArgs,
+
+    #[command(flatten)]
+    pub exit_summary: ExitSummaryArgs,
+
+    #[command(flatten)]
+    pub alert: AlertArgs,
+
+    #[command(flatten)]
+    pub quiet: QuietArgs,
+
+    #[command(flatten)]
+    pub target: TargetArgs,
+
+    #[command(flatten)]
+    pub k8s: K8sArgs,
+
+    #[command(flatten)]
+    pub all_namespaces: AllNamespacesArgs,
+
+    #[command(subcommand)]
+    pub command: Option<Command>,
+}
+
+impl Args {
+    /// The mode that runs instead of the live monitor, named as on the
+    /// command line, or `None` for the monitor itself. Checked in the
+    /// order `main` dispatches them.
+    pub fn other_mode(&self) -> Option<&'static str> {
+        if let Some(command) = &self.command {
+            return Some(match command {
+                Command::Check(_) => "check",
+                Command::Compare(_) => "compare",
+                Command::Bench(_) => "bench",
+                Command::Diff(_) => "diff",
+                Command::Serve(_) => "serve",
+                Command::Baseline(_) => "baseline",
+                Command::Fleet(_) => "fleet",
+                Command::Agent(_) => "agent",
+                Command::Collector(_) => "collector",
+                Command::Server(_) => "server",
+            });
+        }
+        [
+            (self.tui.tui, "--tui"),
+            (self.once.once, "--once"),
+            (!self.sink.sinks.is_empty(), "--sink"),
+            (self.k8s.k8s, "--k8s"),
+            (self.all_namespaces.all_namespaces, "--all-namespaces"),
+            (self.replay.replay.is_some(), "--replay"),
+        ]
+        .into_iter()
+        .find(|(on, _)| *on)
+        .map(|(_, mode)| mode)
+    }
+}
+
+#[derive(Subcommand, Debug, Clone)]
+pub enum Command {
+    /// Grade each mount's health from a short sample and exit with a
+    /// Nagios-style status
+    Check(CheckArgs),
+
+    /// Sample two mounts side by side and compare them per operation
+    Compare(CompareArgs),
+
+    /// Generate load on a mount and report client and mountstats latency
+    Bench(BenchArgs),
+
+    /// Per-op deltas and rates between two saved mountstats files
+    Diff(DiffArgs),
+
+    /// Sample continuously and answer JSON queries over HTTP
+    Serve(ServeArgs),
+
+    /// Record a baseline or compare live intervals against one
+    Baseline(BaselineArgs),
+
+    /// Sample many hosts over SSH and show one table per NFS server
+    Fleet(FleetArgs),
+
+    /// Sample locally and push each interval to a collector
+    Agent(AgentArgs),
+
+    /// Receive intervals pushed by agents and serve the fleet's view
+    Collector(CollectorArgs),
+
+    /// Watch the local NFS server (knfsd) from /proc/net/rpc/nfsd
+    Server(ServerArgs),
+}
+
+/// Operation names from `--ops`; empty means every operation.
+pub fn parse_operations_filter(operations: Option<String>) -> HashSet<String> {
+    operations
+        .map(|ops| {
+            ops.split(',')
+                .map(str::trim)
+                .filter(|op| !op.is_empty())
+                .map(str::to_string)
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use clap::CommandFactory;
+    use std::time::Duration;
+
+    #[test]
+    fn test_command_definition() {
+        Args::command().debug_assert();
+    }
+
+    #[test]
+    fn test_global_flags_after_subcommand() {
+        let args = Args::try_parse_from(["nfs-gaze", "check", "-m", "/mnt/a"]).unwrap();
+        assert_eq!(args.mount_point, ["/mnt/a"]);
+        assert!(matches!(args.command, Some(Command::Check(_))));
+
+        let args =
+            Args::try_parse_from(["nfs-gaze", "baseline", "record", "b.json", "-m", "/mnt/a"])
+                .unwrap();
+        assert_eq!(args.mount_point, ["/mnt/a"]);
+        assert!(matches!(args.command, Some(Command::Baseline(_))));
+
+        let args =
+            Args::try_parse_from(["nfs-gaze", "fleet", "node01", "-m", "/mnt/a", "-i", "500ms"])
+                .unwrap();
+        assert_eq!(args.mount_point, ["/mnt/a"]);
+        match args.command {
+            Some(Command::Fleet(fleet)) => {
+                assert_eq!(fleet.interval.as_duration(), Duration::from_millis(500))
+            }
+            other => panic!("expected fleet, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_other_mode() {
+        let args = Args::try_parse_from(["nfs-gaze", "-m", "/mnt/a"]).unwrap();
+        assert_eq!(args.other_mode(), None);
+        let args = Args::try_parse_from(["nfs-gaze", "--once"]).unwrap();
+        assert_eq!(args.other_mode(), Some("--once"));
+        let args = Args::try_parse_from(["nfs-gaze", "--redact", "serve"]).unwrap();
+        assert_eq!(args.other_mode(), Some("serve"));
+    }
+
+    #[test]
+    fn test_agent_interval_units() {
+        let args =
+            Args::try_parse_from(["nfs-gaze", "agent", "--collector", "http://c", "-i", "2s"])
+                .unwrap();
+        match args.command {
+            Some(Command::Agent(agent)) => {
+                assert_eq!(agent.interval.as_duration(), Duration::from_secs(2))
+            }
+            other => panic!("expected agent, got {:?}", other),
+        }
+    }
+}
@@ -0,0 +1,282 @@
+//! `nfs-gaze collector`: receive intervals pushed by `nfs-gaze agent` and
+//! re-export the fleet's latest view as JSON and Prometheus metrics.
+//!
+//! Only each host's most recent interval is kept; hosts that stop pushing
+//! drop out after `--stale` seconds so dashboards do not show dead nodes
+//! as idle ones.
+
+use crate::exporter::{label_set, parse_listen, read_request, respond};
+use crate::labels::Labels;
+use crate::types::Result;
+use clap::Args;
+use serde_json::{json, Value};
+use std::collections::BTreeMap;
+use std::fmt::Write as _;
+use std::io;
+use std::net::{SocketAddr, TcpListener, TcpStream};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::thread;
+use std::time::{Duration, Instant};
+
+#[derive(Args, Debug, Clone)]
+pub struct CollectorArgs {
+    /// Address to accept pushes and queries on; use :9097 to accept
+    /// agents on every interface
+    #[arg(long = "listen", value_name = "ADDR", value_parser = parse_listen, default_value = "127.0.0.1:9097")]
+    pub listen: SocketAddr,
+
+    /// Forget hosts that have not pushed for this many seconds
+    #[arg(long = "stale", default_value = "300")]
+    pub stale: u64,
+}
+
+struct HostEntry {
+    received: Instant,
+    payload: Value,
+}
+
+#[derive(Default)]
+pub struct Collector {
+    hosts: BTreeMap<String, HostEntry>,
+}
+
+impl Collector {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Store a pushed interval, replacing the host's previous one.
+    pub fn accept(&mut self, payload: Value, now: Instant) -> std::result::Result<(), String> {
+        let host = payload["host"]
+            .as_str()
+            .filter(|h| !h.is_empty())
+            .ok_or("payload has no host")?
+            .to_string();
+        if !payload["mounts"].is_array() {
+            return Err("payload has no mounts array".to_string());
+        }
+        self.hosts.insert(
+            host,
+            HostEntry {
+                received: now,
+                payload,
+            },
+        );
+        Ok(())
+    }
+
+    pub fn expire(&mut self, now: Instant, stale: Duration) {
+        self.hosts
+            .retain(|_, entry| now.saturating_duration_since(entry.received) < stale);
+    }
+
+    pub fn hosts_json(&self, now: Instant) -> Value {
+        self.hosts
+            .iter()
+            .map(|(host, entry)| {
+                json!({
+                    "host": host,
+                    "timestamp": entry.payload["timestamp"],
+                    "mounts": entry.payload["mounts"].as_array().map_or(0, Vec::len),
+                    "age_secs": now.saturating_duration_since(entry.received).as_secs(),
+                })
+            })
+            .collect()
+    }
+
+    pub fn latest_json(&self) -> Value {
+        json!({
+            "hosts": self.hosts.values().map(|e| &e.payload).collect::<Vec<_>>(),
+        })
+    }
+}
+
+/// Gauges from the latest intervals; names end in the unit, as the
+/// exporter's counters do.
+const FLEET_METRICS: &[(&str, &str, &str)] = &[
+    ("nfs_fleet_operation_iops", "Operations per second", "iops"),
+    (
+        "nfs_fleet_operation_kb_per_second",
+        "Kilobytes per second",
+        "kb_per_sec",
+    ),
+    (
+        "nfs_fleet_operation_avg_rtt_milliseconds",
+        "Average RTT in the last interval",
+        "avg_rtt_ms",
+    ),
+    (
+        "nfs_fleet_operation_avg_execute_milliseconds",
+        "Average execute time in the last interval",
+        "avg_exec_ms",
+    ),
+    (
+        "nfs_fleet_operation_retrans",
+        "Retransmissions in the last interval",
+        "retrans",
+    ),
+    (
+        "nfs_fleet_operation_errors",
+        "Errors in the last interval",
+        "errors",
+    ),
+];
+
+/// Render every host's latest interval as Prometheus gauges. The pushing
+/// host is the `target` label, leaving `host` to the collector's own
+/// host metadata.
+pub fn render_fleet_metrics(collector: &Collector, labels: &Labels) -> String {
+    let mut out = String::new();
+    for (name, help, field) in FLEET_METRICS {
+        let _ = writeln!(out, "# HELP {} {}", name, help);
+        let _ = writeln!(out, "# TYPE {} gauge", name);
+        for (host, entry) in &collector.hosts {
+            for mount in entry.payload["mounts"].as_array().into_iter().flatten() {
+                let mount_point = mount["mount"]["mount_point"].as_str().unwrap_or("");
+                let server = mount["mount"]["server"].as_str().unwrap_or("");
+                for op in mount["operations"].as_array().into_iter().flatten() {
+                    let Some(value) = op[*field].as_f64() else {
+                        continue;
+                    };
+                    let set = label_set(
+                        &[
+                            ("target", host),
+                            ("mount_point", mount_point),
+                            ("server", server),
+                            ("operation", op["operation"].as_str().unwrap_or("")),
+                        ],
+                        labels,
+                    );
+                    let _ = writeln!(out, "{}{} {}", name, set, value);
+                }
+            }
+        }
+    }
+    out
+}
+
+fn handle(mut stream: TcpStream, collector: &Mutex<Collector>, labels: &Labels) -> io::Result<()> {
+    let request = read_request(&stream)?;
+    let now = Instant::now();
+    let Ok(mut collector) = collector.lock() else {
+        return respond(&mut stream, "500 Internal Server Error", "text/plain", "");
+    };
+    match (request.method.as_str(), request.target.as_str()) {
+        ("POST", "/push") => {
+            let accepted = serde_json::from_slice(&request.body)
+                .map_err(|e| e.to_string())
+                .and_then(|payload| collector.accept(payload, now));
+            match accepted {
+                Ok(()) => respond(&mut stream, "204 No Content", "text/plain", ""),
+                Err(e) => respond(
+                    &mut stream,
+                    "400 Bad Request",
+                    "application/json",
+                    &format!("{}\n", json!({ "error": e })),
+                ),
+            }
+        }
+        ("GET", "/hosts") => respond(
+            &mut stream,
+            "200 OK",
+            "application/json",
+            &format!("{}\n", collector.hosts_json(now)),
+        ),
+        ("GET", "/intervals/latest") => respond(
+            &mut stream,
+            "200 OK",
+            "application/json",
+            &format!("{}\n", collector.latest_json()),
+        ),
+        ("GET", "/metrics") => respond(
+            &mut stream,
+            "200 OK",
+            "text/plain; version=0.0.4",
+            &render_fleet_metrics(&collector, labels),
+        ),
+        _ => respond(&mut stream, "404 Not Found", "text/plain", "not found\n"),
+    }
+}
+
+/// Accept pushes and queries until `running` is cleared.
+pub fn run_collector(args: &CollectorArgs, labels: &Labels, running: &AtomicBool) -> Result<()> {
+    let listener = TcpListener::bind(args.listen)?;
+    listener.set_nonblocking(true)?;
+    let collector = Mutex::new(Collector::new());
+    let stale = Duration::from_secs(args.stale.max(1));
+    while running.load(Ordering::SeqCst) {
+        match listener.accept() {
+            Ok((stream, _)) => {
+                stream.set_nonblocking(false)?;
+                if let Ok(mut c) = collector.lock() {
+                    c.expire(Instant::now(), stale);
+                }
+                if let Err(e) = handle(stream, &collector, labels) {
+                    eprintln!("Warning: collector request failed: {}", e);
+                }
+            }
+            Err(e) if e.kind() == io::ErrorKind::WouldBlock => {
+                thread::sleep(Duration::from_millis(100));
+            }
+            Err(e) => return Err(e.into()),
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn payload(host: &str) -> Value {
+        json!({
+            "host": host,
+            "timestamp": "2024-05-01T00:00:00.000Z",
+            "interval_secs": 10.0,
+            "mounts": [{
+                "mount": {"mount_point": "/mnt", "server": "filer"},
+                "operations": [{"operation": "READ", "iops": 12.5, "avg_rtt_ms": 2.0}],
+            }],
+        })
+    }
+
+    #[test]
+    fn test_accept_and_expire() {
+        let start = Instant::now();
+        let mut collector = Collector::new();
+        collector.accept(payload("node01"), start).unwrap();
+        collector
+            .accept(payload("node02"), start + Duration::from_secs(200))
+            .unwrap();
+        assert!(collector.accept(json!({"mounts": []}), start).is_err());
+        assert!(collector.accept(json!({"host": "x"}), start).is_err());
+
+        let hosts = collector.hosts_json(start + Duration::from_secs(250));
+        assert_eq!(hosts[0]["host"], "node01");
+        assert_eq!(hosts[0]["mounts"], 1);
+        assert_eq!(hosts[0]["age_secs"], 250);
+
+        collector.expire(start + Duration::from_secs(350), Duration::from_secs(300));
+        assert_eq!(
+            collector.latest_json()["hosts"].as_array().unwrap().len(),
+            1
+        );
+        assert_eq!(collector.latest_json()["hosts"][0]["host"], "node02");
+    }
+
+    #[test]
+    fn test_render_fleet_metrics() {
+        let mut collector = Collector::new();
+        collector.accept(payload("node01"), Instant::now()).unwrap();
+        let mut labels = Labels::new();
+        labels.add("cluster=hpc").unwrap();
+        labels.add("host=collector01").unwrap();
+        let text = render_fleet_metrics(&collector, &labels);
+        assert!(text.contains("# TYPE nfs_fleet_operation_iops gauge\n"));
+        assert!(text.contains(
+            "nfs_fleet_operation_iops{target=\"node01\",mount_point=\"/mnt\",server=\"filer\",operation=\"READ\",cluster=\"hpc\",host=\"collector01\"} 12.5\n"
+        ));
+        assert!(!text.contains("nfs_fleet_operation_errors{"));
+    }
+}
@@ -0,0 +1,289 @@
+//! User-selectable table columns (`--cols`).
+//!
+//! Column names are shared with `--preset` and `--sort`, so
+//! `--cols iops,rtt --sort rtt` reads the same way a preset is written.
+
+use crate::presets::Preset;
+use crate::types::DeltaStats;
+use crate::units::{format_kb_rate, format_ops};
+use clap::Args;
+use std::io::{self, Write};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Column {
+    /// Operations completed in the interval
+    Ops,
+    Iops,
+    /// Bandwidth, shown as MB/s
+    KbS,
+    KbOp,
+    Rtt,
+    Exec,
+    Queue,
+    Retrans,
+    Errors,
+}
+
+/// The simple display: IOPS, latency and errors.
+pub const DEFAULT_COLUMNS: &[Column] = &[Column::Iops, Column::Rtt, Column::Exec, Column::Errors];
+
+/// The simple display with `--bw`.
+pub const BANDWIDTH_COLUMNS: &[Column] = &[
+    Column::Iops,
+    Column::KbS,
+    Column::KbOp,
+    Column::Rtt,
+    Column::Exec,
+    Column::Errors,
+];
+
+impl Column {
+    pub const ALL: [Column; 9] = [
+        Column::Ops,
+        Column::Iops,
+        Column::KbS,
+        Column::KbOp,
+        Column::Rtt,
+        Column::Exec,
+        Column::Queue,
+        Column::Retrans,
+        Column::Errors,
+    ];
+
+    /// Canonical name, as accepted by `--cols`.
+    pub fn name(self) -> &'static str {
+        match self {
+            Column::Ops => "ops",
+            Column::Iops => "iops",
+            Column::KbS => "kb_s",
+            Column::KbOp => "kb_op",
+            Column::Rtt => "rtt",
+            Column::Exec => "exec",
+            Column::Queue => "queue",
+            Column::Retrans => "retrans",
+            Column::Errors => "errors",
+        }
+    }
+
+    /// Look up a column by name or common alias.
+    pub fn from_name(name: &str) -> Option<Self> {
+        let name = name.trim().to_ascii_lowercase();
+        let alias = match name.as_str() {
+            "kbps" | "bw" | "mbps" => "kb_s",
+            "kbop" | "kb/op" => "kb_op",
+            "exe" => "exec",
+            other => other,
+        };
+        Self::ALL.into_iter().find(|c| c.name() == alias)
+    }
+
+    pub(crate) fn header(self) -> &'static str {
+        match self {
+            Column::Ops => "OPS",
+            Column::Iops => "IOPS",
+            Column::KbS => "MB/s",
+            Column::KbOp => "KB/op",
+            Column::Rtt => "RTT(ms)",
+            Column::Exec => "EXE(ms)",
+            Column::Queue => "QUE(ms)",
+            Column::Retrans => "RETRANS",
+            Column::Errors => "ERRORS",
+        }
+    }
+
+    pub(crate) fn value(self, stat: &DeltaStats, human: bool) -> String {
+        match self {
+            Column::Ops => stat.delta_ops.to_string(),
+            Column::Iops => format_ops(stat.iops, human),
+            Column::KbS => format_kb_rate(stat.kb_per_sec, human),
+            Column::KbOp => format!("{:.2}", stat.kb_per_op),
+            Column::Rtt => format!("{:.2}", stat.avg_rtt),
+            Column::Exec => format!("{:.2}", stat.avg_exec),
+            Column::Queue => format!("{:.2}", stat.avg_queue),
+            Column::Retrans => stat.delta_retrans.to_string(),
+            Column::Errors => stat.delta_errors.to_string(),
+        }
+    }
+
+    /// The column's raw value, for plotting. Bandwidth is in KB/s.
+    pub fn metric(self, stat: &DeltaStats) -> f64 {
+        match self {
+            Column::Ops => stat.delta_ops as f64,
+            Column::Iops => stat.iops,
+            Column::KbS => stat.kb_per_sec,
+            Column::KbOp => stat.kb_per_op,
+            Column::Rtt => stat.avg_rtt,
+            Column::Exec => stat.avg_exec,
+            Column::Queue => stat.avg_queue,
+            Column::Retrans => stat.delta_retrans as f64,
+            Column::Errors => stat.delta_errors as f64,
+        }
+    }
+}
+
+pub fn parse_column(s: &str) -> std::result::Result<Column, String> {
+    Column::from_name(s).ok_or_else(|| {
+        let names: Vec<&str> = Column::ALL.iter().map(|c| c.name()).collect();
+        format!(
+            "unknown column '{}' (expected one of: {})",
+            s,
+            names.join(", ")
+        )
+    })
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct ColumnArgs {
+    /// Comma-separated columns to show, e.g. iops,rtt,exec,queue,retrans,errors,kbps
+    #[arg(long = "cols", value_delimiter = ',', value_parser = parse_column)]
+    pub cols: Vec<Column>,
+
+    /// Extended simple display: add queue time and retransmissions
+    #[arg(short = 'x', long = "extended")]
+    pub extended: bool,
+}
+
+/// Insert queue time and retransmissions ahead of the errors column.
+fn extend(columns: &mut Vec<Column>) {
+    let at = columns
+        .iter()
+        .position(|&c| c == Column::Errors)
+        .unwrap_or(columns.len());
+    for column in [Column::Queue, Column::Retrans].into_iter().rev() {
+        if !columns.contains(&column) {
+            columns.insert(at, column);
+        }
+    }
+    if !columns.contains(&Column::Errors) {
+        columns.push(Column::Errors);
+    }
+}
+
+/// Columns to show: `--cols` if given, else the preset's, else the
+/// simple layout (with bandwidth columns if `bandwidth`). `-x` adds
+/// queue, retrans and errors to the preset or simple layout, but never
+/// overrides an explicit `--cols`.
+pub fn columns(args: &ColumnArgs, preset: Option<Preset>, bandwidth: bool) -> Vec<Column> {
+    if !args.cols.is_empty() {
+        return args.cols.clone();
+    }
+    let mut columns: Vec<Column> = match preset {
+        Some(preset) => preset
+            .settings()
+            .columns
+            .iter()
+            .filter_map(|name| Column::from_name(name))
+            .collect(),
+        None if bandwidth => BANDWIDTH_COLUMNS.to_vec(),
+        None => DEFAULT_COLUMNS.to_vec(),
+    };
+    if args.extended {
+        extend(&mut columns);
+    }
+    columns
+}
+
+pub fn display_columns<W: Write>(
+    writer: &mut W,
+    stats: &[DeltaStats],
+    columns: &[Column],
+    human: bool,
+) -> io::Result<()> {
+    if stats.is_empty() {
+        return Ok(());
+    }
+    write!(writer, "{:<14}", "OP")?;
+    for column in columns {
+        // Scaled bandwidth carries its own unit.
+        let header = match column {
+            Column::KbS if human => "BW",
+            _ => column.header(),
+        };
+        write!(writer, " {:>10}", header)?;
+    }
+    writeln!(writer)?;
+    writeln!(writer, "{}", "-".repeat(14 + 11 * columns.len()))?;
+    for stat in stats {
+        write!(writer, "{:<14}", stat.operation)?;
+        for column in columns {
+            write!(writer, " {:>10}", column.value(stat, human))?;
+        }
+        writeln!(writer)?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use clap::ValueEnum;
+
+    fn args(cols: &[Column], extended: bool) -> ColumnArgs {
+        ColumnArgs {
+            cols: cols.to_vec(),
+            extended,
+        }
+    }
+
+    #[test]
+    fn test_parse_columns() {
+        assert_eq!(parse_column("kbps"), Ok(Column::KbS));
+        assert_eq!(parse_column("RTT"), Ok(Column::Rtt));
+        assert!(parse_column("latency")
+            .unwrap_err()
+            .contains("expected one of"));
+
+        // Every preset must name only real columns.
+        for preset in Preset::value_variants() {
+            let names = preset.settings().columns;
+            assert_eq!(
+                columns(&args(&[], false), Some(*preset), false).len(),
+                names.len()
+            );
+        }
+        assert_eq!(
+            columns(&args(&[Column::Queue], true), Some(Preset::Latency), true),
+            vec![Column::Queue]
+        );
+        assert_eq!(columns(&args(&[], false), None, true), BANDWIDTH_COLUMNS);
+    }
+
+    #[test]
+    fn test_extended_columns() {
+        assert_eq!(
+            columns(&args(&[], true), None, false),
+            vec![
+                Column::Iops,
+                Column::Rtt,
+                Column::Exec,
+                Column::Queue,
+                Column::Retrans,
+                Column::Errors
+            ]
+        );
+        // The latency preset already shows queue and retrans.
+        let latency = columns(&args(&[], true), Some(Preset::Latency), false);
+        assert_eq!(latency.last(), Some(&Column::Errors));
+        assert_eq!(latency.len(), 6);
+    }
+
+    #[test]
+    fn test_display_columns() {
+        let mut read = stat("READ", 100, 1.5);
+        read.delta_retrans = 2;
+        let mut out = Vec::new();
+        display_columns(&mut out, &[read], &[Column::Iops, Column::Retrans], false).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(
+            lines[0].split_whitespace().collect::<Vec<_>>(),
+            ["OP", "IOPS", "RETRANS"]
+        );
+        assert_eq!(
+            lines[2].split_whitespace().collect::<Vec<_>>(),
+            ["READ", "100.0", "2"]
+        );
+    }
+}
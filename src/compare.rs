@@ -0,0 +1,240 @@
+//! `nfs-gaze compare A B`: sample two mounts side by side and compare them
+//! per operation at the end of the run.
+
+use crate::delta::mount_delta;
+use crate::monitor::sleep_until;
+use crate::parser::parse_mountstats;
+use crate::session::{OpTotals, Session};
+use crate::types::{NFSMount, NfsGazeError, Result};
+use chrono::Utc;
+use clap::Args;
+use std::collections::BTreeSet;
+use std::io::{self, Write};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant};
+
+#[derive(Args, Debug, Clone)]
+pub struct CompareArgs {
+    /// First mount point
+    pub mount_a: String,
+
+    /// Second mount point
+    pub mount_b: String,
+
+    /// Total sampling time in seconds
+    #[arg(long = "duration", default_value = "60")]
+    pub duration: u64,
+
+    /// Seconds between samples
+    #[arg(short = 'i', long = "interval", default_value = "1")]
+    pub interval: u64,
+}
+
+/// One side's means for an operation.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Side {
+    pub ops: i64,
+    pub iops: f64,
+    pub avg_rtt: f64,
+    pub avg_exec: f64,
+    pub kb_per_op: f64,
+}
+
+impl Side {
+    fn from_totals(totals: Option<&OpTotals>, elapsed_secs: f64) -> Self {
+        let Some(t) = totals else {
+            return Self::default();
+        };
+        Side {
+            ops: t.ops,
+            iops: if elapsed_secs > 0.0 {
+                t.ops as f64 / elapsed_secs
+            } else {
+                0.0
+            },
+            avg_rtt: t.avg_rtt(),
+            avg_exec: t.avg_exec(),
+            kb_per_op: t.kb_per_op(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct OpComparison {
+    pub operation: String,
+    pub a: Side,
+    pub b: Side,
+}
+
+/// Percentage difference of `b` relative to `a`; `None` when `a` is zero.
+pub fn pct_diff(a: f64, b: f64) -> Option<f64> {
+    (a != 0.0).then(|| (b - a) * 100.0 / a)
+}
+
+/// Per-op comparison of two mounts recorded in `session`, for operations
+/// either side actually used.
+pub fn compare(session: &Session, mount_a: &str, mount_b: &str) -> Vec<OpComparison> {
+    let (a, b) = (session.mounts.get(mount_a), session.mounts.get(mount_b));
+    let names: BTreeSet<&String> = a
+        .iter()
+        .chain(b.iter())
+        .flat_map(|m| m.ops.iter())
+        .filter(|(_, t)| t.ops > 0)
+        .map(|(name, _)| name)
+        .collect();
+
+    names
+        .into_iter()
+        .map(|name| OpComparison {
+            operation: name.clone(),
+            a: Side::from_totals(
+                a.and_then(|m| m.ops.get(name)),
+                a.map_or(0.0, |m| m.elapsed_secs),
+            ),
+            b: Side::from_totals(
+                b.and_then(|m| m.ops.get(name)),
+                b.map_or(0.0, |m| m.elapsed_secs),
+            ),
+        })
+        .collect()
+}
+
+/// Sample both mounts until `args.duration` elapses or `running` is
+/// cleared, returning the recorded session.
+pub fn run_compare(path: &str, args: &CompareArgs, running: &AtomicBool) -> Result<Session> {
+    let pick = |mounts: &[NFSMount], mount_point: &str| {
+        mounts
+            .iter()
+            .find(|m| m.mount_point == mount_point)
+            .cloned()
+            .ok_or_else(|| NfsGazeError::MountNotFound(mount_point.to_string()))
+    };
+
+    let initial = parse_mountstats(path)?;
+    let mut prev_a = pick(&initial, &args.mount_a)?;
+    let mut prev_b = pick(&initial, &args.mount_b)?;
+
+    let mut session = Session::new(Utc::now());
+    let interval = Duration::from_secs(args.interval.max(1));
+    let deadline = Instant::now() + Duration::from_secs(args.duration);
+    let mut last = Instant::now();
+
+    while running.load(Ordering::SeqCst) && Instant::now() < deadline {
+        sleep_until(last + interval, running);
+        if !running.load(Ordering::SeqCst) {
+            break;
+        }
+        let mounts = parse_mountstats(path)?;
+        let (cur_a, cur_b) = (pick(&mounts, &args.mount_a)?, pick(&mounts, &args.mount_b)?);
+        let secs = last.elapsed().as_secs_f64();
+        last = Instant::now();
+
+        let now = Utc::now();
+        session.record(
+            &args.mount_a,
+            now,
+            secs,
+            &mount_delta(&prev_a, &cur_a, secs),
+        );
+        session.record(
+            &args.mount_b,
+            now,
+            secs,
+            &mount_delta(&prev_b, &cur_b, secs),
+        );
+        prev_a = cur_a;
+        prev_b = cur_b;
+    }
+    Ok(session)
+}
+
+pub fn display_comparison<W: Write>(
+    writer: &mut W,
+    mount_a: &str,
+    mount_b: &str,
+    rows: &[OpComparison],
+) -> io::Result<()> {
+    writeln!(writer, "A: {}", mount_a)?;
+    writeln!(writer, "B: {}", mount_b)?;
+    writeln!(writer)?;
+    if rows.is_empty() {
+        writeln!(writer, "No operations recorded on either mount")?;
+        return Ok(());
+    }
+
+    writeln!(
+        writer,
+        "{:<14} {:<10} {:>12} {:>12} {:>12} {:>9}",
+        "OP", "METRIC", "A", "B", "DELTA", "DIFF%"
+    )?;
+    writeln!(writer, "{}", "-".repeat(74))?;
+    for row in rows {
+        let metrics = [
+            ("ops/s", row.a.iops, row.b.iops),
+            ("rtt ms", row.a.avg_rtt, row.b.avg_rtt),
+            ("exec ms", row.a.avg_exec, row.b.avg_exec),
+            ("KB/op", row.a.kb_per_op, row.b.kb_per_op),
+        ];
+        for (i, (metric, a, b)) in metrics.into_iter().enumerate() {
+            let diff = pct_diff(a, b).map_or_else(|| "-".to_string(), |p| format!("{:+.1}", p));
+            writeln!(
+                writer,
+                "{:<14} {:<10} {:>12.2} {:>12.2} {:>+12.2} {:>9}",
+                if i == 0 { row.operation.as_str() } else { "" },
+                metric,
+                a,
+                b,
+                b - a,
+                diff
+            )?;
+        }
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_pct_diff() {
+        assert_eq!(pct_diff(2.0, 3.0), Some(50.0));
+        assert_eq!(pct_diff(4.0, 2.0), Some(-50.0));
+        assert_eq!(pct_diff(0.0, 1.0), None);
+    }
+
+    #[test]
+    fn test_compare() {
+        let now = Utc::now();
+        let mut session = Session::new(now);
+        session.record(
+            "/a",
+            now,
+            1.0,
+            &[stat("READ", 100, 2.0), stat("GETATTR", 0, 0.0)],
+        );
+        session.record(
+            "/b",
+            now,
+            1.0,
+            &[stat("READ", 150, 3.0), stat("WRITE", 10, 1.0)],
+        );
+
+        let rows = compare(&session, "/a", "/b");
+        let names: Vec<&str> = rows.iter().map(|r| r.operation.as_str()).collect();
+        assert_eq!(names, ["READ", "WRITE"]);
+
+        let read = &rows[0];
+        assert!((read.a.iops - 100.0).abs() < 1e-9);
+        assert!((read.b.avg_rtt - 3.0).abs() < 1e-9);
+        assert_eq!(rows[1].a.ops, 0);
+
+        let mut out = Vec::new();
+        display_comparison(&mut out, "/a", "/b", &rows).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let rtt_line = text.lines().find(|l| l.contains("rtt ms")).unwrap();
+        assert!(rtt_line.trim_end().ends_with("+50.0"));
+    }
+}
@@ -0,0 +1,325 @@
+//! `--config FILE`: settings from a TOML file.
+//!
+//! Top-level keys are long flag names without the dashes and take the
+//! same values. They become the flags' defaults, so a flag given on the
+//! command line wins over the file.
+//! Settings that do not fit on a command line live in tables:
+//!
+//! ```toml
+//! interval = 5
+//! ops = "READ,WRITE,GETATTR"
+//! bw = true
+//! label = ["env=prod", "team=storage"]
+//!
+//! [thresholds]
+//! rtt_ms = 50.0
+//! retrans_pct = 1.0
+//!
+//! [[sinks]]
+//! kind = "graphite"
+//! target = "graphite.example.com:2003"
+//!
+//! [groups]
+//! scratch = ["/mnt/scratch*", "/mnt/tmp*"]
+//! ```
+
+use crate::selection::parse_glob;
+use crate::types::{NfsGazeError, Result};
+use clap::builder::Str;
+use clap::Args;
+use regex::Regex;
+use serde::Deserialize;
+use std::collections::BTreeMap;
+use std::fs;
+
+#[derive(Args, Debug, Clone)]
+pub struct ConfigArgs {
+    /// Read settings from a TOML file; command-line flags take precedence
+    #[arg(long = "config", value_name = "FILE")]
+    pub config: Option<String>,
+
+    /// Monitor the mounts in a [groups] entry of the config file
+    #[arg(long = "group", value_name = "NAME")]
+    pub group: Vec<String>,
+
+    /// The file's `[thresholds]`, filled in once it has been read.
+    #[arg(skip)]
+    pub thresholds: Thresholds,
+}
+
+/// Alerting thresholds; unset values are not checked.
+#[derive(Debug, Clone, Default, PartialEq, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Thresholds {
+    pub rtt_ms: Option<f64>,
+    pub exec_ms: Option<f64>,
+    pub retrans_pct: Option<f64>,
+    pub errors: Option<i64>,
+}
+
+/// An output destination. Each kind corresponds to the flag of the same
+/// name, so a sink is shorthand for `--<kind> <target>`.
+#[derive(Debug, Clone, PartialEq, Eq, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Sink {
+    pub kind: String,
+    pub target: String,
+}
+
+const SINK_KINDS: &[&str] = &[
+    "graphite",
+    "statsd",
+    "parquet",
+    "record",
+    "wide-events",
+    "zabbix-sender",
+    "output",
+];
+
+#[derive(Debug, Clone, Default, Deserialize)]
+pub struct Config {
+    #[serde(default)]
+    pub thresholds: Thresholds,
+    #[serde(default)]
+    pub sinks: Vec<Sink>,
+    /// Named sets of mount point globs.
+    #[serde(default)]
+    pub groups: BTreeMap<String, Vec<String>>,
+    /// Everything else: flag values by long name.
+    #[serde(flatten)]
+    pub flags: toml::Table,
+}
+
+fn config_error(msg: String) -> NfsGazeError {
+    NfsGazeError::ParseError(format!("config: {}", msg))
+}
+
+impl Config {
+    pub fn parse(contents: &str) -> Result<Self> {
+        let config: Config = toml::from_str(contents).map_err(|e| config_error(e.to_string()))?;
+        if let Some(sink) = config
+            .sinks
+            .iter()
+            .find(|s| !SINK_KINDS.contains(&s.kind.as_str()))
+        {
+            return Err(config_error(format!(
+                "unknown sink kind '{}' (expected one of: {})",
+                sink.kind,
+                SINK_KINDS.join(", ")
+            )));
+        }
+        Ok(config)
+    }
+
+    pub fn load(path: &str) -> Result<Self> {
+        Self::parse(&fs::read_to_string(path)?)
+    }
+
+    /// Mount patterns for the named groups.
+    pub fn group_patterns<S: AsRef<str>>(&self, names: &[S]) -> Result<Vec<Regex>> {
+        let mut patterns = Vec::new();
+        for name in names {
+            let name = name.as_ref();
+            let globs = self
+                .groups
+                .get(name)
+                .ok_or_else(|| config_error(format!("no mount group '{}'", name)))?;
+            for glob in globs {
+                patterns.push(parse_glob(glob).map_err(config_error)?);
+            }
+        }
+        Ok(patterns)
+    }
+
+    /// Values for every flag and sink in the file, by long name. Checked
+    /// against `command` so a typo in the file is reported rather than
+    /// ignored.
+    pub fn settings(&self, command: &clap::Command) -> Result<Settings> {
+        let mut settings: Settings = Vec::new();
+        for (key, value) in &self.flags {
+            if command
+                .get_arguments()
+                .all(|a| a.get_long() != Some(key.as_str()))
+            {
+                return Err(config_error(format!("unknown setting '{}'", key)));
+            }
+            let values = match value {
+                toml::Value::Boolean(b) => vec![b.to_string()],
+                toml::Value::String(s) => vec![s.clone()],
+                toml::Value::Integer(i) => vec![i.to_string()],
+                toml::Value::Float(f) => vec![f.to_string()],
+                toml::Value::Array(items) => items
+                    .iter()
+                    .map(|item| match item {
+                        toml::Value::String(s) => Ok(s.clone()),
+                        toml::Value::Integer(_) | toml::Value::Float(_) => Ok(item.to_string()),
+                        _ => Err(config_error(format!("'{}' must be a list of values", key))),
+                    })
+                    .collect::<Result<_>>()?,
+                _ => return Err(config_error(format!("'{}' has an unsupported value", key))),
+            };
+            settings.push((key.clone(), values));
+        }
+        for sink in &self.sinks {
+            match settings.iter_mut().find(|(long, _)| *long == sink.kind) {
+                Some((_, values)) => values.push(sink.target.clone()),
+                None => settings.push((sink.kind.clone(), vec![sink.target.clone()])),
+            }
+        }
+        Ok(settings)
+    }
+}
+
+/// Flag values as `(long name, values)`; a switch takes `true` or `false`.
+pub type Settings = Vec<(String, Vec<String>)>;
+
+/// `command` with each setting as the default of its flag, so a flag on
+/// the command line still wins and `ArgMatches::value_source` tells the
+/// two apart. Where a flag is set more than once the first setting wins.
+pub fn with_defaults(mut command: clap::Command, settings: &Settings) -> clap::Command {
+    let mut seen = Vec::new();
+    for (long, values) in settings {
+        let id = command
+            .get_arguments()
+            .find(|a| a.get_long() == Some(long.as_str()))
+            .map(|a| a.get_id().clone());
+        let Some(id) = id.filter(|id| !seen.contains(id)) else {
+            continue;
+        };
+        let values: Vec<Str> = values.iter().cloned().map(Str::from).collect();
+        command = command.mut_arg(&id, |arg| arg.default_values(values));
+        seen.push(id);
+    }
+    command
+}
+
+/// The `--config` path from raw arguments, before full parsing, so the
+/// file can be merged in first.
+pub fn config_path(argv: &[String]) -> Option<String> {
+    let mut args = argv.iter();
+    while let Some(arg) = args.next() {
+        if arg == "--config" {
+            return args.next().cloned();
+        }
+        if let Some(path) = arg.strip_prefix("--config=") {
+            return Some(path.to_string());
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use clap::parser::ValueSource;
+    use clap::{CommandFactory, FromArgMatches, Parser};
+
+    #[derive(Parser, Debug)]
+    struct TestArgs {
+        #[arg(short = 'i', long = "interval", default_value = "1")]
+        interval: u64,
+        #[arg(long = "ops")]
+        ops: Option<String>,
+        #[arg(long = "bw")]
+        bw: bool,
+        #[arg(short = 'x', long = "extended")]
+        extended: bool,
+        #[arg(long = "label")]
+        label: Vec<String>,
+        #[arg(long = "graphite")]
+        graphite: Option<String>,
+        #[command(flatten)]
+        config: ConfigArgs,
+    }
+
+    const CONFIG: &str = r#"
+interval = 5
+ops = "READ,WRITE"
+bw = true
+extended = false
+label = ["env=prod", "team=storage"]
+
+[thresholds]
+rtt_ms = 50.0
+
+[[sinks]]
+kind = "graphite"
+target = "graphite:2003"
+
+[groups]
+scratch = ["/mnt/scratch*"]
+"#;
+
+    fn parse(config: &Config, argv: &[&str]) -> (TestArgs, clap::ArgMatches) {
+        let command = TestArgs::command();
+        let settings = config.settings(&command).unwrap();
+        let matches = with_defaults(command, &settings).get_matches_from(argv);
+        (TestArgs::from_arg_matches(&matches).unwrap(), matches)
+    }
+
+    #[test]
+    fn test_with_defaults() {
+        let config = Config::parse(CONFIG).unwrap();
+        assert_eq!(config.thresholds.rtt_ms, Some(50.0));
+
+        let (args, matches) = parse(&config, &["nfs-gaze"]);
+        assert_eq!(args.interval, 5);
+        assert_eq!(args.ops.as_deref(), Some("READ,WRITE"));
+        assert!(args.bw);
+        assert!(!args.extended);
+        assert_eq!(args.label, vec!["env=prod", "team=storage"]);
+        assert_eq!(args.graphite.as_deref(), Some("graphite:2003"));
+        assert_eq!(
+            matches.value_source("interval"),
+            Some(ValueSource::DefaultValue)
+        );
+
+        // Flags on the command line win, in long or short form.
+        let (args, matches) = parse(
+            &config,
+            &[
+                "nfs-gaze",
+                "-xi",
+                "2",
+                "--ops=GETATTR",
+                "--label",
+                "env=dev",
+            ],
+        );
+        assert_eq!(args.interval, 2);
+        assert!(args.extended);
+        assert_eq!(args.ops.as_deref(), Some("GETATTR"));
+        assert_eq!(args.label, vec!["env=dev"]);
+        assert_eq!(
+            matches.value_source("interval"),
+            Some(ValueSource::CommandLine)
+        );
+    }
+
+    #[test]
+    fn test_config_errors() {
+        let command = TestArgs::command();
+        let typo = Config::parse("intervall = 5").unwrap();
+        assert!(typo.settings(&command).is_err());
+        assert!(Config::parse("[[sinks]]\nkind = \"carrier-pigeon\"\ntarget = \"x\"").is_err());
+        assert!(Config::parse("[thresholds]\nrtt = 5").is_err());
+
+        let config = Config::parse(CONFIG).unwrap();
+        let patterns = config.group_patterns(&["scratch"]).unwrap();
+        assert!(patterns[0].is_match("/mnt/scratch01"));
+        assert!(config.group_patterns(&["missing"]).is_err());
+
+        assert_eq!(
+            config_path(&argv(&["nfs-gaze", "--config", "/etc/nfs-gaze.toml"])).as_deref(),
+            Some("/etc/nfs-gaze.toml")
+        );
+        assert_eq!(
+            config_path(&argv(&["nfs-gaze", "--config=a.toml"])).as_deref(),
+            Some("a.toml")
+        );
+    }
+
+    fn argv(args: &[&str]) -> Vec<String> {
+        args.iter().map(|s| s.to_string()).collect()
+    }
+}
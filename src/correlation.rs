@@ -0,0 +1,164 @@
+//! Pearson correlation between per-interval series, to point at likely
+//! causes (retransmissions driving RTT, load driving server time).
+
+use crate::session::{IntervalSample, MountSession};
+use std::io::{self, Write};
+
+/// Fewer intervals than this and r is mostly noise.
+const MIN_SAMPLES: usize = 5;
+const STRONG: f64 = 0.7;
+const MODERATE: f64 = 0.4;
+
+type Field = fn(&IntervalSample) -> f64;
+
+/// Pairs worth checking, with the explanation for a positive relationship.
+const PAIRS: &[(&str, Field, &str, Field, &str)] = &[
+    (
+        "retrans",
+        |s| s.retrans,
+        "avg RTT",
+        |s| s.avg_rtt,
+        "latency rises with retransmissions; suspect the network path",
+    ),
+    (
+        "IOPS",
+        |s| s.iops,
+        "avg exec",
+        |s| s.avg_exec,
+        "execution time rises with load; the server or client queue is saturating",
+    ),
+    (
+        "IOPS",
+        |s| s.iops,
+        "avg RTT",
+        |s| s.avg_rtt,
+        "server response time rises with load; the server is the bottleneck",
+    ),
+];
+
+pub fn pearson(xs: &[f64], ys: &[f64]) -> Option<f64> {
+    let n = xs.len().min(ys.len());
+    if n < 2 {
+        return None;
+    }
+    let (xs, ys) = (&xs[..n], &ys[..n]);
+    let mean = |v: &[f64]| v.iter().sum::<f64>() / n as f64;
+    let (mx, my) = (mean(xs), mean(ys));
+
+    let (mut cov, mut vx, mut vy) = (0.0, 0.0, 0.0);
+    for (x, y) in xs.iter().zip(ys) {
+        cov += (x - mx) * (y - my);
+        vx += (x - mx).powi(2);
+        vy += (y - my).powi(2);
+    }
+    if vx == 0.0 || vy == 0.0 {
+        return None;
+    }
+    Some(cov / (vx * vy).sqrt())
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Correlation {
+    pub x: &'static str,
+    pub y: &'static str,
+    pub r: f64,
+    pub explanation: &'static str,
+}
+
+impl Correlation {
+    pub fn strength(&self) -> &'static str {
+        if self.r.abs() >= STRONG {
+            "strong"
+        } else {
+            "moderate"
+        }
+    }
+}
+
+/// Moderate or stronger positive relationships for one mount, strongest
+/// first. Negative correlations are dropped; none of the pairs has a
+/// useful interpretation in that direction.
+pub fn correlations(mount: &MountSession) -> Vec<Correlation> {
+    if mount.samples.len() < MIN_SAMPLES {
+        return Vec::new();
+    }
+    let mut found: Vec<Correlation> = PAIRS
+        .iter()
+        .filter_map(|&(x, fx, y, fy, explanation)| {
+            let r = pearson(&mount.series(fx), &mount.series(fy))?;
+            (r >= MODERATE).then_some(Correlation {
+                x,
+                y,
+                r,
+                explanation,
+            })
+        })
+        .collect();
+    found.sort_by(|a, b| b.r.total_cmp(&a.r));
+    found
+}
+
+pub fn display_correlations<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    found: &[Correlation],
+) -> io::Result<()> {
+    for c in found {
+        writeln!(
+            writer,
+            "{}: {} {} vs {} (r={:.2}): {}",
+            mount_point,
+            c.strength(),
+            c.x,
+            c.y,
+            c.r,
+            c.explanation
+        )?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pearson() {
+        let xs = [1.0, 2.0, 3.0, 4.0];
+        assert!((pearson(&xs, &[2.0, 4.0, 6.0, 8.0]).unwrap() - 1.0).abs() < 1e-9);
+        assert!((pearson(&xs, &[8.0, 6.0, 4.0, 2.0]).unwrap() + 1.0).abs() < 1e-9);
+        assert_eq!(pearson(&xs, &[1.0, 1.0, 1.0, 1.0]), None);
+        assert_eq!(pearson(&[1.0], &[1.0]), None);
+    }
+
+    #[test]
+    fn test_correlations() {
+        let mut mount = MountSession {
+            mount_point: "/mnt".to_string(),
+            ..Default::default()
+        };
+        for i in 0..6 {
+            let retrans = (i % 2) as f64 * 10.0;
+            mount.samples.push(IntervalSample {
+                iops: 100.0 + i as f64,
+                retrans,
+                avg_rtt: 1.0 + retrans,
+                avg_exec: 2.0,
+                worst_rtt: 0.0,
+            });
+        }
+        let found = correlations(&mount);
+        assert_eq!(found.len(), 1);
+        assert_eq!(found[0].x, "retrans");
+        assert_eq!(found[0].strength(), "strong");
+
+        let mut out = Vec::new();
+        display_correlations(&mut out, "/mnt", &found).unwrap();
+        assert!(String::from_utf8(out)
+            .unwrap()
+            .starts_with("/mnt: strong retrans vs avg RTT (r=1.00)"));
+
+        mount.samples.truncate(MIN_SAMPLES - 1);
+        assert!(correlations(&mount).is_empty());
+    }
+}
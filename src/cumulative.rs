@@ -0,0 +1,89 @@
+//! Interval and since-start values side by side, so totals accumulate
+//! visibly during a capture. Running totals come from the Session
+//! accumulator.
+
+use crate::session::MountSession;
+use crate::types::DeltaStats;
+use clap::Args;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct CumulativeArgs {
+    /// Show since-start cumulative values next to each interval value
+    #[arg(long = "cumulative")]
+    pub cumulative: bool,
+}
+
+/// Print `stats` for one interval with the matching running totals from
+/// `totals`, which must already include this interval.
+pub fn display_dual<W: Write>(
+    writer: &mut W,
+    stats: &[DeltaStats],
+    totals: &MountSession,
+) -> io::Result<()> {
+    writeln!(
+        writer,
+        "{:<14} {:>9} {:>11} {:>9} {:>9} {:>10} {:>11} {:>7} {:>8} {:>7} {:>8}",
+        "OP",
+        "OPS",
+        "OPS(cum)",
+        "RTT",
+        "RTT(cum)",
+        "KB",
+        "MB(cum)",
+        "ERR",
+        "ERR(cum)",
+        "RETR",
+        "RETR(cum)"
+    )?;
+    writeln!(writer, "{}", "-".repeat(115))?;
+    for stat in stats {
+        let cum = totals.ops.get(&stat.operation).cloned().unwrap_or_default();
+        writeln!(
+            writer,
+            "{:<14} {:>9} {:>11} {:>9.2} {:>9.2} {:>10.1} {:>11.1} {:>7} {:>8} {:>7} {:>8}",
+            stat.operation,
+            stat.delta_ops,
+            cum.ops,
+            stat.avg_rtt,
+            cum.avg_rtt(),
+            stat.delta_bytes as f64 / 1024.0,
+            cum.bytes as f64 / 1_048_576.0,
+            stat.delta_errors,
+            cum.errors,
+            stat.delta_retrans,
+            cum.retrans
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::session::Session;
+    use chrono::Utc;
+
+    #[test]
+    fn test_display_dual() {
+        let now = Utc::now();
+        let mut session = Session::new(now);
+        session.record("/mnt", now, 1.0, &[stat("READ", 100, 1.0)]);
+        let second = [stat("READ", 300, 3.0)];
+        session.record("/mnt", now, 1.0, &second);
+
+        let mut out = Vec::new();
+        display_dual(&mut out, &second, &session.mounts["/mnt"]).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let row: Vec<&str> = text
+            .lines()
+            .find(|l| l.starts_with("READ"))
+            .unwrap()
+            .split_whitespace()
+            .collect();
+        // interval 300 ops at 3.00ms; cumulative 400 ops at 2.50ms
+        assert_eq!(&row[1..5], ["300", "400", "3.00", "2.50"]);
+    }
+}
@@ -0,0 +1,300 @@
+//! `--deep-debug`: temporarily raise sunrpc/nfs debug flags around an
+//! incident window and bundle the resulting kernel log lines.
+
+use chrono::{DateTime, Utc};
+use clap::Args;
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, BufRead, BufReader, Seek, SeekFrom, Write};
+use std::os::unix::fs::OpenOptionsExt;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, Mutex};
+use std::thread::{self, JoinHandle};
+use std::time::Duration;
+
+const RPC_DEBUG: &str = "/proc/sys/sunrpc/rpc_debug";
+const NFS_DEBUG: &str = "/proc/sys/sunrpc/nfs_debug";
+const KMSG: &str = "/dev/kmsg";
+
+/// RPCDBG_* bits from include/uapi/linux/sunrpc/debug.h.
+const RPC_FLAGS: &[(&str, u32)] = &[
+    ("xprt", 0x0001),
+    ("call", 0x0002),
+    ("debug", 0x0004),
+    ("nfs", 0x0008),
+    ("auth", 0x0010),
+    ("bind", 0x0020),
+    ("sched", 0x0040),
+    ("trans", 0x0080),
+    ("svcsock", 0x0100),
+    ("svcdsp", 0x0200),
+    ("misc", 0x0400),
+    ("cache", 0x0800),
+];
+
+/// NFSDBG_* bits from include/uapi/linux/nfs_fs.h.
+const NFS_FLAGS: &[(&str, u32)] = &[
+    ("vfs", 0x0001),
+    ("dircache", 0x0002),
+    ("lookupcache", 0x0004),
+    ("pagecache", 0x0008),
+    ("proc", 0x0010),
+    ("xdr", 0x0020),
+    ("file", 0x0040),
+    ("root", 0x0080),
+    ("callback", 0x0100),
+    ("client", 0x0200),
+    ("mount", 0x0400),
+    ("fscache", 0x0800),
+    ("pnfs", 0x1000),
+    ("pnfs_ld", 0x2000),
+    ("state", 0x4000),
+];
+
+#[derive(Args, Debug, Clone)]
+pub struct DeepDebugArgs {
+    /// Enable rpc/nfs kernel debugging around detected incidents (requires root)
+    #[arg(long = "deep-debug")]
+    pub deep_debug: bool,
+
+    /// sunrpc debug flags to raise during capture
+    #[arg(long = "debug-rpc", default_value = "xprt,trans,sched")]
+    pub rpc_flags: String,
+
+    /// nfs debug flags to raise during capture
+    #[arg(long = "debug-nfs", default_value = "proc,state")]
+    pub nfs_flags: String,
+
+    /// Seconds to keep debugging enabled once an incident is detected
+    #[arg(long = "debug-window", default_value = "10")]
+    pub debug_window: u64,
+
+    /// Directory that receives capture bundles
+    #[arg(long = "debug-dir", default_value = "/var/tmp")]
+    pub dir: String,
+}
+
+/// Convert a comma-separated flag list into a debug mask.
+pub fn parse_flags(spec: &str, table: &[(&str, u32)]) -> Result<u32, String> {
+    let mut mask = 0;
+    for name in spec.split(',').map(str::trim).filter(|s| !s.is_empty()) {
+        if name == "all" {
+            return Ok(table.iter().fold(0, |m, (_, bit)| m | bit));
+        }
+        let (_, bit) = table
+            .iter()
+            .find(|(flag, _)| *flag == name)
+            .ok_or_else(|| format!("unknown debug flag: {}", name))?;
+        mask |= bit;
+    }
+    Ok(mask)
+}
+
+pub fn rpc_mask(spec: &str) -> Result<u32, String> {
+    parse_flags(spec, RPC_FLAGS)
+}
+
+pub fn nfs_mask(spec: &str) -> Result<u32, String> {
+    parse_flags(spec, NFS_FLAGS)
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct KmsgRecord {
+    pub priority: u8,
+    pub sequence: u64,
+    pub timestamp_us: u64,
+    pub message: String,
+}
+
+/// Parse a /dev/kmsg record: `6,1234,5678901,-;message`.
+pub fn parse_kmsg(line: &str) -> Option<KmsgRecord> {
+    let (header, message) = line.split_once(';')?;
+    let mut fields = header.split(',');
+    let level: u32 = fields.next()?.parse().ok()?;
+    let sequence = fields.next()?.parse().ok()?;
+    let timestamp_us = fields.next()?.parse().ok()?;
+    Some(KmsgRecord {
+        priority: (level & 7) as u8,
+        sequence,
+        timestamp_us,
+        message: message.to_string(),
+    })
+}
+
+/// An active capture. Debug masks are restored when it is finished or
+/// dropped, even if the caller bails out early.
+pub struct DebugCapture {
+    saved_rpc: Option<String>,
+    saved_nfs: Option<String>,
+    running: Arc<AtomicBool>,
+    lines: Arc<Mutex<Vec<KmsgRecord>>>,
+    reader: Option<JoinHandle<()>>,
+    started: DateTime<Utc>,
+}
+
+impl DebugCapture {
+    pub fn start(rpc: u32, nfs: u32) -> io::Result<Self> {
+        let mut kmsg = OpenOptions::new()
+            .read(true)
+            .custom_flags(libc::O_NONBLOCK)
+            .open(KMSG)?;
+        // Only records produced from now on are of interest.
+        kmsg.seek(SeekFrom::End(0))?;
+
+        let mut capture = Self {
+            saved_rpc: fs::read_to_string(RPC_DEBUG).ok(),
+            saved_nfs: fs::read_to_string(NFS_DEBUG).ok(),
+            running: Arc::new(AtomicBool::new(true)),
+            lines: Arc::new(Mutex::new(Vec::new())),
+            reader: None,
+            started: Utc::now(),
+        };
+        fs::write(RPC_DEBUG, rpc.to_string())?;
+        fs::write(NFS_DEBUG, nfs.to_string())?;
+
+        let running = capture.running.clone();
+        let lines = capture.lines.clone();
+        capture.reader = Some(thread::spawn(move || read_kmsg(kmsg, running, lines)));
+        Ok(capture)
+    }
+
+    /// Restore the debug masks and return everything logged meanwhile.
+    pub fn finish(mut self) -> Vec<KmsgRecord> {
+        self.stop();
+        let mut lines = self.lines.lock().map(|l| l.clone()).unwrap_or_default();
+        lines.sort_by_key(|r| r.sequence);
+        lines
+    }
+
+    fn stop(&mut self) {
+        self.running.store(false, Ordering::SeqCst);
+        if let Some(saved) = self.saved_rpc.take() {
+            let _ = fs::write(RPC_DEBUG, saved.trim());
+        }
+        if let Some(saved) = self.saved_nfs.take() {
+            let _ = fs::write(NFS_DEBUG, saved.trim());
+        }
+        if let Some(reader) = self.reader.take() {
+            let _ = reader.join();
+        }
+    }
+
+    pub fn started(&self) -> DateTime<Utc> {
+        self.started
+    }
+}
+
+impl Drop for DebugCapture {
+    fn drop(&mut self) {
+        self.stop();
+    }
+}
+
+fn read_kmsg(kmsg: File, running: Arc<AtomicBool>, lines: Arc<Mutex<Vec<KmsgRecord>>>) {
+    let mut reader = BufReader::new(kmsg);
+    let mut buf = String::new();
+    while running.load(Ordering::SeqCst) {
+        buf.clear();
+        match reader.read_line(&mut buf) {
+            Ok(0) => thread::sleep(Duration::from_millis(50)),
+            Ok(_) => {
+                if let Some(record) = parse_kmsg(buf.trim_end()) {
+                    if let Ok(mut lines) = lines.lock() {
+                        lines.push(record);
+                    }
+                }
+            }
+            Err(e) if e.kind() == io::ErrorKind::WouldBlock => {
+                thread::sleep(Duration::from_millis(50))
+            }
+            // EPIPE means records were overwritten before we read them;
+            // keep going with what is still available.
+            Err(e) if e.raw_os_error() == Some(libc::EPIPE) => continue,
+            Err(_) => break,
+        }
+    }
+}
+
+/// Write a capture bundle: the kernel log plus the mountstats snapshots
+/// taken around the incident. Returns the bundle directory.
+pub fn write_bundle(
+    dir: &str,
+    reason: &str,
+    started: DateTime<Utc>,
+    records: &[KmsgRecord],
+    snapshots: &[(&str, String)],
+) -> io::Result<PathBuf> {
+    let bundle = Path::new(dir).join(format!(
+        "nfs-gaze-debug-{}",
+        started.format("%Y%m%dT%H%M%SZ")
+    ));
+    fs::create_dir_all(&bundle)?;
+
+    let mut log = File::create(bundle.join("kernel.log"))?;
+    for record in records {
+        writeln!(
+            log,
+            "[{:>5}.{:06}] {}",
+            record.timestamp_us / 1_000_000,
+            record.timestamp_us % 1_000_000,
+            record.message
+        )?;
+    }
+
+    for (name, contents) in snapshots {
+        fs::write(bundle.join(format!("mountstats.{}", name)), contents)?;
+    }
+
+    let mut info = File::create(bundle.join("README"))?;
+    writeln!(info, "reason: {}", reason)?;
+    writeln!(info, "started: {}", started.to_rfc3339())?;
+    writeln!(info, "finished: {}", Utc::now().to_rfc3339())?;
+    writeln!(info, "kernel log lines: {}", records.len())?;
+    Ok(bundle)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_flag_masks() {
+        assert_eq!(rpc_mask("xprt,trans").unwrap(), 0x0081);
+        assert_eq!(nfs_mask(" proc , state ").unwrap(), 0x4010);
+        assert_eq!(nfs_mask("").unwrap(), 0);
+        assert_eq!(rpc_mask("all").unwrap(), 0x0fff);
+        assert!(rpc_mask("bogus").is_err());
+    }
+
+    #[test]
+    fn test_parse_kmsg() {
+        let record = parse_kmsg("7,4021,88123456,-;RPC:  4321 xprt_transmit(112)").unwrap();
+        assert_eq!(record.priority, 7);
+        assert_eq!(record.sequence, 4021);
+        assert_eq!(record.timestamp_us, 88_123_456);
+        assert_eq!(record.message, "RPC:  4321 xprt_transmit(112)");
+
+        assert!(parse_kmsg("no header").is_none());
+    }
+
+    #[test]
+    fn test_write_bundle() {
+        let dir = tempfile::tempdir().unwrap();
+        let started = Utc::now();
+        let records = vec![parse_kmsg("6,1,1500000,-;NFS: server not responding").unwrap()];
+        let bundle = write_bundle(
+            dir.path().to_str().unwrap(),
+            "READ rtt 250ms",
+            started,
+            &records,
+            &[("before", "device a".to_string())],
+        )
+        .unwrap();
+
+        let log = fs::read_to_string(bundle.join("kernel.log")).unwrap();
+        assert!(log.contains("[    1.500000] NFS: server not responding"));
+        assert!(bundle.join("mountstats.before").exists());
+        let info = fs::read_to_string(bundle.join("README")).unwrap();
+        assert!(info.contains("reason: READ rtt 250ms"));
+    }
+}
@@ -0,0 +1,192 @@
+//! NFSv4 delegation grant/recall/return activity per mount.
+//!
+//! Tracepoints give the full picture; without them only returns are
+//! visible, through the DELEGRETURN operation counter in mountstats.
+
+use crate::mountinfo::MountInfo;
+use crate::tracefs::{self, HeldEvents, TraceRecord};
+use crate::types::NFSMount;
+use clap::Args;
+use std::collections::{BTreeMap, HashMap};
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct DelegationArgs {
+    /// Show NFSv4 delegation grants, recalls and returns per mount
+    #[arg(long = "delegations")]
+    pub delegations: bool,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DelegationEvent {
+    Granted,
+    Reclaimed,
+    Recalled,
+    Returned,
+}
+
+/// tracefs event → what it means for delegation state.
+pub const DELEGATION_EVENTS: &[(&str, DelegationEvent)] = &[
+    ("nfs4/nfs4_set_delegation", DelegationEvent::Granted),
+    ("nfs4/nfs4_reclaim_delegation", DelegationEvent::Reclaimed),
+    ("nfs4/nfs4_cb_recall", DelegationEvent::Recalled),
+    ("nfs4/nfs4_delegreturn", DelegationEvent::Returned),
+];
+
+/// Convert the `00:2b` device prefix used in tracepoint `fileid=`/`dev=`
+/// fields into mountinfo's decimal `0:43` form.
+pub fn trace_device(payload: &str) -> Option<String> {
+    let raw = tracefs::field(payload, "fileid").or_else(|| tracefs::field(payload, "dev"))?;
+    let mut parts = raw.split(':');
+    let major = u32::from_str_radix(parts.next()?, 16).ok()?;
+    let minor = u32::from_str_radix(parts.next()?, 16).ok()?;
+    Some(format!("{}:{}", major, minor))
+}
+
+pub fn classify(record: &TraceRecord) -> Option<DelegationEvent> {
+    DELEGATION_EVENTS
+        .iter()
+        .find(|(event, _)| event.rsplit('/').next() == Some(record.event.as_str()))
+        .map(|(_, kind)| *kind)
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct DelegationCounts {
+    pub granted: u64,
+    pub reclaimed: u64,
+    pub recalled: u64,
+    pub returned: u64,
+}
+
+impl DelegationCounts {
+    fn add(&mut self, event: DelegationEvent) {
+        match event {
+            DelegationEvent::Granted => self.granted += 1,
+            DelegationEvent::Reclaimed => self.reclaimed += 1,
+            DelegationEvent::Recalled => self.recalled += 1,
+            DelegationEvent::Returned => self.returned += 1,
+        }
+    }
+}
+
+/// Accumulates tracepoint events per superblock device.
+#[derive(Debug, Default)]
+pub struct DelegationTracker {
+    by_device: HashMap<String, DelegationCounts>,
+}
+
+impl DelegationTracker {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn record(&mut self, record: &TraceRecord) {
+        let (Some(kind), Some(device)) = (classify(record), trace_device(&record.payload)) else {
+            return;
+        };
+        self.by_device.entry(device).or_default().add(kind);
+    }
+
+    /// The interval's counts keyed by mount point, resetting the tally.
+    /// Events on devices that are not monitored are dropped.
+    pub fn take_interval(
+        &mut self,
+        mounts: &HashMap<String, MountInfo>,
+    ) -> BTreeMap<String, DelegationCounts> {
+        let by_device = std::mem::take(&mut self.by_device);
+        mounts
+            .iter()
+            .filter_map(|(mp, info)| {
+                by_device
+                    .get(&info.device)
+                    .map(|counts| (mp.clone(), counts.clone()))
+            })
+            .collect()
+    }
+}
+
+/// Fallback when tracepoints are unavailable: DELEGRETURN completions
+/// between two snapshots of the same mount.
+pub fn returns_from_mountstats(before: &NFSMount, after: &NFSMount) -> DelegationCounts {
+    let count = |m: &NFSMount| m.operations.get("DELEGRETURN").map_or(0, |op| op.ops);
+    DelegationCounts {
+        returned: (count(after) - count(before)).max(0) as u64,
+        ..Default::default()
+    }
+}
+
+pub fn enable_delegation_events(root: &str) -> io::Result<HeldEvents> {
+    let events: Vec<&'static str> = DELEGATION_EVENTS.iter().map(|(event, _)| *event).collect();
+    tracefs::enable_events(root, &events)
+}
+
+pub fn display_delegations<W: Write>(
+    writer: &mut W,
+    counts: &BTreeMap<String, DelegationCounts>,
+) -> io::Result<()> {
+    if counts.is_empty() {
+        return Ok(());
+    }
+
+    writeln!(
+        writer,
+        "{:<28} {:>8} {:>9} {:>8} {:>8}",
+        "MOUNT", "GRANTED", "RECLAIMED", "RECALLED", "RETURNED"
+    )?;
+    writeln!(writer, "{}", "-".repeat(65))?;
+    for (mount_point, c) in counts {
+        writeln!(
+            writer,
+            "{:<28} {:>8} {:>9} {:>8} {:>8}",
+            mount_point, c.granted, c.reclaimed, c.recalled, c.returned
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::mountinfo::parse_nfs_mountinfo;
+    use crate::tracefs::parse_record;
+
+    #[test]
+    fn test_trace_device() {
+        assert_eq!(
+            trace_device("error=0 (OK) fileid=00:2b:1234 fhandle=0xabcd"),
+            Some("0:43".to_string())
+        );
+        assert_eq!(
+            trace_device("dev=00:35 fhandle=0x1"),
+            Some("0:53".to_string())
+        );
+        assert_eq!(trace_device("fhandle=0x1"), None);
+    }
+
+    #[test]
+    fn test_tracker_maps_to_mounts() {
+        let mounts = parse_nfs_mountinfo(
+            "36 22 0:43 / /mnt/home rw - nfs4 srv:/home rw,vers=4.1\n\
+             37 22 0:44 / /mnt/scratch rw - nfs4 srv:/scratch rw,vers=4.1\n",
+        );
+        let mut tracker = DelegationTracker::new();
+        for line in [
+            "cp-1 [000] ..... 1.0: nfs4_set_delegation: fileid=00:2b:10 fhandle=0x1 type=READ",
+            "cp-1 [000] ..... 1.1: nfs4_set_delegation: fileid=00:2b:11 fhandle=0x2 type=READ",
+            "kworker-9 [001] ..... 1.2: nfs4_cb_recall: error=0 (OK) fileid=00:2b:10 fhandle=0x1",
+            "cp-1 [000] ..... 1.3: nfs4_delegreturn: error=0 (OK) dev=00:2b fhandle=0x1",
+            "cp-1 [000] ..... 1.4: nfs4_set_delegation: fileid=00:99:1 fhandle=0x3",
+        ] {
+            tracker.record(&parse_record(line).unwrap());
+        }
+
+        let counts = tracker.take_interval(&mounts);
+        assert_eq!(counts.len(), 1);
+        let home = &counts["/mnt/home"];
+        assert_eq!(home.granted, 2);
+        assert_eq!(home.recalled, 1);
+        assert_eq!(home.returned, 1);
+        assert!(tracker.take_interval(&mounts).is_empty());
+    }
+}
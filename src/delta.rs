@@ -0,0 +1,193 @@
+//! Converting two raw mount snapshots into per-operation DeltaStats, for
+//! subcommands that sample mountstats themselves.
+
+use crate::types::{DeltaStats, NFSMount, NFSOperation};
+
+/// A per-op counter that went backwards between two samples without
+/// having been near the top of its range.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CounterReset {
+    pub operation: String,
+    pub counter: &'static str,
+    pub previous: u64,
+    pub current: u64,
+}
+
+/// The kernel's counters are u64. One that drops from the top quarter of
+/// the range has wrapped, and `wrapping_sub` gives its true delta; any
+/// other drop is a reset.
+const WRAP_THRESHOLD: u64 = u64::MAX / 4 * 3;
+
+/// The counters as the kernel's u64s; the parser stores them bit for bit.
+fn counters(op: &NFSOperation) -> [(&'static str, u64); 9] {
+    [
+        ("ops", op.ops as u64),
+        ("ntrans", op.ntrans as u64),
+        ("timeouts", op.timeouts as u64),
+        ("bytes_sent", op.bytes_sent as u64),
+        ("bytes_recv", op.bytes_recv as u64),
+        ("queue", op.queue_time as u64),
+        ("rtt", op.rtt as u64),
+        ("execute", op.execute_time as u64),
+        ("errors", op.errors as u64),
+    ]
+}
+
+/// The first counter of `cur` that was reset since `prev`, if any.
+fn find_reset(prev: &NFSOperation, cur: &NFSOperation) -> Option<CounterReset> {
+    counters(prev)
+        .into_iter()
+        .zip(counters(cur))
+        .find(|((_, p), (_, c))| c < p && *p < WRAP_THRESHOLD)
+        .map(|((counter, previous), (_, current))| CounterReset {
+            operation: cur.name.clone(),
+            counter,
+            previous,
+            current,
+        })
+}
+
+/// `cur - prev` in u64, across a wrap if there was one.
+pub(crate) fn delta(cur: i64, prev: i64) -> i64 {
+    (cur as u64).wrapping_sub(prev as u64) as i64
+}
+
+/// Per-op deltas between `before` and `after` over `interval_secs`, in
+/// operation name order. Operations missing from `before`, or with any
+/// counter that went backwards, are skipped.
+pub fn mount_delta(before: &NFSMount, after: &NFSMount, interval_secs: f64) -> Vec<DeltaStats> {
+    checked_mount_delta(before, after, interval_secs).0
+}
+
+/// Like [`mount_delta`], also returning the counter resets that caused
+/// operations to be discarded for this interval.
+pub fn checked_mount_delta(
+    before: &NFSMount,
+    after: &NFSMount,
+    interval_secs: f64,
+) -> (Vec<DeltaStats>, Vec<CounterReset>) {
+    let secs = interval_secs.max(f64::EPSILON);
+    let mut resets = Vec::new();
+    let mut stats: Vec<DeltaStats> = after
+        .operations
+        .iter()
+        .filter_map(|(name, cur)| {
+            let prev = before.operations.get(name)?;
+            if let Some(reset) = find_reset(prev, cur) {
+                resets.push(reset);
+                return None;
+            }
+            let ops = delta(cur.ops, prev.ops);
+            let sent = delta(cur.bytes_sent, prev.bytes_sent);
+            let recv = delta(cur.bytes_recv, prev.bytes_recv);
+            let rtt = delta(cur.rtt, prev.rtt);
+            let exec = delta(cur.execute_time, prev.execute_time);
+            let queue = delta(cur.queue_time, prev.queue_time);
+            let per_op = |total: i64| {
+                if ops > 0 {
+                    total as f64 / ops as f64
+                } else {
+                    0.0
+                }
+            };
+            Some(DeltaStats {
+                operation: name.clone(),
+                delta_ops: ops,
+                delta_bytes: sent + recv,
+                delta_sent: sent,
+                delta_recv: recv,
+                delta_rtt: rtt,
+                delta_exec: exec,
+                delta_queue: queue,
+                delta_errors: delta(cur.errors, prev.errors),
+                delta_retrans: (delta(cur.ntrans, prev.ntrans) - ops).max(0),
+                avg_rtt: per_op(rtt),
+                avg_exec: per_op(exec),
+                avg_queue: per_op(queue),
+                kb_per_op: per_op(sent + recv) / 1024.0,
+                kb_per_sec: (sent + recv) as f64 / 1024.0 / secs,
+                iops: ops as f64 / secs,
+            })
+        })
+        .collect();
+    stats.sort_by(|a, b| a.operation.cmp(&b.operation));
+    resets.sort_by(|a, b| a.operation.cmp(&b.operation));
+    (stats, resets)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil;
+    use crate::types::NFSOperation;
+
+    fn mount(ops: i64, ntrans: i64, rtt: i64) -> NFSMount {
+        let op = |name: &str| NFSOperation {
+            ops,
+            ntrans,
+            rtt,
+            execute_time: rtt * 2,
+            bytes_sent: ops * 100,
+            bytes_recv: ops * 924,
+            ..testutil::op(name)
+        };
+        testutil::with_ops(testutil::mount("/mnt"), [op("WRITE"), op("READ")])
+    }
+
+    #[test]
+    fn test_mount_delta() {
+        let stats = mount_delta(&mount(10, 10, 10), &mount(110, 112, 310), 2.0);
+        assert_eq!(stats.len(), 2);
+        assert_eq!(stats[0].operation, "READ");
+
+        let read = &stats[0];
+        assert_eq!(read.delta_ops, 100);
+        assert_eq!(read.delta_retrans, 2);
+        assert_eq!(read.delta_bytes, 102_400);
+        assert!((read.avg_rtt - 3.0).abs() < 1e-9);
+        assert!((read.avg_exec - 6.0).abs() < 1e-9);
+        assert!((read.kb_per_op - 1.0).abs() < 1e-9);
+        assert!((read.iops - 50.0).abs() < 1e-9);
+        assert!((read.kb_per_sec - 50.0).abs() < 1e-9);
+
+        assert!(mount_delta(&mount(110, 110, 0), &mount(10, 10, 0), 1.0).is_empty());
+    }
+
+    #[test]
+    fn test_counter_reset_and_wrap() {
+        let before = mount(100, 100, 500);
+        let mut after = mount(110, 110, 600);
+        after.operations.get_mut("WRITE").unwrap().rtt = 5;
+
+        let (stats, resets) = checked_mount_delta(&before, &after, 1.0);
+        assert_eq!(stats.len(), 1);
+        assert_eq!(stats[0].operation, "READ");
+        assert_eq!(
+            resets,
+            vec![CounterReset {
+                operation: "WRITE".to_string(),
+                counter: "rtt",
+                previous: 500,
+                current: 5,
+            }]
+        );
+
+        // u64::MAX - 9 as the parser stores it; 20 more bytes wrap to 10.
+        let mut near_max = mount(10, 10, 0);
+        near_max.operations.get_mut("READ").unwrap().bytes_recv = (u64::MAX - 9) as i64;
+        let mut after = mount(20, 20, 0);
+        after.operations.get_mut("READ").unwrap().bytes_recv = 10;
+        let (stats, resets) = checked_mount_delta(&near_max, &after, 1.0);
+        assert!(resets.is_empty());
+        assert_eq!(stats[0].operation, "READ");
+        assert_eq!(stats[0].delta_recv, 20);
+        assert_eq!(stats[0].delta_ops, 10);
+
+        // Dropping from the middle of the range is a reset, not a wrap.
+        let mut big = mount(10, 10, 0);
+        big.operations.get_mut("READ").unwrap().bytes_recv = i64::MAX;
+        let (_, resets) = checked_mount_delta(&big, &after, 1.0);
+        assert_eq!(resets[0].counter, "bytes_recv");
+        assert_eq!(resets[0].previous, i64::MAX as u64);
+    }
+}
@@ -0,0 +1,181 @@
+//! `nfs-gaze diff before after`: per-op deltas and rates between two
+//! saved mountstats files, for before/after benchmark comparisons without
+//! keeping a sampler running.
+
+use crate::columns::{columns, display_columns, ColumnArgs};
+use crate::delta::{checked_mount_delta, CounterReset};
+use crate::parser::parse_mountstats;
+use crate::types::{DeltaStats, NFSMount, Result};
+use crate::units::HumanArgs;
+use clap::Args;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct DiffArgs {
+    /// mountstats file saved before the run
+    pub before: String,
+
+    /// mountstats file saved after the run
+    pub after: String,
+
+    /// Time between the two files (e.g. 30s, 5m); defaults to the
+    /// difference in each mount's age
+    #[arg(long = "duration", value_parser = parse_duration)]
+    pub duration: Option<f64>,
+
+    #[command(flatten)]
+    pub columns: ColumnArgs,
+
+    #[command(flatten)]
+    pub human: HumanArgs,
+}
+
+/// Parse `30`, `30s`, `5m`, `1h` or `1.5s` into seconds.
+pub fn parse_duration(s: &str) -> std::result::Result<f64, String> {
+    let s = s.trim();
+    let (num, scale) = match s.char_indices().last() {
+        Some((i, 's')) => (&s[..i], 1.0),
+        Some((i, 'm')) => (&s[..i], 60.0),
+        Some((i, 'h')) => (&s[..i], 3600.0),
+        _ => (s, 1.0),
+    };
+    match num.parse::<f64>() {
+        Ok(n) if n > 0.0 && n.is_finite() => Ok(n * scale),
+        _ => Err(format!("invalid duration '{}'", s)),
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct MountDiff {
+    pub mount: NFSMount,
+    pub interval_secs: f64,
+    pub stats: Vec<DeltaStats>,
+    /// Counters that went backwards, typically because the files were
+    /// taken across a remount or reboot.
+    pub resets: Vec<CounterReset>,
+}
+
+/// Diff every mount present in both snapshots, in `after` order. Mounts
+/// found in only one snapshot are returned by mount point.
+pub fn diff_mounts(
+    before: &[NFSMount],
+    after: &[NFSMount],
+    duration: Option<f64>,
+) -> (Vec<MountDiff>, Vec<String>) {
+    let mut diffs = Vec::new();
+    let mut unmatched = Vec::new();
+    for cur in after {
+        let Some(prev) = before.iter().find(|m| m.mount_point == cur.mount_point) else {
+            unmatched.push(cur.mount_point.clone());
+            continue;
+        };
+        let interval_secs = duration.unwrap_or_else(|| (cur.age - prev.age).max(1) as f64);
+        let (stats, resets) = checked_mount_delta(prev, cur, interval_secs);
+        diffs.push(MountDiff {
+            mount: cur.clone(),
+            interval_secs,
+            stats,
+            resets,
+        });
+    }
+    unmatched.extend(
+        before
+            .iter()
+            .filter(|m| !after.iter().any(|a| a.mount_point == m.mount_point))
+            .map(|m| m.mount_point.clone()),
+    );
+    (diffs, unmatched)
+}
+
+pub fn run_diff<W: Write>(writer: &mut W, args: &DiffArgs) -> Result<()> {
+    let before = parse_mountstats(&args.before)?;
+    let after = parse_mountstats(&args.after)?;
+    let (diffs, unmatched) = diff_mounts(&before, &after, args.duration);
+    display_diff(writer, &diffs, &unmatched, args)?;
+    Ok(())
+}
+
+pub fn display_diff<W: Write>(
+    writer: &mut W,
+    diffs: &[MountDiff],
+    unmatched: &[String],
+    args: &DiffArgs,
+) -> io::Result<()> {
+    let cols = columns(&args.columns, None, false);
+    for diff in diffs {
+        writeln!(
+            writer,
+            "{} mounted on {}",
+            diff.mount.device, diff.mount.mount_point
+        )?;
+        writeln!(writer, "Interval: {:.1}s", diff.interval_secs)?;
+        for reset in &diff.resets {
+            writeln!(
+                writer,
+                "WARNING: {} {} went from {} to {}; operation skipped",
+                reset.operation, reset.counter, reset.previous, reset.current
+            )?;
+        }
+        writeln!(writer)?;
+        display_columns(writer, &diff.stats, &cols, args.human.human)?;
+    }
+    for mount_point in unmatched {
+        writeln!(writer, "NOTE: {} is only in one of the files", mount_point)?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil::mount;
+    use crate::types::NFSOperation;
+
+    fn with_reads(mount_point: &str, age: i64, ops: i64) -> NFSMount {
+        let mut m = mount(mount_point);
+        m.age = age;
+        m.operations.insert(
+            "READ".to_string(),
+            NFSOperation {
+                name: "READ".to_string(),
+                ops,
+                ntrans: ops,
+                bytes_recv: ops * 4096,
+                rtt: ops * 2,
+                ..Default::default()
+            },
+        );
+        m
+    }
+
+    #[test]
+    fn test_parse_duration() {
+        assert_eq!(parse_duration("30s"), Ok(30.0));
+        assert_eq!(parse_duration("5m"), Ok(300.0));
+        assert_eq!(parse_duration("1h"), Ok(3600.0));
+        assert_eq!(parse_duration("2.5"), Ok(2.5));
+        assert!(parse_duration("0s").is_err());
+        assert!(parse_duration("soon").is_err());
+    }
+
+    #[test]
+    fn test_diff_mounts() {
+        let before = vec![with_reads("/a", 100, 1000), with_reads("/gone", 5, 1)];
+        let after = vec![with_reads("/a", 110, 3000), with_reads("/new", 1, 1)];
+
+        let (diffs, unmatched) = diff_mounts(&before, &after, None);
+        assert_eq!(diffs.len(), 1);
+        assert_eq!(diffs[0].interval_secs, 10.0);
+        assert_eq!(diffs[0].stats[0].delta_ops, 2000);
+        assert!((diffs[0].stats[0].iops - 200.0).abs() < 1e-9);
+        assert_eq!(unmatched, vec!["/new", "/gone"]);
+
+        let (diffs, _) = diff_mounts(&before, &after, Some(40.0));
+        assert!((diffs[0].stats[0].iops - 50.0).abs() < 1e-9);
+
+        // Swapped files: counters run backwards and are reported.
+        let (diffs, _) = diff_mounts(&after, &before, Some(1.0));
+        assert!(diffs[0].stats.is_empty());
+        assert_eq!(diffs[0].resets[0].operation, "READ");
+    }
+}
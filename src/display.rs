@@ -0,0 +1,105 @@
+//! The default per-interval table.
+
+use crate::types::{DeltaStats, NFSEvents, NFSMount};
+use chrono::{DateTime, Utc};
+use std::io::{self, Write};
+
+/// The `<time> <mount point> (<device>)` line above each mount's table.
+pub fn display_mount_header<W: Write>(
+    writer: &mut W,
+    mount: &NFSMount,
+    timestamp: &DateTime<Utc>,
+) -> io::Result<()> {
+    writeln!(
+        writer,
+        "{} {} ({})",
+        timestamp.format("%Y-%m-%d %H:%M:%S"),
+        mount.mount_point,
+        mount.device
+    )
+}
+
+/// Print one interval of `stats` for `mount`. Nothing is printed for an
+/// interval without operations.
+pub fn display_stats_simple<W: Write>(
+    writer: &mut W,
+    mount: &NFSMount,
+    stats: &[DeltaStats],
+    show_bandwidth: bool,
+    timestamp: &DateTime<Utc>,
+) -> io::Result<()> {
+    if stats.is_empty() {
+        return Ok(());
+    }
+    display_mount_header(writer, mount, timestamp)?;
+    write!(
+        writer,
+        "{:<14} {:>10} {:>10} {:>10}",
+        "OP", "IOPS", "RTT", "EXEC"
+    )?;
+    if show_bandwidth {
+        write!(writer, " {:>10} {:>10}", "KB/op", "MB/s")?;
+    }
+    writeln!(writer)?;
+    writeln!(
+        writer,
+        "{}",
+        "-".repeat(if show_bandwidth { 80 } else { 58 })
+    )?;
+    for stat in stats {
+        write!(
+            writer,
+            "{:<14} {:>10} {:>10} {:>10}",
+            stat.operation,
+            format_rate(stat.iops),
+            format_duration(ms_to_us(stat.avg_rtt)),
+            format_duration(ms_to_us(stat.avg_exec))
+        )?;
+        if show_bandwidth {
+            write!(
+                writer,
+                " {:>10.1} {:>10}",
+                stat.kb_per_op,
+                format_bandwidth(stat.kb_per_sec)
+            )?;
+        }
+        writeln!(writer)?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+fn ms_to_us(ms: f64) -> i64 {
+    (ms * 1000.0).round() as i64
+}
+
+/// Microseconds as milliseconds, e.g. `1.5ms`.
+pub fn format_duration(us: i64) -> String {
+    format!("{:.1}ms", us as f64 / 1000.0)
+}
+
+pub fn format_rate(rate: f64) -> String {
+    format!("{:.1}", rate)
+}
+
+/// KB/s as MB/s.
+pub fn format_bandwidth(kb_per_sec: f64) -> String {
+    format!("{:.1}", kb_per_sec / 1024.0)
+}
+
+/// Attribute cache activity between two `events:` samples.
+pub fn display_attr_stats<W: Write>(
+    writer: &mut W,
+    prev: &NFSEvents,
+    cur: &NFSEvents,
+) -> io::Result<()> {
+    writeln!(
+        writer,
+        "Attribute cache: {} inode revalidations, {} dentry revalidations, {} attr invalidations, {} data invalidations",
+        cur.inode_revalidate - prev.inode_revalidate,
+        cur.dentry_revalidate - prev.dentry_revalidate,
+        cur.attr_invalidate - prev.attr_invalidate,
+        cur.data_invalidate - prev.data_invalidate
+    )?;
+    writeln!(writer)
+}
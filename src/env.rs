@@ -0,0 +1,156 @@
+//! `NFS_GAZE_*` environment variables, for containers and systemd units.
+//!
+//! Every long flag has a variable: upper-case it, swap dashes for
+//! underscores and add the prefix, so `--mount-point` is
+//! `NFS_GAZE_MOUNT_POINT` and `--bw` is `NFS_GAZE_BW=1`. Precedence is
+//! command line, then environment, then `--config` file.
+
+use crate::config::{Config, Settings};
+use crate::types::{NfsGazeError, Result};
+
+pub const PREFIX: &str = "NFS_GAZE_";
+
+/// Variable name for the flag with long name `long`.
+pub fn var_name(long: &str) -> String {
+    format!("{}{}", PREFIX, long.to_ascii_uppercase().replace('-', "_"))
+}
+
+fn parse_bool(name: &str, value: &str) -> Result<bool> {
+    match value.trim().to_ascii_lowercase().as_str() {
+        "1" | "true" | "yes" | "on" => Ok(true),
+        "0" | "false" | "no" | "off" | "" => Ok(false),
+        _ => Err(NfsGazeError::ParseError(format!(
+            "{}: expected true or false, got '{}'",
+            name, value
+        ))),
+    }
+}
+
+/// Settings from `NFS_GAZE_*` variables in `vars`. Flags that read their
+/// own variable through clap (such as `--redact-key`) are left to clap.
+/// Unknown `NFS_GAZE_*` names are errors, so typos do not pass silently.
+pub fn env_settings<I>(vars: I, command: &clap::Command) -> Result<Settings>
+where
+    I: IntoIterator<Item = (String, String)>,
+{
+    let mut settings: Settings = Vec::new();
+    let mut vars: Vec<(String, String)> = vars
+        .into_iter()
+        .filter(|(name, _)| name.starts_with(PREFIX))
+        .collect();
+    vars.sort();
+
+    for (name, value) in vars {
+        let arg = command
+            .get_arguments()
+            .find(|a| a.get_long().is_some_and(|long| var_name(long) == name));
+        let Some(arg) = arg else {
+            if command.get_arguments().any(|a| {
+                a.get_env()
+                    .is_some_and(|env| env.to_str() == Some(name.as_str()))
+            }) {
+                continue;
+            }
+            return Err(NfsGazeError::ParseError(format!(
+                "unknown environment variable {}",
+                name
+            )));
+        };
+        if arg.get_env().is_some() {
+            continue;
+        }
+        let long = arg.get_long().unwrap_or_default().to_string();
+        let value = if arg.get_action().takes_values() {
+            value
+        } else {
+            // Kept even when false, to override a config file's `true`.
+            parse_bool(&name, &value)?.to_string()
+        };
+        settings.push((long, vec![value]));
+    }
+    Ok(settings)
+}
+
+/// Environment settings followed by config file settings, so the
+/// environment wins where both set a flag.
+pub fn all_settings<I>(
+    vars: I,
+    config: Option<&Config>,
+    command: &clap::Command,
+) -> Result<Settings>
+where
+    I: IntoIterator<Item = (String, String)>,
+{
+    let mut settings = env_settings(vars, command)?;
+    if let Some(config) = config {
+        settings.extend(config.settings(command)?);
+    }
+    Ok(settings)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::config::with_defaults;
+    use clap::{CommandFactory, FromArgMatches, Parser};
+
+    #[derive(Parser, Debug)]
+    struct TestArgs {
+        #[arg(short = 'i', long = "interval", default_value = "1")]
+        interval: u64,
+        #[arg(short = 'm', long = "mount-point")]
+        mount_point: Option<String>,
+        #[arg(long = "bw")]
+        bw: bool,
+        #[arg(long = "ops")]
+        ops: Option<String>,
+        #[arg(long = "redact-key", env = "NFS_GAZE_REDACT_KEY")]
+        redact_key: Option<String>,
+    }
+
+    fn vars(pairs: &[(&str, &str)]) -> Vec<(String, String)> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    #[test]
+    fn test_env_settings() {
+        assert_eq!(var_name("mount-point"), "NFS_GAZE_MOUNT_POINT");
+        let command = TestArgs::command();
+        let env = vars(&[
+            ("NFS_GAZE_INTERVAL", "10"),
+            ("NFS_GAZE_MOUNT_POINT", "/mnt/data"),
+            ("NFS_GAZE_BW", "yes"),
+            ("NFS_GAZE_REDACT_KEY", "secret"),
+            ("PATH", "/usr/bin"),
+        ]);
+        let config = Config::parse("interval = 3\nops = \"READ\"").unwrap();
+        let settings = all_settings(env, Some(&config), &command).unwrap();
+        assert!(!settings.iter().any(|(_, values)| values[0] == "secret"));
+        let parse = |argv: &[&str]| {
+            let matches = with_defaults(TestArgs::command(), &settings).get_matches_from(argv);
+            TestArgs::from_arg_matches(&matches).unwrap()
+        };
+
+        // Command line beats environment beats config file.
+        let args = parse(&["nfs-gaze", "-i", "2"]);
+        assert_eq!(args.interval, 2);
+        assert_eq!(args.mount_point.as_deref(), Some("/mnt/data"));
+        assert!(args.bw);
+        assert_eq!(args.ops.as_deref(), Some("READ"));
+        assert_eq!(parse(&["nfs-gaze"]).interval, 10);
+
+        // A false switch in the environment overrides the config file.
+        let config = Config::parse("bw = true").unwrap();
+        let settings =
+            all_settings(vars(&[("NFS_GAZE_BW", "0")]), Some(&config), &command).unwrap();
+        assert_eq!(settings[0], ("bw".to_string(), vec!["false".to_string()]));
+        let matches = with_defaults(TestArgs::command(), &settings).get_matches_from(["nfs-gaze"]);
+        assert!(!TestArgs::from_arg_matches(&matches).unwrap().bw);
+
+        assert!(env_settings(vars(&[("NFS_GAZE_BW", "maybe")]), &command).is_err());
+        assert!(env_settings(vars(&[("NFS_GAZE_INTERVALL", "5")]), &command).is_err());
+    }
+}
@@ -0,0 +1,300 @@
+//! Break the per-op error counter down by NFS status code using the
+//! nfs/nfs4 `*_xdr_status` tracepoints.
+
+use crate::tracefs::{self, HeldEvents, TraceRecord};
+use clap::Args;
+use std::collections::HashMap;
+use std::io::{self, Write};
+
+/// Tracepoints that fire whenever a reply carries a non-OK status.
+pub const STATUS_EVENTS: &[&str] = &["nfs4/nfs4_xdr_status", "nfs/nfs_xdr_status"];
+
+#[derive(Args, Debug, Clone)]
+pub struct ErrorCodeArgs {
+    /// Break NFS errors down by status code via tracepoints (requires root)
+    #[arg(long = "error-codes")]
+    pub error_codes: bool,
+}
+
+/// NFSv4 operation numbers from RFC 7530/8881, for kernels that print
+/// `operation=` numerically.
+const NFS4_OPS: &[(u32, &str)] = &[
+    (3, "ACCESS"),
+    (4, "CLOSE"),
+    (5, "COMMIT"),
+    (6, "CREATE"),
+    (7, "DELEGPURGE"),
+    (8, "DELEGRETURN"),
+    (9, "GETATTR"),
+    (10, "GETFH"),
+    (11, "LINK"),
+    (12, "LOCK"),
+    (13, "LOCKT"),
+    (14, "LOCKU"),
+    (15, "LOOKUP"),
+    (16, "LOOKUPP"),
+    (17, "NVERIFY"),
+    (18, "OPEN"),
+    (19, "OPENATTR"),
+    (20, "OPEN_CONFIRM"),
+    (21, "OPEN_DOWNGRADE"),
+    (22, "PUTFH"),
+    (23, "PUTPUBFH"),
+    (24, "PUTROOTFH"),
+    (25, "READ"),
+    (26, "READDIR"),
+    (27, "READLINK"),
+    (28, "REMOVE"),
+    (29, "RENAME"),
+    (30, "RENEW"),
+    (31, "RESTOREFH"),
+    (32, "SAVEFH"),
+    (33, "SECINFO"),
+    (34, "SETATTR"),
+    (35, "SETCLIENTID"),
+    (36, "SETCLIENTID_CONFIRM"),
+    (37, "VERIFY"),
+    (38, "WRITE"),
+    (39, "RELEASE_LOCKOWNER"),
+    (42, "EXCHANGE_ID"),
+    (43, "CREATE_SESSION"),
+    (44, "DESTROY_SESSION"),
+    (47, "GETDEVICEINFO"),
+    (49, "LAYOUTCOMMIT"),
+    (50, "LAYOUTGET"),
+    (51, "LAYOUTRETURN"),
+    (53, "SEQUENCE"),
+    (57, "DESTROY_CLIENTID"),
+    (58, "RECLAIM_COMPLETE"),
+    (59, "ALLOCATE"),
+    (60, "COPY"),
+    (67, "DEALLOCATE"),
+    (68, "IO_ADVISE"),
+];
+
+/// Status names for kernels that only print the numeric error.
+const STATUS_NAMES: &[(i64, &str)] = &[
+    (1, "EPERM"),
+    (2, "ENOENT"),
+    (5, "EIO"),
+    (6, "ENXIO"),
+    (11, "EAGAIN"),
+    (13, "EACCES"),
+    (17, "EEXIST"),
+    (18, "EXDEV"),
+    (20, "ENOTDIR"),
+    (21, "EISDIR"),
+    (22, "EINVAL"),
+    (27, "EFBIG"),
+    (28, "ENOSPC"),
+    (30, "EROFS"),
+    (31, "EMLINK"),
+    (36, "ENAMETOOLONG"),
+    (39, "ENOTEMPTY"),
+    (110, "ETIMEDOUT"),
+    (116, "ESTALE"),
+    (121, "EREMOTEIO"),
+    (122, "EDQUOT"),
+    (521, "EBADHANDLE"),
+    (524, "ENOTSUPP"),
+    (528, "EJUKEBOX"),
+    (10001, "NFS4ERR_BADHANDLE"),
+    (10003, "NFS4ERR_BAD_COOKIE"),
+    (10004, "NFS4ERR_NOTSUPP"),
+    (10006, "NFS4ERR_SERVERFAULT"),
+    (10008, "NFS4ERR_DELAY"),
+    (10010, "NFS4ERR_DENIED"),
+    (10011, "NFS4ERR_EXPIRED"),
+    (10012, "NFS4ERR_LOCKED"),
+    (10013, "NFS4ERR_GRACE"),
+    (10014, "NFS4ERR_FHEXPIRED"),
+    (10015, "NFS4ERR_SHARE_DENIED"),
+    (10016, "NFS4ERR_WRONGSEC"),
+    (10018, "NFS4ERR_RESOURCE"),
+    (10019, "NFS4ERR_MOVED"),
+    (10022, "NFS4ERR_STALE_CLIENTID"),
+    (10023, "NFS4ERR_STALE_STATEID"),
+    (10024, "NFS4ERR_OLD_STATEID"),
+    (10025, "NFS4ERR_BAD_STATEID"),
+    (10026, "NFS4ERR_BAD_SEQID"),
+    (10052, "NFS4ERR_BADSESSION"),
+    (10053, "NFS4ERR_BADSLOT"),
+    (10063, "NFS4ERR_SEQ_MISORDERED"),
+];
+
+/// Readable name for a status. `label` is the symbolic name the kernel
+/// printed in parentheses, if any.
+pub fn status_name(code: i64, label: Option<&str>) -> String {
+    let code = code.abs();
+    match label {
+        Some(label) if code >= 10000 && !label.starts_with("NFS4ERR_") => {
+            format!("NFS4ERR_{}", label)
+        }
+        Some(label) if code < 10000 && !label.starts_with('E') => format!("E{}", label),
+        Some(label) => label.to_string(),
+        None => STATUS_NAMES
+            .iter()
+            .find(|(c, _)| *c == code)
+            .map(|(_, name)| name.to_string())
+            .unwrap_or_else(|| format!("ERR_{}", code)),
+    }
+}
+
+/// Extract `(operation, status)` from an xdr_status record.
+pub fn parse_status(record: &TraceRecord) -> Option<(String, String)> {
+    let payload = &record.payload;
+    let code: i64 = tracefs::field(payload, "error")?.parse().ok()?;
+    if code == 0 {
+        return None;
+    }
+    let label = payload
+        .split_once("error=")
+        .and_then(|(_, rest)| rest.split_once('('))
+        .and_then(|(_, rest)| rest.split_once(')'))
+        .map(|(name, _)| name);
+
+    let operation = match tracefs::field(payload, "operation") {
+        Some(op) => match op.parse::<u32>() {
+            Ok(num) => NFS4_OPS
+                .iter()
+                .find(|(n, _)| *n == num)
+                .map(|(_, name)| name.to_string())
+                .unwrap_or_else(|| format!("OP{}", num)),
+            Err(_) => op.to_uppercase(),
+        },
+        // nfs_xdr_status prints "nfsv3 GETATTR" instead of operation=.
+        None => {
+            let mut tokens = payload.split_whitespace();
+            tokens.find(|t| t.starts_with("nfsv"))?;
+            tokens.next()?.to_uppercase()
+        }
+    };
+
+    Some((operation, status_name(code, label)))
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct ErrorCount {
+    pub operation: String,
+    pub status: String,
+    pub count: u64,
+}
+
+#[derive(Debug, Default)]
+pub struct ErrorBreakdown {
+    counts: HashMap<(String, String), u64>,
+}
+
+impl ErrorBreakdown {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn record(&mut self, record: &TraceRecord) {
+        if let Some(key) = parse_status(record) {
+            *self.counts.entry(key).or_insert(0) += 1;
+        }
+    }
+
+    /// The interval's counts, most frequent first, resetting the tally.
+    pub fn take_interval(&mut self) -> Vec<ErrorCount> {
+        let mut counts: Vec<ErrorCount> = self
+            .counts
+            .drain()
+            .map(|((operation, status), count)| ErrorCount {
+                operation,
+                status,
+                count,
+            })
+            .collect();
+        counts.sort_by(|a, b| {
+            b.count
+                .cmp(&a.count)
+                .then_with(|| a.operation.cmp(&b.operation))
+                .then_with(|| a.status.cmp(&b.status))
+        });
+        counts
+    }
+}
+
+/// Enable every status tracepoint available on this kernel; they are
+/// switched off when the returned events are dropped.
+pub fn enable_status_events(root: &str) -> io::Result<HeldEvents> {
+    tracefs::enable_events(root, STATUS_EVENTS)
+}
+
+pub fn display_error_breakdown<W: Write>(writer: &mut W, counts: &[ErrorCount]) -> io::Result<()> {
+    if counts.is_empty() {
+        return Ok(());
+    }
+
+    writeln!(writer, "{:<16} {:<24} {:>8}", "OP", "STATUS", "COUNT")?;
+    writeln!(writer, "{}", "-".repeat(50))?;
+    for count in counts {
+        writeln!(
+            writer,
+            "{:<16} {:<24} {:>8}",
+            count.operation, count.status, count.count
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::tracefs::parse_record;
+
+    #[test]
+    fn test_parse_status_variants() {
+        let v4 = parse_record(
+            "kworker-1 [000] ..... 1.0: nfs4_xdr_status: task:00000001@00000002 xid=0x1 error=-10008 (DELAY) operation=9",
+        )
+        .unwrap();
+        assert_eq!(
+            parse_status(&v4),
+            Some(("GETATTR".to_string(), "NFS4ERR_DELAY".to_string()))
+        );
+
+        let v3 = parse_record(
+            "cp-77 [001] ..... 2.0: nfs_xdr_status: task:00000001@00000002 xid=0x2 nfsv3 LOOKUP error=-116 (STALE)",
+        )
+        .unwrap();
+        assert_eq!(
+            parse_status(&v3),
+            Some(("LOOKUP".to_string(), "ESTALE".to_string()))
+        );
+
+        let bare =
+            parse_record("ls-5 [001] ..... 3.0: nfs4_xdr_status: error=-13 operation=3").unwrap();
+        assert_eq!(
+            parse_status(&bare),
+            Some(("ACCESS".to_string(), "EACCES".to_string()))
+        );
+
+        let ok =
+            parse_record("ls-5 [001] ..... 3.0: nfs4_xdr_status: error=0 operation=3").unwrap();
+        assert_eq!(parse_status(&ok), None);
+    }
+
+    #[test]
+    fn test_breakdown_ordering() {
+        let mut breakdown = ErrorBreakdown::new();
+        for line in [
+            "a-1 [000] ..... 1.0: nfs4_xdr_status: error=-10008 (DELAY) operation=25",
+            "a-1 [000] ..... 1.1: nfs4_xdr_status: error=-10008 (DELAY) operation=25",
+            "a-1 [000] ..... 1.2: nfs4_xdr_status: error=-13 (ACCES) operation=18",
+        ] {
+            breakdown.record(&parse_record(line).unwrap());
+        }
+
+        let counts = breakdown.take_interval();
+        assert_eq!(counts.len(), 2);
+        assert_eq!(counts[0].operation, "READ");
+        assert_eq!(counts[0].status, "NFS4ERR_DELAY");
+        assert_eq!(counts[0].count, 2);
+        assert_eq!(counts[1].status, "EACCES");
+        assert!(breakdown.take_interval().is_empty());
+    }
+}
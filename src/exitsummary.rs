@@ -0,0 +1,144 @@
+//! Ping-style statistics per mount, printed when monitoring stops on
+//! Ctrl-C or after `--count` intervals.
+
+use crate::session::{IntervalSample, MountSession, Session};
+use crate::units::human_bytes;
+use clap::Args;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct ExitSummaryArgs {
+    /// Do not print per-mount statistics when monitoring stops
+    #[arg(long = "no-exit-summary")]
+    pub no_exit_summary: bool,
+}
+
+/// min/avg/max of one latency over a mount's active intervals.
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct LatencyRange {
+    pub min: f64,
+    pub avg: f64,
+    pub max: f64,
+}
+
+impl LatencyRange {
+    /// `avg` is the op-weighted whole-run average; min and max are taken
+    /// over interval averages, skipping intervals that completed nothing.
+    fn over(mount: &MountSession, avg: f64, field: impl Fn(&IntervalSample) -> f64) -> Self {
+        let active = mount.samples.iter().filter(|s| s.iops > 0.0).map(field);
+        let (min, max) = active.fold((f64::INFINITY, 0.0f64), |(lo, hi), v| {
+            (lo.min(v), hi.max(v))
+        });
+        Self {
+            min: if min.is_finite() { min } else { 0.0 },
+            avg,
+            max,
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct MountSummary {
+    pub mount_point: String,
+    pub intervals: u64,
+    pub elapsed_secs: f64,
+    pub ops: i64,
+    pub bytes: i64,
+    pub retrans: i64,
+    pub errors: i64,
+    pub rtt: LatencyRange,
+    pub exec: LatencyRange,
+}
+
+pub fn summarize_mount(mount: &MountSession) -> MountSummary {
+    let ops = mount.total_ops();
+    let per_op = |total: i64| {
+        if ops > 0 {
+            total as f64 / ops as f64
+        } else {
+            0.0
+        }
+    };
+    let rtt: i64 = mount.ops.values().map(|o| o.rtt).sum();
+    let exec: i64 = mount.ops.values().map(|o| o.exec).sum();
+    MountSummary {
+        mount_point: mount.mount_point.clone(),
+        intervals: mount.intervals,
+        elapsed_secs: mount.elapsed_secs,
+        ops,
+        bytes: mount.ops.values().map(|o| o.bytes).sum(),
+        retrans: mount.total_retrans(),
+        errors: mount.ops.values().map(|o| o.errors).sum(),
+        rtt: LatencyRange::over(mount, per_op(rtt), |s| s.avg_rtt),
+        exec: LatencyRange::over(mount, per_op(exec), |s| s.avg_exec),
+    }
+}
+
+pub fn display_exit_summary<W: Write>(writer: &mut W, session: &Session) -> io::Result<()> {
+    for mount in session.mounts.values() {
+        let s = summarize_mount(mount);
+        writeln!(writer)?;
+        writeln!(writer, "--- {} nfs-gaze statistics ---", s.mount_point)?;
+        writeln!(
+            writer,
+            "{} intervals over {:.1}s, {} ops, {} transferred, {} retrans, {} errors",
+            s.intervals,
+            s.elapsed_secs,
+            s.ops,
+            human_bytes(s.bytes as f64),
+            s.retrans,
+            s.errors
+        )?;
+        for (label, range) in [("rtt", s.rtt), ("exec", s.exec)] {
+            writeln!(
+                writer,
+                "{} min/avg/max = {:.3}/{:.3}/{:.3} ms",
+                label, range.min, range.avg, range.max
+            )?;
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use chrono::{Duration, Utc};
+
+    #[test]
+    fn test_exit_summary() {
+        let start = Utc::now();
+        let mut session = Session::new(start);
+        let mut read = stat("READ", 100, 2.0);
+        read.delta_errors = 1;
+        session.record("/mnt", start + Duration::seconds(1), 1.0, &[read]);
+        let mut idle = stat("READ", 0, 0.0);
+        idle.delta_retrans = 2;
+        session.record("/mnt", start + Duration::seconds(2), 1.0, &[idle]);
+        session.record(
+            "/mnt",
+            start + Duration::seconds(3),
+            1.0,
+            &[stat("READ", 300, 6.0)],
+        );
+
+        let summary = summarize_mount(&session.mounts["/mnt"]);
+        assert_eq!(summary.ops, 400);
+        assert_eq!(summary.bytes, 400 * 4096);
+        assert_eq!(summary.retrans, 2);
+        assert_eq!(summary.errors, 1);
+        // The idle interval does not drag the minimum to zero.
+        assert_eq!(summary.rtt.min, 2.0);
+        assert_eq!(summary.rtt.max, 6.0);
+        assert!((summary.rtt.avg - 5.0).abs() < 1e-9);
+
+        let mut out = Vec::new();
+        display_exit_summary(&mut out, &session).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.contains("--- /mnt nfs-gaze statistics ---\n"));
+        assert!(text
+            .contains("3 intervals over 3.0s, 400 ops, 1.6M transferred, 2 retrans, 1 errors\n"));
+        assert!(text.contains("rtt min/avg/max = 2.000/5.000/6.000 ms\n"));
+    }
+}
@@ -0,0 +1,590 @@
+//! Built-in Prometheus exporter: `--listen :9099` serves `/metrics` in the
+//! text exposition format, read fresh from mountstats on every scrape.
+//!
+//! mountstats counters are already cumulative, which is what Prometheus
+//! expects, so the exporter keeps no state between scrapes and needs no
+//! optional dependencies. It can stand in for node-exporter's mountstats
+//! collector. When it runs alongside the live display, the session's
+//! per-interval latency percentiles are exported as summaries too.
+
+use crate::labels::Labels;
+use crate::ordering::{sort_mounts, sorted_operations};
+use crate::parser::parse_mountstats;
+use crate::quantile::{Quantiles, REPORTED};
+use crate::sections::{read_sections, MountSection};
+use crate::selection::MountSelector;
+use crate::session::{OpTotals, Session};
+use crate::types::NFSMount;
+use crate::xprt::{transports, NFSTransport};
+use clap::Args;
+use std::fmt::Write as _;
+use std::io::{self, BufRead, BufReader, Read, Write};
+use std::net::{SocketAddr, TcpListener, TcpStream, ToSocketAddrs};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::thread;
+use std::time::Duration;
+
+#[derive(Args, Debug, Clone)]
+pub struct ExporterArgs {
+    /// Serve Prometheus metrics on ADDR (e.g. :9099 or 127.0.0.1:9099)
+    #[arg(long = "listen", value_name = "ADDR", value_parser = parse_listen)]
+    pub listen: Option<SocketAddr>,
+
+    /// Serve Prometheus metrics on localhost (same as --listen 127.0.0.1:PORT)
+    #[arg(long = "prometheus", conflicts_with = "listen")]
+    pub prometheus: bool,
+
+    /// Port for --prometheus
+    #[arg(long = "prometheus-port", value_name = "PORT", default_value_t = 9090)]
+    pub prometheus_port: u16,
+}
+
+impl ExporterArgs {
+    /// Where to serve metrics, if anywhere.
+    pub fn addr(&self) -> Option<SocketAddr> {
+        if self.prometheus {
+            return Some(SocketAddr::from(([127, 0, 0, 1], self.prometheus_port)));
+        }
+        self.listen
+    }
+}
+
+/// Accept `:port` as shorthand for all interfaces.
+pub fn parse_listen(s: &str) -> Result<SocketAddr, String> {
+    let full = match s.strip_prefix(':') {
+        Some(port) => format!("0.0.0.0:{}", port),
+        None => s.to_string(),
+    };
+    full.to_socket_addrs()
+        .ok()
+        .and_then(|mut addrs| addrs.next())
+        .ok_or_else(|| format!("invalid listen address '{}'", s))
+}
+
+/// Escape a label value per the exposition format.
+fn escape_label(value: &str) -> String {
+    value
+        .replace('\\', "\\\\")
+        .replace('"', "\\\"")
+        .replace('\n', "\\n")
+}
+
+/// `{k="v",...}` for the series' own `pairs` followed by the static
+/// labels. A static label whose name the series already uses is renamed
+/// `exported_<name>`, as Prometheus does for clashing target labels, so
+/// no series carries the same label twice.
+pub(crate) fn label_set(pairs: &[(&str, &str)], labels: &Labels) -> String {
+    let mut out = String::from("{");
+    for (i, (key, value)) in pairs.iter().enumerate() {
+        if i > 0 {
+            out.push(',');
+        }
+        let _ = write!(out, "{}=\"{}\"", key, escape_label(value));
+    }
+    for (key, value) in labels.iter() {
+        if out.len() > 1 {
+            out.push(',');
+        }
+        let prefix = if pairs.iter().any(|(k, _)| *k == key) {
+            "exported_"
+        } else {
+            ""
+        };
+        let _ = write!(out, "{}{}=\"{}\"", prefix, key, escape_label(value));
+    }
+    out.push('}');
+    out
+}
+
+type OpField = fn(&crate::types::NFSOperation) -> i64;
+
+const OP_METRICS: &[(&str, &str, OpField)] = &[
+    ("nfs_operations_total", "NFS operations completed", |o| {
+        o.ops
+    }),
+    (
+        "nfs_operation_transmissions_total",
+        "RPC transmissions, including retransmissions",
+        |o| o.ntrans,
+    ),
+    ("nfs_operation_retrans_total", "RPC retransmissions", |o| {
+        o.ntrans - o.ops
+    }),
+    ("nfs_operation_timeouts_total", "Major timeouts", |o| {
+        o.timeouts
+    }),
+    (
+        "nfs_operation_bytes_sent_total",
+        "Bytes sent, including RPC headers",
+        |o| o.bytes_sent,
+    ),
+    (
+        "nfs_operation_bytes_received_total",
+        "Bytes received, including RPC headers",
+        |o| o.bytes_recv,
+    ),
+    (
+        "nfs_operation_queue_milliseconds_total",
+        "Milliseconds queued before transmission",
+        |o| o.queue_time,
+    ),
+    (
+        "nfs_operation_rtt_milliseconds_total",
+        "Milliseconds waiting for server replies",
+        |o| o.rtt,
+    ),
+    (
+        "nfs_operation_execute_milliseconds_total",
+        "Milliseconds from submission to completion",
+        |o| o.execute_time,
+    ),
+    (
+        "nfs_operation_errors_total",
+        "Operations that completed with an error",
+        |o| o.errors,
+    ),
+];
+
+type MountField = fn(&NFSMount) -> i64;
+
+const MOUNT_METRICS: &[(&str, &str, &str, MountField)] = &[
+    (
+        "nfs_mount_age_seconds",
+        "gauge",
+        "Seconds since the mount was established",
+        |m| m.age,
+    ),
+    (
+        "nfs_mount_bytes_read_total",
+        "counter",
+        "Bytes read by applications",
+        |m| m.bytes_read,
+    ),
+    (
+        "nfs_mount_bytes_written_total",
+        "counter",
+        "Bytes written by applications",
+        |m| m.bytes_write,
+    ),
+];
+
+/// Render `mounts` as Prometheus text. Mounts and operations are ordered
+/// so consecutive scrapes diff cleanly.
+pub fn render_metrics(mounts: &[NFSMount], labels: &Labels) -> String {
+    let mut mounts = mounts.to_vec();
+    sort_mounts(&mut mounts);
+    let mut out = String::new();
+
+    for (name, kind, help, field) in MOUNT_METRICS {
+        let _ = writeln!(out, "# HELP {} {}", name, help);
+        let _ = writeln!(out, "# TYPE {} {}", name, kind);
+        for mount in &mounts {
+            let set = label_set(
+                &[
+                    ("mount_point", &mount.mount_point),
+                    ("server", &mount.server),
+                ],
+                labels,
+            );
+            let _ = writeln!(out, "{}{} {}", name, set, field(mount));
+        }
+    }
+    for (name, help, field) in OP_METRICS {
+        let _ = writeln!(out, "# HELP {} {}", name, help);
+        let _ = writeln!(out, "# TYPE {} counter", name);
+        for mount in &mounts {
+            for op in sorted_operations(mount) {
+                let set = label_set(
+                    &[
+                        ("mount_point", &mount.mount_point),
+                        ("server", &mount.server),
+                        ("operation", &op.name),
+                    ],
+                    labels,
+                );
+                let _ = writeln!(out, "{}{} {}", name, set, field(op).max(0));
+            }
+        }
+    }
+    out
+}
+
+type TransportField = fn(&NFSTransport) -> Option<u64>;
+
+const TRANSPORT_METRICS: &[(&str, &str, &str, TransportField)] = &[
+    ("nfs_transport_binds_total", "counter", "Port binds", |t| {
+        Some(t.bind_count)
+    }),
+    (
+        "nfs_transport_connects_total",
+        "counter",
+        "Connection establishments",
+        |t| Some(t.connect_count),
+    ),
+    (
+        "nfs_transport_idle_seconds",
+        "gauge",
+        "Seconds since the transport was last used",
+        |t| Some(t.idle_time),
+    ),
+    (
+        "nfs_transport_sends_total",
+        "counter",
+        "RPC requests sent",
+        |t| Some(t.sends),
+    ),
+    (
+        "nfs_transport_receives_total",
+        "counter",
+        "RPC replies received",
+        |t| Some(t.recvs),
+    ),
+    (
+        "nfs_transport_bad_xids_total",
+        "counter",
+        "Replies with an unknown XID",
+        |t| Some(t.bad_xids),
+    ),
+    (
+        "nfs_transport_requests_in_flight_sum_total",
+        "counter",
+        "Requests in flight, summed over sends",
+        |t| Some(t.req_u),
+    ),
+    (
+        "nfs_transport_backlog_sum_total",
+        "counter",
+        "Backlog queue length, summed over sends",
+        |t| Some(t.bklog_u),
+    ),
+    (
+        "nfs_transport_max_slots",
+        "gauge",
+        "Size of the RPC slot table",
+        |t| t.max_slots,
+    ),
+];
+
+/// Render transport statistics from the raw `xprt:` lines.
+pub fn render_transport_metrics(sections: &[MountSection], labels: &Labels) -> String {
+    let mut out = String::new();
+    for (name, kind, help, field) in TRANSPORT_METRICS {
+        let _ = writeln!(out, "# HELP {} {}", name, help);
+        let _ = writeln!(out, "# TYPE {} {}", name, kind);
+        for section in sections {
+            for t in transports(section) {
+                let Some(value) = field(&t) else {
+                    continue;
+                };
+                let port = t.port.to_string();
+                let set = label_set(
+                    &[
+                        ("mount_point", &section.mount_point),
+                        ("protocol", &t.protocol),
+                        ("port", &port),
+                    ],
+                    labels,
+                );
+                let _ = writeln!(out, "{}{} {}", name, set, value);
+            }
+        }
+    }
+    out
+}
+
+type QuantileField = fn(&OpTotals) -> &Quantiles;
+
+const QUANTILE_METRICS: &[(&str, &str, QuantileField)] = &[
+    (
+        "nfs_operation_interval_rtt_milliseconds",
+        "Per-interval average RTT over the session",
+        |o| &o.rtt_quantiles,
+    ),
+    (
+        "nfs_operation_interval_execute_milliseconds",
+        "Per-interval average execute time over the session",
+        |o| &o.exec_quantiles,
+    ),
+];
+
+/// Render the session's per-interval latency distributions as summaries.
+pub fn render_quantile_metrics(session: &Session, labels: &Labels) -> String {
+    let mut out = String::new();
+    for (name, help, field) in QUANTILE_METRICS {
+        let _ = writeln!(out, "# HELP {} {}", name, help);
+        let _ = writeln!(out, "# TYPE {} summary", name);
+        for mount in session.mounts.values() {
+            for (operation, op) in &mount.ops {
+                let quantiles = field(op);
+                if quantiles.is_empty() {
+                    continue;
+                }
+                let pairs = [
+                    ("mount_point", mount.mount_point.as_str()),
+                    ("operation", operation.as_str()),
+                ];
+                for q in REPORTED {
+                    let quantile = q.to_string();
+                    let mut with_quantile = pairs.to_vec();
+                    with_quantile.push(("quantile", &quantile));
+                    let set = label_set(&with_quantile, labels);
+                    let _ = writeln!(out, "{}{} {}", name, set, quantiles.quantile(q));
+                }
+                let set = label_set(&pairs, labels);
+                let _ = writeln!(out, "{}_sum{} {}", name, set, quantiles.sum());
+                let _ = writeln!(out, "{}_count{} {}", name, set, quantiles.count());
+            }
+        }
+    }
+    out
+}
+
+pub(crate) fn respond(
+    stream: &mut TcpStream,
+    status: &str,
+    content_type: &str,
+    body: &str,
+) -> io::Result<()> {
+    write!(
+        stream,
+        "HTTP/1.1 {}\r\nContent-Type: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{}",
+        status,
+        content_type,
+        body.len(),
+        body
+    )?;
+    stream.flush()
+}
+
+/// Largest request body accepted; pushed intervals are a few kB.
+const MAX_BODY: usize = 8 << 20;
+
+/// An HTTP request with its body, if it declared a Content-Length.
+#[derive(Debug, Default)]
+pub(crate) struct Request {
+    pub method: String,
+    pub target: String,
+    pub headers: Vec<(String, String)>,
+    pub body: Vec<u8>,
+}
+
+impl Request {
+    /// Value of header `name`, compared case-insensitively.
+    pub fn header(&self, name: &str) -> Option<&str> {
+        self.headers
+            .iter()
+            .find(|(k, _)| k.eq_ignore_ascii_case(name))
+            .map(|(_, v)| v.as_str())
+    }
+}
+
+/// Read the request line, headers and any Content-Length body.
+pub(crate) fn read_request(stream: &TcpStream) -> io::Result<Request> {
+    stream.set_read_timeout(Some(Duration::from_secs(5)))?;
+    let mut reader = BufReader::new(stream);
+    let mut line = String::new();
+    reader.read_line(&mut line)?;
+    let mut parts = line.split_whitespace();
+    let mut request = Request {
+        method: parts.next().unwrap_or("").to_string(),
+        target: parts.next().unwrap_or("").to_string(),
+        headers: Vec::new(),
+        body: Vec::new(),
+    };
+    loop {
+        line.clear();
+        if reader.read_line(&mut line)? == 0 {
+            break;
+        }
+        let Some((key, value)) = line.trim_end().split_once(':') else {
+            break;
+        };
+        request
+            .headers
+            .push((key.trim().to_string(), value.trim().to_string()));
+    }
+    if let Some(len) = request
+        .header("Content-Length")
+        .and_then(|v| v.parse::<usize>().ok())
+    {
+        if len > MAX_BODY {
+            return Err(io::Error::new(
+                io::ErrorKind::InvalidData,
+                "request body too large",
+            ));
+        }
+        request.body.resize(len, 0);
+        reader.read_exact(&mut request.body)?;
+    }
+    Ok(request)
+}
+
+fn handle(
+    mut stream: TcpStream,
+    path: &str,
+    selector: &MountSelector,
+    labels: &Labels,
+    session: Option<&Mutex<Session>>,
+) -> io::Result<()> {
+    let request = read_request(&stream)?;
+    match (request.method.as_str(), request.target.as_str()) {
+        ("GET", "/metrics") => match parse_mountstats(path) {
+            Ok(mut mounts) => {
+                let mut sections = read_sections(path).unwrap_or_default();
+                mounts.retain(|m| selector.matches(&m.mount_point));
+                sections.retain(|s| selector.matches(&s.mount_point));
+                let mut body = render_metrics(&mounts, labels);
+                body.push_str(&render_transport_metrics(&sections, labels));
+                if let Some(Ok(session)) = session.map(Mutex::lock) {
+                    body.push_str(&render_quantile_metrics(&session, labels));
+                }
+                respond(&mut stream, "200 OK", "text/plain; version=0.0.4", &body)
+            }
+            Err(e) => respond(
+                &mut stream,
+                "500 Internal Server Error",
+                "text/plain",
+                &format!("{}\n", e),
+            ),
+        },
+        ("GET", "/") => respond(
+            &mut stream,
+            "200 OK",
+            "text/html",
+            "<html><body><a href=\"/metrics\">metrics</a></body></html>\n",
+        ),
+        _ => respond(&mut stream, "404 Not Found", "text/plain", "not found\n"),
+    }
+}
+
+/// Serve scrapes until `running` is cleared. Requests are handled one at
+/// a time; a scrape costs one mountstats parse. `session`, when given, is
+/// the live loop's session and adds latency percentile summaries.
+pub fn serve(
+    listener: TcpListener,
+    path: &str,
+    selector: &MountSelector,
+    labels: &Labels,
+    session: Option<&Mutex<Session>>,
+    running: &AtomicBool,
+) -> io::Result<()> {
+    listener.set_nonblocking(true)?;
+    while running.load(Ordering::SeqCst) {
+        match listener.accept() {
+            Ok((stream, _)) => {
+                stream.set_nonblocking(false)?;
+                if let Err(e) = handle(stream, path, selector, labels, session) {
+                    eprintln!("Warning: metrics request failed: {}", e);
+                }
+            }
+            Err(e) if e.kind() == io::ErrorKind::WouldBlock => {
+                thread::sleep(Duration::from_millis(100));
+            }
+            Err(e) => return Err(e),
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::types::NFSOperation;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_parse_listen() {
+        assert_eq!(
+            parse_listen(":9099").unwrap(),
+            "0.0.0.0:9099".parse().unwrap()
+        );
+        assert_eq!(
+            parse_listen("127.0.0.1:8080").unwrap(),
+            "127.0.0.1:8080".parse().unwrap()
+        );
+        assert!(parse_listen("9099").is_err());
+    }
+
+    #[test]
+    fn test_label_set_renames_clashes() {
+        let mut labels = Labels::new();
+        labels.add("server=override").unwrap();
+        labels.add("env=prod").unwrap();
+        assert_eq!(
+            label_set(&[("server", "filer")], &labels),
+            "{server=\"filer\",env=\"prod\",exported_server=\"override\"}"
+        );
+        assert_eq!(
+            label_set(&[], &labels),
+            "{env=\"prod\",server=\"override\"}"
+        );
+    }
+
+    #[test]
+    fn test_render_metrics() {
+        let read = NFSOperation {
+            name: "READ".to_string(),
+            ops: 100,
+            ntrans: 103,
+            rtt: 250,
+            ..NFSOperation::default()
+        };
+        let mount = NFSMount {
+            device: "filer:/vol".to_string(),
+            mount_point: "/mnt/\"q\"".to_string(),
+            server: "filer".to_string(),
+            export: "/vol".to_string(),
+            age: 42,
+            operations: HashMap::from([("READ".to_string(), read)]),
+            events: None,
+            bytes_read: 4096,
+            bytes_write: 0,
+        };
+        let mut labels = Labels::new();
+        labels.add("env=prod").unwrap();
+        let text = render_metrics(&[mount], &labels);
+
+        assert!(text.contains("# TYPE nfs_operations_total counter\n"));
+        assert!(text.contains("# TYPE nfs_mount_age_seconds gauge\n"));
+        assert!(text.contains(
+            "nfs_operations_total{mount_point=\"/mnt/\\\"q\\\"\",server=\"filer\",operation=\"READ\",env=\"prod\"} 100\n"
+        ));
+        assert!(text.contains("nfs_operation_retrans_total{"));
+        assert!(text.contains("nfs_operation_rtt_milliseconds_total{mount_point=\"/mnt/\\\"q\\\"\",server=\"filer\",operation=\"READ\",env=\"prod\"} 250\n"));
+        assert!(text.contains("nfs_mount_bytes_read_total{mount_point=\"/mnt/\\\"q\\\"\",server=\"filer\",env=\"prod\"} 4096\n"));
+    }
+
+    #[test]
+    fn test_render_transport_metrics() {
+        use crate::sections::{parse_sections, tests::MOUNTSTATS};
+        let text = render_transport_metrics(&parse_sections(MOUNTSTATS), &Labels::new());
+        assert!(text.contains(
+            "nfs_transport_sends_total{mount_point=\"/mnt/nfs\",protocol=\"tcp\",port=\"870\"} 800\n"
+        ));
+        assert!(text.contains("# TYPE nfs_transport_max_slots gauge\n"));
+    }
+
+    #[test]
+    fn test_render_quantile_metrics() {
+        use crate::aggregate::tests::stat;
+        use chrono::Utc;
+
+        let now = Utc::now();
+        let mut session = Session::new(now);
+        for rtt in [1.0, 2.0, 3.0, 40.0] {
+            session.record("/mnt", now, 1.0, &[stat("READ", 10, rtt)]);
+        }
+        session.record("/mnt", now, 1.0, &[stat("GETATTR", 0, 0.0)]);
+        let text = render_quantile_metrics(&session, &Labels::new());
+
+        assert!(text.contains("# TYPE nfs_operation_interval_rtt_milliseconds summary\n"));
+        assert!(text.contains(
+            "nfs_operation_interval_rtt_milliseconds{mount_point=\"/mnt\",operation=\"READ\",quantile=\"0.99\"} 40\n"
+        ));
+        assert!(text.contains(
+            "nfs_operation_interval_execute_milliseconds_count{mount_point=\"/mnt\",operation=\"READ\"} 4\n"
+        ));
+        assert!(!text.contains("GETATTR"));
+    }
+}
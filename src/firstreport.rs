@@ -0,0 +1,109 @@
+//! What the first report shows. Shared by simple and nfsiostat-style
+//! output so both behave the same way.
+//!
+//! - `delta` (default): take a baseline, wait one interval, report a true
+//!   delta.
+//! - `cumulative`: report immediately with totals since mount, as
+//!   nfsiostat does.
+//! - `skip`: like `delta`, but also discard the first interval as warm-up.
+
+use crate::types::{NFSMount, NFSOperation};
+use clap::{Args, ValueEnum};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, ValueEnum)]
+pub enum FirstReport {
+    #[default]
+    Delta,
+    Cumulative,
+    Skip,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct FirstReportArgs {
+    /// First report: delta (after one interval), cumulative (since mount), or skip
+    #[arg(long = "first-report", value_enum, default_value_t = FirstReport::Delta)]
+    pub first_report: FirstReport,
+}
+
+impl FirstReport {
+    /// Whether a report is printed straight away, before the first sleep.
+    pub fn immediate(self) -> bool {
+        self == FirstReport::Cumulative
+    }
+
+    /// Whether the report for interval `index` (0-based, counting only
+    /// reports computed from two real snapshots) should be printed.
+    pub fn should_print(self, index: u64) -> bool {
+        !(self == FirstReport::Skip && index == 0)
+    }
+}
+
+/// A copy of `mount` with every counter zeroed. Diffing against it yields
+/// totals since mount; use the mount's age as the interval length.
+pub fn zero_baseline(mount: &NFSMount) -> NFSMount {
+    NFSMount {
+        age: 0,
+        operations: mount
+            .operations
+            .keys()
+            .map(|name| {
+                (
+                    name.clone(),
+                    NFSOperation {
+                        name: name.clone(),
+                        ..Default::default()
+                    },
+                )
+            })
+            .collect(),
+        events: None,
+        bytes_read: 0,
+        bytes_write: 0,
+        ..mount.clone()
+    }
+}
+
+/// Interval length for a cumulative first report: the mount's age, never
+/// less than one second.
+pub fn cumulative_interval_secs(mount: &NFSMount) -> f64 {
+    mount.age.max(1) as f64
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::delta::mount_delta;
+    use crate::testutil;
+
+    fn mount() -> NFSMount {
+        let read = NFSOperation {
+            ops: 500,
+            ntrans: 500,
+            rtt: 1000,
+            ..testutil::op("READ")
+        };
+        NFSMount {
+            age: 100,
+            bytes_read: 4096,
+            ..testutil::with_ops(testutil::mount("/mnt"), [read])
+        }
+    }
+
+    #[test]
+    fn test_modes() {
+        assert!(FirstReport::Cumulative.immediate());
+        assert!(!FirstReport::Delta.immediate());
+        assert!(FirstReport::Delta.should_print(0));
+        assert!(!FirstReport::Skip.should_print(0));
+        assert!(FirstReport::Skip.should_print(1));
+    }
+
+    #[test]
+    fn test_cumulative_baseline() {
+        let m = mount();
+        let stats = mount_delta(&zero_baseline(&m), &m, cumulative_interval_secs(&m));
+        assert_eq!(stats[0].delta_ops, 500);
+        assert!((stats[0].iops - 5.0).abs() < 1e-9);
+        assert!((stats[0].avg_rtt - 2.0).abs() < 1e-9);
+    }
+}
@@ -0,0 +1,405 @@
+//! `nfs-gaze fleet HOST...`: sample mountstats on many hosts over SSH and
+//! show one merged table grouped by NFS server, so a filer's load and
+//! latency can be read across a whole cluster at once.
+//!
+//! Nothing needs installing remotely: each interval runs `cat` on the
+//! remote mountstats and parses it locally.
+
+use crate::aggregate::total_stats;
+use crate::parser::parse_mountstats_str;
+use crate::sampling::Interval;
+use crate::selection::MountSelector;
+use crate::tracker::{MountInterval, MountTracker};
+use crate::types::{NfsGazeError, Result};
+use crate::units::{format_kb_rate, format_ops};
+use chrono::{DateTime, Utc};
+use clap::Args;
+use std::collections::BTreeMap;
+use std::fs;
+use std::io::{self, Write};
+use std::process::Command;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::thread;
+use std::time::{Duration, Instant};
+
+#[derive(Args, Debug, Clone)]
+pub struct FleetArgs {
+    /// Hosts to sample, as accepted by ssh (user@host works)
+    #[arg(value_name = "HOST")]
+    pub hosts: Vec<String>,
+
+    /// Read more hosts from FILE, one per line; # starts a comment
+    #[arg(long = "hosts-file", value_name = "FILE")]
+    pub hosts_file: Option<String>,
+
+    /// Time between samples: seconds, or with a unit (500ms, 2s, 1m)
+    #[arg(short = 'i', long = "interval", default_value = "5")]
+    pub interval: Interval,
+
+    /// Number of intervals to show (0 = until interrupted)
+    #[arg(short = 'c', long = "count", default_value = "0")]
+    pub count: u64,
+
+    /// ssh command to run
+    #[arg(long = "ssh", default_value = "ssh")]
+    pub ssh: String,
+
+    /// mountstats path on the remote hosts
+    #[arg(long = "remote-path", default_value = "/proc/self/mountstats")]
+    pub remote_path: String,
+
+    /// Auto-scale sizes and counts
+    #[arg(short = 'H', long = "human")]
+    pub human: bool,
+}
+
+/// Hosts from a hosts file: one per line, blank lines and comments skipped.
+pub fn parse_hosts(contents: &str) -> Vec<String> {
+    contents
+        .lines()
+        .map(|line| line.split('#').next().unwrap_or("").trim())
+        .filter(|line| !line.is_empty())
+        .map(str::to_string)
+        .collect()
+}
+
+/// Hosts from the command line followed by those in `--hosts-file`,
+/// without duplicates.
+pub fn fleet_hosts(args: &FleetArgs) -> Result<Vec<String>> {
+    let mut hosts = args.hosts.clone();
+    if let Some(path) = &args.hosts_file {
+        let contents = fs::read_to_string(path).map_err(NfsGazeError::MountstatsRead)?;
+        hosts.extend(parse_hosts(&contents));
+    }
+    // ssh would take such a "host" as an option, e.g. -oProxyCommand=.
+    if let Some(host) = hosts.iter().find(|h| h.starts_with('-')) {
+        return Err(NfsGazeError::ParseError(format!(
+            "fleet: invalid host {:?}",
+            host
+        )));
+    }
+    let mut seen = std::collections::HashSet::new();
+    hosts.retain(|h| seen.insert(h.clone()));
+    if hosts.is_empty() {
+        return Err(NfsGazeError::ParseError(
+            "fleet: no hosts given".to_string(),
+        ));
+    }
+    Ok(hosts)
+}
+
+/// `s` as a single word for a POSIX shell.
+fn shell_quote(s: &str) -> String {
+    format!("'{}'", s.replace('\'', r"'\''"))
+}
+
+/// Read the remote mountstats. BatchMode keeps a host that wants a
+/// password from hanging the whole fleet. ssh hands the command to the
+/// remote shell as one string, so the path is quoted.
+pub fn fetch(ssh: &str, host: &str, remote_path: &str) -> io::Result<String> {
+    let output = Command::new(ssh)
+        .args(["-o", "BatchMode=yes", "-o", "ConnectTimeout=5", "--"])
+        .arg(host)
+        .args(["cat", "--", &shell_quote(remote_path)])
+        .output()?;
+    if !output.status.success() {
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        return Err(io::Error::other(format!(
+            "ssh {} failed: {}",
+            host,
+            stderr.trim()
+        )));
+    }
+    String::from_utf8(output.stdout).map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e))
+}
+
+/// One host's traffic to one server in an interval.
+#[derive(Debug, Clone, PartialEq)]
+pub struct FleetRow {
+    pub server: String,
+    pub host: String,
+    pub mounts: usize,
+    pub iops: f64,
+    pub kb_per_sec: f64,
+    pub avg_rtt: f64,
+    pub avg_exec: f64,
+    pub retrans: i64,
+    pub errors: i64,
+}
+
+/// Rows grouped by server, then host. Averages are op-weighted across
+/// each host's mounts of that server.
+pub fn fleet_rows(samples: &[(String, Vec<MountInterval>)]) -> Vec<FleetRow> {
+    let mut groups: BTreeMap<(String, String), Vec<&MountInterval>> = BTreeMap::new();
+    for (host, intervals) in samples {
+        for interval in intervals {
+            groups
+                .entry((interval.mount.server.clone(), host.clone()))
+                .or_default()
+                .push(interval);
+        }
+    }
+    groups
+        .into_iter()
+        .map(|((server, host), intervals)| {
+            let total = total_stats("", intervals.iter().flat_map(|i| i.stats.iter()));
+            FleetRow {
+                server,
+                host,
+                mounts: intervals.len(),
+                iops: total.iops,
+                kb_per_sec: total.kb_per_sec,
+                avg_rtt: total.avg_rtt,
+                avg_exec: total.avg_exec,
+                retrans: total.delta_retrans,
+                errors: total.delta_errors,
+            }
+        })
+        .collect()
+}
+
+pub fn display_fleet<W: Write>(
+    writer: &mut W,
+    timestamp: &DateTime<Utc>,
+    rows: &[FleetRow],
+    failures: &[(String, String)],
+    human: bool,
+) -> io::Result<()> {
+    writeln!(
+        writer,
+        "Fleet at {}",
+        timestamp.format("%Y-%m-%d %H:%M:%S UTC")
+    )?;
+    writeln!(writer)?;
+    let mut server: Option<&str> = None;
+    for row in rows {
+        if server != Some(row.server.as_str()) {
+            let hosts: Vec<&FleetRow> = rows.iter().filter(|r| r.server == row.server).collect();
+            let iops: f64 = hosts.iter().map(|r| r.iops).sum();
+            let kb: f64 = hosts.iter().map(|r| r.kb_per_sec).sum();
+            writeln!(
+                writer,
+                "{}: {} hosts, {} IOPS, {} MB/s",
+                row.server,
+                hosts.len(),
+                format_ops(iops, human),
+                format_kb_rate(kb, human)
+            )?;
+            writeln!(
+                writer,
+                "  {:<24} {:>6} {:>10} {:>10} {:>10} {:>10} {:>8} {:>8}",
+                "HOST",
+                "MOUNTS",
+                "IOPS",
+                if human { "BW" } else { "MB/s" },
+                "RTT(ms)",
+                "EXE(ms)",
+                "RETRANS",
+                "ERRORS"
+            )?;
+            server = Some(&row.server);
+        }
+        writeln!(
+            writer,
+            "  {:<24} {:>6} {:>10} {:>10} {:>10.2} {:>10.2} {:>8} {:>8}",
+            row.host,
+            row.mounts,
+            format_ops(row.iops, human),
+            format_kb_rate(row.kb_per_sec, human),
+            row.avg_rtt,
+            row.avg_exec,
+            row.retrans,
+            row.errors
+        )?;
+    }
+    for (host, error) in failures {
+        writeln!(writer, "Warning: {}: {}", host, error)?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+struct FleetHost {
+    name: String,
+    tracker: MountTracker,
+    last: Option<Instant>,
+}
+
+impl FleetHost {
+    /// Fetch and diff one snapshot; the first only sets baselines.
+    fn sample(&mut self, args: &FleetArgs) -> Result<Vec<MountInterval>> {
+        let contents = fetch(&args.ssh, &self.name, &args.remote_path)?;
+        let mounts = parse_mountstats_str(&contents)?;
+        let secs = self.last.map_or(0.0, |t| t.elapsed().as_secs_f64());
+        self.last = Some(Instant::now());
+        Ok(self.tracker.observe(mounts, secs).intervals)
+    }
+}
+
+/// Sample every host in parallel each interval until `args.count`
+/// intervals were shown or `running` is cleared. Only mounts `selector`
+/// picks are shown, on every host alike. A failing host is reported and
+/// retried next interval.
+pub fn run_fleet<W: Write>(
+    writer: &mut W,
+    args: &FleetArgs,
+    selector: &MountSelector,
+    running: &AtomicBool,
+) -> Result<()> {
+    let mut hosts: Vec<FleetHost> = fleet_hosts(args)?
+        .into_iter()
+        .map(|name| FleetHost {
+            name,
+            tracker: MountTracker::new(selector.clone()),
+            last: None,
+        })
+        .collect();
+    let interval = args.interval.as_duration();
+    let mut shown = 0;
+    let mut primed = false;
+
+    while running.load(Ordering::SeqCst) {
+        let started = Instant::now();
+        let results: Vec<(String, Result<Vec<MountInterval>>)> = thread::scope(|scope| {
+            let handles: Vec<_> = hosts
+                .iter_mut()
+                .map(|host| scope.spawn(move || (host.name.clone(), host.sample(args))))
+                .collect();
+            handles.into_iter().filter_map(|h| h.join().ok()).collect()
+        });
+
+        let mut samples = Vec::new();
+        let mut failures = Vec::new();
+        for (host, result) in results {
+            match result {
+                Ok(intervals) => samples.push((host, intervals)),
+                Err(e) => failures.push((host, e.to_string())),
+            }
+        }
+        // The first round only sets baselines, unless a host already
+        // failed and the user should hear about it.
+        if primed || !failures.is_empty() {
+            display_fleet(
+                writer,
+                &Utc::now(),
+                &fleet_rows(&samples),
+                &failures,
+                args.human,
+            )?;
+            writer.flush()?;
+            shown += 1;
+            if args.count > 0 && shown >= args.count {
+                break;
+            }
+        }
+        primed = true;
+
+        let due = started + interval;
+        while running.load(Ordering::SeqCst) && Instant::now() < due {
+            thread::sleep(
+                Duration::from_millis(100).min(due.saturating_duration_since(Instant::now())),
+            );
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::testutil::mount;
+
+    #[test]
+    fn test_parse_hosts() {
+        assert_eq!(
+            parse_hosts("node01\n# spare\n\n  node02  # rack 2\nroot@node03\n"),
+            ["node01", "node02", "root@node03"]
+        );
+    }
+
+    #[test]
+    fn test_fleet_rows() {
+        let interval = |mount_point: &str, server: &str, ops, rtt| {
+            let mut m = mount(mount_point);
+            m.server = server.to_string();
+            MountInterval {
+                mount: m,
+                stats: vec![stat("READ", ops, rtt)],
+            }
+        };
+        let samples = vec![
+            (
+                "node02".to_string(),
+                vec![interval("/scratch", "filer", 100, 4.0)],
+            ),
+            (
+                "node01".to_string(),
+                vec![
+                    interval("/home", "filer", 100, 1.0),
+                    interval("/scratch", "filer", 300, 5.0),
+                    interval("/apps", "apps-srv", 10, 1.0),
+                ],
+            ),
+        ];
+        let rows = fleet_rows(&samples);
+        let keys: Vec<(&str, &str)> = rows
+            .iter()
+            .map(|r| (r.server.as_str(), r.host.as_str()))
+            .collect();
+        assert_eq!(
+            keys,
+            [
+                ("apps-srv", "node01"),
+                ("filer", "node01"),
+                ("filer", "node02")
+            ]
+        );
+        assert_eq!(rows[1].mounts, 2);
+        assert!((rows[1].avg_rtt - 4.0).abs() < 1e-9);
+
+        let mut out = Vec::new();
+        let ts = Utc::now();
+        display_fleet(
+            &mut out,
+            &ts,
+            &rows,
+            &[("node09".to_string(), "timed out".to_string())],
+            false,
+        )
+        .unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.contains("filer: 2 hosts, 500.0 IOPS"));
+        assert!(text.contains("Warning: node09: timed out"));
+    }
+
+    #[test]
+    fn test_fetch_reports_failure() {
+        let err = fetch("false", "node01", "/proc/self/mountstats").unwrap_err();
+        assert!(err.to_string().starts_with("ssh node01 failed"));
+    }
+
+    #[test]
+    fn test_fetch_quotes_remote_path() {
+        let argv = fetch("echo", "node01", "/tmp/it's; rm -rf /").unwrap();
+        assert_eq!(
+            argv.trim_end(),
+            r"-o BatchMode=yes -o ConnectTimeout=5 -- node01 cat -- '/tmp/it'\''s; rm -rf /'"
+        );
+    }
+
+    #[test]
+    fn test_fleet_hosts_rejects_options() {
+        let args = FleetArgs {
+            hosts: vec!["node01".to_string(), "-oProxyCommand=sh".to_string()],
+            hosts_file: None,
+            interval: "5".parse().unwrap(),
+            count: 0,
+            ssh: "ssh".to_string(),
+            remote_path: "/proc/self/mountstats".to_string(),
+            human: false,
+        };
+        let err = fleet_hosts(&args).unwrap_err();
+        assert!(err.to_string().contains("invalid host"));
+    }
+}
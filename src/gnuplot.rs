@@ -0,0 +1,193 @@
+//! gnuplot-ready export: whitespace-separated data files plus a script
+//! that turns them into PNG graphs with nothing but gnuplot installed.
+
+use crate::types::DeltaStats;
+use chrono::{DateTime, Utc};
+use clap::Args;
+use std::collections::BTreeMap;
+use std::fs::{self, File};
+use std::io::{self, BufWriter, Write};
+use std::path::PathBuf;
+
+pub const SCRIPT_NAME: &str = "plot.gp";
+
+/// (output name, title, data column)
+const METRICS: &[(&str, &str, usize)] = &[
+    ("iops", "Operations per second", 2),
+    ("rtt", "Average RTT (ms)", 3),
+    ("exec", "Average execute time (ms)", 4),
+    ("throughput", "Throughput (KB/s)", 5),
+];
+
+#[derive(Args, Debug, Clone)]
+pub struct GnuplotArgs {
+    /// Write gnuplot data files and a plot script into DIR
+    #[arg(long = "gnuplot", value_name = "DIR")]
+    pub gnuplot: Option<String>,
+}
+
+/// File-name-safe form of a mount point, e.g. `/mnt/data` -> `mnt_data`.
+pub fn slug(mount_point: &str) -> String {
+    let slug: String = mount_point
+        .chars()
+        .map(|c| if c.is_ascii_alphanumeric() { c } else { '_' })
+        .collect();
+    let slug = slug.trim_matches('_');
+    if slug.is_empty() {
+        "root".to_string()
+    } else {
+        slug.to_string()
+    }
+}
+
+struct Series {
+    title: String,
+    writer: BufWriter<File>,
+}
+
+/// One data file per mount/operation pair. Columns: epoch seconds, IOPS,
+/// average RTT, average exec time, KB/s.
+pub struct GnuplotExport {
+    dir: PathBuf,
+    series: BTreeMap<String, Series>,
+}
+
+impl GnuplotExport {
+    pub fn create(dir: &str) -> io::Result<Self> {
+        fs::create_dir_all(dir)?;
+        Ok(Self {
+            dir: PathBuf::from(dir),
+            series: BTreeMap::new(),
+        })
+    }
+
+    pub fn record(
+        &mut self,
+        mount_point: &str,
+        timestamp: DateTime<Utc>,
+        stats: &[DeltaStats],
+    ) -> io::Result<()> {
+        for stat in stats {
+            let file_name = format!("{}_{}.dat", slug(mount_point), stat.operation);
+            if !self.series.contains_key(&file_name) {
+                let mut writer = BufWriter::new(File::create(self.dir.join(&file_name))?);
+                writeln!(writer, "# time iops avg_rtt_ms avg_exec_ms kb_per_sec")?;
+                self.series.insert(
+                    file_name.clone(),
+                    Series {
+                        title: format!("{} {}", mount_point, stat.operation),
+                        writer,
+                    },
+                );
+            }
+            let series = self.series.get_mut(&file_name).expect("inserted above");
+            writeln!(
+                series.writer,
+                "{} {:.3} {:.3} {:.3} {:.3}",
+                timestamp.timestamp(),
+                stat.iops,
+                stat.avg_rtt,
+                stat.avg_exec,
+                stat.kb_per_sec
+            )?;
+        }
+        Ok(())
+    }
+
+    /// Flush the data files and write the plot script. Returns the script
+    /// path.
+    pub fn finish(mut self) -> io::Result<PathBuf> {
+        for series in self.series.values_mut() {
+            series.writer.flush()?;
+        }
+        let path = self.dir.join(SCRIPT_NAME);
+        let mut script = BufWriter::new(File::create(&path)?);
+        write_script(&mut script, &self.series)?;
+        script.flush()?;
+        Ok(path)
+    }
+}
+
+fn quote(s: &str) -> String {
+    format!("\"{}\"", s.replace('\\', "\\\\").replace('"', "\\\""))
+}
+
+fn write_script<W: Write>(writer: &mut W, series: &BTreeMap<String, Series>) -> io::Result<()> {
+    writeln!(
+        writer,
+        "# Generated by nfs-gaze. Run: gnuplot {}",
+        SCRIPT_NAME
+    )?;
+    writeln!(writer, "set terminal pngcairo size 1280,640")?;
+    writeln!(writer, "set xdata time")?;
+    writeln!(writer, "set timefmt \"%s\"")?;
+    writeln!(writer, "set format x \"%H:%M:%S\"")?;
+    writeln!(writer, "set key outside right")?;
+    writeln!(writer, "set grid")?;
+    if series.is_empty() {
+        return Ok(());
+    }
+    for (name, title, column) in METRICS {
+        writeln!(writer)?;
+        writeln!(writer, "set output \"{}.png\"", name)?;
+        writeln!(writer, "set title \"{}\"", title)?;
+        let plots: Vec<String> = series
+            .iter()
+            .map(|(file, s)| {
+                format!(
+                    "{} using 1:{} with lines title {}",
+                    quote(file),
+                    column,
+                    quote(&s.title)
+                )
+            })
+            .collect();
+        writeln!(writer, "plot {}", plots.join(", \\\n     "))?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use chrono::TimeZone;
+
+    #[test]
+    fn test_slug() {
+        assert_eq!(slug("/mnt/data"), "mnt_data");
+        assert_eq!(slug("/mnt/with space/"), "mnt_with_space");
+        assert_eq!(slug("/"), "root");
+    }
+
+    #[test]
+    fn test_export() {
+        let dir = tempfile::tempdir().unwrap();
+        let mut export = GnuplotExport::create(dir.path().to_str().unwrap()).unwrap();
+        let t0 = Utc.timestamp_opt(1_700_000_000, 0).unwrap();
+        export
+            .record(
+                "/mnt/a",
+                t0,
+                &[stat("READ", 100, 2.0), stat("WRITE", 10, 5.0)],
+            )
+            .unwrap();
+        export
+            .record(
+                "/mnt/a",
+                t0 + chrono::Duration::seconds(1),
+                &[stat("READ", 50, 1.0)],
+            )
+            .unwrap();
+        let script_path = export.finish().unwrap();
+
+        let data = fs::read_to_string(dir.path().join("mnt_a_READ.dat")).unwrap();
+        let lines: Vec<&str> = data.lines().collect();
+        assert_eq!(lines.len(), 3);
+        assert_eq!(lines[1], "1700000000 100.000 2.000 2.000 400.000");
+
+        let script = fs::read_to_string(script_path).unwrap();
+        assert!(script.contains("set output \"rtt.png\""));
+        assert!(script.contains("\"mnt_a_WRITE.dat\" using 1:3 with lines title \"/mnt/a WRITE\""));
+    }
+}
@@ -0,0 +1,282 @@
+//! `--graph rtt:READ`: a terminal chart of one metric over time, redrawn
+//! every interval, for when a table of numbers hides the shape of a spike.
+
+use crate::columns::{parse_column, Column};
+use crate::types::DeltaStats;
+use clap::{Args, ValueEnum};
+use std::collections::{HashMap, VecDeque};
+use std::io::{self, Write};
+
+/// Width of the y-axis labels.
+const LABEL_WIDTH: usize = 9;
+
+#[derive(Args, Debug, Clone)]
+pub struct GraphArgs {
+    /// Plot METRIC:OP over time instead of the table, e.g. rtt:READ
+    #[arg(long = "graph", value_name = "METRIC:OP", value_parser = parse_graph)]
+    pub graph: Option<GraphSpec>,
+
+    /// Chart width in characters
+    #[arg(long = "graph-width", default_value = "60",
+          value_parser = clap::value_parser!(u32).range(8..))]
+    pub graph_width: u32,
+
+    /// Chart height in lines
+    #[arg(long = "graph-height", default_value = "10",
+          value_parser = clap::value_parser!(u32).range(2..))]
+    pub graph_height: u32,
+
+    /// Draw with braille dots, or plain ASCII for terminals without them
+    #[arg(long = "graph-style", value_enum, default_value = "braille")]
+    pub graph_style: GraphStyle,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum GraphStyle {
+    Braille,
+    Ascii,
+}
+
+impl GraphStyle {
+    /// Samples per character horizontally and dots per line vertically.
+    fn resolution(self) -> (usize, usize) {
+        match self {
+            GraphStyle::Braille => (2, 4),
+            GraphStyle::Ascii => (1, 1),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct GraphSpec {
+    pub column: Column,
+    /// Upper-case operation name.
+    pub operation: String,
+}
+
+/// Parse `METRIC:OP`, where METRIC is any `--cols` name.
+pub fn parse_graph(s: &str) -> std::result::Result<GraphSpec, String> {
+    let (metric, op) = s
+        .split_once(':')
+        .ok_or_else(|| format!("invalid graph '{}': expected METRIC:OP, e.g. rtt:READ", s))?;
+    let op = op.trim();
+    if op.is_empty() {
+        return Err(format!("invalid graph '{}': missing operation", s));
+    }
+    Ok(GraphSpec {
+        column: parse_column(metric)?,
+        operation: op.to_ascii_uppercase(),
+    })
+}
+
+/// Recent samples of the chosen metric per mount.
+pub struct Grapher {
+    spec: GraphSpec,
+    style: GraphStyle,
+    width: usize,
+    height: usize,
+    series: HashMap<String, VecDeque<Option<f64>>>,
+}
+
+impl Grapher {
+    pub fn new(spec: GraphSpec, style: GraphStyle, width: u32, height: u32) -> Self {
+        Self {
+            spec,
+            style,
+            width: width.max(1) as usize,
+            height: height.max(1) as usize,
+            series: HashMap::new(),
+        }
+    }
+
+    pub fn from_args(args: &GraphArgs) -> Option<Self> {
+        args.graph
+            .clone()
+            .map(|spec| Self::new(spec, args.graph_style, args.graph_width, args.graph_height))
+    }
+
+    fn capacity(&self) -> usize {
+        self.width * self.style.resolution().0
+    }
+
+    /// Add one interval for `mount_point`. Averages leave a gap where the
+    /// operation did not run; rates and counters drop to zero.
+    pub fn push(&mut self, mount_point: &str, stats: &[DeltaStats]) {
+        let column = self.spec.column;
+        let value = match stats.iter().find(|s| s.operation == self.spec.operation) {
+            Some(s) if s.delta_ops > 0 || !average(column) => Some(column.metric(s)),
+            Some(_) => None,
+            None => (!average(column)).then_some(0.0),
+        };
+        let capacity = self.capacity();
+        let series = self.series.entry(mount_point.to_string()).or_default();
+        series.push_back(value);
+        while series.len() > capacity {
+            series.pop_front();
+        }
+    }
+
+    pub fn latest(&self, mount_point: &str) -> Option<f64> {
+        self.series.get(mount_point)?.back().copied().flatten()
+    }
+
+    /// The chart for `mount_point` as lines, newest sample at the right.
+    pub fn render(&self, mount_point: &str) -> Vec<String> {
+        let empty = VecDeque::new();
+        let series = self.series.get(mount_point).unwrap_or(&empty);
+        let max = series.iter().flatten().fold(0.0_f64, |a, &b| a.max(b));
+        let (per_char, per_line) = self.style.resolution();
+
+        // Left-pad so the newest sample is always in the last column.
+        let mut samples = vec![None; self.capacity() - series.len()];
+        samples.extend(series.iter().copied());
+        let levels: Vec<usize> = samples
+            .iter()
+            .map(|v| level(*v, max, self.height * per_line))
+            .collect();
+
+        (0..self.height)
+            .map(|row| {
+                // Dots below this line, counting up from the bottom.
+                let base = (self.height - 1 - row) * per_line;
+                let label = match row {
+                    0 => format!("{:.2}", max),
+                    r if r == self.height - 1 => "0".to_string(),
+                    _ => String::new(),
+                };
+                let cells: String = levels
+                    .chunks(per_char)
+                    .map(|chunk| match self.style {
+                        GraphStyle::Braille => braille(chunk, base),
+                        GraphStyle::Ascii => {
+                            if chunk[0] > base {
+                                '#'
+                            } else {
+                                ' '
+                            }
+                        }
+                    })
+                    .collect();
+                format!("{:>w$} ┤{}", label, cells, w = LABEL_WIDTH)
+            })
+            .collect()
+    }
+}
+
+/// Per-operation averages, which are undefined for an idle interval.
+fn average(column: Column) -> bool {
+    matches!(
+        column,
+        Column::Rtt | Column::Exec | Column::Queue | Column::KbOp
+    )
+}
+
+/// How many dots of `dots` a sample fills. Any non-zero value shows at
+/// least one dot so small blips are not lost.
+fn level(value: Option<f64>, max: f64, dots: usize) -> usize {
+    match value {
+        Some(v) if v > 0.0 && max > 0.0 => {
+            ((v / max * dots as f64).round() as usize).clamp(1, dots)
+        }
+        _ => 0,
+    }
+}
+
+/// One braille cell for up to two samples, filled from the bottom.
+fn braille(levels: &[usize], base: usize) -> char {
+    // Dot bits by column, bottom row first.
+    const DOTS: [[u32; 4]; 2] = [[0x40, 0x04, 0x02, 0x01], [0x80, 0x20, 0x10, 0x08]];
+    let mut bits = 0;
+    for (col, &level) in levels.iter().enumerate() {
+        for (k, bit) in DOTS[col].iter().enumerate() {
+            if base + k < level {
+                bits |= bit;
+            }
+        }
+    }
+    char::from_u32(0x2800 + bits).unwrap_or(' ')
+}
+
+pub fn display_graph<W: Write>(
+    writer: &mut W,
+    grapher: &Grapher,
+    mount_point: &str,
+) -> io::Result<()> {
+    let latest = match grapher.latest(mount_point) {
+        Some(v) => format!("{:.2}", v),
+        None => "-".to_string(),
+    };
+    writeln!(
+        writer,
+        "{} {} on {} (latest {})",
+        grapher.spec.operation,
+        grapher.spec.column.header(),
+        mount_point,
+        latest
+    )?;
+    for line in grapher.render(mount_point) {
+        writeln!(writer, "{}", line)?;
+    }
+    writeln!(
+        writer,
+        "{:>w$} └{}",
+        "",
+        "─".repeat(grapher.width),
+        w = LABEL_WIDTH
+    )?;
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_parse_graph() {
+        assert_eq!(
+            parse_graph("rtt:read"),
+            Ok(GraphSpec {
+                column: Column::Rtt,
+                operation: "READ".to_string()
+            })
+        );
+        assert_eq!(parse_graph("kbps:WRITE").unwrap().column, Column::KbS);
+        assert!(parse_graph("rtt").is_err());
+        assert!(parse_graph("rtt:").is_err());
+        assert!(parse_graph("latency:READ").is_err());
+    }
+
+    #[test]
+    fn test_ascii_chart() {
+        let spec = parse_graph("rtt:READ").unwrap();
+        let mut grapher = Grapher::new(spec, GraphStyle::Ascii, 4, 2);
+        grapher.push("/mnt", &[stat("READ", 10, 4.0)]);
+        grapher.push("/mnt", &[stat("READ", 0, 0.0)]);
+        grapher.push("/mnt", &[stat("READ", 10, 1.0)]);
+
+        let lines = grapher.render("/mnt");
+        assert_eq!(lines, vec!["     4.00 ┤ #  ", "        0 ┤ # #"]);
+        assert_eq!(grapher.latest("/mnt"), Some(1.0));
+        assert_eq!(grapher.render("/other")[1], "        0 ┤    ");
+    }
+
+    #[test]
+    fn test_braille_chart() {
+        let spec = parse_graph("iops:READ").unwrap();
+        let mut grapher = Grapher::new(spec, GraphStyle::Braille, 1, 1);
+        grapher.push("/mnt", &[stat("READ", 100, 1.0)]);
+        grapher.push("/mnt", &[stat("READ", 50, 1.0)]);
+        // Left column full height, right column half.
+        assert_eq!(grapher.render("/mnt"), vec!["   100.00 ┤⣧"]);
+        // Older samples scroll out.
+        grapher.push("/mnt", &[stat("READ", 0, 0.0)]);
+        assert_eq!(grapher.render("/mnt"), vec!["    50.00 ┤⡇"]);
+
+        let mut out = Vec::new();
+        display_graph(&mut out, &grapher, "/mnt").unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.starts_with("READ IOPS on /mnt (latest 0.00)\n"));
+    }
+}
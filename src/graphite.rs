@@ -0,0 +1,157 @@
+//! Graphite plaintext sender: `--graphite host:port` pushes each
+//! interval's per-mount, per-operation metrics as
+//! `prefix.mount.op.metric value timestamp` lines.
+
+use crate::gnuplot::slug;
+use crate::labels::Labels;
+use crate::types::DeltaStats;
+use chrono::{DateTime, Utc};
+use clap::Args;
+use std::io::{self, Write};
+use std::net::{TcpStream, ToSocketAddrs};
+use std::time::Duration;
+
+const CONNECT_TIMEOUT: Duration = Duration::from_secs(2);
+
+#[derive(Args, Debug, Clone)]
+pub struct GraphiteArgs {
+    /// Send interval metrics to a Graphite carbon receiver at HOST:PORT
+    #[arg(long = "graphite", value_name = "HOST:PORT")]
+    pub graphite: Option<String>,
+
+    /// Metric path prefix for Graphite
+    #[arg(long = "graphite-prefix", default_value = "nfs")]
+    pub graphite_prefix: String,
+}
+
+/// Graphite path component: lower-case operation names and mount slugs
+/// never contain the `.` separator.
+fn component(s: &str) -> String {
+    slug(s).to_lowercase()
+}
+
+/// Graphite 1.1 tag suffix, e.g. `;env=prod;dc=east`.
+fn tag_suffix(labels: &Labels) -> String {
+    labels
+        .iter()
+        .map(|(k, v)| format!(";{}={}", k, v.replace([';', ' ', '~'], "_")))
+        .collect()
+}
+
+/// Plaintext lines for one mount's interval.
+pub fn graphite_lines(
+    prefix: &str,
+    mount_point: &str,
+    timestamp: DateTime<Utc>,
+    stats: &[DeltaStats],
+    labels: &Labels,
+) -> Vec<String> {
+    let base = format!(
+        "{}.{}",
+        prefix.trim_end_matches('.'),
+        component(mount_point)
+    );
+    let tags = tag_suffix(labels);
+    let ts = timestamp.timestamp();
+    let mut lines = Vec::new();
+    for stat in stats {
+        let metrics = [
+            ("ops", stat.delta_ops as f64),
+            ("iops", stat.iops),
+            ("kb_per_sec", stat.kb_per_sec),
+            ("kb_per_op", stat.kb_per_op),
+            ("avg_rtt_ms", stat.avg_rtt),
+            ("avg_exec_ms", stat.avg_exec),
+            ("avg_queue_ms", stat.avg_queue),
+            ("retrans", stat.delta_retrans as f64),
+            ("errors", stat.delta_errors as f64),
+        ];
+        let op = component(&stat.operation);
+        for (name, value) in metrics {
+            lines.push(format!("{}.{}.{}{} {} {}", base, op, name, tags, value, ts));
+        }
+    }
+    lines
+}
+
+/// Carbon connection that reconnects on the next send after a failure,
+/// so a restarted receiver costs at most one interval.
+pub struct GraphiteSender {
+    addr: String,
+    stream: Option<TcpStream>,
+}
+
+impl GraphiteSender {
+    pub fn new(addr: &str) -> Self {
+        Self {
+            addr: addr.to_string(),
+            stream: None,
+        }
+    }
+
+    fn connect(&self) -> io::Result<TcpStream> {
+        let addr = self.addr.to_socket_addrs()?.next().ok_or_else(|| {
+            io::Error::new(io::ErrorKind::InvalidInput, "no address for graphite host")
+        })?;
+        let stream = TcpStream::connect_timeout(&addr, CONNECT_TIMEOUT)?;
+        stream.set_write_timeout(Some(CONNECT_TIMEOUT))?;
+        Ok(stream)
+    }
+
+    pub fn send(&mut self, lines: &[String]) -> io::Result<()> {
+        if lines.is_empty() {
+            return Ok(());
+        }
+        let stream = match self.stream.as_mut() {
+            Some(stream) => stream,
+            None => self.stream.insert(self.connect()?),
+        };
+        let mut payload = lines.join("\n");
+        payload.push('\n');
+        let result = stream
+            .write_all(payload.as_bytes())
+            .and_then(|_| stream.flush());
+        if result.is_err() {
+            self.stream = None;
+        }
+        result
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use chrono::TimeZone;
+    use std::io::Read;
+    use std::net::TcpListener;
+
+    #[test]
+    fn test_graphite_lines() {
+        let ts = Utc.with_ymd_and_hms(2024, 3, 1, 0, 0, 0).unwrap();
+        let mut labels = Labels::new();
+        labels.add("env=prod").unwrap();
+        let lines = graphite_lines("nfs.", "/mnt/data.1", ts, &[stat("READ", 10, 2.5)], &labels);
+        assert_eq!(lines.len(), 9);
+        assert_eq!(lines[0], "nfs.mnt_data_1.read.ops;env=prod 10 1709251200");
+        assert!(
+            lines.contains(&"nfs.mnt_data_1.read.avg_rtt_ms;env=prod 2.5 1709251200".to_string())
+        );
+    }
+
+    #[test]
+    fn test_sender_delivers_lines() {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap().to_string();
+        let mut sender = GraphiteSender::new(&addr);
+        sender
+            .send(&["a.b 1 100".to_string(), "a.c 2 100".to_string()])
+            .unwrap();
+        drop(sender);
+
+        let (mut conn, _) = listener.accept().unwrap();
+        let mut received = String::new();
+        conn.read_to_string(&mut received).unwrap();
+        assert_eq!(received, "a.b 1 100\na.c 2 100\n");
+    }
+}
@@ -0,0 +1,229 @@
+//! gRPC streaming API (`--grpc-listen`), built with the `grpc` feature.
+//!
+//! `WatchStats` streams one `Interval` per sampling interval for as long as
+//! the client stays subscribed. Each call gets its own tracker and ticker,
+//! so clients may watch different mounts at different intervals. The
+//! schema is `proto/nfs_gaze.proto`; generating stubs needs `protoc`.
+
+use crate::exporter::parse_listen;
+use crate::parser::parse_mountstats;
+use crate::selection::MountSelector;
+use crate::tracker::{MountInterval, MountTracker};
+use crate::types::{DeltaStats, NFSMount, NFSOperation};
+use chrono::{DateTime, Utc};
+use clap::Args;
+use std::net::SocketAddr;
+use std::time::{Duration, Instant};
+use tokio::sync::mpsc;
+use tokio_stream::wrappers::ReceiverStream;
+use tonic::{Request, Response, Status};
+
+pub mod pb {
+    tonic::include_proto!("nfs_gaze");
+}
+
+use pb::nfs_gaze_server::{NfsGaze, NfsGazeServer};
+
+/// Intervals buffered per client before the sampler waits on it.
+const STREAM_BUFFER: usize = 16;
+
+#[derive(Args, Debug, Clone)]
+pub struct GrpcArgs {
+    /// Serve the gRPC WatchStats API on ADDR (e.g. :9097)
+    #[arg(long = "grpc-listen", value_name = "ADDR", value_parser = parse_listen)]
+    pub grpc_listen: Option<SocketAddr>,
+}
+
+impl From<&NFSOperation> for pb::NfsOperation {
+    fn from(op: &NFSOperation) -> Self {
+        Self {
+            name: op.name.clone(),
+            ops: op.ops,
+            ntrans: op.ntrans,
+            timeouts: op.timeouts,
+            bytes_sent: op.bytes_sent,
+            bytes_recv: op.bytes_recv,
+            queue_time: op.queue_time,
+            rtt: op.rtt,
+            execute_time: op.execute_time,
+            errors: op.errors,
+        }
+    }
+}
+
+impl From<&NFSMount> for pb::NfsMount {
+    fn from(mount: &NFSMount) -> Self {
+        let mut operations: Vec<pb::NfsOperation> =
+            mount.operations.values().map(Into::into).collect();
+        operations.sort_by(|a, b| a.name.cmp(&b.name));
+        Self {
+            device: mount.device.clone(),
+            mount_point: mount.mount_point.clone(),
+            server: mount.server.clone(),
+            export: mount.export.clone(),
+            age: mount.age,
+            operations,
+            bytes_read: mount.bytes_read,
+            bytes_write: mount.bytes_write,
+        }
+    }
+}
+
+impl From<&DeltaStats> for pb::DeltaStats {
+    fn from(stat: &DeltaStats) -> Self {
+        Self {
+            operation: stat.operation.clone(),
+            delta_ops: stat.delta_ops,
+            delta_bytes: stat.delta_bytes,
+            delta_sent: stat.delta_sent,
+            delta_recv: stat.delta_recv,
+            delta_rtt: stat.delta_rtt,
+            delta_exec: stat.delta_exec,
+            delta_queue: stat.delta_queue,
+            delta_errors: stat.delta_errors,
+            delta_retrans: stat.delta_retrans,
+            avg_rtt: stat.avg_rtt,
+            avg_exec: stat.avg_exec,
+            avg_queue: stat.avg_queue,
+            kb_per_op: stat.kb_per_op,
+            kb_per_sec: stat.kb_per_sec,
+            iops: stat.iops,
+        }
+    }
+}
+
+pub fn interval_message(
+    timestamp: DateTime<Utc>,
+    interval_secs: f64,
+    intervals: &[MountInterval],
+) -> pb::Interval {
+    pb::Interval {
+        timestamp_ms: timestamp.timestamp_millis(),
+        interval_secs,
+        mounts: intervals
+            .iter()
+            .map(|i| pb::MountInterval {
+                mount: Some((&i.mount).into()),
+                stats: i.stats.iter().map(Into::into).collect(),
+            })
+            .collect(),
+    }
+}
+
+pub struct WatchService {
+    path: String,
+    selector: MountSelector,
+    interval: Duration,
+}
+
+impl WatchService {
+    pub fn new(path: &str, selector: MountSelector, interval: Duration) -> Self {
+        Self {
+            path: path.to_string(),
+            selector,
+            interval,
+        }
+    }
+}
+
+#[tonic::async_trait]
+impl NfsGaze for WatchService {
+    type WatchStatsStream = ReceiverStream<Result<pb::Interval, Status>>;
+
+    async fn watch_stats(
+        &self,
+        request: Request<pb::WatchRequest>,
+    ) -> Result<Response<Self::WatchStatsStream>, Status> {
+        let request = request.into_inner();
+        let selector = if request.mount_points.is_empty() {
+            self.selector.clone()
+        } else {
+            MountSelector::new(&request.mount_points[..])
+        };
+        let interval = match request.interval_secs {
+            0 => self.interval,
+            secs => Duration::from_secs(secs.into()),
+        };
+        let path = self.path.clone();
+        let (tx, rx) = mpsc::channel(STREAM_BUFFER);
+
+        tokio::spawn(async move {
+            let mut tracker = MountTracker::new(selector);
+            let mut ticker = tokio::time::interval(interval);
+            let mut last = Instant::now();
+            loop {
+                ticker.tick().await;
+                let secs = last.elapsed().as_secs_f64();
+                last = Instant::now();
+                let mounts = match parse_mountstats(&path) {
+                    Ok(mounts) => mounts,
+                    Err(e) => {
+                        let _ = tx.send(Err(Status::internal(e.to_string()))).await;
+                        return;
+                    }
+                };
+                let update = tracker.observe(mounts, secs);
+                if update.intervals.is_empty() {
+                    continue;
+                }
+                let message = interval_message(Utc::now(), secs, &update.intervals);
+                if tx.send(Ok(message)).await.is_err() {
+                    // The client cancelled.
+                    return;
+                }
+            }
+        });
+
+        Ok(Response::new(ReceiverStream::new(rx)))
+    }
+}
+
+/// Serve WatchStats on `addr` until the process exits.
+pub async fn serve_grpc(
+    addr: SocketAddr,
+    service: WatchService,
+) -> std::result::Result<(), tonic::transport::Error> {
+    tonic::transport::Server::builder()
+        .add_service(NfsGazeServer::new(service))
+        .serve(addr)
+        .await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::testutil::mount;
+    use chrono::TimeZone;
+
+    #[test]
+    fn test_interval_message() {
+        let mut m = mount("/mnt/a");
+        for name in ["WRITE", "READ"] {
+            m.operations.insert(
+                name.to_string(),
+                NFSOperation {
+                    name: name.to_string(),
+                    ops: 5,
+                    ..Default::default()
+                },
+            );
+        }
+        let ts = Utc.with_ymd_and_hms(2024, 3, 1, 0, 0, 0).unwrap();
+        let message = interval_message(
+            ts,
+            2.0,
+            &[MountInterval {
+                mount: m,
+                stats: vec![stat("READ", 10, 1.5)],
+            }],
+        );
+
+        assert_eq!(message.timestamp_ms, 1_709_251_200_000);
+        let mount = message.mounts[0].mount.as_ref().unwrap();
+        assert_eq!(mount.mount_point, "/mnt/a");
+        assert_eq!(mount.operations[0].name, "READ");
+        assert_eq!(message.mounts[0].stats[0].delta_ops, 10);
+        assert_eq!(message.mounts[0].stats[0].avg_rtt, 1.5);
+    }
+}
@@ -0,0 +1,103 @@
+//! ASCII histograms of per-interval values, to show burstiness that
+//! session averages hide.
+
+use std::io::{self, Write};
+
+const DEFAULT_BUCKETS: usize = 10;
+const BAR_WIDTH: usize = 40;
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Bucket {
+    pub low: f64,
+    pub high: f64,
+    pub count: usize,
+}
+
+/// Split `values` into `buckets` equal-width buckets between the minimum
+/// and maximum. All-equal input yields a single bucket.
+pub fn bucketize(values: &[f64], buckets: usize) -> Vec<Bucket> {
+    if values.is_empty() || buckets == 0 {
+        return Vec::new();
+    }
+    let min = values.iter().copied().fold(f64::INFINITY, f64::min);
+    let max = values.iter().copied().fold(f64::NEG_INFINITY, f64::max);
+    if max <= min {
+        return vec![Bucket {
+            low: min,
+            high: max,
+            count: values.len(),
+        }];
+    }
+
+    let width = (max - min) / buckets as f64;
+    let mut result: Vec<Bucket> = (0..buckets)
+        .map(|i| Bucket {
+            low: min + width * i as f64,
+            high: min + width * (i + 1) as f64,
+            count: 0,
+        })
+        .collect();
+    for value in values {
+        let index = (((value - min) / width) as usize).min(buckets - 1);
+        result[index].count += 1;
+    }
+    result
+}
+
+/// Print a titled histogram of `values`, e.g. per-interval IOPS.
+pub fn display_histogram<W: Write>(writer: &mut W, title: &str, values: &[f64]) -> io::Result<()> {
+    let buckets = bucketize(values, DEFAULT_BUCKETS);
+    if buckets.is_empty() {
+        return Ok(());
+    }
+    let peak = buckets.iter().map(|b| b.count).max().unwrap_or(0).max(1);
+
+    writeln!(writer, "{} ({} intervals)", title, values.len())?;
+    for bucket in &buckets {
+        let bar = (bucket.count * BAR_WIDTH).div_ceil(peak);
+        writeln!(
+            writer,
+            "  {:>10.1} - {:<10.1} |{:<width$}| {}",
+            bucket.low,
+            bucket.high,
+            "#".repeat(bar),
+            bucket.count,
+            width = BAR_WIDTH
+        )?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_bucketize() {
+        let values = [0.0, 1.0, 1.0, 9.0, 10.0];
+        let buckets = bucketize(&values, 5);
+        assert_eq!(buckets.len(), 5);
+        assert_eq!(buckets[0].count, 3);
+        assert_eq!(buckets[4].count, 2);
+        assert_eq!(buckets.iter().map(|b| b.count).sum::<usize>(), 5);
+
+        let flat = bucketize(&[3.0, 3.0], 5);
+        assert_eq!(flat.len(), 1);
+        assert_eq!(flat[0].count, 2);
+
+        assert!(bucketize(&[], 5).is_empty());
+    }
+
+    #[test]
+    fn test_display_histogram() {
+        let mut out = Vec::new();
+        display_histogram(&mut out, "IOPS per interval", &[10.0, 10.0, 10.0, 100.0]).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(lines[0], "IOPS per interval (4 intervals)");
+        assert_eq!(lines.len(), 1 + DEFAULT_BUCKETS);
+        assert!(lines[1].contains(&"#".repeat(BAR_WIDTH)));
+        assert!(lines[1].ends_with("| 3"));
+        assert!(lines[DEFAULT_BUCKETS].ends_with("| 1"));
+    }
+}
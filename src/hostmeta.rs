@@ -0,0 +1,250 @@
+//! Host and topology metadata (hostname, kernel, NFS client module, and
+//! optionally cloud instance identity) so exported samples describe
+//! where they came from.
+//!
+//! Metadata is merged into the static labels without replacing any key
+//! the user set with --label.
+
+use crate::labels::Labels;
+use clap::Args;
+use std::fs;
+use std::io::{self, BufRead, BufReader, Read, Write};
+use std::net::{SocketAddr, TcpStream};
+use std::time::Duration;
+
+/// Link-local instance metadata endpoint shared by AWS, GCP and Azure.
+const IMDS_ADDR: &str = "169.254.169.254:80";
+const IMDS_TIMEOUT: Duration = Duration::from_millis(500);
+
+#[derive(Args, Debug, Clone)]
+pub struct HostMetaArgs {
+    /// Do not add hostname/kernel/NFS module labels to output
+    #[arg(long = "no-host-metadata")]
+    pub no_host_metadata: bool,
+
+    /// Query the cloud instance metadata service for instance id, type and zone
+    #[arg(long = "cloud-metadata")]
+    pub cloud_metadata: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CloudMetadata {
+    pub provider: &'static str,
+    pub instance_id: String,
+    pub instance_type: String,
+    pub zone: String,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct HostMetadata {
+    pub hostname: String,
+    pub kernel: String,
+    /// `version` of the nfs module, or its `srcversion` when the module
+    /// does not declare one.
+    pub nfs_module: Option<String>,
+    pub cloud: Option<CloudMetadata>,
+}
+
+fn read_trimmed(path: &str) -> Option<String> {
+    fs::read_to_string(path)
+        .ok()
+        .map(|s| s.trim().to_string())
+        .filter(|s| !s.is_empty())
+}
+
+impl HostMetadata {
+    /// Collect local metadata from /proc and /sys rooted at `root`
+    /// (normally `/`).
+    pub fn collect_local(root: &str) -> Self {
+        let path = |p: &str| format!("{}/{}", root.trim_end_matches('/'), p);
+        Self {
+            hostname: read_trimmed(&path("proc/sys/kernel/hostname")).unwrap_or_default(),
+            kernel: read_trimmed(&path("proc/sys/kernel/osrelease")).unwrap_or_default(),
+            nfs_module: read_trimmed(&path("sys/module/nfs/version"))
+                .or_else(|| read_trimmed(&path("sys/module/nfs/srcversion"))),
+            cloud: None,
+        }
+    }
+
+    pub fn collect(args: &HostMetaArgs) -> Option<Self> {
+        if args.no_host_metadata {
+            return None;
+        }
+        let mut meta = Self::collect_local("/");
+        if args.cloud_metadata {
+            meta.cloud = fetch_cloud_metadata();
+        }
+        Some(meta)
+    }
+
+    /// Add the metadata to `labels` under `host`, `kernel`, `nfs_module`
+    /// and `cloud_*`, leaving any existing keys alone.
+    pub fn add_to(&self, labels: &mut Labels) {
+        let mut add = |key: &str, value: &str| {
+            if !value.is_empty() && labels.get(key).is_none() {
+                labels.insert(key, value);
+            }
+        };
+        add("host", &self.hostname);
+        add("kernel", &self.kernel);
+        if let Some(module) = &self.nfs_module {
+            add("nfs_module", module);
+        }
+        if let Some(cloud) = &self.cloud {
+            add("cloud_provider", cloud.provider);
+            add("cloud_instance_id", &cloud.instance_id);
+            add("cloud_instance_type", &cloud.instance_type);
+            add("cloud_zone", &cloud.zone);
+        }
+    }
+}
+
+/// Minimal HTTP/1.0 request against the metadata endpoint. Returns the
+/// body on a 200 response.
+fn imds_request(method: &str, path: &str, headers: &[(&str, &str)]) -> io::Result<String> {
+    let addr: SocketAddr = IMDS_ADDR.parse().expect("valid address");
+    let mut stream = TcpStream::connect_timeout(&addr, IMDS_TIMEOUT)?;
+    stream.set_read_timeout(Some(IMDS_TIMEOUT))?;
+    stream.set_write_timeout(Some(IMDS_TIMEOUT))?;
+
+    let mut request = format!("{} {} HTTP/1.0\r\nHost: 169.254.169.254\r\n", method, path);
+    for (name, value) in headers {
+        request.push_str(&format!("{}: {}\r\n", name, value));
+    }
+    request.push_str("Content-Length: 0\r\n\r\n");
+    stream.write_all(request.as_bytes())?;
+
+    let mut reader = BufReader::new(stream);
+    let mut status = String::new();
+    reader.read_line(&mut status)?;
+    if status.split_whitespace().nth(1) != Some("200") {
+        return Err(io::Error::other(format!(
+            "metadata service: {}",
+            status.trim()
+        )));
+    }
+    let mut line = String::new();
+    loop {
+        line.clear();
+        if reader.read_line(&mut line)? == 0 || line.trim().is_empty() {
+            break;
+        }
+    }
+    let mut body = String::new();
+    reader.read_to_string(&mut body)?;
+    Ok(body.trim().to_string())
+}
+
+fn aws() -> Option<CloudMetadata> {
+    let token = imds_request(
+        "PUT",
+        "/latest/api/token",
+        &[("X-aws-ec2-metadata-token-ttl-seconds", "60")],
+    )
+    .ok()?;
+    let get = |path: &str| {
+        imds_request(
+            "GET",
+            &format!("/latest/meta-data/{}", path),
+            &[("X-aws-ec2-metadata-token", &token)],
+        )
+        .ok()
+    };
+    Some(CloudMetadata {
+        provider: "aws",
+        instance_id: get("instance-id")?,
+        instance_type: get("instance-type").unwrap_or_default(),
+        zone: get("placement/availability-zone").unwrap_or_default(),
+    })
+}
+
+fn gcp() -> Option<CloudMetadata> {
+    let get = |path: &str| {
+        imds_request(
+            "GET",
+            &format!("/computeMetadata/v1/instance/{}", path),
+            &[("Metadata-Flavor", "Google")],
+        )
+        .ok()
+    };
+    // machine-type and zone come back as full resource paths.
+    let last = |s: String| s.rsplit('/').next().unwrap_or_default().to_string();
+    Some(CloudMetadata {
+        provider: "gcp",
+        instance_id: get("id")?,
+        instance_type: get("machine-type").map(last).unwrap_or_default(),
+        zone: get("zone").map(last).unwrap_or_default(),
+    })
+}
+
+fn azure() -> Option<CloudMetadata> {
+    let body = imds_request(
+        "GET",
+        "/metadata/instance/compute?api-version=2021-02-01",
+        &[("Metadata", "true")],
+    )
+    .ok()?;
+    let compute: serde_json::Value = serde_json::from_str(&body).ok()?;
+    let field = |name: &str| compute[name].as_str().unwrap_or_default().to_string();
+    Some(CloudMetadata {
+        provider: "azure",
+        instance_id: compute["vmId"].as_str()?.to_string(),
+        instance_type: field("vmSize"),
+        zone: match field("zone").as_str() {
+            "" => field("location"),
+            zone => format!("{}-{}", field("location"), zone),
+        },
+    })
+}
+
+/// Try each provider in turn. Off-cloud, the first connection attempt
+/// times out quickly and nothing is returned.
+pub fn fetch_cloud_metadata() -> Option<CloudMetadata> {
+    aws().or_else(gcp).or_else(azure)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_collect_local() {
+        let root = tempfile::tempdir().unwrap();
+        let write = |p: &str, contents: &str| {
+            let path = root.path().join(p);
+            fs::create_dir_all(path.parent().unwrap()).unwrap();
+            fs::write(path, contents).unwrap();
+        };
+        write("proc/sys/kernel/hostname", "node042\n");
+        write("proc/sys/kernel/osrelease", "6.8.0-45-generic\n");
+        write("sys/module/nfs/srcversion", "ABC123\n");
+
+        let meta = HostMetadata::collect_local(root.path().to_str().unwrap());
+        assert_eq!(meta.hostname, "node042");
+        assert_eq!(meta.kernel, "6.8.0-45-generic");
+        assert_eq!(meta.nfs_module.as_deref(), Some("ABC123"));
+    }
+
+    #[test]
+    fn test_add_to_labels() {
+        let meta = HostMetadata {
+            hostname: "node042".to_string(),
+            kernel: "6.8.0".to_string(),
+            nfs_module: None,
+            cloud: Some(CloudMetadata {
+                provider: "aws",
+                instance_id: "i-0abc".to_string(),
+                instance_type: "c7i.large".to_string(),
+                zone: "us-east-1a".to_string(),
+            }),
+        };
+        let mut labels = Labels::new();
+        labels.add("host=override").unwrap();
+        meta.add_to(&mut labels);
+
+        assert_eq!(labels.get("host"), Some("override"));
+        assert_eq!(labels.get("kernel"), Some("6.8.0"));
+        assert_eq!(labels.get("nfs_module"), None);
+        assert_eq!(labels.get("cloud_zone"), Some("us-east-1a"));
+    }
+}
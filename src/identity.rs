@@ -0,0 +1,247 @@
+//! Which local address and interface each mount talks from.
+//!
+//! NFSv4 mounts record the callback address in `clientaddr=`; the kernel
+//! picks it when the mount is created and never revisits it. On
+//! multi-homed hosts that address can disagree with the source address
+//! the routing table currently selects for the server, which is the usual
+//! cause of one-way callback and delegation failures.
+
+use crate::options::MountOptions;
+use crate::sections::MountSection;
+use clap::Args;
+use std::collections::HashMap;
+use std::ffi::CStr;
+use std::io::{self, Write};
+use std::net::{IpAddr, Ipv4Addr, Ipv6Addr, SocketAddr, UdpSocket};
+
+#[derive(Args, Debug, Clone)]
+pub struct IdentityArgs {
+    /// Show the client address and local interface used by each mount
+    #[arg(long = "client-identity")]
+    pub client_identity: bool,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct ClientIdentity {
+    pub mount_point: String,
+    /// Server address from `addr=` (or `mountaddr=` for v3).
+    pub server_addr: Option<IpAddr>,
+    /// Address the client advertised to the server (`clientaddr=`).
+    pub clientaddr: Option<IpAddr>,
+    /// Source address the routing table picks for the server right now.
+    pub route_src: Option<IpAddr>,
+    pub interface: Option<String>,
+}
+
+impl ClientIdentity {
+    /// The advertised address no longer matches the route to the server.
+    pub fn mismatched(&self) -> bool {
+        match (self.clientaddr, self.route_src) {
+            (Some(client), Some(route)) => !client.is_unspecified() && client != route,
+            _ => false,
+        }
+    }
+
+    /// The address this mount effectively uses: `clientaddr=` if set,
+    /// otherwise the routed source address.
+    pub fn local_addr(&self) -> Option<IpAddr> {
+        self.clientaddr
+            .filter(|a| !a.is_unspecified())
+            .or(self.route_src)
+    }
+}
+
+fn parse_addr(value: Option<&str>) -> Option<IpAddr> {
+    value?.trim_matches(|c| c == '[' || c == ']').parse().ok()
+}
+
+/// Source address the kernel would use to reach `server`. Connecting a UDP
+/// socket only performs the route lookup; nothing is sent.
+pub fn route_source(server: IpAddr) -> Option<IpAddr> {
+    let bind: SocketAddr = match server {
+        IpAddr::V4(_) => (Ipv4Addr::UNSPECIFIED, 0).into(),
+        IpAddr::V6(_) => (Ipv6Addr::UNSPECIFIED, 0).into(),
+    };
+    let socket = UdpSocket::bind(bind).ok()?;
+    socket.connect((server, 2049)).ok()?;
+    socket.local_addr().ok().map(|a| a.ip())
+}
+
+/// Map of local addresses to interface names.
+pub fn interface_addresses() -> HashMap<IpAddr, String> {
+    let mut found = HashMap::new();
+    let mut ifap: *mut libc::ifaddrs = std::ptr::null_mut();
+    // SAFETY: getifaddrs fills `ifap` with a list we free below; each node
+    // is only read while the list is alive.
+    unsafe {
+        if libc::getifaddrs(&mut ifap) != 0 {
+            return found;
+        }
+        let mut cur = ifap;
+        while !cur.is_null() {
+            let entry = &*cur;
+            cur = entry.ifa_next;
+            if entry.ifa_addr.is_null() || entry.ifa_name.is_null() {
+                continue;
+            }
+            let addr = match (*entry.ifa_addr).sa_family as libc::c_int {
+                libc::AF_INET => {
+                    let sin = &*(entry.ifa_addr as *const libc::sockaddr_in);
+                    IpAddr::V4(Ipv4Addr::from(sin.sin_addr.s_addr.to_ne_bytes()))
+                }
+                libc::AF_INET6 => {
+                    let sin6 = &*(entry.ifa_addr as *const libc::sockaddr_in6);
+                    IpAddr::V6(Ipv6Addr::from(sin6.sin6_addr.s6_addr))
+                }
+                _ => continue,
+            };
+            let name = CStr::from_ptr(entry.ifa_name)
+                .to_string_lossy()
+                .into_owned();
+            found.entry(addr).or_insert(name);
+        }
+        libc::freeifaddrs(ifap);
+    }
+    found
+}
+
+/// Build the identity of one mount. `route` and `interfaces` are passed in
+/// so callers can cache them and tests can stub them.
+pub fn identify<F>(
+    section: &MountSection,
+    route: F,
+    interfaces: &HashMap<IpAddr, String>,
+) -> ClientIdentity
+where
+    F: FnMut(IpAddr) -> Option<IpAddr>,
+{
+    let opts = MountOptions::from_section(section);
+    let server_addr = parse_addr(opts.get("addr")).or_else(|| parse_addr(opts.get("mountaddr")));
+    let clientaddr = parse_addr(opts.get("clientaddr"));
+    let route_src = server_addr.and_then(route);
+
+    let mut identity = ClientIdentity {
+        mount_point: section.mount_point.clone(),
+        server_addr,
+        clientaddr,
+        route_src,
+        interface: None,
+    };
+    identity.interface = identity
+        .local_addr()
+        .and_then(|a| interfaces.get(&a).cloned());
+    identity
+}
+
+/// Identities for every mount, using the live routing table.
+pub fn identify_all(sections: &[MountSection]) -> Vec<ClientIdentity> {
+    let interfaces = interface_addresses();
+    let mut routes: HashMap<IpAddr, Option<IpAddr>> = HashMap::new();
+    sections
+        .iter()
+        .map(|s| {
+            identify(
+                s,
+                |server| *routes.entry(server).or_insert_with(|| route_source(server)),
+                &interfaces,
+            )
+        })
+        .collect()
+}
+
+pub fn display_identities<W: Write>(
+    writer: &mut W,
+    identities: &[ClientIdentity],
+) -> io::Result<()> {
+    if identities.is_empty() {
+        return Ok(());
+    }
+
+    let show = |addr: Option<IpAddr>| addr.map_or_else(|| "-".to_string(), |a| a.to_string());
+    writeln!(
+        writer,
+        "{:<30} {:<18} {:<18} {:<18} {:<10}",
+        "MOUNT", "SERVER ADDR", "CLIENTADDR", "ROUTE SRC", "IFACE"
+    )?;
+    writeln!(writer, "{}", "-".repeat(98))?;
+    for id in identities {
+        writeln!(
+            writer,
+            "{:<30} {:<18} {:<18} {:<18} {:<10}{}",
+            id.mount_point,
+            show(id.server_addr),
+            show(id.clientaddr),
+            show(id.route_src),
+            id.interface.as_deref().unwrap_or("-"),
+            if id.mismatched() {
+                "  clientaddr differs from route"
+            } else {
+                ""
+            }
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::parse_sections;
+    use crate::sections::tests::MOUNTSTATS;
+
+    #[test]
+    fn test_identify() {
+        let sections = parse_sections(MOUNTSTATS);
+        let interfaces: HashMap<IpAddr, String> = [
+            ("10.0.0.2".parse().unwrap(), "eth0".to_string()),
+            ("10.1.0.2".parse().unwrap(), "eth1".to_string()),
+        ]
+        .into_iter()
+        .collect();
+
+        // v4 mount with clientaddr= but no addr=: no route lookup possible.
+        let v4 = identify(&sections[0], |_| None, &interfaces);
+        assert_eq!(v4.clientaddr, Some("10.0.0.2".parse().unwrap()));
+        assert_eq!(v4.interface.as_deref(), Some("eth0"));
+        assert!(!v4.mismatched());
+
+        // v3 mount falls back to mountaddr= and the routed source.
+        let v3 = identify(
+            &sections[1],
+            |_| Some("10.1.0.2".parse().unwrap()),
+            &interfaces,
+        );
+        assert_eq!(v3.server_addr, Some("10.0.0.9".parse().unwrap()));
+        assert_eq!(v3.clientaddr, None);
+        assert_eq!(v3.interface.as_deref(), Some("eth1"));
+    }
+
+    #[test]
+    fn test_mismatch() {
+        let id = ClientIdentity {
+            mount_point: "/mnt".to_string(),
+            server_addr: Some("192.168.1.10".parse().unwrap()),
+            clientaddr: Some("10.0.0.2".parse().unwrap()),
+            route_src: Some("192.168.1.2".parse().unwrap()),
+            interface: Some("eth0".to_string()),
+        };
+        assert!(id.mismatched());
+
+        let mut out = Vec::new();
+        display_identities(&mut out, &[id]).unwrap();
+        assert!(String::from_utf8(out)
+            .unwrap()
+            .contains("clientaddr differs from route"));
+
+        let unspecified = ClientIdentity {
+            mount_point: "/mnt".to_string(),
+            server_addr: None,
+            clientaddr: Some("0.0.0.0".parse().unwrap()),
+            route_src: Some("192.168.1.2".parse().unwrap()),
+            interface: None,
+        };
+        assert!(!unspecified.mismatched());
+        assert_eq!(unspecified.local_addr(), unspecified.route_src);
+    }
+}
@@ -0,0 +1,85 @@
+//! `--exit-after-idle`: stop once every monitored mount has been quiet
+//! for N consecutive intervals, e.g. after a wrapped benchmark finishes.
+
+use crate::types::DeltaStats;
+use clap::Args;
+
+#[derive(Args, Debug, Clone)]
+pub struct IdleArgs {
+    /// Exit after N consecutive intervals with no activity on any monitored mount
+    #[arg(long = "exit-after-idle", value_name = "N")]
+    pub exit_after_idle: Option<u32>,
+}
+
+/// Whether an interval's rows show no activity at all.
+pub fn is_idle<'a, I>(stats: I) -> bool
+where
+    I: IntoIterator<Item = &'a DeltaStats>,
+{
+    stats
+        .into_iter()
+        .all(|s| s.delta_ops == 0 && s.delta_retrans == 0 && s.delta_bytes == 0)
+}
+
+#[derive(Debug, Clone)]
+pub struct IdleTracker {
+    limit: u32,
+    consecutive: u32,
+}
+
+impl IdleTracker {
+    pub fn new(limit: u32) -> Self {
+        Self {
+            limit: limit.max(1),
+            consecutive: 0,
+        }
+    }
+
+    pub fn from_args(args: &IdleArgs) -> Option<Self> {
+        args.exit_after_idle.map(Self::new)
+    }
+
+    /// Record one interval across all mounts. Returns true once the idle
+    /// limit has been reached and monitoring should stop.
+    pub fn observe(&mut self, idle: bool) -> bool {
+        if idle {
+            self.consecutive += 1;
+        } else {
+            self.consecutive = 0;
+        }
+        self.consecutive >= self.limit
+    }
+
+    pub fn consecutive(&self) -> u32 {
+        self.consecutive
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_is_idle() {
+        assert!(is_idle(&[stat("READ", 0, 0.0), stat("WRITE", 0, 0.0)]));
+        assert!(!is_idle(&[stat("READ", 0, 0.0), stat("WRITE", 1, 1.0)]));
+
+        let mut stalled = stat("WRITE", 0, 0.0);
+        stalled.delta_retrans = 1;
+        assert!(!is_idle(&[stalled]));
+        assert!(is_idle(&[]));
+    }
+
+    #[test]
+    fn test_tracker_resets_on_activity() {
+        let mut tracker = IdleTracker::new(3);
+        assert!(!tracker.observe(true));
+        assert!(!tracker.observe(true));
+        assert!(!tracker.observe(false));
+        assert_eq!(tracker.consecutive(), 0);
+        assert!(!tracker.observe(true));
+        assert!(!tracker.observe(true));
+        assert!(tracker.observe(true));
+    }
+}
@@ -0,0 +1,292 @@
+//! `--k8s`: per-pod NFS statistics on a Kubernetes node, found by
+//! scanning /proc rather than asking the API server.
+//!
+//! Container processes are mapped to pods through the pod UID in their
+//! cgroup path. The pod's name and namespace come from the kubelet's
+//! per-pod directory, so no credentials or sidecars are needed. Each
+//! container has its own mount namespace; the NFS volumes they share are
+//! the same kernel mount, so they are counted once per pod.
+
+use crate::cgroups::parse_ns_link;
+use crate::labels::Labels;
+use crate::parser::parse_mountstats;
+use crate::selection::MountSelector;
+use crate::types::NFSMount;
+use clap::Args;
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::io;
+use std::path::Path;
+
+#[derive(Args, Debug, Clone)]
+pub struct K8sArgs {
+    /// Discover Kubernetes pods on this node and report NFS statistics per pod
+    #[arg(long = "k8s")]
+    pub k8s: bool,
+
+    /// Kubelet state directory, used to name pods
+    #[arg(long = "kubelet-root", default_value = "/var/lib/kubelet")]
+    pub kubelet_root: String,
+}
+
+/// Pod UID from a cgroup path: `kubepods-burstable-pod<uid>.slice` with
+/// the systemd driver (dashes escaped as underscores), `pod<uid>` with
+/// cgroupfs.
+pub fn pod_uid(cgroup_path: &str) -> Option<String> {
+    cgroup_path.split('/').find_map(|component| {
+        let component = component.strip_suffix(".slice").unwrap_or(component);
+        let uid = component
+            .rsplit_once("-pod")
+            .map_or_else(|| component.strip_prefix("pod"), |(_, uid)| Some(uid))?;
+        let uid = uid.replace('_', "-");
+        (uid.len() == 36 && uid.bytes().all(|b| b.is_ascii_hexdigit() || b == b'-')).then_some(uid)
+    })
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, PartialOrd, Ord)]
+pub struct PodInfo {
+    pub namespace: String,
+    pub name: String,
+    pub uid: String,
+}
+
+impl PodInfo {
+    /// `labels` plus `namespace` and `pod`.
+    pub fn labels(&self, labels: &Labels) -> Labels {
+        let mut labels = labels.clone();
+        labels.insert("namespace", &self.namespace);
+        labels.insert("pod", &self.name);
+        labels
+    }
+
+    /// `namespace/pod`, or just the pod when its namespace is unknown.
+    pub fn tag(&self) -> String {
+        if self.namespace.is_empty() {
+            self.name.clone()
+        } else {
+            format!("{}/{}", self.namespace, self.name)
+        }
+    }
+}
+
+/// The pod's hostname from the kubelet-managed /etc/hosts; it is the pod
+/// name unless the spec overrides `hostname`.
+fn hosts_name(contents: &str) -> Option<String> {
+    contents
+        .lines()
+        .map(str::trim)
+        .filter(|l| !l.is_empty() && !l.starts_with('#'))
+        .next_back()?
+        .split_whitespace()
+        .nth(1)
+        .map(str::to_string)
+}
+
+/// Namespace from the service account volume every pod gets by default.
+fn projected_namespace(pod_dir: &Path) -> Option<String> {
+    let projected = pod_dir.join("volumes/kubernetes.io~projected");
+    fs::read_dir(projected).ok()?.flatten().find_map(|volume| {
+        fs::read_to_string(volume.path().join("namespace"))
+            .ok()
+            .map(|ns| ns.trim().to_string())
+    })
+}
+
+/// Name a pod from the kubelet directory, falling back to its UID.
+pub fn pod_info(kubelet_root: &str, uid: &str) -> PodInfo {
+    let pod_dir = Path::new(kubelet_root).join("pods").join(uid);
+    let name = fs::read_to_string(pod_dir.join("etc-hosts"))
+        .ok()
+        .and_then(|c| hosts_name(&c))
+        .unwrap_or_else(|| format!("pod-{}", &uid[..8]));
+    PodInfo {
+        namespace: projected_namespace(&pod_dir).unwrap_or_default(),
+        name,
+        uid: uid.to_string(),
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Pod {
+    pub info: PodInfo,
+    /// One member process per mount namespace, lowest PID first.
+    pub pids: Vec<u32>,
+}
+
+/// Walk `proc_root` and group container processes into pods.
+pub fn discover_pods(proc_root: &str, kubelet_root: &str) -> io::Result<Vec<Pod>> {
+    let mut by_uid: BTreeMap<String, BTreeMap<u64, u32>> = BTreeMap::new();
+
+    for entry in fs::read_dir(proc_root)?.flatten() {
+        let Some(pid) = entry
+            .file_name()
+            .to_str()
+            .and_then(|s| s.parse::<u32>().ok())
+        else {
+            continue;
+        };
+        let base = entry.path();
+        // Processes can exit mid-scan; skip anything we cannot read.
+        let Some(uid) = fs::read_to_string(base.join("cgroup")).ok().and_then(|c| {
+            c.lines()
+                .find_map(|l| l.splitn(3, ':').nth(2).and_then(pod_uid))
+        }) else {
+            continue;
+        };
+        let Some(inode) = fs::read_link(base.join("ns/mnt"))
+            .ok()
+            .and_then(|l| parse_ns_link(&l.to_string_lossy()))
+        else {
+            continue;
+        };
+        let first = by_uid.entry(uid).or_default().entry(inode).or_insert(pid);
+        *first = (*first).min(pid);
+    }
+
+    let mut pods: Vec<Pod> = by_uid
+        .into_iter()
+        .map(|(uid, namespaces)| {
+            let mut pids: Vec<u32> = namespaces.into_values().collect();
+            pids.sort_unstable();
+            Pod {
+                info: pod_info(kubelet_root, &uid),
+                pids,
+            }
+        })
+        .collect();
+    pods.sort_by(|a, b| a.info.cmp(&b.info));
+    Ok(pods)
+}
+
+/// NFS mounts of `pod`, read through each of its mount namespaces. A
+/// volume mounted into several containers appears once, under the mount
+/// point of the first container that has it.
+pub fn pod_mounts(proc_root: &str, pod: &Pod) -> Vec<NFSMount> {
+    let mut seen: HashMap<String, NFSMount> = HashMap::new();
+    let mut order = Vec::new();
+    for pid in &pod.pids {
+        let path = format!("{}/{}/mountstats", proc_root, pid);
+        for mount in parse_mountstats(&path).unwrap_or_default() {
+            if !seen.contains_key(&mount.device) {
+                order.push(mount.device.clone());
+                seen.insert(mount.device.clone(), mount);
+            }
+        }
+    }
+    order
+        .into_iter()
+        .filter_map(|device| seen.remove(&device))
+        .collect()
+}
+
+/// Mounts of every pod that has any, keyed by pod UID.
+pub fn snapshot(proc_root: &str, pods: &[Pod]) -> HashMap<String, Vec<NFSMount>> {
+    pods.iter()
+        .map(|pod| (pod.info.uid.clone(), pod_mounts(proc_root, pod)))
+        .filter(|(_, mounts)| !mounts.is_empty())
+        .collect()
+}
+
+/// Selected NFS mounts of every pod, ready for the normal tracker. Mount
+/// points are tagged with their pod, e.g. `/data [payments/api-7d9f]`, so
+/// pods mounting the same path stay apart, and each mount carries
+/// `labels` plus its pod's.
+pub fn labelled_mounts(
+    proc_root: &str,
+    kubelet_root: &str,
+    selector: &MountSelector,
+    labels: &Labels,
+) -> io::Result<Vec<(NFSMount, Labels)>> {
+    let mut found = Vec::new();
+    for pod in discover_pods(proc_root, kubelet_root)? {
+        let pod_labels = pod.info.labels(labels);
+        for mut mount in pod_mounts(proc_root, &pod) {
+            if !selector.matches(&mount.mount_point) {
+                continue;
+            }
+            mount.mount_point = format!("{} [{}]", mount.mount_point, pod.info.tag());
+            found.push((mount, pod_labels.clone()));
+        }
+    }
+    Ok(found)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::tests::MOUNTSTATS;
+    use std::os::unix::fs::symlink;
+
+    const UID: &str = "6b1c2a3e-0d4f-4e5a-9b8c-7d6e5f4a3b2c";
+
+    #[test]
+    fn test_pod_uid() {
+        let systemd = format!(
+            "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod{}.slice/cri-containerd-abc.scope",
+            UID.replace('-', "_")
+        );
+        assert_eq!(pod_uid(&systemd).as_deref(), Some(UID));
+        let cgroupfs = format!("/kubepods/besteffort/pod{}/0123abcd", UID);
+        assert_eq!(pod_uid(&cgroupfs).as_deref(), Some(UID));
+        assert_eq!(pod_uid("/kubepods.slice/kubepods-burstable.slice"), None);
+        assert_eq!(pod_uid("/system.slice/kubelet.service"), None);
+    }
+
+    #[test]
+    fn test_discover_pods() {
+        let proc_root = tempfile::tempdir().unwrap();
+        let kubelet = tempfile::tempdir().unwrap();
+        let cgroup = format!("0::/kubepods/burstable/pod{}/ctr\n", UID);
+        for (pid, ns, cgroup) in [
+            (40, 400, cgroup.as_str()),
+            (41, 400, cgroup.as_str()),
+            (42, 401, cgroup.as_str()),
+            (5, 1, "0::/system.slice/kubelet.service\n"),
+        ] {
+            let dir = proc_root.path().join(pid.to_string());
+            fs::create_dir_all(dir.join("ns")).unwrap();
+            symlink(format!("mnt:[{}]", ns), dir.join("ns/mnt")).unwrap();
+            fs::write(dir.join("cgroup"), cgroup).unwrap();
+        }
+        let pod_dir = kubelet.path().join("pods").join(UID);
+        let token = pod_dir.join("volumes/kubernetes.io~projected/kube-api-access-x1");
+        fs::create_dir_all(&token).unwrap();
+        fs::write(token.join("namespace"), "payments").unwrap();
+        fs::write(
+            pod_dir.join("etc-hosts"),
+            "# Kubernetes-managed hosts file.\n127.0.0.1\tlocalhost\n10.1.2.3\tapi-7d9f\n",
+        )
+        .unwrap();
+
+        let pods = discover_pods(
+            proc_root.path().to_str().unwrap(),
+            kubelet.path().to_str().unwrap(),
+        )
+        .unwrap();
+        assert_eq!(pods.len(), 1);
+        assert_eq!(pods[0].pids, vec![40, 42]);
+        assert_eq!(pods[0].info.name, "api-7d9f");
+        assert_eq!(pods[0].info.namespace, "payments");
+
+        let labels = pods[0].info.labels(&Labels::new());
+        assert_eq!(labels.get("pod"), Some("api-7d9f"));
+        assert_eq!(labels.get("namespace"), Some("payments"));
+
+        fs::write(proc_root.path().join("40/mountstats"), MOUNTSTATS).unwrap();
+        fs::write(proc_root.path().join("42/mountstats"), MOUNTSTATS).unwrap();
+        let mounts = labelled_mounts(
+            proc_root.path().to_str().unwrap(),
+            kubelet.path().to_str().unwrap(),
+            &MountSelector::new(&["/mnt/nfs"]),
+            &Labels::new(),
+        )
+        .unwrap();
+        assert_eq!(mounts.len(), 1);
+        assert_eq!(mounts[0].0.mount_point, "/mnt/nfs [payments/api-7d9f]");
+        assert_eq!(mounts[0].1.get("pod"), Some("api-7d9f"));
+
+        let unnamed = pod_info("/nonexistent", UID);
+        assert_eq!(unnamed.name, "pod-6b1c2a3e");
+        assert_eq!(unnamed.namespace, "");
+    }
+}
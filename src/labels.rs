@@ -0,0 +1,132 @@
+//! Static key=value labels (env=prod, cluster=hpc01, rack=12) attached
+//! to every exported sample, report and alert.
+
+use clap::Args;
+use serde_json::{Map, Value};
+use std::collections::BTreeMap;
+use std::fmt;
+
+#[derive(Args, Debug, Clone)]
+pub struct LabelArgs {
+    /// Attach a static label to all output, e.g. --label env=prod (repeatable)
+    #[arg(long = "label", value_name = "KEY=VALUE")]
+    pub labels: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct Labels(BTreeMap<String, String>);
+
+/// Keys must be usable as Prometheus label names and JSON field names.
+fn valid_key(key: &str) -> bool {
+    let mut chars = key.chars();
+    matches!(chars.next(), Some(c) if c.is_ascii_alphabetic() || c == '_')
+        && chars.all(|c| c.is_ascii_alphanumeric() || c == '_')
+}
+
+impl Labels {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Add one `key=value` definition; a repeated key replaces the earlier
+    /// value so command-line flags can override config.
+    pub fn add(&mut self, definition: &str) -> std::result::Result<(), String> {
+        let (key, value) = definition
+            .split_once('=')
+            .ok_or_else(|| format!("label '{}' must be KEY=VALUE", definition))?;
+        let key = key.trim();
+        if !valid_key(key) {
+            return Err(format!("invalid label name '{}'", key));
+        }
+        self.0.insert(key.to_string(), value.trim().to_string());
+        Ok(())
+    }
+
+    pub fn from_args(args: &LabelArgs) -> std::result::Result<Self, String> {
+        let mut labels = Self::new();
+        for definition in &args.labels {
+            labels.add(definition)?;
+        }
+        Ok(labels)
+    }
+
+    /// Set a label directly. Callers supply trusted, valid keys.
+    pub fn insert(&mut self, key: &str, value: &str) {
+        debug_assert!(valid_key(key), "invalid label name {}", key);
+        self.0.insert(key.to_string(), value.to_string());
+    }
+
+    pub fn get(&self, key: &str) -> Option<&str> {
+        self.0.get(key).map(String::as_str)
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+
+    pub fn iter(&self) -> impl Iterator<Item = (&str, &str)> {
+        self.0.iter().map(|(k, v)| (k.as_str(), v.as_str()))
+    }
+
+    /// Insert every label as a top-level field, without overwriting
+    /// fields the sample already has.
+    pub fn extend_json(&self, fields: &mut Map<String, Value>) {
+        for (key, value) in &self.0 {
+            fields
+                .entry(key.clone())
+                .or_insert_with(|| value.clone().into());
+        }
+    }
+}
+
+impl fmt::Display for Labels {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let mut first = true;
+        for (key, value) in &self.0 {
+            if !first {
+                f.write_str(" ")?;
+            }
+            write!(f, "{}={}", key, value)?;
+            first = false;
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_labels() {
+        let labels = Labels::from_args(&LabelArgs {
+            labels: vec![
+                "env=prod".to_string(),
+                "rack=12".to_string(),
+                "env=staging".to_string(),
+                "note=a=b".to_string(),
+            ],
+        })
+        .unwrap();
+        assert_eq!(labels.to_string(), "env=staging note=a=b rack=12");
+
+        let mut bad = Labels::new();
+        assert!(bad.add("novalue").is_err());
+        assert!(bad.add("1st=x").is_err());
+        assert!(bad.add("has-dash=x").is_err());
+        assert!(bad.is_empty());
+    }
+
+    #[test]
+    fn test_extend_json_keeps_existing_fields() {
+        let mut labels = Labels::new();
+        labels.add("cluster=hpc01").unwrap();
+        labels.add("mount_point=spoofed").unwrap();
+
+        let mut fields = Map::new();
+        fields.insert("mount_point".to_string(), "/mnt".into());
+        labels.extend_json(&mut fields);
+        assert_eq!(fields["cluster"], "hpc01");
+        assert_eq!(fields["mount_point"], "/mnt");
+    }
+}
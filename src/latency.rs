@@ -0,0 +1,263 @@
+//! Per-RPC latency histograms from the `sunrpc:rpc_stats_latency`
+//! tracepoint (`--latency-hist`).
+//!
+//! mountstats only gives summed RTT and execute times, so its averages
+//! hide the tail: one 2s READ among a thousand 1ms ones barely moves the
+//! mean. The tracepoint fires once per completed RPC with that call's own
+//! timings, which is enough for real distributions without an eBPF
+//! toolchain. It carries no mount information, so histograms are per
+//! operation across all mounts.
+
+use crate::tracefs::{self, TraceRecord};
+use clap::Args;
+use std::collections::BTreeMap;
+use std::io::{self, Write};
+
+pub const LATENCY_EVENT: &str = "sunrpc/rpc_stats_latency";
+
+const BUCKETS: usize = 32;
+const BAR_WIDTH: usize = 40;
+
+#[derive(Args, Debug, Clone)]
+pub struct LatencyArgs {
+    /// Show per-op RPC latency histograms from sunrpc tracepoints (requires root)
+    #[arg(long = "latency-hist")]
+    pub latency_hist: bool,
+}
+
+/// Power-of-two microsecond buckets, as bcc's nfsdist prints: bucket `i`
+/// holds values in `[2^i, 2^(i+1))`, with 0 in bucket 0.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Log2Histogram {
+    buckets: [u64; BUCKETS],
+    count: u64,
+    sum_us: u64,
+    max_us: u64,
+}
+
+impl Default for Log2Histogram {
+    fn default() -> Self {
+        Self {
+            buckets: [0; BUCKETS],
+            count: 0,
+            sum_us: 0,
+            max_us: 0,
+        }
+    }
+}
+
+fn bucket_low(index: usize) -> u64 {
+    if index == 0 {
+        0
+    } else {
+        1 << index
+    }
+}
+
+impl Log2Histogram {
+    pub fn record(&mut self, us: u64) {
+        let index = (u64::BITS - us.leading_zeros()).saturating_sub(1) as usize;
+        self.buckets[index.min(BUCKETS - 1)] += 1;
+        self.count += 1;
+        self.sum_us = self.sum_us.saturating_add(us);
+        self.max_us = self.max_us.max(us);
+    }
+
+    pub fn count(&self) -> u64 {
+        self.count
+    }
+
+    pub fn mean_us(&self) -> f64 {
+        if self.count == 0 {
+            0.0
+        } else {
+            self.sum_us as f64 / self.count as f64
+        }
+    }
+
+    pub fn max_us(&self) -> u64 {
+        self.max_us
+    }
+
+    /// Estimate the `p`th percentile (0-100) by interpolating within the
+    /// bucket it falls in; never above the largest value seen.
+    pub fn percentile(&self, p: f64) -> f64 {
+        if self.count == 0 {
+            return 0.0;
+        }
+        let rank = (p / 100.0 * self.count as f64).max(1.0);
+        let mut seen = 0u64;
+        for (i, &n) in self.buckets.iter().enumerate() {
+            if n == 0 {
+                continue;
+            }
+            if (seen + n) as f64 >= rank {
+                let low = bucket_low(i) as f64;
+                let high = bucket_low(i + 1) as f64;
+                let within = (rank - seen as f64) / n as f64;
+                return (low + (high - low) * within).min(self.max_us as f64);
+            }
+            seen += n;
+        }
+        self.max_us as f64
+    }
+
+    /// Non-empty buckets as `(low, high, count)`, from the first to the
+    /// last populated bucket so gaps stay visible.
+    pub fn rows(&self) -> Vec<(u64, u64, u64)> {
+        let first = self.buckets.iter().position(|&n| n > 0);
+        let last = self.buckets.iter().rposition(|&n| n > 0);
+        match (first, last) {
+            (Some(first), Some(last)) => (first..=last)
+                .map(|i| (bucket_low(i), bucket_low(i + 1) - 1, self.buckets[i]))
+                .collect(),
+            _ => Vec::new(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct OpLatency {
+    pub rtt: Log2Histogram,
+    /// Time from the call being queued to its completion; what the
+    /// application waited for.
+    pub execute: Log2Histogram,
+}
+
+/// Parse `task:1@2 xid=0x6f9a8bf4 nfsv4 READ backlog=23 rtt=1104
+/// execute=1148` into the procedure name and the rtt and execute times.
+pub fn parse_latency(payload: &str) -> Option<(String, u64, u64)> {
+    let tokens: Vec<&str> = payload.split_whitespace().collect();
+    let backlog = tokens.iter().position(|t| t.starts_with("backlog="))?;
+    let procedure = tokens.get(backlog.checked_sub(1)?)?;
+    if procedure.contains('=') {
+        return None;
+    }
+    let number = |key| tracefs::field(payload, key).and_then(|v| v.parse().ok());
+    Some((procedure.to_uppercase(), number("rtt")?, number("execute")?))
+}
+
+#[derive(Debug, Default)]
+pub struct LatencyTracker {
+    ops: BTreeMap<String, OpLatency>,
+}
+
+impl LatencyTracker {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn record(&mut self, record: &TraceRecord) {
+        if record.event != "rpc_stats_latency" {
+            return;
+        }
+        if let Some((op, rtt, execute)) = parse_latency(&record.payload) {
+            let latency = self.ops.entry(op).or_default();
+            latency.rtt.record(rtt);
+            latency.execute.record(execute);
+        }
+    }
+
+    /// The interval's histograms in operation order, starting afresh.
+    pub fn take_interval(&mut self) -> Vec<(String, OpLatency)> {
+        std::mem::take(&mut self.ops).into_iter().collect()
+    }
+}
+
+pub fn display_latency_histograms<W: Write>(
+    writer: &mut W,
+    ops: &[(String, OpLatency)],
+) -> io::Result<()> {
+    for (op, latency) in ops {
+        let h = &latency.execute;
+        writeln!(
+            writer,
+            "{} latency ({} calls): avg {:.0}us, p50 {:.0}us, p95 {:.0}us, p99 {:.0}us, max {}us (rtt p99 {:.0}us)",
+            op,
+            h.count(),
+            h.mean_us(),
+            h.percentile(50.0),
+            h.percentile(95.0),
+            h.percentile(99.0),
+            h.max_us(),
+            latency.rtt.percentile(99.0)
+        )?;
+        let rows = h.rows();
+        let peak = rows.iter().map(|r| r.2).max().unwrap_or(0).max(1);
+        for (low, high, count) in rows {
+            let bar = (count as usize * BAR_WIDTH).div_ceil(peak as usize);
+            writeln!(
+                writer,
+                "  {:>10} -> {:<10} : {:<8} |{:<width$}|",
+                low,
+                high,
+                count,
+                "*".repeat(bar),
+                width = BAR_WIDTH
+            )?;
+        }
+        writeln!(writer)?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::tracefs::parse_record;
+
+    #[test]
+    fn test_parse_latency() {
+        let line = "  dd-4312  [001] ..... 8123.456789: rpc_stats_latency: task:00000011@00000001 xid=0x6f9a8bf4 nfsv4 READ backlog=23 rtt=1104 execute=1148";
+        let record = parse_record(line).unwrap();
+        assert_eq!(
+            parse_latency(&record.payload),
+            Some(("READ".to_string(), 1104, 1148))
+        );
+        assert_eq!(parse_latency("task:1@1 xid=0x1 backlog=1 rtt=2"), None);
+
+        let mut tracker = LatencyTracker::new();
+        tracker.record(&record);
+        tracker.record(&record);
+        let ops = tracker.take_interval();
+        assert_eq!(ops[0].0, "READ");
+        assert_eq!(ops[0].1.execute.count(), 2);
+        assert!(tracker.take_interval().is_empty());
+    }
+
+    #[test]
+    fn test_histogram_percentiles() {
+        let mut h = Log2Histogram::default();
+        for _ in 0..99 {
+            h.record(1000);
+        }
+        h.record(2_000_000);
+
+        assert_eq!(h.count(), 100);
+        // 1000us lands in the 512-1023 bucket.
+        assert!(h.percentile(50.0) >= 512.0 && h.percentile(50.0) <= 1024.0);
+        assert!(h.percentile(99.0) <= 1024.0);
+        assert_eq!(h.percentile(100.0), 2_000_000.0);
+        assert!((h.mean_us() - 20_990.0).abs() < 1e-6);
+
+        let rows = h.rows();
+        assert_eq!(rows.first(), Some(&(512, 1023, 99)));
+        assert_eq!(rows.last(), Some(&(1_048_576, 2_097_151, 1)));
+
+        let mut out = Vec::new();
+        display_latency_histograms(
+            &mut out,
+            &[(
+                "READ".to_string(),
+                OpLatency {
+                    rtt: h.clone(),
+                    execute: h,
+                },
+            )],
+        )
+        .unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.starts_with("READ latency (100 calls)"));
+        assert!(text.contains("max 2000000us"));
+    }
+}
@@ -0,0 +1,120 @@
+//! nfs-gaze: per-operation NFS client statistics from
+//! `/proc/self/mountstats`.
+//!
+//! The binary is a thin wrapper around these modules. Library users
+//! should start from [`mountstats`], the stable subset of the API.
+
+pub mod advisor;
+pub mod agent;
+pub mod aggregate;
+pub mod alert;
+pub mod allns;
+pub mod attribution;
+pub mod baseline;
+pub mod bench;
+pub mod bytes;
+pub mod capacity;
+pub mod census;
+pub mod cgroups;
+pub mod check;
+pub mod cli;
+pub mod collector;
+#[cfg(feature = "parquet")]
+pub mod columnar;
+pub mod columns;
+pub mod compare;
+pub mod config;
+pub mod correlation;
+pub mod cumulative;
+pub mod deepdebug;
+pub mod delegation;
+pub mod delta;
+pub mod diff;
+pub mod display;
+pub mod env;
+pub mod errcodes;
+pub mod exitsummary;
+pub mod exporter;
+pub mod firstreport;
+pub mod fleet;
+pub mod gnuplot;
+pub mod graph;
+pub mod graphite;
+#[cfg(feature = "grpc")]
+pub mod grpc;
+pub mod histogram;
+pub mod hostmeta;
+pub mod identity;
+pub mod idle;
+pub mod k8s;
+pub mod labels;
+pub mod latency;
+pub mod monitor;
+pub mod mountinfo;
+pub mod mountstats;
+pub mod nfsd;
+pub mod notify;
+pub mod once;
+pub mod options;
+pub mod ordering;
+pub mod output;
+pub mod parser;
+pub mod perop;
+pub mod pnfs;
+pub mod presets;
+pub mod quantile;
+pub mod quiet;
+pub mod readahead;
+pub mod record;
+pub mod recovery;
+pub mod redact;
+pub mod replay;
+pub mod report;
+pub mod resolve;
+pub mod retrans;
+pub mod rollup;
+pub mod sampling;
+pub mod sandbox;
+pub mod sections;
+pub mod security;
+pub mod selection;
+pub mod serve;
+pub mod servergroups;
+pub mod session;
+pub mod sink;
+pub mod slab;
+pub mod slots;
+pub mod smooth;
+pub mod spans;
+pub mod spark;
+pub mod statsd;
+#[cfg(feature = "sqlite")]
+pub mod store;
+pub mod summary;
+pub mod sunrpc;
+pub mod talkers;
+pub mod target;
+#[cfg(test)]
+pub(crate) mod testutil;
+pub mod tls;
+pub mod totals;
+pub mod tracefs;
+pub mod tracker;
+pub mod tui;
+pub mod types;
+pub mod units;
+pub mod watcher;
+pub mod watchop;
+pub mod websocket;
+pub mod wide;
+pub mod writeback;
+pub mod xprt;
+pub mod zabbix;
+
+pub use parser::{parse_events, parse_mountstats, parse_nfs_operation};
+pub use types::*;
+
+/// Compiles the README's Rust examples with the doctests.
+#[cfg(doctest)]
+#[doc = include_str!("../README.md")]
+pub struct ReadmeDoctests;
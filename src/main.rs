@@ -0,0 +1,238 @@
+#[cfg(not(target_os = "linux"))]
+compile_error!("nfs-gaze only works on Linux");
+
+use clap::{CommandFactory, FromArgMatches};
+use nfs_gaze::agent::run_agent;
+use nfs_gaze::allns;
+use nfs_gaze::baseline::{self, BaselineCommand};
+use nfs_gaze::bench::{display_bench, run_bench};
+use nfs_gaze::check::{run_check, write_json, write_summary};
+use nfs_gaze::cli::{Args, Command};
+use nfs_gaze::collector::run_collector;
+use nfs_gaze::columns::columns;
+use nfs_gaze::compare::{compare, display_comparison, run_compare};
+use nfs_gaze::config::{config_path, with_defaults, Config};
+use nfs_gaze::diff::run_diff;
+use nfs_gaze::env::all_settings;
+use nfs_gaze::fleet::run_fleet;
+use nfs_gaze::hostmeta::HostMetadata;
+use nfs_gaze::k8s;
+use nfs_gaze::labels::Labels;
+use nfs_gaze::monitor::{replay_monitor, run_monitor, run_scanned, run_sinks};
+use nfs_gaze::nfsd::run_server;
+use nfs_gaze::once::run_once;
+use nfs_gaze::presets;
+use nfs_gaze::selection::MountSelector;
+use nfs_gaze::serve::run_serve;
+use nfs_gaze::target::mountstats_path;
+use nfs_gaze::tracker::MountTracker;
+use nfs_gaze::tui::run_tui;
+use nfs_gaze::{NfsGazeError, Result};
+use signal_hook::consts::{SIGINT, SIGTERM};
+use signal_hook::low_level;
+use std::io;
+use std::process;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+
+/// Clear the returned flag on SIGINT or SIGTERM so loops can finish
+/// their interval and exit cleanly. The flag is cleared from the handler
+/// itself rather than a thread, so `--sandbox` confines the whole process.
+fn install_signal_handler() -> io::Result<Arc<AtomicBool>> {
+    let running = Arc::new(AtomicBool::new(true));
+    for signal in [SIGINT, SIGTERM] {
+        let r = running.clone();
+        // SAFETY: the handler only stores to an atomic, which is
+        // async-signal-safe.
+        unsafe { low_level::register(signal, move || r.store(false, Ordering::SeqCst)) }?;
+    }
+    Ok(running)
+}
+
+/// Parse the command line over the `NFS_GAZE_*` variables and the
+/// `--config` file's settings, which become the flags' defaults.
+fn parse_args() -> Result<Args> {
+    let argv: Vec<String> = std::env::args().collect();
+    let config = config_path(&argv)
+        .map(|path| Config::load(&path))
+        .transpose()?;
+    let command = Args::command();
+    // Variables that aren't valid UTF-8 can't be ours; std::env::vars
+    // would panic on them.
+    let vars = std::env::vars_os()
+        .filter_map(|(name, value)| Some((name.into_string().ok()?, value.into_string().ok()?)));
+    let settings = all_settings(vars, config.as_ref(), &command)?;
+    let matches = with_defaults(command, &settings).get_matches_from(argv);
+    let mut args = Args::from_arg_matches(&matches).unwrap_or_else(|e| e.exit());
+    match config {
+        Some(config) => {
+            let groups = config.group_patterns(&args.config.group)?;
+            args.patterns.m_glob.extend(groups);
+            args.config.thresholds = config.thresholds;
+        }
+        None if !args.config.group.is_empty() => {
+            return Err(NfsGazeError::ParseError(
+                "--group needs a --config file with [groups]".to_string(),
+            ));
+        }
+        None => {}
+    }
+    args.mountstats_path =
+        mountstats_path(&args.target, &args.cgroups.proc_root, &args.mountstats_path)?;
+    // Mountstats is redacted as the live monitor reads it, and only the
+    // monitor knows when it is done opening files and can sandbox; the
+    // other modes would print real names or run unconfined.
+    if let Some(mode) = args.other_mode() {
+        for (set, flag) in [
+            (args.redact.redact, "--redact"),
+            (args.sandbox.sandbox, "--sandbox"),
+        ] {
+            if set {
+                return Err(NfsGazeError::ParseError(format!(
+                    "{} only applies to the live monitor, not {}",
+                    flag, mode
+                )));
+            }
+        }
+    }
+    Ok(args)
+}
+
+/// `--label` values plus the host metadata, for modes that export.
+fn labels(args: &Args) -> Result<Labels> {
+    let mut labels = Labels::from_args(&args.labels).map_err(NfsGazeError::ParseError)?;
+    if let Some(meta) = HostMetadata::collect(&args.host_meta) {
+        meta.add_to(&mut labels);
+    }
+    Ok(labels)
+}
+
+/// Run the selected mode, returning the process exit code.
+fn run(args: Args) -> Result<i32> {
+    let running = install_signal_handler()?;
+    let stdout = io::stdout();
+    let mut out = stdout.lock();
+    let path = args.mountstats_path.as_str();
+
+    match &args.command {
+        Some(Command::Check(check)) => {
+            let report = run_check(
+                path,
+                &MountSelector::new(&args.mount_point).with_patterns(&args.patterns),
+                check,
+            )?;
+            if check.json {
+                write_json(&mut out, &report)?;
+            } else {
+                write_summary(&mut out, &report)?;
+            }
+            Ok(report.status.exit_code())
+        }
+        Some(Command::Compare(args)) => {
+            let session = run_compare(path, args, &running)?;
+            let rows = compare(&session, &args.mount_a, &args.mount_b);
+            display_comparison(&mut out, &args.mount_a, &args.mount_b, &rows)?;
+            Ok(0)
+        }
+        Some(Command::Bench(args)) => {
+            let report = run_bench(path, args, &running)?;
+            display_bench(&mut out, args, &report)?;
+            Ok(0)
+        }
+        Some(Command::Baseline(baseline)) => {
+            match &baseline.command {
+                BaselineCommand::Record(record) => {
+                    baseline::run_record(path, &args.mount_point, record, &running)?;
+                    eprintln!("Baseline written to {}", record.file);
+                }
+                BaselineCommand::Compare(compare) => {
+                    baseline::run_compare(&mut out, path, &args.mount_point, compare, &running)?
+                }
+            }
+            Ok(0)
+        }
+        Some(Command::Agent(agent)) => {
+            let labels = labels(&args)?;
+            let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+            run_agent(path, agent, selector, &labels, &running)?;
+            Ok(0)
+        }
+        Some(Command::Collector(collector)) => {
+            let labels = labels(&args)?;
+            eprintln!("Collecting on http://{}", collector.listen);
+            run_collector(collector, &labels, &running)?;
+            Ok(0)
+        }
+        Some(Command::Server(server)) => {
+            run_server(&mut out, server, &running)?;
+            Ok(0)
+        }
+        Some(Command::Fleet(fleet)) => {
+            let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+            run_fleet(&mut out, fleet, &selector, &running)?;
+            Ok(0)
+        }
+        Some(Command::Diff(args)) => {
+            run_diff(&mut out, args)?;
+            Ok(0)
+        }
+        Some(Command::Serve(serve)) => {
+            let labels = labels(&args)?;
+            let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+            eprintln!("Serving the JSON API on http://{}", serve.listen);
+            run_serve(serve, path, MountTracker::new(selector), &labels, &running)?;
+            Ok(0)
+        }
+        None if args.tui.tui => {
+            let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+            let sort = presets::sort(args.sort.sort, args.preset.preset);
+            run_tui(path, selector, args.interval.as_duration(), sort, &running)?;
+            Ok(0)
+        }
+        None if args.once.once => {
+            let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+            let columns = columns(&args.columns, args.preset.preset, args.show_bandwidth);
+            run_once(&mut out, path, &selector, &columns, args.human.human)?;
+            Ok(0)
+        }
+        None if !args.sink.sinks.is_empty() => {
+            run_sinks(&args, &running)?;
+            Ok(0)
+        }
+        None if args.k8s.k8s => {
+            let mut out = args.output.writer()?;
+            let proc_root = &args.cgroups.proc_root;
+            run_scanned(&mut out, &args, &running, |selector, labels| {
+                k8s::labelled_mounts(proc_root, &args.k8s.kubelet_root, selector, labels)
+            })?;
+            Ok(0)
+        }
+        None if args.all_namespaces.all_namespaces => {
+            let mut out = args.output.writer()?;
+            let proc_root = &args.cgroups.proc_root;
+            run_scanned(&mut out, &args, &running, |selector, labels| {
+                allns::labelled_mounts(proc_root, selector, labels)
+            })?;
+            Ok(0)
+        }
+        None if args.replay.replay.is_some() => {
+            let mut out = args.output.writer()?;
+            let capture = args.replay.replay.as_deref().unwrap_or_default();
+            replay_monitor(&mut out, &args, capture, &running)
+        }
+        None => {
+            let mut out = args.output.writer()?;
+            run_monitor(&mut out, &args, &running)
+        }
+    }
+}
+
+fn main() {
+    match parse_args().and_then(run) {
+        Ok(code) => process::exit(code),
+        Err(e) => {
+            eprintln!("Error: {}", e);
+            process::exit(1);
+        }
+    }
+}
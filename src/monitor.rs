@@ -0,0 +1,2128 @@
+//! The monitoring loop: sample mountstats every interval and print each
+//! selected mount's per-operation activity.
+
+use crate::alert::{check_interval, display_alerts, Alert, AlertRules, AlertState};
+use crate::attribution::{
+    display_process_stats, event_from_record, tracing_available, Attributor, KprobeTracer,
+};
+use crate::bytes::{display_io_split, IoSplit, NFSBytes};
+use crate::capacity::{display_capacity, statvfs_with_timeout};
+use crate::census::{display_census, take_census};
+use crate::cgroups::{
+    attribute, cgroup_v2_available, discover_namespaces, display_cgroup_usage, snapshot,
+};
+use crate::cli::{parse_operations_filter, Args};
+#[cfg(feature = "parquet")]
+use crate::columnar::ParquetExport;
+use crate::columns::{columns, display_columns, Column};
+use crate::cumulative::display_dual;
+use crate::deepdebug::{nfs_mask, rpc_mask, write_bundle, DebugCapture};
+use crate::delegation::{
+    display_delegations, enable_delegation_events, returns_from_mountstats, DelegationTracker,
+};
+use crate::delta::mount_delta;
+use crate::display::{display_attr_stats, display_mount_header, display_stats_simple};
+use crate::errcodes::{display_error_breakdown, enable_status_events, ErrorBreakdown};
+use crate::exitsummary::display_exit_summary;
+use crate::exporter;
+use crate::firstreport::{cumulative_interval_secs, zero_baseline};
+use crate::gnuplot::GnuplotExport;
+use crate::graph::{display_graph, Grapher};
+use crate::graphite::{self, GraphiteSender};
+#[cfg(feature = "grpc")]
+use crate::grpc::{serve_grpc, WatchService};
+use crate::hostmeta::HostMetadata;
+use crate::identity::{display_identities, identify_all};
+use crate::idle::{is_idle, IdleTracker};
+use crate::labels::Labels;
+use crate::latency::{display_latency_histograms, LatencyTracker, LATENCY_EVENT};
+use crate::mountinfo::{parse_nfs_mountinfo, read_nfs_mountinfo, MOUNTINFO_PATH};
+use crate::notify::Notifier;
+use crate::options::{
+    check_options, display_annotations, display_option_warnings, options_by_mount,
+};
+use crate::ordering::{sort_stats_by, top_n, SortKey};
+use crate::output::{
+    attach_transports, interval_json, write_csv_header, write_csv_rows, write_json_record,
+    OutputFormat,
+};
+use crate::parser::parse_mountstats_str;
+use crate::pnfs::{display_pnfs, layout_driver, PnfsInterval, PnfsTracker};
+use crate::presets;
+use crate::quiet::{should_print, QuietCounter};
+use crate::readahead::{display_readahead, ReadaheadRow};
+use crate::record::{read_recording, Recorder};
+use crate::recovery::{
+    detect_from_counters, detect_from_trace, detect_lease_expiry, display_recovery_events,
+    RECOVERY_EVENTS,
+};
+use crate::redact::Redactor;
+use crate::replay::run_replay;
+use crate::report::write_report;
+use crate::resolve::{display_server, split_device, Resolver};
+use crate::retrans::{breakdown, display_retrans};
+use crate::rollup::{emit_rollup, Rollup};
+use crate::sampling::{interval_advice, measure_parse, recommend_interval, OverheadGuard, Verdict};
+use crate::sandbox::{self, HeldFile};
+use crate::sections::{parse_sections, MountSection};
+use crate::security::{display_security, matches_flavor, mount_security, select_by_flavor};
+use crate::selection::{parse_mount_list, MountSelector};
+use crate::servergroups::{display_server_groups, ServerGroups};
+use crate::session::Session;
+use crate::sink::{
+    ConsoleSink, CsvSink, GraphiteSink, IostatSink, JsonSink, Sink, SinkKind, Sinks, StatsdSink,
+};
+use crate::slab::{
+    calculate_slab_delta, display_slab_delta, parse_slabinfo, SlabCache, SLABINFO_PATH,
+};
+use crate::slots::{display_slot_usage, session_mounts, SlotTracker, SLOT_EVENTS};
+use crate::smooth::{display_smoothed, Smoother};
+#[cfg(feature = "opentelemetry")]
+use crate::spans::emit_interval_span;
+use crate::spark::{display_with_spark, SparkHistory};
+use crate::statsd::{self, StatsdSender};
+#[cfg(feature = "sqlite")]
+use crate::store::SqliteStore;
+use crate::summary::{display_summary, summarize};
+use crate::sunrpc::{display_sunrpc_transports, SunrpcState, SYSFS_SUNRPC};
+use crate::talkers::{display_top_talkers, TopTalkers};
+use crate::tls::{check_tls_policy, TransportSecurity};
+use crate::totals::display_totals;
+use crate::tracefs::{enable_events, open_trace_pipe, stream_records, HeldEvents, TraceRecord};
+use crate::tracker::{display_mount_events, MountEvent, MountInterval, MountTracker};
+use crate::types::{DeltaStats, NFSEvents, NFSMount, NfsGazeError, Result};
+use crate::watcher::Watcher;
+use crate::watchop::{display_watch_op, watch_rows};
+use crate::wide::{wide_event, write_wide_event};
+use crate::writeback::{self, display_writeback, writeback_row, BdiStats};
+use crate::xprt::{
+    delta_transports, display_rpc_summary, display_transports, total_transport,
+    transports_by_mount, NFSTransport,
+};
+use crate::zabbix::{discovery_json, sender_lines, write_sender_lines};
+use chrono::{DateTime, Utc};
+use crossterm::{cursor, execute, terminal};
+use std::borrow::Cow;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::fs::{self, File};
+use std::io::{self, BufWriter, IsTerminal, Write};
+use std::net::{SocketAddr, TcpListener};
+use std::path::Path;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::mpsc::{self, Receiver, Sender};
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::{Duration, Instant};
+
+/// How long `--df` waits on statfs before calling a mount unresponsive.
+const STATFS_TIMEOUT: Duration = Duration::from_secs(2);
+
+/// trace_pipe records read on a background thread. trace_pipe hands each
+/// record to only one reader, so every tracing feature shares this feed.
+struct TraceFeed {
+    records: Receiver<TraceRecord>,
+    /// trace_pipe and the sending end, until the reader thread starts.
+    pending: Option<(File, Sender<TraceRecord>)>,
+}
+
+impl TraceFeed {
+    /// Open trace_pipe while still privileged; records flow once
+    /// [`TraceFeed::start`] runs.
+    fn open(root: &str) -> Result<Self> {
+        let (tx, records) = mpsc::channel();
+        Ok(Self {
+            records,
+            pending: Some((open_trace_pipe(root)?, tx)),
+        })
+    }
+
+    fn start(&mut self, running: &Arc<AtomicBool>) {
+        if let Some((pipe, tx)) = self.pending.take() {
+            let running = running.clone();
+            thread::spawn(move || stream_records(pipe, running, tx));
+        }
+    }
+
+    /// Records that arrived since the last call.
+    fn drain(&self) -> Vec<TraceRecord> {
+        self.records.try_iter().collect()
+    }
+}
+
+/// Tracepoints switched on for a panel, switched off again on drop.
+struct EnabledEvents {
+    _events: HeldEvents,
+}
+
+impl EnabledEvents {
+    /// Enable `events`, failing if the kernel has none of them.
+    fn enable(root: &str, events: &[&'static str], flag: &str) -> Result<Self> {
+        if !tracing_available(root) {
+            return Err(NfsGazeError::ParseError(format!(
+                "{} needs a writable tracefs at {} (run as root)",
+                flag, root
+            )));
+        }
+        let events = enable_events(root, events)?;
+        if events.is_empty() {
+            return Err(NfsGazeError::ParseError(format!(
+                "{}: none of its tracepoints exist under {}",
+                flag, root
+            )));
+        }
+        Ok(Self { _events: events })
+    }
+}
+
+/// `--slots`: NFSv4.1 session slot table usage.
+struct SlotPanel {
+    _events: EnabledEvents,
+    tracker: SlotTracker,
+}
+
+/// `--latency-hist`: per-op RPC latency histograms.
+struct LatencyPanel {
+    _events: EnabledEvents,
+    tracker: LatencyTracker,
+}
+
+/// `--recovery-events`: state recovery from the nfs4 tracepoints when they
+/// can be enabled, otherwise from the counters it leaves behind.
+struct RecoveryPanel {
+    events: Option<EnabledEvents>,
+}
+
+impl RecoveryPanel {
+    fn observe<W: Write>(
+        &self,
+        writer: &mut W,
+        tick: &Tick,
+        records: &[TraceRecord],
+    ) -> Result<()> {
+        let now = Utc::now();
+        let mut events: Vec<_> = records
+            .iter()
+            .filter_map(|r| detect_from_trace(r, now))
+            .collect();
+        let before = parse_sections(tick.before);
+        let after = parse_sections(tick.contents);
+        let old_mounts = parse_mountstats_str(tick.before)?;
+        for interval in tick.intervals {
+            let mount = &interval.mount;
+            let nfsv4 = |sections: &[MountSection]| {
+                sections
+                    .iter()
+                    .find(|s| s.mount_point == mount.mount_point)
+                    .and_then(|s| s.value("nfsv4").map(str::to_string))
+            };
+            events.extend(detect_lease_expiry(
+                &mount.mount_point,
+                nfsv4(&before).as_deref(),
+                nfsv4(&after).as_deref(),
+                now,
+            ));
+            if self.events.is_none() {
+                if let Some(old) = old_mounts
+                    .iter()
+                    .find(|m| m.mount_point == mount.mount_point)
+                {
+                    events.extend(detect_from_counters(old, mount, now));
+                }
+            }
+        }
+        display_recovery_events(writer, &events)?;
+        Ok(())
+    }
+}
+
+/// `--by-process`: time in NFS file reads and writes per process, from
+/// kprobes. The probes are removed when the tracer is dropped at the end
+/// of the run.
+struct ProcessPanel {
+    _tracer: KprobeTracer,
+    attributor: Attributor,
+}
+
+/// `--error-codes`: errors by NFS status from the xdr_status tracepoints,
+/// which are switched off again when the panel is dropped.
+struct ErrorPanel {
+    _enabled: HeldEvents,
+    breakdown: ErrorBreakdown,
+}
+
+impl ErrorPanel {
+    fn start(tracefs: &str) -> Result<Self> {
+        let enabled = enable_status_events(tracefs)?;
+        if enabled.is_empty() {
+            return Err(NfsGazeError::ParseError(format!(
+                "--error-codes: no NFS status tracepoints under {}",
+                tracefs
+            )));
+        }
+        Ok(Self {
+            _enabled: enabled,
+            breakdown: ErrorBreakdown::new(),
+        })
+    }
+}
+
+/// A `--deep-debug` capture in progress.
+struct ActiveCapture {
+    capture: DebugCapture,
+    reason: String,
+    until: Instant,
+    /// mountstats from the interval before the incident.
+    before: String,
+}
+
+/// `--deep-debug`: raise the kernel debug masks for a bounded window when
+/// an interval shows retransmissions or errors, then write a bundle.
+struct DebugPanel {
+    rpc: u32,
+    nfs: u32,
+    window: Duration,
+    dir: String,
+    active: Option<ActiveCapture>,
+}
+
+impl DebugPanel {
+    fn new(args: &Args) -> Result<Self> {
+        let debug = &args.deep_debug;
+        Ok(Self {
+            rpc: rpc_mask(&debug.rpc_flags).map_err(NfsGazeError::ParseError)?,
+            nfs: nfs_mask(&debug.nfs_flags).map_err(NfsGazeError::ParseError)?,
+            window: Duration::from_secs(debug.debug_window.max(1)),
+            dir: debug.dir.clone(),
+            active: None,
+        })
+    }
+
+    /// Start a capture on the first incident, and write the bundle once
+    /// the window has passed.
+    fn observe<W: Write>(&mut self, writer: &mut W, tick: &Tick) -> Result<()> {
+        if self.active.is_none() {
+            if let Some(reason) = incident(tick.intervals) {
+                writeln!(writer, "DEBUG: {}; capturing kernel debug log", reason)?;
+                self.active = Some(ActiveCapture {
+                    capture: DebugCapture::start(self.rpc, self.nfs)?,
+                    reason,
+                    until: Instant::now() + self.window,
+                    before: tick.before.to_string(),
+                });
+            }
+            return Ok(());
+        }
+        if self
+            .active
+            .as_ref()
+            .is_some_and(|a| Instant::now() >= a.until)
+        {
+            if let Some(active) = self.active.take() {
+                let started = active.capture.started();
+                let records = active.capture.finish();
+                let bundle = write_bundle(
+                    &self.dir,
+                    &active.reason,
+                    started,
+                    &records,
+                    &[
+                        ("before", active.before),
+                        ("after", tick.contents.to_string()),
+                    ],
+                )?;
+                writeln!(writer, "DEBUG: capture written to {}", bundle.display())?;
+            }
+        }
+        Ok(())
+    }
+}
+
+/// `--by-cgroup`: traffic per container or systemd unit, from the
+/// mountstats of each mount namespace under `--proc-root`.
+struct CgroupPanel {
+    proc_root: String,
+    previous: HashMap<u64, Vec<NFSMount>>,
+}
+
+impl CgroupPanel {
+    fn start(proc_root: &str) -> Result<Self> {
+        if !cgroup_v2_available(proc_root) {
+            return Err(NfsGazeError::ParseError(format!(
+                "--by-cgroup needs cgroup v2 under {}",
+                proc_root
+            )));
+        }
+        let namespaces = discover_namespaces(proc_root)?;
+        Ok(Self {
+            proc_root: proc_root.to_string(),
+            previous: snapshot(proc_root, &namespaces),
+        })
+    }
+
+    /// Namespaces are rediscovered each interval so new containers show
+    /// up after one sample.
+    fn observe<W: Write>(&mut self, writer: &mut W, secs: f64) -> Result<()> {
+        let namespaces = discover_namespaces(&self.proc_root)?;
+        let current = snapshot(&self.proc_root, &namespaces);
+        let usage = attribute(&namespaces, &self.previous, &current);
+        self.previous = current;
+        display_cgroup_usage(writer, &usage, secs)?;
+        Ok(())
+    }
+}
+
+/// `--slab`: growth of the NFS slab caches between intervals.
+/// /proc/slabinfo is readable only by root, so it is held open for
+/// `--sandbox`.
+struct SlabPanel {
+    slabinfo: HeldFile,
+    previous: BTreeMap<String, SlabCache>,
+}
+
+impl SlabPanel {
+    fn start() -> Result<Self> {
+        let mut slabinfo = HeldFile::open(SLABINFO_PATH)?;
+        Ok(Self {
+            previous: parse_slabinfo(&slabinfo.read()?)?,
+            slabinfo,
+        })
+    }
+
+    fn observe<W: Write>(&mut self, writer: &mut W) -> Result<()> {
+        let current = parse_slabinfo(&self.slabinfo.read()?)?;
+        display_slab_delta(writer, &calculate_slab_delta(&self.previous, &current))?;
+        self.previous = current;
+        Ok(())
+    }
+}
+
+/// `--writeback`: dirty and writeback levels of each mount's BDI next to
+/// its WRITE latency.
+struct WritebackPanel {
+    debugfs: String,
+    previous: HashMap<String, BdiStats>,
+}
+
+impl WritebackPanel {
+    fn start(debugfs: &str) -> Result<Self> {
+        if !Path::new(debugfs).join("bdi").is_dir() {
+            return Err(NfsGazeError::ParseError(format!(
+                "--writeback needs debugfs mounted at {} (run as root)",
+                debugfs
+            )));
+        }
+        Ok(Self {
+            debugfs: debugfs.to_string(),
+            previous: writeback::sample(debugfs, &read_nfs_mountinfo(MOUNTINFO_PATH)?),
+        })
+    }
+
+    fn observe<W: Write>(&mut self, writer: &mut W, tick: &Tick) -> Result<()> {
+        let current = writeback::sample(&self.debugfs, &read_nfs_mountinfo(MOUNTINFO_PATH)?);
+        let rows: Vec<_> = tick
+            .intervals
+            .iter()
+            .filter_map(|interval| {
+                let mp = &interval.mount.mount_point;
+                let (before, after) = (self.previous.get(mp)?, current.get(mp)?);
+                let write_rtt = interval
+                    .stats
+                    .iter()
+                    .find(|s| s.operation == "WRITE" && s.delta_ops > 0)
+                    .map(|s| s.avg_rtt);
+                Some(writeback_row(mp, before, after, tick.secs, write_rtt))
+            })
+            .collect();
+        self.previous = current;
+        display_writeback(writer, &rows)?;
+        Ok(())
+    }
+}
+
+/// `--delegations`: grants, recalls and returns from the nfs4 tracepoints,
+/// or just DELEGRETURN counts from mountstats when tracing is unavailable.
+struct DelegationPanel {
+    /// The tracepoints, and mountinfo held open to name their mounts
+    /// after `--sandbox` makes /proc/self unreadable.
+    traced: Option<(HeldEvents, HeldFile)>,
+    tracker: DelegationTracker,
+}
+
+impl DelegationPanel {
+    fn start(tracefs: &str) -> Result<Self> {
+        let mut traced = None;
+        if tracing_available(tracefs) {
+            let events = enable_delegation_events(tracefs)?;
+            if !events.is_empty() {
+                traced = Some((events, HeldFile::open(MOUNTINFO_PATH)?));
+            }
+        }
+        Ok(Self {
+            traced,
+            tracker: DelegationTracker::new(),
+        })
+    }
+
+    fn traced(&self) -> bool {
+        self.traced.is_some()
+    }
+
+    fn observe<W: Write>(
+        &mut self,
+        writer: &mut W,
+        tick: &Tick,
+        records: &[TraceRecord],
+    ) -> Result<()> {
+        let counts = if let Some((_, mountinfo)) = &mut self.traced {
+            for record in records {
+                self.tracker.record(record);
+            }
+            self.tracker
+                .take_interval(&parse_nfs_mountinfo(&mountinfo.read()?))
+        } else {
+            let before = parse_mountstats_str(tick.before)?;
+            tick.intervals
+                .iter()
+                .filter_map(|interval| {
+                    let mount = &interval.mount;
+                    let old = before.iter().find(|m| m.mount_point == mount.mount_point)?;
+                    let counts = returns_from_mountstats(old, mount);
+                    (counts.returned > 0).then(|| (mount.mount_point.clone(), counts))
+                })
+                .collect()
+        };
+        display_delegations(writer, &counts)?;
+        Ok(())
+    }
+}
+
+/// What makes an interval worth a debug capture, if anything.
+fn incident(intervals: &[MountInterval]) -> Option<String> {
+    intervals.iter().find_map(|interval| {
+        let sum = |f: fn(&DeltaStats) -> i64| interval.stats.iter().map(f).sum::<i64>();
+        let (retrans, errors) = (sum(|s| s.delta_retrans), sum(|s| s.delta_errors));
+        (retrans > 0 || errors > 0).then(|| {
+            format!(
+                "{}: {} retransmissions, {} errors",
+                interval.mount.mount_point, retrans, errors
+            )
+        })
+    })
+}
+
+/// One sampling interval, as handed to [`Monitor::report`].
+struct Tick<'s> {
+    intervals: &'s [MountInterval],
+    /// Mounts that came, went or were remounted since the last sample.
+    events: &'s [MountEvent],
+    /// Raw mountstats the interval was computed from.
+    before: &'s str,
+    contents: &'s str,
+    secs: f64,
+    /// When the interval ended; the recorded time when replaying.
+    at: DateTime<Utc>,
+}
+
+impl Tick<'_> {
+    /// Each mount's transport counters over the interval; full counters
+    /// for a mount with no earlier sample.
+    fn transports(&self) -> BTreeMap<String, Vec<NFSTransport>> {
+        let before = transports_by_mount(&parse_sections(self.before));
+        transports_by_mount(&parse_sections(self.contents))
+            .into_iter()
+            .map(|(mount_point, cur)| {
+                let deltas = match before.get(&mount_point) {
+                    Some(prev) => delta_transports(prev, &cur),
+                    None => cur,
+                };
+                (mount_point, deltas)
+            })
+            .collect()
+    }
+
+    /// Each mount's buffered, direct and server bandwidth over the
+    /// interval.
+    fn io_splits(&self) -> BTreeMap<String, IoSplit> {
+        let bytes = |contents| -> BTreeMap<String, NFSBytes> {
+            parse_sections(contents)
+                .iter()
+                .filter_map(|s| Some((s.mount_point.clone(), NFSBytes::from_section(s)?)))
+                .collect()
+        };
+        let before = bytes(self.before);
+        bytes(self.contents)
+            .into_iter()
+            .map(|(mount_point, cur)| {
+                let delta = match before.get(&mount_point) {
+                    Some(prev) => cur.delta(prev),
+                    None => cur,
+                };
+                (mount_point, IoSplit::from_delta(&delta, self.secs))
+            })
+            .collect()
+    }
+}
+
+/// Per-run display state carried between intervals.
+struct Monitor<'a> {
+    args: &'a Args,
+    /// Display settings from the command line, filled in from `--preset`.
+    operations: HashSet<String>,
+    sort: SortKey,
+    show_bandwidth: bool,
+    show_attr: bool,
+    /// Table columns, when not the fixed simple layout.
+    columns: Option<Vec<Column>>,
+    /// Last `events:` sample per mount, for `--attr`.
+    events: HashMap<String, NFSEvents>,
+    /// Name/address cache for `--resolve` and `--reverse`.
+    resolver: Option<Resolver>,
+    groups: ServerGroups,
+    /// Whole-run statistics for `--report` and `--cumulative`.
+    /// Shared with the metrics exporter, which serves its latency
+    /// percentiles.
+    session: Option<Arc<Mutex<Session>>>,
+    rollup: Option<Rollup>,
+    talkers: Option<TopTalkers>,
+    gnuplot: Option<GnuplotExport>,
+    #[cfg(feature = "parquet")]
+    parquet: Option<ParquetExport>,
+    /// `--store` history database.
+    #[cfg(feature = "sqlite")]
+    store: Option<SqliteStore>,
+    /// Whether the `--csv` header has been written.
+    csv_header: bool,
+    /// `--wide-events` destination.
+    wide: Option<Box<dyn Write>>,
+    graphite: Option<GraphiteSender>,
+    statsd: Option<StatsdSender>,
+    /// `--zabbix-sender` destination.
+    zabbix: Option<Box<dyn Write>>,
+    /// `--label` values attached to every exported sample and alert.
+    labels: Labels,
+    notifier: Notifier,
+    /// Mounts whose risky options and TLS policy have been reported.
+    warned: HashSet<String>,
+    trace: Option<TraceFeed>,
+    processes: Option<ProcessPanel>,
+    errors: Option<ErrorPanel>,
+    debug: Option<DebugPanel>,
+    cgroups: Option<CgroupPanel>,
+    slab: Option<SlabPanel>,
+    writeback: Option<WritebackPanel>,
+    delegations: Option<DelegationPanel>,
+    slots: Option<SlotPanel>,
+    latency: Option<LatencyPanel>,
+    smoother: Option<Smoother>,
+    spark: Option<SparkHistory>,
+    /// `--graph`: drawn instead of the per-mount table.
+    grapher: Option<Grapher>,
+    pnfs: Option<PnfsTracker>,
+    /// `None` when no threshold is set.
+    alert_rules: Option<AlertRules>,
+    alert_state: AlertState,
+    /// Intervals shown and suppressed by `--quiet`.
+    quiet: QuietCounter,
+    /// Highlight alerts; only when the table goes to a terminal.
+    color: bool,
+    recovery: Option<RecoveryPanel>,
+}
+
+impl<'a> Monitor<'a> {
+    fn new(args: &'a Args, redactor: Option<&Redactor>) -> Result<Self> {
+        let tracefs = args.tracefs.tracefs.as_str();
+        if args.spans.otel_spans && cfg!(not(feature = "opentelemetry")) {
+            return Err(NfsGazeError::ParseError(
+                "--otel-spans needs a build with the opentelemetry feature".to_string(),
+            ));
+        }
+        let debugfs = args.writeback.debugfs.as_str();
+        if args.sunrpc.sunrpc && SunrpcState::sample(debugfs, SYSFS_SUNRPC).is_none() {
+            return Err(NfsGazeError::ParseError(format!(
+                "--sunrpc needs debugfs mounted at {} (run as root)",
+                debugfs
+            )));
+        }
+        let processes = if args.attribution.by_process {
+            if !tracing_available(tracefs) {
+                return Err(NfsGazeError::ParseError(format!(
+                    "--by-process needs a writable tracefs at {} (run as root)",
+                    tracefs
+                )));
+            }
+            Some(ProcessPanel {
+                _tracer: KprobeTracer::attach(tracefs)?,
+                attributor: Attributor::new(),
+            })
+        } else {
+            None
+        };
+        let errors = args
+            .error_codes
+            .error_codes
+            .then(|| ErrorPanel::start(tracefs))
+            .transpose()?;
+        let debug = args
+            .deep_debug
+            .deep_debug
+            .then(|| DebugPanel::new(args))
+            .transpose()?;
+        let cgroups = args
+            .cgroups
+            .by_cgroup
+            .then(|| CgroupPanel::start(&args.cgroups.proc_root))
+            .transpose()?;
+        let slab = args.slab.slab.then(SlabPanel::start).transpose()?;
+        let writeback = args
+            .writeback
+            .writeback
+            .then(|| WritebackPanel::start(&args.writeback.debugfs))
+            .transpose()?;
+        let delegations = args
+            .delegation
+            .delegations
+            .then(|| DelegationPanel::start(tracefs))
+            .transpose()?;
+        let slots = args
+            .slots
+            .slots
+            .then(|| {
+                EnabledEvents::enable(tracefs, SLOT_EVENTS, "--slots").map(|events| SlotPanel {
+                    _events: events,
+                    tracker: SlotTracker::new(),
+                })
+            })
+            .transpose()?;
+        let latency = args
+            .latency
+            .latency_hist
+            .then(|| {
+                EnabledEvents::enable(tracefs, &[LATENCY_EVENT], "--latency-hist").map(|events| {
+                    LatencyPanel {
+                        _events: events,
+                        tracker: LatencyTracker::new(),
+                    }
+                })
+            })
+            .transpose()?;
+        let preset = args.preset.preset;
+        let recovery = presets::flag(args.recovery.recovery_events, preset, |s| s.recovery_events)
+            .then(|| RecoveryPanel {
+                events: EnabledEvents::enable(tracefs, RECOVERY_EVENTS, "--recovery-events").ok(),
+            });
+        let mut labels = Labels::from_args(&args.labels).map_err(NfsGazeError::ParseError)?;
+        let mut notifier = Notifier::new(&args.notify);
+        notifier.set_labels(&labels);
+        // Desktop notifications stay on this host, so only exports and
+        // reports carry the host metadata.
+        if let Some(meta) = HostMetadata::collect(&args.host_meta) {
+            meta.add_to(&mut labels);
+        }
+        if let Some(redactor) = redactor {
+            redactor.labels(&mut labels);
+        }
+        let trace = (processes.is_some()
+            || recovery.as_ref().is_some_and(|r| r.events.is_some())
+            || errors.is_some()
+            || slots.is_some()
+            || latency.is_some()
+            || delegations.as_ref().is_some_and(DelegationPanel::traced))
+        .then(|| TraceFeed::open(tracefs))
+        .transpose()?;
+        let alert_rules = Some(AlertRules::new(&args.alert, &args.config.thresholds))
+            .filter(|rules| !rules.is_empty());
+        if args.quiet.quiet && alert_rules.is_none() {
+            return Err(NfsGazeError::ParseError(
+                "--quiet needs an alert threshold (--alert-rtt-ms, --alert-retrans-pct, \
+                 --alert-error-pct or [thresholds] in the config file)"
+                    .to_string(),
+            ));
+        }
+        Ok(Self {
+            args,
+            operations: parse_operations_filter(presets::operations(
+                args.operations.clone(),
+                preset,
+            )),
+            sort: presets::sort(args.sort.sort, preset),
+            show_bandwidth: presets::flag(args.show_bandwidth, preset, |s| s.bandwidth),
+            show_attr: presets::flag(args.show_attr, preset, |s| s.attr_cache),
+            columns: (!args.columns.cols.is_empty()
+                || args.columns.extended
+                || preset.is_some()
+                || args.human.human
+                || args.smooth.window.is_some()
+                || args.spark.spark.is_some())
+            .then(|| columns(&args.columns, preset, args.show_bandwidth)),
+            events: HashMap::new(),
+            warned: HashSet::new(),
+            talkers: args
+                .talkers
+                .top_talkers
+                .then(|| TopTalkers::new(args.talkers.talker_window)),
+            rollup: args
+                .rollup
+                .rollup
+                .map(|period| Rollup::new(period, Utc::now(), labels.clone())),
+            session: (args.report.report.is_some()
+                || args.cumulative.cumulative
+                || args.exporter.addr().is_some()
+                || !args.exit_summary.no_exit_summary)
+                .then(|| {
+                    let mut session = Session::new(Utc::now());
+                    session.labels = labels.clone();
+                    Arc::new(Mutex::new(session))
+                }),
+            gnuplot: args
+                .gnuplot
+                .gnuplot
+                .as_deref()
+                .map(GnuplotExport::create)
+                .transpose()?,
+            #[cfg(feature = "parquet")]
+            parquet: args
+                .parquet
+                .parquet
+                .as_deref()
+                .map(ParquetExport::create)
+                .transpose()?,
+            #[cfg(feature = "sqlite")]
+            store: args
+                .store
+                .store
+                .as_deref()
+                .map(|path| SqliteStore::open(path, args.store.retention))
+                .transpose()?,
+            csv_header: false,
+            wide: match args.wide.wide_events.as_deref() {
+                None => None,
+                Some("-") => Some(Box::new(io::stdout())),
+                Some(path) => Some(Box::new(BufWriter::new(File::create(path)?))),
+            },
+            graphite: args.graphite.graphite.as_deref().map(GraphiteSender::new),
+            statsd: args
+                .statsd
+                .statsd
+                .as_deref()
+                .map(StatsdSender::new)
+                .transpose()?,
+            zabbix: match args.zabbix.zabbix_sender.as_deref() {
+                None => None,
+                Some("-") => Some(Box::new(io::stdout())),
+                Some(path) => Some(Box::new(BufWriter::new(File::create(path)?))),
+            },
+            notifier,
+            groups: ServerGroups::from_args(&args.server_groups)?,
+            resolver: None,
+            trace,
+            processes,
+            errors,
+            debug,
+            cgroups,
+            slab,
+            writeback,
+            delegations,
+            slots,
+            latency,
+            smoother: Smoother::from_args(&args.smooth),
+            spark: SparkHistory::from_args(&args.spark),
+            grapher: Grapher::from_args(&args.graph),
+            pnfs: args.pnfs.pnfs.then(PnfsTracker::new),
+            alert_rules,
+            alert_state: AlertState::default(),
+            quiet: QuietCounter::default(),
+            color: args.output.output.is_none() && io::stdout().is_terminal(),
+            recovery,
+            labels,
+        })
+    }
+
+    /// Start the trace_pipe reader and the resolver. Called once
+    /// `--sandbox` is up, so their threads start inside it.
+    fn start(&mut self, running: &Arc<AtomicBool>) {
+        if let Some(trace) = &mut self.trace {
+            trace.start(running);
+        }
+        let resolve = &self.args.resolve;
+        if resolve.resolve || resolve.reverse {
+            self.resolver = Some(Resolver::new(Duration::from_secs(resolve.dns_ttl)));
+        }
+    }
+
+    fn remember_events(&mut self, mount: &NFSMount) -> Option<NFSEvents> {
+        match &mount.events {
+            Some(events) => self
+                .events
+                .insert(mount.mount_point.clone(), events.clone()),
+            None => self.events.remove(&mount.mount_point),
+        }
+    }
+
+    /// End-of-run output; returns the run's exit status.
+    fn finish<W: Write>(&mut self, writer: &mut W) -> Result<i32> {
+        let mut stderr = io::stderr();
+        if let Some(rollup) = self.rollup.take() {
+            let mut panels = panel_writer(self.args.output.format(), writer, &mut stderr);
+            emit_rollup(&self.args.rollup, &mut panels, &rollup.finish(Utc::now()))?;
+        }
+        if let Some(Ok(session)) = self.session.as_deref().map(Mutex::lock) {
+            write_report(
+                &self.args.report,
+                &session,
+                presets::checks(self.args.preset.preset),
+            )?;
+            // Machine-readable output stays parseable.
+            if !self.args.exit_summary.no_exit_summary
+                && self.args.output.format() == OutputFormat::Table
+            {
+                display_exit_summary(writer, &session)?;
+                if self.args.quiet.quiet {
+                    writeln!(writer, "{}", self.quiet.describe())?;
+                }
+            }
+        }
+        #[cfg(feature = "parquet")]
+        if let Some(export) = self.parquet.take() {
+            export.finish()?;
+        }
+        if let Some(wide) = &mut self.wide {
+            wide.flush()?;
+        }
+        if let Some(export) = self.gnuplot.take() {
+            let script = export.finish()?;
+            let panels = panel_writer(self.args.output.format(), writer, &mut stderr);
+            writeln!(panels, "gnuplot script written to {}", script.display())?;
+        }
+        Ok(self.alert_state.exit_code(&self.args.alert))
+    }
+
+    /// `mount` with its server decorated for display when resolving, and
+    /// its transport security noted when encrypted.
+    fn shown<'m>(&self, mount: &'m NFSMount, security: TransportSecurity) -> Cow<'m, NFSMount> {
+        let mut device = Cow::Borrowed(mount.device.as_str());
+        if let (Some(resolver), Some((server, export))) =
+            (&self.resolver, split_device(&mount.device))
+        {
+            let server = display_server(&server, resolver, &self.args.resolve);
+            device = Cow::Owned(if mount.device.starts_with('[') {
+                format!("[{}]:{}", server, export)
+            } else {
+                format!("{}:{}", server, export)
+            });
+        }
+        if security.is_encrypted() {
+            device = Cow::Owned(format!("{}, xprtsec={}", device, security));
+        }
+        match device {
+            Cow::Borrowed(_) => Cow::Borrowed(mount),
+            Cow::Owned(device) => Cow::Owned(NFSMount {
+                device,
+                ..mount.clone()
+            }),
+        }
+    }
+
+    /// The active, selected operations of `interval` in display order.
+    fn shown_stats(&self, interval: &MountInterval) -> Vec<DeltaStats> {
+        let mut stats: Vec<_> = interval
+            .stats
+            .iter()
+            .filter(|s| s.delta_ops > 0)
+            .filter(|s| self.operations.is_empty() || self.operations.contains(&s.operation))
+            .cloned()
+            .collect();
+        match self.args.top.top {
+            Some(n) => top_n(&mut stats, n, self.sort),
+            None => sort_stats_by(&mut stats, self.sort),
+        }
+        stats
+    }
+
+    /// `--json`: one record per mount, one per line.
+    fn report_json<W: Write>(
+        &mut self,
+        writer: &mut W,
+        tick: &Tick,
+        now: &DateTime<Utc>,
+    ) -> Result<()> {
+        let transports = tick.transports();
+        for interval in tick.intervals {
+            let stats = self.shown_stats(interval);
+            let mut record = interval_json(&interval.mount, *now, tick.secs, &stats, &self.labels);
+            if let Some(deltas) = transports.get(&interval.mount.mount_point) {
+                attach_transports(&mut record, deltas);
+            }
+            write_json_record(writer, &record)?;
+        }
+        Ok(())
+    }
+
+    /// EVENT lines go with the table, and to stderr when stdout carries
+    /// JSON or CSV.
+    fn report_events<W: Write>(&self, writer: &mut W, events: &[MountEvent]) -> io::Result<()> {
+        match self.args.output.format() {
+            OutputFormat::Table => display_mount_events(writer, events),
+            _ => display_mount_events(&mut io::stderr(), events),
+        }
+    }
+
+    /// `--csv`: one row per operation, under a header written once.
+    fn report_csv<W: Write>(
+        &mut self,
+        writer: &mut W,
+        tick: &Tick,
+        now: &DateTime<Utc>,
+    ) -> Result<()> {
+        if !self.csv_header {
+            write_csv_header(writer)?;
+            self.csv_header = true;
+        }
+        for interval in tick.intervals {
+            let stats = self.shown_stats(interval);
+            write_csv_rows(writer, &interval.mount.mount_point, *now, &stats)?;
+        }
+        Ok(())
+    }
+
+    /// `--immediate`: totals since mount, in the chosen output format.
+    /// The panels and whole-run statistics start with the first real
+    /// interval.
+    fn report_since_mount<W: Write>(&mut self, writer: &mut W, tick: &Tick) -> Result<()> {
+        let now = Utc::now();
+        match self.args.output.format() {
+            OutputFormat::Json => self.report_json(writer, tick, &now),
+            OutputFormat::Csv => self.report_csv(writer, tick, &now),
+            OutputFormat::Table => self.report_mounts(writer, tick, &now),
+        }
+    }
+
+    /// The default view: one table per mount.
+    fn report_mounts<W: Write>(
+        &mut self,
+        writer: &mut W,
+        tick: &Tick,
+        now: &DateTime<Utc>,
+    ) -> Result<()> {
+        let sections = parse_sections(tick.contents);
+        let options = options_by_mount(&sections);
+        let before: HashMap<String, NFSMount> = parse_mountstats_str(tick.before)?
+            .into_iter()
+            .map(|m| (m.mount_point.clone(), m))
+            .collect();
+        let transports = if self.args.transports.transports || self.args.sunrpc.sunrpc {
+            tick.transports()
+        } else {
+            BTreeMap::new()
+        };
+        let sunrpc = self
+            .args
+            .sunrpc
+            .sunrpc
+            .then(|| SunrpcState::sample(&self.args.writeback.debugfs, SYSFS_SUNRPC))
+            .flatten();
+        let mut readahead = Vec::new();
+        let splits = if self.show_bandwidth {
+            tick.io_splits()
+        } else {
+            BTreeMap::new()
+        };
+        for interval in tick.intervals {
+            let mount = &interval.mount;
+            let opts = options.get(&mount.mount_point).cloned().unwrap_or_default();
+            let warnings = if self.args.option_warnings.no_option_warnings {
+                Vec::new()
+            } else {
+                check_options(&opts)
+            };
+            let security = TransportSecurity::from_options(&opts);
+            if self.warned.insert(mount.mount_point.clone()) {
+                display_option_warnings(writer, &mount.mount_point, &warnings)?;
+                let unencrypted = check_tls_policy(
+                    writer,
+                    &mount.mount_point,
+                    &mount.server,
+                    security,
+                    &self.args.tls,
+                    &self.groups,
+                )?;
+                if unencrypted {
+                    self.notifier.alert(
+                        &mut io::stderr(),
+                        &format!("{} tls", mount.mount_point),
+                        "NFS mount without TLS",
+                        &format!(
+                            "{} is mounted from {} in the clear",
+                            mount.mount_point, mount.server
+                        ),
+                    )?;
+                }
+            }
+            let stats = self.shown_stats(interval);
+            let smoothed = self
+                .smoother
+                .as_mut()
+                .map(|smoother| smoother.smooth(&mount.mount_point, tick.secs, &stats));
+            let shown = self.shown(mount, security);
+            let session = self
+                .session
+                .clone()
+                .filter(|_| self.args.cumulative.cumulative);
+            let session = session.as_deref().and_then(|s| s.lock().ok());
+            let totals = session
+                .as_ref()
+                .and_then(|session| session.mounts.get(&mount.mount_point));
+            match (totals, &self.columns) {
+                (Some(totals), _) if !stats.is_empty() => {
+                    display_mount_header(writer, &shown, now)?;
+                    display_dual(writer, &stats, totals)?;
+                }
+                (_, Some(columns)) if !stats.is_empty() => {
+                    display_mount_header(writer, &shown, now)?;
+                    match (&smoothed, &self.spark) {
+                        (Some(smoothed), _) => display_smoothed(
+                            writer,
+                            &stats,
+                            smoothed,
+                            columns,
+                            self.args.human.human,
+                        )?,
+                        (None, Some(spark)) => display_with_spark(
+                            writer,
+                            &mount.mount_point,
+                            &stats,
+                            columns,
+                            spark,
+                            self.args.human.human,
+                        )?,
+                        (None, None) => {
+                            display_columns(writer, &stats, columns, self.args.human.human)?
+                        }
+                    }
+                }
+                _ => display_stats_simple(writer, &shown, &stats, self.show_bandwidth, now)?,
+            }
+            if !self.args.totals.no_totals {
+                display_totals(writer, &stats)?;
+            }
+            display_annotations(writer, &stats, &warnings)?;
+            if let Some(prev) = before.get(&mount.mount_point) {
+                display_retrans(writer, &breakdown(prev, mount))?;
+            }
+            if let Some(split) = splits.get(&mount.mount_point) {
+                display_io_split(writer, &mount.mount_point, split)?;
+            }
+            if let Some(deltas) = transports.get(&mount.mount_point) {
+                display_rpc_summary(writer, deltas, tick.secs)?;
+                display_transports(writer, &mount.mount_point, deltas)?;
+                if let Some(state) = &sunrpc {
+                    display_sunrpc_transports(
+                        writer,
+                        &mount.mount_point,
+                        &mount.server,
+                        deltas,
+                        state,
+                    )?;
+                }
+            }
+            if let Some(pnfs) = &mut self.pnfs {
+                let prev = before
+                    .get(&mount.mount_point)
+                    .and_then(|m| m.events.as_ref());
+                if let (Some(prev), Some(cur)) = (prev, &mount.events) {
+                    let driver = sections
+                        .iter()
+                        .find(|s| s.mount_point == mount.mount_point)
+                        .and_then(layout_driver);
+                    let layout = PnfsInterval::new(prev, cur, &interval.stats, tick.secs);
+                    let warning = pnfs.check(&mount.mount_point, driver.as_deref(), &layout);
+                    // Mounts without pNFS have nothing to show.
+                    if driver.is_some() || layout.layout_reads + layout.layout_writes > 0 {
+                        display_pnfs(
+                            writer,
+                            &mount.mount_point,
+                            driver.as_deref(),
+                            &layout,
+                            warning,
+                        )?;
+                    }
+                }
+            }
+            if self.args.capacity.df && !stats.is_empty() {
+                let capacity = statvfs_with_timeout(Path::new(&mount.mount_point), STATFS_TIMEOUT);
+                display_capacity(
+                    writer,
+                    &mount.mount_point,
+                    &capacity,
+                    self.args.capacity.alert_full_pct,
+                )?;
+                let full_pct = self.args.capacity.alert_full_pct;
+                if let Some(c) = capacity.as_ref().ok().filter(|c| c.near_full(full_pct)) {
+                    self.notifier.alert(
+                        &mut io::stderr(),
+                        &format!("{} full", mount.mount_point),
+                        "NFS mount nearly full",
+                        &format!("{} is {:.0}% full", mount.mount_point, c.used_pct()),
+                    )?;
+                }
+            }
+            let prev = self.remember_events(mount);
+            if let (Some(prev), Some(cur)) = (&prev, &mount.events) {
+                if self.show_attr {
+                    display_attr_stats(writer, prev, cur)?;
+                }
+                let read = interval
+                    .stats
+                    .iter()
+                    .find(|s| s.operation == "READ" && s.delta_ops > 0);
+                if let Some(read) = read.filter(|_| self.args.readahead.readahead) {
+                    readahead.push(ReadaheadRow::new(
+                        &mount.mount_point,
+                        read,
+                        &opts,
+                        prev,
+                        cur,
+                    ));
+                }
+            }
+        }
+        display_readahead(writer, &readahead)?;
+        Ok(())
+    }
+
+    fn report<W: Write>(&mut self, writer: &mut W, tick: &Tick) -> Result<()> {
+        let format = self.args.output.format();
+        // A chart is redrawn in place when it goes to a terminal.
+        let redraw = self.grapher.is_some() && self.color;
+        if (self.args.clear_screen || redraw) && format == OutputFormat::Table {
+            execute!(
+                writer,
+                terminal::Clear(terminal::ClearType::All),
+                cursor::MoveTo(0, 0)
+            )?;
+        }
+        self.report_events(writer, tick.events)?;
+        for event in tick.events {
+            if let MountEvent::Remounted { mount_point, .. }
+            | MountEvent::Disappeared { mount_point } = event
+            {
+                if let Some(smoother) = &mut self.smoother {
+                    smoother.reset(mount_point);
+                }
+                if let Some(spark) = &mut self.spark {
+                    spark.reset(mount_point);
+                }
+            }
+        }
+        for interval in tick.intervals {
+            if let Some(spark) = &mut self.spark {
+                spark.push(&interval.mount.mount_point, &interval.stats);
+            }
+            if let Some(grapher) = &mut self.grapher {
+                grapher.push(&interval.mount.mount_point, &interval.stats);
+            }
+        }
+        let now = tick.at;
+        // Recorded first so `--cumulative` totals include this interval.
+        if let Some(Ok(mut session)) = self.session.as_deref().map(Mutex::lock) {
+            let transports = tick.transports();
+            for interval in tick.intervals {
+                let mp = &interval.mount.mount_point;
+                session.record(mp, now, tick.secs, &interval.stats);
+                if let Some(deltas) = transports.get(mp) {
+                    session.record_transport(mp, &total_transport(deltas));
+                }
+            }
+        }
+        let alerts: Vec<Vec<Alert>> = match &self.alert_rules {
+            Some(rules) => tick
+                .intervals
+                .iter()
+                .map(|i| check_interval(rules, &i.mount.mount_point, &i.stats))
+                .collect(),
+            None => Vec::new(),
+        };
+        // `--quiet` hides the mounts with nothing to flag; exports and
+        // the session still see every interval.
+        let mut loud = Vec::new();
+        if self.args.quiet.quiet {
+            loud = tick
+                .intervals
+                .iter()
+                .zip(&alerts)
+                .filter(|(i, alerts)| should_print(&self.args.quiet, &i.stats, alerts, &[]))
+                .map(|(i, _)| i.clone())
+                .collect();
+            self.quiet.observe(!loud.is_empty());
+        }
+        let shown = Tick {
+            intervals: if self.args.quiet.quiet {
+                &loud
+            } else {
+                tick.intervals
+            },
+            ..*tick
+        };
+        match format {
+            OutputFormat::Json => self.report_json(writer, &shown, &now)?,
+            OutputFormat::Csv => self.report_csv(writer, &shown, &now)?,
+            // `--watch-op` replaces the table.
+            OutputFormat::Table if self.args.watch_op.watch_op.is_some() => {}
+            OutputFormat::Table if self.grapher.is_some() => {
+                if let Some(grapher) = &self.grapher {
+                    for interval in shown.intervals {
+                        display_graph(writer, grapher, &interval.mount.mount_point)?;
+                    }
+                }
+            }
+            OutputFormat::Table if self.args.summary.summary => {
+                let rows = summarize(shown.intervals, self.sort);
+                display_summary(writer, &rows, &now, self.args.human.human)?;
+            }
+            OutputFormat::Table => self.report_mounts(writer, &shown, &now)?,
+        }
+        if self.alert_rules.is_some() {
+            let alerts: Vec<Alert> = alerts.into_iter().flatten().collect();
+            self.alert_state.record(&alerts);
+            match format {
+                OutputFormat::Table => display_alerts(writer, &alerts, self.color)?,
+                _ => display_alerts(&mut io::stderr(), &alerts, io::stderr().is_terminal())?,
+            }
+        }
+        writer.flush()?;
+
+        let mut stderr = io::stderr();
+        let mut panels = panel_writer(format, writer, &mut stderr);
+        if let Some(operation) = &self.args.watch_op.watch_op {
+            let before = parse_mountstats_str(shown.before)?;
+            let after: Vec<NFSMount> = shown.intervals.iter().map(|i| i.mount.clone()).collect();
+            let rows = watch_rows(operation, &before, &after, shown.secs);
+            display_watch_op(&mut panels, operation, &rows)?;
+        }
+
+        if let Some(talkers) = &mut self.talkers {
+            talkers.push(
+                tick.intervals
+                    .iter()
+                    .map(|i| (i.mount.mount_point.as_str(), i.stats.as_slice())),
+            );
+            display_top_talkers(&mut panels, talkers)?;
+        }
+        if let Some(rollup) = &mut self.rollup {
+            if let Some(window) = rollup.advance(now) {
+                emit_rollup(&self.args.rollup, &mut panels, &window)?;
+            }
+            for interval in tick.intervals {
+                rollup.record(&interval.mount.mount_point, now, tick.secs, &interval.stats);
+            }
+        }
+        #[cfg(feature = "sqlite")]
+        if let Some(store) = &mut self.store {
+            store.record(now, tick.secs, tick.intervals)?;
+        }
+        let mut graphite_lines = Vec::new();
+        let mut statsd_lines = Vec::new();
+        for interval in tick.intervals {
+            let mount_point = &interval.mount.mount_point;
+            let active: Vec<DeltaStats> = interval
+                .stats
+                .iter()
+                .filter(|s| s.delta_ops > 0)
+                .cloned()
+                .collect();
+            if let Some(export) = &mut self.gnuplot {
+                export.record(mount_point, now, &active)?;
+            }
+            if self.graphite.is_some() {
+                graphite_lines.extend(graphite::graphite_lines(
+                    &self.args.graphite.graphite_prefix,
+                    mount_point,
+                    now,
+                    &active,
+                    &self.labels,
+                ));
+            }
+            #[cfg(feature = "parquet")]
+            if let Some(export) = &mut self.parquet {
+                export.record(mount_point, now, &active)?;
+            }
+            if self.statsd.is_some() {
+                statsd_lines.extend(statsd::statsd_lines(
+                    &self.args.statsd,
+                    mount_point,
+                    &interval.mount.server,
+                    &interval.stats,
+                    &self.labels,
+                ));
+            }
+            if let Some(zabbix) = &mut self.zabbix {
+                let lines = sender_lines(
+                    &self.args.zabbix.zabbix_host,
+                    mount_point,
+                    now,
+                    &interval.stats,
+                );
+                write_sender_lines(zabbix, &lines)?;
+            }
+            if let Some(wide) = &mut self.wide {
+                let event = wide_event(
+                    &interval.mount,
+                    now,
+                    tick.secs,
+                    &interval.stats,
+                    &self.labels,
+                );
+                write_wide_event(wide, &event)?;
+            }
+            #[cfg(feature = "opentelemetry")]
+            if self.args.spans.otel_spans {
+                emit_interval_span(
+                    mount_point,
+                    &interval.mount.server,
+                    now.into(),
+                    Duration::from_secs_f64(tick.secs),
+                    &active,
+                    self.args.spans.span_threshold_ms,
+                    &self.labels,
+                );
+            }
+        }
+        if let Some(sender) = &mut self.graphite {
+            // A carbon outage shouldn't stop the display; the sender
+            // reconnects on the next interval.
+            if let Err(e) = sender.send(&graphite_lines) {
+                eprintln!("Warning: sending to graphite failed: {}", e);
+            }
+        }
+        if let Some(sender) = &self.statsd {
+            if let Err(e) = sender.send(&statsd_lines) {
+                eprintln!("Warning: sending to statsd failed: {}", e);
+            }
+        }
+        if !self.groups.is_empty() {
+            let by_server = self.groups.aggregate(
+                tick.intervals
+                    .iter()
+                    .map(|i| (i.mount.server.as_str(), i.stats.as_slice())),
+            );
+            display_server_groups(&mut panels, &by_server)?;
+        }
+
+        let records = self
+            .trace
+            .as_ref()
+            .map(TraceFeed::drain)
+            .unwrap_or_default();
+        let mount_points: Vec<String> = tick
+            .intervals
+            .iter()
+            .map(|i| i.mount.mount_point.clone())
+            .collect();
+        if let Some(panel) = &mut self.processes {
+            for event in records.iter().cloned().filter_map(event_from_record) {
+                panel.attributor.record(&event);
+            }
+            display_process_stats(
+                &mut panels,
+                &panel.attributor.take_interval(),
+                self.args.attribution.proc_top,
+                &mount_points,
+            )?;
+        }
+        if let Some(panel) = &mut self.errors {
+            for record in &records {
+                panel.breakdown.record(record);
+            }
+            display_error_breakdown(&mut panels, &panel.breakdown.take_interval())?;
+        }
+        if self.args.census.open_files {
+            let census = take_census(&self.args.cgroups.proc_root, &mount_points)?;
+            display_census(&mut panels, &census, self.args.attribution.proc_top)?;
+        }
+        if let Some(panel) = &mut self.cgroups {
+            panel.observe(&mut panels, tick.secs)?;
+        }
+        if let Some(panel) = &self.recovery {
+            panel.observe(&mut panels, tick, &records)?;
+        }
+        if let Some(panel) = &mut self.slots {
+            for record in &records {
+                panel.tracker.record(record);
+            }
+            let mounts = session_mounts(&parse_sections(tick.contents));
+            display_slot_usage(&mut panels, &panel.tracker.take_interval(), &mounts)?;
+        }
+        if let Some(panel) = &mut self.latency {
+            for record in &records {
+                panel.tracker.record(record);
+            }
+            display_latency_histograms(&mut panels, &panel.tracker.take_interval())?;
+        }
+        if let Some(panel) = &mut self.delegations {
+            panel.observe(&mut panels, tick, &records)?;
+        }
+        if let Some(panel) = &mut self.writeback {
+            panel.observe(&mut panels, tick)?;
+        }
+        if let Some(panel) = &mut self.slab {
+            panel.observe(&mut panels)?;
+        }
+        if let Some(panel) = &mut self.debug {
+            panel.observe(&mut panels, tick)?;
+        }
+        panels.flush()?;
+        Ok(())
+    }
+}
+
+/// Where text panels go: after the table, or to stderr when stdout
+/// carries JSON or CSV records.
+fn panel_writer<'w, W: Write>(
+    format: OutputFormat,
+    writer: &'w mut W,
+    stderr: &'w mut io::Stderr,
+) -> &'w mut dyn Write {
+    match format {
+        OutputFormat::Table => writer,
+        _ => stderr,
+    }
+}
+
+/// An error if a mount named with `-m` is missing or nothing is selected.
+fn check_selection(selector: &MountSelector, mounts: &[NFSMount]) -> Result<()> {
+    if let Some(missing) = selector.missing(mounts).first() {
+        return Err(NfsGazeError::MountNotFound(missing.to_string()));
+    }
+    if !mounts.iter().any(|m| selector.matches(&m.mount_point)) {
+        return Err(NfsGazeError::MountNotFound(
+            "no NFS mounts found".to_string(),
+        ));
+    }
+    Ok(())
+}
+
+/// Directories the monitor writes into after start-up, for `--sandbox`.
+fn output_dirs(args: &Args) -> Vec<String> {
+    let parent = |path: &str| match Path::new(path).parent() {
+        Some(dir) if !dir.as_os_str().is_empty() => dir.to_string_lossy().into_owned(),
+        _ => ".".to_string(),
+    };
+    let mut dirs = Vec::new();
+    dirs.extend(args.report.report.as_deref().map(parent));
+    dirs.extend(args.gnuplot.gnuplot.clone());
+    // SQLite keeps its journal next to the database.
+    #[cfg(feature = "sqlite")]
+    dirs.extend(
+        args.store
+            .store
+            .as_deref()
+            .and_then(|path| path.to_str())
+            .map(parent),
+    );
+    dirs
+}
+
+/// Serve `--listen`/`--prometheus` from a thread of its own. The exporter
+/// reads mountstats on every scrape rather than sharing the sampler's;
+/// `session`, when given, adds the run's latency percentiles.
+fn spawn_exporter(
+    addr: SocketAddr,
+    args: &Args,
+    selector: &MountSelector,
+    labels: &Labels,
+    session: Option<Arc<Mutex<Session>>>,
+    running: &Arc<AtomicBool>,
+) -> Result<()> {
+    let listener = TcpListener::bind(addr)?;
+    eprintln!("Serving metrics on http://{}/metrics", addr);
+    let path = args.mountstats_path.clone();
+    let selector = selector.clone();
+    let labels = labels.clone();
+    let running = Arc::clone(running);
+    thread::spawn(move || {
+        let session = session.as_deref();
+        if let Err(e) = exporter::serve(listener, &path, &selector, &labels, session, &running) {
+            eprintln!("Warning: metrics exporter stopped: {}", e);
+        }
+    });
+    Ok(())
+}
+
+/// Hand every interval to the `--sink` outputs, alongside the exporter
+/// if one is configured, until `running` is cleared or `--count`
+/// intervals were emitted.
+pub fn run_sinks(args: &Args, running: &Arc<AtomicBool>) -> Result<()> {
+    let mut labels = Labels::from_args(&args.labels).map_err(NfsGazeError::ParseError)?;
+    if let Some(meta) = HostMetadata::collect(&args.host_meta) {
+        meta.add_to(&mut labels);
+    }
+    let columns = columns(&args.columns, args.preset.preset, args.show_bandwidth);
+    let missing = |flag: &str| NfsGazeError::ParseError(format!("--sink needs {}", flag));
+    let mut sinks = Sinks::default();
+    for kind in &args.sink.sinks {
+        let sink: Box<dyn Sink + Send> = match kind {
+            SinkKind::Console => Box::new(ConsoleSink::new(
+                io::stdout(),
+                columns.clone(),
+                args.human.human,
+            )),
+            SinkKind::Nfsiostat => Box::new(IostatSink::new(io::stdout())),
+            SinkKind::Json => Box::new(JsonSink::new(io::stdout(), labels.clone())),
+            SinkKind::Csv => Box::new(CsvSink::new(io::stdout())),
+            SinkKind::Graphite => Box::new(GraphiteSink::new(
+                args.graphite
+                    .graphite
+                    .as_deref()
+                    .ok_or_else(|| missing("--graphite HOST:PORT"))?,
+                &args.graphite.graphite_prefix,
+                labels.clone(),
+            )),
+            SinkKind::Statsd => Box::new(StatsdSink::new(
+                &args.statsd,
+                args.statsd
+                    .statsd
+                    .as_deref()
+                    .ok_or_else(|| missing("--statsd HOST:PORT"))?,
+                labels.clone(),
+            )?),
+        };
+        sinks.add(sink);
+    }
+    let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+    if let Some(addr) = args.exporter.addr() {
+        spawn_exporter(addr, args, &selector, &labels, None, running)?;
+    }
+    let watch = Watcher::new(&args.mountstats_path, args.interval.as_duration())
+        .selector(selector)
+        .watch();
+    let mut shown = 0;
+    while running.load(Ordering::SeqCst) {
+        let Some(item) = watch.recv_timeout(Duration::from_millis(100)) else {
+            continue;
+        };
+        for (name, e) in sinks.emit(&item?) {
+            eprintln!("Warning: {} sink: {}", name, e);
+        }
+        shown += 1;
+        if args.count > 0 && shown >= args.count {
+            break;
+        }
+    }
+    Ok(())
+}
+
+/// `--k8s` and `--all-namespaces`: sample the mounts `scan` gathers from many processes'
+/// mountstats, each returned with its labels, until `running` is cleared
+/// or `--count` intervals were shown. `scan` applies the mount selection
+/// itself, before tagging mount points with where they were found.
+pub fn run_scanned<W, F>(
+    writer: &mut W,
+    args: &Args,
+    running: &AtomicBool,
+    mut scan: F,
+) -> Result<()>
+where
+    W: Write,
+    F: FnMut(&MountSelector, &Labels) -> io::Result<Vec<(NFSMount, Labels)>>,
+{
+    let mut labels = Labels::from_args(&args.labels).map_err(NfsGazeError::ParseError)?;
+    if let Some(meta) = HostMetadata::collect(&args.host_meta) {
+        meta.add_to(&mut labels);
+    }
+    let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+    let preset = args.preset.preset;
+    let operations = parse_operations_filter(presets::operations(args.operations.clone(), preset));
+    let sort = presets::sort(args.sort.sort, preset);
+    let format = args.output.format();
+    let mut scanned = || -> Result<(Vec<NFSMount>, HashMap<String, Labels>)> {
+        let found = scan(&selector, &labels)?;
+        let by_mount = found
+            .iter()
+            .map(|(mount, labels)| (mount.mount_point.clone(), labels.clone()))
+            .collect();
+        Ok((
+            found.into_iter().map(|(mount, _)| mount).collect(),
+            by_mount,
+        ))
+    };
+    let (mounts, _) = scanned()?;
+    if mounts.is_empty() {
+        return Err(NfsGazeError::MountNotFound(
+            "no NFS mounts found".to_string(),
+        ));
+    }
+    let interval = args.interval.as_duration();
+    let mut tracker = MountTracker::new(MountSelector::new::<&str>(&[]));
+    tracker.observe(mounts, interval.as_secs_f64());
+    if format == OutputFormat::Csv {
+        write_csv_header(writer)?;
+    }
+    let mut sampled_at = Instant::now();
+    let mut shown = 0;
+
+    while running.load(Ordering::SeqCst) {
+        sleep_until(sampled_at + interval, running);
+        if !running.load(Ordering::SeqCst) {
+            break;
+        }
+        let now = Instant::now();
+        let (mounts, by_mount) = scanned()?;
+        let secs = now.duration_since(sampled_at).as_secs_f64();
+        sampled_at = now;
+        let update = tracker.observe(mounts, secs);
+        let at = Utc::now();
+        match format {
+            OutputFormat::Table => display_mount_events(writer, &update.events)?,
+            _ => display_mount_events(&mut io::stderr(), &update.events)?,
+        }
+        for interval in &update.intervals {
+            let mount = &interval.mount;
+            let mut stats: Vec<DeltaStats> = interval
+                .stats
+                .iter()
+                .filter(|s| s.delta_ops > 0)
+                .filter(|s| operations.is_empty() || operations.contains(&s.operation))
+                .cloned()
+                .collect();
+            sort_stats_by(&mut stats, sort);
+            match format {
+                OutputFormat::Json => {
+                    let labels = by_mount.get(&mount.mount_point).unwrap_or(&labels);
+                    write_json_record(writer, &interval_json(mount, at, secs, &stats, labels))?;
+                }
+                OutputFormat::Csv => write_csv_rows(writer, &mount.mount_point, at, &stats)?,
+                OutputFormat::Table => {
+                    display_stats_simple(writer, mount, &stats, args.show_bandwidth, &at)?
+                }
+            }
+        }
+        writer.flush()?;
+        shown += 1;
+        if args.count > 0 && shown >= args.count {
+            break;
+        }
+    }
+    Ok(())
+}
+
+/// Show the `--replay` capture at `path` the way the monitor would have
+/// shown it live, stamped with the recorded times.
+pub fn replay_monitor<W: Write>(
+    writer: &mut W,
+    args: &Args,
+    path: &str,
+    running: &Arc<AtomicBool>,
+) -> Result<i32> {
+    let mut snapshots = read_recording(path)?;
+    if args.count > 0 {
+        // One extra snapshot for the baseline.
+        snapshots.truncate(args.count + 1);
+    }
+    let mut monitor = Monitor::new(args, None)?;
+    monitor.start(running);
+    let tracker =
+        MountTracker::new(MountSelector::new(&args.mount_point).with_patterns(&args.patterns));
+    run_replay(
+        &snapshots,
+        tracker,
+        args.replay.speed,
+        running,
+        |before, snapshot, secs, update| {
+            let tick = Tick {
+                intervals: &update.intervals,
+                events: &update.events,
+                before: &before.contents,
+                contents: &snapshot.contents,
+                secs,
+                at: snapshot.timestamp,
+            };
+            monitor.report(writer, &tick)?;
+            writer.flush()?;
+            Ok(())
+        },
+    )?;
+    monitor.finish(writer)
+}
+
+/// Serve `--grpc-listen` on a runtime of its own. Each WatchStats call
+/// samples mountstats itself, at the interval the client asks for.
+#[cfg(feature = "grpc")]
+fn spawn_grpc(
+    addr: std::net::SocketAddr,
+    path: &str,
+    selector: MountSelector,
+    interval: Duration,
+) -> Result<()> {
+    let runtime = tokio::runtime::Runtime::new()?;
+    let service = WatchService::new(path, selector, interval);
+    eprintln!("Serving gRPC WatchStats on {}", addr);
+    thread::spawn(move || {
+        if let Err(e) = runtime.block_on(serve_grpc(addr, service)) {
+            eprintln!("Warning: gRPC server stopped: {}", e);
+        }
+    });
+    Ok(())
+}
+
+/// Sleep until `due`, waking early when `running` is cleared.
+pub fn sleep_until(due: Instant, running: &AtomicBool) {
+    while running.load(Ordering::SeqCst) && Instant::now() < due {
+        thread::sleep(
+            Duration::from_millis(100).min(due.saturating_duration_since(Instant::now())),
+        );
+    }
+}
+
+pub fn run_monitor<W: Write>(
+    writer: &mut W,
+    args: &Args,
+    running: &Arc<AtomicBool>,
+) -> Result<i32> {
+    let redactor = Redactor::from_args(&args.redact)?;
+    if redactor.is_some()
+        && (args.capacity.df || args.census.open_files || args.attribution.by_process)
+    {
+        return Err(NfsGazeError::ParseError(
+            "--redact cannot be combined with --df, --open-files or --by-process, which need the real mount points".to_string(),
+        ));
+    }
+    // Pseudonyms can't be matched against a pattern written for the real
+    // mount points.
+    let patterns = &args.patterns;
+    if redactor.is_some()
+        && !(patterns.m_glob.is_empty()
+            && patterns.m_regex.is_empty()
+            && patterns.exclude.is_empty())
+    {
+        return Err(NfsGazeError::ParseError(
+            "--redact cannot be combined with --m-glob, --m-regex or --exclude; name mounts with -m".to_string(),
+        ));
+    }
+    // The exporter re-reads mountstats by path on every scrape, which
+    // neither redaction nor the sandbox can follow.
+    if args.exporter.addr().is_some() && (redactor.is_some() || args.sandbox.sandbox) {
+        return Err(NfsGazeError::ParseError(
+            "--listen and --prometheus cannot be combined with --redact or --sandbox".to_string(),
+        ));
+    }
+    #[cfg(feature = "grpc")]
+    if args.grpc.grpc_listen.is_some() && (redactor.is_some() || args.sandbox.sandbox) {
+        return Err(NfsGazeError::ParseError(
+            "--grpc-listen cannot be combined with --redact or --sandbox".to_string(),
+        ));
+    }
+    // These read root-only files every interval: other processes' /proc
+    // entries, debugfs and the kernel debug switches.
+    if args.sandbox.sandbox {
+        let root_only = [
+            (args.census.open_files, "--open-files"),
+            (args.cgroups.by_cgroup, "--by-cgroup"),
+            (args.writeback.writeback, "--writeback"),
+            (args.sunrpc.sunrpc, "--sunrpc"),
+            (args.deep_debug.deep_debug, "--deep-debug"),
+        ];
+        if let Some((_, flag)) = root_only.iter().find(|(set, _)| *set) {
+            return Err(NfsGazeError::ParseError(format!(
+                "--sandbox cannot be combined with {}, which needs root every interval",
+                flag
+            )));
+        }
+    }
+    // Once privileges are dropped mountstats can only be re-read through
+    // a descriptor opened beforehand.
+    let mut held = args
+        .sandbox
+        .sandbox
+        .then(|| HeldFile::open(&args.mountstats_path))
+        .transpose()?;
+    // Opened before the sandbox goes up; what is recorded is what the
+    // monitor sees, so a redacted run records redacted snapshots.
+    let mut recorder = args
+        .record
+        .record
+        .as_deref()
+        .map(Recorder::open)
+        .transpose()?;
+    let mut read = || -> Result<String> {
+        let contents = match &mut held {
+            Some(held) => held.read()?,
+            None => fs::read_to_string(&args.mountstats_path)?,
+        };
+        let contents = match &redactor {
+            Some(redactor) => redactor.mountstats(&contents),
+            None => contents,
+        };
+        if let Some(recorder) = &mut recorder {
+            recorder.record(Utc::now(), &contents)?;
+        }
+        Ok(contents)
+    };
+    // Mounts are selected by their real names, then tracked under their
+    // pseudonyms.
+    let selector = MountSelector::new(&args.mount_point).with_patterns(&args.patterns);
+    check_selection(
+        &selector,
+        &parse_mountstats_str(&fs::read_to_string(&args.mountstats_path)?)?,
+    )?;
+    let selector = match &redactor {
+        Some(redactor) => MountSelector::new(
+            &parse_mount_list(&args.mount_point)
+                .iter()
+                .map(|m| redactor.path(m))
+                .collect::<Vec<_>>(),
+        ),
+        None => selector,
+    };
+    let mut contents = read()?;
+    let mounts = select_by_flavor(
+        parse_mountstats_str(&contents)?,
+        &parse_sections(&contents),
+        &args.security,
+    );
+    if let Some(level) = args.zabbix.zabbix_discovery {
+        let selected: Vec<NFSMount> = mounts
+            .into_iter()
+            .filter(|m| selector.matches(&m.mount_point))
+            .collect();
+        writeln!(writer, "{}", discovery_json(&selected, level))?;
+        return Ok(0);
+    }
+
+    let mut interval = args.interval;
+    let (parse_time, mount_count) = measure_parse(&args.mountstats_path)?;
+    let recommended = recommend_interval(parse_time, args.sampling.max_overhead);
+    if args.sampling.auto_interval {
+        interval = recommended;
+        eprintln!("Sampling every {}", interval);
+    } else if let Some(advice) = interval_advice(interval, recommended, parse_time, mount_count) {
+        eprintln!("{}", advice);
+    }
+    let mut guard = OverheadGuard::new(&args.sampling);
+    let mut monitor = Monitor::new(args, redactor.as_ref())?;
+    if let Some(addr) = args.exporter.addr() {
+        spawn_exporter(
+            addr,
+            args,
+            &selector,
+            &monitor.labels,
+            monitor.session.clone(),
+            running,
+        )?;
+    }
+    #[cfg(feature = "grpc")]
+    if let Some(addr) = args.grpc.grpc_listen {
+        spawn_grpc(
+            addr,
+            &args.mountstats_path,
+            selector.clone(),
+            interval.as_duration(),
+        )?;
+    }
+    if args.sandbox.sandbox {
+        let dirs = output_dirs(args);
+        let dirs: Vec<&str> = dirs.iter().map(String::as_str).collect();
+        let status = sandbox::apply(&args.sandbox, &dirs, args.notify.notify)?;
+        eprintln!("Sandbox: {}", status);
+    }
+    monitor.start(running);
+    for mount in mounts.iter().filter(|m| selector.matches(&m.mount_point)) {
+        monitor.remember_events(mount);
+    }
+    if args.identity.client_identity {
+        let sections: Vec<_> = parse_sections(&contents)
+            .into_iter()
+            .filter(|s| selector.matches(&s.mount_point))
+            .collect();
+        let mut stderr = io::stderr();
+        let mut panels = panel_writer(args.output.format(), writer, &mut stderr);
+        display_identities(&mut panels, &identify_all(&sections))?;
+    }
+    if args.security.show_caps {
+        let security: Vec<_> = parse_sections(&contents)
+            .iter()
+            .filter(|s| selector.matches(&s.mount_point))
+            .map(mount_security)
+            .filter(|s| matches_flavor(s, &args.security.sec))
+            .collect();
+        let mut stderr = io::stderr();
+        let mut panels = panel_writer(args.output.format(), writer, &mut stderr);
+        display_security(&mut panels, &security)?;
+    }
+    let first_report = args.first_report.first_report;
+    let mut shown = 0;
+    if first_report.immediate() {
+        let since_mount: Vec<MountInterval> = mounts
+            .iter()
+            .filter(|m| selector.matches(&m.mount_point))
+            .map(|m| MountInterval {
+                stats: mount_delta(&zero_baseline(m), m, cumulative_interval_secs(m)),
+                mount: m.clone(),
+            })
+            .collect();
+        let tick = Tick {
+            intervals: &since_mount,
+            events: &[],
+            before: "",
+            contents: &contents,
+            secs: since_mount
+                .iter()
+                .map(|i| cumulative_interval_secs(&i.mount))
+                .fold(1.0, f64::max),
+            at: Utc::now(),
+        };
+        monitor.report_since_mount(writer, &tick)?;
+        writer.flush()?;
+        shown += 1;
+        if args.count > 0 && shown >= args.count {
+            return monitor.finish(writer);
+        }
+    }
+    let mut tracker = MountTracker::new(selector);
+    tracker.observe(mounts, interval.as_secs_f64());
+    let mut idle = IdleTracker::from_args(&args.idle);
+    let mut sampled_at = Instant::now();
+    let mut index = 0;
+
+    while running.load(Ordering::SeqCst) {
+        sleep_until(sampled_at + interval.as_duration(), running);
+        if !running.load(Ordering::SeqCst) {
+            break;
+        }
+        let now = Instant::now();
+        let before = std::mem::replace(&mut contents, read()?);
+        let mounts = select_by_flavor(
+            parse_mountstats_str(&contents)?,
+            &parse_sections(&contents),
+            &args.security,
+        );
+        match guard.observe(now.elapsed(), interval) {
+            Verdict::Ok => {}
+            Verdict::Warn(fraction) => eprintln!(
+                "Reading mountstats takes {:.0}% of the {} interval (limit {}%)",
+                fraction * 100.0,
+                interval,
+                args.sampling.max_overhead
+            ),
+            Verdict::BackOff(longer) => {
+                eprintln!(
+                    "Reading mountstats is too slow for a {} interval; sampling every {}",
+                    interval, longer
+                );
+                interval = longer;
+            }
+        }
+        let secs = now.duration_since(sampled_at).as_secs_f64();
+        let update = tracker.observe(mounts, secs);
+        sampled_at = now;
+
+        let tick = Tick {
+            intervals: &update.intervals,
+            events: &update.events,
+            before: &before,
+            contents: &contents,
+            secs,
+            at: Utc::now(),
+        };
+        if first_report.should_print(index) {
+            monitor.report(writer, &tick)?;
+            shown += 1;
+        } else {
+            monitor.report_events(writer, tick.events)?;
+        }
+        index += 1;
+        if args.count > 0 && shown >= args.count {
+            break;
+        }
+        if let Some(idle) = &mut idle {
+            if idle.observe(is_idle(update.intervals.iter().flat_map(|i| &i.stats))) {
+                let mut stderr = io::stderr();
+                writeln!(
+                    panel_writer(args.output.format(), writer, &mut stderr),
+                    "No NFS activity for {} intervals, exiting",
+                    idle.consecutive()
+                )?;
+                break;
+            }
+        }
+    }
+    monitor.finish(writer)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::tests::MOUNTSTATS;
+    use clap::Parser;
+
+    /// Run the monitor over the shared fixture with `flags`, returning
+    /// what it wrote to stdout.
+    fn run(flags: &[&str]) -> String {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("mountstats");
+        fs::write(&path, MOUNTSTATS).unwrap();
+        let path = path.to_str().unwrap();
+        let mut argv = vec!["nfs-gaze", "-f", path, "-i", "10ms"];
+        argv.extend_from_slice(flags);
+        let args = Args::try_parse_from(argv).unwrap();
+        let mut out = Vec::new();
+        run_monitor(&mut out, &args, &Arc::new(AtomicBool::new(true))).unwrap();
+        String::from_utf8(out).unwrap()
+    }
+
+    #[test]
+    fn test_cumulative_first_report_follows_format() {
+        let out = run(&["--first-report", "cumulative", "--json", "-c", "1"]);
+        let records: Vec<serde_json::Value> = out
+            .lines()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
+        assert_eq!(records.len(), 2);
+        assert_eq!(records[0]["mount"]["mount_point"], "/mnt/nfs");
+
+        let out = run(&["--first-report", "cumulative", "--csv", "-c", "1"]);
+        assert!(out.lines().next().unwrap().starts_with("timestamp,"));
+    }
+
+    #[test]
+    fn test_sandbox_refuses_root_only_panels() {
+        let args = Args::try_parse_from(["nfs-gaze", "--sandbox", "--writeback"]).unwrap();
+        let running = Arc::new(AtomicBool::new(true));
+        let err = run_monitor(&mut Vec::new(), &args, &running).unwrap_err();
+        assert!(err.to_string().contains("--writeback"));
+    }
+
+    #[test]
+    fn test_json_lines_stay_parseable() {
+        let out = run(&["--json", "-c", "2", "--top-talkers", "--watch-op", "READ"]);
+        let mut lines = 0;
+        for line in out.lines() {
+            let record: serde_json::Value = serde_json::from_str(line).unwrap();
+            assert!(record["mount"]["mount_point"].is_string());
+            lines += 1;
+        }
+        // Two mounts, two intervals.
+        assert_eq!(lines, 4);
+    }
+
+    #[test]
+    fn test_csv_header_written_once() {
+        let out = run(&["--csv", "-c", "2", "--top-talkers"]);
+        let headers = out.lines().filter(|l| l.starts_with("timestamp,")).count();
+        assert_eq!(headers, 1);
+        assert!(!out.contains("Top talkers"));
+    }
+
+    #[test]
+    fn test_table_carries_panels() {
+        let out = run(&["-c", "2", "--top-talkers"]);
+        assert!(out.contains("/mnt/nfs"));
+        assert_eq!(out.matches("Top talkers").count(), 2);
+    }
+
+    #[test]
+    fn test_cumulative_first_report_counts_toward_count() {
+        // The report since mount is the first of the two; the panels
+        // start with the first real interval.
+        let out = run(&["--first-report", "cumulative", "-c", "2", "--top-talkers"]);
+        assert_eq!(out.matches("Top talkers").count(), 1);
+    }
+}
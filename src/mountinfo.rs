@@ -0,0 +1,98 @@
+//! Parsing of /proc/<pid>/mountinfo for details mountstats lacks, such as
+//! the superblock device number.
+
+use std::collections::HashMap;
+use std::fs;
+use std::io;
+
+pub const MOUNTINFO_PATH: &str = "/proc/self/mountinfo";
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct MountInfo {
+    pub mount_id: u32,
+    /// `major:minor` of the superblock; also the BDI name for NFS.
+    pub device: String,
+    pub mount_point: String,
+    pub fstype: String,
+    pub source: String,
+    pub super_options: String,
+}
+
+/// Undo the octal escaping mountinfo applies to spaces and friends.
+pub fn unescape(field: &str) -> String {
+    let bytes = field.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] == b'\\' && i + 4 <= bytes.len() {
+            if let Some(value) = std::str::from_utf8(&bytes[i + 1..i + 4])
+                .ok()
+                .and_then(|digits| u8::from_str_radix(digits, 8).ok())
+            {
+                out.push(value);
+                i += 4;
+                continue;
+            }
+        }
+        out.push(bytes[i]);
+        i += 1;
+    }
+    String::from_utf8_lossy(&out).into_owned()
+}
+
+/// Parse one mountinfo line:
+/// `36 35 0:53 / /mnt/nfs rw,relatime shared:1 - nfs4 srv:/export rw,vers=4.2`.
+pub fn parse_line(line: &str) -> Option<MountInfo> {
+    let (left, right) = line.split_once(" - ")?;
+    let left: Vec<&str> = left.split_whitespace().collect();
+    let mut right = right.split_whitespace();
+    if left.len() < 5 {
+        return None;
+    }
+    Some(MountInfo {
+        mount_id: left[0].parse().ok()?,
+        device: left[2].to_string(),
+        mount_point: unescape(left[4]),
+        fstype: right.next()?.to_string(),
+        source: unescape(right.next()?),
+        super_options: right.next().unwrap_or("").to_string(),
+    })
+}
+
+/// NFS entries keyed by mount point. Later entries win, matching the
+/// visible (top-most) mount when paths are stacked.
+pub fn parse_nfs_mountinfo(contents: &str) -> HashMap<String, MountInfo> {
+    contents
+        .lines()
+        .filter_map(parse_line)
+        .filter(|m| m.fstype.starts_with("nfs"))
+        .map(|m| (m.mount_point.clone(), m))
+        .collect()
+}
+
+pub fn read_nfs_mountinfo(path: &str) -> io::Result<HashMap<String, MountInfo>> {
+    Ok(parse_nfs_mountinfo(&fs::read_to_string(path)?))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_nfs_mountinfo() {
+        let contents = "\
+22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+36 22 0:53 / /mnt/nfs rw,relatime shared:2 - nfs4 srv:/export rw,vers=4.2,rsize=1048576
+37 22 0:54 / /mnt/with\\040space rw shared:3 - nfs srv:/old rw,vers=3
+";
+        let mounts = parse_nfs_mountinfo(contents);
+        assert_eq!(mounts.len(), 2);
+        let nfs4 = &mounts["/mnt/nfs"];
+        assert_eq!(nfs4.device, "0:53");
+        assert_eq!(nfs4.fstype, "nfs4");
+        assert_eq!(nfs4.source, "srv:/export");
+        assert!(nfs4.super_options.contains("rsize=1048576"));
+        assert!(mounts.contains_key("/mnt/with space"));
+        assert!(parse_line("garbage").is_none());
+    }
+}
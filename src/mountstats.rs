@@ -0,0 +1,50 @@
+//! The stable library API: mountstats parsing, per-interval deltas and
+//! the types they use, in one place for programs embedding nfs-gaze.
+//!
+//! Everything else in the crate serves the command-line tool and may
+//! change between releases; items re-exported here only change with a
+//! major version.
+//!
+//! ```no_run
+//! use nfs_gaze::mountstats::{mount_delta, parse_mountstats};
+//!
+//! let before = parse_mountstats("/proc/self/mountstats")?;
+//! std::thread::sleep(std::time::Duration::from_secs(1));
+//! let after = parse_mountstats("/proc/self/mountstats")?;
+//! for cur in &after {
+//!     let Some(prev) = before.iter().find(|m| m.mount_point == cur.mount_point) else {
+//!         continue;
+//!     };
+//!     for stat in mount_delta(prev, cur, 1.0) {
+//!         println!("{} {} {:.1} ops/s", cur.mount_point, stat.operation, stat.iops);
+//!     }
+//! }
+//! # Ok::<(), nfs_gaze::mountstats::NfsGazeError>(())
+//! ```
+//!
+//! Snapshots read some other way, such as over SSH or from a capture,
+//! parse with [`parse_mountstats_str`]:
+//!
+//! ```
+//! use nfs_gaze::mountstats::parse_mountstats_str;
+//!
+//! let snapshot = "\
+//! device filer:/export mounted on /mnt/data with fstype nfs4 statvers=1.1
+//! \tage:\t600
+//! \tper-op statistics
+//! \t        READ: 120 120 0 19200 491520 60 240 312 0
+//! ";
+//! let mounts = parse_mountstats_str(snapshot)?;
+//! assert_eq!(mounts[0].mount_point, "/mnt/data");
+//! assert_eq!(mounts[0].operations["READ"].ops, 120);
+//! # Ok::<(), nfs_gaze::mountstats::NfsGazeError>(())
+//! ```
+
+pub use crate::aggregate::{merge_by_operation, total_stats};
+pub use crate::delta::{checked_mount_delta, mount_delta, CounterReset};
+pub use crate::parser::{parse_mountstats, parse_mountstats_str};
+pub use crate::sections::{parse_sections, read_sections, MountSection};
+pub use crate::selection::MountSelector;
+pub use crate::tracker::{MountEvent, MountInterval, MountTracker, Update};
+pub use crate::types::{DeltaStats, NFSMount, NFSOperation, NfsGazeError, Result};
+pub use crate::watcher::{IntervalStats, Watch, Watcher};
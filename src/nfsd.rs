@@ -0,0 +1,452 @@
+//! `nfs-gaze server`: the knfsd side, from /proc/net/rpc/nfsd. Thread
+//! count, reply-cache and read-ahead hit rates, and per-procedure call
+//! rates, so a host that both serves and mounts NFS can be watched with
+//! one tool.
+
+use crate::types::Result;
+use crate::units::{format_kb_rate, format_ops};
+use chrono::Local;
+use clap::Args;
+use std::fs;
+use std::io::{self, Write};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::thread;
+use std::time::{Duration, Instant};
+
+pub const NFSD_PATH: &str = "/proc/net/rpc/nfsd";
+
+const PROC2: &[&str] = &[
+    "NULL", "GETATTR", "SETATTR", "ROOT", "LOOKUP", "READLINK", "READ", "WRCACHE", "WRITE",
+    "CREATE", "REMOVE", "RENAME", "LINK", "SYMLINK", "MKDIR", "RMDIR", "READDIR", "STATFS",
+];
+
+const PROC3: &[&str] = &[
+    "NULL",
+    "GETATTR",
+    "SETATTR",
+    "LOOKUP",
+    "ACCESS",
+    "READLINK",
+    "READ",
+    "WRITE",
+    "CREATE",
+    "MKDIR",
+    "SYMLINK",
+    "MKNOD",
+    "REMOVE",
+    "RMDIR",
+    "RENAME",
+    "LINK",
+    "READDIR",
+    "READDIRPLUS",
+    "FSSTAT",
+    "FSINFO",
+    "PATHCONF",
+    "COMMIT",
+];
+
+const PROC4: &[&str] = &["NULL", "COMPOUND"];
+
+/// NFSv4 operations by opcode; 0-2 are reserved.
+const PROC4OPS: &[&str] = &[
+    "",
+    "",
+    "",
+    "ACCESS",
+    "CLOSE",
+    "COMMIT",
+    "CREATE",
+    "DELEGPURGE",
+    "DELEGRETURN",
+    "GETATTR",
+    "GETFH",
+    "LINK",
+    "LOCK",
+    "LOCKT",
+    "LOCKU",
+    "LOOKUP",
+    "LOOKUPP",
+    "NVERIFY",
+    "OPEN",
+    "OPENATTR",
+    "OPEN_CONFIRM",
+    "OPEN_DOWNGRADE",
+    "PUTFH",
+    "PUTPUBFH",
+    "PUTROOTFH",
+    "READ",
+    "READDIR",
+    "READLINK",
+    "REMOVE",
+    "RENAME",
+    "RENEW",
+    "RESTOREFH",
+    "SAVEFH",
+    "SECINFO",
+    "SETATTR",
+    "SETCLIENTID",
+    "SETCLIENTID_CONFIRM",
+    "VERIFY",
+    "WRITE",
+    "RELEASE_LOCKOWNER",
+    "BACKCHANNEL_CTL",
+    "BIND_CONN_TO_SESSION",
+    "EXCHANGE_ID",
+    "CREATE_SESSION",
+    "DESTROY_SESSION",
+    "FREE_STATEID",
+    "GET_DIR_DELEGATION",
+    "GETDEVICEINFO",
+    "GETDEVICELIST",
+    "LAYOUTCOMMIT",
+    "LAYOUTGET",
+    "LAYOUTRETURN",
+    "SECINFO_NO_NAME",
+    "SEQUENCE",
+    "SET_SSV",
+    "TEST_STATEID",
+    "WANT_DELEGATION",
+    "DESTROY_CLIENTID",
+    "RECLAIM_COMPLETE",
+    "ALLOCATE",
+    "COPY",
+    "COPY_NOTIFY",
+    "DEALLOCATE",
+    "IO_ADVISE",
+    "LAYOUTERROR",
+    "LAYOUTSTATS",
+    "OFFLOAD_CANCEL",
+    "OFFLOAD_STATUS",
+    "READ_PLUS",
+    "SEEK",
+    "WRITE_SAME",
+    "CLONE",
+    "GETXATTR",
+    "SETXATTR",
+    "LISTXATTRS",
+    "REMOVEXATTR",
+];
+
+#[derive(Args, Debug, Clone)]
+pub struct ServerArgs {
+    /// Seconds between samples
+    #[arg(short = 'i', long = "interval", default_value = "1")]
+    pub interval: u64,
+
+    /// Number of intervals to show (0 = until interrupted)
+    #[arg(short = 'c', long = "count", default_value = "0")]
+    pub count: u64,
+
+    /// Path to the nfsd statistics file
+    #[arg(long = "nfsd-path", default_value = NFSD_PATH)]
+    pub nfsd_path: String,
+
+    /// Auto-scale sizes and counts
+    #[arg(short = 'H', long = "human")]
+    pub human: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ProcCount {
+    /// `v2`, `v3`, `v4` or `v4ops`.
+    pub version: &'static str,
+    pub name: String,
+    pub count: u64,
+}
+
+/// Read-ahead cache counters; kernels since 5.x no longer report them.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ReadAhead {
+    pub size: u64,
+    /// Lookups found at any depth in the cache.
+    pub found: u64,
+    pub not_found: u64,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct NfsdStats {
+    pub rc_hits: u64,
+    pub rc_misses: u64,
+    pub rc_nocache: u64,
+    pub bytes_read: u64,
+    pub bytes_written: u64,
+    pub threads: u64,
+    pub read_ahead: Option<ReadAhead>,
+    pub rpc_calls: u64,
+    pub rpc_bad_calls: u64,
+    pub procs: Vec<ProcCount>,
+}
+
+impl NfsdStats {
+    fn count(&self, version: &str, name: &str) -> u64 {
+        self.procs
+            .iter()
+            .find(|p| p.version == version && p.name == name)
+            .map_or(0, |p| p.count)
+    }
+}
+
+fn push_procs(stats: &mut NfsdStats, version: &'static str, names: &[&str], counts: &[u64]) {
+    // proc2/3/4 lead with how many counters follow; proc4ops too.
+    let Some((_, counts)) = counts.split_first() else {
+        return;
+    };
+    for (i, &count) in counts.iter().enumerate() {
+        let name = match names.get(i) {
+            Some(&"") => continue,
+            Some(name) => name.to_string(),
+            None => format!("OP{}", i),
+        };
+        stats.procs.push(ProcCount {
+            version,
+            name,
+            count,
+        });
+    }
+}
+
+pub fn parse_nfsd(contents: &str) -> NfsdStats {
+    let mut stats = NfsdStats::default();
+    for line in contents.lines() {
+        let mut fields = line.split_whitespace();
+        let Some(key) = fields.next() else {
+            continue;
+        };
+        // `th` carries a float histogram on older kernels; only the
+        // leading integers matter here.
+        let values: Vec<u64> = fields.map_while(|f| f.parse().ok()).collect();
+        let at = |i: usize| values.get(i).copied().unwrap_or(0);
+        match key {
+            "rc" => {
+                stats.rc_hits = at(0);
+                stats.rc_misses = at(1);
+                stats.rc_nocache = at(2);
+            }
+            "io" => {
+                stats.bytes_read = at(0);
+                stats.bytes_written = at(1);
+            }
+            "th" => stats.threads = at(0),
+            "ra" if values.len() >= 2 => {
+                stats.read_ahead = Some(ReadAhead {
+                    size: at(0),
+                    found: values[1..values.len() - 1].iter().sum(),
+                    not_found: at(values.len() - 1),
+                });
+            }
+            "rpc" => {
+                stats.rpc_calls = at(0);
+                stats.rpc_bad_calls = at(1);
+            }
+            "proc2" => push_procs(&mut stats, "v2", PROC2, &values),
+            "proc3" => push_procs(&mut stats, "v3", PROC3, &values),
+            "proc4" => push_procs(&mut stats, "v4", PROC4, &values),
+            "proc4ops" => push_procs(&mut stats, "v4ops", PROC4OPS, &values),
+            _ => {}
+        }
+    }
+    stats
+}
+
+pub fn read_nfsd(path: &str) -> io::Result<NfsdStats> {
+    Ok(parse_nfsd(&fs::read_to_string(path)?))
+}
+
+fn ratio(hits: u64, misses: u64) -> Option<f64> {
+    let total = hits + misses;
+    (total > 0).then(|| hits as f64 * 100.0 / total as f64)
+}
+
+fn percent(value: Option<f64>) -> String {
+    value.map_or_else(|| "-".to_string(), |v| format!("{:.1}%", v))
+}
+
+/// One interval between two snapshots. Counters that went backwards
+/// (nfsd was restarted) count from zero.
+pub fn display_server<W: Write>(
+    writer: &mut W,
+    prev: &NfsdStats,
+    cur: &NfsdStats,
+    secs: f64,
+    human: bool,
+) -> io::Result<()> {
+    let secs = secs.max(f64::EPSILON);
+    let d = |cur: u64, prev: u64| cur.checked_sub(prev).unwrap_or(cur);
+
+    let rc = ratio(
+        d(cur.rc_hits, prev.rc_hits),
+        d(cur.rc_misses, prev.rc_misses),
+    );
+    write!(
+        writer,
+        "nfsd: {} threads  {} calls/s  {} bad calls  reply cache {}",
+        cur.threads,
+        format_ops(d(cur.rpc_calls, prev.rpc_calls) as f64 / secs, human),
+        d(cur.rpc_bad_calls, prev.rpc_bad_calls),
+        percent(rc)
+    )?;
+    if let (Some(cur_ra), Some(prev_ra)) = (&cur.read_ahead, &prev.read_ahead) {
+        let ra = ratio(
+            d(cur_ra.found, prev_ra.found),
+            d(cur_ra.not_found, prev_ra.not_found),
+        );
+        write!(writer, "  read-ahead {}", percent(ra))?;
+    }
+    writeln!(
+        writer,
+        "  read {}  write {}",
+        format_kb_rate(
+            d(cur.bytes_read, prev.bytes_read) as f64 / 1024.0 / secs,
+            human
+        ),
+        format_kb_rate(
+            d(cur.bytes_written, prev.bytes_written) as f64 / 1024.0 / secs,
+            human
+        ),
+    )?;
+
+    let mut rows: Vec<(&ProcCount, u64)> = cur
+        .procs
+        .iter()
+        .map(|p| (p, d(p.count, prev.count(p.version, &p.name))))
+        .filter(|(_, delta)| *delta > 0)
+        .collect();
+    rows.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.name.cmp(&b.0.name)));
+    if rows.is_empty() {
+        writeln!(writer, "(no calls)")?;
+    } else {
+        writeln!(writer, "{:<7} {:<22} {:>10}", "VERSION", "OP", "OPS/s")?;
+        writeln!(writer, "{}", "-".repeat(41))?;
+        for (proc, delta) in rows {
+            writeln!(
+                writer,
+                "{:<7} {:<22} {:>10}",
+                proc.version,
+                proc.name,
+                format_ops(delta as f64 / secs, human)
+            )?;
+        }
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+pub fn run_server<W: Write>(writer: &mut W, args: &ServerArgs, running: &AtomicBool) -> Result<()> {
+    let interval = Duration::from_secs(args.interval.max(1));
+    let mut prev = read_nfsd(&args.nfsd_path)?;
+    let mut prev_at = Instant::now();
+    let mut shown = 0;
+
+    while running.load(Ordering::SeqCst) {
+        let due = prev_at + interval;
+        while running.load(Ordering::SeqCst) && Instant::now() < due {
+            thread::sleep(
+                Duration::from_millis(100).min(due.saturating_duration_since(Instant::now())),
+            );
+        }
+        if !running.load(Ordering::SeqCst) {
+            break;
+        }
+        let cur = read_nfsd(&args.nfsd_path)?;
+        let now = Instant::now();
+        writeln!(writer, "{}", Local::now().format("%H:%M:%S"))?;
+        display_server(
+            writer,
+            &prev,
+            &cur,
+            now.duration_since(prev_at).as_secs_f64(),
+            args.human,
+        )?;
+        writer.flush()?;
+        prev = cur;
+        prev_at = now;
+
+        shown += 1;
+        if args.count > 0 && shown >= args.count {
+            break;
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const NFSD: &str = "\
+rc 90 10 500
+fh 0 0 0 0 0
+io 1048576 2097152
+th 8 0 0.000 0.000 0.000 0.000 0.000 0.000 0.000 0.000 0.000 0.000
+ra 32 60 10 0 0 0 0 0 0 0 0 30
+net 600 0 600 4
+rpc 600 1 1 0 0
+proc3 22 2 40 0 30 20 0 100 50 0 0 0 0 0 0 0 0 0 0 1 1 0 5
+proc4 2 2 300
+proc4ops 76 0 0 0 10 5 0 0 0 0 80 0 0 0 0 0 20 0 0 5 0 0 0 300 0 0 100 0 0 0 0 0 0 0 0 0 0 0 0 50 0 0 0 0 0 0 0 0 0 0 0 0 0 0 300 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+";
+
+    #[test]
+    fn test_parse_nfsd() {
+        let stats = parse_nfsd(NFSD);
+        assert_eq!(stats.rc_hits, 90);
+        assert_eq!(stats.threads, 8);
+        assert_eq!(stats.bytes_written, 2097152);
+        assert_eq!(
+            stats.read_ahead,
+            Some(ReadAhead {
+                size: 32,
+                found: 70,
+                not_found: 30
+            })
+        );
+        assert_eq!(stats.rpc_calls, 600);
+        assert_eq!(stats.count("v3", "READ"), 100);
+        assert_eq!(stats.count("v3", "COMMIT"), 5);
+        assert_eq!(stats.count("v4", "COMPOUND"), 300);
+        assert_eq!(stats.count("v4ops", "PUTFH"), 300);
+        assert_eq!(stats.count("v4ops", "SEQUENCE"), 300);
+        // Reserved opcodes are skipped; unnamed newer ones keep their number.
+        assert!(stats.procs.iter().all(|p| !p.name.is_empty()));
+        assert_eq!(stats.count("v4ops", "OP76"), 0);
+        assert!(stats.procs.iter().any(|p| p.name == "REMOVEXATTR"));
+    }
+
+    #[test]
+    fn test_display_server() {
+        let prev = parse_nfsd("rc 0 0 0\nth 8\nrpc 100 0\nproc3 22 0 0 0 0 0 0 10\n");
+        let cur = parse_nfsd(NFSD);
+        let mut out = Vec::new();
+        display_server(&mut out, &prev, &cur, 2.0, false).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert!(
+            lines[0].starts_with("nfsd: 8 threads  250.0 calls/s  1 bad calls  reply cache 90.0%")
+        );
+        // No read-ahead in the previous sample, so no ratio.
+        assert!(!lines[0].contains("read-ahead"));
+        // Busiest first, name as tie-breaker.
+        let ops: Vec<(&str, &str)> = lines[3..]
+            .iter()
+            .take_while(|l| !l.is_empty())
+            .map(|l| {
+                let f: Vec<&str> = l.split_whitespace().collect();
+                (f[0], f[1])
+            })
+            .collect();
+        assert_eq!(
+            &ops[..3],
+            &[
+                ("v4", "COMPOUND"),
+                ("v4ops", "PUTFH"),
+                ("v4ops", "SEQUENCE")
+            ]
+        );
+        // READ went from 10 to 100 over 2s.
+        assert!(text.contains(&format!("{:<7} {:<22} {:>10}", "v3", "READ", "45.0")));
+
+        let mut out = Vec::new();
+        display_server(&mut out, &cur, &cur, 1.0, false).unwrap();
+        assert!(String::from_utf8(out).unwrap().contains("(no calls)"));
+    }
+}
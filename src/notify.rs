@@ -0,0 +1,201 @@
+//! Opt-in terminal bell and desktop notifications when an alert fires.
+
+use crate::labels::Labels;
+use clap::Args;
+use std::collections::HashMap;
+use std::io::{self, Write};
+use std::process::{Command, Stdio};
+use std::thread;
+use std::time::{Duration, Instant};
+
+/// The same alert is not repeated more often than this.
+const REPEAT_AFTER: Duration = Duration::from_secs(60);
+
+#[derive(Args, Debug, Clone)]
+pub struct NotifyArgs {
+    /// Ring the terminal bell when an alert fires
+    #[arg(long = "bell")]
+    pub bell: bool,
+
+    /// Send a desktop notification (notify-send) when an alert fires
+    #[arg(long = "notify")]
+    pub notify: bool,
+}
+
+type Sender = Box<dyn FnMut(&str, &str) + Send>;
+
+pub struct Notifier {
+    bell: bool,
+    send: Option<Sender>,
+    last_sent: HashMap<String, Instant>,
+    labels: String,
+}
+
+/// Fire-and-forget notify-send; a missing binary or absent desktop
+/// session is silently ignored.
+fn notify_send(summary: &str, body: &str) {
+    let child = Command::new("notify-send")
+        .args(["--app-name=nfs-gaze", "--urgency=critical", summary, body])
+        .stdin(Stdio::null())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .spawn();
+    if let Ok(mut child) = child {
+        // Reap in the background so a slow notification daemon never
+        // delays an interval.
+        thread::spawn(move || child.wait());
+    }
+}
+
+impl Notifier {
+    pub fn new(args: &NotifyArgs) -> Self {
+        let send: Option<Sender> = if args.notify {
+            Some(Box::new(notify_send))
+        } else {
+            None
+        };
+        Self {
+            bell: args.bell,
+            send,
+            last_sent: HashMap::new(),
+            labels: String::new(),
+        }
+    }
+
+    /// Use `send` instead of notify-send.
+    pub fn with_sender<F>(bell: bool, send: F) -> Self
+    where
+        F: FnMut(&str, &str) + Send + 'static,
+    {
+        Self {
+            bell,
+            send: Some(Box::new(send)),
+            last_sent: HashMap::new(),
+            labels: String::new(),
+        }
+    }
+
+    /// Append the static labels to every notification body.
+    pub fn set_labels(&mut self, labels: &Labels) {
+        self.labels = labels.to_string();
+    }
+
+    pub fn is_enabled(&self) -> bool {
+        self.bell || self.send.is_some()
+    }
+
+    /// Announce an alert identified by `key` (e.g. mount point plus rule),
+    /// unless the same key fired within the last minute. The bell goes to
+    /// `terminal`, normally stderr so it survives redirected output.
+    pub fn alert<W: Write>(
+        &mut self,
+        terminal: &mut W,
+        key: &str,
+        summary: &str,
+        body: &str,
+    ) -> io::Result<bool> {
+        self.alert_at(terminal, key, summary, body, Instant::now())
+    }
+
+    fn alert_at<W: Write>(
+        &mut self,
+        terminal: &mut W,
+        key: &str,
+        summary: &str,
+        body: &str,
+        now: Instant,
+    ) -> io::Result<bool> {
+        if !self.is_enabled() {
+            return Ok(false);
+        }
+        if let Some(last) = self.last_sent.get(key) {
+            if now.duration_since(*last) < REPEAT_AFTER {
+                return Ok(false);
+            }
+        }
+        self.last_sent.insert(key.to_string(), now);
+
+        if self.bell {
+            terminal.write_all(b"\x07")?;
+            terminal.flush()?;
+        }
+        if let Some(send) = self.send.as_mut() {
+            if self.labels.is_empty() {
+                send(summary, body);
+            } else {
+                send(summary, &format!("{} [{}]", body, self.labels));
+            }
+        }
+        Ok(true)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::{Arc, Mutex};
+
+    #[test]
+    fn test_alert_rate_limited() {
+        let sent = Arc::new(Mutex::new(Vec::new()));
+        let log = sent.clone();
+        let mut notifier = Notifier::with_sender(true, move |summary, body| {
+            log.lock().unwrap().push(format!("{}: {}", summary, body));
+        });
+
+        let mut labels = Labels::new();
+        labels.add("cluster=hpc01").unwrap();
+        notifier.set_labels(&labels);
+
+        let mut terminal = Vec::new();
+        let t0 = Instant::now();
+        assert!(notifier
+            .alert_at(&mut terminal, "/mnt:rtt", "NFS alert", "/mnt RTT 80ms", t0)
+            .unwrap());
+        assert!(!notifier
+            .alert_at(
+                &mut terminal,
+                "/mnt:rtt",
+                "NFS alert",
+                "/mnt RTT 90ms",
+                t0 + Duration::from_secs(5)
+            )
+            .unwrap());
+        assert!(notifier
+            .alert_at(
+                &mut terminal,
+                "/other:rtt",
+                "NFS alert",
+                "/other RTT 90ms",
+                t0
+            )
+            .unwrap());
+        assert!(notifier
+            .alert_at(
+                &mut terminal,
+                "/mnt:rtt",
+                "NFS alert",
+                "again",
+                t0 + REPEAT_AFTER
+            )
+            .unwrap());
+
+        assert_eq!(terminal, b"\x07\x07\x07");
+        assert_eq!(sent.lock().unwrap().len(), 3);
+        assert_eq!(
+            sent.lock().unwrap()[0],
+            "NFS alert: /mnt RTT 80ms [cluster=hpc01]"
+        );
+    }
+
+    #[test]
+    fn test_disabled() {
+        let mut notifier = Notifier::new(&NotifyArgs {
+            bell: false,
+            notify: false,
+        });
+        let mut terminal = Vec::new();
+        assert!(!notifier.alert(&mut terminal, "k", "s", "b").unwrap());
+        assert!(terminal.is_empty());
+    }
+}
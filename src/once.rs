@@ -0,0 +1,102 @@
+//! `--once`: print totals since mount for each selected mount and exit,
+//! the way a bare `nfsiostat` does, for cron jobs and quick looks.
+
+use crate::columns::{display_columns, Column};
+use crate::delta::mount_delta;
+use crate::firstreport::{cumulative_interval_secs, zero_baseline};
+use crate::parser::parse_mountstats;
+use crate::selection::MountSelector;
+use crate::totals::display_totals;
+use crate::tracker::MountInterval;
+use crate::types::{NFSMount, Result};
+use clap::Args;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct OnceArgs {
+    /// Print cumulative stats since mount once and exit
+    #[arg(long = "once")]
+    pub once: bool,
+}
+
+/// Each mount's counters since mount, with rates over the mount's age.
+pub fn since_mount(mounts: Vec<NFSMount>) -> Vec<MountInterval> {
+    mounts
+        .into_iter()
+        .map(|mount| {
+            let stats = mount_delta(
+                &zero_baseline(&mount),
+                &mount,
+                cumulative_interval_secs(&mount),
+            );
+            MountInterval { mount, stats }
+        })
+        .collect()
+}
+
+pub fn display_since_mount<W: Write>(
+    writer: &mut W,
+    intervals: &[MountInterval],
+    columns: &[Column],
+    human: bool,
+) -> io::Result<()> {
+    for interval in intervals {
+        let mount = &interval.mount;
+        writeln!(writer, "{} mounted on {}", mount.device, mount.mount_point)?;
+        writeln!(writer, "Since mount: {}s", mount.age)?;
+        writeln!(writer)?;
+        display_columns(writer, &interval.stats, columns, human)?;
+        display_totals(writer, &interval.stats)?;
+        writeln!(writer)?;
+    }
+    Ok(())
+}
+
+/// Read `path` once and print every selected mount's totals since mount.
+pub fn run_once<W: Write>(
+    writer: &mut W,
+    path: &str,
+    selector: &MountSelector,
+    columns: &[Column],
+    human: bool,
+) -> Result<()> {
+    let intervals = since_mount(selector.select(parse_mountstats(path)?));
+    display_since_mount(writer, &intervals, columns, human)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::columns::DEFAULT_COLUMNS;
+    use crate::testutil::mount;
+    use crate::types::NFSOperation;
+
+    #[test]
+    fn test_since_mount() {
+        let mut m = mount("/mnt/a");
+        m.age = 50;
+        m.operations.insert(
+            "GETATTR".to_string(),
+            NFSOperation {
+                name: "GETATTR".to_string(),
+                ops: 1000,
+                ntrans: 1000,
+                rtt: 500,
+                ..Default::default()
+            },
+        );
+
+        let intervals = since_mount(vec![m]);
+        let stats = &intervals[0].stats;
+        assert_eq!(stats[0].delta_ops, 1000);
+        assert!((stats[0].iops - 20.0).abs() < 1e-9);
+
+        let mut out = Vec::new();
+        display_since_mount(&mut out, &intervals, DEFAULT_COLUMNS, false).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.contains("Since mount: 50s"));
+        assert!(text.contains("GETATTR"));
+        assert!(text.contains("TOTAL: 20.0 IOPS"));
+    }
+}
@@ -0,0 +1,278 @@
+//! Mount options from the `opts:` line, and warnings for option choices
+//! that routinely explain odd counters.
+
+use crate::sections::MountSection;
+use crate::types::DeltaStats;
+use clap::Args;
+use std::collections::{BTreeMap, HashMap};
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct OptionWarningArgs {
+    /// Do not warn about soft mounts and other risky mount options
+    #[arg(long = "no-option-warnings")]
+    pub no_option_warnings: bool,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct MountOptions {
+    options: BTreeMap<String, Option<String>>,
+}
+
+impl MountOptions {
+    /// Parse a comma-separated option string such as `rw,vers=4.2,hard`.
+    pub fn parse(opts: &str) -> Self {
+        let options = opts
+            .split(',')
+            .map(str::trim)
+            .filter(|o| !o.is_empty())
+            .map(|o| match o.split_once('=') {
+                Some((k, v)) => (k.to_string(), Some(v.to_string())),
+                None => (o.to_string(), None),
+            })
+            .collect();
+        Self { options }
+    }
+
+    pub fn from_section(section: &MountSection) -> Self {
+        section.value("opts").map(Self::parse).unwrap_or_default()
+    }
+
+    /// Whether a bare flag (e.g. `soft`, `noac`) is set.
+    pub fn has(&self, flag: &str) -> bool {
+        self.options.contains_key(flag)
+    }
+
+    pub fn get(&self, key: &str) -> Option<&str> {
+        self.options.get(key).and_then(|v| v.as_deref())
+    }
+
+    pub fn get_u64(&self, key: &str) -> Option<u64> {
+        self.get(key).and_then(|v| v.parse().ok())
+    }
+}
+
+/// Options for every section, keyed by mount point.
+pub fn options_by_mount(sections: &[MountSection]) -> HashMap<String, MountOptions> {
+    sections
+        .iter()
+        .map(|s| (s.mount_point.clone(), MountOptions::from_section(s)))
+        .collect()
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Severity {
+    Info,
+    Warning,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct OptionWarning {
+    pub severity: Severity,
+    pub option: String,
+    pub message: &'static str,
+    /// Operations whose columns this option affects; empty for all.
+    pub affects: &'static [&'static str],
+}
+
+/// timeo is in tenths of a second; below 10s on TCP retransmits too
+/// eagerly (the TCP default is 600).
+const MIN_TCP_TIMEO: u64 = 100;
+
+pub fn check_options(opts: &MountOptions) -> Vec<OptionWarning> {
+    let mut warnings = Vec::new();
+    let mut warn = |severity, option: String, message, affects| {
+        warnings.push(OptionWarning {
+            severity,
+            option,
+            message,
+            affects,
+        })
+    };
+
+    if opts.has("soft") || opts.has("softerr") {
+        let option = if opts.has("softerr") {
+            "softerr"
+        } else {
+            "soft"
+        };
+        warn(
+            Severity::Warning,
+            option.to_string(),
+            "I/O fails with errors after retrans timeouts instead of retrying",
+            &[],
+        );
+    }
+    let udp = opts.get("proto").is_some_and(|p| p.starts_with("udp"));
+    if udp {
+        warn(
+            Severity::Warning,
+            "proto=udp".to_string(),
+            "UDP transport is prone to silent data loss under load",
+            &[],
+        );
+    }
+    if let Some(timeo) = opts.get_u64("timeo") {
+        if !udp && timeo < MIN_TCP_TIMEO {
+            warn(
+                Severity::Warning,
+                format!("timeo={}", timeo),
+                "very short RPC timeout causes premature retransmissions",
+                &[],
+            );
+        }
+    }
+    if let Some(retrans) = opts.get_u64("retrans") {
+        if retrans < 2 {
+            warn(
+                Severity::Warning,
+                format!("retrans={}", retrans),
+                "few retries before a major timeout is declared",
+                &[],
+            );
+        }
+    }
+    if opts.has("noac") || opts.get_u64("actimeo") == Some(0) {
+        let option = if opts.has("noac") {
+            "noac"
+        } else {
+            "actimeo=0"
+        };
+        warn(
+            Severity::Warning,
+            option.to_string(),
+            "attribute caching disabled; expect heavy GETATTR traffic",
+            &["GETATTR", "ACCESS"],
+        );
+    } else if opts.get_u64("acregmax") == Some(0) || opts.get_u64("acdirmax") == Some(0) {
+        warn(
+            Severity::Info,
+            "ac*max=0".to_string(),
+            "attribute caching partly disabled",
+            &["GETATTR"],
+        );
+    }
+    if opts.get("lookupcache") == Some("none") {
+        warn(
+            Severity::Info,
+            "lookupcache=none".to_string(),
+            "lookup caching disabled; expect heavy LOOKUP traffic",
+            &["LOOKUP"],
+        );
+    }
+    if opts.has("sync") {
+        warn(
+            Severity::Info,
+            "sync".to_string(),
+            "every write is synchronous",
+            &["WRITE", "COMMIT"],
+        );
+    }
+    warnings
+}
+
+/// Short tags to append to `operation`'s row, e.g. `noac` on GETATTR.
+pub fn annotations<'a>(operation: &str, warnings: &'a [OptionWarning]) -> Vec<&'a str> {
+    warnings
+        .iter()
+        .filter(|w| w.affects.is_empty() || w.affects.contains(&operation))
+        .map(|w| w.option.as_str())
+        .collect()
+}
+
+pub fn display_option_warnings<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    warnings: &[OptionWarning],
+) -> io::Result<()> {
+    for warning in warnings {
+        let label = match warning.severity {
+            Severity::Warning => "WARNING",
+            Severity::Info => "NOTE",
+        };
+        writeln!(
+            writer,
+            "{}: {} mounted with {}: {}",
+            label, mount_point, warning.option, warning.message
+        )?;
+    }
+    Ok(())
+}
+
+/// One line naming the shown operations whose numbers the mount's
+/// options skew, e.g. `Options: GETATTR (soft, actimeo=0)`.
+pub fn display_annotations<W: Write>(
+    writer: &mut W,
+    stats: &[DeltaStats],
+    warnings: &[OptionWarning],
+) -> io::Result<()> {
+    let annotated: Vec<String> = stats
+        .iter()
+        .filter_map(|s| {
+            let tags = annotations(&s.operation, warnings);
+            (!tags.is_empty()).then(|| format!("{} ({})", s.operation, tags.join(", ")))
+        })
+        .collect();
+    if annotated.is_empty() {
+        return Ok(());
+    }
+    writeln!(writer, "Options: {}", annotated.join(", "))?;
+    writeln!(writer)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::sections::{parse_sections, tests::MOUNTSTATS};
+
+    #[test]
+    fn test_parse_options() {
+        let sections = parse_sections(MOUNTSTATS);
+        let opts = MountOptions::from_section(&sections[0]);
+        assert!(opts.has("hard"));
+        assert_eq!(opts.get("vers"), Some("4.2"));
+        assert_eq!(opts.get_u64("rsize"), Some(1048576));
+        assert_eq!(opts.get("hard"), None);
+        assert!(check_options(&opts).is_empty());
+    }
+
+    #[test]
+    fn test_risky_options() {
+        let sections = parse_sections(MOUNTSTATS);
+        let opts = MountOptions::from_section(&sections[1]);
+        let warnings = check_options(&opts);
+        let flagged: Vec<&str> = warnings.iter().map(|w| w.option.as_str()).collect();
+        // timeo is not judged against the TCP floor on UDP mounts.
+        assert_eq!(flagged, vec!["soft", "proto=udp", "retrans=1", "actimeo=0"]);
+
+        assert_eq!(
+            annotations("GETATTR", &warnings),
+            vec!["soft", "proto=udp", "retrans=1", "actimeo=0"]
+        );
+        assert_eq!(
+            annotations("READ", &warnings),
+            vec!["soft", "proto=udp", "retrans=1"]
+        );
+
+        let tcp = MountOptions::parse("hard,proto=tcp,timeo=30,retrans=2,lookupcache=none");
+        let flagged: Vec<String> = check_options(&tcp).into_iter().map(|w| w.option).collect();
+        assert_eq!(flagged, vec!["timeo=30", "lookupcache=none"]);
+    }
+
+    #[test]
+    fn test_display_annotations() {
+        let warnings = check_options(&MountOptions::parse("hard,actimeo=0"));
+        let stats = vec![stat("GETATTR", 10, 1.0), stat("READ", 10, 1.0)];
+        let mut out = Vec::new();
+        display_annotations(&mut out, &stats, &warnings).unwrap();
+        assert_eq!(
+            String::from_utf8(out).unwrap(),
+            "Options: GETATTR (actimeo=0)\n\n"
+        );
+
+        let mut out = Vec::new();
+        display_annotations(&mut out, &stats, &[]).unwrap();
+        assert!(out.is_empty());
+    }
+}
@@ -0,0 +1,243 @@
+//! Stable row ordering for operation tables.
+//!
+//! Operations live in a `HashMap`, whose iteration order changes between
+//! runs and intervals. Every display path orders rows through here so
+//! consecutive intervals line up and output can be diffed. `--sort`
+//! orders by a metric instead, with the name as a tie-breaker so equal
+//! rows still keep their place.
+
+use crate::types::{DeltaStats, NFSMount, NFSOperation};
+use clap::{Args, ValueEnum};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, ValueEnum)]
+pub enum SortKey {
+    /// Operation name (default)
+    #[default]
+    Name,
+    /// Average RTT, highest first
+    Rtt,
+    /// Average execute time, highest first
+    Exec,
+    /// Operations per second, highest first
+    Iops,
+    /// KB per second, highest first
+    Bw,
+    /// Errors plus retransmissions, highest first
+    Errors,
+}
+
+impl SortKey {
+    pub const ALL: [SortKey; 6] = [
+        SortKey::Name,
+        SortKey::Rtt,
+        SortKey::Exec,
+        SortKey::Iops,
+        SortKey::Bw,
+        SortKey::Errors,
+    ];
+
+    /// The key after this one, for cycling through sort orders.
+    pub fn next(self) -> Self {
+        let i = Self::ALL.iter().position(|&k| k == self).unwrap_or(0);
+        Self::ALL[(i + 1) % Self::ALL.len()]
+    }
+
+    /// Column name the key sorts by.
+    pub fn column(self) -> &'static str {
+        match self {
+            SortKey::Name => "name",
+            SortKey::Rtt => "rtt",
+            SortKey::Exec => "exec",
+            SortKey::Iops => "iops",
+            SortKey::Bw => "kb_s",
+            SortKey::Errors => "errors",
+        }
+    }
+
+    /// Sort value for a row; larger sorts first. Zero for `Name`.
+    pub fn metric(self, stat: &DeltaStats) -> f64 {
+        match self {
+            SortKey::Name => 0.0,
+            SortKey::Rtt => stat.avg_rtt,
+            SortKey::Exec => stat.avg_exec,
+            SortKey::Iops => stat.iops,
+            SortKey::Bw => stat.kb_per_sec,
+            SortKey::Errors => (stat.delta_errors + stat.delta_retrans) as f64,
+        }
+    }
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct SortArgs {
+    /// Order operation rows by name (default), rtt, exec, iops, bw or errors
+    #[arg(long = "sort", value_enum)]
+    pub sort: Option<SortKey>,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct TopArgs {
+    /// Show only the N busiest operations per mount (slowest etc. with --sort)
+    #[arg(long = "top", value_name = "N")]
+    pub top: Option<usize>,
+}
+
+/// Keep the `n` highest-ranked active operations. They are ranked by
+/// `key`, or by IOPS when sorting by name. The survivors are then put
+/// back in `key` order for display.
+pub fn top_n(stats: &mut Vec<DeltaStats>, n: usize, key: SortKey) {
+    let rank = if key == SortKey::Name {
+        SortKey::Iops
+    } else {
+        key
+    };
+    stats.retain(|s| s.delta_ops > 0 || s.delta_retrans > 0);
+    sort_stats_by(stats, rank);
+    stats.truncate(n);
+    sort_stats_by(stats, key);
+}
+
+/// Order rows by operation name.
+pub fn sort_stats(stats: &mut [DeltaStats]) {
+    sort_stats_by(stats, SortKey::Name);
+}
+
+/// Order rows by `key`, then by operation name.
+pub fn sort_stats_by(stats: &mut [DeltaStats], key: SortKey) {
+    stats.sort_by(|a, b| {
+        key.metric(b)
+            .total_cmp(&key.metric(a))
+            .then_with(|| a.operation.cmp(&b.operation))
+    });
+}
+
+/// A mount's operations in name order.
+pub fn sorted_operations(mount: &NFSMount) -> Vec<&NFSOperation> {
+    let mut ops: Vec<&NFSOperation> = mount.operations.values().collect();
+    ops.sort_by(|a, b| a.name.cmp(&b.name));
+    ops
+}
+
+/// Mounts in mount-point order, with the device as a tie-breaker for
+/// stacked mounts on the same path.
+pub fn sort_mounts(mounts: &mut [NFSMount]) {
+    mounts.sort_by(|a, b| {
+        a.mount_point
+            .cmp(&b.mount_point)
+            .then_with(|| a.device.cmp(&b.device))
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_sort_stats_is_stable_across_inputs() {
+        let mut a = vec![
+            stat("WRITE", 1, 1.0),
+            stat("GETATTR", 2, 1.0),
+            stat("READ", 3, 1.0),
+        ];
+        let mut b = vec![
+            stat("READ", 3, 1.0),
+            stat("WRITE", 1, 1.0),
+            stat("GETATTR", 2, 1.0),
+        ];
+        sort_stats(&mut a);
+        sort_stats(&mut b);
+
+        let names = |s: &[DeltaStats]| s.iter().map(|d| d.operation.clone()).collect::<Vec<_>>();
+        assert_eq!(names(&a), ["GETATTR", "READ", "WRITE"]);
+        assert_eq!(names(&a), names(&b));
+    }
+
+    #[test]
+    fn test_sort_stats_by_metric() {
+        let mut slow_write = stat("WRITE", 10, 9.0);
+        slow_write.delta_errors = 1;
+        let mut stats = vec![
+            stat("READ", 300, 2.0),
+            slow_write,
+            stat("GETATTR", 300, 0.5),
+            stat("LOOKUP", 5, 2.0),
+        ];
+        let names = |s: &[DeltaStats]| {
+            s.iter()
+                .map(|d| d.operation.as_str())
+                .collect::<Vec<_>>()
+                .join(",")
+        };
+
+        sort_stats_by(&mut stats, SortKey::Rtt);
+        assert_eq!(names(&stats), "WRITE,LOOKUP,READ,GETATTR");
+        sort_stats_by(&mut stats, SortKey::Iops);
+        assert_eq!(names(&stats), "GETATTR,READ,WRITE,LOOKUP");
+        sort_stats_by(&mut stats, SortKey::Errors);
+        assert_eq!(names(&stats), "WRITE,GETATTR,LOOKUP,READ");
+        sort_stats_by(&mut stats, SortKey::Name);
+        assert_eq!(names(&stats), "GETATTR,LOOKUP,READ,WRITE");
+
+        assert_eq!(SortKey::Errors.next(), SortKey::Name);
+    }
+
+    #[test]
+    fn test_top_n() {
+        let mut stats = vec![
+            stat("READ", 300, 2.0),
+            stat("WRITE", 10, 9.0),
+            stat("GETATTR", 500, 0.5),
+            stat("NULL", 0, 0.0),
+            stat("LOOKUP", 5, 4.0),
+        ];
+        let names = |s: &[DeltaStats]| {
+            s.iter()
+                .map(|d| d.operation.as_str())
+                .collect::<Vec<_>>()
+                .join(",")
+        };
+
+        let mut busiest = stats.clone();
+        top_n(&mut busiest, 2, SortKey::Name);
+        assert_eq!(names(&busiest), "GETATTR,READ");
+
+        top_n(&mut stats, 2, SortKey::Rtt);
+        assert_eq!(names(&stats), "WRITE,LOOKUP");
+
+        let mut idle = vec![stat("NULL", 0, 0.0)];
+        top_n(&mut idle, 5, SortKey::Name);
+        assert!(idle.is_empty());
+    }
+
+    #[test]
+    fn test_sorted_operations() {
+        let op = |name: &str| {
+            (
+                name.to_string(),
+                NFSOperation {
+                    name: name.to_string(),
+                    ..Default::default()
+                },
+            )
+        };
+        let mount = NFSMount {
+            device: "s:/e".to_string(),
+            mount_point: "/mnt".to_string(),
+            server: "s".to_string(),
+            export: "/e".to_string(),
+            age: 1,
+            operations: ["WRITE", "ACCESS", "READ", "LOOKUP"]
+                .into_iter()
+                .map(op)
+                .collect(),
+            events: None,
+            bytes_read: 0,
+            bytes_write: 0,
+        };
+        let names: Vec<&str> = sorted_operations(&mount)
+            .iter()
+            .map(|o| o.name.as_str())
+            .collect();
+        assert_eq!(names, ["ACCESS", "LOOKUP", "READ", "WRITE"]);
+    }
+}
@@ -0,0 +1,256 @@
+//! Machine-readable interval output. The table renderer stays the
+//! default; `--format json` (or `--json`) writes one JSON object per
+//! mount per interval instead, one per line, ready for jq, and
+//! `--format csv` (or `--csv`) writes one row per operation with a fixed
+//! header. `-o FILE` sends either to a file.
+
+use crate::labels::Labels;
+use crate::types::{DeltaStats, NFSMount};
+use crate::xprt::NFSTransport;
+use chrono::{DateTime, SecondsFormat, Utc};
+use clap::{Args, ValueEnum};
+use serde_json::{json, Map, Value};
+use std::fs::File;
+use std::io::{self, BufWriter, Write};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default, ValueEnum)]
+pub enum OutputFormat {
+    #[default]
+    Table,
+    Json,
+    Csv,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct OutputArgs {
+    /// Output format for interval reports
+    #[arg(long = "format", value_enum, default_value_t = OutputFormat::Table)]
+    pub format: OutputFormat,
+
+    /// Shorthand for --format json
+    #[arg(long = "json", conflicts_with_all = ["format", "csv"])]
+    pub json: bool,
+
+    /// Shorthand for --format csv
+    #[arg(long = "csv", conflicts_with = "format")]
+    pub csv: bool,
+
+    /// Write output to FILE instead of stdout
+    #[arg(short = 'o', long = "output", value_name = "FILE")]
+    pub output: Option<String>,
+}
+
+impl OutputArgs {
+    pub fn format(&self) -> OutputFormat {
+        if self.json {
+            OutputFormat::Json
+        } else if self.csv {
+            OutputFormat::Csv
+        } else {
+            self.format
+        }
+    }
+
+    /// Destination for interval output: the `-o` file, truncated, or
+    /// stdout.
+    pub fn writer(&self) -> io::Result<Box<dyn Write>> {
+        Ok(match &self.output {
+            Some(path) => Box::new(BufWriter::new(File::create(path)?)),
+            None => Box::new(io::stdout()),
+        })
+    }
+}
+
+fn stat_json(stat: &DeltaStats) -> Value {
+    json!({
+        "operation": stat.operation,
+        "ops": stat.delta_ops,
+        "bytes": stat.delta_bytes,
+        "bytes_sent": stat.delta_sent,
+        "bytes_recv": stat.delta_recv,
+        "rtt_ms": stat.delta_rtt,
+        "exec_ms": stat.delta_exec,
+        "queue_ms": stat.delta_queue,
+        "errors": stat.delta_errors,
+        "retrans": stat.delta_retrans,
+        "iops": stat.iops,
+        "kb_per_sec": stat.kb_per_sec,
+        "kb_per_op": stat.kb_per_op,
+        "avg_rtt_ms": stat.avg_rtt,
+        "avg_exec_ms": stat.avg_exec,
+        "avg_queue_ms": stat.avg_queue,
+    })
+}
+
+/// The record for one mount's interval. `stats` should already be
+/// filtered and ordered the way the table would show them.
+pub fn interval_json(
+    mount: &NFSMount,
+    timestamp: DateTime<Utc>,
+    interval_secs: f64,
+    stats: &[DeltaStats],
+    labels: &Labels,
+) -> Value {
+    let mut fields = Map::new();
+    fields.insert(
+        "timestamp".to_string(),
+        timestamp
+            .to_rfc3339_opts(SecondsFormat::Millis, true)
+            .into(),
+    );
+    fields.insert("interval_secs".to_string(), interval_secs.into());
+    fields.insert(
+        "mount".to_string(),
+        json!({
+            "mount_point": mount.mount_point,
+            "device": mount.device,
+            "server": mount.server,
+            "export": mount.export,
+            "age": mount.age,
+        }),
+    );
+    fields.insert(
+        "operations".to_string(),
+        stats.iter().map(stat_json).collect(),
+    );
+    labels.extend_json(&mut fields);
+    Value::Object(fields)
+}
+
+/// Add the mount's transport statistics to an interval record.
+pub fn attach_transports(record: &mut Value, transports: &[NFSTransport]) {
+    if let Value::Object(fields) = record {
+        fields.insert(
+            "transports".to_string(),
+            serde_json::to_value(transports).unwrap_or(Value::Null),
+        );
+    }
+}
+
+/// Write `record` as a single line and flush, so consumers reading a pipe
+/// see each interval as soon as it is sampled.
+pub fn write_json_record<W: Write>(writer: &mut W, record: &Value) -> io::Result<()> {
+    serde_json::to_writer(&mut *writer, record)?;
+    writeln!(writer)?;
+    writer.flush()
+}
+
+/// CSV columns, in order. Kept stable so saved runs stay loadable.
+pub const CSV_HEADER: &str =
+    "timestamp,mount,op,iops,kb_per_sec,avg_rtt_ms,avg_exec_ms,retrans,errors";
+
+/// Quote a CSV field if it contains a delimiter, quote or newline.
+pub fn csv_field(value: &str) -> String {
+    if value.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", value.replace('"', "\"\""))
+    } else {
+        value.to_string()
+    }
+}
+
+pub fn write_csv_header<W: Write>(writer: &mut W) -> io::Result<()> {
+    writeln!(writer, "{}", CSV_HEADER)
+}
+
+/// One row per operation for a mount's interval.
+pub fn write_csv_rows<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    timestamp: DateTime<Utc>,
+    stats: &[DeltaStats],
+) -> io::Result<()> {
+    let ts = timestamp.to_rfc3339_opts(SecondsFormat::Secs, true);
+    let mount = csv_field(mount_point);
+    for stat in stats {
+        writeln!(
+            writer,
+            "{},{},{},{:.2},{:.2},{:.3},{:.3},{},{}",
+            ts,
+            mount,
+            csv_field(&stat.operation),
+            stat.iops,
+            stat.kb_per_sec,
+            stat.avg_rtt,
+            stat.avg_exec,
+            stat.delta_retrans,
+            stat.delta_errors
+        )?;
+    }
+    writer.flush()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use chrono::TimeZone;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_interval_json() {
+        let mount = NFSMount {
+            device: "filer:/vol".to_string(),
+            mount_point: "/mnt/vol".to_string(),
+            server: "filer".to_string(),
+            export: "/vol".to_string(),
+            age: 42,
+            operations: HashMap::new(),
+            events: None,
+            bytes_read: 0,
+            bytes_write: 0,
+        };
+        let ts = Utc.with_ymd_and_hms(2024, 3, 1, 12, 0, 0).unwrap();
+        let record = interval_json(
+            &mount,
+            ts,
+            2.0,
+            &[stat("READ", 100, 2.0), stat("WRITE", 0, 0.0)],
+            &Labels::new(),
+        );
+
+        assert_eq!(record["timestamp"], "2024-03-01T12:00:00.000Z");
+        assert_eq!(record["interval_secs"], 2.0);
+        assert_eq!(record["mount"]["mount_point"], "/mnt/vol");
+        assert_eq!(record["mount"]["server"], "filer");
+        assert_eq!(record["operations"][0]["operation"], "READ");
+        assert_eq!(record["operations"][0]["ops"], 100);
+        assert_eq!(record["operations"][0]["avg_rtt_ms"], 2.0);
+        assert_eq!(record["operations"].as_array().unwrap().len(), 2);
+
+        let mut record = record;
+        let tcp = NFSTransport::parse("tcp 869 1 1 0 5 1000 1000 0 2000 0 16 100 50").unwrap();
+        attach_transports(&mut record, &[tcp]);
+        assert_eq!(record["transports"][0]["sends"], 1000);
+        assert_eq!(record["transports"][0]["max_slots"], 16);
+
+        let mut out = Vec::new();
+        write_json_record(&mut out, &record).unwrap();
+        let line = String::from_utf8(out).unwrap();
+        assert_eq!(line.lines().count(), 1);
+        let parsed: Value = serde_json::from_str(&line).unwrap();
+        assert_eq!(parsed, record);
+    }
+
+    #[test]
+    fn test_csv_rows() {
+        let ts = Utc.with_ymd_and_hms(2024, 3, 1, 12, 0, 0).unwrap();
+        let mut out = Vec::new();
+        write_csv_header(&mut out).unwrap();
+        write_csv_rows(
+            &mut out,
+            "/mnt/a,b",
+            ts,
+            &[stat("READ", 100, 2.0), stat("WRITE", 10, 1.5)],
+        )
+        .unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(lines[0], CSV_HEADER);
+        assert_eq!(
+            lines[1],
+            "2024-03-01T12:00:00Z,\"/mnt/a,b\",READ,100.00,400.00,2.000,2.000,0,0"
+        );
+        assert_eq!(lines.len(), 3);
+        assert_eq!(csv_field("say \"hi\""), "\"say \"\"hi\"\"\"");
+    }
+}
@@ -0,0 +1,184 @@
+//! Parsing `/proc/self/mountstats` into [`NFSMount`]s.
+//!
+//! Device blocks are split by [`crate::sections`]; this keeps the fields
+//! the core display needs. Per-op lines are read with the layout of the
+//! mount's RPC iostats version.
+
+use crate::perop::{self, OpLayout};
+use crate::sections::{parse_sections, MountSection};
+use crate::types::{NFSEvents, NFSMount, NFSOperation, NfsGazeError, Result};
+use std::fs;
+
+/// Counters on an `events:` line before the two pNFS ones were added.
+const MIN_EVENTS: usize = 25;
+
+pub fn parse_mountstats(path: &str) -> Result<Vec<NFSMount>> {
+    parse_mountstats_str(&fs::read_to_string(path)?)
+}
+
+/// Parse mountstats content already in memory, such as a recorded
+/// snapshot or one fetched from another host.
+pub fn parse_mountstats_str(contents: &str) -> Result<Vec<NFSMount>> {
+    parse_sections(contents).iter().map(parse_mount).collect()
+}
+
+fn parse_mount(section: &MountSection) -> Result<NFSMount> {
+    let (server, export) = split_device(&section.device);
+    let age = match section.value("age") {
+        Some(age) => parse_field("age", age)?,
+        None => 0,
+    };
+    let events = section
+        .value("events")
+        .map(|value| parse_events(&fields(value)))
+        .transpose()?;
+    let (bytes_read, bytes_write) = match section.value("bytes") {
+        Some(value) => {
+            let bytes = fields(value);
+            if bytes.len() < 2 {
+                return Err(NfsGazeError::ParseError(format!(
+                    "bytes line for {} has {} fields",
+                    section.mount_point,
+                    bytes.len()
+                )));
+            }
+            (
+                parse_field("bytes", &bytes[0])?,
+                parse_field("bytes", &bytes[1])?,
+            )
+        }
+        None => (0, 0),
+    };
+    Ok(NFSMount {
+        device: section.device.clone(),
+        mount_point: section.mount_point.clone(),
+        server,
+        export,
+        age,
+        operations: perop::operations(section),
+        events,
+        bytes_read,
+        bytes_write,
+    })
+}
+
+/// Split `server:/export`. IPv6 servers are bracketed, so the export
+/// starts at the first `:/`.
+fn split_device(device: &str) -> (String, String) {
+    match device.find(":/") {
+        Some(i) => (device[..i].to_string(), device[i + 1..].to_string()),
+        None => match device.split_once(':') {
+            Some((server, export)) => (server.to_string(), export.to_string()),
+            None => (device.to_string(), String::new()),
+        },
+    }
+}
+
+fn fields(value: &str) -> Vec<String> {
+    value.split_whitespace().map(str::to_string).collect()
+}
+
+fn parse_field(name: &str, value: &str) -> Result<i64> {
+    value
+        .parse()
+        .map_err(|_| NfsGazeError::ParseError(format!("invalid {} value '{}'", name, value)))
+}
+
+/// Parse the counters of an `events:` line. Kernels before pNFS print
+/// 25; the missing pNFS counters read as zero.
+pub fn parse_events(parts: &[String]) -> Result<NFSEvents> {
+    if parts.len() < MIN_EVENTS {
+        return Err(NfsGazeError::ParseError(format!(
+            "events line has {} fields, expected at least {}",
+            parts.len(),
+            MIN_EVENTS
+        )));
+    }
+    let n = parts
+        .iter()
+        .map(|p| parse_field("events", p))
+        .collect::<Result<Vec<i64>>>()?;
+    let get = |i: usize| n.get(i).copied().unwrap_or(0);
+    Ok(NFSEvents {
+        inode_revalidate: n[0],
+        dentry_revalidate: n[1],
+        data_invalidate: n[2],
+        attr_invalidate: n[3],
+        vfs_open: n[4],
+        vfs_lookup: n[5],
+        vfs_access: n[6],
+        vfs_update_page: n[7],
+        vfs_read_page: n[8],
+        vfs_read_pages: n[9],
+        vfs_write_page: n[10],
+        vfs_write_pages: n[11],
+        vfs_getdents: n[12],
+        vfs_setattr: n[13],
+        vfs_flush: n[14],
+        vfs_fsync: n[15],
+        vfs_lock: n[16],
+        vfs_release: n[17],
+        congestion_wait: n[18],
+        setattr_trunc: n[19],
+        extend_write: n[20],
+        silly_rename: n[21],
+        short_read: n[22],
+        short_write: n[23],
+        delay: n[24],
+        pnfs_read: get(25),
+        pnfs_write: get(26),
+    })
+}
+
+/// Parse the counters after `NAME:` on a statvers 1.1 per-op line, the
+/// same way [`perop::parse_op_line`] reads them from a mountstats file.
+pub fn parse_nfs_operation(name: &str, stats: &[String]) -> Result<NFSOperation> {
+    let line = format!("{}: {}", name, stats.join(" "));
+    perop::parse_op_line(&line, OpLayout::V1_1).ok_or_else(|| {
+        NfsGazeError::ParseError(format!(
+            "{} needs {} numeric counters, got '{}'",
+            name,
+            OpLayout::V1_1.fields(),
+            stats.join(" ")
+        ))
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::tests::MOUNTSTATS;
+
+    #[test]
+    fn test_parse_mountstats_str() {
+        let mounts = parse_mountstats_str(MOUNTSTATS).unwrap();
+        assert_eq!(mounts.len(), 2);
+
+        let first = &mounts[0];
+        assert_eq!(first.server, "filer");
+        assert_eq!(first.export, "/export");
+        assert_eq!(first.age, 3600);
+        assert_eq!(first.bytes_read, 1000);
+        assert_eq!(first.bytes_write, 2000);
+        assert_eq!(first.operations.len(), 4);
+        assert_eq!(first.operations["READ"].bytes_recv, 409_600);
+        assert_eq!(first.events.as_ref().unwrap().pnfs_write, 2700);
+
+        let second = &mounts[1];
+        assert_eq!(second.mount_point, "/mnt/with space");
+        assert!(second.events.is_none());
+        assert_eq!(second.operations["READ"].execute_time, 26);
+    }
+
+    #[test]
+    fn test_split_device() {
+        assert_eq!(
+            split_device("[fe80::1]:/data"),
+            ("[fe80::1]".to_string(), "/data".to_string())
+        );
+        assert_eq!(
+            split_device("filer:vol"),
+            ("filer".to_string(), "vol".to_string())
+        );
+    }
+}
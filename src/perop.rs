@@ -0,0 +1,140 @@
+//! Per-op statistics lines, with the field layout chosen by the
+//! `RPC iostats version:` line.
+//!
+//! statvers 1.0 kernels print eight counters per operation; 1.1 adds an
+//! errors column. Treating every mount as 1.1 rejects all op lines on
+//! older kernels, so the layout is picked per mount.
+
+use crate::sections::MountSection;
+use crate::types::NFSOperation;
+use std::collections::HashMap;
+
+/// Per-op line layout for an iostats version.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum OpLayout {
+    /// ops ntrans timeouts bytes_sent bytes_recv queue rtt execute
+    V1_0,
+    /// As 1.0, plus errors.
+    V1_1,
+}
+
+impl OpLayout {
+    /// Layout for a version string; unknown later versions are read as
+    /// 1.1, ignoring any extra columns.
+    pub fn for_version(version: &str) -> Self {
+        match version.trim() {
+            "1.0" => OpLayout::V1_0,
+            _ => OpLayout::V1_1,
+        }
+    }
+
+    pub fn fields(self) -> usize {
+        match self {
+            OpLayout::V1_0 => 8,
+            OpLayout::V1_1 => 9,
+        }
+    }
+}
+
+/// Version from `RPC iostats version: 1.1  p/v: 100003/4 (nfs)`.
+pub fn rpc_iostats_version(section: &MountSection) -> Option<&str> {
+    section.lines.iter().find_map(|line| {
+        line.strip_prefix("RPC iostats version:")
+            .and_then(|rest| rest.split_whitespace().next())
+    })
+}
+
+/// Layout for a section: its RPC iostats version, falling back to the
+/// device line's statvers, then to 1.1.
+pub fn layout(section: &MountSection) -> OpLayout {
+    rpc_iostats_version(section)
+        .or(section.statvers.as_deref())
+        .map_or(OpLayout::V1_1, OpLayout::for_version)
+}
+
+/// Parse `NAME: n n n ...` using `layout`.
+pub fn parse_op_line(line: &str, layout: OpLayout) -> Option<NFSOperation> {
+    let (name, rest) = line.trim().split_once(':')?;
+    if name.is_empty() || name.contains(char::is_whitespace) {
+        return None;
+    }
+    // The kernel's counters are u64; they are kept bit for bit so deltas
+    // can be taken across a wrap.
+    let nums: Vec<i64> = rest
+        .split_whitespace()
+        .map(|f| f.parse::<u64>().ok().map(|n| n as i64))
+        .collect::<Option<_>>()?;
+    if nums.len() < layout.fields() {
+        return None;
+    }
+    Some(NFSOperation {
+        name: name.to_string(),
+        ops: nums[0],
+        ntrans: nums[1],
+        timeouts: nums[2],
+        bytes_sent: nums[3],
+        bytes_recv: nums[4],
+        queue_time: nums[5],
+        rtt: nums[6],
+        execute_time: nums[7],
+        errors: match layout {
+            OpLayout::V1_0 => 0,
+            OpLayout::V1_1 => nums[8],
+        },
+    })
+}
+
+/// Every operation under a section's `per-op statistics` heading.
+pub fn operations(section: &MountSection) -> HashMap<String, NFSOperation> {
+    let layout = layout(section);
+    section
+        .lines
+        .iter()
+        .skip_while(|line| *line != "per-op statistics")
+        .skip(1)
+        .filter_map(|line| parse_op_line(line, layout))
+        .map(|op| (op.name.clone(), op))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::{parse_sections, tests::MOUNTSTATS};
+
+    #[test]
+    fn test_layout_from_version() {
+        let sections = parse_sections(MOUNTSTATS);
+        assert_eq!(rpc_iostats_version(&sections[0]), Some("1.1"));
+        assert_eq!(layout(&sections[0]), OpLayout::V1_1);
+        assert_eq!(layout(&sections[1]), OpLayout::V1_0);
+        assert_eq!(OpLayout::for_version("1.2"), OpLayout::V1_1);
+    }
+
+    #[test]
+    fn test_operations_for_both_versions() {
+        let sections = parse_sections(MOUNTSTATS);
+
+        let new = operations(&sections[0]);
+        assert_eq!(new.len(), 4);
+        assert_eq!(new["WRITE"].ntrans, 52);
+        assert_eq!(new["WRITE"].errors, 1);
+
+        // The 1.0 mount's eight-field lines are accepted, with no errors.
+        let old = operations(&sections[1]);
+        assert_eq!(old.len(), 1);
+        assert_eq!(old["READ"].ops, 10);
+        assert_eq!(old["READ"].execute_time, 26);
+        assert_eq!(old["READ"].errors, 0);
+    }
+
+    #[test]
+    fn test_parse_op_line_rejects_short_or_bad_lines() {
+        assert!(parse_op_line("READ: 1 2 3 4 5 6 7", OpLayout::V1_0).is_none());
+        assert!(parse_op_line("READ: 1 2 3 4 5 6 7 8", OpLayout::V1_1).is_none());
+        assert!(parse_op_line("READ: 1 2 3 x 5 6 7 8 9", OpLayout::V1_1).is_none());
+        assert!(parse_op_line("age: 10", OpLayout::V1_0).is_none());
+        let op = parse_op_line("GETATTR: 1 1 0 2 3 0 1 1 0", OpLayout::V1_1).unwrap();
+        assert_eq!(op.name, "GETATTR");
+    }
+}
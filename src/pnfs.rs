@@ -0,0 +1,277 @@
+//! `--pnfs`: layout I/O against MDS-proxied I/O per mount.
+//!
+//! The `events:` line counts reads and writes that went through a pNFS
+//! layout straight to the data servers; READ and WRITE in the per-op
+//! table are what went to the metadata server. A mount whose layout
+//! driver is loaded but whose I/O has drifted back to the MDS (layouts
+//! recalled, a data server unreachable) keeps working, only slower, so
+//! the fallback is flagged.
+
+use crate::sections::MountSection;
+use crate::types::{DeltaStats, NFSEvents};
+use clap::Args;
+use std::collections::HashSet;
+use std::io::{self, Write};
+
+/// Layout operations, as named in the per-op table.
+const LAYOUT_OPS: &[&str] = &["LAYOUTGET", "LAYOUTRETURN", "LAYOUTCOMMIT", "GETDEVICEINFO"];
+
+#[derive(Args, Debug, Clone)]
+pub struct PnfsArgs {
+    /// Show pNFS layout I/O rates and how much I/O bypasses the MDS
+    #[arg(long = "pnfs")]
+    pub pnfs: bool,
+}
+
+/// The layout driver from `pnfs=` on the nfsv4 line, e.g.
+/// `LAYOUT_NFSV4_1_FILES`; `None` when not configured.
+pub fn layout_driver(section: &MountSection) -> Option<String> {
+    section
+        .value("nfsv4")?
+        .split(',')
+        .find_map(|f| f.trim().strip_prefix("pnfs="))
+        .filter(|d| !d.is_empty() && *d != "not configured")
+        .map(str::to_string)
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct PnfsInterval {
+    pub secs: f64,
+    pub layout_reads: i64,
+    pub layout_writes: i64,
+    pub mds_reads: i64,
+    pub mds_writes: i64,
+    /// (operation, count) for layout operations that ran.
+    pub layout_ops: Vec<(String, i64)>,
+}
+
+fn share(direct: i64, proxied: i64) -> Option<f64> {
+    let total = direct + proxied;
+    (total > 0).then(|| direct as f64 * 100.0 / total as f64)
+}
+
+impl PnfsInterval {
+    pub fn new(before: &NFSEvents, after: &NFSEvents, stats: &[DeltaStats], secs: f64) -> Self {
+        let ops = |name: &str| {
+            stats
+                .iter()
+                .find(|s| s.operation == name)
+                .map_or(0, |s| s.delta_ops)
+        };
+        Self {
+            secs,
+            layout_reads: (after.pnfs_read - before.pnfs_read).max(0),
+            layout_writes: (after.pnfs_write - before.pnfs_write).max(0),
+            mds_reads: ops("READ"),
+            mds_writes: ops("WRITE"),
+            layout_ops: LAYOUT_OPS
+                .iter()
+                .map(|op| (op.to_string(), ops(op)))
+                .filter(|(_, n)| *n > 0)
+                .collect(),
+        }
+    }
+
+    /// Share of reads that bypassed the MDS.
+    pub fn direct_read_pct(&self) -> Option<f64> {
+        share(self.layout_reads, self.mds_reads)
+    }
+
+    pub fn direct_write_pct(&self) -> Option<f64> {
+        share(self.layout_writes, self.mds_writes)
+    }
+
+    fn rate(&self, count: i64) -> f64 {
+        count as f64 / self.secs.max(f64::EPSILON)
+    }
+
+    fn uses_layouts(&self) -> bool {
+        self.layout_reads + self.layout_writes > 0
+    }
+
+    fn uses_mds(&self) -> bool {
+        self.mds_reads + self.mds_writes > 0
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PnfsWarning {
+    /// Layout I/O was seen earlier, but this interval's I/O all went
+    /// through the MDS.
+    Stopped,
+    /// A layout driver is configured but no I/O has used it yet.
+    Unused,
+}
+
+/// Remembers which mounts have done layout I/O, to tell a fallback from
+/// a mount that never used pNFS.
+#[derive(Debug, Default)]
+pub struct PnfsTracker {
+    used: HashSet<String>,
+}
+
+impl PnfsTracker {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    pub fn check(
+        &mut self,
+        mount_point: &str,
+        driver: Option<&str>,
+        interval: &PnfsInterval,
+    ) -> Option<PnfsWarning> {
+        if interval.uses_layouts() {
+            self.used.insert(mount_point.to_string());
+            return None;
+        }
+        if !interval.uses_mds() {
+            return None;
+        }
+        if self.used.contains(mount_point) {
+            Some(PnfsWarning::Stopped)
+        } else if driver.is_some() {
+            Some(PnfsWarning::Unused)
+        } else {
+            None
+        }
+    }
+}
+
+fn percent(value: Option<f64>) -> String {
+    value.map_or_else(|| "-".to_string(), |v| format!("{:.1}%", v))
+}
+
+pub fn display_pnfs<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    driver: Option<&str>,
+    interval: &PnfsInterval,
+    warning: Option<PnfsWarning>,
+) -> io::Result<()> {
+    writeln!(
+        writer,
+        "pNFS for {} (layout: {})",
+        mount_point,
+        driver.unwrap_or("not configured")
+    )?;
+    writeln!(
+        writer,
+        "{:<6} {:>12} {:>12} {:>9}",
+        "", "LAYOUT/s", "MDS/s", "DIRECT"
+    )?;
+    writeln!(
+        writer,
+        "{:<6} {:>12.1} {:>12.1} {:>9}",
+        "read",
+        interval.rate(interval.layout_reads),
+        interval.rate(interval.mds_reads),
+        percent(interval.direct_read_pct())
+    )?;
+    writeln!(
+        writer,
+        "{:<6} {:>12.1} {:>12.1} {:>9}",
+        "write",
+        interval.rate(interval.layout_writes),
+        interval.rate(interval.mds_writes),
+        percent(interval.direct_write_pct())
+    )?;
+    if !interval.layout_ops.is_empty() {
+        let ops: Vec<String> = interval
+            .layout_ops
+            .iter()
+            .map(|(op, n)| format!("{} {:.1}/s", op, interval.rate(*n)))
+            .collect();
+        writeln!(writer, "layout ops: {}", ops.join(", "))?;
+    }
+    match warning {
+        Some(PnfsWarning::Stopped) => writeln!(
+            writer,
+            "WARNING: {} stopped using pNFS; all I/O is going through the MDS",
+            mount_point
+        )?,
+        Some(PnfsWarning::Unused) => writeln!(
+            writer,
+            "NOTE: {} has a layout driver but no I/O is using it",
+            mount_point
+        )?,
+        None => {}
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::sections::{parse_sections, tests::MOUNTSTATS};
+
+    fn events(read: i64, write: i64) -> NFSEvents {
+        NFSEvents {
+            pnfs_read: read,
+            pnfs_write: write,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_layout_driver() {
+        let sections = parse_sections(MOUNTSTATS);
+        assert_eq!(layout_driver(&sections[0]), None);
+        let mut section = sections[0].clone();
+        section.lines[3] =
+            "nfsv4:\tbm0=0xfdffbfff,sessions,pnfs=LAYOUT_NFSV4_1_FILES,lease_time=90".to_string();
+        assert_eq!(
+            layout_driver(&section).as_deref(),
+            Some("LAYOUT_NFSV4_1_FILES")
+        );
+    }
+
+    #[test]
+    fn test_interval_and_fallback() {
+        let stats = [
+            stat("READ", 100, 1.0),
+            stat("WRITE", 0, 0.0),
+            stat("LAYOUTGET", 4, 1.0),
+        ];
+        let interval = PnfsInterval::new(&events(10, 5), &events(310, 55), &stats, 2.0);
+        assert_eq!(interval.layout_reads, 300);
+        assert_eq!(interval.direct_read_pct(), Some(75.0));
+        assert_eq!(interval.direct_write_pct(), Some(100.0));
+        assert_eq!(interval.layout_ops, vec![("LAYOUTGET".to_string(), 4)]);
+
+        let driver = Some("LAYOUT_NFSV4_1_FILES");
+        let mut tracker = PnfsTracker::new();
+        let mds_only = PnfsInterval::new(&events(310, 55), &events(310, 55), &stats, 1.0);
+        assert_eq!(
+            tracker.check("/mnt", driver, &mds_only),
+            Some(PnfsWarning::Unused)
+        );
+        assert_eq!(tracker.check("/mnt", None, &mds_only), None);
+        assert_eq!(tracker.check("/mnt", driver, &interval), None);
+        assert_eq!(
+            tracker.check("/mnt", driver, &mds_only),
+            Some(PnfsWarning::Stopped)
+        );
+        // Idle intervals are not a fallback.
+        let idle = PnfsInterval::new(&events(0, 0), &events(0, 0), &[], 1.0);
+        assert_eq!(tracker.check("/mnt", driver, &idle), None);
+
+        let mut out = Vec::new();
+        display_pnfs(
+            &mut out,
+            "/mnt",
+            driver,
+            &mds_only,
+            Some(PnfsWarning::Stopped),
+        )
+        .unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(lines[0], "pNFS for /mnt (layout: LAYOUT_NFSV4_1_FILES)");
+        assert_eq!(lines[2], "read            0.0        100.0      0.0%");
+        assert_eq!(lines[3], "write           0.0          0.0         -");
+        assert!(lines[5].starts_with("WARNING: /mnt stopped using pNFS"));
+    }
+}
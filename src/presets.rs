@@ -0,0 +1,175 @@
+//! Scenario presets. `--preset` picks an operation filter, columns, sort
+//! order and advisor checks suited to one question, so a first look at a
+//! problem does not need half a dozen flags. Anything given explicitly on
+//! the command line wins over the preset.
+
+use crate::advisor::{Check, ALL_CHECKS};
+use crate::ordering::SortKey;
+use clap::{Args, ValueEnum};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum Preset {
+    /// Where does time go: RTT, execute and queue time for data ops
+    Latency,
+    /// How much moves: data ops, bandwidth and transfer sizes
+    Throughput,
+    /// Metadata-heavy workloads: GETATTR, LOOKUP, ACCESS and friends
+    Metadata,
+    /// Everything, with retransmission, recovery and option diagnostics
+    Troubleshoot,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct PresetArgs {
+    /// Scenario preset: latency, throughput, metadata or troubleshoot
+    #[arg(long = "preset", value_enum)]
+    pub preset: Option<Preset>,
+}
+
+/// What a preset turns on. Column names are the ones accepted by the
+/// corresponding command-line flag.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PresetSettings {
+    /// Comma-separated operations, as for `--ops`; `None` shows all.
+    pub operations: Option<&'static str>,
+    pub columns: &'static [&'static str],
+    pub sort: SortKey,
+    pub checks: &'static [Check],
+    pub bandwidth: bool,
+    pub attr_cache: bool,
+    pub retrans_breakdown: bool,
+    pub recovery_events: bool,
+    pub option_warnings: bool,
+}
+
+const DATA_OPS: &str = "READ,WRITE,COMMIT";
+const METADATA_OPS: &str =
+    "GETATTR,SETATTR,LOOKUP,ACCESS,READDIR,READDIRPLUS,OPEN,CLOSE,CREATE,REMOVE,RENAME";
+
+impl Preset {
+    pub fn settings(self) -> PresetSettings {
+        match self {
+            Preset::Latency => PresetSettings {
+                operations: Some(DATA_OPS),
+                columns: &["ops", "rtt", "exec", "queue", "retrans"],
+                sort: SortKey::Rtt,
+                checks: &[Check::Stalls, Check::Retrans, Check::SlowIo],
+                bandwidth: false,
+                attr_cache: false,
+                retrans_breakdown: false,
+                recovery_events: false,
+                option_warnings: false,
+            },
+            Preset::Throughput => PresetSettings {
+                operations: Some(DATA_OPS),
+                columns: &["ops", "iops", "kb_s", "kb_op", "rtt"],
+                sort: SortKey::Bw,
+                checks: &[Check::SmallIo, Check::SlowIo],
+                bandwidth: true,
+                attr_cache: false,
+                retrans_breakdown: false,
+                recovery_events: false,
+                option_warnings: false,
+            },
+            Preset::Metadata => PresetSettings {
+                operations: Some(METADATA_OPS),
+                columns: &["ops", "iops", "rtt", "exec", "errors"],
+                sort: SortKey::Iops,
+                checks: &[Check::GetattrStorm],
+                bandwidth: false,
+                attr_cache: true,
+                retrans_breakdown: false,
+                recovery_events: false,
+                option_warnings: true,
+            },
+            Preset::Troubleshoot => PresetSettings {
+                operations: None,
+                columns: &[
+                    "ops", "iops", "kb_s", "rtt", "exec", "queue", "errors", "retrans",
+                ],
+                sort: SortKey::Errors,
+                checks: ALL_CHECKS,
+                bandwidth: true,
+                attr_cache: true,
+                retrans_breakdown: true,
+                recovery_events: true,
+                option_warnings: true,
+            },
+        }
+    }
+}
+
+/// `explicit` if the user gave it, otherwise the preset's value.
+pub fn resolve<T>(explicit: Option<T>, preset: Option<T>) -> Option<T> {
+    explicit.or(preset)
+}
+
+/// Operation filter to use: `--ops` if given, else the preset's.
+pub fn operations(explicit: Option<String>, preset: Option<Preset>) -> Option<String> {
+    resolve(
+        explicit,
+        preset
+            .and_then(|p| p.settings().operations)
+            .map(str::to_string),
+    )
+}
+
+/// A boolean flag is on if set explicitly or implied by the preset.
+pub fn flag(explicit: bool, preset: Option<Preset>, pick: fn(&PresetSettings) -> bool) -> bool {
+    explicit || preset.is_some_and(|p| pick(&p.settings()))
+}
+
+/// Sort order: `--sort` if given, else the preset's, else by name.
+pub fn sort(explicit: Option<SortKey>, preset: Option<Preset>) -> SortKey {
+    resolve(explicit, preset.map(|p| p.settings().sort)).unwrap_or_default()
+}
+
+/// Advisor checks to run; all of them without a preset.
+pub fn checks(preset: Option<Preset>) -> &'static [Check] {
+    preset.map_or(ALL_CHECKS, |p| p.settings().checks)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_explicit_flags_win() {
+        assert_eq!(
+            operations(None, Some(Preset::Latency)).as_deref(),
+            Some(DATA_OPS)
+        );
+        assert_eq!(
+            operations(Some("GETATTR".to_string()), Some(Preset::Latency)).as_deref(),
+            Some("GETATTR")
+        );
+        assert_eq!(operations(None, Some(Preset::Troubleshoot)), None);
+        assert_eq!(operations(None, None), None);
+
+        assert!(flag(false, Some(Preset::Throughput), |s| s.bandwidth));
+        assert!(!flag(false, Some(Preset::Latency), |s| s.bandwidth));
+        assert!(flag(true, None, |s| s.bandwidth));
+
+        assert_eq!(sort(None, Some(Preset::Latency)), SortKey::Rtt);
+        assert_eq!(
+            sort(Some(SortKey::Iops), Some(Preset::Latency)),
+            SortKey::Iops
+        );
+        assert_eq!(sort(None, None), SortKey::Name);
+
+        assert_eq!(checks(None), ALL_CHECKS);
+        assert_eq!(checks(Some(Preset::Metadata)), &[Check::GetattrStorm]);
+    }
+
+    #[test]
+    fn test_presets_sort_by_a_shown_column() {
+        for preset in Preset::value_variants() {
+            let settings = preset.settings();
+            assert!(
+                settings.columns.contains(&settings.sort.column()),
+                "{:?} sorts by a hidden column",
+                preset
+            );
+        }
+    }
+}
@@ -0,0 +1,128 @@
+//! Streaming quantile estimates for long runs.
+//!
+//! Buckets grow geometrically, each 2% wider than the last, so an
+//! estimate is always within 1% of a value that was recorded and memory
+//! depends on the range of values rather than the length of the run.
+
+use std::collections::BTreeMap;
+
+const GAMMA: f64 = 1.02;
+/// Values at or below this are counted as zero.
+const MIN_VALUE: f64 = 1e-3;
+
+/// Quantiles reported in summaries and metrics.
+pub const REPORTED: [f64; 3] = [0.5, 0.95, 0.99];
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Quantiles {
+    zeros: u64,
+    buckets: BTreeMap<i32, u64>,
+    count: u64,
+    sum: f64,
+    max: f64,
+}
+
+impl Quantiles {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Add one value; negative and non-finite values are ignored.
+    pub fn record(&mut self, value: f64) {
+        if !value.is_finite() || value < 0.0 {
+            return;
+        }
+        self.count += 1;
+        self.sum += value;
+        self.max = self.max.max(value);
+        if value <= MIN_VALUE {
+            self.zeros += 1;
+        } else {
+            let index = (value.ln() / GAMMA.ln()).ceil() as i32;
+            *self.buckets.entry(index).or_insert(0) += 1;
+        }
+    }
+
+    pub fn count(&self) -> u64 {
+        self.count
+    }
+
+    pub fn sum(&self) -> f64 {
+        self.sum
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.count == 0
+    }
+
+    /// Estimated value at quantile `q` (0.0-1.0), by nearest rank.
+    pub fn quantile(&self, q: f64) -> f64 {
+        if self.count == 0 {
+            return 0.0;
+        }
+        let rank = ((q.clamp(0.0, 1.0) * self.count as f64).ceil() as u64).max(1);
+        if rank >= self.count {
+            return self.max;
+        }
+        if rank <= self.zeros {
+            return 0.0;
+        }
+        let mut seen = self.zeros;
+        for (&index, &n) in &self.buckets {
+            seen += n;
+            if seen >= rank {
+                // Bucket `index` holds (GAMMA^(index-1), GAMMA^index]; its
+                // midpoint in relative terms bounds the error.
+                let estimate = 2.0 * GAMMA.powi(index) / (GAMMA + 1.0);
+                return estimate.min(self.max);
+            }
+        }
+        self.max
+    }
+
+    /// Label such as `p95` for a reported quantile.
+    pub fn label(q: f64) -> String {
+        format!("p{}", (q * 100.0).round())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_quantiles() {
+        let mut q = Quantiles::new();
+        assert_eq!(q.quantile(0.5), 0.0);
+        for i in 1..=100 {
+            q.record(i as f64);
+        }
+        q.record(f64::NAN);
+        q.record(-1.0);
+
+        assert_eq!(q.count(), 100);
+        assert!((q.sum() - 5050.0).abs() < 1e-9);
+        for (quantile, expected) in [(0.5, 50.0), (0.95, 95.0), (0.99, 99.0)] {
+            let estimate = q.quantile(quantile);
+            assert!(
+                (estimate - expected).abs() / expected <= 0.01,
+                "{} -> {}",
+                quantile,
+                estimate
+            );
+        }
+        assert_eq!(q.quantile(1.0), 100.0);
+        assert_eq!(Quantiles::label(0.95), "p95");
+    }
+
+    #[test]
+    fn test_zero_values() {
+        let mut q = Quantiles::new();
+        for _ in 0..9 {
+            q.record(0.0);
+        }
+        q.record(12.5);
+        assert_eq!(q.quantile(0.5), 0.0);
+        assert!((q.quantile(0.99) - 12.5).abs() / 12.5 <= 0.01);
+    }
+}
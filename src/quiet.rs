@@ -0,0 +1,94 @@
+//! `--quiet`: print nothing for ordinary intervals, only those with
+//! activity that tripped an alert threshold or strayed from a baseline,
+//! so a run can sit in a tmux pane for days and still be readable.
+
+use crate::alert::Alert;
+use crate::baseline::Deviation;
+use crate::idle::is_idle;
+use crate::types::DeltaStats;
+use clap::Args;
+
+#[derive(Args, Debug, Clone)]
+pub struct QuietArgs {
+    /// Only print intervals that breach an alert threshold or baseline
+    #[arg(short = 'q', long = "quiet")]
+    pub quiet: bool,
+}
+
+/// Whether a mount's interval should be shown. Without `--quiet`
+/// everything is; with it, only intervals with activity where an alert
+/// or baseline deviation fired.
+pub fn should_print(
+    args: &QuietArgs,
+    stats: &[DeltaStats],
+    alerts: &[Alert],
+    deviations: &[Deviation],
+) -> bool {
+    if !args.quiet {
+        return true;
+    }
+    !is_idle(stats) && (!alerts.is_empty() || !deviations.is_empty())
+}
+
+/// Counts intervals suppressed by `--quiet`, for the exit summary.
+#[derive(Debug, Clone, Default)]
+pub struct QuietCounter {
+    shown: u64,
+    suppressed: u64,
+}
+
+impl QuietCounter {
+    pub fn observe(&mut self, printed: bool) {
+        if printed {
+            self.shown += 1;
+        } else {
+            self.suppressed += 1;
+        }
+    }
+
+    pub fn suppressed(&self) -> u64 {
+        self.suppressed
+    }
+
+    /// e.g. `3 of 3600 intervals shown`.
+    pub fn describe(&self) -> String {
+        format!(
+            "{} of {} intervals shown",
+            self.shown,
+            self.shown + self.suppressed
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::alert::{check_interval, AlertRules};
+
+    #[test]
+    fn test_should_print() {
+        let loud = QuietArgs { quiet: false };
+        let quiet = QuietArgs { quiet: true };
+        let rules = AlertRules {
+            rtt_ms: Some(5.0),
+            ..Default::default()
+        };
+
+        let busy = [stat("READ", 100, 1.0)];
+        assert!(should_print(&loud, &busy, &[], &[]));
+        assert!(!should_print(&quiet, &busy, &[], &[]));
+
+        let slow = [stat("READ", 100, 9.0)];
+        let alerts = check_interval(&rules, "/mnt", &slow);
+        assert!(should_print(&quiet, &slow, &alerts, &[]));
+        assert!(!should_print(&quiet, &[stat("READ", 0, 0.0)], &alerts, &[]));
+
+        let mut counter = QuietCounter::default();
+        counter.observe(false);
+        counter.observe(true);
+        counter.observe(false);
+        assert_eq!(counter.suppressed(), 2);
+        assert_eq!(counter.describe(), "1 of 3 intervals shown");
+    }
+}
@@ -0,0 +1,171 @@
+//! `--readahead`: how well reads are being batched. Each READ is compared
+//! with the mount's rsize, and readahead batches (VFSReadPages) with the
+//! READs they produced, so workloads doing tiny scattered reads stand out
+//! from streaming ones.
+
+use crate::options::MountOptions;
+use crate::types::{DeltaStats, NFSEvents};
+use clap::Args;
+use std::io::{self, Write};
+
+/// Below this share of rsize per READ, reads are called small.
+pub const SMALL_READ_PCT: f64 = 25.0;
+
+/// Fewer READs than this in an interval are too few to judge.
+pub const MIN_READS: i64 = 10;
+
+#[derive(Args, Debug, Clone)]
+pub struct ReadaheadArgs {
+    /// Show readahead efficiency: bytes per READ against rsize
+    #[arg(long = "readahead")]
+    pub readahead: bool,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct ReadaheadRow {
+    pub mount_point: String,
+    pub reads_per_sec: f64,
+    pub kb_per_read: f64,
+    pub rsize_kb: Option<f64>,
+    /// READs issued per readahead batch; `None` without batches.
+    pub reads_per_batch: Option<f64>,
+    /// Share of page-cache fills that came through readahead batches
+    /// rather than single-page reads.
+    pub batched_pct: Option<f64>,
+    read_ops: i64,
+}
+
+impl ReadaheadRow {
+    pub fn new(
+        mount_point: &str,
+        read: &DeltaStats,
+        options: &MountOptions,
+        before: &NFSEvents,
+        after: &NFSEvents,
+    ) -> Self {
+        let batches = (after.vfs_read_pages - before.vfs_read_pages).max(0);
+        let single = (after.vfs_read_page - before.vfs_read_page).max(0);
+        Self {
+            mount_point: mount_point.to_string(),
+            reads_per_sec: read.iops,
+            kb_per_read: read.kb_per_op,
+            rsize_kb: options.get_u64("rsize").map(|b| b as f64 / 1024.0),
+            reads_per_batch: (batches > 0).then(|| read.delta_ops as f64 / batches as f64),
+            batched_pct: (batches + single > 0)
+                .then(|| batches as f64 * 100.0 / (batches + single) as f64),
+            read_ops: read.delta_ops,
+        }
+    }
+
+    /// Average READ size as a share of rsize: the readahead efficiency.
+    pub fn efficiency_pct(&self) -> Option<f64> {
+        self.rsize_kb
+            .filter(|&r| r > 0.0 && self.read_ops > 0)
+            .map(|r| (self.kb_per_read / r * 100.0).min(100.0))
+    }
+
+    /// Enough READs to judge, and each well short of rsize.
+    pub fn small_reads(&self) -> bool {
+        self.read_ops >= MIN_READS && self.efficiency_pct().is_some_and(|e| e < SMALL_READ_PCT)
+    }
+}
+
+fn opt(value: Option<f64>, precision: usize) -> String {
+    value.map_or_else(|| "-".to_string(), |v| format!("{:.*}", precision, v))
+}
+
+fn percent(value: Option<f64>) -> String {
+    value.map_or_else(|| "-".to_string(), |v| format!("{:.1}%", v))
+}
+
+pub fn display_readahead<W: Write>(writer: &mut W, rows: &[ReadaheadRow]) -> io::Result<()> {
+    if rows.is_empty() {
+        return Ok(());
+    }
+    writeln!(
+        writer,
+        "{:<24} {:>10} {:>10} {:>10} {:>8} {:>10} {:>9}",
+        "MOUNT", "READS/s", "KB/READ", "RSIZE(KB)", "RA EFF", "READS/RA", "BATCHED"
+    )?;
+    writeln!(writer, "{}", "-".repeat(87))?;
+    for row in rows {
+        write!(
+            writer,
+            "{:<24} {:>10.1} {:>10.2} {:>10} {:>8} {:>10} {:>9}",
+            row.mount_point,
+            row.reads_per_sec,
+            row.kb_per_read,
+            opt(row.rsize_kb, 0),
+            percent(row.efficiency_pct()),
+            opt(row.reads_per_batch, 2),
+            percent(row.batched_pct),
+        )?;
+        if row.small_reads() {
+            write!(writer, "  small scattered reads")?;
+        }
+        writeln!(writer)?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    fn events(single: i64, batches: i64) -> NFSEvents {
+        NFSEvents {
+            vfs_read_page: single,
+            vfs_read_pages: batches,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_streaming_and_scattered() {
+        let opts = MountOptions::parse("rw,vers=4.2,rsize=1048576");
+
+        let mut streaming = stat("READ", 100, 1.0);
+        streaming.kb_per_op = 1024.0;
+        let row = ReadaheadRow::new("/mnt/a", &streaming, &opts, &events(0, 0), &events(0, 25));
+        assert_eq!(row.efficiency_pct(), Some(100.0));
+        assert_eq!(row.reads_per_batch, Some(4.0));
+        assert_eq!(row.batched_pct, Some(100.0));
+        assert!(!row.small_reads());
+
+        // stat() reads are 4 KB: 0.4% of a 1 MB rsize.
+        let scattered = stat("READ", 100, 1.0);
+        let row = ReadaheadRow::new(
+            "/mnt/b",
+            &scattered,
+            &opts,
+            &events(0, 0),
+            &events(300, 100),
+        );
+        assert!((row.efficiency_pct().unwrap() - 0.390625).abs() < 1e-9);
+        assert_eq!(row.batched_pct, Some(25.0));
+        assert!(row.small_reads());
+
+        let few = stat("READ", 5, 1.0);
+        let row = ReadaheadRow::new("/mnt/c", &few, &opts, &events(0, 0), &events(0, 0));
+        assert!(!row.small_reads());
+        let none = ReadaheadRow::new(
+            "/mnt/d",
+            &few,
+            &MountOptions::default(),
+            &events(0, 0),
+            &events(0, 0),
+        );
+        assert_eq!(none.efficiency_pct(), None);
+
+        let mut out = Vec::new();
+        display_readahead(&mut out, &[row, none]).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert!(lines[2].starts_with("/mnt/c"));
+        assert!(lines[2].contains("0.4%"));
+        assert!(!lines[2].contains("small"));
+        assert!(lines[3].split_whitespace().filter(|f| *f == "-").count() >= 3);
+    }
+}
@@ -0,0 +1,150 @@
+//! Capture files of raw mountstats snapshots (`--record`), for replaying
+//! an incident later with different filters, columns or output formats.
+//!
+//! A capture is a header line followed by length-prefixed snapshots:
+//!
+//! ```text
+//! nfs-gaze-record 1
+//! snapshot 2024-03-01T00:00:00.000Z 5120
+//! <5120 bytes of /proc/self/mountstats>
+//! ```
+//!
+//! Snapshots are stored verbatim so a capture can be re-parsed by newer
+//! versions that understand more of the file.
+
+use chrono::{DateTime, SecondsFormat, Utc};
+use clap::Args;
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, Write};
+
+const MAGIC: &str = "nfs-gaze-record 1";
+
+#[derive(Args, Debug, Clone)]
+pub struct RecordArgs {
+    /// Append a timestamped raw mountstats snapshot to FILE every interval
+    #[arg(long = "record", value_name = "FILE")]
+    pub record: Option<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Snapshot {
+    pub timestamp: DateTime<Utc>,
+    pub contents: String,
+}
+
+pub struct Recorder {
+    file: File,
+}
+
+impl Recorder {
+    /// Open `path` for appending, writing the header if the file is new.
+    pub fn open(path: &str) -> io::Result<Self> {
+        let mut file = OpenOptions::new().create(true).append(true).open(path)?;
+        if file.metadata()?.len() == 0 {
+            writeln!(file, "{}", MAGIC)?;
+        }
+        Ok(Self { file })
+    }
+
+    /// Append one snapshot. Each snapshot is written with a single call so
+    /// a capture cut short by a crash ends on a snapshot boundary or is
+    /// detectably truncated.
+    pub fn record(&mut self, timestamp: DateTime<Utc>, contents: &str) -> io::Result<()> {
+        let mut buf = format!(
+            "snapshot {} {}\n",
+            timestamp.to_rfc3339_opts(SecondsFormat::Millis, true),
+            contents.len()
+        );
+        buf.push_str(contents);
+        buf.push('\n');
+        self.file.write_all(buf.as_bytes())
+    }
+}
+
+fn invalid(msg: String) -> io::Error {
+    io::Error::new(io::ErrorKind::InvalidData, msg)
+}
+
+/// Parse a whole capture. A truncated final snapshot is dropped rather
+/// than treated as an error, since that is how an interrupted recording
+/// ends.
+pub fn parse_recording(data: &str) -> io::Result<Vec<Snapshot>> {
+    let rest = data
+        .strip_prefix(MAGIC)
+        .and_then(|r| r.strip_prefix('\n'))
+        .ok_or_else(|| invalid("not an nfs-gaze recording".to_string()))?;
+
+    let mut snapshots = Vec::new();
+    let mut rest = rest;
+    while !rest.is_empty() {
+        let (header, body) = match rest.split_once('\n') {
+            Some(split) => split,
+            None => break,
+        };
+        let mut fields = header.split(' ');
+        let (Some("snapshot"), Some(ts), Some(len), None) =
+            (fields.next(), fields.next(), fields.next(), fields.next())
+        else {
+            return Err(invalid(format!("bad snapshot header '{}'", header)));
+        };
+        let timestamp = DateTime::parse_from_rfc3339(ts)
+            .map_err(|e| invalid(format!("bad snapshot timestamp '{}': {}", ts, e)))?
+            .with_timezone(&Utc);
+        let len: usize = len
+            .parse()
+            .map_err(|_| invalid(format!("bad snapshot length '{}'", len)))?;
+        if body.len() < len || !body.is_char_boundary(len) {
+            break;
+        }
+        snapshots.push(Snapshot {
+            timestamp,
+            contents: body[..len].to_string(),
+        });
+        rest = body[len..].strip_prefix('\n').unwrap_or(&body[len..]);
+    }
+    Ok(snapshots)
+}
+
+pub fn read_recording(path: &str) -> io::Result<Vec<Snapshot>> {
+    parse_recording(&fs::read_to_string(path)?)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::tests::MOUNTSTATS;
+    use chrono::TimeZone;
+
+    #[test]
+    fn test_record_round_trip() {
+        let dir = std::env::temp_dir().join(format!("nfs-gaze-record-{}", std::process::id()));
+        fs::create_dir_all(&dir).unwrap();
+        let path = dir.join("capture.rec");
+        let path = path.to_str().unwrap();
+        let _ = fs::remove_file(path);
+
+        let t0 = Utc.with_ymd_and_hms(2024, 3, 1, 0, 0, 0).unwrap();
+        let t1 = t0 + chrono::Duration::seconds(1);
+        Recorder::open(path)
+            .unwrap()
+            .record(t0, MOUNTSTATS)
+            .unwrap();
+        // Reopening appends without a second header.
+        Recorder::open(path).unwrap().record(t1, "").unwrap();
+
+        let snapshots = read_recording(path).unwrap();
+        assert_eq!(snapshots.len(), 2);
+        assert_eq!(snapshots[0].timestamp, t0);
+        assert_eq!(snapshots[0].contents, MOUNTSTATS);
+        assert_eq!(snapshots[1].timestamp, t1);
+        assert_eq!(snapshots[1].contents, "");
+
+        // A recording cut off mid-snapshot keeps the complete ones.
+        let data = fs::read_to_string(path).unwrap();
+        let cut = data.find("snapshot 2024-03-01T00:00:01").unwrap() - 10;
+        assert_eq!(parse_recording(&data[..cut]).unwrap().len(), 0);
+        assert!(parse_recording("garbage").is_err());
+
+        fs::remove_dir_all(&dir).unwrap();
+    }
+}
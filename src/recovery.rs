@@ -0,0 +1,261 @@
+//! NFSv4 client state recovery detection: server reboots, failovers,
+//! grace periods and OPEN/LOCK reclaim.
+//!
+//! Tracepoints give precise events. Without them, recovery still leaves a
+//! signature in the counters: a client only re-runs EXCHANGE_ID,
+//! CREATE_SESSION or RECLAIM_COMPLETE (SETCLIENTID on 4.0) after losing
+//! its state on the server.
+
+use crate::tracefs::{self, TraceRecord};
+use crate::types::NFSMount;
+use chrono::{DateTime, Utc};
+use clap::Args;
+use std::fmt;
+use std::io::{self, Write};
+
+/// Operations that only run when (re)establishing client state.
+const STATE_SETUP_OPS: &[&str] = &[
+    "EXCHANGE_ID",
+    "CREATE_SESSION",
+    "RECLAIM_COMPLETE",
+    "SETCLIENTID",
+    "SETCLIENTID_CONFIRM",
+];
+
+pub const RECOVERY_EVENTS: &[&str] = &[
+    "nfs4/nfs4_state_mgr",
+    "nfs4/nfs4_state_mgr_failed",
+    "nfs4/nfs4_open_reclaim",
+    "nfs4/nfs4_lock_reclaim",
+    "nfs4/nfs4_xdr_status",
+];
+
+#[derive(Args, Debug, Clone)]
+pub struct RecoveryArgs {
+    /// Report NFSv4 state recovery events (server reboots, grace periods, reclaims)
+    #[arg(long = "recovery-events")]
+    pub recovery_events: bool,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum RecoveryKind {
+    /// Client state re-established; server reboot or failover likely.
+    StateReestablished,
+    /// State manager reclaiming after a server reboot.
+    RebootRecovery,
+    /// State could not be reclaimed within grace.
+    NoGraceRecovery,
+    OpenReclaim,
+    LockReclaim,
+    /// Server answered NFS4ERR_GRACE.
+    GracePeriod,
+    /// Lease expired before it could be renewed.
+    LeaseExpired,
+    RecoveryFailed,
+}
+
+impl fmt::Display for RecoveryKind {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(match self {
+            RecoveryKind::StateReestablished => "STATE-REESTABLISHED",
+            RecoveryKind::RebootRecovery => "REBOOT-RECOVERY",
+            RecoveryKind::NoGraceRecovery => "NOGRACE-RECOVERY",
+            RecoveryKind::OpenReclaim => "OPEN-RECLAIM",
+            RecoveryKind::LockReclaim => "LOCK-RECLAIM",
+            RecoveryKind::GracePeriod => "GRACE",
+            RecoveryKind::LeaseExpired => "LEASE-EXPIRED",
+            RecoveryKind::RecoveryFailed => "RECOVERY-FAILED",
+        })
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct RecoveryEvent {
+    pub timestamp: DateTime<Utc>,
+    /// Mount point, server hostname, or empty when the source is unknown.
+    pub source: String,
+    pub kind: RecoveryKind,
+    pub detail: String,
+}
+
+/// Look for recovery signatures between two snapshots of one mount.
+pub fn detect_from_counters(
+    before: &NFSMount,
+    after: &NFSMount,
+    timestamp: DateTime<Utc>,
+) -> Vec<RecoveryEvent> {
+    let mut ops: Vec<String> = STATE_SETUP_OPS
+        .iter()
+        .filter_map(|name| {
+            let delta = after.operations.get(*name)?.ops - before.operations.get(*name)?.ops;
+            (delta > 0).then(|| format!("{} x{}", name, delta))
+        })
+        .collect();
+    if ops.is_empty() {
+        return Vec::new();
+    }
+    ops.sort();
+    vec![RecoveryEvent {
+        timestamp,
+        source: after.mount_point.clone(),
+        kind: RecoveryKind::StateReestablished,
+        detail: format!("server reboot or failover suspected ({})", ops.join(", ")),
+    }]
+}
+
+/// Compare `lease_expired=` from the nfsv4: line of two samples.
+pub fn detect_lease_expiry(
+    mount_point: &str,
+    before: Option<&str>,
+    after: Option<&str>,
+    timestamp: DateTime<Utc>,
+) -> Option<RecoveryEvent> {
+    let expired = |line: Option<&str>| -> Option<u64> {
+        line?
+            .split(',')
+            .find_map(|f| f.trim().strip_prefix("lease_expired="))?
+            .parse()
+            .ok()
+    };
+    let (prev, cur) = (expired(before)?, expired(after)?);
+    (cur > prev).then(|| RecoveryEvent {
+        timestamp,
+        source: mount_point.to_string(),
+        kind: RecoveryKind::LeaseExpired,
+        detail: format!("lease expired {} time(s)", cur - prev),
+    })
+}
+
+/// Interpret one tracepoint record.
+pub fn detect_from_trace(record: &TraceRecord, timestamp: DateTime<Utc>) -> Option<RecoveryEvent> {
+    let payload = &record.payload;
+    let source = tracefs::field(payload, "hostname")
+        .unwrap_or("")
+        .to_string();
+    let event = |kind, detail: String| {
+        Some(RecoveryEvent {
+            timestamp,
+            source: source.clone(),
+            kind,
+            detail,
+        })
+    };
+
+    match record.event.as_str() {
+        "nfs4_state_mgr" => {
+            let state = tracefs::field(payload, "state").unwrap_or("");
+            if state.contains("RECLAIM_NOGRACE") {
+                event(RecoveryKind::NoGraceRecovery, format!("state={}", state))
+            } else if state.contains("RECLAIM_REBOOT") {
+                event(RecoveryKind::RebootRecovery, format!("state={}", state))
+            } else {
+                None
+            }
+        }
+        "nfs4_state_mgr_failed" => event(RecoveryKind::RecoveryFailed, payload.clone()),
+        "nfs4_open_reclaim" => event(RecoveryKind::OpenReclaim, payload.clone()),
+        "nfs4_lock_reclaim" => event(RecoveryKind::LockReclaim, payload.clone()),
+        "nfs4_xdr_status" if payload.contains("(GRACE)") || payload.contains("error=-10013") => {
+            event(
+                RecoveryKind::GracePeriod,
+                "server in grace period".to_string(),
+            )
+        }
+        _ => None,
+    }
+}
+
+pub fn display_recovery_events<W: Write>(
+    writer: &mut W,
+    events: &[RecoveryEvent],
+) -> io::Result<()> {
+    for event in events {
+        writeln!(
+            writer,
+            "[{}] RECOVERY {} {}: {}",
+            event.timestamp.format("%Y-%m-%d %H:%M:%S UTC"),
+            event.kind,
+            if event.source.is_empty() {
+                "-"
+            } else {
+                &event.source
+            },
+            event.detail
+        )?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil;
+    use crate::tracefs::parse_record;
+    use crate::types::NFSOperation;
+    use chrono::TimeZone;
+
+    fn mount(exchange_id: i64) -> NFSMount {
+        let op = |name: &str, ops| NFSOperation {
+            ops,
+            ..testutil::op(name)
+        };
+        testutil::with_ops(
+            testutil::mount_of("filer", "/vol", "/mnt/vol"),
+            [op("EXCHANGE_ID", exchange_id), op("READ", 10)],
+        )
+    }
+
+    #[test]
+    fn test_counter_signature() {
+        let ts = Utc.with_ymd_and_hms(2024, 1, 1, 12, 0, 0).unwrap();
+        assert!(detect_from_counters(&mount(1), &mount(1), ts).is_empty());
+
+        let events = detect_from_counters(&mount(1), &mount(2), ts);
+        assert_eq!(events.len(), 1);
+        assert_eq!(events[0].kind, RecoveryKind::StateReestablished);
+        assert!(events[0].detail.contains("EXCHANGE_ID x1"));
+
+        let mut out = Vec::new();
+        display_recovery_events(&mut out, &events).unwrap();
+        assert!(String::from_utf8(out)
+            .unwrap()
+            .starts_with("[2024-01-01 12:00:00 UTC] RECOVERY STATE-REESTABLISHED /mnt/vol"));
+    }
+
+    #[test]
+    fn test_lease_expiry() {
+        let ts = Utc::now();
+        let before = "bm0=0x1,sessions,lease_time=90,lease_expired=0";
+        let after = "bm0=0x1,sessions,lease_time=90,lease_expired=2";
+        let event = detect_lease_expiry("/mnt", Some(before), Some(after), ts).unwrap();
+        assert_eq!(event.kind, RecoveryKind::LeaseExpired);
+        assert!(detect_lease_expiry("/mnt", Some(after), Some(after), ts).is_none());
+        assert!(detect_lease_expiry("/mnt", None, Some(after), ts).is_none());
+    }
+
+    #[test]
+    fn test_trace_detection() {
+        let ts = Utc::now();
+        let reboot = parse_record(
+            "kworker-5 [000] ..... 9.0: nfs4_state_mgr: hostname=filer clp state=MANAGER_RUNNING|RECLAIM_REBOOT",
+        )
+        .unwrap();
+        let event = detect_from_trace(&reboot, ts).unwrap();
+        assert_eq!(event.kind, RecoveryKind::RebootRecovery);
+        assert_eq!(event.source, "filer");
+
+        let grace = parse_record(
+            "cp-7 [001] ..... 9.5: nfs4_xdr_status: error=-10013 (GRACE) operation=18",
+        )
+        .unwrap();
+        assert_eq!(
+            detect_from_trace(&grace, ts).unwrap().kind,
+            RecoveryKind::GracePeriod
+        );
+
+        let idle =
+            parse_record("kworker-5 [000] ..... 9.0: nfs4_state_mgr: hostname=filer state=0x0")
+                .unwrap();
+        assert!(detect_from_trace(&idle, ts).is_none());
+    }
+}
@@ -0,0 +1,247 @@
+//! `--redact`: replace server names, export paths and mount points with
+//! stable pseudonyms so captures can be shared without leaking internal
+//! naming.
+//!
+//! Mountstats text is redacted as soon as it is read, so every output,
+//! report and raw capture sees the same pseudonyms. Path components are
+//! hashed one at a time, keeping nesting visible (`/a/b` stays under
+//! `/a`). Client and server addresses in mount options are replaced too.
+//! Only the live monitor redacts; the other modes refuse `--redact`.
+
+use crate::labels::Labels;
+use crate::mountinfo::unescape;
+use crate::types::NFSMount;
+use clap::Args;
+use sha2::{Digest, Sha256};
+use std::fs::File;
+use std::io::{self, Read};
+
+/// Labels that carry host identity.
+const IDENTIFYING_LABELS: &[&str] = &["host", "cloud_instance_id"];
+/// Mount options whose values are IP addresses.
+const ADDRESS_OPTIONS: &[&str] = &["addr", "clientaddr", "mountaddr"];
+
+#[derive(Args, Debug, Clone)]
+pub struct RedactArgs {
+    /// Anonymize server names, exports and mount points in the monitor's output
+    #[arg(long = "redact")]
+    pub redact: bool,
+
+    /// Key for --redact; the same key gives the same pseudonyms across runs
+    #[arg(long = "redact-key", value_name = "KEY", env = "NFS_GAZE_REDACT_KEY")]
+    pub redact_key: Option<String>,
+}
+
+pub struct Redactor {
+    key: Vec<u8>,
+}
+
+impl Redactor {
+    pub fn new(key: &[u8]) -> Self {
+        Self { key: key.to_vec() }
+    }
+
+    /// A redactor keyed by `--redact-key`, or by a random per-run key.
+    pub fn from_args(args: &RedactArgs) -> io::Result<Option<Self>> {
+        if !args.redact {
+            return Ok(None);
+        }
+        let key = match &args.redact_key {
+            Some(key) => key.as_bytes().to_vec(),
+            None => {
+                let mut key = vec![0u8; 16];
+                File::open("/dev/urandom")?.read_exact(&mut key)?;
+                key
+            }
+        };
+        Ok(Some(Self::new(&key)))
+    }
+
+    fn tag(&self, kind: &str, value: &str) -> String {
+        let mut hasher = Sha256::new();
+        hasher.update(&self.key);
+        hasher.update(kind.as_bytes());
+        hasher.update([0]);
+        hasher.update(value.as_bytes());
+        hasher.finalize()[..4]
+            .iter()
+            .map(|b| format!("{:02x}", b))
+            .collect()
+    }
+
+    pub fn server(&self, server: &str) -> String {
+        if server.is_empty() {
+            return String::new();
+        }
+        format!("server-{}", self.tag("server", server))
+    }
+
+    /// Hash each component of `path`, keeping the leading slash and depth.
+    pub fn path(&self, path: &str) -> String {
+        let redacted: Vec<String> = path
+            .split('/')
+            .map(|part| {
+                if part.is_empty() {
+                    String::new()
+                } else {
+                    format!("p-{}", self.tag("path", part))
+                }
+            })
+            .collect();
+        let joined = redacted.join("/");
+        if joined.is_empty() && path.starts_with('/') {
+            "/".to_string()
+        } else {
+            joined
+        }
+    }
+
+    pub fn mount(&self, mount: &NFSMount) -> NFSMount {
+        let server = self.server(&mount.server);
+        let export = self.path(&mount.export);
+        NFSMount {
+            device: format!("{}:{}", server, export),
+            mount_point: self.path(&mount.mount_point),
+            server,
+            export,
+            ..mount.clone()
+        }
+    }
+
+    /// `server:export` as [`Redactor::mount`] would show it.
+    fn device(&self, device: &str) -> String {
+        let (server, export) = match device.find(":/") {
+            Some(i) => (&device[..i], &device[i + 1..]),
+            None => (device, ""),
+        };
+        format!("{}:{}", self.server(server), self.path(export))
+    }
+
+    /// A mount option string with address values replaced.
+    pub fn options(&self, options: &str) -> String {
+        options
+            .split(',')
+            .map(|option| match option.split_once('=') {
+                Some((key, value)) if ADDRESS_OPTIONS.contains(&key) => {
+                    format!("{}=addr-{}", key, self.tag("addr", value))
+                }
+                _ => option.to_string(),
+            })
+            .collect::<Vec<_>>()
+            .join(",")
+    }
+
+    /// Redact raw mountstats text: every mount point, NFS devices, and
+    /// the addresses in `opts:` lines. Parsing the result gives the same
+    /// names as [`Redactor::mount`] on the original.
+    pub fn mountstats(&self, contents: &str) -> String {
+        let mut out = String::with_capacity(contents.len());
+        for line in contents.lines() {
+            out.push_str(&self.line(line));
+            out.push('\n');
+        }
+        out
+    }
+
+    fn line(&self, line: &str) -> String {
+        if let Some(rest) = line.strip_prefix("device ") {
+            let parts = rest
+                .split_once(" mounted on ")
+                .and_then(|(device, rest)| Some((device, rest.rsplit_once(" with fstype ")?)));
+            if let Some((device, (mount_point, fstype))) = parts {
+                let device = if fstype.starts_with("nfs") {
+                    self.device(device)
+                } else {
+                    device.to_string()
+                };
+                return format!(
+                    "device {} mounted on {} with fstype {}",
+                    device,
+                    self.path(&unescape(mount_point)),
+                    fstype
+                );
+            }
+        }
+        if let Some(options) = line.trim_start().strip_prefix("opts:") {
+            let indent = &line[..line.len() - line.trim_start().len()];
+            return format!("{}opts:\t{}", indent, self.options(options.trim()));
+        }
+        line.to_string()
+    }
+
+    pub fn mounts(&self, mounts: Vec<NFSMount>) -> Vec<NFSMount> {
+        mounts.iter().map(|m| self.mount(m)).collect()
+    }
+
+    /// Replace identifying host labels with pseudonyms.
+    pub fn labels(&self, labels: &mut Labels) {
+        for key in IDENTIFYING_LABELS {
+            if let Some(value) = labels.get(key).map(str::to_string) {
+                labels.insert(key, &format!("host-{}", self.tag(key, &value)));
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil;
+
+    fn mount(server: &str, export: &str, mount_point: &str) -> NFSMount {
+        NFSMount {
+            age: 7,
+            ..testutil::mount_of(server, export, mount_point)
+        }
+    }
+
+    #[test]
+    fn test_consistent_pseudonyms() {
+        let redactor = Redactor::new(b"key");
+        let a = redactor.mount(&mount("filer01.corp", "/vol/projects", "/mnt/projects"));
+        let b = redactor.mount(&mount("filer01.corp", "/vol/home", "/mnt/projects/sub"));
+
+        assert_eq!(a.server, b.server);
+        assert!(a.server.starts_with("server-"));
+        assert!(!a.device.contains("filer01"));
+        assert_eq!(a.device, format!("{}:{}", a.server, a.export));
+        assert!(b.mount_point.starts_with(&a.mount_point));
+        assert_eq!(a.mount_point.matches('/').count(), 2);
+        assert_eq!(a.age, 7);
+
+        let other = Redactor::new(b"other");
+        assert_ne!(other.server("filer01.corp"), a.server);
+        assert_eq!(redactor.path("/"), "/");
+    }
+
+    #[test]
+    fn test_mountstats() {
+        let redactor = Redactor::new(b"key");
+        let raw = "device proc mounted on /proc with fstype proc\n\
+device filer01.corp:/vol/projects mounted on /mnt/with\\040space with fstype nfs4 statvers=1.1\n\
+\topts:\trw,vers=4.2,clientaddr=10.0.0.2,local_lock=none\n\
+\tage:\t7\n";
+        let text = redactor.mountstats(raw);
+        assert!(!text.contains("filer01"));
+        assert!(!text.contains("10.0.0.2"));
+        assert!(text.contains("\topts:\trw,vers=4.2,clientaddr=addr-"));
+        assert!(text.contains(",local_lock=none\n\tage:\t7\n"));
+        assert!(text.starts_with("device proc mounted on /p-"));
+
+        let mounts = crate::parser::parse_mountstats_str(&text).unwrap();
+        let expected = redactor.mount(&mount("filer01.corp", "/vol/projects", "/mnt/with space"));
+        assert_eq!(mounts[0].device, expected.device);
+        assert_eq!(mounts[0].mount_point, expected.mount_point);
+    }
+
+    #[test]
+    fn test_labels() {
+        let redactor = Redactor::new(b"key");
+        let mut labels = Labels::new();
+        labels.add("host=node042").unwrap();
+        labels.add("env=prod").unwrap();
+        redactor.labels(&mut labels);
+        assert!(labels.get("host").unwrap().starts_with("host-"));
+        assert_eq!(labels.get("env"), Some("prod"));
+    }
+}
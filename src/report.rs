@@ -0,0 +1,442 @@
+//! End-of-run session report: per-mount summary plus advisor findings, as
+//! plain text or a self-contained HTML page.
+
+use crate::advisor::{analyze_checks, Check, Finding};
+use crate::correlation::{correlations, display_correlations};
+use crate::histogram::display_histogram;
+use crate::quantile::REPORTED;
+use crate::session::{MountSession, Session};
+use clap::{Args, ValueEnum};
+use std::fs::File;
+use std::io::{self, BufWriter, Write};
+use std::path::Path;
+
+#[derive(Args, Debug, Clone)]
+pub struct ReportArgs {
+    /// Write a session report to FILE on exit
+    #[arg(long = "report", value_name = "FILE")]
+    pub report: Option<String>,
+
+    /// Report format (default: html for .html/.htm files, text otherwise)
+    #[arg(long = "report-format", value_enum)]
+    pub report_format: Option<ReportFormat>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum ReportFormat {
+    Text,
+    Html,
+}
+
+impl ReportFormat {
+    pub fn for_path(path: &str) -> Self {
+        match Path::new(path).extension().and_then(|e| e.to_str()) {
+            Some(ext) if ext.eq_ignore_ascii_case("html") || ext.eq_ignore_ascii_case("htm") => {
+                ReportFormat::Html
+            }
+            _ => ReportFormat::Text,
+        }
+    }
+}
+
+/// Percentiles of each operation's per-interval average RTT and execute
+/// time, which show tail behaviour the whole-run averages smooth over.
+fn display_percentiles<W: Write>(writer: &mut W, mount: &MountSession) -> io::Result<()> {
+    let ops: Vec<_> = mount
+        .ops
+        .iter()
+        .filter(|(_, op)| !op.rtt_quantiles.is_empty())
+        .collect();
+    if ops.is_empty() {
+        return Ok(());
+    }
+    writeln!(writer, "  Per-interval latency percentiles (ms)")?;
+    writeln!(
+        writer,
+        "  {:<14} {:>8} {:>8} {:>8} {:>8} {:>8} {:>8}",
+        "OP", "RTT P50", "RTT P95", "RTT P99", "EXE P50", "EXE P95", "EXE P99"
+    )?;
+    for (name, op) in ops {
+        write!(writer, "  {:<14}", name)?;
+        for quantiles in [&op.rtt_quantiles, &op.exec_quantiles] {
+            for q in REPORTED {
+                write!(writer, " {:>8.2}", quantiles.quantile(q))?;
+            }
+        }
+        writeln!(writer)?;
+    }
+    writeln!(writer)
+}
+
+/// Lowest and highest per-interval figures, so a spike seen mid-run
+/// survives into the report even when the overall averages look fine.
+fn display_ranges<W: Write>(writer: &mut W, mount: &MountSession) -> io::Result<()> {
+    let ops: Vec<_> = mount.ops.iter().filter(|(_, op)| op.ops > 0).collect();
+    if ops.is_empty() {
+        return Ok(());
+    }
+    writeln!(writer, "  Per-interval minimum / maximum")?;
+    writeln!(
+        writer,
+        "  {:<14} {:>10} {:>10} {:>8} {:>8} {:>8} {:>8}",
+        "OP", "MIN IOPS", "MAX IOPS", "MIN RTT", "MAX RTT", "MIN EXE", "MAX EXE"
+    )?;
+    for (name, op) in ops {
+        writeln!(
+            writer,
+            "  {:<14} {:>10.1} {:>10.1} {:>8.2} {:>8.2} {:>8.2} {:>8.2}",
+            name,
+            op.min_iops,
+            op.peak_iops,
+            op.min_avg_rtt,
+            op.peak_avg_rtt,
+            op.min_avg_exec,
+            op.peak_avg_exec
+        )?;
+    }
+    writeln!(writer)
+}
+
+pub fn write_text<W: Write>(
+    writer: &mut W,
+    session: &Session,
+    findings: &[Finding],
+) -> io::Result<()> {
+    writeln!(writer, "NFS session report")?;
+    writeln!(
+        writer,
+        "Period: {} - {} ({:.0}s)",
+        session.started.format("%Y-%m-%d %H:%M:%S UTC"),
+        session.ended.format("%Y-%m-%d %H:%M:%S UTC"),
+        session.duration_secs()
+    )?;
+    if !session.labels.is_empty() {
+        writeln!(writer, "Labels: {}", session.labels)?;
+    }
+    writeln!(writer)?;
+
+    for mount in session.mounts.values() {
+        writeln!(
+            writer,
+            "{}: {} ops, avg {:.1} ops/s, peak {:.1} ops/s, {} intervals",
+            mount.mount_point,
+            mount.total_ops(),
+            mount.avg_iops(),
+            mount.peak_iops,
+            mount.intervals
+        )?;
+        writeln!(
+            writer,
+            "  {:<14} {:>10} {:>10} {:>10} {:>10} {:>8} {:>8}",
+            "OP", "OPS", "KB/OP", "RTT(ms)", "PEAK RTT", "ERRORS", "RETRANS"
+        )?;
+        for (name, op) in &mount.ops {
+            if op.ops == 0 && op.retrans == 0 {
+                continue;
+            }
+            writeln!(
+                writer,
+                "  {:<14} {:>10} {:>10.1} {:>10.2} {:>10.2} {:>8} {:>8}",
+                name,
+                op.ops,
+                op.kb_per_op(),
+                op.avg_rtt(),
+                op.peak_avg_rtt,
+                op.errors,
+                op.retrans
+            )?;
+        }
+        writeln!(writer)?;
+        display_percentiles(writer, mount)?;
+        display_ranges(writer, mount)?;
+        display_histogram(writer, "  IOPS per interval", &mount.series(|s| s.iops))?;
+        display_histogram(
+            writer,
+            "  Worst RTT (ms) per interval",
+            &mount.series(|s| s.worst_rtt),
+        )?;
+        writeln!(writer)?;
+    }
+
+    writeln!(writer, "Findings")?;
+    writeln!(writer, "{}", "-".repeat(8))?;
+    if findings.is_empty() {
+        writeln!(writer, "No issues detected.")?;
+    }
+    for finding in findings {
+        writeln!(
+            writer,
+            "[{}] {}: {}",
+            finding.severity, finding.mount_point, finding.title
+        )?;
+        writeln!(writer, "    {}", finding.detail)?;
+        writeln!(writer, "    Recommendation: {}", finding.recommendation)?;
+    }
+
+    let mut related = Vec::new();
+    for mount in session.mounts.values() {
+        display_correlations(&mut related, &mount.mount_point, &correlations(mount))?;
+    }
+    if !related.is_empty() {
+        writeln!(writer)?;
+        writeln!(writer, "Correlations")?;
+        writeln!(writer, "{}", "-".repeat(12))?;
+        writer.write_all(&related)?;
+    }
+    Ok(())
+}
+
+fn escape(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+const STYLE: &str = "body{font-family:sans-serif;margin:2em}\
+table{border-collapse:collapse;margin-bottom:1.5em}\
+td,th{border:1px solid #ccc;padding:4px 8px;text-align:right}\
+td:first-child,th:first-child{text-align:left}\
+.critical{color:#b00}.warning{color:#b60}.info{color:#06b}";
+
+pub fn write_html<W: Write>(
+    writer: &mut W,
+    session: &Session,
+    findings: &[Finding],
+) -> io::Result<()> {
+    writeln!(writer, "<!DOCTYPE html>")?;
+    writeln!(
+        writer,
+        "<html><head><meta charset=\"utf-8\"><title>NFS session report</title><style>{}</style></head><body>",
+        STYLE
+    )?;
+    writeln!(writer, "<h1>NFS session report</h1>")?;
+    writeln!(
+        writer,
+        "<p>{} &ndash; {} ({:.0}s)</p>",
+        session.started.format("%Y-%m-%d %H:%M:%S UTC"),
+        session.ended.format("%Y-%m-%d %H:%M:%S UTC"),
+        session.duration_secs()
+    )?;
+    if !session.labels.is_empty() {
+        writeln!(
+            writer,
+            "<p>Labels: {}</p>",
+            escape(&session.labels.to_string())
+        )?;
+    }
+
+    writeln!(writer, "<h2>Findings</h2>")?;
+    if findings.is_empty() {
+        writeln!(writer, "<p>No issues detected.</p>")?;
+    } else {
+        writeln!(writer, "<ul>")?;
+        for finding in findings {
+            writeln!(
+                writer,
+                "<li class=\"{}\"><b>{}</b> {}: {}<br>{}<br><i>{}</i></li>",
+                finding.severity.to_string().to_lowercase(),
+                finding.severity,
+                escape(&finding.mount_point),
+                escape(&finding.title),
+                escape(&finding.detail),
+                escape(finding.recommendation)
+            )?;
+        }
+        writeln!(writer, "</ul>")?;
+    }
+
+    let mut related = Vec::new();
+    for mount in session.mounts.values() {
+        display_correlations(&mut related, &mount.mount_point, &correlations(mount))?;
+    }
+    if !related.is_empty() {
+        writeln!(writer, "<h2>Correlations</h2>")?;
+        writeln!(
+            writer,
+            "<pre>{}</pre>",
+            escape(&String::from_utf8_lossy(&related))
+        )?;
+    }
+
+    for mount in session.mounts.values() {
+        writeln!(writer, "<h2>{}</h2>", escape(&mount.mount_point))?;
+        writeln!(
+            writer,
+            "<p>{} ops, avg {:.1} ops/s, peak {:.1} ops/s, {} intervals</p>",
+            mount.total_ops(),
+            mount.avg_iops(),
+            mount.peak_iops,
+            mount.intervals
+        )?;
+        writeln!(
+            writer,
+            "<table><tr><th>Op</th><th>Ops</th><th>KB/op</th><th>RTT (ms)</th><th>Peak RTT</th><th>Errors</th><th>Retrans</th></tr>"
+        )?;
+        for (name, op) in &mount.ops {
+            if op.ops == 0 && op.retrans == 0 {
+                continue;
+            }
+            writeln!(
+                writer,
+                "<tr><td>{}</td><td>{}</td><td>{:.1}</td><td>{:.2}</td><td>{:.2}</td><td>{}</td><td>{}</td></tr>",
+                escape(name),
+                op.ops,
+                op.kb_per_op(),
+                op.avg_rtt(),
+                op.peak_avg_rtt,
+                op.errors,
+                op.retrans
+            )?;
+        }
+        writeln!(writer, "</table>")?;
+
+        writeln!(
+            writer,
+            "<table><tr><th>Op</th><th>RTT p50</th><th>RTT p95</th><th>RTT p99</th><th>Exec p50</th><th>Exec p95</th><th>Exec p99</th></tr>"
+        )?;
+        for (name, op) in &mount.ops {
+            if op.rtt_quantiles.is_empty() {
+                continue;
+            }
+            write!(writer, "<tr><td>{}</td>", escape(name))?;
+            for quantiles in [&op.rtt_quantiles, &op.exec_quantiles] {
+                for q in REPORTED {
+                    write!(writer, "<td>{:.2}</td>", quantiles.quantile(q))?;
+                }
+            }
+            writeln!(writer, "</tr>")?;
+        }
+        writeln!(writer, "</table>")?;
+
+        writeln!(
+            writer,
+            "<table><tr><th>Op</th><th>Min IOPS</th><th>Max IOPS</th><th>Min RTT</th><th>Max RTT</th><th>Min exec</th><th>Max exec</th></tr>"
+        )?;
+        for (name, op) in &mount.ops {
+            if op.ops == 0 {
+                continue;
+            }
+            writeln!(
+                writer,
+                "<tr><td>{}</td><td>{:.1}</td><td>{:.1}</td><td>{:.2}</td><td>{:.2}</td><td>{:.2}</td><td>{:.2}</td></tr>",
+                escape(name),
+                op.min_iops,
+                op.peak_iops,
+                op.min_avg_rtt,
+                op.peak_avg_rtt,
+                op.min_avg_exec,
+                op.peak_avg_exec
+            )?;
+        }
+        writeln!(writer, "</table>")?;
+
+        let mut histograms = Vec::new();
+        display_histogram(
+            &mut histograms,
+            "IOPS per interval",
+            &mount.series(|s| s.iops),
+        )?;
+        display_histogram(
+            &mut histograms,
+            "Worst RTT (ms) per interval",
+            &mount.series(|s| s.worst_rtt),
+        )?;
+        writeln!(
+            writer,
+            "<pre>{}</pre>",
+            escape(&String::from_utf8_lossy(&histograms))
+        )?;
+    }
+    writeln!(writer, "</body></html>")?;
+    Ok(())
+}
+
+/// Run `checks` over the session and write the report requested by
+/// `args`, if any.
+pub fn write_report(args: &ReportArgs, session: &Session, checks: &[Check]) -> io::Result<()> {
+    let Some(path) = &args.report else {
+        return Ok(());
+    };
+    let findings = analyze_checks(session, checks);
+    let mut writer = BufWriter::new(File::create(path)?);
+    match args
+        .report_format
+        .unwrap_or_else(|| ReportFormat::for_path(path))
+    {
+        ReportFormat::Text => write_text(&mut writer, session, &findings)?,
+        ReportFormat::Html => write_html(&mut writer, session, &findings)?,
+    }
+    writer.flush()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::advisor::{analyze, ALL_CHECKS};
+    use crate::aggregate::tests::stat;
+    use chrono::Utc;
+
+    fn session() -> Session {
+        let now = Utc::now();
+        let mut session = Session::new(now);
+        session.labels.add("env=prod").unwrap();
+        let mut read = stat("READ", 100, 2.0);
+        read.delta_retrans = 1;
+        session.record("/mnt/<odd>", now, 1.0, &[read]);
+        session
+    }
+
+    #[test]
+    fn test_format_for_path() {
+        assert_eq!(ReportFormat::for_path("out.HTML"), ReportFormat::Html);
+        assert_eq!(ReportFormat::for_path("out.txt"), ReportFormat::Text);
+        assert_eq!(ReportFormat::for_path("report"), ReportFormat::Text);
+    }
+
+    #[test]
+    fn test_text_report() {
+        let session = session();
+        let mut out = Vec::new();
+        write_text(&mut out, &session, &analyze(&session)).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.contains("Labels: env=prod"));
+        assert!(text.contains("/mnt/<odd>: 100 ops"));
+        assert!(text.contains("[WARNING] /mnt/<odd>: Retransmissions"));
+        assert!(text.contains("Recommendation:"));
+        assert!(text.contains("IOPS per interval (1 intervals)"));
+        assert!(text.contains("  READ               2.00     2.00     2.00"));
+        assert!(text.contains("  READ                100.0      100.0     2.00     2.00"));
+    }
+
+    #[test]
+    fn test_html_report_escapes() {
+        let session = session();
+        let mut out = Vec::new();
+        write_html(&mut out, &session, &analyze(&session)).unwrap();
+        let html = String::from_utf8(out).unwrap();
+        assert!(html.contains("<h2>/mnt/&lt;odd&gt;</h2>"));
+        assert!(!html.contains("<odd>"));
+        assert!(html.ends_with("</body></html>\n"));
+    }
+
+    #[test]
+    fn test_write_report_file() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("session.html");
+        let args = ReportArgs {
+            report: Some(path.to_string_lossy().into_owned()),
+            report_format: None,
+        };
+        write_report(&args, &session(), ALL_CHECKS).unwrap();
+        let contents = std::fs::read_to_string(path).unwrap();
+        assert!(contents.starts_with("<!DOCTYPE html>"));
+    }
+}
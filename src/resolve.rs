@@ -0,0 +1,266 @@
+//! Server name/address display with a non-blocking lookup cache.
+//!
+//! Lookups run on a background thread; the sampling loop only ever reads
+//! the cache, so a slow or dead DNS server cannot delay an interval.
+
+use clap::Args;
+use std::collections::{HashMap, HashSet};
+use std::ffi::CStr;
+use std::mem;
+use std::net::{IpAddr, SocketAddr, ToSocketAddrs};
+use std::sync::mpsc::{self, Sender};
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::{Duration, Instant};
+
+#[derive(Args, Debug, Clone)]
+pub struct ResolveArgs {
+    /// Show resolved IP addresses next to server hostnames
+    #[arg(long = "resolve")]
+    pub resolve: bool,
+
+    /// Show hostnames next to servers mounted by IP address
+    #[arg(long = "reverse")]
+    pub reverse: bool,
+
+    /// Seconds before a cached lookup is refreshed
+    #[arg(long = "dns-ttl", default_value = "300")]
+    pub dns_ttl: u64,
+}
+
+/// Split a mountstats device into server and export, handling bracketed
+/// IPv6 literals such as `[2001:db8::1]:/export`.
+pub fn split_device(device: &str) -> Option<(String, String)> {
+    if let Some(rest) = device.strip_prefix('[') {
+        let (addr, export) = rest.split_once("]:")?;
+        return Some((addr.to_string(), export.to_string()));
+    }
+    let idx = device.find(":/").or_else(|| device.rfind(':'))?;
+    Some((device[..idx].to_string(), device[idx + 1..].to_string()))
+}
+
+/// Strip IPv6 brackets so the server can be parsed as an address.
+pub fn normalize_server(server: &str) -> &str {
+    server
+        .strip_prefix('[')
+        .and_then(|s| s.strip_suffix(']'))
+        .unwrap_or(server)
+}
+
+fn forward_lookup(host: &str) -> Vec<String> {
+    let mut addrs: Vec<IpAddr> = (host, 0)
+        .to_socket_addrs()
+        .map(|it| it.map(|sa| sa.ip()).collect())
+        .unwrap_or_default();
+    addrs.sort();
+    addrs.dedup();
+    addrs.iter().map(IpAddr::to_string).collect()
+}
+
+fn reverse_lookup(ip: IpAddr) -> Vec<String> {
+    let addr = SocketAddr::new(ip, 0);
+    let mut host = [0 as libc::c_char; libc::NI_MAXHOST as usize];
+    // SAFETY: the sockaddr structs are fully initialised copies of `addr`,
+    // and `host` is a writable buffer of the advertised length.
+    let rc = unsafe {
+        match addr {
+            SocketAddr::V4(v4) => {
+                let mut sin: libc::sockaddr_in = mem::zeroed();
+                sin.sin_family = libc::AF_INET as libc::sa_family_t;
+                sin.sin_addr.s_addr = u32::from_ne_bytes(v4.ip().octets());
+                libc::getnameinfo(
+                    &sin as *const _ as *const libc::sockaddr,
+                    mem::size_of::<libc::sockaddr_in>() as libc::socklen_t,
+                    host.as_mut_ptr(),
+                    host.len() as libc::socklen_t,
+                    std::ptr::null_mut(),
+                    0,
+                    libc::NI_NAMEREQD,
+                )
+            }
+            SocketAddr::V6(v6) => {
+                let mut sin6: libc::sockaddr_in6 = mem::zeroed();
+                sin6.sin6_family = libc::AF_INET6 as libc::sa_family_t;
+                sin6.sin6_addr.s6_addr = v6.ip().octets();
+                libc::getnameinfo(
+                    &sin6 as *const _ as *const libc::sockaddr,
+                    mem::size_of::<libc::sockaddr_in6>() as libc::socklen_t,
+                    host.as_mut_ptr(),
+                    host.len() as libc::socklen_t,
+                    std::ptr::null_mut(),
+                    0,
+                    libc::NI_NAMEREQD,
+                )
+            }
+        }
+    };
+    if rc != 0 {
+        return Vec::new();
+    }
+    // SAFETY: getnameinfo NUL-terminates on success.
+    let name = unsafe { CStr::from_ptr(host.as_ptr()) };
+    vec![name.to_string_lossy().into_owned()]
+}
+
+/// Resolve `server` in whichever direction applies: hostnames to
+/// addresses, addresses to names.
+pub fn lookup(server: &str) -> Vec<String> {
+    match normalize_server(server).parse::<IpAddr>() {
+        Ok(ip) => reverse_lookup(ip),
+        Err(_) => forward_lookup(server),
+    }
+}
+
+struct Entry {
+    names: Vec<String>,
+    resolved_at: Instant,
+}
+
+/// Caching resolver backed by a single worker thread.
+pub struct Resolver {
+    cache: Arc<Mutex<HashMap<String, Entry>>>,
+    pending: Arc<Mutex<HashSet<String>>>,
+    queue: Sender<String>,
+    ttl: Duration,
+}
+
+impl Resolver {
+    pub fn new(ttl: Duration) -> Self {
+        Self::with_lookup(ttl, lookup)
+    }
+
+    /// Build a resolver around a custom lookup function.
+    pub fn with_lookup<F>(ttl: Duration, lookup: F) -> Self
+    where
+        F: Fn(&str) -> Vec<String> + Send + 'static,
+    {
+        let cache: Arc<Mutex<HashMap<String, Entry>>> = Arc::default();
+        let pending: Arc<Mutex<HashSet<String>>> = Arc::default();
+        let (queue, rx) = mpsc::channel::<String>();
+
+        let worker_cache = cache.clone();
+        let worker_pending = pending.clone();
+        thread::spawn(move || {
+            for server in rx {
+                let names = lookup(&server);
+                if let Ok(mut cache) = worker_cache.lock() {
+                    cache.insert(
+                        server.clone(),
+                        Entry {
+                            names,
+                            resolved_at: Instant::now(),
+                        },
+                    );
+                }
+                if let Ok(mut pending) = worker_pending.lock() {
+                    pending.remove(&server);
+                }
+            }
+        });
+
+        Self {
+            cache,
+            pending,
+            queue,
+            ttl,
+        }
+    }
+
+    /// Cached result for `server`, if any. Missing or stale entries are
+    /// queued for refresh; this never blocks on DNS.
+    pub fn get(&self, server: &str) -> Option<Vec<String>> {
+        let (cached, stale) = match self.cache.lock() {
+            Ok(cache) => match cache.get(server) {
+                Some(entry) => (
+                    Some(entry.names.clone()),
+                    entry.resolved_at.elapsed() >= self.ttl,
+                ),
+                None => (None, true),
+            },
+            Err(_) => (None, false),
+        };
+
+        if stale {
+            let queued = self
+                .pending
+                .lock()
+                .map(|mut p| p.insert(server.to_string()))
+                .unwrap_or(false);
+            if queued {
+                let _ = self.queue.send(server.to_string());
+            }
+        }
+        cached
+    }
+
+    /// `server` decorated with whatever the cache currently knows, e.g.
+    /// `filer01 (10.0.0.5)`.
+    pub fn describe(&self, server: &str) -> String {
+        match self.get(server) {
+            Some(names) if !names.is_empty() => format!("{} ({})", server, names.join(", ")),
+            _ => server.to_string(),
+        }
+    }
+}
+
+/// Whether the server string is already a literal address.
+pub fn is_address(server: &str) -> bool {
+    normalize_server(server).parse::<IpAddr>().is_ok()
+}
+
+/// Decorate `server` according to the display flags.
+pub fn display_server(server: &str, resolver: &Resolver, args: &ResolveArgs) -> String {
+    let wanted = if is_address(server) {
+        args.reverse
+    } else {
+        args.resolve
+    };
+    if wanted {
+        resolver.describe(server)
+    } else {
+        server.to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_split_device() {
+        assert_eq!(
+            split_device("filer:/vol/data"),
+            Some(("filer".to_string(), "/vol/data".to_string()))
+        );
+        assert_eq!(
+            split_device("[2001:db8::1]:/export"),
+            Some(("2001:db8::1".to_string(), "/export".to_string()))
+        );
+        assert_eq!(
+            split_device("10.0.0.5:/"),
+            Some(("10.0.0.5".to_string(), "/".to_string()))
+        );
+        assert_eq!(split_device("nocolon"), None);
+        assert!(is_address("[fe80::1]"));
+        assert!(!is_address("filer"));
+    }
+
+    #[test]
+    fn test_resolver_is_non_blocking_and_caches() {
+        let resolver = Resolver::with_lookup(Duration::from_secs(60), |host| {
+            thread::sleep(Duration::from_millis(20));
+            vec![format!("ip-of-{}", host)]
+        });
+
+        let start = Instant::now();
+        assert_eq!(resolver.get("filer"), None);
+        assert!(start.elapsed() < Duration::from_millis(20));
+        assert_eq!(resolver.describe("filer"), "filer");
+
+        let deadline = Instant::now() + Duration::from_secs(5);
+        while resolver.get("filer").is_none() && Instant::now() < deadline {
+            thread::sleep(Duration::from_millis(5));
+        }
+        assert_eq!(resolver.describe("filer"), "filer (ip-of-filer)");
+    }
+}
@@ -0,0 +1,131 @@
+//! Retransmissions and major timeouts as separate figures.
+//!
+//! The per-op line carries both: `ntrans - ops` is the number of times a
+//! request was sent again, while `timeouts` counts major timeouts, where
+//! the whole retry budget ran out. A lossy link shows the first; a dead
+//! server shows both.
+
+use crate::types::NFSMount;
+use std::io::{self, Write};
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct RetransBreakdown {
+    pub operation: String,
+    pub ops: i64,
+    pub retrans: i64,
+    pub timeouts: i64,
+}
+
+impl RetransBreakdown {
+    fn pct(&self, count: i64) -> f64 {
+        if self.ops > 0 {
+            count as f64 * 100.0 / self.ops as f64
+        } else {
+            0.0
+        }
+    }
+
+    pub fn retrans_pct(&self) -> f64 {
+        self.pct(self.retrans)
+    }
+
+    pub fn timeout_pct(&self) -> f64 {
+        self.pct(self.timeouts)
+    }
+}
+
+/// Per-op retransmissions and major timeouts between two snapshots, in
+/// operation name order. Operations with neither are omitted.
+pub fn breakdown(before: &NFSMount, after: &NFSMount) -> Vec<RetransBreakdown> {
+    let mut rows: Vec<RetransBreakdown> = after
+        .operations
+        .iter()
+        .filter_map(|(name, cur)| {
+            let prev = before.operations.get(name)?;
+            let ops = cur.ops - prev.ops;
+            if ops < 0 {
+                return None;
+            }
+            let row = RetransBreakdown {
+                operation: name.clone(),
+                ops,
+                retrans: (cur.ntrans - prev.ntrans - ops).max(0),
+                timeouts: (cur.timeouts - prev.timeouts).max(0),
+            };
+            (row.retrans > 0 || row.timeouts > 0).then_some(row)
+        })
+        .collect();
+    rows.sort_by(|a, b| a.operation.cmp(&b.operation));
+    rows
+}
+
+pub fn display_retrans<W: Write>(writer: &mut W, rows: &[RetransBreakdown]) -> io::Result<()> {
+    if rows.is_empty() {
+        return Ok(());
+    }
+
+    writeln!(
+        writer,
+        "{:<14} {:>10} {:>10} {:>9} {:>10} {:>9}",
+        "OP", "OPS", "RETRANS", "RETR%", "TIMEOUTS", "TMOUT%"
+    )?;
+    writeln!(writer, "{}", "-".repeat(67))?;
+    for row in rows {
+        writeln!(
+            writer,
+            "{:<14} {:>10} {:>10} {:>9.2} {:>10} {:>9.2}",
+            row.operation,
+            row.ops,
+            row.retrans,
+            row.retrans_pct(),
+            row.timeouts,
+            row.timeout_pct()
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil;
+    use crate::types::NFSOperation;
+
+    fn mount(ops: &[(&str, i64, i64, i64)]) -> NFSMount {
+        let ops = ops
+            .iter()
+            .map(|&(name, ops, ntrans, timeouts)| NFSOperation {
+                ops,
+                ntrans,
+                timeouts,
+                ..testutil::op(name)
+            });
+        testutil::with_ops(testutil::mount("/mnt"), ops)
+    }
+
+    #[test]
+    fn test_breakdown_separates_counters() {
+        let before = mount(&[("READ", 0, 0, 0), ("WRITE", 0, 0, 0), ("GETATTR", 0, 0, 0)]);
+        let after = mount(&[
+            ("READ", 100, 104, 0),
+            ("WRITE", 10, 13, 2),
+            ("GETATTR", 50, 50, 0),
+        ]);
+        let rows = breakdown(&before, &after);
+        assert_eq!(rows.len(), 2);
+
+        assert_eq!(rows[0].operation, "READ");
+        assert_eq!(rows[0].retrans, 4);
+        assert_eq!(rows[0].timeouts, 0);
+        assert!((rows[0].retrans_pct() - 4.0).abs() < 1e-9);
+
+        assert_eq!(rows[1].retrans, 3);
+        assert_eq!(rows[1].timeouts, 2);
+        assert!((rows[1].timeout_pct() - 20.0).abs() < 1e-9);
+
+        let mut out = Vec::new();
+        display_retrans(&mut out, &rows).unwrap();
+        assert!(String::from_utf8(out).unwrap().contains("TIMEOUTS"));
+    }
+}
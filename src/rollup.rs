@@ -0,0 +1,214 @@
+//! Periodic rollup summaries for long-running sessions.
+//!
+//! Windows are aligned to the wall clock (an hourly rollup covers
+//! 14:00-15:00, not 14:23-15:23) so rollups from several hosts line up.
+
+use crate::labels::Labels;
+use crate::session::Session;
+use crate::types::DeltaStats;
+use chrono::{DateTime, TimeZone, Utc};
+use clap::Args;
+use std::fs::OpenOptions;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct RollupArgs {
+    /// Emit a rollup summary every PERIOD (hourly, daily, or e.g. 15m, 6h)
+    #[arg(long = "rollup", value_name = "PERIOD", value_parser = parse_period)]
+    pub rollup: Option<i64>,
+
+    /// Append rollups to FILE instead of the main output
+    #[arg(long = "rollup-file", value_name = "FILE")]
+    pub rollup_file: Option<String>,
+}
+
+/// Parse a rollup period into seconds.
+pub fn parse_period(s: &str) -> std::result::Result<i64, String> {
+    let secs = match s {
+        "hourly" => 3600,
+        "daily" => 86400,
+        _ => {
+            let split = s.len() - s.trim_start_matches(|c: char| c.is_ascii_digit()).len();
+            let (num, unit) = s.split_at(split);
+            let n: i64 = num
+                .parse()
+                .map_err(|_| format!("invalid rollup period '{}'", s))?;
+            let scale = match unit {
+                "s" => 1,
+                "m" => 60,
+                "h" => 3600,
+                "d" => 86400,
+                _ => return Err(format!("invalid rollup period '{}'", s)),
+            };
+            n * scale
+        }
+    };
+    if secs < 60 {
+        return Err("rollup period must be at least one minute".to_string());
+    }
+    Ok(secs)
+}
+
+/// Start of the window containing `timestamp`.
+fn window_start(timestamp: DateTime<Utc>, period_secs: i64) -> DateTime<Utc> {
+    let secs = timestamp.timestamp();
+    Utc.timestamp_opt(secs - secs.rem_euclid(period_secs), 0)
+        .single()
+        .unwrap_or(timestamp)
+}
+
+/// Accumulates intervals into wall-clock aligned windows.
+pub struct Rollup {
+    period_secs: i64,
+    window_end: DateTime<Utc>,
+    window: Session,
+}
+
+impl Rollup {
+    pub fn new(period_secs: i64, now: DateTime<Utc>, labels: Labels) -> Self {
+        let start = window_start(now, period_secs);
+        let mut window = Session::new(start);
+        window.labels = labels;
+        Self {
+            period_secs,
+            window_end: start + chrono::Duration::seconds(period_secs),
+            window,
+        }
+    }
+
+    /// Close the current window if `timestamp` falls past its end,
+    /// returning the finished window.
+    pub fn advance(&mut self, timestamp: DateTime<Utc>) -> Option<Session> {
+        if timestamp < self.window_end {
+            return None;
+        }
+        let start = window_start(timestamp, self.period_secs);
+        let mut next = Session::new(start);
+        next.labels = self.window.labels.clone();
+        let mut finished = std::mem::replace(&mut self.window, next);
+        finished.ended = self.window_end;
+        self.window_end = start + chrono::Duration::seconds(self.period_secs);
+        Some(finished)
+    }
+
+    pub fn record(
+        &mut self,
+        mount_point: &str,
+        timestamp: DateTime<Utc>,
+        interval_secs: f64,
+        stats: &[DeltaStats],
+    ) {
+        self.window
+            .record(mount_point, timestamp, interval_secs, stats);
+    }
+
+    /// The partial window, for flushing on exit.
+    pub fn finish(self, now: DateTime<Utc>) -> Session {
+        let mut window = self.window;
+        window.ended = now;
+        window
+    }
+}
+
+pub fn display_rollup<W: Write>(writer: &mut W, window: &Session) -> io::Result<()> {
+    writeln!(
+        writer,
+        "=== Rollup {} - {} ===",
+        window.started.format("%Y-%m-%d %H:%M"),
+        window.ended.format("%Y-%m-%d %H:%M UTC")
+    )?;
+    if !window.labels.is_empty() {
+        writeln!(writer, "Labels: {}", window.labels)?;
+    }
+    if window.mounts.is_empty() {
+        writeln!(writer, "No activity")?;
+    }
+    for mount in window.mounts.values() {
+        let (rtt_total, ops) = mount
+            .ops
+            .values()
+            .fold((0i64, 0i64), |(r, o), op| (r + op.rtt, o + op.ops));
+        let peak_rtt = mount
+            .ops
+            .values()
+            .map(|op| op.peak_avg_rtt)
+            .fold(0.0, f64::max);
+        let errors: i64 = mount.ops.values().map(|op| op.errors).sum();
+        writeln!(
+            writer,
+            "{:<28} ops={} avg_iops={:.1} peak_iops={:.1} avg_rtt={:.2}ms peak_rtt={:.2}ms errors={} retrans={}",
+            mount.mount_point,
+            ops,
+            mount.avg_iops(),
+            mount.peak_iops,
+            if ops > 0 {
+                rtt_total as f64 / ops as f64
+            } else {
+                0.0
+            },
+            peak_rtt,
+            errors,
+            mount.total_retrans()
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+/// Write a finished window to `--rollup-file`, or to `fallback`.
+pub fn emit_rollup<W: Write>(
+    args: &RollupArgs,
+    fallback: &mut W,
+    window: &Session,
+) -> io::Result<()> {
+    match &args.rollup_file {
+        Some(path) => {
+            let mut file = OpenOptions::new().create(true).append(true).open(path)?;
+            display_rollup(&mut file, window)
+        }
+        None => display_rollup(fallback, window),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_parse_period() {
+        assert_eq!(parse_period("hourly"), Ok(3600));
+        assert_eq!(parse_period("daily"), Ok(86400));
+        assert_eq!(parse_period("15m"), Ok(900));
+        assert_eq!(parse_period("6h"), Ok(21600));
+        assert!(parse_period("30s").is_err());
+        assert!(parse_period("abc").is_err());
+        assert!(parse_period("5w").is_err());
+    }
+
+    #[test]
+    fn test_windows_align_to_clock() {
+        let at = |h, m| Utc.with_ymd_and_hms(2024, 5, 1, h, m, 0).unwrap();
+        let mut rollup = Rollup::new(3600, at(14, 23), Labels::new());
+
+        rollup.record("/mnt", at(14, 30), 10.0, &[stat("READ", 100, 1.0)]);
+        assert!(rollup.advance(at(14, 59)).is_none());
+        rollup.record("/mnt", at(14, 59), 10.0, &[stat("READ", 300, 3.0)]);
+
+        let window = rollup
+            .advance(at(15, 0))
+            .expect("window closes on the hour");
+        assert_eq!(window.started, at(14, 0));
+        assert_eq!(window.ended, at(15, 0));
+        assert_eq!(window.mounts["/mnt"].total_ops(), 400);
+
+        let mut out = Vec::new();
+        display_rollup(&mut out, &window).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.starts_with("=== Rollup 2024-05-01 14:00 - 2024-05-01 15:00 UTC ==="));
+        assert!(text.contains("ops=400 avg_iops=20.0 peak_iops=300.0 avg_rtt=2.50ms"));
+
+        // The next window starts empty.
+        assert!(rollup.finish(at(15, 10)).mounts.is_empty());
+    }
+}
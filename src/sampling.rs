@@ -0,0 +1,291 @@
+//! Sub-second sampling intervals and a guard that keeps the cost of
+//! reading mountstats below a set fraction of the interval.
+
+use crate::parser::parse_mountstats;
+use crate::types::Result;
+use clap::Args;
+use std::collections::VecDeque;
+use std::fmt;
+use std::str::FromStr;
+use std::time::{Duration, Instant};
+
+/// Shortest interval accepted; below this the scheduler jitter dominates.
+pub const MIN_INTERVAL: Duration = Duration::from_millis(10);
+/// Parse timings averaged by the overhead guard.
+const WINDOW: usize = 5;
+/// Intervals the startup recommendation picks from.
+const NICE_INTERVALS_MS: &[u64] = &[100, 200, 500, 1000, 2000, 5000, 10_000, 30_000, 60_000];
+/// Startup parses timed for the recommendation.
+const CALIBRATION_RUNS: u32 = 3;
+
+/// A sampling interval. Accepts plain seconds (`5`, `0.5`) or a unit
+/// suffix (`200ms`, `2s`, `1m`).
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub struct Interval(Duration);
+
+impl Interval {
+    pub fn from_duration(duration: Duration) -> Self {
+        Interval(duration.max(MIN_INTERVAL))
+    }
+
+    pub fn as_duration(self) -> Duration {
+        self.0
+    }
+
+    pub fn as_secs_f64(self) -> f64 {
+        self.0.as_secs_f64()
+    }
+}
+
+impl FromStr for Interval {
+    type Err = String;
+
+    fn from_str(s: &str) -> std::result::Result<Self, Self::Err> {
+        let s = s.trim();
+        let split = s
+            .find(|c: char| !(c.is_ascii_digit() || c == '.'))
+            .unwrap_or(s.len());
+        let (num, unit) = s.split_at(split);
+        let value: f64 = num
+            .parse()
+            .map_err(|_| format!("invalid interval '{}'", s))?;
+        let secs = match unit {
+            "" | "s" => value,
+            "ms" => value / 1000.0,
+            "m" => value * 60.0,
+            _ => return Err(format!("invalid interval unit in '{}'", s)),
+        };
+        if !secs.is_finite() || secs <= 0.0 {
+            return Err("interval must be positive".to_string());
+        }
+        let duration = Duration::from_secs_f64(secs);
+        if duration < MIN_INTERVAL {
+            return Err(format!(
+                "interval must be at least {}ms",
+                MIN_INTERVAL.as_millis()
+            ));
+        }
+        Ok(Interval(duration))
+    }
+}
+
+impl fmt::Display for Interval {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        if self.0.subsec_nanos() == 0 {
+            write!(f, "{}s", self.0.as_secs())
+        } else {
+            write!(f, "{}ms", self.0.as_millis())
+        }
+    }
+}
+
+/// Lets existing whole-second comparisons (`args.interval == 5`) keep
+/// working.
+impl PartialEq<u64> for Interval {
+    fn eq(&self, secs: &u64) -> bool {
+        self.0 == Duration::from_secs(*secs)
+    }
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct SamplingArgs {
+    /// Warn when reading mountstats takes more than this percentage of the interval
+    #[arg(long = "max-overhead", default_value = "10")]
+    pub max_overhead: f64,
+
+    /// Lengthen the interval instead of only warning when overhead is exceeded
+    #[arg(long = "overhead-backoff")]
+    pub overhead_backoff: bool,
+
+    /// Measure parse cost at startup and pick the interval automatically
+    #[arg(long = "auto-interval")]
+    pub auto_interval: bool,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Verdict {
+    Ok,
+    /// Overhead exceeded; the value is the measured fraction of the interval.
+    Warn(f64),
+    /// Overhead exceeded; switch to this interval.
+    BackOff(Interval),
+}
+
+/// Tracks recent parse times against the current interval.
+#[derive(Debug, Clone)]
+pub struct OverheadGuard {
+    max_fraction: f64,
+    backoff: bool,
+    samples: VecDeque<Duration>,
+    warned: bool,
+}
+
+impl OverheadGuard {
+    pub fn new(args: &SamplingArgs) -> Self {
+        Self {
+            max_fraction: (args.max_overhead / 100.0).clamp(0.001, 1.0),
+            backoff: args.overhead_backoff,
+            samples: VecDeque::with_capacity(WINDOW),
+            warned: false,
+        }
+    }
+
+    pub fn average(&self) -> Duration {
+        if self.samples.is_empty() {
+            return Duration::ZERO;
+        }
+        self.samples.iter().sum::<Duration>() / self.samples.len() as u32
+    }
+
+    /// Record how long one sample took. A warning is returned once per
+    /// episode of excess overhead; backing off is returned every time the
+    /// interval needs to grow.
+    pub fn observe(&mut self, parse_time: Duration, interval: Interval) -> Verdict {
+        if self.samples.len() == WINDOW {
+            self.samples.pop_front();
+        }
+        self.samples.push_back(parse_time);
+
+        let fraction = self.average().as_secs_f64() / interval.as_secs_f64();
+        if fraction <= self.max_fraction {
+            self.warned = false;
+            return Verdict::Ok;
+        }
+        if self.backoff {
+            // Round up to whole 100ms steps so the interval stays readable.
+            let needed = self.average().as_secs_f64() / self.max_fraction;
+            let steps = (needed * 10.0).ceil().max(1.0);
+            self.samples.clear();
+            return Verdict::BackOff(Interval::from_duration(Duration::from_secs_f64(
+                steps / 10.0,
+            )));
+        }
+        if self.warned {
+            return Verdict::Ok;
+        }
+        self.warned = true;
+        Verdict::Warn(fraction)
+    }
+}
+
+/// Average time to parse `path`, and the number of mounts it holds.
+pub fn measure_parse(path: &str) -> Result<(Duration, usize)> {
+    let start = Instant::now();
+    let mut mounts = 0;
+    for _ in 0..CALIBRATION_RUNS {
+        mounts = parse_mountstats(path)?.len();
+    }
+    Ok((start.elapsed() / CALIBRATION_RUNS, mounts))
+}
+
+/// The shortest standard interval at which `parse_time` stays within
+/// `max_overhead` percent.
+pub fn recommend_interval(parse_time: Duration, max_overhead: f64) -> Interval {
+    let fraction = (max_overhead / 100.0).clamp(0.001, 1.0);
+    let needed = parse_time.as_secs_f64() / fraction;
+    let ms = NICE_INTERVALS_MS
+        .iter()
+        .copied()
+        .find(|&ms| ms as f64 / 1000.0 >= needed)
+        .unwrap_or(*NICE_INTERVALS_MS.last().expect("non-empty"));
+    Interval::from_duration(Duration::from_millis(ms))
+}
+
+/// A startup note when `current` is shorter than recommended.
+pub fn interval_advice(
+    current: Interval,
+    recommended: Interval,
+    parse_time: Duration,
+    mounts: usize,
+) -> Option<String> {
+    (current < recommended).then(|| {
+        format!(
+            "Reading mountstats takes {:.1}ms for {} mounts; an interval of at least {} is recommended (use --auto-interval to apply it)",
+            parse_time.as_secs_f64() * 1000.0,
+            mounts,
+            recommended
+        )
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_interval() {
+        let ms = |n| Duration::from_millis(n);
+        assert_eq!("200ms".parse::<Interval>().unwrap().as_duration(), ms(200));
+        assert_eq!("0.5".parse::<Interval>().unwrap().as_duration(), ms(500));
+        assert_eq!("2s".parse::<Interval>().unwrap().as_duration(), ms(2000));
+        assert_eq!("1m".parse::<Interval>().unwrap().as_duration(), ms(60_000));
+        assert_eq!("5".parse::<Interval>().unwrap(), 5);
+        assert!("5ms".parse::<Interval>().is_err());
+        assert!("0".parse::<Interval>().is_err());
+        assert!("fast".parse::<Interval>().is_err());
+        assert!("3h".parse::<Interval>().is_err());
+
+        assert_eq!("200ms".parse::<Interval>().unwrap().to_string(), "200ms");
+        assert_eq!("3".parse::<Interval>().unwrap().to_string(), "3s");
+    }
+
+    #[test]
+    fn test_guard_warns_once() {
+        let mut guard = OverheadGuard::new(&SamplingArgs {
+            max_overhead: 10.0,
+            overhead_backoff: false,
+            auto_interval: false,
+        });
+        let interval: Interval = "200ms".parse().unwrap();
+        assert_eq!(
+            guard.observe(Duration::from_millis(5), interval),
+            Verdict::Ok
+        );
+
+        let mut warned = 0;
+        for _ in 0..10 {
+            if let Verdict::Warn(fraction) = guard.observe(Duration::from_millis(60), interval) {
+                assert!(fraction > 0.1);
+                warned += 1;
+            }
+        }
+        assert_eq!(warned, 1);
+    }
+
+    #[test]
+    fn test_guard_backs_off() {
+        let mut guard = OverheadGuard::new(&SamplingArgs {
+            max_overhead: 10.0,
+            overhead_backoff: true,
+            auto_interval: false,
+        });
+        let interval: Interval = "100ms".parse().unwrap();
+        match guard.observe(Duration::from_millis(35), interval) {
+            Verdict::BackOff(next) => {
+                assert_eq!(next.as_duration(), Duration::from_millis(400))
+            }
+            other => panic!("expected backoff, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_recommend_interval() {
+        let ms = Duration::from_millis;
+        assert_eq!(recommend_interval(ms(2), 10.0).as_duration(), ms(100));
+        assert_eq!(recommend_interval(ms(30), 10.0).as_duration(), ms(500));
+        // A 2000-mount render node taking 150ms per parse.
+        assert_eq!(recommend_interval(ms(150), 10.0).as_duration(), ms(2000));
+        assert_eq!(
+            recommend_interval(ms(60_000), 10.0).as_duration(),
+            ms(60_000)
+        );
+
+        let one: Interval = "1".parse().unwrap();
+        assert!(
+            interval_advice(one, recommend_interval(ms(150), 10.0), ms(150), 2000)
+                .unwrap()
+                .contains("at least 2s")
+        );
+        assert!(interval_advice(one, recommend_interval(ms(2), 10.0), ms(2), 3).is_none());
+    }
+}
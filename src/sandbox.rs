@@ -0,0 +1,464 @@
+//! Privilege drop and sandboxing for long-running root instances.
+//!
+//! Some data sources (other namespaces, tracefs, eBPF) need root to open.
+//! With `--sandbox` the caller opens those first and then calls [`apply`],
+//! which switches to an unprivileged user, confines the filesystem with
+//! Landlock and installs a seccomp filter that refuses the syscalls a
+//! compromised monitor could abuse. Descriptors opened beforehand keep
+//! working. Kernels without Landlock or seccomp degrade to whatever is
+//! available; the returned status says what was applied.
+//!
+//! Only the live monitor sandboxes. It refuses panels that need root on
+//! every interval, and starts its threads after [`apply`].
+
+use clap::Args;
+use std::ffi::CString;
+use std::fmt;
+use std::fs::{self, File};
+use std::io::{self, Read, Seek, SeekFrom};
+use std::mem;
+use std::os::fd::{AsRawFd, FromRawFd, OwnedFd};
+use std::path::Path;
+
+#[derive(Args, Debug, Clone)]
+pub struct SandboxArgs {
+    /// Drop privileges and apply seccomp/Landlock restrictions after start-up (live monitor only)
+    #[arg(long = "sandbox")]
+    pub sandbox: bool,
+
+    /// User to switch to when sandboxing as root
+    #[arg(long = "run-as", value_name = "USER", default_value = "nobody")]
+    pub run_as: String,
+}
+
+/// What [`apply`] managed to put in place.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct SandboxStatus {
+    /// uid/gid switched to, if started as root.
+    pub dropped_to: Option<(u32, u32)>,
+    pub landlock: bool,
+    pub seccomp: bool,
+}
+
+impl fmt::Display for SandboxStatus {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let onoff = |b| if b { "on" } else { "unavailable" };
+        match self.dropped_to {
+            Some((uid, gid)) => write!(f, "uid={} gid={}", uid, gid)?,
+            None => f.write_str("privileges unchanged")?,
+        }
+        write!(
+            f,
+            ", landlock {}, seccomp {}",
+            onoff(self.landlock),
+            onoff(self.seccomp)
+        )
+    }
+}
+
+/// Paths the monitor still needs after start-up: counters, name service
+/// files and shared libraries loaded lazily by the resolver.
+pub const DEFAULT_READ_PATHS: &[&str] = &["/proc", "/sys", "/etc", "/usr", "/lib", "/lib64"];
+
+/// uid and gid of `user`, from the password database.
+pub fn lookup_user(user: &str) -> io::Result<(u32, u32)> {
+    let name = CString::new(user)
+        .map_err(|_| io::Error::new(io::ErrorKind::InvalidInput, "user name contains NUL"))?;
+    // SAFETY: passwd is plain data; all-zero is a valid placeholder.
+    let mut pwd: libc::passwd = unsafe { mem::zeroed() };
+    let mut buf = vec![0 as libc::c_char; 4096];
+    let mut result: *mut libc::passwd = std::ptr::null_mut();
+    // SAFETY: all pointers refer to live, correctly sized buffers; `result`
+    // is only dereferenced through `pwd` when getpwnam_r reports success.
+    let rc = unsafe {
+        libc::getpwnam_r(
+            name.as_ptr(),
+            &mut pwd,
+            buf.as_mut_ptr(),
+            buf.len(),
+            &mut result,
+        )
+    };
+    if rc != 0 {
+        return Err(io::Error::from_raw_os_error(rc));
+    }
+    if result.is_null() {
+        return Err(io::Error::new(
+            io::ErrorKind::NotFound,
+            format!("no such user: {}", user),
+        ));
+    }
+    Ok((pwd.pw_uid, pwd.pw_gid))
+}
+
+fn check(rc: libc::c_int) -> io::Result<()> {
+    if rc < 0 {
+        Err(io::Error::last_os_error())
+    } else {
+        Ok(())
+    }
+}
+
+/// Switch to `uid`/`gid` for good, clearing supplementary groups, and
+/// verify root cannot be regained. The kernel clears the dumpable flag on
+/// a uid change, which makes `/proc/self` root-owned: anything needed from
+/// there must be opened beforehand (see [`HeldFile`]).
+pub fn drop_privileges(uid: u32, gid: u32) -> io::Result<()> {
+    // SAFETY: plain syscalls with no pointer arguments besides the null
+    // group list of length zero.
+    unsafe {
+        check(libc::setgroups(0, std::ptr::null()))?;
+        check(libc::setresgid(gid, gid, gid))?;
+        check(libc::setresuid(uid, uid, uid))?;
+        if uid != 0 && libc::setuid(0) == 0 {
+            return Err(io::Error::other("privilege drop did not stick"));
+        }
+    }
+    Ok(())
+}
+
+/// A file opened before the privilege drop and re-read from the start
+/// on every sample, so it stays readable once `/proc/self` is not.
+pub struct HeldFile {
+    file: File,
+}
+
+impl HeldFile {
+    pub fn open<P: AsRef<Path>>(path: P) -> io::Result<Self> {
+        Ok(Self {
+            file: File::open(path)?,
+        })
+    }
+
+    pub fn read(&mut self) -> io::Result<String> {
+        self.file.seek(SeekFrom::Start(0))?;
+        let mut contents = String::new();
+        self.file.read_to_string(&mut contents)?;
+        Ok(contents)
+    }
+}
+
+fn set_no_new_privs() -> io::Result<()> {
+    // SAFETY: prctl with integer arguments only.
+    check(unsafe { libc::prctl(libc::PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) })
+}
+
+// Landlock ABI v1; libc does not define these.
+const LANDLOCK_CREATE_RULESET_VERSION: u32 = 1;
+const LANDLOCK_RULE_PATH_BENEATH: libc::c_int = 1;
+const ACCESS_FS_EXECUTE: u64 = 1 << 0;
+const ACCESS_FS_WRITE_FILE: u64 = 1 << 1;
+const ACCESS_FS_READ_FILE: u64 = 1 << 2;
+const ACCESS_FS_READ_DIR: u64 = 1 << 3;
+const ACCESS_FS_MAKE_REG: u64 = 1 << 8;
+/// Every right defined by ABI v1.
+const ACCESS_FS_ALL: u64 = (1 << 13) - 1;
+const ACCESS_FS_FILE: u64 = ACCESS_FS_EXECUTE | ACCESS_FS_WRITE_FILE | ACCESS_FS_READ_FILE;
+
+#[repr(C)]
+struct RulesetAttr {
+    handled_access_fs: u64,
+}
+
+#[repr(C, packed)]
+struct PathBeneathAttr {
+    allowed_access: u64,
+    parent_fd: i32,
+}
+
+fn landlock_supported() -> bool {
+    // SAFETY: the version query takes no attribute pointer.
+    let abi = unsafe {
+        libc::syscall(
+            libc::SYS_landlock_create_ruleset,
+            std::ptr::null::<RulesetAttr>(),
+            0usize,
+            LANDLOCK_CREATE_RULESET_VERSION,
+        )
+    };
+    abi >= 1
+}
+
+fn add_rule(ruleset: &OwnedFd, path: &Path, access: u64) -> io::Result<()> {
+    let dir = match File::open(path) {
+        Ok(f) => f,
+        Err(e) if e.kind() == io::ErrorKind::NotFound => return Ok(()),
+        Err(e) => return Err(e),
+    };
+    let access = if dir.metadata()?.is_dir() {
+        access
+    } else {
+        access & ACCESS_FS_FILE
+    };
+    let attr = PathBeneathAttr {
+        allowed_access: access,
+        parent_fd: dir.as_raw_fd(),
+    };
+    // SAFETY: `attr` outlives the call and `dir` stays open until after it.
+    let rc = unsafe {
+        libc::syscall(
+            libc::SYS_landlock_add_rule,
+            ruleset.as_raw_fd(),
+            LANDLOCK_RULE_PATH_BENEATH,
+            &attr as *const PathBeneathAttr,
+            0u32,
+        )
+    };
+    check(rc as libc::c_int)
+}
+
+/// Confine filesystem access to reading `read_paths` and writing files in
+/// `write_paths`. Returns `false` when the kernel lacks Landlock.
+pub fn restrict_filesystem(read_paths: &[&str], write_paths: &[&str]) -> io::Result<bool> {
+    if !landlock_supported() {
+        return Ok(false);
+    }
+    let attr = RulesetAttr {
+        handled_access_fs: ACCESS_FS_ALL,
+    };
+    // SAFETY: `attr` is a valid v1 ruleset attribute of the given size.
+    let fd = unsafe {
+        libc::syscall(
+            libc::SYS_landlock_create_ruleset,
+            &attr as *const RulesetAttr,
+            mem::size_of::<RulesetAttr>(),
+            0u32,
+        )
+    };
+    check(fd as libc::c_int)?;
+    // SAFETY: a successful create_ruleset returns a new descriptor we own.
+    let ruleset = unsafe { OwnedFd::from_raw_fd(fd as libc::c_int) };
+
+    for path in read_paths {
+        add_rule(
+            &ruleset,
+            Path::new(path),
+            ACCESS_FS_READ_FILE | ACCESS_FS_READ_DIR,
+        )?;
+    }
+    for path in write_paths {
+        add_rule(
+            &ruleset,
+            Path::new(path),
+            ACCESS_FS_READ_FILE | ACCESS_FS_READ_DIR | ACCESS_FS_WRITE_FILE | ACCESS_FS_MAKE_REG,
+        )?;
+    }
+    set_no_new_privs()?;
+    // SAFETY: restrict_self only takes the ruleset descriptor and flags.
+    let rc = unsafe { libc::syscall(libc::SYS_landlock_restrict_self, ruleset.as_raw_fd(), 0u32) };
+    check(rc as libc::c_int)?;
+    Ok(true)
+}
+
+#[cfg(target_arch = "x86_64")]
+const AUDIT_ARCH: Option<u32> = Some(0xc000_003e);
+#[cfg(target_arch = "aarch64")]
+const AUDIT_ARCH: Option<u32> = Some(0xc000_00b7);
+#[cfg(not(any(target_arch = "x86_64", target_arch = "aarch64")))]
+const AUDIT_ARCH: Option<u32> = None;
+
+/// x32 syscalls share the x86_64 audit arch and are told apart by this
+/// bit in the syscall number, so they would slip past the deny list.
+#[cfg(target_arch = "x86_64")]
+const X32_SYSCALL_BIT: Option<u32> = Some(0x4000_0000);
+#[cfg(not(target_arch = "x86_64"))]
+const X32_SYSCALL_BIT: Option<u32> = None;
+
+const SECCOMP_RET_KILL_PROCESS: u32 = 0x8000_0000;
+
+/// Syscalls a monitor has no business making once it is running.
+fn denied_syscalls(allow_exec: bool) -> Vec<libc::c_long> {
+    let mut denied = vec![
+        libc::SYS_ptrace,
+        libc::SYS_process_vm_writev,
+        libc::SYS_mount,
+        libc::SYS_umount2,
+        libc::SYS_pivot_root,
+        libc::SYS_chroot,
+        libc::SYS_setuid,
+        libc::SYS_setgid,
+        libc::SYS_setreuid,
+        libc::SYS_setregid,
+        libc::SYS_setresuid,
+        libc::SYS_setresgid,
+        libc::SYS_setgroups,
+        libc::SYS_unshare,
+        libc::SYS_setns,
+        libc::SYS_init_module,
+        libc::SYS_finit_module,
+        libc::SYS_delete_module,
+        libc::SYS_kexec_load,
+        libc::SYS_reboot,
+        libc::SYS_bpf,
+        libc::SYS_perf_event_open,
+    ];
+    if !allow_exec {
+        denied.extend([libc::SYS_execve, libc::SYS_execveat]);
+    }
+    denied
+}
+
+fn stmt(code: u32, k: u32) -> libc::sock_filter {
+    libc::sock_filter {
+        code: code as u16,
+        jt: 0,
+        jf: 0,
+        k,
+    }
+}
+
+fn jump(code: u32, k: u32, jt: u8, jf: u8) -> libc::sock_filter {
+    libc::sock_filter {
+        code: code as u16,
+        jt,
+        jf,
+        k,
+    }
+}
+
+/// Classic BPF program: kill on a foreign architecture or an x32
+/// syscall, fail `denied` syscalls with EPERM, allow everything else.
+fn seccomp_program(arch: u32, denied: &[libc::c_long]) -> Vec<libc::sock_filter> {
+    // Offsets into struct seccomp_data.
+    const NR: u32 = 0;
+    const ARCH: u32 = 4;
+    let load = libc::BPF_LD | libc::BPF_W | libc::BPF_ABS;
+    let jeq = libc::BPF_JMP | libc::BPF_JEQ | libc::BPF_K;
+    let jge = libc::BPF_JMP | libc::BPF_JGE | libc::BPF_K;
+    let ret = libc::BPF_RET | libc::BPF_K;
+
+    let mut prog = vec![
+        stmt(load, ARCH),
+        jump(jeq, arch, 1, 0),
+        stmt(ret, SECCOMP_RET_KILL_PROCESS),
+        stmt(load, NR),
+    ];
+    if let Some(bit) = X32_SYSCALL_BIT {
+        prog.push(jump(jge, bit, 0, 1));
+        prog.push(stmt(ret, SECCOMP_RET_KILL_PROCESS));
+    }
+    for &nr in denied {
+        prog.push(jump(jeq, nr as u32, 0, 1));
+        prog.push(stmt(ret, libc::SECCOMP_RET_ERRNO | libc::EPERM as u32));
+    }
+    prog.push(stmt(ret, libc::SECCOMP_RET_ALLOW));
+    prog
+}
+
+/// Install the syscall filter on every thread of the process. Keep
+/// `allow_exec` when a feature spawns helpers, such as `--notify`.
+/// Returns `false` on unsupported architectures.
+pub fn deny_syscalls(allow_exec: bool) -> io::Result<bool> {
+    let Some(arch) = AUDIT_ARCH else {
+        return Ok(false);
+    };
+    let prog = seccomp_program(arch, &denied_syscalls(allow_exec));
+    let fprog = libc::sock_fprog {
+        len: prog.len() as u16,
+        filter: prog.as_ptr() as *mut libc::sock_filter,
+    };
+    set_no_new_privs()?;
+    // SAFETY: `fprog` points at `prog`, which outlives the call; the
+    // kernel copies the program.
+    let rc = unsafe {
+        libc::syscall(
+            libc::SYS_seccomp,
+            libc::SECCOMP_SET_MODE_FILTER,
+            libc::SECCOMP_FILTER_FLAG_TSYNC,
+            &fprog as *const libc::sock_fprog,
+        )
+    };
+    // With TSYNC a positive result is the id of a thread that could not
+    // be synchronized.
+    if rc > 0 {
+        return Err(io::Error::other(format!(
+            "seccomp filter could not be applied to thread {}",
+            rc
+        )));
+    }
+    check(rc as libc::c_int)?;
+    Ok(true)
+}
+
+/// Threads of this process, from /proc/self/task.
+fn thread_count() -> io::Result<usize> {
+    Ok(fs::read_dir("/proc/self/task")?.count())
+}
+
+/// Drop to `args.run_as` when running as root, then confine filesystem
+/// and syscall access. Call after every privileged resource is open and
+/// before any thread is started: Landlock only confines the calling
+/// thread and the threads it starts later.
+pub fn apply(
+    args: &SandboxArgs,
+    write_paths: &[&str],
+    allow_exec: bool,
+) -> io::Result<SandboxStatus> {
+    let threads = thread_count()?;
+    if threads > 1 {
+        return Err(io::Error::other(format!(
+            "sandbox must be applied before threads start ({} running)",
+            threads
+        )));
+    }
+    let mut status = SandboxStatus::default();
+    // SAFETY: geteuid has no preconditions.
+    if unsafe { libc::geteuid() } == 0 {
+        let (uid, gid) = lookup_user(&args.run_as)?;
+        drop_privileges(uid, gid)?;
+        status.dropped_to = Some((uid, gid));
+    }
+    status.landlock = restrict_filesystem(DEFAULT_READ_PATHS, write_paths)?;
+    status.seccomp = deny_syscalls(allow_exec)?;
+    Ok(status)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lookup_user() {
+        assert_eq!(lookup_user("root").unwrap(), (0, 0));
+        assert!(lookup_user("no-such-user-nfs-gaze").is_err());
+    }
+
+    #[test]
+    fn test_seccomp_program_layout() {
+        let denied = denied_syscalls(false);
+        let prog = seccomp_program(0xc000_003e, &denied);
+        let x32 = if X32_SYSCALL_BIT.is_some() { 2 } else { 0 };
+        assert_eq!(prog.len(), 4 + x32 + 2 * denied.len() + 1);
+        assert_eq!(prog[1].k, 0xc000_003e);
+        if let Some(bit) = X32_SYSCALL_BIT {
+            assert_eq!(prog[4].k, bit);
+            assert_eq!(prog[5].k, SECCOMP_RET_KILL_PROCESS);
+        }
+        assert_eq!(prog.last().unwrap().k, libc::SECCOMP_RET_ALLOW);
+        assert!(denied_syscalls(false).contains(&libc::SYS_execve));
+        assert!(!denied_syscalls(true).contains(&libc::SYS_execve));
+    }
+
+    #[test]
+    fn test_held_file_rereads() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("stats");
+        std::fs::write(&path, "one\n").unwrap();
+        let mut held = HeldFile::open(&path).unwrap();
+        assert_eq!(held.read().unwrap(), "one\n");
+        assert_eq!(held.read().unwrap(), "one\n");
+    }
+
+    #[test]
+    fn test_status_display() {
+        let status = SandboxStatus {
+            dropped_to: Some((65534, 65534)),
+            landlock: true,
+            seccomp: false,
+        };
+        assert_eq!(
+            status.to_string(),
+            "uid=65534 gid=65534, landlock on, seccomp unavailable"
+        );
+    }
+}
@@ -0,0 +1,144 @@
+//! Raw per-mount sections of a mountstats file.
+//!
+//! The structured parser only keeps what the core display needs; this
+//! keeps every line of each NFS device block so optional features can
+//! pick out `opts:`, `xprt:`, `sec:` and friends without re-implementing
+//! device-line handling.
+
+use crate::mountinfo::unescape;
+use std::fs;
+use std::io;
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct MountSection {
+    pub device: String,
+    pub mount_point: String,
+    pub fstype: String,
+    /// `statvers=` from the device line, if present.
+    pub statvers: Option<String>,
+    /// Body lines with surrounding whitespace removed.
+    pub lines: Vec<String>,
+}
+
+impl MountSection {
+    /// Value after `key:` on the first line that starts with it.
+    pub fn value(&self, key: &str) -> Option<&str> {
+        self.lines.iter().find_map(|line| strip_key(line, key))
+    }
+
+    /// Values of every line starting with `key:`; `xprt:` repeats with
+    /// nconnect, for example.
+    pub fn values<'a>(&'a self, key: &'a str) -> impl Iterator<Item = &'a str> + 'a {
+        self.lines
+            .iter()
+            .filter_map(move |line| strip_key(line, key))
+    }
+}
+
+fn strip_key<'a>(line: &'a str, key: &str) -> Option<&'a str> {
+    line.strip_prefix(key)
+        .and_then(|rest| rest.strip_prefix(':'))
+        .map(str::trim)
+}
+
+/// Parse `device X mounted on Y with fstype Z [statvers=V]`.
+fn parse_device_line(line: &str) -> Option<MountSection> {
+    let rest = line.strip_prefix("device ")?;
+    let (device, rest) = rest.split_once(" mounted on ")?;
+    let (mount_point, rest) = rest.rsplit_once(" with fstype ")?;
+    let mut fields = rest.split_whitespace();
+    let fstype = fields.next()?.to_string();
+    let statvers = fields
+        .find_map(|f| f.strip_prefix("statvers="))
+        .map(str::to_string);
+    Some(MountSection {
+        device: device.to_string(),
+        mount_point: unescape(mount_point),
+        fstype,
+        statvers,
+        lines: Vec::new(),
+    })
+}
+
+/// Split mountstats content into NFS device sections, in file order.
+/// Non-NFS devices are skipped along with their bodies.
+pub fn parse_sections(contents: &str) -> Vec<MountSection> {
+    let mut sections = Vec::new();
+    let mut current: Option<MountSection> = None;
+
+    for line in contents.lines() {
+        if line.starts_with("device ") {
+            if let Some(section) = current.take() {
+                sections.push(section);
+            }
+            current = parse_device_line(line).filter(|s| s.fstype.starts_with("nfs"));
+            continue;
+        }
+        if let Some(section) = current.as_mut() {
+            let trimmed = line.trim();
+            if !trimmed.is_empty() {
+                section.lines.push(trimmed.to_string());
+            }
+        }
+    }
+    if let Some(section) = current {
+        sections.push(section);
+    }
+    sections
+}
+
+pub fn read_sections(path: &str) -> io::Result<Vec<MountSection>> {
+    Ok(parse_sections(&fs::read_to_string(path)?))
+}
+
+#[cfg(test)]
+pub(crate) mod tests {
+    use super::*;
+
+    pub(crate) const MOUNTSTATS: &str = "\
+device rootfs mounted on / with fstype rootfs
+device proc mounted on /proc with fstype proc
+device filer:/export mounted on /mnt/nfs with fstype nfs4 statvers=1.1
+\topts:\trw,vers=4.2,rsize=1048576,wsize=1048576,namlen=255,acregmin=3,acregmax=60,acdirmin=30,acdirmax=60,hard,proto=tcp,nconnect=2,timeo=600,retrans=2,sec=krb5p,clientaddr=10.0.0.2,local_lock=none
+\tage:\t3600
+\tcaps:\tcaps=0x3ffbffff,wtmult=512,dtsize=1048576,bsize=0,namlen=255
+\tnfsv4:\tbm0=0xfdffbfff,bm1=0x40f9be3e,bm2=0x60800,acl=0x3,sessions,pnfs=not configured,lease_time=90,lease_expired=0
+\tsec:\tflavor=390005,pseudoflavor=390005
+\tevents:\t100 200 300 400 500 600 700 800 900 1000 1100 1200 1300 1400 1500 1600 1700 1800 1900 2000 2100 2200 2300 2400 2500 2600 2700
+\tbytes:\t1000 2000 300 400 5000 6000 70 80
+\tRPC iostats version: 1.1  p/v: 100003/4 (nfs)
+\txprt:\ttcp 869 1 1 0 5 1000 1000 0 2000 0 16 100 50
+\txprt:\ttcp 870 1 1 0 5 800 800 0 1500 0 16 80 40
+\tper-op statistics
+\t        NULL: 0 0 0 0 0 0 0 0 0
+\t        READ: 100 100 0 16000 409600 50 200 260 0
+\t       WRITE: 50 52 1 204800 8000 20 150 180 1
+\t     GETATTR: 400 400 0 60000 96000 4 80 100 0
+
+device filer2:/old mounted on /mnt/with\\040space with fstype nfs statvers=1.0
+\topts:\trw,vers=3,rsize=32768,wsize=32768,soft,proto=udp,timeo=11,retrans=1,sec=sys,mountaddr=10.0.0.9,actimeo=0
+\tage:\t10
+\tRPC iostats version: 1.0  p/v: 100003/3 (nfs)
+\txprt:\tudp 901 0 200 10 0 300 0 700 0 10
+\tper-op statistics
+\t        READ: 10 11 1 1600 40960 5 20 26
+";
+
+    #[test]
+    fn test_parse_sections() {
+        let sections = parse_sections(MOUNTSTATS);
+        assert_eq!(sections.len(), 2);
+
+        let first = &sections[0];
+        assert_eq!(first.device, "filer:/export");
+        assert_eq!(first.mount_point, "/mnt/nfs");
+        assert_eq!(first.fstype, "nfs4");
+        assert_eq!(first.statvers.as_deref(), Some("1.1"));
+        assert_eq!(first.value("age"), Some("3600"));
+        assert_eq!(first.values("xprt").count(), 2);
+        assert!(first.value("missing").is_none());
+
+        assert_eq!(sections[1].mount_point, "/mnt/with space");
+        assert_eq!(sections[1].statvers.as_deref(), Some("1.0"));
+    }
+}
@@ -0,0 +1,316 @@
+//! Security flavor (`sec:`) and negotiated server capabilities (`caps:`)
+//! per mount, and `--sec` filtering by flavor.
+
+use crate::sections::MountSection;
+use crate::types::NFSMount;
+use clap::Args;
+use serde::Serialize;
+use std::collections::HashSet;
+use std::fmt;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct SecurityArgs {
+    /// Only show mounts using this security flavor, e.g. sys or krb5p (repeatable)
+    #[arg(long = "sec", value_name = "FLAVOR")]
+    pub sec: Vec<String>,
+
+    /// Show each mount's security flavor and server capabilities
+    #[arg(long = "show-caps")]
+    pub show_caps: bool,
+}
+
+/// RPC authentication flavor, by pseudoflavor number.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum SecFlavor {
+    None,
+    Sys,
+    Krb5,
+    Krb5i,
+    Krb5p,
+    Other(u32),
+}
+
+impl SecFlavor {
+    pub fn from_number(n: u32) -> Self {
+        match n {
+            0 => SecFlavor::None,
+            1 => SecFlavor::Sys,
+            390003 => SecFlavor::Krb5,
+            390004 => SecFlavor::Krb5i,
+            390005 => SecFlavor::Krb5p,
+            other => SecFlavor::Other(other),
+        }
+    }
+
+    pub fn is_kerberos(self) -> bool {
+        matches!(self, SecFlavor::Krb5 | SecFlavor::Krb5i | SecFlavor::Krb5p)
+    }
+}
+
+impl fmt::Display for SecFlavor {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            SecFlavor::None => f.write_str("none"),
+            SecFlavor::Sys => f.write_str("sys"),
+            SecFlavor::Krb5 => f.write_str("krb5"),
+            SecFlavor::Krb5i => f.write_str("krb5i"),
+            SecFlavor::Krb5p => f.write_str("krb5p"),
+            SecFlavor::Other(n) => write!(f, "flavor-{}", n),
+        }
+    }
+}
+
+fn key_values(value: &str) -> impl Iterator<Item = (&str, &str)> {
+    value.split(',').filter_map(|kv| kv.trim().split_once('='))
+}
+
+fn parse_number(v: &str) -> Option<u64> {
+    match v.strip_prefix("0x") {
+        Some(hex) => u64::from_str_radix(hex, 16).ok(),
+        None => v.parse().ok(),
+    }
+}
+
+/// Parse `flavor=390005,pseudoflavor=390005`. The pseudoflavor is the
+/// specific one (krb5i vs krb5p); `flavor` alone is used when absent.
+pub fn parse_sec(value: &str) -> Option<SecFlavor> {
+    let mut flavor = None;
+    let mut pseudo = None;
+    for (k, v) in key_values(value) {
+        match k {
+            "flavor" => flavor = v.parse().ok(),
+            "pseudoflavor" => pseudo = v.parse().ok(),
+            _ => {}
+        }
+    }
+    pseudo.or(flavor).map(SecFlavor::from_number)
+}
+
+/// `NFS_CAP_*` bits from include/linux/nfs_fs_sb.h that have kept their
+/// meaning across kernel versions.
+const CAP_NAMES: &[(u32, &str)] = &[
+    (0, "readdirplus"),
+    (1, "hardlinks"),
+    (2, "symlinks"),
+    (3, "acls"),
+    (4, "atomic_open"),
+    (5, "lgopen"),
+    (6, "case_insensitive"),
+    (7, "case_preserving"),
+    (14, "posix_lock"),
+    (15, "uidgid_nomap"),
+    (16, "stateid_nfsv41"),
+    (17, "atomic_open_v1"),
+    (18, "security_label"),
+    (19, "seek"),
+    (20, "allocate"),
+    (21, "deallocate"),
+    (22, "layoutstats"),
+    (23, "clone"),
+    (24, "copy"),
+    (25, "offload_cancel"),
+    (26, "layouterror"),
+    (27, "copy_notify"),
+    (28, "xattr"),
+    (29, "read_plus"),
+    (30, "fs_locations"),
+    (31, "moveable"),
+];
+
+/// The `caps:` line.
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+pub struct ServerCaps {
+    pub caps: u64,
+    pub wtmult: Option<u64>,
+    pub dtsize: Option<u64>,
+    pub bsize: Option<u64>,
+    pub namlen: Option<u64>,
+}
+
+impl ServerCaps {
+    pub fn parse(value: &str) -> Option<Self> {
+        let mut caps = None;
+        let mut out = ServerCaps::default();
+        for (k, v) in key_values(value) {
+            let n = parse_number(v);
+            match k {
+                "caps" => caps = n,
+                "wtmult" => out.wtmult = n,
+                "dtsize" => out.dtsize = n,
+                "bsize" => out.bsize = n,
+                "namlen" => out.namlen = n,
+                _ => {}
+            }
+        }
+        out.caps = caps?;
+        Some(out)
+    }
+
+    pub fn has(&self, bit: u32) -> bool {
+        self.caps & (1 << bit) != 0
+    }
+
+    /// Names of the known capabilities that are set.
+    pub fn names(&self) -> Vec<&'static str> {
+        CAP_NAMES
+            .iter()
+            .filter(|(bit, _)| self.has(*bit))
+            .map(|(_, name)| *name)
+            .collect()
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub struct MountSecurity {
+    pub mount_point: String,
+    pub flavor: Option<SecFlavor>,
+    pub caps: Option<ServerCaps>,
+}
+
+pub fn mount_security(section: &MountSection) -> MountSecurity {
+    MountSecurity {
+        mount_point: section.mount_point.clone(),
+        flavor: section.value("sec").and_then(parse_sec),
+        caps: section.value("caps").and_then(ServerCaps::parse),
+    }
+}
+
+/// Whether `security` passes the `--sec` filter. An empty filter passes
+/// everything; `krb5*` matches any Kerberos flavor.
+pub fn matches_flavor(security: &MountSecurity, wanted: &[String]) -> bool {
+    if wanted.is_empty() {
+        return true;
+    }
+    let Some(flavor) = security.flavor else {
+        return false;
+    };
+    let name = flavor.to_string();
+    wanted.iter().any(|w| {
+        w.eq_ignore_ascii_case(&name) || (w.eq_ignore_ascii_case("krb5*") && flavor.is_kerberos())
+    })
+}
+
+/// Mount points whose flavor passes `args.sec`.
+pub fn filter_mounts(sections: &[MountSection], args: &SecurityArgs) -> Vec<String> {
+    sections
+        .iter()
+        .map(mount_security)
+        .filter(|s| matches_flavor(s, &args.sec))
+        .map(|s| s.mount_point)
+        .collect()
+}
+
+/// `mounts` whose flavor, as given by `sections`, passes `args.sec`.
+pub fn select_by_flavor(
+    mounts: Vec<NFSMount>,
+    sections: &[MountSection],
+    args: &SecurityArgs,
+) -> Vec<NFSMount> {
+    if args.sec.is_empty() {
+        return mounts;
+    }
+    let allowed: HashSet<String> = filter_mounts(sections, args).into_iter().collect();
+    mounts
+        .into_iter()
+        .filter(|m| allowed.contains(&m.mount_point))
+        .collect()
+}
+
+pub fn display_security<W: Write>(writer: &mut W, mounts: &[MountSecurity]) -> io::Result<()> {
+    writeln!(
+        writer,
+        "{:<30} {:<8} {:>8} {:>8}  CAPABILITIES",
+        "MOUNT", "SEC", "WTMULT", "NAMLEN"
+    )?;
+    writeln!(writer, "{}", "-".repeat(78))?;
+    for m in mounts {
+        let flavor = m.flavor.map_or_else(|| "-".to_string(), |f| f.to_string());
+        let opt = |v: Option<u64>| v.map_or_else(|| "-".to_string(), |v| v.to_string());
+        let (wtmult, namlen, names) = match &m.caps {
+            Some(c) => (opt(c.wtmult), opt(c.namlen), c.names().join(",")),
+            None => ("-".to_string(), "-".to_string(), String::new()),
+        };
+        writeln!(
+            writer,
+            "{:<30} {:<8} {:>8} {:>8}  {}",
+            m.mount_point, flavor, wtmult, namlen, names
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::{parse_sections, tests::MOUNTSTATS};
+
+    #[test]
+    fn test_select_by_flavor() {
+        use crate::testutil::mount;
+        let sections = parse_sections(MOUNTSTATS);
+        let mounts = || {
+            sections
+                .iter()
+                .map(|s| mount(&s.mount_point))
+                .collect::<Vec<_>>()
+        };
+        let args = |sec: &[&str]| SecurityArgs {
+            sec: sec.iter().map(|s| s.to_string()).collect(),
+            show_caps: false,
+        };
+        assert_eq!(
+            select_by_flavor(mounts(), &sections, &args(&[])).len(),
+            sections.len()
+        );
+        let kerberos = select_by_flavor(mounts(), &sections, &args(&["krb5*"]));
+        assert_eq!(kerberos.len(), 1);
+        assert_eq!(kerberos[0].mount_point, sections[0].mount_point);
+    }
+
+    #[test]
+    fn test_mount_security() {
+        let sections = parse_sections(MOUNTSTATS);
+        let first = mount_security(&sections[0]);
+        assert_eq!(first.flavor, Some(SecFlavor::Krb5p));
+        let caps = first.caps.unwrap();
+        assert_eq!(caps.caps, 0x3ffbffff);
+        assert_eq!(caps.wtmult, Some(512));
+        assert_eq!(caps.namlen, Some(255));
+        let names = caps.names();
+        assert!(names.contains(&"readdirplus"));
+        assert!(names.contains(&"seek"));
+        // Bit 18 is clear in 0x3ffbffff.
+        assert!(!names.contains(&"security_label"));
+
+        // The v3 fixture mount has neither line.
+        let second = mount_security(&sections[1]);
+        assert_eq!(second.flavor, None);
+        assert!(second.caps.is_none());
+    }
+
+    #[test]
+    fn test_flavor_filter() {
+        assert_eq!(parse_sec("flavor=1,pseudoflavor=1"), Some(SecFlavor::Sys));
+        assert_eq!(parse_sec("flavor=6"), Some(SecFlavor::Other(6)));
+        assert_eq!(SecFlavor::Other(6).to_string(), "flavor-6");
+
+        let sections = parse_sections(MOUNTSTATS);
+        let args = |sec: &[&str]| SecurityArgs {
+            sec: sec.iter().map(|s| s.to_string()).collect(),
+            show_caps: false,
+        };
+        assert_eq!(filter_mounts(&sections, &args(&[])).len(), 2);
+        assert_eq!(
+            filter_mounts(&sections, &args(&["KRB5P"])),
+            vec!["/mnt/nfs"]
+        );
+        assert_eq!(
+            filter_mounts(&sections, &args(&["krb5*"])),
+            vec!["/mnt/nfs"]
+        );
+        assert!(filter_mounts(&sections, &args(&["sys"])).is_empty());
+    }
+}
@@ -0,0 +1,330 @@
+//! Which mounts to monitor. `-m` may be repeated or given a
+//! comma-separated list, and `--m-glob`/`--m-regex` select by pattern. A
+//! mount is monitored if it matches any of them; with none given, every
+//! NFS mount is monitored. `--exclude` then drops mounts by name or glob,
+//! and wins over every form of selection.
+
+use crate::types::NFSMount;
+use clap::Args;
+use regex::Regex;
+
+#[derive(Args, Debug, Clone)]
+pub struct MountPatternArgs {
+    /// Monitor mounts matching a shell glob, e.g. '/mnt/project*' (repeatable)
+    #[arg(long = "m-glob", value_name = "GLOB", value_parser = parse_glob, global = true)]
+    pub m_glob: Vec<Regex>,
+
+    /// Monitor mounts matching a regular expression (repeatable)
+    #[arg(long = "m-regex", value_name = "REGEX", value_parser = parse_regex, global = true)]
+    pub m_regex: Vec<Regex>,
+
+    /// Skip these mounts; exact paths or globs, comma-separated or repeated
+    #[arg(
+        long = "exclude",
+        value_name = "MOUNT",
+        value_delimiter = ',',
+        value_parser = parse_glob,
+        global = true
+    )]
+    pub exclude: Vec<Regex>,
+}
+
+/// Translate a shell glob into an anchored regex. `*` and `?` stop at
+/// `/` as in a shell; `**` crosses directories. `[...]` is a character
+/// class, negated by a leading `!` or `^`; a `]` straight after the
+/// opening bracket is a member, and a `[` with no closing `]` is literal.
+pub fn glob_to_regex(glob: &str) -> String {
+    let chars: Vec<char> = glob.chars().collect();
+    let mut re = String::from("^");
+    let mut i = 0;
+    while i < chars.len() {
+        match chars[i] {
+            '*' if chars.get(i + 1) == Some(&'*') => {
+                re.push_str(".*");
+                i += 1;
+            }
+            '*' => re.push_str("[^/]*"),
+            '?' => re.push_str("[^/]"),
+            '[' => {
+                if let Some((class, end)) = glob_class(&chars, i) {
+                    re.push_str(&class);
+                    i = end;
+                    continue;
+                }
+                re.push_str("\\[");
+            }
+            c => re.push_str(&regex::escape(c.encode_utf8(&mut [0; 4]))),
+        }
+        i += 1;
+    }
+    re.push('$');
+    re
+}
+
+/// The regex class for the glob class opening at `chars[start]`, and the
+/// index just past its closing `]`; `None` if it is never closed.
+fn glob_class(chars: &[char], start: usize) -> Option<(String, usize)> {
+    let mut i = start + 1;
+    let negated = matches!(chars.get(i), Some('!' | '^'));
+    if negated {
+        i += 1;
+    }
+    let first = i;
+    let mut class = String::from("[");
+    if negated {
+        // Like `*` and `?`, a negated class never matches `/`.
+        class.push_str("^/");
+    }
+    loop {
+        let c = *chars.get(i)?;
+        match c {
+            ']' if i > first => break,
+            // A range, unless `-` is first or last and so a member.
+            '-' if i > first && chars.get(i + 1).is_some_and(|&n| n != ']') => class.push('-'),
+            // Everything the regex crate treats specially inside a class,
+            // including the `&&`, `--` and `~~` set operators.
+            '\\' | '[' | ']' | '^' | '-' | '&' | '~' => {
+                class.push('\\');
+                class.push(c);
+            }
+            c => class.push(c),
+        }
+        i += 1;
+    }
+    class.push(']');
+    Some((class, i + 1))
+}
+
+pub fn parse_glob(glob: &str) -> Result<Regex, String> {
+    Regex::new(&glob_to_regex(glob)).map_err(|e| format!("invalid glob '{}': {}", glob, e))
+}
+
+pub fn parse_regex(re: &str) -> Result<Regex, String> {
+    Regex::new(re).map_err(|e| format!("invalid regex '{}': {}", re, e))
+}
+
+/// Split repeated and comma-separated `-m` values into mount points,
+/// dropping blanks and duplicates while keeping the order given.
+pub fn parse_mount_list<S: AsRef<str>>(values: &[S]) -> Vec<String> {
+    let mut mounts: Vec<String> = Vec::new();
+    for value in values {
+        for mount in value.as_ref().split(',').map(str::trim) {
+            if !mount.is_empty() && !mounts.iter().any(|m| m == mount) {
+                mounts.push(mount.to_string());
+            }
+        }
+    }
+    mounts
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct MountSelector {
+    /// Exact mount points.
+    pub mounts: Vec<String>,
+    /// Glob and regex patterns, already compiled.
+    pub patterns: Vec<Regex>,
+    /// Mounts to drop even if selected.
+    pub excludes: Vec<Regex>,
+}
+
+impl MountSelector {
+    pub fn new<S: AsRef<str>>(mount_args: &[S]) -> Self {
+        Self {
+            mounts: parse_mount_list(mount_args),
+            patterns: Vec::new(),
+            excludes: Vec::new(),
+        }
+    }
+
+    /// Add the `--m-glob`, `--m-regex` and `--exclude` patterns.
+    pub fn with_patterns(mut self, args: &MountPatternArgs) -> Self {
+        self.patterns
+            .extend(args.m_glob.iter().chain(&args.m_regex).cloned());
+        self.excludes.extend(args.exclude.iter().cloned());
+        self
+    }
+
+    pub fn is_all(&self) -> bool {
+        self.mounts.is_empty() && self.patterns.is_empty()
+    }
+
+    pub fn excluded(&self, mount_point: &str) -> bool {
+        self.excludes.iter().any(|p| p.is_match(mount_point))
+    }
+
+    pub fn matches(&self, mount_point: &str) -> bool {
+        if self.excluded(mount_point) {
+            return false;
+        }
+        self.is_all()
+            || self.mounts.iter().any(|m| m == mount_point)
+            || self.patterns.iter().any(|p| p.is_match(mount_point))
+    }
+
+    /// Keep the selected mounts from `mounts`.
+    pub fn select(&self, mounts: Vec<NFSMount>) -> Vec<NFSMount> {
+        mounts
+            .into_iter()
+            .filter(|m| self.matches(&m.mount_point))
+            .collect()
+    }
+
+    /// Explicitly named mounts that are not present, for a not-found
+    /// error or warning.
+    pub fn missing<'a>(&'a self, mounts: &[NFSMount]) -> Vec<&'a str> {
+        self.mounts
+            .iter()
+            .filter(|want| !mounts.iter().any(|m| &m.mount_point == *want))
+            .map(String::as_str)
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil::mount;
+
+    #[test]
+    fn test_parse_mount_list() {
+        assert_eq!(
+            parse_mount_list(&["/a,/b", " /c ", "/a", ""]),
+            vec!["/a", "/b", "/c"]
+        );
+        assert!(parse_mount_list::<&str>(&[]).is_empty());
+    }
+
+    #[test]
+    fn test_select() {
+        let all = vec![mount("/a"), mount("/b"), mount("/c")];
+        let selector = MountSelector::new(&["/a,/c", "/x"]);
+        let picked: Vec<String> = selector
+            .select(all.clone())
+            .into_iter()
+            .map(|m| m.mount_point)
+            .collect();
+        assert_eq!(picked, vec!["/a", "/c"]);
+        assert_eq!(selector.missing(&all), vec!["/x"]);
+
+        let everything = MountSelector::new::<&str>(&[]);
+        assert!(everything.is_all());
+        assert_eq!(everything.select(all).len(), 3);
+    }
+
+    #[test]
+    fn test_glob_to_regex() {
+        let glob = |g: &str| parse_glob(g).unwrap();
+        assert!(glob("/mnt/project*").is_match("/mnt/project42"));
+        assert!(!glob("/mnt/project*").is_match("/mnt/project42/sub"));
+        assert!(glob("/home/**").is_match("/home/alice/data"));
+        assert!(glob("/mnt/vol?").is_match("/mnt/vol1"));
+        assert!(glob("/mnt/[ab]*").is_match("/mnt/beta"));
+        assert!(!glob("/mnt/[!ab]*").is_match("/mnt/beta"));
+        assert!(glob("/mnt/a.b").is_match("/mnt/a.b"));
+        assert!(!glob("/mnt/a.b").is_match("/mnt/axb"));
+        assert!(glob("/mnt/[x").is_match("/mnt/[x"));
+    }
+
+    #[test]
+    fn test_glob_classes() {
+        let glob = |g: &str| parse_glob(g).unwrap();
+        assert!(glob("/mnt/[!x]").is_match("/mnt/y"));
+        assert!(!glob("/mnt/[!x]").is_match("/mnt/x"));
+        assert!(!glob("/mnt[!x]a").is_match("/mnt/a"));
+        assert!(glob("/mnt/[^x]").is_match("/mnt/y"));
+        // `]` first in the class is a member, negated or not.
+        assert!(glob("/mnt/[]a]").is_match("/mnt/]"));
+        assert!(glob("/mnt/[]a]").is_match("/mnt/a"));
+        assert!(!glob("/mnt/[!]]").is_match("/mnt/]"));
+        assert!(glob("/mnt/[!]]").is_match("/mnt/a"));
+        // Ranges, and `-` as a member at either end.
+        assert!(glob("/mnt/vol[0-9]").is_match("/mnt/vol7"));
+        assert!(glob("/mnt/[-a]").is_match("/mnt/-"));
+        assert!(glob("/mnt/[a-]").is_match("/mnt/-"));
+        assert!(!glob("/mnt/[a-]").is_match("/mnt/b"));
+        // Characters the regex crate treats specially inside a class.
+        assert!(glob("/mnt/[&&x]").is_match("/mnt/&"));
+        assert!(glob("/mnt/[[]").is_match("/mnt/["));
+        assert!(glob("/mnt/[\\\\]").is_match("/mnt/\\"));
+        // An unterminated `[` is literal and the rest is still a glob.
+        assert!(glob("/mnt/[ab*").is_match("/mnt/[abc"));
+        assert!(!glob("/mnt/[ab*").is_match("/mnt/a"));
+        assert!(parse_glob("/mnt/[z-a]").is_err());
+    }
+
+    #[test]
+    fn test_select_by_pattern() {
+        let all = vec![
+            mount("/mnt/proj1"),
+            mount("/mnt/proj2"),
+            mount("/home/bob"),
+            mount("/data"),
+        ];
+        let args = MountPatternArgs {
+            m_glob: vec![parse_glob("/mnt/proj*").unwrap()],
+            m_regex: vec![parse_regex("^/home/").unwrap()],
+            exclude: Vec::new(),
+        };
+        let selector = MountSelector::new(&["/data"]).with_patterns(&args);
+        assert_eq!(selector.select(all.clone()).len(), 4);
+
+        let selector = MountSelector::new::<&str>(&[]).with_patterns(&args);
+        let picked: Vec<String> = selector
+            .select(all)
+            .into_iter()
+            .map(|m| m.mount_point)
+            .collect();
+        assert_eq!(picked, vec!["/mnt/proj1", "/mnt/proj2", "/home/bob"]);
+        assert!(parse_regex("(").is_err());
+    }
+
+    #[test]
+    fn test_exclude() {
+        let all = vec![
+            mount("/home/alice"),
+            mount("/home/bob"),
+            mount("/net"),
+            mount("/data"),
+        ];
+        let args = MountPatternArgs {
+            m_glob: Vec::new(),
+            m_regex: Vec::new(),
+            exclude: vec![parse_glob("/home/*").unwrap(), parse_glob("/net").unwrap()],
+        };
+        let selector = MountSelector::new::<&str>(&[]).with_patterns(&args);
+        // Exclusions alone still monitor everything else.
+        assert!(selector.is_all());
+        let picked: Vec<String> = selector
+            .select(all.clone())
+            .into_iter()
+            .map(|m| m.mount_point)
+            .collect();
+        assert_eq!(picked, vec!["/data"]);
+
+        // An explicit -m does not override an exclusion.
+        let selector = MountSelector::new(&["/net,/data"]).with_patterns(&args);
+        assert_eq!(selector.select(all).len(), 1);
+    }
+
+    #[test]
+    fn test_exclude_flag_splits_on_commas() {
+        use clap::Parser;
+
+        #[derive(Parser)]
+        struct Cli {
+            #[command(flatten)]
+            patterns: MountPatternArgs,
+        }
+
+        let cli = Cli::try_parse_from([
+            "nfs-gaze",
+            "--exclude",
+            "/home/*,/net",
+            "--exclude",
+            "/misc",
+        ])
+        .unwrap();
+        assert_eq!(cli.patterns.exclude.len(), 3);
+        assert!(Cli::try_parse_from(["nfs-gaze", "--m-regex", "("]).is_err());
+    }
+}
@@ -0,0 +1,329 @@
+//! `nfs-gaze serve`: sample continuously and answer JSON queries over
+//! HTTP, for dashboards and scripts that would otherwise scrape stdout.
+//!
+//! - `GET /mounts`: tracked mounts
+//! - `GET /mounts/{mount point}/ops`: that mount's latest interval; the
+//!   mount point may be percent-encoded (`/mounts/%2Fmnt%2Fdata/ops`) or
+//!   written out (`/mounts/mnt/data/ops`)
+//! - `GET /intervals/latest`: the latest interval for every mount
+//! - `/stream`: a WebSocket that receives the same document as
+//!   `/intervals/latest` as a text frame after every interval
+//!
+//! A sampler thread owns the tracker; requests only read its last result,
+//! so a slow client never delays sampling.
+
+use crate::exporter::{parse_listen, read_request, respond};
+use crate::labels::Labels;
+use crate::output::interval_json;
+use crate::parser::parse_mountstats;
+use crate::tracker::{display_mount_events, MountInterval, MountTracker};
+use crate::types::Result;
+use crate::websocket::{accept, is_upgrade, Hub};
+use chrono::{DateTime, SecondsFormat, Utc};
+use clap::Args;
+use serde_json::{json, Value};
+use std::io;
+use std::net::{SocketAddr, TcpListener, TcpStream};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::{Duration, Instant};
+
+#[derive(Args, Debug, Clone)]
+pub struct ServeArgs {
+    /// Address for the JSON API (e.g. :9098 or 127.0.0.1:9098)
+    #[arg(long = "listen", default_value = ":9098", value_parser = parse_listen)]
+    pub listen: SocketAddr,
+
+    /// Seconds between samples
+    #[arg(short = 'i', long = "interval", default_value = "1")]
+    pub interval: u64,
+}
+
+/// The sampler's most recent result.
+#[derive(Debug, Default)]
+pub struct LiveState {
+    pub timestamp: Option<DateTime<Utc>>,
+    pub interval_secs: f64,
+    pub intervals: Vec<MountInterval>,
+}
+
+impl LiveState {
+    pub fn update(
+        &mut self,
+        timestamp: DateTime<Utc>,
+        interval_secs: f64,
+        intervals: Vec<MountInterval>,
+    ) {
+        self.timestamp = Some(timestamp);
+        self.interval_secs = interval_secs;
+        self.intervals = intervals;
+    }
+
+    /// Every mount's latest interval, or `None` before the first one.
+    pub fn latest_json(&self, labels: &Labels) -> Option<Value> {
+        let timestamp = self.timestamp?;
+        let mounts: Vec<Value> = self
+            .intervals
+            .iter()
+            .map(|i| self.interval_json(i, labels))
+            .collect();
+        Some(json!({
+            "timestamp": timestamp.to_rfc3339_opts(SecondsFormat::Millis, true),
+            "interval_secs": self.interval_secs,
+            "mounts": mounts,
+        }))
+    }
+
+    fn interval_json(&self, interval: &MountInterval, labels: &Labels) -> Value {
+        interval_json(
+            &interval.mount,
+            self.timestamp.unwrap_or_else(Utc::now),
+            self.interval_secs,
+            &interval.stats,
+            labels,
+        )
+    }
+}
+
+/// Decode `%XX` escapes; malformed escapes are kept as written.
+fn percent_decode(s: &str) -> String {
+    let bytes = s.as_bytes();
+    let mut out = Vec::with_capacity(bytes.len());
+    let mut i = 0;
+    while i < bytes.len() {
+        if bytes[i] == b'%' && i + 2 < bytes.len() {
+            let hex = std::str::from_utf8(&bytes[i + 1..i + 3]).ok();
+            if let Some(b) = hex.and_then(|h| u8::from_str_radix(h, 16).ok()) {
+                out.push(b);
+                i += 3;
+                continue;
+            }
+        }
+        out.push(bytes[i]);
+        i += 1;
+    }
+    String::from_utf8_lossy(&out).into_owned()
+}
+
+/// Answer a GET for `target`, returning the status line and JSON body.
+pub fn route(state: &LiveState, target: &str, labels: &Labels) -> (&'static str, Value) {
+    let path = target.split('?').next().unwrap_or("");
+    let not_found = |what: String| ("404 Not Found", json!({ "error": what }));
+
+    if path == "/mounts" {
+        let mounts: Vec<Value> = state
+            .intervals
+            .iter()
+            .map(|i| {
+                json!({
+                    "mount_point": i.mount.mount_point,
+                    "device": i.mount.device,
+                    "server": i.mount.server,
+                    "export": i.mount.export,
+                    "age": i.mount.age,
+                })
+            })
+            .collect();
+        return ("200 OK", Value::Array(mounts));
+    }
+    if path == "/intervals/latest" {
+        return match state.latest_json(labels) {
+            Some(latest) => ("200 OK", latest),
+            None => not_found("no interval sampled yet".to_string()),
+        };
+    }
+    if let Some(encoded) = path
+        .strip_prefix("/mounts/")
+        .and_then(|rest| rest.strip_suffix("/ops"))
+    {
+        let decoded = percent_decode(encoded);
+        let mount_point = if decoded.starts_with('/') {
+            decoded
+        } else {
+            format!("/{}", decoded)
+        };
+        return match state
+            .intervals
+            .iter()
+            .find(|i| i.mount.mount_point == mount_point)
+        {
+            Some(interval) => ("200 OK", state.interval_json(interval, labels)),
+            None => not_found(format!("mount {} not tracked", mount_point)),
+        };
+    }
+    not_found(format!("no route for {}", path))
+}
+
+fn handle(
+    mut stream: TcpStream,
+    state: &Mutex<LiveState>,
+    hub: &Hub,
+    labels: &Labels,
+) -> io::Result<()> {
+    let request = read_request(&stream)?;
+    if request.target == "/stream" {
+        if !is_upgrade(&request) {
+            return respond(
+                &mut stream,
+                "426 Upgrade Required",
+                "application/json",
+                "{\"error\":\"/stream is a WebSocket endpoint\"}\n",
+            );
+        }
+        accept(&mut stream, &request)?;
+        hub.add(stream);
+        return Ok(());
+    }
+    if request.method != "GET" {
+        return respond(
+            &mut stream,
+            "405 Method Not Allowed",
+            "application/json",
+            "{\"error\":\"only GET is supported\"}\n",
+        );
+    }
+    let (status, body) = match state.lock() {
+        Ok(state) => route(&state, &request.target, labels),
+        Err(_) => (
+            "500 Internal Server Error",
+            json!({ "error": "sampler failed" }),
+        ),
+    };
+    respond(
+        &mut stream,
+        status,
+        "application/json",
+        &format!("{}\n", body),
+    )
+}
+
+/// Sample every `interval` until `running` is cleared, publishing each
+/// interval to `state` and to any stream clients.
+pub fn run_sampler(
+    path: &str,
+    mut tracker: MountTracker,
+    interval: Duration,
+    state: &Mutex<LiveState>,
+    hub: &Hub,
+    labels: &Labels,
+    running: &AtomicBool,
+) -> Result<()> {
+    tracker.observe(parse_mountstats(path)?, interval.as_secs_f64());
+    let mut last = Instant::now();
+    while running.load(Ordering::SeqCst) {
+        thread::sleep(interval);
+        let secs = last.elapsed().as_secs_f64();
+        last = Instant::now();
+        let update = tracker.observe(parse_mountstats(path)?, secs);
+        display_mount_events(&mut io::stderr(), &update.events)?;
+        let latest = match state.lock() {
+            Ok(mut state) => {
+                state.update(Utc::now(), secs, update.intervals);
+                state.latest_json(labels)
+            }
+            Err(_) => None,
+        };
+        if let Some(latest) = latest.filter(|_| !hub.is_empty()) {
+            hub.broadcast(&latest.to_string());
+        }
+    }
+    Ok(())
+}
+
+/// Run the sampler and the API until `running` is cleared. Requests are
+/// handled one at a time.
+pub fn run_serve(
+    args: &ServeArgs,
+    path: &str,
+    tracker: MountTracker,
+    labels: &Labels,
+    running: &AtomicBool,
+) -> Result<()> {
+    let listener = TcpListener::bind(args.listen)?;
+    listener.set_nonblocking(true)?;
+    let state = Arc::new(Mutex::new(LiveState::default()));
+    let hub = Hub::default();
+    let interval = Duration::from_secs(args.interval.max(1));
+
+    thread::scope(|scope| {
+        let sampler = scope.spawn(|| {
+            let result = run_sampler(path, tracker, interval, &state, &hub, labels, running);
+            running.store(false, Ordering::SeqCst);
+            result
+        });
+        while running.load(Ordering::SeqCst) {
+            match listener.accept() {
+                Ok((stream, _)) => {
+                    stream.set_nonblocking(false)?;
+                    if let Err(e) = handle(stream, &state, &hub, labels) {
+                        eprintln!("Warning: API request failed: {}", e);
+                    }
+                }
+                Err(e) if e.kind() == io::ErrorKind::WouldBlock => {
+                    thread::sleep(Duration::from_millis(50));
+                }
+                Err(e) => {
+                    running.store(false, Ordering::SeqCst);
+                    return Err(e.into());
+                }
+            }
+        }
+        sampler.join().unwrap_or(Ok(()))
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::testutil::mount;
+    use chrono::TimeZone;
+
+    #[test]
+    fn test_percent_decode() {
+        assert_eq!(percent_decode("%2Fmnt%2Fdata"), "/mnt/data");
+        assert_eq!(percent_decode("with%20space"), "with space");
+        assert_eq!(percent_decode("bad%zz%2"), "bad%zz%2");
+        assert_eq!(percent_decode("%é"), "%é");
+    }
+
+    #[test]
+    fn test_route() {
+        let labels = Labels::new();
+        let mut state = LiveState::default();
+        assert_eq!(
+            route(&state, "/intervals/latest", &labels).0,
+            "404 Not Found"
+        );
+
+        let ts = Utc.with_ymd_and_hms(2024, 3, 1, 0, 0, 0).unwrap();
+        state.update(
+            ts,
+            2.0,
+            vec![MountInterval {
+                mount: mount("/mnt/data"),
+                stats: vec![stat("READ", 10, 1.0)],
+            }],
+        );
+
+        let (status, body) = route(&state, "/mounts", &labels);
+        assert_eq!(status, "200 OK");
+        assert_eq!(body[0]["mount_point"], "/mnt/data");
+
+        for target in ["/mounts/%2Fmnt%2Fdata/ops", "/mounts/mnt/data/ops"] {
+            let (status, body) = route(&state, target, &labels);
+            assert_eq!(status, "200 OK", "{}", target);
+            assert_eq!(body["operations"][0]["ops"], 10);
+        }
+        assert_eq!(
+            route(&state, "/mounts/mnt/other/ops", &labels).0,
+            "404 Not Found"
+        );
+
+        let (_, body) = route(&state, "/intervals/latest?pretty", &labels);
+        assert_eq!(body["timestamp"], "2024-03-01T00:00:00.000Z");
+        assert_eq!(body["mounts"][0]["interval_secs"], 2.0);
+        assert_eq!(route(&state, "/nope", &labels).0, "404 Not Found");
+    }
+}
@@ -0,0 +1,208 @@
+//! Treat several hostnames/addresses of one filer as a single logical
+//! server for aggregation and reporting.
+//!
+//! Mapping file format, one group per line:
+//!
+//! ```text
+//! # logical name = aliases
+//! filer01 = filer01-a.example.com, filer01-b.example.com, 10.0.0.5
+//! ```
+
+use crate::aggregate::merge_by_operation;
+use crate::display::{format_duration, format_rate};
+use crate::types::{DeltaStats, NfsGazeError, Result};
+use clap::Args;
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct ServerGroupArgs {
+    /// File mapping server aliases to logical server names
+    #[arg(long = "server-groups")]
+    pub server_groups: Option<String>,
+
+    /// Inline group definition, e.g. filer01=10.0.0.5,10.0.0.6 (repeatable)
+    #[arg(long = "server-group")]
+    pub server_group: Vec<String>,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct ServerGroups {
+    /// Lower-cased alias → logical name.
+    aliases: HashMap<String, String>,
+}
+
+impl ServerGroups {
+    /// Parse the mapping format. Aliases are matched case-insensitively.
+    pub fn parse(contents: &str) -> Result<Self> {
+        let mut groups = Self::default();
+        for (lineno, line) in contents.lines().enumerate() {
+            let line = line.split('#').next().unwrap_or("").trim();
+            if line.is_empty() {
+                continue;
+            }
+            groups.add_definition(line).map_err(|e| {
+                NfsGazeError::ParseError(format!("server groups line {}: {}", lineno + 1, e))
+            })?;
+        }
+        Ok(groups)
+    }
+
+    /// Add one `logical = alias, alias` definition.
+    pub fn add_definition(&mut self, definition: &str) -> std::result::Result<(), String> {
+        let (logical, aliases) = definition
+            .split_once('=')
+            .ok_or_else(|| format!("expected name=aliases, got {:?}", definition))?;
+        let logical = logical.trim();
+        if logical.is_empty() {
+            return Err("empty logical server name".to_string());
+        }
+        for alias in aliases.split(',').map(str::trim).filter(|a| !a.is_empty()) {
+            if let Some(existing) = self.aliases.get(&alias.to_lowercase()) {
+                if existing != logical {
+                    return Err(format!("{} already belongs to {}", alias, existing));
+                }
+            }
+            self.aliases
+                .insert(alias.to_lowercase(), logical.to_string());
+        }
+        // The logical name always maps to itself.
+        self.aliases
+            .insert(logical.to_lowercase(), logical.to_string());
+        Ok(())
+    }
+
+    pub fn from_args(args: &ServerGroupArgs) -> Result<Self> {
+        let mut groups = match &args.server_groups {
+            Some(path) => Self::parse(&fs::read_to_string(path)?)?,
+            None => Self::default(),
+        };
+        for definition in &args.server_group {
+            groups
+                .add_definition(definition)
+                .map_err(NfsGazeError::ParseError)?;
+        }
+        Ok(groups)
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.aliases.is_empty()
+    }
+
+    /// Logical name for `server`, or the server itself if ungrouped.
+    pub fn logical_name<'a>(&'a self, server: &'a str) -> &'a str {
+        self.aliases
+            .get(&server.to_lowercase())
+            .map(String::as_str)
+            .unwrap_or(server)
+    }
+
+    /// Merge per-mount interval stats into per-logical-server stats.
+    /// `stats` pairs each mount's server with its DeltaStats.
+    pub fn aggregate<'a, I>(&self, stats: I) -> BTreeMap<String, Vec<DeltaStats>>
+    where
+        I: IntoIterator<Item = (&'a str, &'a [DeltaStats])>,
+    {
+        let mut by_server: BTreeMap<String, Vec<&[DeltaStats]>> = BTreeMap::new();
+        for (server, delta) in stats {
+            by_server
+                .entry(self.logical_name(server).to_string())
+                .or_default()
+                .push(delta);
+        }
+        by_server
+            .into_iter()
+            .map(|(server, sources)| (server, merge_by_operation(sources)))
+            .collect()
+    }
+}
+
+/// One table per logical server, for servers with traffic.
+pub fn display_server_groups<W: Write>(
+    writer: &mut W,
+    by_server: &BTreeMap<String, Vec<DeltaStats>>,
+) -> io::Result<()> {
+    for (server, stats) in by_server {
+        let stats: Vec<_> = stats.iter().filter(|s| s.delta_ops > 0).collect();
+        if stats.is_empty() {
+            continue;
+        }
+        writeln!(writer, "Server {}", server)?;
+        writeln!(
+            writer,
+            "{:<14} {:>10} {:>10} {:>10}",
+            "OP", "IOPS", "RTT", "EXEC"
+        )?;
+        writeln!(writer, "{}", "-".repeat(58))?;
+        for stat in stats {
+            writeln!(
+                writer,
+                "{:<14} {:>10} {:>10} {:>10}",
+                stat.operation,
+                format_rate(stat.iops),
+                format_duration((stat.avg_rtt * 1000.0).round() as i64),
+                format_duration((stat.avg_exec * 1000.0).round() as i64)
+            )?;
+        }
+        writeln!(writer)?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_parse_groups() {
+        let groups = ServerGroups::parse(
+            "# filers\nfiler01 = filer01-a, FILER01-B ,10.0.0.5\n\nfiler02=10.0.0.9 # dr site\n",
+        )
+        .unwrap();
+        assert_eq!(groups.logical_name("filer01-b"), "filer01");
+        assert_eq!(groups.logical_name("10.0.0.5"), "filer01");
+        assert_eq!(groups.logical_name("filer01"), "filer01");
+        assert_eq!(groups.logical_name("10.0.0.9"), "filer02");
+        assert_eq!(groups.logical_name("other"), "other");
+
+        assert!(ServerGroups::parse("no equals sign").is_err());
+        assert!(ServerGroups::parse("a = x\nb = x\n").is_err());
+    }
+
+    #[test]
+    fn test_aggregate() {
+        let mut groups = ServerGroups::default();
+        groups.add_definition("filer=10.0.0.5,10.0.0.6").unwrap();
+
+        let a = vec![stat("READ", 100, 2.0)];
+        let b = vec![stat("READ", 100, 4.0)];
+        let c = vec![stat("READ", 10, 1.0)];
+        let merged = groups.aggregate([
+            ("10.0.0.5", a.as_slice()),
+            ("10.0.0.6", b.as_slice()),
+            ("other", c.as_slice()),
+        ]);
+
+        assert_eq!(merged.len(), 2);
+        let filer = &merged["filer"];
+        assert_eq!(filer[0].delta_ops, 200);
+        assert!((filer[0].avg_rtt - 3.0).abs() < 1e-9);
+        assert_eq!(merged["other"][0].delta_ops, 10);
+    }
+
+    #[test]
+    fn test_display_server_groups() {
+        let mut by_server = BTreeMap::new();
+        by_server.insert("filer".to_string(), vec![stat("READ", 100, 2.0)]);
+        by_server.insert("idle".to_string(), vec![stat("READ", 0, 0.0)]);
+
+        let mut out = Vec::new();
+        display_server_groups(&mut out, &by_server).unwrap();
+        let out = String::from_utf8(out).unwrap();
+        assert!(out.starts_with("Server filer\n"));
+        assert!(out.contains("2.0ms"));
+        assert!(!out.contains("idle"));
+    }
+}
@@ -0,0 +1,302 @@
+//! Whole-run accumulation of per-interval statistics, used by the
+//! end-of-run report and summaries.
+
+use crate::labels::Labels;
+use crate::quantile::Quantiles;
+use crate::types::DeltaStats;
+use crate::xprt::NFSTransport;
+use chrono::{DateTime, Utc};
+use std::collections::BTreeMap;
+
+/// Running totals and peaks for one operation over the session.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct OpTotals {
+    pub ops: i64,
+    pub bytes: i64,
+    pub rtt: i64,
+    pub exec: i64,
+    pub errors: i64,
+    pub retrans: i64,
+    /// Intervals this operation appeared in.
+    pub intervals: u64,
+    pub min_iops: f64,
+    pub peak_iops: f64,
+    /// Lowest and highest per-interval averages, over intervals where the
+    /// operation ran; an idle interval has no latency to speak of.
+    pub min_avg_rtt: f64,
+    pub peak_avg_rtt: f64,
+    pub min_avg_exec: f64,
+    pub peak_avg_exec: f64,
+    /// Distribution of the per-interval average RTT and execute time, over
+    /// intervals where the operation ran.
+    pub rtt_quantiles: Quantiles,
+    pub exec_quantiles: Quantiles,
+}
+
+impl OpTotals {
+    fn add(&mut self, stat: &DeltaStats) {
+        self.ops += stat.delta_ops;
+        self.bytes += stat.delta_bytes;
+        self.rtt += stat.delta_rtt;
+        self.exec += stat.delta_exec;
+        self.errors += stat.delta_errors;
+        self.retrans += stat.delta_retrans;
+        self.min_iops = if self.intervals == 0 {
+            stat.iops
+        } else {
+            self.min_iops.min(stat.iops)
+        };
+        self.intervals += 1;
+        self.peak_iops = self.peak_iops.max(stat.iops);
+        if stat.delta_ops > 0 {
+            if self.rtt_quantiles.is_empty() {
+                self.min_avg_rtt = stat.avg_rtt;
+                self.min_avg_exec = stat.avg_exec;
+            }
+            self.min_avg_rtt = self.min_avg_rtt.min(stat.avg_rtt);
+            self.min_avg_exec = self.min_avg_exec.min(stat.avg_exec);
+            self.peak_avg_rtt = self.peak_avg_rtt.max(stat.avg_rtt);
+            self.peak_avg_exec = self.peak_avg_exec.max(stat.avg_exec);
+            self.rtt_quantiles.record(stat.avg_rtt);
+            self.exec_quantiles.record(stat.avg_exec);
+        }
+    }
+
+    fn per_op(&self, total: i64) -> f64 {
+        if self.ops > 0 {
+            total as f64 / self.ops as f64
+        } else {
+            0.0
+        }
+    }
+
+    pub fn avg_rtt(&self) -> f64 {
+        self.per_op(self.rtt)
+    }
+
+    pub fn avg_exec(&self) -> f64 {
+        self.per_op(self.exec)
+    }
+
+    pub fn kb_per_op(&self) -> f64 {
+        self.per_op(self.bytes) / 1024.0
+    }
+}
+
+/// Whole-mount figures for a single interval.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct IntervalSample {
+    pub iops: f64,
+    pub retrans: f64,
+    /// Op-weighted average RTT and execute time across all operations.
+    pub avg_rtt: f64,
+    pub avg_exec: f64,
+    /// Worst per-op average RTT.
+    pub worst_rtt: f64,
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct MountSession {
+    pub mount_point: String,
+    pub intervals: u64,
+    pub elapsed_secs: f64,
+    /// Intervals where retransmissions happened.
+    pub retrans_intervals: u64,
+    /// Intervals where RPCs were sent but no replies came back; see
+    /// [`Session::record_transport`].
+    pub stalled_intervals: u64,
+    /// Replies that matched no outstanding request, usually answers to
+    /// requests that had already been retransmitted.
+    pub bad_xids: u64,
+    pub peak_iops: f64,
+    pub ops: BTreeMap<String, OpTotals>,
+    /// One point per interval, in order.
+    pub samples: Vec<IntervalSample>,
+}
+
+impl MountSession {
+    pub fn total_ops(&self) -> i64 {
+        self.ops.values().map(|o| o.ops).sum()
+    }
+
+    pub fn total_retrans(&self) -> i64 {
+        self.ops.values().map(|o| o.retrans).sum()
+    }
+
+    pub fn avg_iops(&self) -> f64 {
+        if self.elapsed_secs > 0.0 {
+            self.total_ops() as f64 / self.elapsed_secs
+        } else {
+            0.0
+        }
+    }
+
+    /// One field of every interval sample, e.g. `mount.series(|s| s.iops)`.
+    pub fn series(&self, field: impl Fn(&IntervalSample) -> f64) -> Vec<f64> {
+        self.samples.iter().map(field).collect()
+    }
+
+    /// Share of all operations that were `operation`, in percent.
+    pub fn op_share(&self, operation: &str) -> f64 {
+        let total = self.total_ops();
+        match self.ops.get(operation) {
+            Some(op) if total > 0 => op.ops as f64 * 100.0 / total as f64,
+            _ => 0.0,
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct Session {
+    pub started: DateTime<Utc>,
+    pub ended: DateTime<Utc>,
+    pub mounts: BTreeMap<String, MountSession>,
+    /// Static labels shown in reports built from this session.
+    pub labels: Labels,
+}
+
+impl Session {
+    pub fn new(started: DateTime<Utc>) -> Self {
+        Self {
+            started,
+            ended: started,
+            mounts: BTreeMap::new(),
+            labels: Labels::new(),
+        }
+    }
+
+    /// Add one interval's delta rows for `mount_point`.
+    pub fn record(
+        &mut self,
+        mount_point: &str,
+        timestamp: DateTime<Utc>,
+        interval_secs: f64,
+        stats: &[DeltaStats],
+    ) {
+        self.ended = self.ended.max(timestamp);
+        let mount = self.mount_mut(mount_point);
+
+        mount.intervals += 1;
+        mount.elapsed_secs += interval_secs;
+        let ops: i64 = stats.iter().map(|s| s.delta_ops).sum();
+        let retrans: i64 = stats.iter().map(|s| s.delta_retrans).sum();
+        if retrans > 0 {
+            mount.retrans_intervals += 1;
+        }
+        let iops: f64 = stats.iter().map(|s| s.iops).sum();
+        let per_op = |total: i64| {
+            if ops > 0 {
+                total as f64 / ops as f64
+            } else {
+                0.0
+            }
+        };
+        mount.peak_iops = mount.peak_iops.max(iops);
+        mount.samples.push(IntervalSample {
+            iops,
+            retrans: retrans as f64,
+            avg_rtt: per_op(stats.iter().map(|s| s.delta_rtt).sum()),
+            avg_exec: per_op(stats.iter().map(|s| s.delta_exec).sum()),
+            worst_rtt: stats
+                .iter()
+                .filter(|s| s.delta_ops > 0)
+                .map(|s| s.avg_rtt)
+                .fold(0.0, f64::max),
+        });
+        for stat in stats {
+            mount
+                .ops
+                .entry(stat.operation.clone())
+                .or_default()
+                .add(stat);
+        }
+    }
+
+    /// Add one interval's transport delta for `mount_point`, summed over
+    /// its connections. Stalls are judged here rather than from the per-op
+    /// rows, which only move when a request completes.
+    pub fn record_transport(&mut self, mount_point: &str, delta: &NFSTransport) {
+        let mount = self.mount_mut(mount_point);
+        if delta.is_stalled() {
+            mount.stalled_intervals += 1;
+        }
+        mount.bad_xids += delta.bad_xids;
+    }
+
+    fn mount_mut(&mut self, mount_point: &str) -> &mut MountSession {
+        self.mounts
+            .entry(mount_point.to_string())
+            .or_insert_with(|| MountSession {
+                mount_point: mount_point.to_string(),
+                ..Default::default()
+            })
+    }
+
+    pub fn duration_secs(&self) -> f64 {
+        (self.ended - self.started).num_milliseconds() as f64 / 1000.0
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use chrono::Duration;
+
+    /// Successive `xprt:` samples of one TCP connection: a busy interval,
+    /// one where the server stops answering, and the recovery where the
+    /// retransmitted requests complete and late replies are discarded.
+    const XPRT: [&str; 4] = [
+        "tcp 869 1 1 0 5 1000 1000 0 2000 0 16 100 50",
+        "tcp 869 1 1 0 0 1400 1400 0 2800 0 16 500 90",
+        "tcp 869 1 1 0 0 1406 1400 0 2830 12 16 540 110",
+        "tcp 869 1 1 0 0 1409 1410 2 2850 12 16 560 120",
+    ];
+
+    #[test]
+    fn test_session_accumulates() {
+        let xprt: Vec<_> = XPRT
+            .iter()
+            .map(|line| NFSTransport::parse(line).unwrap())
+            .collect();
+        let mut retransmitted = stat("READ", 10, 2.0);
+        retransmitted.delta_retrans = 3;
+        let intervals = [
+            vec![stat("READ", 100, 2.0), stat("GETATTR", 300, 0.5)],
+            vec![stat("READ", 0, 0.0), stat("GETATTR", 0, 0.0)],
+            vec![retransmitted, stat("GETATTR", 0, 0.0)],
+        ];
+
+        let start = Utc::now();
+        let mut session = Session::new(start);
+        for (i, stats) in intervals.iter().enumerate() {
+            let at = start + Duration::seconds(i as i64 + 1);
+            session.record("/mnt", at, 1.0, stats);
+            session.record_transport("/mnt", &xprt[i + 1].delta(&xprt[i]));
+        }
+
+        let mount = &session.mounts["/mnt"];
+        assert_eq!(mount.intervals, 3);
+        assert_eq!(mount.total_ops(), 410);
+        // Only the middle interval sent without hearing back.
+        assert_eq!(mount.stalled_intervals, 1);
+        assert_eq!(mount.bad_xids, 2);
+        assert_eq!(mount.retrans_intervals, 1);
+        assert_eq!(mount.total_retrans(), 3);
+        assert!((mount.avg_iops() - 410.0 / 3.0).abs() < 1e-9);
+        assert!((mount.op_share("GETATTR") - 300.0 * 100.0 / 410.0).abs() < 1e-9);
+        assert!((mount.ops["READ"].avg_rtt() - 2.0).abs() < 1e-9);
+        assert!((mount.ops["READ"].kb_per_op() - 4.0).abs() < 1e-9);
+        // The stalled interval completed no READs, so it is not a sample.
+        assert_eq!(mount.ops["READ"].rtt_quantiles.count(), 2);
+        assert_eq!(mount.ops["READ"].min_iops, 0.0);
+        assert_eq!(mount.ops["READ"].peak_iops, 100.0);
+        assert_eq!(mount.ops["READ"].min_avg_rtt, 2.0);
+        assert!((session.duration_secs() - 3.0).abs() < 1e-9);
+        assert_eq!(mount.series(|s| s.iops), [400.0, 0.0, 10.0]);
+        assert_eq!(mount.series(|s| s.worst_rtt), [2.0, 0.0, 2.0]);
+        assert_eq!(mount.series(|s| s.retrans), [0.0, 0.0, 3.0]);
+        // (100 * 2.0 + 300 * 0.5) / 400
+        assert!((mount.samples[0].avg_rtt - 0.875).abs() < 1e-9);
+    }
+}
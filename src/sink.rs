@@ -0,0 +1,382 @@
+//! Output sinks. Each interval is handed to every configured sink, so the
+//! console table, JSON lines and a metrics backend can all run at once
+//! (`--sink console,json,graphite`). The Prometheus exporter is pull-based
+//! and runs alongside any sinks via `--listen`.
+
+use crate::columns::{display_columns, Column};
+use crate::graphite::{graphite_lines, GraphiteSender};
+use crate::labels::Labels;
+use crate::output::{interval_json, write_csv_header, write_csv_rows, write_json_record};
+use crate::statsd::{statsd_lines, StatsdArgs, StatsdSender};
+use crate::totals::{display_iostat_block, display_totals, display_totals_iostat};
+use crate::watcher::IntervalStats;
+use clap::{Args, ValueEnum};
+use std::io::{self, Write};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum SinkKind {
+    /// The simple per-op table
+    Console,
+    /// nfsiostat-style per-op blocks
+    Nfsiostat,
+    /// One JSON record per mount per interval
+    Json,
+    /// CSV rows
+    Csv,
+    /// Graphite plaintext (needs --graphite)
+    Graphite,
+    /// statsd over UDP (needs --statsd)
+    Statsd,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct SinkArgs {
+    /// Outputs to write each interval to, e.g. console,json
+    #[arg(long = "sink", value_enum, value_delimiter = ',')]
+    pub sinks: Vec<SinkKind>,
+}
+
+pub trait Sink {
+    /// Name used in warnings.
+    fn name(&self) -> &str;
+
+    fn emit(&mut self, interval: &IntervalStats) -> io::Result<()>;
+}
+
+pub struct ConsoleSink<W: Write> {
+    writer: W,
+    columns: Vec<Column>,
+    human: bool,
+}
+
+impl<W: Write> ConsoleSink<W> {
+    pub fn new(writer: W, columns: Vec<Column>, human: bool) -> Self {
+        Self {
+            writer,
+            columns,
+            human,
+        }
+    }
+}
+
+impl<W: Write> Sink for ConsoleSink<W> {
+    fn name(&self) -> &str {
+        "console"
+    }
+
+    fn emit(&mut self, interval: &IntervalStats) -> io::Result<()> {
+        for m in &interval.mounts {
+            writeln!(
+                self.writer,
+                "{} mounted on {}",
+                m.mount.device, m.mount.mount_point
+            )?;
+            writeln!(
+                self.writer,
+                "Timestamp: {}",
+                interval.timestamp.format("%Y-%m-%d %H:%M:%S UTC")
+            )?;
+            writeln!(self.writer)?;
+            display_columns(&mut self.writer, &m.stats, &self.columns, self.human)?;
+            display_totals(&mut self.writer, &m.stats)?;
+        }
+        self.writer.flush()
+    }
+}
+
+pub struct IostatSink<W: Write> {
+    writer: W,
+}
+
+impl<W: Write> IostatSink<W> {
+    pub fn new(writer: W) -> Self {
+        Self { writer }
+    }
+}
+
+impl<W: Write> Sink for IostatSink<W> {
+    fn name(&self) -> &str {
+        "nfsiostat"
+    }
+
+    fn emit(&mut self, interval: &IntervalStats) -> io::Result<()> {
+        for m in &interval.mounts {
+            writeln!(
+                self.writer,
+                "{} mounted on {}:",
+                m.mount.device, m.mount.mount_point
+            )?;
+            writeln!(self.writer)?;
+            for stat in &m.stats {
+                display_iostat_block(
+                    &mut self.writer,
+                    &format!("{}:", stat.operation.to_lowercase()),
+                    stat,
+                )?;
+            }
+            display_totals_iostat(&mut self.writer, &m.stats)?;
+            writeln!(self.writer)?;
+        }
+        self.writer.flush()
+    }
+}
+
+pub struct JsonSink<W: Write> {
+    writer: W,
+    labels: Labels,
+}
+
+impl<W: Write> JsonSink<W> {
+    pub fn new(writer: W, labels: Labels) -> Self {
+        Self { writer, labels }
+    }
+}
+
+impl<W: Write> Sink for JsonSink<W> {
+    fn name(&self) -> &str {
+        "json"
+    }
+
+    fn emit(&mut self, interval: &IntervalStats) -> io::Result<()> {
+        for m in &interval.mounts {
+            let record = interval_json(
+                &m.mount,
+                interval.timestamp,
+                interval.interval_secs,
+                &m.stats,
+                &self.labels,
+            );
+            write_json_record(&mut self.writer, &record)?;
+        }
+        Ok(())
+    }
+}
+
+pub struct CsvSink<W: Write> {
+    writer: W,
+    header_written: bool,
+}
+
+impl<W: Write> CsvSink<W> {
+    pub fn new(writer: W) -> Self {
+        Self {
+            writer,
+            header_written: false,
+        }
+    }
+}
+
+impl<W: Write> Sink for CsvSink<W> {
+    fn name(&self) -> &str {
+        "csv"
+    }
+
+    fn emit(&mut self, interval: &IntervalStats) -> io::Result<()> {
+        if !self.header_written {
+            write_csv_header(&mut self.writer)?;
+            self.header_written = true;
+        }
+        for m in &interval.mounts {
+            write_csv_rows(
+                &mut self.writer,
+                &m.mount.mount_point,
+                interval.timestamp,
+                &m.stats,
+            )?;
+        }
+        Ok(())
+    }
+}
+
+pub struct GraphiteSink {
+    sender: GraphiteSender,
+    prefix: String,
+    labels: Labels,
+}
+
+impl GraphiteSink {
+    pub fn new(addr: &str, prefix: &str, labels: Labels) -> Self {
+        Self {
+            sender: GraphiteSender::new(addr),
+            prefix: prefix.to_string(),
+            labels,
+        }
+    }
+}
+
+impl Sink for GraphiteSink {
+    fn name(&self) -> &str {
+        "graphite"
+    }
+
+    fn emit(&mut self, interval: &IntervalStats) -> io::Result<()> {
+        let lines: Vec<String> = interval
+            .mounts
+            .iter()
+            .flat_map(|m| {
+                graphite_lines(
+                    &self.prefix,
+                    &m.mount.mount_point,
+                    interval.timestamp,
+                    &m.stats,
+                    &self.labels,
+                )
+            })
+            .collect();
+        self.sender.send(&lines)
+    }
+}
+
+pub struct StatsdSink {
+    sender: StatsdSender,
+    args: StatsdArgs,
+    labels: Labels,
+}
+
+impl StatsdSink {
+    pub fn new(args: &StatsdArgs, addr: &str, labels: Labels) -> io::Result<Self> {
+        Ok(Self {
+            sender: StatsdSender::new(addr)?,
+            args: args.clone(),
+            labels,
+        })
+    }
+}
+
+impl Sink for StatsdSink {
+    fn name(&self) -> &str {
+        "statsd"
+    }
+
+    fn emit(&mut self, interval: &IntervalStats) -> io::Result<()> {
+        let lines: Vec<String> = interval
+            .mounts
+            .iter()
+            .flat_map(|m| {
+                statsd_lines(
+                    &self.args,
+                    &m.mount.mount_point,
+                    &m.mount.server,
+                    &m.stats,
+                    &self.labels,
+                )
+            })
+            .collect();
+        self.sender.send(&lines)
+    }
+}
+
+/// Every configured sink. A failing sink is reported and skipped for
+/// that interval; it does not stop the others.
+#[derive(Default)]
+pub struct Sinks {
+    sinks: Vec<Box<dyn Sink + Send>>,
+}
+
+impl Sinks {
+    pub fn add(&mut self, sink: Box<dyn Sink + Send>) {
+        self.sinks.push(sink);
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.sinks.is_empty()
+    }
+
+    /// Emit to every sink, returning the failures by sink name.
+    pub fn emit(&mut self, interval: &IntervalStats) -> Vec<(String, io::Error)> {
+        self.sinks
+            .iter_mut()
+            .filter_map(|sink| {
+                sink.emit(interval)
+                    .err()
+                    .map(|e| (sink.name().to_string(), e))
+            })
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::columns::DEFAULT_COLUMNS;
+    use crate::testutil::mount;
+    use crate::tracker::MountInterval;
+    use chrono::{TimeZone, Utc};
+    use std::sync::{Arc, Mutex};
+
+    /// A writer whose contents stay readable after it is moved into a sink.
+    #[derive(Clone, Default)]
+    struct Shared(Arc<Mutex<Vec<u8>>>);
+
+    impl Write for Shared {
+        fn write(&mut self, buf: &[u8]) -> io::Result<usize> {
+            self.0.lock().unwrap().extend_from_slice(buf);
+            Ok(buf.len())
+        }
+
+        fn flush(&mut self) -> io::Result<()> {
+            Ok(())
+        }
+    }
+
+    impl Shared {
+        fn text(&self) -> String {
+            String::from_utf8(self.0.lock().unwrap().clone()).unwrap()
+        }
+    }
+
+    struct Failing;
+
+    impl Sink for Failing {
+        fn name(&self) -> &str {
+            "failing"
+        }
+
+        fn emit(&mut self, _: &IntervalStats) -> io::Result<()> {
+            Err(io::Error::other("down"))
+        }
+    }
+
+    #[test]
+    fn test_sinks_fan_out() {
+        let interval = IntervalStats {
+            timestamp: Utc.with_ymd_and_hms(2024, 3, 1, 0, 0, 0).unwrap(),
+            interval_secs: 1.0,
+            mounts: vec![MountInterval {
+                mount: mount("/mnt/a"),
+                stats: vec![stat("READ", 10, 1.0)],
+            }],
+            events: Vec::new(),
+        };
+        let (console, iostat, json, csv) = (
+            Shared::default(),
+            Shared::default(),
+            Shared::default(),
+            Shared::default(),
+        );
+
+        let mut sinks = Sinks::default();
+        sinks.add(Box::new(ConsoleSink::new(
+            console.clone(),
+            DEFAULT_COLUMNS.to_vec(),
+            false,
+        )));
+        sinks.add(Box::new(Failing));
+        sinks.add(Box::new(IostatSink::new(iostat.clone())));
+        sinks.add(Box::new(JsonSink::new(json.clone(), Labels::new())));
+        sinks.add(Box::new(CsvSink::new(csv.clone())));
+
+        let failures = sinks.emit(&interval);
+        assert_eq!(failures.len(), 1);
+        assert_eq!(failures[0].0, "failing");
+        sinks.emit(&interval);
+
+        assert!(console.text().contains("TOTAL: 10.0 IOPS"));
+        assert!(iostat.text().contains("read:"));
+        assert!(iostat.text().contains("total:"));
+        assert_eq!(json.text().lines().count(), 2);
+        // The CSV header is written once.
+        assert_eq!(csv.text().lines().count(), 3);
+    }
+}
@@ -0,0 +1,254 @@
+//! `--window N`: smoothed rates over the last N intervals, shown next to
+//! the instantaneous values so 1s sampling of bursty workloads stays
+//! readable without hiding the bursts.
+
+use crate::aggregate::merge_by_operation;
+use crate::columns::Column;
+use crate::types::DeltaStats;
+use clap::{Args, ValueEnum};
+use std::collections::{HashMap, VecDeque};
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct SmoothArgs {
+    /// Show rates smoothed over the last N intervals next to the instantaneous values
+    #[arg(long = "window", value_name = "N", value_parser = clap::value_parser!(u32).range(2..))]
+    pub window: Option<u32>,
+
+    /// How --window smooths: a simple moving average or an exponential one
+    #[arg(long = "smoothing", value_enum, default_value = "simple")]
+    pub smoothing: Smoothing,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum Smoothing {
+    Simple,
+    Exponential,
+}
+
+#[derive(Debug, Default)]
+struct MountWindow {
+    /// Simple: the last N intervals as (seconds, rows).
+    history: VecDeque<(f64, Vec<DeltaStats>)>,
+    /// Exponential: the previous smoothed row per operation.
+    previous: HashMap<String, DeltaStats>,
+}
+
+pub struct Smoother {
+    window: usize,
+    smoothing: Smoothing,
+    mounts: HashMap<String, MountWindow>,
+}
+
+impl Smoother {
+    pub fn new(window: u32, smoothing: Smoothing) -> Self {
+        Self {
+            window: window.max(1) as usize,
+            smoothing,
+            mounts: HashMap::new(),
+        }
+    }
+
+    pub fn from_args(args: &SmoothArgs) -> Option<Self> {
+        args.window.map(|n| Self::new(n, args.smoothing))
+    }
+
+    pub fn window(&self) -> usize {
+        self.window
+    }
+
+    /// Add one interval for `mount_point` and return smoothed rows in the
+    /// same order as `stats`. Rates and averages are smoothed; the delta
+    /// counts stay those of the latest interval.
+    pub fn smooth(
+        &mut self,
+        mount_point: &str,
+        interval_secs: f64,
+        stats: &[DeltaStats],
+    ) -> Vec<DeltaStats> {
+        let state = self.mounts.entry(mount_point.to_string()).or_default();
+        match self.smoothing {
+            Smoothing::Simple => {
+                state.history.push_back((interval_secs, stats.to_vec()));
+                while state.history.len() > self.window {
+                    state.history.pop_front();
+                }
+                simple(&state.history, stats)
+            }
+            Smoothing::Exponential => {
+                let alpha = 2.0 / (self.window as f64 + 1.0);
+                stats
+                    .iter()
+                    .map(|stat| {
+                        let smoothed = match state.previous.get(&stat.operation) {
+                            Some(prev) => exponential(prev, stat, alpha),
+                            None => stat.clone(),
+                        };
+                        state
+                            .previous
+                            .insert(stat.operation.clone(), smoothed.clone());
+                        smoothed
+                    })
+                    .collect()
+            }
+        }
+    }
+
+    /// Forget a mount's history, e.g. after it was remounted.
+    pub fn reset(&mut self, mount_point: &str) {
+        self.mounts.remove(mount_point);
+    }
+}
+
+/// Totals over the window divided by its length, so a quiet interval and
+/// a busy one are weighted by what actually happened in them.
+fn simple(history: &VecDeque<(f64, Vec<DeltaStats>)>, latest: &[DeltaStats]) -> Vec<DeltaStats> {
+    let secs: f64 = history.iter().map(|(secs, _)| secs).sum();
+    let merged = merge_by_operation(history.iter().map(|(_, rows)| rows.as_slice()));
+    let by_op: HashMap<&str, &DeltaStats> =
+        merged.iter().map(|s| (s.operation.as_str(), s)).collect();
+    latest
+        .iter()
+        .map(|stat| {
+            let mut smoothed = stat.clone();
+            if let Some(window) = by_op.get(stat.operation.as_str()) {
+                if secs > 0.0 {
+                    smoothed.iops = window.delta_ops as f64 / secs;
+                    smoothed.kb_per_sec = window.delta_bytes as f64 / 1024.0 / secs;
+                }
+                smoothed.avg_rtt = window.avg_rtt;
+                smoothed.avg_exec = window.avg_exec;
+                smoothed.avg_queue = window.avg_queue;
+                smoothed.kb_per_op = window.kb_per_op;
+            }
+            smoothed
+        })
+        .collect()
+}
+
+/// Exponentially weighted update. Latency averages only move on intervals
+/// where the operation ran, so idle gaps do not drag them towards zero.
+fn exponential(prev: &DeltaStats, stat: &DeltaStats, alpha: f64) -> DeltaStats {
+    let blend = |old: f64, new: f64| alpha * new + (1.0 - alpha) * old;
+    let mut smoothed = stat.clone();
+    smoothed.iops = blend(prev.iops, stat.iops);
+    smoothed.kb_per_sec = blend(prev.kb_per_sec, stat.kb_per_sec);
+    if stat.delta_ops > 0 {
+        smoothed.avg_rtt = blend(prev.avg_rtt, stat.avg_rtt);
+        smoothed.avg_exec = blend(prev.avg_exec, stat.avg_exec);
+        smoothed.avg_queue = blend(prev.avg_queue, stat.avg_queue);
+        smoothed.kb_per_op = blend(prev.kb_per_op, stat.kb_per_op);
+    } else {
+        smoothed.avg_rtt = prev.avg_rtt;
+        smoothed.avg_exec = prev.avg_exec;
+        smoothed.avg_queue = prev.avg_queue;
+        smoothed.kb_per_op = prev.kb_per_op;
+    }
+    smoothed
+}
+
+/// Counters are per interval by nature; only rates and averages get a
+/// smoothed companion column.
+fn smoothable(column: Column) -> bool {
+    !matches!(column, Column::Ops | Column::Retrans | Column::Errors)
+}
+
+/// Like `display_columns`, with a `~` column after each rate showing its
+/// smoothed value. `smoothed` must be in the same order as `stats`.
+pub fn display_smoothed<W: Write>(
+    writer: &mut W,
+    stats: &[DeltaStats],
+    smoothed: &[DeltaStats],
+    columns: &[Column],
+    human: bool,
+) -> io::Result<()> {
+    if stats.is_empty() {
+        return Ok(());
+    }
+    let mut width = 14;
+    write!(writer, "{:<14}", "OP")?;
+    for &column in columns {
+        write!(writer, " {:>10}", column.header())?;
+        width += 11;
+        if smoothable(column) {
+            write!(writer, " {:>10}", format!("~{}", column.header()))?;
+            width += 11;
+        }
+    }
+    writeln!(writer)?;
+    writeln!(writer, "{}", "-".repeat(width))?;
+    for (stat, smooth) in stats.iter().zip(smoothed) {
+        write!(writer, "{:<14}", stat.operation)?;
+        for &column in columns {
+            write!(writer, " {:>10}", column.value(stat, human))?;
+            if smoothable(column) {
+                write!(writer, " {:>10}", column.value(smooth, human))?;
+            }
+        }
+        writeln!(writer)?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_simple_window() {
+        let mut smoother = Smoother::new(3, Smoothing::Simple);
+        smoother.smooth("/mnt", 1.0, &[stat("READ", 100, 2.0)]);
+        smoother.smooth("/mnt", 1.0, &[stat("READ", 0, 0.0)]);
+        let rows = smoother.smooth("/mnt", 1.0, &[stat("READ", 200, 5.0)]);
+        // 300 ops over 3s; RTT weighted by ops: (100*2 + 200*5) / 300.
+        assert!((rows[0].iops - 100.0).abs() < 1e-9);
+        assert!((rows[0].avg_rtt - 4.0).abs() < 1e-9);
+        assert_eq!(rows[0].delta_ops, 200);
+
+        // The first interval has now fallen out of the window.
+        let rows = smoother.smooth("/mnt", 1.0, &[stat("READ", 0, 0.0)]);
+        assert!((rows[0].iops - 200.0 / 3.0).abs() < 1e-9);
+        assert!((rows[0].avg_rtt - 5.0).abs() < 1e-9);
+    }
+
+    #[test]
+    fn test_exponential() {
+        // alpha = 2 / (3 + 1) = 0.5
+        let mut smoother = Smoother::new(3, Smoothing::Exponential);
+        smoother.smooth("/mnt", 1.0, &[stat("READ", 100, 2.0)]);
+        let rows = smoother.smooth("/mnt", 1.0, &[stat("READ", 300, 4.0)]);
+        assert!((rows[0].iops - 200.0).abs() < 1e-9);
+        assert!((rows[0].avg_rtt - 3.0).abs() < 1e-9);
+
+        let rows = smoother.smooth("/mnt", 1.0, &[stat("READ", 0, 0.0)]);
+        assert!((rows[0].iops - 100.0).abs() < 1e-9);
+        assert!((rows[0].avg_rtt - 3.0).abs() < 1e-9);
+
+        smoother.reset("/mnt");
+        let rows = smoother.smooth("/mnt", 1.0, &[stat("READ", 10, 1.0)]);
+        assert!((rows[0].iops - 10.0).abs() < 1e-9);
+    }
+
+    #[test]
+    fn test_display_smoothed() {
+        let stats = [stat("READ", 100, 2.0)];
+        let mut out = Vec::new();
+        display_smoothed(
+            &mut out,
+            &stats,
+            &stats,
+            &[Column::Iops, Column::Errors],
+            false,
+        )
+        .unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let header = text.lines().next().unwrap();
+        assert_eq!(
+            header.split_whitespace().collect::<Vec<_>>(),
+            ["OP", "IOPS", "~IOPS", "ERRORS"]
+        );
+        assert_eq!(text.lines().nth(1).unwrap().len(), 14 + 33);
+    }
+}
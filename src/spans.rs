@@ -0,0 +1,129 @@
+//! OpenTelemetry trace spans for slow intervals: one span per mount per
+//! interval, with a child span for each operation over the threshold.
+//!
+//! Spans go to the global tracer provider, so they land in whichever
+//! backend the OpenTelemetry setup exports to.
+
+use crate::types::DeltaStats;
+use clap::Args;
+
+#[derive(Args, Debug, Clone)]
+pub struct SpanArgs {
+    /// Emit a trace span for intervals where an operation exceeds --span-threshold-ms
+    #[arg(long = "otel-spans")]
+    pub otel_spans: bool,
+
+    /// Average RTT, in milliseconds, above which an operation counts as slow
+    #[arg(long = "span-threshold-ms", default_value = "50")]
+    pub span_threshold_ms: f64,
+}
+
+/// Operations whose average RTT exceeded `threshold_ms` this interval,
+/// slowest first.
+pub fn slow_ops(stats: &[DeltaStats], threshold_ms: f64) -> Vec<&DeltaStats> {
+    let mut slow: Vec<&DeltaStats> = stats
+        .iter()
+        .filter(|s| s.delta_ops > 0 && s.avg_rtt > threshold_ms)
+        .collect();
+    slow.sort_by(|a, b| {
+        b.avg_rtt
+            .total_cmp(&a.avg_rtt)
+            .then_with(|| a.operation.cmp(&b.operation))
+    });
+    slow
+}
+
+#[cfg(feature = "opentelemetry")]
+pub use emit::emit_interval_span;
+
+#[cfg(feature = "opentelemetry")]
+mod emit {
+    use super::slow_ops;
+    use crate::labels::Labels;
+    use crate::types::DeltaStats;
+    use opentelemetry::trace::{Span, SpanKind, Status, TraceContextExt, Tracer};
+    use opentelemetry::{global, Context, KeyValue};
+    use std::time::{Duration, SystemTime};
+
+    /// Emit a span covering the interval that ended at `end`, if any
+    /// operation was slow. Returns whether a span was emitted.
+    pub fn emit_interval_span(
+        mount_point: &str,
+        server: &str,
+        end: SystemTime,
+        interval: Duration,
+        stats: &[DeltaStats],
+        threshold_ms: f64,
+        labels: &Labels,
+    ) -> bool {
+        let slow = slow_ops(stats, threshold_ms);
+        if slow.is_empty() {
+            return false;
+        }
+        let start = end.checked_sub(interval).unwrap_or(end);
+        let tracer = global::tracer("nfs-gaze");
+
+        let mut attributes = vec![
+            KeyValue::new("nfs.mount_point", mount_point.to_string()),
+            KeyValue::new("nfs.server", server.to_string()),
+            KeyValue::new("nfs.interval_secs", interval.as_secs_f64()),
+            KeyValue::new("nfs.slow_ops", slow.len() as i64),
+            KeyValue::new("nfs.threshold_ms", threshold_ms),
+        ];
+        attributes.extend(
+            labels
+                .iter()
+                .map(|(k, v)| KeyValue::new(k.to_string(), v.to_string())),
+        );
+        let mut parent = tracer
+            .span_builder("nfs.interval")
+            .with_kind(SpanKind::Internal)
+            .with_start_time(start)
+            .with_attributes(attributes)
+            .start(&tracer);
+        parent.set_status(Status::error("NFS latency threshold exceeded"));
+        let cx = Context::current_with_span(parent);
+
+        for stat in slow {
+            let mut child = tracer
+                .span_builder(format!("nfs.{}", stat.operation))
+                .with_kind(SpanKind::Client)
+                .with_start_time(start)
+                .with_attributes(vec![
+                    KeyValue::new("nfs.operation", stat.operation.clone()),
+                    KeyValue::new("nfs.ops", stat.delta_ops),
+                    KeyValue::new("nfs.avg_rtt_ms", stat.avg_rtt),
+                    KeyValue::new("nfs.avg_exec_ms", stat.avg_exec),
+                    KeyValue::new("nfs.avg_queue_ms", stat.avg_queue),
+                    KeyValue::new("nfs.retrans", stat.delta_retrans),
+                    KeyValue::new("nfs.errors", stat.delta_errors),
+                ])
+                .start_with_context(&tracer, &cx);
+            child.end_with_timestamp(end);
+        }
+        cx.span().end_with_timestamp(end);
+        true
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_slow_ops() {
+        let stats = [
+            stat("READ", 10, 80.0),
+            stat("WRITE", 10, 120.0),
+            stat("GETATTR", 10, 1.0),
+            stat("COMMIT", 0, 0.0),
+        ];
+        let slow: Vec<&str> = slow_ops(&stats, 50.0)
+            .iter()
+            .map(|s| s.operation.as_str())
+            .collect();
+        assert_eq!(slow, ["WRITE", "READ"]);
+        assert!(slow_ops(&stats, 500.0).is_empty());
+    }
+}
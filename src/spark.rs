@@ -0,0 +1,213 @@
+//! `--spark`: a unicode sparkline of each operation's recent IOPS or RTT
+//! at the end of its row, for trend context without leaving the table.
+
+use crate::columns::Column;
+use crate::types::DeltaStats;
+use clap::{Args, ValueEnum};
+use std::collections::{HashMap, VecDeque};
+use std::io::{self, Write};
+
+const BARS: [char; 8] = ['▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'];
+
+#[derive(Args, Debug, Clone)]
+pub struct SparkArgs {
+    /// Add a sparkline of recent intervals to each row (iops or rtt)
+    #[arg(
+        long = "spark",
+        value_enum,
+        num_args = 0..=1,
+        default_missing_value = "iops",
+        value_name = "METRIC"
+    )]
+    pub spark: Option<SparkMetric>,
+
+    /// Number of intervals shown in each sparkline
+    #[arg(long = "spark-width", value_name = "N", default_value = "20",
+          value_parser = clap::value_parser!(u32).range(2..))]
+    pub spark_width: u32,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum SparkMetric {
+    Iops,
+    Rtt,
+}
+
+impl SparkMetric {
+    fn value(self, stat: &DeltaStats) -> Option<f64> {
+        match self {
+            SparkMetric::Iops => Some(stat.iops),
+            // An idle interval has no latency; it is drawn as a gap.
+            SparkMetric::Rtt => (stat.delta_ops > 0).then_some(stat.avg_rtt),
+        }
+    }
+
+    fn header(self) -> &'static str {
+        match self {
+            SparkMetric::Iops => "IOPS TREND",
+            SparkMetric::Rtt => "RTT TREND",
+        }
+    }
+}
+
+/// Render `values` scaled from zero to their maximum. Gaps are spaces;
+/// an all-zero series is a flat baseline.
+pub fn sparkline(values: &[Option<f64>]) -> String {
+    let max = values.iter().flatten().fold(0.0_f64, |a, &b| a.max(b));
+    values
+        .iter()
+        .map(|v| match v {
+            None => ' ',
+            Some(_) if max <= 0.0 => BARS[0],
+            Some(v) => {
+                let level = (v.max(0.0) / max * (BARS.len() - 1) as f64).round() as usize;
+                BARS[level.min(BARS.len() - 1)]
+            }
+        })
+        .collect()
+}
+
+/// The last N values of the chosen metric per mount and operation.
+pub struct SparkHistory {
+    metric: SparkMetric,
+    width: usize,
+    mounts: HashMap<String, HashMap<String, VecDeque<Option<f64>>>>,
+}
+
+impl SparkHistory {
+    pub fn new(metric: SparkMetric, width: u32) -> Self {
+        Self {
+            metric,
+            width: width.max(1) as usize,
+            mounts: HashMap::new(),
+        }
+    }
+
+    pub fn from_args(args: &SparkArgs) -> Option<Self> {
+        args.spark.map(|metric| Self::new(metric, args.spark_width))
+    }
+
+    /// Add one interval for `mount_point`. Operations missing from `stats`
+    /// get a gap so every line stays aligned in time.
+    pub fn push(&mut self, mount_point: &str, stats: &[DeltaStats]) {
+        let ops = self.mounts.entry(mount_point.to_string()).or_default();
+        let seen: HashMap<&str, &DeltaStats> =
+            stats.iter().map(|s| (s.operation.as_str(), s)).collect();
+        for (op, history) in ops.iter_mut() {
+            if !seen.contains_key(op.as_str()) {
+                history.push_back(None);
+            }
+        }
+        for stat in stats {
+            ops.entry(stat.operation.clone())
+                .or_default()
+                .push_back(self.metric.value(stat));
+        }
+        for history in ops.values_mut() {
+            while history.len() > self.width {
+                history.pop_front();
+            }
+        }
+    }
+
+    /// The sparkline for one operation, right-aligned to the full width
+    /// until enough intervals have been seen.
+    pub fn line(&self, mount_point: &str, operation: &str) -> String {
+        let values: Vec<Option<f64>> = self
+            .mounts
+            .get(mount_point)
+            .and_then(|ops| ops.get(operation))
+            .map(|h| h.iter().copied().collect())
+            .unwrap_or_default();
+        format!("{:>width$}", sparkline(&values), width = self.width)
+    }
+
+    /// Forget a mount's history, e.g. after it was remounted.
+    pub fn reset(&mut self, mount_point: &str) {
+        self.mounts.remove(mount_point);
+    }
+}
+
+/// Like `display_columns`, with each row's sparkline in a final column.
+pub fn display_with_spark<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    stats: &[DeltaStats],
+    columns: &[Column],
+    history: &SparkHistory,
+    human: bool,
+) -> io::Result<()> {
+    if stats.is_empty() {
+        return Ok(());
+    }
+    let width = history.width.max(history.metric.header().len());
+    write!(writer, "{:<14}", "OP")?;
+    for column in columns {
+        write!(writer, " {:>10}", column.header())?;
+    }
+    writeln!(writer, "  {:<width$}", history.metric.header())?;
+    writeln!(
+        writer,
+        "{}",
+        "-".repeat(14 + 11 * columns.len() + 2 + width)
+    )?;
+    for stat in stats {
+        write!(writer, "{:<14}", stat.operation)?;
+        for column in columns {
+            write!(writer, " {:>10}", column.value(stat, human))?;
+        }
+        writeln!(writer, "  {}", history.line(mount_point, &stat.operation))?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_sparkline() {
+        assert_eq!(
+            sparkline(&[Some(0.0), Some(50.0), Some(100.0), None]),
+            "▁▅█ "
+        );
+        assert_eq!(sparkline(&[Some(0.0), Some(0.0)]), "▁▁");
+        assert_eq!(sparkline(&[]), "");
+    }
+
+    #[test]
+    fn test_history() {
+        let mut history = SparkHistory::new(SparkMetric::Rtt, 3);
+        history.push("/mnt", &[stat("READ", 10, 1.0)]);
+        assert_eq!(history.line("/mnt", "READ"), "  █");
+
+        history.push("/mnt", &[stat("READ", 0, 0.0), stat("WRITE", 5, 2.0)]);
+        history.push("/mnt", &[stat("READ", 10, 4.0)]);
+        history.push("/mnt", &[stat("READ", 10, 2.0)]);
+        // Idle READ is a gap; WRITE did not appear in the last interval.
+        assert_eq!(history.line("/mnt", "READ"), " █▅");
+        assert_eq!(history.line("/mnt", "WRITE"), "█  ");
+        assert_eq!(history.line("/other", "READ"), "   ");
+
+        history.reset("/mnt");
+        assert_eq!(history.line("/mnt", "READ"), "   ");
+    }
+
+    #[test]
+    fn test_display_with_spark() {
+        let mut history = SparkHistory::new(SparkMetric::Iops, 4);
+        let stats = [stat("READ", 100, 2.0)];
+        history.push("/mnt", &stats);
+        let mut out = Vec::new();
+        display_with_spark(&mut out, "/mnt", &stats, &[Column::Iops], &history, false).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert_eq!(
+            lines[0].split_whitespace().collect::<Vec<_>>(),
+            ["OP", "IOPS", "IOPS", "TREND"]
+        );
+        assert!(lines[2].ends_with("   █"));
+    }
+}
@@ -0,0 +1,181 @@
+//! statsd and DogStatsD sink. Plain statsd encodes mount and operation in
+//! the metric name; DogStatsD keeps names fixed and sends them as tags.
+
+use crate::gnuplot::slug;
+use crate::labels::Labels;
+use crate::types::DeltaStats;
+use clap::Args;
+use std::io;
+use std::net::UdpSocket;
+
+/// Keep datagrams under a typical Ethernet MTU after IP/UDP headers.
+const MAX_DATAGRAM: usize = 1432;
+
+#[derive(Args, Debug, Clone)]
+pub struct StatsdArgs {
+    /// Send interval metrics to a statsd server at HOST:PORT (UDP)
+    #[arg(long = "statsd", value_name = "HOST:PORT")]
+    pub statsd: Option<String>,
+
+    /// Metric name prefix for statsd
+    #[arg(long = "statsd-prefix", default_value = "nfs")]
+    pub statsd_prefix: String,
+
+    /// Use DogStatsD tags (mount, server, op and --label values)
+    #[arg(long = "dogstatsd")]
+    pub dogstatsd: bool,
+}
+
+/// One statsd metric before encoding.
+struct Metric {
+    name: &'static str,
+    value: f64,
+    kind: &'static str,
+}
+
+fn metrics(stat: &DeltaStats) -> [Metric; 5] {
+    let metric = |name, value, kind| Metric { name, value, kind };
+    [
+        metric("iops", stat.iops, "g"),
+        metric("kb_per_sec", stat.kb_per_sec, "g"),
+        metric("rtt", stat.avg_rtt, "ms"),
+        metric("exec", stat.avg_exec, "ms"),
+        metric("retrans", stat.delta_retrans as f64, "c"),
+    ]
+}
+
+/// DogStatsD tag values may not contain `,` or `|`.
+fn tag(key: &str, value: &str) -> String {
+    format!("{}:{}", key, value.replace([',', '|'], "_"))
+}
+
+/// Encoded metric lines for one mount's interval. Timings are only sent
+/// for operations that completed, so idle ops do not drag averages to 0.
+pub fn statsd_lines(
+    args: &StatsdArgs,
+    mount_point: &str,
+    server: &str,
+    stats: &[DeltaStats],
+    labels: &Labels,
+) -> Vec<String> {
+    let prefix = args.statsd_prefix.trim_end_matches('.');
+    let mut lines = Vec::new();
+    for stat in stats {
+        let op = stat.operation.to_lowercase();
+        let tags = if args.dogstatsd {
+            let mut tags = vec![
+                tag("mount", mount_point),
+                tag("server", server),
+                tag("op", &op),
+            ];
+            tags.extend(labels.iter().map(|(k, v)| tag(k, v)));
+            format!("|#{}", tags.join(","))
+        } else {
+            String::new()
+        };
+        for m in metrics(stat) {
+            if m.kind == "ms" && stat.delta_ops == 0 {
+                continue;
+            }
+            let name = if args.dogstatsd {
+                format!("{}.{}", prefix, m.name)
+            } else {
+                format!("{}.{}.{}.{}", prefix, slug(mount_point), op, m.name)
+            };
+            lines.push(format!("{}:{}|{}{}", name, m.value, m.kind, tags));
+        }
+    }
+    lines
+}
+
+/// Join lines into newline-separated payloads no larger than `max`.
+fn pack(lines: &[String], max: usize) -> Vec<String> {
+    let mut packets = Vec::new();
+    let mut current = String::new();
+    for line in lines {
+        if !current.is_empty() && current.len() + 1 + line.len() > max {
+            packets.push(std::mem::take(&mut current));
+        }
+        if !current.is_empty() {
+            current.push('\n');
+        }
+        current.push_str(line);
+    }
+    if !current.is_empty() {
+        packets.push(current);
+    }
+    packets
+}
+
+pub struct StatsdSender {
+    socket: UdpSocket,
+}
+
+impl StatsdSender {
+    pub fn new(addr: &str) -> io::Result<Self> {
+        let socket = UdpSocket::bind("0.0.0.0:0").or_else(|_| UdpSocket::bind("[::]:0"))?;
+        socket.connect(addr)?;
+        Ok(Self { socket })
+    }
+
+    /// Send `lines`, batched into datagrams. UDP delivery is best effort.
+    pub fn send(&self, lines: &[String]) -> io::Result<()> {
+        for packet in pack(lines, MAX_DATAGRAM) {
+            self.socket.send(packet.as_bytes())?;
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    fn args(dogstatsd: bool) -> StatsdArgs {
+        StatsdArgs {
+            statsd: Some("127.0.0.1:8125".to_string()),
+            statsd_prefix: "nfs".to_string(),
+            dogstatsd,
+        }
+    }
+
+    #[test]
+    fn test_plain_and_tagged_lines() {
+        let stats = [stat("READ", 10, 2.5), stat("NULL", 0, 0.0)];
+        let plain = statsd_lines(&args(false), "/mnt/data", "filer", &stats, &Labels::new());
+        assert_eq!(plain[0], "nfs.mnt_data.read.iops:10|g");
+        assert!(plain.contains(&"nfs.mnt_data.read.rtt:2.5|ms".to_string()));
+        // Idle ops send gauges and counters but no timings.
+        assert!(!plain.iter().any(|l| l.starts_with("nfs.mnt_data.null.rtt")));
+        assert_eq!(plain.len(), 5 + 3);
+
+        let mut labels = Labels::new();
+        labels.add("env=prod").unwrap();
+        let tagged = statsd_lines(&args(true), "/mnt/data", "filer", &stats[..1], &labels);
+        assert_eq!(
+            tagged[0],
+            "nfs.iops:10|g|#mount:/mnt/data,server:filer,op:read,env:prod"
+        );
+    }
+
+    #[test]
+    fn test_pack_respects_limit() {
+        let lines: Vec<String> = (0..100).map(|i| format!("metric.{}:1|c", i)).collect();
+        let packets = pack(&lines, 64);
+        assert!(packets.iter().all(|p| p.len() <= 64));
+        assert_eq!(packets.join("\n").lines().count(), 100);
+    }
+
+    #[test]
+    fn test_sender() {
+        let receiver = UdpSocket::bind("127.0.0.1:0").unwrap();
+        let sender = StatsdSender::new(&receiver.local_addr().unwrap().to_string()).unwrap();
+        sender
+            .send(&["a:1|c".to_string(), "b:2|g".to_string()])
+            .unwrap();
+        let mut buf = [0u8; 64];
+        let n = receiver.recv(&mut buf).unwrap();
+        assert_eq!(&buf[..n], b"a:1|c\nb:2|g");
+    }
+}
@@ -0,0 +1,269 @@
+//! Interval history in SQLite (`--store sqlite:/var/lib/nfs-gaze.db`),
+//! for asking after the fact what a mount looked like last Tuesday.
+//! Built with the `sqlite` feature.
+//!
+//! The schema is versioned with `PRAGMA user_version`; opening a store
+//! applies any migrations it has not seen yet, so databases written by an
+//! older nfs-gaze keep working. Rows older than `--retention` are pruned
+//! as new intervals arrive.
+
+use crate::rollup::parse_period;
+use crate::tracker::MountInterval;
+use chrono::{DateTime, Duration, Utc};
+use clap::Args;
+use rusqlite::{params, Connection};
+use std::io;
+use std::path::PathBuf;
+
+/// How often old rows are pruned while recording.
+const PRUNE_EVERY_SECS: i64 = 3600;
+
+/// Schema migrations, applied in order; entry N brings the schema to
+/// version N + 1. Never edit one that has shipped; append instead.
+const MIGRATIONS: &[&str] = &[
+    "CREATE TABLE intervals (
+        id INTEGER PRIMARY KEY,
+        timestamp_ms INTEGER NOT NULL,
+        interval_secs REAL NOT NULL,
+        mount_point TEXT NOT NULL,
+        server TEXT NOT NULL,
+        export TEXT NOT NULL
+    );
+    CREATE TABLE op_stats (
+        interval_id INTEGER NOT NULL REFERENCES intervals(id) ON DELETE CASCADE,
+        operation TEXT NOT NULL,
+        ops INTEGER NOT NULL,
+        bytes INTEGER NOT NULL,
+        bytes_sent INTEGER NOT NULL,
+        bytes_recv INTEGER NOT NULL,
+        rtt_ms INTEGER NOT NULL,
+        exec_ms INTEGER NOT NULL,
+        queue_ms INTEGER NOT NULL,
+        errors INTEGER NOT NULL,
+        retrans INTEGER NOT NULL
+    );",
+    "CREATE INDEX intervals_by_time ON intervals (timestamp_ms);
+    CREATE INDEX intervals_by_mount ON intervals (mount_point, timestamp_ms);
+    CREATE INDEX op_stats_by_interval ON op_stats (interval_id);",
+];
+
+#[derive(Args, Debug, Clone)]
+pub struct StoreArgs {
+    /// Persist interval deltas, e.g. sqlite:/var/lib/nfs-gaze.db
+    #[arg(long = "store", value_name = "URL", value_parser = parse_store)]
+    pub store: Option<PathBuf>,
+
+    /// Delete stored intervals older than this (e.g. 7d, 12h)
+    #[arg(long = "retention", value_parser = parse_period, default_value = "30d")]
+    pub retention: i64,
+}
+
+/// The database path from a `sqlite:` store URL.
+pub fn parse_store(s: &str) -> Result<PathBuf, String> {
+    match s.strip_prefix("sqlite:") {
+        Some(path) if !path.is_empty() => Ok(PathBuf::from(path)),
+        _ => Err(format!(
+            "unsupported store '{}': expected sqlite:/path/to/file.db",
+            s
+        )),
+    }
+}
+
+fn to_io(err: rusqlite::Error) -> io::Error {
+    io::Error::other(err)
+}
+
+/// Bring `conn` up to the latest schema, returning the version reached.
+fn migrate(conn: &mut Connection) -> rusqlite::Result<usize> {
+    let current: usize = conn.pragma_query_value(None, "user_version", |row| row.get(0))?;
+    for (i, sql) in MIGRATIONS.iter().enumerate().skip(current) {
+        let tx = conn.transaction()?;
+        tx.execute_batch(sql)?;
+        tx.pragma_update(None, "user_version", i + 1)?;
+        tx.commit()?;
+    }
+    Ok(MIGRATIONS.len().max(current))
+}
+
+pub struct SqliteStore {
+    conn: Connection,
+    retention: Duration,
+    last_prune: Option<DateTime<Utc>>,
+}
+
+impl SqliteStore {
+    pub fn open(path: &std::path::Path, retention_secs: i64) -> io::Result<Self> {
+        Self::with_connection(Connection::open(path).map_err(to_io)?, retention_secs)
+    }
+
+    pub fn open_in_memory(retention_secs: i64) -> io::Result<Self> {
+        Self::with_connection(Connection::open_in_memory().map_err(to_io)?, retention_secs)
+    }
+
+    fn with_connection(mut conn: Connection, retention_secs: i64) -> io::Result<Self> {
+        conn.execute_batch("PRAGMA foreign_keys = ON; PRAGMA journal_mode = WAL;")
+            .map_err(to_io)?;
+        migrate(&mut conn).map_err(to_io)?;
+        Ok(Self {
+            conn,
+            retention: Duration::seconds(retention_secs),
+            last_prune: None,
+        })
+    }
+
+    pub fn schema_version(&self) -> io::Result<usize> {
+        self.conn
+            .pragma_query_value(None, "user_version", |row| row.get(0))
+            .map_err(to_io)
+    }
+
+    /// Store one interval for every mount in a single transaction, pruning
+    /// expired rows at most hourly.
+    pub fn record(
+        &mut self,
+        timestamp: DateTime<Utc>,
+        interval_secs: f64,
+        intervals: &[MountInterval],
+    ) -> io::Result<()> {
+        let tx = self.conn.transaction().map_err(to_io)?;
+        {
+            let mut insert_interval = tx
+                .prepare_cached(
+                    "INSERT INTO intervals (timestamp_ms, interval_secs, mount_point, server, export)
+                     VALUES (?1, ?2, ?3, ?4, ?5)",
+                )
+                .map_err(to_io)?;
+            let mut insert_op = tx
+                .prepare_cached(
+                    "INSERT INTO op_stats (interval_id, operation, ops, bytes, bytes_sent,
+                     bytes_recv, rtt_ms, exec_ms, queue_ms, errors, retrans)
+                     VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)",
+                )
+                .map_err(to_io)?;
+            for interval in intervals {
+                let mount = &interval.mount;
+                let id = insert_interval
+                    .insert(params![
+                        timestamp.timestamp_millis(),
+                        interval_secs,
+                        mount.mount_point,
+                        mount.server,
+                        mount.export
+                    ])
+                    .map_err(to_io)?;
+                for s in interval
+                    .stats
+                    .iter()
+                    .filter(|s| s.delta_ops > 0 || s.delta_retrans > 0)
+                {
+                    insert_op
+                        .execute(params![
+                            id,
+                            s.operation,
+                            s.delta_ops,
+                            s.delta_bytes,
+                            s.delta_sent,
+                            s.delta_recv,
+                            s.delta_rtt,
+                            s.delta_exec,
+                            s.delta_queue,
+                            s.delta_errors,
+                            s.delta_retrans
+                        ])
+                        .map_err(to_io)?;
+                }
+            }
+        }
+        tx.commit().map_err(to_io)?;
+
+        let due = self.last_prune.map_or(true, |last| {
+            (timestamp - last).num_seconds() >= PRUNE_EVERY_SECS
+        });
+        if due {
+            self.prune(timestamp)?;
+        }
+        Ok(())
+    }
+
+    /// Delete intervals older than the retention period, returning how
+    /// many were removed. Their per-op rows go with them.
+    pub fn prune(&mut self, now: DateTime<Utc>) -> io::Result<usize> {
+        self.last_prune = Some(now);
+        let cutoff = (now - self.retention).timestamp_millis();
+        self.conn
+            .execute(
+                "DELETE FROM intervals WHERE timestamp_ms < ?1",
+                params![cutoff],
+            )
+            .map_err(to_io)
+    }
+
+    pub fn interval_count(&self) -> io::Result<i64> {
+        self.conn
+            .query_row("SELECT COUNT(*) FROM intervals", [], |row| row.get(0))
+            .map_err(to_io)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::testutil::mount;
+
+    fn interval() -> Vec<MountInterval> {
+        vec![MountInterval {
+            mount: mount("/mnt"),
+            stats: vec![stat("READ", 100, 2.0), stat("NULL", 0, 0.0)],
+        }]
+    }
+
+    #[test]
+    fn test_parse_store() {
+        assert_eq!(
+            parse_store("sqlite:/var/lib/nfs-gaze.db").unwrap(),
+            PathBuf::from("/var/lib/nfs-gaze.db")
+        );
+        assert!(parse_store("sqlite:").is_err());
+        assert!(parse_store("postgres://db").is_err());
+    }
+
+    #[test]
+    fn test_record_and_prune() {
+        let mut store = SqliteStore::open_in_memory(86400).unwrap();
+        assert_eq!(store.schema_version().unwrap(), MIGRATIONS.len());
+
+        let now = Utc::now();
+        store
+            .record(now - Duration::days(2), 1.0, &interval())
+            .unwrap();
+        store.record(now, 1.0, &interval()).unwrap();
+        let ops: i64 = store
+            .conn
+            .query_row("SELECT COUNT(*) FROM op_stats", [], |row| row.get(0))
+            .unwrap();
+        // Idle operations are not stored.
+        assert_eq!(ops, 2);
+
+        assert_eq!(store.prune(now).unwrap(), 1);
+        assert_eq!(store.interval_count().unwrap(), 1);
+        let ops: i64 = store
+            .conn
+            .query_row("SELECT COUNT(*) FROM op_stats", [], |row| row.get(0))
+            .unwrap();
+        assert_eq!(ops, 1);
+    }
+
+    #[test]
+    fn test_reopen_keeps_data() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("history.db");
+        let mut store = SqliteStore::open(&path, 86400).unwrap();
+        store.record(Utc::now(), 1.0, &interval()).unwrap();
+        drop(store);
+
+        let store = SqliteStore::open(&path, 86400).unwrap();
+        assert_eq!(store.schema_version().unwrap(), MIGRATIONS.len());
+        assert_eq!(store.interval_count().unwrap(), 1);
+    }
+}
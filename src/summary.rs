@@ -0,0 +1,124 @@
+//! `--summary`: one totals row per mount instead of per-op tables, for
+//! hosts with more mounts than fit on a screen of per-op detail.
+
+use crate::ordering::{sort_stats_by, SortKey};
+use crate::totals::mount_totals;
+use crate::tracker::MountInterval;
+use crate::types::DeltaStats;
+use crate::units::{format_kb_rate, format_ops};
+use chrono::{DateTime, Utc};
+use clap::Args;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct SummaryArgs {
+    /// Show one totals row per mount instead of per-operation tables
+    #[arg(long = "summary")]
+    pub summary: bool,
+}
+
+/// Totals for each mount, labelled with the mount point and ordered by
+/// `key` (mount point order for `SortKey::Name`).
+pub fn summarize(intervals: &[MountInterval], key: SortKey) -> Vec<DeltaStats> {
+    let mut rows: Vec<DeltaStats> = intervals
+        .iter()
+        .map(|interval| DeltaStats {
+            operation: interval.mount.mount_point.clone(),
+            ..mount_totals(&interval.stats)
+        })
+        .collect();
+    sort_stats_by(&mut rows, key);
+    rows
+}
+
+pub fn display_summary<W: Write>(
+    writer: &mut W,
+    rows: &[DeltaStats],
+    timestamp: &DateTime<Utc>,
+    human: bool,
+) -> io::Result<()> {
+    if rows.is_empty() {
+        return Ok(());
+    }
+    writeln!(
+        writer,
+        "Summary of {} mounts at {}",
+        rows.len(),
+        timestamp.format("%Y-%m-%d %H:%M:%S UTC")
+    )?;
+    writeln!(writer)?;
+    writeln!(
+        writer,
+        "{:<32} {:>10} {:>10} {:>10} {:>10} {:>8} {:>8}",
+        "MOUNT",
+        "IOPS",
+        if human { "BW" } else { "MB/s" },
+        "RTT(ms)",
+        "EXE(ms)",
+        "RETRANS",
+        "ERRORS"
+    )?;
+    writeln!(writer, "{}", "-".repeat(94))?;
+    for row in rows {
+        writeln!(
+            writer,
+            "{:<32} {:>10} {:>10} {:>10.2} {:>10.2} {:>8} {:>8}",
+            row.operation,
+            format_ops(row.iops, human),
+            format_kb_rate(row.kb_per_sec, human),
+            row.avg_rtt,
+            row.avg_exec,
+            row.delta_retrans,
+            row.delta_errors
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::testutil::mount;
+    use chrono::TimeZone;
+
+    fn interval(mount_point: &str, stats: Vec<DeltaStats>) -> MountInterval {
+        MountInterval {
+            mount: mount(mount_point),
+            stats,
+        }
+    }
+
+    #[test]
+    fn test_summarize() {
+        let intervals = vec![
+            interval("/mnt/b", vec![stat("READ", 10, 9.0)]),
+            interval(
+                "/mnt/a",
+                vec![stat("READ", 100, 1.0), stat("WRITE", 300, 5.0)],
+            ),
+        ];
+
+        let rows = summarize(&intervals, SortKey::Name);
+        assert_eq!(rows[0].operation, "/mnt/a");
+        assert_eq!(rows[0].delta_ops, 400);
+        assert!((rows[0].avg_rtt - 4.0).abs() < 1e-9);
+
+        let rows = summarize(&intervals, SortKey::Rtt);
+        assert_eq!(rows[0].operation, "/mnt/b");
+
+        let ts = Utc.with_ymd_and_hms(2024, 1, 1, 12, 0, 0).unwrap();
+        let mut out = Vec::new();
+        display_summary(&mut out, &rows, &ts, false).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.starts_with("Summary of 2 mounts at 2024-01-01 12:00:00 UTC"));
+        assert!(text.contains("/mnt/a"));
+        assert!(text.contains("400.0"));
+
+        let mut out = Vec::new();
+        display_summary(&mut out, &rows, &ts, true).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.contains("1.6M/s"));
+    }
+}
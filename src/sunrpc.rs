@@ -0,0 +1,350 @@
+//! Live sunrpc transport state, merged into the transport view.
+//!
+//! mountstats only has cumulative queue sums. When debugfs is mounted,
+//! `sunrpc/rpc_clnt/*/tasks` lists every outstanding RPC and the wait
+//! queue it sits on, and the sunrpc sysfs tree (5.15+) has each
+//! transport's congestion window and queue lengths. A task parked on
+//! `xprt_backlog` is waiting for a transport slot, which is what high
+//! queue times usually mean.
+
+use crate::xprt::NFSTransport;
+use clap::Args;
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::io::{self, Write};
+use std::path::Path;
+
+pub const SYSFS_SUNRPC: &str = "/sys/kernel/sunrpc";
+
+/// Wait queue of tasks waiting for a free transport slot.
+pub const BACKLOG_QUEUE: &str = "xprt_backlog";
+
+#[derive(Args, Debug, Clone)]
+pub struct SunrpcArgs {
+    /// Merge live sunrpc transport state from debugfs/sysfs into the transport view (requires root)
+    #[arg(long = "sunrpc")]
+    pub sunrpc: bool,
+}
+
+/// One transport from `xprt-switches/*/xprt-*/` in sysfs.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct XprtState {
+    pub name: String,
+    pub dst_addr: String,
+    pub src_port: u64,
+    pub dst_port: u64,
+    /// State flags, e.g. `CONNECTED BOUND`.
+    pub state: String,
+    pub cur_cong: u64,
+    pub cong_win: u64,
+    pub max_slots: u64,
+    pub num_reqs: u64,
+    pub binding_q: u64,
+    pub sending_q: u64,
+    pub pending_q: u64,
+    pub backlog_q: u64,
+}
+
+impl XprtState {
+    /// Requests are waiting for a slot.
+    pub fn slots_exhausted(&self) -> bool {
+        self.backlog_q > 0 || (self.max_slots > 0 && self.num_reqs >= self.max_slots)
+    }
+
+    /// The congestion window is full (UDP and RDMA; TCP leaves it to the
+    /// socket).
+    pub fn congested(&self) -> bool {
+        self.cong_win > 0 && self.cur_cong >= self.cong_win
+    }
+}
+
+/// Parse a sysfs `xprt_info` file of `key=value` lines.
+pub fn parse_xprt_info(contents: &str) -> XprtState {
+    let values: HashMap<&str, u64> = contents
+        .lines()
+        .filter_map(|line| {
+            let (key, value) = line.split_once('=')?;
+            Some((key.trim(), value.trim().parse().ok()?))
+        })
+        .collect();
+    let get = |key: &str| values.get(key).copied().unwrap_or(0);
+    XprtState {
+        src_port: get("src_port"),
+        dst_port: get("dst_port"),
+        cur_cong: get("cur_cong"),
+        cong_win: get("cong_win"),
+        max_slots: get("max_num_slots"),
+        num_reqs: get("num_reqs"),
+        binding_q: get("binding_q_len"),
+        sending_q: get("sending_q_len"),
+        pending_q: get("pending_q_len"),
+        backlog_q: get("backlog_q_len"),
+        ..Default::default()
+    }
+}
+
+fn read_trimmed(path: &Path) -> String {
+    fs::read_to_string(path)
+        .map(|s| s.trim().to_string())
+        .unwrap_or_default()
+}
+
+/// Every transport under the sunrpc sysfs tree. Missing on kernels
+/// before 5.15, in which case this is empty.
+pub fn read_xprts(sysfs: &str) -> Vec<XprtState> {
+    let mut xprts = Vec::new();
+    let Ok(switches) = fs::read_dir(Path::new(sysfs).join("xprt-switches")) else {
+        return xprts;
+    };
+    for switch in switches.flatten() {
+        let Ok(entries) = fs::read_dir(switch.path()) else {
+            continue;
+        };
+        for entry in entries.flatten() {
+            let name = entry.file_name().to_string_lossy().into_owned();
+            if !name.starts_with("xprt-") {
+                continue;
+            }
+            let dir = entry.path();
+            let Ok(info) = fs::read_to_string(dir.join("xprt_info")) else {
+                continue;
+            };
+            let state = read_trimmed(&dir.join("xprt_state"));
+            xprts.push(XprtState {
+                name,
+                dst_addr: read_trimmed(&dir.join("dstaddr")),
+                state: state
+                    .strip_prefix("state=")
+                    .unwrap_or(&state)
+                    .trim()
+                    .to_string(),
+                ..parse_xprt_info(&info)
+            });
+        }
+    }
+    xprts.sort_by(|a, b| a.name.cmp(&b.name));
+    xprts
+}
+
+/// Count tasks per wait queue in a debugfs `rpc_clnt/<id>/tasks` file.
+/// Each line ends with `q:<queue>`; running tasks have no queue.
+pub fn parse_tasks(contents: &str) -> BTreeMap<String, u64> {
+    let mut queues = BTreeMap::new();
+    for line in contents.lines() {
+        let queue = line
+            .split_whitespace()
+            .find_map(|f| f.strip_prefix("q:"))
+            .filter(|q| !q.is_empty() && *q != "NULL");
+        if let Some(queue) = queue {
+            *queues.entry(queue.to_string()).or_insert(0) += 1;
+        }
+    }
+    queues
+}
+
+/// Waiting tasks per server address and queue, across every RPC client.
+/// `None` when debugfs has no sunrpc directory.
+pub fn read_waiting(debugfs: &str) -> Option<BTreeMap<String, BTreeMap<String, u64>>> {
+    let clients = fs::read_dir(Path::new(debugfs).join("sunrpc").join("rpc_clnt")).ok()?;
+    let mut waiting: BTreeMap<String, BTreeMap<String, u64>> = BTreeMap::new();
+    for client in clients.flatten() {
+        let dir = client.path();
+        let Ok(tasks) = fs::read_to_string(dir.join("tasks")) else {
+            continue;
+        };
+        // `xprt` links to the client's transport; its info names the server.
+        let info = fs::read_to_string(dir.join("xprt").join("info")).unwrap_or_default();
+        let addr = info
+            .lines()
+            .find_map(|l| l.strip_prefix("addr:"))
+            .map(str::trim)
+            .unwrap_or("?")
+            .to_string();
+        let queues = waiting.entry(addr).or_default();
+        for (queue, count) in parse_tasks(&tasks) {
+            *queues.entry(queue).or_insert(0) += count;
+        }
+    }
+    Some(waiting)
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct SunrpcState {
+    pub xprts: Vec<XprtState>,
+    pub waiting: BTreeMap<String, BTreeMap<String, u64>>,
+}
+
+impl SunrpcState {
+    /// Sample debugfs and sysfs; `None` if debugfs has no sunrpc
+    /// directory (not mounted, or not root).
+    pub fn sample(debugfs: &str, sysfs: &str) -> Option<Self> {
+        Some(Self {
+            waiting: read_waiting(debugfs)?,
+            xprts: read_xprts(sysfs),
+        })
+    }
+
+    /// Live state for a mountstats transport, matched on its local port.
+    pub fn for_transport(&self, transport: &NFSTransport) -> Option<&XprtState> {
+        self.xprts
+            .iter()
+            .find(|x| x.src_port != 0 && x.src_port == transport.port)
+    }
+
+    /// Tasks waiting for a transport slot on `server`.
+    pub fn backlogged(&self, server: &str) -> u64 {
+        self.waiting
+            .get(server)
+            .and_then(|q| q.get(BACKLOG_QUEUE))
+            .copied()
+            .unwrap_or(0)
+    }
+}
+
+fn opt(value: Option<u64>) -> String {
+    value.map_or_else(|| "-".to_string(), |v| v.to_string())
+}
+
+/// The live columns for a mount's transports, then a note when requests
+/// are queued for slots.
+pub fn display_sunrpc_transports<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    server: &str,
+    transports: &[NFSTransport],
+    state: &SunrpcState,
+) -> io::Result<()> {
+    if transports.is_empty() {
+        return Ok(());
+    }
+    writeln!(writer, "Live transport state for {}", mount_point)?;
+    writeln!(
+        writer,
+        "{:<6} {:>6} {:<20} {:>6} {:>6} {:>6} {:>6} {:>6} {:>6} {:>6}",
+        "PROTO", "PORT", "STATE", "CWND", "CONG", "SLOTS", "REQS", "SENDQ", "PENDQ", "BKLOGQ"
+    )?;
+    writeln!(writer, "{}", "-".repeat(90))?;
+    let mut exhausted = false;
+    let mut congested = false;
+    for t in transports {
+        let live = state.for_transport(t);
+        exhausted |= live.is_some_and(XprtState::slots_exhausted);
+        congested |= live.is_some_and(XprtState::congested);
+        writeln!(
+            writer,
+            "{:<6} {:>6} {:<20} {:>6} {:>6} {:>6} {:>6} {:>6} {:>6} {:>6}",
+            t.protocol,
+            t.port,
+            live.map_or("-", |x| x.state.as_str()),
+            opt(live.map(|x| x.cong_win)),
+            opt(live.map(|x| x.cur_cong)),
+            opt(live.map(|x| x.max_slots)),
+            opt(live.map(|x| x.num_reqs)),
+            opt(live.map(|x| x.sending_q)),
+            opt(live.map(|x| x.pending_q)),
+            opt(live.map(|x| x.backlog_q)),
+        )?;
+    }
+    let backlogged = state.backlogged(server);
+    if exhausted || backlogged > 0 {
+        writeln!(
+            writer,
+            "NOTE: {} task(s) waiting for a transport slot; queue time on {} is slot-table exhaustion, not server latency",
+            backlogged, mount_point
+        )?;
+    }
+    if congested {
+        writeln!(
+            writer,
+            "NOTE: congestion window full on {}; the client is holding back requests",
+            mount_point
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const XPRT_INFO: &str = "\
+last_used=4295013048
+cur_cong=0
+cong_win=256
+max_num_slots=2
+min_num_slots=2
+num_reqs=2
+binding_q_len=0
+sending_q_len=0
+pending_q_len=1
+backlog_q_len=3
+main_xprt=1
+src_port=869
+tasks_queuelen=5
+dst_port=2049
+";
+
+    const TASKS: &str = "\
+  235 0880      0 0x8 0x5d2d1bc2     6000 nfs_pgio_common_ops [nfs] nfsv4 WRITE a:call_reserveresult [sunrpc] q:xprt_backlog
+  236 0880      0 0x8 0x5d2d1bc3     6000 nfs_pgio_common_ops [nfs] nfsv4 WRITE a:call_reserveresult [sunrpc] q:xprt_backlog
+  237 0880      0 0x8 0x5d2d1bc4     6000 nfs_pgio_common_ops [nfs] nfsv4 READ a:call_status [sunrpc] q:xprt_pending
+  238 0880      0 0x8 0x5d2d1bc5     6000 nfs_pgio_common_ops [nfs] nfsv4 READ a:call_start [sunrpc] q:NULL
+";
+
+    #[test]
+    fn test_parse() {
+        let x = parse_xprt_info(XPRT_INFO);
+        assert_eq!(x.src_port, 869);
+        assert_eq!(x.cong_win, 256);
+        assert_eq!(x.backlog_q, 3);
+        assert!(x.slots_exhausted());
+        assert!(!x.congested());
+
+        let queues = parse_tasks(TASKS);
+        assert_eq!(queues.get(BACKLOG_QUEUE), Some(&2));
+        assert_eq!(queues.get("xprt_pending"), Some(&1));
+        assert_eq!(queues.len(), 2);
+    }
+
+    #[test]
+    fn test_sample_and_display() {
+        let dir = tempfile::tempdir().unwrap();
+        let debugfs = dir.path().join("debug");
+        let sysfs = dir.path().join("sunrpc");
+        let client = debugfs.join("sunrpc/rpc_clnt/3");
+        fs::create_dir_all(client.join("xprt")).unwrap();
+        fs::write(client.join("tasks"), TASKS).unwrap();
+        fs::write(
+            client.join("xprt/info"),
+            "netid: tcp\naddr:  10.0.0.1\nport:  2049\nstate: 0x1b\n",
+        )
+        .unwrap();
+        let xprt = sysfs.join("xprt-switches/switch-0/xprt-0-tcp");
+        fs::create_dir_all(&xprt).unwrap();
+        fs::write(xprt.join("xprt_info"), XPRT_INFO).unwrap();
+        fs::write(xprt.join("xprt_state"), "state= CONNECTED BOUND\n").unwrap();
+        fs::write(xprt.join("dstaddr"), "10.0.0.1\n").unwrap();
+
+        assert!(SunrpcState::sample(dir.path().to_str().unwrap(), "/nonexistent").is_none());
+        let state =
+            SunrpcState::sample(debugfs.to_str().unwrap(), sysfs.to_str().unwrap()).unwrap();
+        assert_eq!(state.xprts.len(), 1);
+        assert_eq!(state.xprts[0].state, "CONNECTED BOUND");
+        assert_eq!(state.xprts[0].dst_addr, "10.0.0.1");
+        assert_eq!(state.backlogged("10.0.0.1"), 2);
+
+        let transports = [
+            NFSTransport::parse("tcp 869 1 1 0 5 1000 1000 0 2000 0 16 100 50").unwrap(),
+            NFSTransport::parse("tcp 870 1 1 0 5 800 800 0 1500 0 16 80 40").unwrap(),
+        ];
+        let mut out = Vec::new();
+        display_sunrpc_transports(&mut out, "/mnt/nfs", "10.0.0.1", &transports, &state).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert!(lines[3].contains("CONNECTED BOUND"));
+        // The second connection has no live state.
+        assert!(lines[4].ends_with("      -"));
+        assert!(lines[5].starts_with("NOTE: 2 task(s) waiting for a transport slot"));
+    }
+}
@@ -0,0 +1,205 @@
+//! Rolling "top talkers": which mount/operation pairs consumed the most
+//! ops, bytes and RTT time over the last N intervals.
+
+use crate::types::DeltaStats;
+use clap::Args;
+use std::collections::{HashMap, VecDeque};
+use std::io::{self, Write};
+
+const PANEL_ROWS: usize = 5;
+
+#[derive(Args, Debug, Clone)]
+pub struct TalkerArgs {
+    /// Show a rolling top-talkers panel
+    #[arg(long = "top-talkers")]
+    pub top_talkers: bool,
+
+    /// Number of intervals the top-talkers window covers
+    #[arg(long = "talker-window", default_value = "10")]
+    pub talker_window: usize,
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Usage {
+    pub ops: i64,
+    pub bytes: i64,
+    /// Total RTT time in milliseconds.
+    pub rtt_ms: i64,
+}
+
+impl Usage {
+    fn add(&mut self, other: &Usage) {
+        self.ops += other.ops;
+        self.bytes += other.bytes;
+        self.rtt_ms += other.rtt_ms;
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Metric {
+    Ops,
+    Bytes,
+    Latency,
+}
+
+impl Metric {
+    fn value(self, usage: &Usage) -> i64 {
+        match self {
+            Metric::Ops => usage.ops,
+            Metric::Bytes => usage.bytes,
+            Metric::Latency => usage.rtt_ms,
+        }
+    }
+}
+
+type Key = (String, String);
+
+/// Sliding window of per-interval usage keyed by (mount point, operation).
+#[derive(Debug)]
+pub struct TopTalkers {
+    capacity: usize,
+    intervals: VecDeque<HashMap<Key, Usage>>,
+}
+
+impl TopTalkers {
+    pub fn new(window: usize) -> Self {
+        Self {
+            capacity: window.max(1),
+            intervals: VecDeque::new(),
+        }
+    }
+
+    /// Add one interval's rows for every mount, dropping the oldest
+    /// interval once the window is full.
+    pub fn push<'a, I>(&mut self, interval: I)
+    where
+        I: IntoIterator<Item = (&'a str, &'a [DeltaStats])>,
+    {
+        let mut usage: HashMap<Key, Usage> = HashMap::new();
+        for (mount_point, stats) in interval {
+            for stat in stats.iter().filter(|s| s.delta_ops > 0) {
+                usage
+                    .entry((mount_point.to_string(), stat.operation.clone()))
+                    .or_default()
+                    .add(&Usage {
+                        ops: stat.delta_ops,
+                        bytes: stat.delta_bytes,
+                        rtt_ms: stat.delta_rtt,
+                    });
+            }
+        }
+        if self.intervals.len() == self.capacity {
+            self.intervals.pop_front();
+        }
+        self.intervals.push_back(usage);
+    }
+
+    pub fn len(&self) -> usize {
+        self.intervals.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.intervals.is_empty()
+    }
+
+    /// The `n` largest (mount point, operation) pairs by `metric`.
+    pub fn top(&self, metric: Metric, n: usize) -> Vec<(Key, Usage)> {
+        let mut totals: HashMap<&Key, Usage> = HashMap::new();
+        for interval in &self.intervals {
+            for (key, usage) in interval {
+                totals.entry(key).or_default().add(usage);
+            }
+        }
+        let mut ranked: Vec<(Key, Usage)> = totals
+            .into_iter()
+            .filter(|(_, u)| metric.value(u) > 0)
+            .map(|(k, u)| (k.clone(), u))
+            .collect();
+        ranked.sort_by(|a, b| {
+            metric
+                .value(&b.1)
+                .cmp(&metric.value(&a.1))
+                .then_with(|| a.0.cmp(&b.0))
+        });
+        ranked.truncate(n);
+        ranked
+    }
+}
+
+pub fn display_top_talkers<W: Write>(writer: &mut W, talkers: &TopTalkers) -> io::Result<()> {
+    if talkers.is_empty() {
+        return Ok(());
+    }
+
+    writeln!(writer, "Top talkers (last {} intervals)", talkers.len())?;
+    writeln!(writer, "{}", "-".repeat(60))?;
+    for (title, metric) in [
+        ("ops", Metric::Ops),
+        ("bytes", Metric::Bytes),
+        ("rtt time", Metric::Latency),
+    ] {
+        let rows = talkers.top(metric, PANEL_ROWS);
+        if rows.is_empty() {
+            continue;
+        }
+        writeln!(writer, "By {}:", title)?;
+        for ((mount_point, operation), usage) in rows {
+            let value = match metric {
+                Metric::Ops => format!("{} ops", usage.ops),
+                Metric::Bytes => format!("{:.1} MB", usage.bytes as f64 / 1_048_576.0),
+                Metric::Latency => format!("{:.2} s", usage.rtt_ms as f64 / 1000.0),
+            };
+            writeln!(
+                writer,
+                "  {:<28} {:<14} {:>14}",
+                mount_point, operation, value
+            )?;
+        }
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_window_slides() {
+        let mut talkers = TopTalkers::new(2);
+        let a = [stat("READ", 100, 1.0), stat("GETATTR", 10, 50.0)];
+        let b = [stat("WRITE", 500, 2.0)];
+        talkers.push([("/mnt/a", &a[..]), ("/mnt/b", &b[..])]);
+        talkers.push([("/mnt/a", &a[..])]);
+
+        let by_ops = talkers.top(Metric::Ops, 5);
+        assert_eq!(by_ops[0].0, ("/mnt/b".to_string(), "WRITE".to_string()));
+        assert_eq!(by_ops[1].1.ops, 200);
+
+        let by_latency = talkers.top(Metric::Latency, 1);
+        assert_eq!(by_latency[0].0 .1, "GETATTR");
+
+        // The third interval pushes the /mnt/b rows out of the window.
+        talkers.push([("/mnt/a", &a[..])]);
+        assert_eq!(talkers.len(), 2);
+        assert!(talkers
+            .top(Metric::Ops, 5)
+            .iter()
+            .all(|(key, _)| key.0 == "/mnt/a"));
+    }
+
+    #[test]
+    fn test_display() {
+        let mut talkers = TopTalkers::new(3);
+        let a = [stat("READ", 256, 1.0)];
+        talkers.push([("/mnt/a", &a[..])]);
+
+        let mut out = Vec::new();
+        display_top_talkers(&mut out, &talkers).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.starts_with("Top talkers (last 1 intervals)"));
+        assert!(text.contains("By bytes:"));
+        assert!(text.contains("1.0 MB"));
+    }
+}
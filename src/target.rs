@@ -0,0 +1,131 @@
+//! `--pid N` / `--container ID`: read another process's mountstats so NFS
+//! mounts inside containers and other mount namespaces can be watched
+//! from the host.
+//!
+//! `/proc/<pid>/mountstats` lists the mounts of that process's namespace,
+//! the same view as reading through `/proc/<pid>/root`, without having to
+//! enter it.
+
+use crate::cgroups::container_id;
+use crate::types::{NfsGazeError, Result};
+use clap::Args;
+use std::fs;
+use std::io;
+use std::path::Path;
+
+#[derive(Args, Debug, Clone, Default)]
+pub struct TargetArgs {
+    /// Monitor the NFS mounts visible to process PID (its mount namespace)
+    #[arg(long = "pid", value_name = "PID", conflicts_with = "container")]
+    pub pid: Option<u32>,
+
+    /// Monitor the NFS mounts inside a container, by full or abbreviated ID
+    #[arg(long = "container", value_name = "ID")]
+    pub container: Option<String>,
+}
+
+/// Cgroup paths from /proc/<pid>/cgroup, v1 and v2 alike.
+fn cgroup_paths(contents: &str) -> impl Iterator<Item = &str> {
+    contents
+        .lines()
+        .filter_map(|line| line.splitn(3, ':').nth(2))
+}
+
+/// Lowest PID whose cgroup belongs to the container `id`. A prefix of the
+/// ID is enough, as with `docker ps` short IDs.
+pub fn find_container_pid(proc_root: &str, id: &str) -> io::Result<Option<u32>> {
+    let mut found = None;
+    for entry in fs::read_dir(proc_root)?.flatten() {
+        let Some(pid) = entry
+            .file_name()
+            .to_str()
+            .and_then(|s| s.parse::<u32>().ok())
+        else {
+            continue;
+        };
+        // Processes can exit mid-scan; skip anything we cannot read.
+        let Ok(contents) = fs::read_to_string(entry.path().join("cgroup")) else {
+            continue;
+        };
+        let matches = cgroup_paths(&contents)
+            .filter_map(container_id)
+            .any(|cid| cid.starts_with(id));
+        if matches {
+            found = Some(found.map_or(pid, |f: u32| f.min(pid)));
+        }
+    }
+    Ok(found)
+}
+
+/// The mountstats file to read: the target process's when `--pid` or
+/// `--container` is given, otherwise `default`.
+pub fn mountstats_path(args: &TargetArgs, proc_root: &str, default: &str) -> Result<String> {
+    let pid = match (&args.pid, &args.container) {
+        (Some(pid), _) => *pid,
+        (None, Some(id)) => find_container_pid(proc_root, id)?.ok_or_else(|| {
+            NfsGazeError::ParseError(format!("no running process found in container {}", id))
+        })?,
+        (None, None) => return Ok(default.to_string()),
+    };
+    let path = format!("{}/{}/mountstats", proc_root, pid);
+    if !Path::new(&path).exists() {
+        return Err(NfsGazeError::ParseError(format!(
+            "process {} not found (no {})",
+            pid, path
+        )));
+    }
+    Ok(path)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_mountstats_path() {
+        let proc_root = tempfile::tempdir().unwrap();
+        let hex = "0f".repeat(32);
+        for (pid, cgroup) in [
+            (300, format!("0::/system.slice/docker-{}.scope\n", hex)),
+            (250, format!("12:memory:/docker/{}\n0::/\n", hex)),
+            (10, "0::/system.slice/sshd.service\n".to_string()),
+        ] {
+            let dir = proc_root.path().join(pid.to_string());
+            fs::create_dir_all(&dir).unwrap();
+            fs::write(dir.join("cgroup"), cgroup).unwrap();
+            fs::write(dir.join("mountstats"), "").unwrap();
+        }
+        let root = proc_root.path().to_str().unwrap();
+
+        assert_eq!(find_container_pid(root, "0f0f0f").unwrap(), Some(250));
+        assert_eq!(find_container_pid(root, "beef").unwrap(), None);
+
+        let default = "/proc/self/mountstats";
+        assert_eq!(
+            mountstats_path(&TargetArgs::default(), root, default).unwrap(),
+            default
+        );
+        let by_pid = TargetArgs {
+            pid: Some(10),
+            container: None,
+        };
+        assert_eq!(
+            mountstats_path(&by_pid, root, default).unwrap(),
+            format!("{}/10/mountstats", root)
+        );
+        let by_container = TargetArgs {
+            pid: None,
+            container: Some("0f0f".to_string()),
+        };
+        assert_eq!(
+            mountstats_path(&by_container, root, default).unwrap(),
+            format!("{}/250/mountstats", root)
+        );
+
+        let gone = TargetArgs {
+            pid: Some(99),
+            container: None,
+        };
+        assert!(mountstats_path(&gone, root, default).is_err());
+    }
+}
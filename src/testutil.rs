@@ -0,0 +1,44 @@
+//! Fixtures shared by the unit tests.
+
+use crate::types::{NFSMount, NFSOperation};
+use std::collections::HashMap;
+
+/// An idle mount of `server:export` on `mount_point`. Tests that care
+/// about other fields override them with struct update syntax.
+pub(crate) fn mount_of(server: &str, export: &str, mount_point: &str) -> NFSMount {
+    NFSMount {
+        device: format!("{}:{}", server, export),
+        mount_point: mount_point.to_string(),
+        server: server.to_string(),
+        export: export.to_string(),
+        age: 1,
+        operations: HashMap::new(),
+        events: None,
+        bytes_read: 0,
+        bytes_write: 0,
+    }
+}
+
+/// An idle mount of `filer:<mount_point>`.
+pub(crate) fn mount(mount_point: &str) -> NFSMount {
+    mount_of("filer", mount_point, mount_point)
+}
+
+/// `mount` carrying `ops`, keyed by operation name.
+pub(crate) fn with_ops<I>(mount: NFSMount, ops: I) -> NFSMount
+where
+    I: IntoIterator<Item = NFSOperation>,
+{
+    NFSMount {
+        operations: ops.into_iter().map(|op| (op.name.clone(), op)).collect(),
+        ..mount
+    }
+}
+
+/// An operation with every counter at zero.
+pub(crate) fn op(name: &str) -> NFSOperation {
+    NFSOperation {
+        name: name.to_string(),
+        ..Default::default()
+    }
+}
@@ -0,0 +1,143 @@
+//! RPC-with-TLS status per mount (`xprtsec=` mount option) and a policy
+//! check for servers that must only be reached encrypted.
+
+use crate::options::MountOptions;
+use crate::servergroups::ServerGroups;
+use clap::Args;
+use serde::Serialize;
+use std::fmt;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct TlsArgs {
+    /// Alert when a mount to this server is not using TLS (repeatable)
+    #[arg(long = "require-tls")]
+    pub require_tls: Vec<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum TransportSecurity {
+    None,
+    Tls,
+    Mtls,
+}
+
+impl TransportSecurity {
+    pub fn from_options(opts: &MountOptions) -> Self {
+        match opts.get("xprtsec") {
+            Some("tls") => TransportSecurity::Tls,
+            Some("mtls") => TransportSecurity::Mtls,
+            _ => TransportSecurity::None,
+        }
+    }
+
+    pub fn is_encrypted(self) -> bool {
+        self != TransportSecurity::None
+    }
+
+    pub fn as_str(self) -> &'static str {
+        match self {
+            TransportSecurity::None => "none",
+            TransportSecurity::Tls => "tls",
+            TransportSecurity::Mtls => "mtls",
+        }
+    }
+}
+
+impl fmt::Display for TransportSecurity {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(self.as_str())
+    }
+}
+
+/// Whether `server` falls under the --require-tls policy, either directly
+/// or through its logical server group.
+pub fn tls_required(server: &str, required: &[String], groups: &ServerGroups) -> bool {
+    let logical = groups.logical_name(server);
+    required
+        .iter()
+        .any(|r| r.eq_ignore_ascii_case(server) || r.eq_ignore_ascii_case(logical))
+}
+
+/// Emit a policy alert if `mount_point` reaches a TLS-required server in
+/// the clear. Returns whether an alert was written.
+pub fn check_tls_policy<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    server: &str,
+    security: TransportSecurity,
+    args: &TlsArgs,
+    groups: &ServerGroups,
+) -> io::Result<bool> {
+    if security.is_encrypted() || !tls_required(server, &args.require_tls, groups) {
+        return Ok(false);
+    }
+    writeln!(
+        writer,
+        "ALERT: {} is mounted from {} without TLS (xprtsec=none)",
+        mount_point, server
+    )?;
+    Ok(true)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_transport_security() {
+        let tls = MountOptions::parse("rw,vers=4.2,xprtsec=tls");
+        assert_eq!(
+            TransportSecurity::from_options(&tls),
+            TransportSecurity::Tls
+        );
+        let mtls = MountOptions::parse("xprtsec=mtls");
+        assert_eq!(TransportSecurity::from_options(&mtls).to_string(), "mtls");
+        let plain = MountOptions::parse("rw,vers=4.2");
+        assert!(!TransportSecurity::from_options(&plain).is_encrypted());
+    }
+
+    #[test]
+    fn test_policy_alert() {
+        let mut groups = ServerGroups::default();
+        groups.add_definition("vault=10.1.0.1,10.1.0.2").unwrap();
+        let args = TlsArgs {
+            require_tls: vec!["vault".to_string()],
+        };
+
+        let mut out = Vec::new();
+        let alerted = check_tls_policy(
+            &mut out,
+            "/secure",
+            "10.1.0.2",
+            TransportSecurity::None,
+            &args,
+            &groups,
+        )
+        .unwrap();
+        assert!(alerted);
+        assert!(String::from_utf8(out).unwrap().contains("ALERT: /secure"));
+
+        let mut out = Vec::new();
+        assert!(!check_tls_policy(
+            &mut out,
+            "/secure",
+            "10.1.0.2",
+            TransportSecurity::Mtls,
+            &args,
+            &groups
+        )
+        .unwrap());
+        assert!(!check_tls_policy(
+            &mut out,
+            "/other",
+            "filer",
+            TransportSecurity::None,
+            &args,
+            &groups
+        )
+        .unwrap());
+        assert!(out.is_empty());
+    }
+}
@@ -0,0 +1,122 @@
+//! Per-mount totals printed under the per-op table.
+//!
+//! Averages are weighted by operation count (see `aggregate::total_stats`),
+//! so a handful of slow COMMITs cannot drown out thousands of fast GETATTRs.
+
+use crate::aggregate::total_stats;
+use crate::types::DeltaStats;
+use clap::Args;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct TotalsArgs {
+    /// Do not print the per-mount totals line under each table
+    #[arg(long = "no-totals")]
+    pub no_totals: bool,
+}
+
+/// The totals row for one mount's interval.
+pub fn mount_totals(stats: &[DeltaStats]) -> DeltaStats {
+    total_stats("TOTAL", stats)
+}
+
+/// Totals line for the simple table.
+pub fn display_totals<W: Write>(writer: &mut W, stats: &[DeltaStats]) -> io::Result<()> {
+    if stats.is_empty() {
+        return Ok(());
+    }
+    let total = mount_totals(stats);
+    writeln!(
+        writer,
+        "TOTAL: {:.1} IOPS, {:.2} MB/s, avg RTT {:.2}ms, avg exec {:.2}ms, {} retrans, {} errors",
+        total.iops,
+        total.kb_per_sec / 1024.0,
+        total.avg_rtt,
+        total.avg_exec,
+        total.delta_retrans,
+        total.delta_errors
+    )
+}
+
+fn percent(part: i64, whole: i64) -> f64 {
+    if whole <= 0 {
+        0.0
+    } else {
+        part as f64 * 100.0 / whole as f64
+    }
+}
+
+/// One operation's block in nfsiostat's per-op layout, headed by
+/// `label` (e.g. `read:`).
+pub fn display_iostat_block<W: Write>(
+    writer: &mut W,
+    label: &str,
+    stat: &DeltaStats,
+) -> io::Result<()> {
+    writeln!(
+        writer,
+        "{:<10}{:>16}{:>16}{:>16}{:>16}{:>16}{:>16}{:>16}",
+        label, "ops/s", "kB/s", "kB/op", "retrans", "avg RTT (ms)", "avg exe (ms)", "errors"
+    )?;
+    writeln!(
+        writer,
+        "{:<10}{:>16.3}{:>16.3}{:>16.3}{:>16}{:>16.3}{:>16.3}{:>16}",
+        "",
+        stat.iops,
+        stat.kb_per_sec,
+        stat.kb_per_op,
+        format!(
+            "{} ({:.1}%)",
+            stat.delta_retrans,
+            percent(stat.delta_retrans, stat.delta_ops)
+        ),
+        stat.avg_rtt,
+        stat.avg_exec,
+        format!(
+            "{} ({:.1}%)",
+            stat.delta_errors,
+            percent(stat.delta_errors, stat.delta_ops)
+        )
+    )
+}
+
+/// Totals block in nfsiostat's per-op layout, headed `total:`.
+pub fn display_totals_iostat<W: Write>(writer: &mut W, stats: &[DeltaStats]) -> io::Result<()> {
+    if stats.is_empty() {
+        return Ok(());
+    }
+    display_iostat_block(writer, "total:", &mount_totals(stats))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+
+    #[test]
+    fn test_display_totals() {
+        let mut write = stat("WRITE", 300, 5.0);
+        write.delta_retrans = 3;
+        write.delta_errors = 1;
+        let stats = vec![stat("READ", 100, 1.0), write];
+
+        let mut out = Vec::new();
+        display_totals(&mut out, &stats).unwrap();
+        assert_eq!(
+            String::from_utf8(out).unwrap(),
+            "TOTAL: 400.0 IOPS, 1.56 MB/s, avg RTT 4.00ms, avg exec 4.00ms, 3 retrans, 1 errors\n"
+        );
+
+        let mut out = Vec::new();
+        display_totals_iostat(&mut out, &stats).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.starts_with("total:"));
+        assert!(text.contains("400.000"));
+        assert!(text.contains("3 (0.8%)"));
+        assert!(text.contains("1 (0.2%)"));
+
+        let mut out = Vec::new();
+        display_totals(&mut out, &[]).unwrap();
+        assert!(out.is_empty());
+    }
+}
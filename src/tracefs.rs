@@ -0,0 +1,148 @@
+//! Minimal tracefs access: enabling events and reading trace_pipe.
+
+use clap::Args;
+use std::fs::{File, OpenOptions};
+use std::io::{self, BufRead, BufReader, Write};
+use std::path::Path;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::mpsc::Sender;
+use std::sync::Arc;
+
+pub const DEFAULT_TRACEFS: &str = "/sys/kernel/tracing";
+
+#[derive(Args, Debug, Clone)]
+pub struct TracefsArgs {
+    /// tracefs mount point used by tracepoint and kprobe features
+    #[arg(long = "tracefs", default_value = DEFAULT_TRACEFS)]
+    pub tracefs: String,
+}
+
+/// One line of trace_pipe output, split into its fixed fields.
+#[derive(Debug, Clone, PartialEq)]
+pub struct TraceRecord {
+    pub comm: String,
+    pub pid: u32,
+    pub timestamp_us: f64,
+    pub event: String,
+    pub payload: String,
+}
+
+/// Parse a trace_pipe line such as
+/// `  dd-4312  [001] ..... 8123.456789: nfs4_xdr_status: task:... error=-10008`.
+pub fn parse_record(line: &str) -> Option<TraceRecord> {
+    let cpu_start = line.find(" [")?;
+    let (task, rest) = line.split_at(cpu_start);
+    let (comm, pid) = task.trim().rsplit_once('-')?;
+    let pid = pid.parse().ok()?;
+
+    let mut fields = rest.splitn(3, ": ");
+    let timestamp = fields.next()?.split_whitespace().last()?;
+    let timestamp_us = timestamp.parse::<f64>().ok()? * 1_000_000.0;
+    let event = fields.next()?.trim().to_string();
+    let payload = fields.next().unwrap_or("").trim().to_string();
+
+    Some(TraceRecord {
+        comm: comm.to_string(),
+        pid,
+        timestamp_us,
+        event,
+        payload,
+    })
+}
+
+/// Look up `key=value` in a tracepoint payload.
+pub fn field<'a>(payload: &'a str, key: &str) -> Option<&'a str> {
+    payload.split_whitespace().find_map(|token| {
+        token
+            .strip_prefix(key)
+            .and_then(|rest| rest.strip_prefix('='))
+    })
+}
+
+/// Whether `event` exists on this kernel.
+pub fn event_exists(root: &str, event: &str) -> bool {
+    Path::new(root).join("events").join(event).is_dir()
+}
+
+/// Tracepoints switched on by [`enable_events`] and switched off again on
+/// drop. Their `enable` files stay open, so switching off still works
+/// after `--sandbox` has dropped root.
+pub struct HeldEvents {
+    enabled: Vec<(&'static str, File)>,
+}
+
+impl HeldEvents {
+    pub fn is_empty(&self) -> bool {
+        self.enabled.is_empty()
+    }
+}
+
+impl Drop for HeldEvents {
+    fn drop(&mut self) {
+        for (_, file) in &mut self.enabled {
+            let _ = file.write_all(b"0");
+        }
+    }
+}
+
+/// Enable whichever of `events` (e.g. `nfs4/nfs4_xdr_status`) this kernel
+/// has.
+pub fn enable_events(root: &str, events: &[&'static str]) -> io::Result<HeldEvents> {
+    let mut held = HeldEvents {
+        enabled: Vec::new(),
+    };
+    for event in events {
+        if event_exists(root, event) {
+            let path = Path::new(root).join("events").join(event).join("enable");
+            let mut file = OpenOptions::new().write(true).open(path)?;
+            file.write_all(b"1")?;
+            held.enabled.push((*event, file));
+        }
+    }
+    Ok(held)
+}
+
+/// Open trace_pipe, before any privilege drop.
+pub fn open_trace_pipe(root: &str) -> io::Result<File> {
+    File::open(Path::new(root).join("trace_pipe"))
+}
+
+/// Forward parsed records from `pipe` to `tx` until `running` is cleared
+/// or the receiver goes away.
+pub fn stream_records(
+    pipe: File,
+    running: Arc<AtomicBool>,
+    tx: Sender<TraceRecord>,
+) -> io::Result<()> {
+    for line in BufReader::new(pipe).lines() {
+        if !running.load(Ordering::SeqCst) {
+            break;
+        }
+        if let Some(record) = parse_record(&line?) {
+            if tx.send(record).is_err() {
+                break;
+            }
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_record() {
+        let line = "  kworker/u8:2-118 [003] ..... 512.250000: nfs4_xdr_status: task:0000002a@00000003 error=-10008 (DELAY) operation=9";
+        let record = parse_record(line).expect("should parse");
+        assert_eq!(record.comm, "kworker/u8:2");
+        assert_eq!(record.pid, 118);
+        assert_eq!(record.event, "nfs4_xdr_status");
+        assert!((record.timestamp_us - 512_250_000.0).abs() < 1.0);
+        assert_eq!(field(&record.payload, "error"), Some("-10008"));
+        assert_eq!(field(&record.payload, "operation"), Some("9"));
+        assert_eq!(field(&record.payload, "xid"), None);
+
+        assert!(parse_record("no cpu field").is_none());
+    }
+}
@@ -0,0 +1,325 @@
+//! Per-mount baselines across intervals for the monitoring loop.
+//!
+//! The set of mounts is re-read every interval rather than fixed at
+//! start-up: a selected mount that appears mid-run is baselined on first
+//! sight and reported from the following interval.
+//!
+//! A mount that vanishes is dropped, and one whose `age` goes backwards
+//! or whose device changes has been remounted with fresh counters. It is
+//! re-baselined instead of producing a garbage delta. Both cases are
+//! reported as events rather than skipped silently. So is an individual
+//! operation whose counters reset; that op is left out of the interval.
+//! A counter that wrapped past u64::MAX is diffed across the wrap.
+
+use crate::delta::{checked_mount_delta, CounterReset};
+use crate::selection::MountSelector;
+use crate::types::{DeltaStats, NFSMount};
+use std::collections::BTreeMap;
+use std::io::{self, Write};
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum MountEvent {
+    /// A selected mount appeared after start-up.
+    Appeared { mount_point: String, device: String },
+    /// A tracked mount is no longer in mountstats.
+    Disappeared { mount_point: String },
+    /// A tracked mount was unmounted and mounted again between samples.
+    Remounted {
+        mount_point: String,
+        old_age: i64,
+        new_age: i64,
+        device_changed: bool,
+    },
+    /// An operation's counter went backwards; it is missing from this
+    /// interval's report.
+    CounterReset {
+        mount_point: String,
+        reset: CounterReset,
+    },
+}
+
+impl MountEvent {
+    pub fn mount_point(&self) -> &str {
+        match self {
+            MountEvent::Appeared { mount_point, .. }
+            | MountEvent::Disappeared { mount_point }
+            | MountEvent::Remounted { mount_point, .. }
+            | MountEvent::CounterReset { mount_point, .. } => mount_point,
+        }
+    }
+}
+
+/// Whether `cur` is a different mount instance than `prev` on the same
+/// path.
+fn remounted(prev: &NFSMount, cur: &NFSMount) -> bool {
+    cur.age < prev.age || cur.device != prev.device
+}
+
+/// One mount's report for an interval.
+#[derive(Debug, Clone)]
+pub struct MountInterval {
+    pub mount: NFSMount,
+    pub stats: Vec<DeltaStats>,
+}
+
+#[derive(Debug, Default)]
+pub struct Update {
+    /// Mounts with a baseline from the previous interval, in mount-point
+    /// order.
+    pub intervals: Vec<MountInterval>,
+    pub events: Vec<MountEvent>,
+}
+
+pub struct MountTracker {
+    selector: MountSelector,
+    baselines: BTreeMap<String, NFSMount>,
+    started: bool,
+}
+
+impl MountTracker {
+    pub fn new(selector: MountSelector) -> Self {
+        Self {
+            selector,
+            baselines: BTreeMap::new(),
+            started: false,
+        }
+    }
+
+    /// Feed a fresh parse of mountstats. The first call only records
+    /// baselines; later calls return deltas for known mounts and
+    /// baseline any newcomers.
+    pub fn observe(&mut self, mounts: Vec<NFSMount>, interval_secs: f64) -> Update {
+        let mut update = Update::default();
+        let mounts = self.selector.select(mounts);
+        let gone: Vec<String> = self
+            .baselines
+            .keys()
+            .filter(|mp| !mounts.iter().any(|m| &m.mount_point == *mp))
+            .cloned()
+            .collect();
+        for mount_point in gone {
+            self.baselines.remove(&mount_point);
+            update.events.push(MountEvent::Disappeared { mount_point });
+        }
+
+        for mount in mounts {
+            match self.baselines.get(&mount.mount_point) {
+                Some(prev) if remounted(prev, &mount) => {
+                    update.events.push(MountEvent::Remounted {
+                        mount_point: mount.mount_point.clone(),
+                        old_age: prev.age,
+                        new_age: mount.age,
+                        device_changed: prev.device != mount.device,
+                    })
+                }
+                Some(prev) => {
+                    let (stats, resets) = checked_mount_delta(prev, &mount, interval_secs);
+                    update.events.extend(resets.into_iter().map(|reset| {
+                        MountEvent::CounterReset {
+                            mount_point: mount.mount_point.clone(),
+                            reset,
+                        }
+                    }));
+                    update.intervals.push(MountInterval {
+                        stats,
+                        mount: mount.clone(),
+                    });
+                }
+                None if self.started => update.events.push(MountEvent::Appeared {
+                    mount_point: mount.mount_point.clone(),
+                    device: mount.device.clone(),
+                }),
+                None => {}
+            }
+            self.baselines.insert(mount.mount_point.clone(), mount);
+        }
+        self.started = true;
+        update
+            .intervals
+            .sort_by(|a, b| a.mount.mount_point.cmp(&b.mount.mount_point));
+        update
+    }
+
+    /// Mount points currently tracked.
+    pub fn mount_points(&self) -> impl Iterator<Item = &str> {
+        self.baselines.keys().map(String::as_str)
+    }
+}
+
+pub fn display_mount_events<W: Write>(writer: &mut W, events: &[MountEvent]) -> io::Result<()> {
+    for event in events {
+        match event {
+            MountEvent::Appeared {
+                mount_point,
+                device,
+            } => writeln!(
+                writer,
+                "EVENT: new mount {} ({}); reporting from the next interval",
+                mount_point, device
+            )?,
+            MountEvent::Disappeared { mount_point } => writeln!(
+                writer,
+                "EVENT: {} was unmounted; no longer reported",
+                mount_point
+            )?,
+            MountEvent::Remounted {
+                mount_point,
+                old_age,
+                new_age,
+                device_changed,
+            } => writeln!(
+                writer,
+                "EVENT: {} was remounted ({}); counters re-baselined, interval skipped",
+                mount_point,
+                if *device_changed {
+                    "device changed".to_string()
+                } else {
+                    format!("age {}s -> {}s", old_age, new_age)
+                }
+            )?,
+            MountEvent::CounterReset { mount_point, reset } => writeln!(
+                writer,
+                "EVENT: {} {} {} counter reset ({} -> {}); {} left out of this interval",
+                mount_point,
+                reset.operation,
+                reset.counter,
+                reset.previous,
+                reset.current,
+                reset.operation
+            )?,
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil::mount;
+
+    #[test]
+    fn test_new_mounts_are_baselined_then_reported() {
+        let mut tracker = MountTracker::new(MountSelector::default());
+
+        let first = tracker.observe(vec![mount("/a")], 1.0);
+        assert!(first.intervals.is_empty());
+        assert!(first.events.is_empty());
+
+        let second = tracker.observe(vec![mount("/a"), mount("/b")], 1.0);
+        assert_eq!(second.intervals.len(), 1);
+        assert_eq!(second.intervals[0].mount.mount_point, "/a");
+        assert_eq!(second.events.len(), 1);
+        assert_eq!(second.events[0].mount_point(), "/b");
+
+        let third = tracker.observe(vec![mount("/b"), mount("/a")], 1.0);
+        let reported: Vec<&str> = third
+            .intervals
+            .iter()
+            .map(|i| i.mount.mount_point.as_str())
+            .collect();
+        assert_eq!(reported, vec!["/a", "/b"]);
+        assert!(third.events.is_empty());
+
+        let mut out = Vec::new();
+        display_mount_events(&mut out, &second.events).unwrap();
+        assert!(String::from_utf8(out)
+            .unwrap()
+            .starts_with("EVENT: new mount /b"));
+    }
+
+    #[test]
+    fn test_unselected_mounts_are_ignored() {
+        let mut tracker = MountTracker::new(MountSelector::new(&["/a"]));
+        tracker.observe(vec![mount("/a")], 1.0);
+        let update = tracker.observe(vec![mount("/a"), mount("/b")], 1.0);
+        assert!(update.events.is_empty());
+        assert_eq!(tracker.mount_points().collect::<Vec<_>>(), vec!["/a"]);
+    }
+
+    #[test]
+    fn test_unmount_and_remount() {
+        let mut tracker = MountTracker::new(MountSelector::default());
+        let mut a = mount("/a");
+        a.age = 100;
+        tracker.observe(vec![a.clone(), mount("/b")], 1.0);
+
+        let mut fresh = a.clone();
+        fresh.age = 3;
+        let update = tracker.observe(vec![fresh.clone()], 1.0);
+        assert!(update.intervals.is_empty());
+        assert_eq!(
+            update.events,
+            vec![
+                MountEvent::Disappeared {
+                    mount_point: "/b".to_string()
+                },
+                MountEvent::Remounted {
+                    mount_point: "/a".to_string(),
+                    old_age: 100,
+                    new_age: 3,
+                    device_changed: false,
+                },
+            ]
+        );
+
+        // Re-baselined: the next interval reports normally.
+        fresh.age = 4;
+        let update = tracker.observe(vec![fresh.clone()], 1.0);
+        assert_eq!(update.intervals.len(), 1);
+        assert!(update.events.is_empty());
+
+        // Same path, different export.
+        let mut moved = fresh;
+        moved.device = "other:/x".to_string();
+        let update = tracker.observe(vec![moved], 1.0);
+        assert!(matches!(
+            update.events[0],
+            MountEvent::Remounted {
+                device_changed: true,
+                ..
+            }
+        ));
+
+        let mut out = Vec::new();
+        display_mount_events(&mut out, &update.events).unwrap();
+        assert!(String::from_utf8(out)
+            .unwrap()
+            .contains("remounted (device changed)"));
+    }
+
+    #[test]
+    fn test_counter_reset_event() {
+        use crate::types::NFSOperation;
+
+        let with_read = |ops: i64| {
+            let mut m = mount("/a");
+            m.operations.insert(
+                "READ".to_string(),
+                NFSOperation {
+                    name: "READ".to_string(),
+                    ops,
+                    ..NFSOperation::default()
+                },
+            );
+            m
+        };
+        let mut tracker = MountTracker::new(MountSelector::default());
+        tracker.observe(vec![with_read(50)], 1.0);
+        let update = tracker.observe(vec![with_read(5)], 1.0);
+        assert!(update.intervals[0].stats.is_empty());
+        assert!(
+            matches!(&update.events[0], MountEvent::CounterReset { reset, .. } if reset.counter == "ops")
+        );
+
+        let mut out = Vec::new();
+        display_mount_events(&mut out, &update.events).unwrap();
+        assert_eq!(
+            String::from_utf8(out).unwrap(),
+            "EVENT: /a READ ops counter reset (50 -> 5); READ left out of this interval\n"
+        );
+
+        // The new values become the baseline.
+        let update = tracker.observe(vec![with_read(15)], 1.0);
+        assert_eq!(update.intervals[0].stats[0].delta_ops, 10);
+    }
+}
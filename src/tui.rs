@@ -0,0 +1,423 @@
+//! Interactive full-screen mode (`--tui`): a live table of mounts with
+//! per-mount drill-down, keyboard sorting, pause/resume and interval
+//! changes on the fly.
+//!
+//! State and rendering are kept apart from the terminal so key handling
+//! and layout can be tested; [`run_tui`] only wires them to crossterm.
+//!
+//! Keys: ↑/↓ or j/k select, Enter drills into a mount, Esc/Backspace goes
+//! back, s cycles the sort column, p or space pauses, +/- change the
+//! interval, q quits.
+
+use crate::aggregate::total_stats;
+use crate::ordering::SortKey;
+use crate::parser::parse_mountstats;
+use crate::selection::MountSelector;
+use crate::tracker::{display_mount_events, MountEvent, MountInterval, MountTracker};
+use crate::types::{DeltaStats, Result};
+use clap::Args;
+use crossterm::event::{self, Event, KeyCode, KeyEvent, KeyEventKind, KeyModifiers};
+use crossterm::{cursor, execute, queue, style, terminal};
+use std::io::{self, Write};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant};
+
+const MIN_INTERVAL: Duration = Duration::from_secs(1);
+const MAX_INTERVAL: Duration = Duration::from_secs(60);
+/// Event lines kept for the status area.
+const EVENT_LINES: usize = 3;
+
+#[derive(Args, Debug, Clone)]
+pub struct TuiArgs {
+    /// Interactive full-screen view with sorting and drill-down
+    #[arg(long = "tui")]
+    pub tui: bool,
+}
+
+/// Order rows by `key`, with names as the tie-breaker so rows do not
+/// jump around.
+fn sort_rows(rows: &mut [(String, DeltaStats)], key: SortKey) {
+    rows.sort_by(|(an, a), (bn, b)| {
+        key.metric(b)
+            .total_cmp(&key.metric(a))
+            .then_with(|| an.cmp(bn))
+    });
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum View {
+    Mounts,
+    Mount(String),
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Action {
+    None,
+    Redraw,
+    IntervalChanged,
+    Quit,
+}
+
+pub struct TuiState {
+    pub view: View,
+    pub selected: usize,
+    pub sort: SortKey,
+    pub paused: bool,
+    pub interval: Duration,
+    latest: Vec<MountInterval>,
+    events: Vec<String>,
+}
+
+impl TuiState {
+    pub fn new(interval: Duration, sort: SortKey) -> Self {
+        Self {
+            view: View::Mounts,
+            selected: 0,
+            sort,
+            paused: false,
+            interval: interval.clamp(MIN_INTERVAL, MAX_INTERVAL),
+            latest: Vec::new(),
+            events: Vec::new(),
+        }
+    }
+
+    /// Take a new interval's data; ignored while paused so the screen
+    /// holds still for reading.
+    pub fn update(&mut self, intervals: Vec<MountInterval>, events: &[MountEvent]) {
+        if self.paused {
+            return;
+        }
+        self.latest = intervals;
+        for event in events {
+            let mut line = Vec::new();
+            let _ = display_mount_events(&mut line, std::slice::from_ref(event));
+            self.events
+                .push(String::from_utf8_lossy(&line).trim_end().to_string());
+        }
+        let excess = self.events.len().saturating_sub(EVENT_LINES);
+        self.events.drain(..excess);
+        self.selected = self.selected.min(self.rows().len().saturating_sub(1));
+    }
+
+    /// Rows for the current view: one total per mount, or one per op.
+    pub fn rows(&self) -> Vec<(String, DeltaStats)> {
+        let mut rows: Vec<(String, DeltaStats)> = match &self.view {
+            View::Mounts => self
+                .latest
+                .iter()
+                .map(|i| {
+                    let name = i.mount.mount_point.clone();
+                    (name.clone(), total_stats(&name, &i.stats))
+                })
+                .collect(),
+            View::Mount(mount_point) => self
+                .latest
+                .iter()
+                .find(|i| &i.mount.mount_point == mount_point)
+                .map(|i| {
+                    i.stats
+                        .iter()
+                        .filter(|s| s.delta_ops > 0 || s.delta_retrans > 0)
+                        .map(|s| (s.operation.clone(), s.clone()))
+                        .collect()
+                })
+                .unwrap_or_default(),
+        };
+        sort_rows(&mut rows, self.sort);
+        rows
+    }
+
+    pub fn handle_key(&mut self, key: KeyEvent) -> Action {
+        if key.kind == KeyEventKind::Release {
+            return Action::None;
+        }
+        let rows = self.rows().len();
+        match key.code {
+            KeyCode::Char('q') => Action::Quit,
+            KeyCode::Char('c') if key.modifiers.contains(KeyModifiers::CONTROL) => Action::Quit,
+            KeyCode::Up | KeyCode::Char('k') => {
+                self.selected = self.selected.saturating_sub(1);
+                Action::Redraw
+            }
+            KeyCode::Down | KeyCode::Char('j') => {
+                self.selected = (self.selected + 1).min(rows.saturating_sub(1));
+                Action::Redraw
+            }
+            KeyCode::Enter if self.view == View::Mounts => match self.rows().get(self.selected) {
+                Some((name, _)) => {
+                    self.view = View::Mount(name.clone());
+                    self.selected = 0;
+                    Action::Redraw
+                }
+                None => Action::None,
+            },
+            KeyCode::Esc | KeyCode::Backspace | KeyCode::Left => {
+                if let View::Mount(mount_point) = &self.view {
+                    let mount_point = mount_point.clone();
+                    self.view = View::Mounts;
+                    self.selected = self
+                        .rows()
+                        .iter()
+                        .position(|(name, _)| *name == mount_point)
+                        .unwrap_or(0);
+                }
+                Action::Redraw
+            }
+            KeyCode::Char('s') => {
+                self.sort = self.sort.next();
+                Action::Redraw
+            }
+            KeyCode::Char('p') | KeyCode::Char(' ') => {
+                self.paused = !self.paused;
+                Action::Redraw
+            }
+            KeyCode::Char('+') | KeyCode::Char('=') => {
+                self.set_interval(self.interval + MIN_INTERVAL)
+            }
+            KeyCode::Char('-') => self.set_interval(self.interval.saturating_sub(MIN_INTERVAL)),
+            _ => Action::None,
+        }
+    }
+
+    fn set_interval(&mut self, interval: Duration) -> Action {
+        let interval = interval.clamp(MIN_INTERVAL, MAX_INTERVAL);
+        if interval == self.interval {
+            return Action::None;
+        }
+        self.interval = interval;
+        Action::IntervalChanged
+    }
+
+    /// The screen as lines no wider than `width`, at most `height` long.
+    pub fn render(&self, width: usize, height: usize) -> Vec<String> {
+        let title = match &self.view {
+            View::Mounts => "all mounts".to_string(),
+            View::Mount(mount_point) => mount_point.clone(),
+        };
+        let mut lines = vec![
+            format!(
+                "nfs-gaze — {} | interval {}s | sort {}{}",
+                title,
+                self.interval.as_secs(),
+                self.sort.column(),
+                if self.paused { " | PAUSED" } else { "" }
+            ),
+            format!(
+                "  {:<30} {:>10} {:>10} {:>9} {:>9} {:>8} {:>7}",
+                if self.view == View::Mounts {
+                    "MOUNT"
+                } else {
+                    "OP"
+                },
+                "OPS/s",
+                "KB/s",
+                "RTT ms",
+                "EXEC ms",
+                "RETRANS",
+                "ERRORS"
+            ),
+        ];
+        let footer = 1 + self.events.len();
+        let room = height.saturating_sub(lines.len() + footer);
+        let rows = self.rows();
+        // Scroll so the selection stays visible.
+        let start = self.selected.saturating_sub(room.saturating_sub(1));
+        for (i, (name, s)) in rows.iter().enumerate().skip(start).take(room) {
+            lines.push(format!(
+                "{} {:<30} {:>10.1} {:>10.1} {:>9.2} {:>9.2} {:>8} {:>7}",
+                if i == self.selected { ">" } else { " " },
+                name,
+                s.iops,
+                s.kb_per_sec,
+                s.avg_rtt,
+                s.avg_exec,
+                s.delta_retrans,
+                s.delta_errors
+            ));
+        }
+        if rows.is_empty() {
+            lines.push("  waiting for data...".to_string());
+        }
+        while lines.len() + footer < height {
+            lines.push(String::new());
+        }
+        lines.extend(self.events.iter().cloned());
+        lines.push(
+            "q quit  ↑↓ select  enter drill in  esc back  s sort  p pause  +/- interval"
+                .to_string(),
+        );
+        lines
+            .into_iter()
+            .take(height)
+            .map(|l| l.chars().take(width).collect())
+            .collect()
+    }
+}
+
+fn draw<W: Write>(out: &mut W, state: &TuiState) -> io::Result<()> {
+    let (width, height) = terminal::size()?;
+    queue!(out, cursor::MoveTo(0, 0))?;
+    for (i, line) in state
+        .render(width as usize, height as usize)
+        .iter()
+        .enumerate()
+    {
+        queue!(
+            out,
+            cursor::MoveTo(0, i as u16),
+            terminal::Clear(terminal::ClearType::CurrentLine)
+        )?;
+        if i == 0 {
+            queue!(out, style::SetAttribute(style::Attribute::Reverse))?;
+            queue!(out, style::Print(format!("{:<1$}", line, width as usize)))?;
+            queue!(out, style::SetAttribute(style::Attribute::Reset))?;
+        } else {
+            queue!(out, style::Print(line))?;
+        }
+    }
+    out.flush()
+}
+
+/// Restores the terminal however the TUI exits.
+struct TerminalGuard;
+
+impl TerminalGuard {
+    fn enter() -> io::Result<Self> {
+        terminal::enable_raw_mode()?;
+        execute!(io::stdout(), terminal::EnterAlternateScreen, cursor::Hide)?;
+        Ok(Self)
+    }
+}
+
+impl Drop for TerminalGuard {
+    fn drop(&mut self) {
+        let _ = execute!(io::stdout(), cursor::Show, terminal::LeaveAlternateScreen);
+        let _ = terminal::disable_raw_mode();
+    }
+}
+
+pub fn run_tui(
+    path: &str,
+    selector: MountSelector,
+    interval: Duration,
+    sort: SortKey,
+    running: &AtomicBool,
+) -> Result<()> {
+    let mut tracker = MountTracker::new(selector);
+    let mut state = TuiState::new(interval, sort);
+    tracker.observe(parse_mountstats(path)?, state.interval.as_secs_f64());
+
+    let _guard = TerminalGuard::enter()?;
+    let mut out = io::stdout();
+    let mut last_sample = Instant::now();
+    draw(&mut out, &state)?;
+
+    while running.load(Ordering::SeqCst) {
+        let due = last_sample + state.interval;
+        let timeout = due.saturating_duration_since(Instant::now());
+        if event::poll(timeout)? {
+            match event::read()? {
+                Event::Key(key) => match state.handle_key(key) {
+                    Action::Quit => break,
+                    Action::None => {}
+                    Action::Redraw | Action::IntervalChanged => draw(&mut out, &state)?,
+                },
+                Event::Resize(_, _) => draw(&mut out, &state)?,
+                _ => {}
+            }
+            continue;
+        }
+        let secs = last_sample.elapsed().as_secs_f64();
+        last_sample = Instant::now();
+        let update = tracker.observe(parse_mountstats(path)?, secs);
+        state.update(update.intervals, &update.events);
+        draw(&mut out, &state)?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::testutil::mount;
+
+    fn key(code: KeyCode) -> KeyEvent {
+        KeyEvent::new(code, KeyModifiers::NONE)
+    }
+
+    fn sample() -> Vec<MountInterval> {
+        vec![
+            MountInterval {
+                mount: mount("/a"),
+                stats: vec![stat("READ", 10, 1.0), stat("WRITE", 50, 9.0)],
+            },
+            MountInterval {
+                mount: mount("/b"),
+                stats: vec![stat("GETATTR", 500, 0.5)],
+            },
+        ]
+    }
+
+    #[test]
+    fn test_sorting_and_drill_down() {
+        let mut state = TuiState::new(Duration::from_secs(1), SortKey::Name);
+        state.update(sample(), &[]);
+
+        let names = |s: &TuiState| s.rows().into_iter().map(|(n, _)| n).collect::<Vec<_>>();
+        assert_eq!(names(&state), vec!["/a", "/b"]);
+        state.handle_key(key(KeyCode::Char('s')));
+        assert_eq!(state.sort, SortKey::Rtt);
+        assert_eq!(names(&state), vec!["/a", "/b"]);
+        state.handle_key(key(KeyCode::Char('s')));
+        state.handle_key(key(KeyCode::Char('s')));
+        assert_eq!(state.sort, SortKey::Iops);
+        assert_eq!(names(&state), vec!["/b", "/a"]);
+
+        // /a is second by IOPS.
+        state.handle_key(key(KeyCode::Down));
+        state.handle_key(key(KeyCode::Enter));
+        assert_eq!(state.view, View::Mount("/a".to_string()));
+        assert_eq!(names(&state), vec!["WRITE", "READ"]);
+
+        state.handle_key(key(KeyCode::Esc));
+        assert_eq!(state.view, View::Mounts);
+        assert_eq!(state.selected, 1);
+        assert_eq!(state.handle_key(key(KeyCode::Char('q'))), Action::Quit);
+    }
+
+    #[test]
+    fn test_pause_and_interval() {
+        let mut state = TuiState::new(Duration::from_secs(1), SortKey::Name);
+        state.handle_key(key(KeyCode::Char('p')));
+        state.update(sample(), &[]);
+        assert!(state.rows().is_empty());
+        state.handle_key(key(KeyCode::Char(' ')));
+        state.update(sample(), &[]);
+        assert_eq!(state.rows().len(), 2);
+
+        assert_eq!(state.handle_key(key(KeyCode::Char('-'))), Action::None);
+        assert_eq!(
+            state.handle_key(key(KeyCode::Char('+'))),
+            Action::IntervalChanged
+        );
+        assert_eq!(state.interval, Duration::from_secs(2));
+    }
+
+    #[test]
+    fn test_render_fits_screen() {
+        let mut state = TuiState::new(Duration::from_secs(1), SortKey::Name);
+        state.update(
+            sample(),
+            &[MountEvent::Disappeared {
+                mount_point: "/c".to_string(),
+            }],
+        );
+        let lines = state.render(60, 10);
+        assert_eq!(lines.len(), 10);
+        assert!(lines.iter().all(|l| l.chars().count() <= 60));
+        assert!(lines[0].contains("all mounts"));
+        assert!(lines[2].starts_with("> /a"));
+        assert!(lines[8].contains("/c was unmounted"));
+    }
+}
@@ -0,0 +1,106 @@
+//! Core data types: one parsed mount from mountstats, its per-operation
+//! counters, and the per-interval deltas computed from two samples.
+
+use std::collections::HashMap;
+use thiserror::Error;
+
+/// Cumulative counters from one `per-op statistics` line. Times are in
+/// milliseconds.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct NFSOperation {
+    pub name: String,
+    pub ops: i64,
+    pub ntrans: i64,
+    pub timeouts: i64,
+    pub bytes_sent: i64,
+    pub bytes_recv: i64,
+    pub queue_time: i64,
+    pub rtt: i64,
+    pub execute_time: i64,
+    pub errors: i64,
+}
+
+/// VFS event counters from the `events:` line, in kernel order. The two
+/// pNFS counters are absent on older kernels and read as zero.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct NFSEvents {
+    pub inode_revalidate: i64,
+    pub dentry_revalidate: i64,
+    pub data_invalidate: i64,
+    pub attr_invalidate: i64,
+    pub vfs_open: i64,
+    pub vfs_lookup: i64,
+    pub vfs_access: i64,
+    pub vfs_update_page: i64,
+    pub vfs_read_page: i64,
+    pub vfs_read_pages: i64,
+    pub vfs_write_page: i64,
+    pub vfs_write_pages: i64,
+    pub vfs_getdents: i64,
+    pub vfs_setattr: i64,
+    pub vfs_flush: i64,
+    pub vfs_fsync: i64,
+    pub vfs_lock: i64,
+    pub vfs_release: i64,
+    pub congestion_wait: i64,
+    pub setattr_trunc: i64,
+    pub extend_write: i64,
+    pub silly_rename: i64,
+    pub short_read: i64,
+    pub short_write: i64,
+    pub delay: i64,
+    pub pnfs_read: i64,
+    pub pnfs_write: i64,
+}
+
+/// One NFS mount as read from mountstats.
+#[derive(Debug, Clone)]
+pub struct NFSMount {
+    /// `server:/export` as printed on the device line.
+    pub device: String,
+    pub mount_point: String,
+    pub server: String,
+    pub export: String,
+    /// Seconds since the mount was made.
+    pub age: i64,
+    pub operations: HashMap<String, NFSOperation>,
+    pub events: Option<NFSEvents>,
+    /// Application bytes read and written through the page cache.
+    pub bytes_read: i64,
+    pub bytes_write: i64,
+}
+
+/// Per-operation activity between two samples of the same mount. The
+/// `delta_*` fields are raw counter differences; the rest are derived.
+#[derive(Debug, Clone)]
+pub struct DeltaStats {
+    pub operation: String,
+    pub delta_ops: i64,
+    pub delta_bytes: i64,
+    pub delta_sent: i64,
+    pub delta_recv: i64,
+    pub delta_rtt: i64,
+    pub delta_exec: i64,
+    pub delta_queue: i64,
+    pub delta_errors: i64,
+    pub delta_retrans: i64,
+    /// Milliseconds per operation.
+    pub avg_rtt: f64,
+    pub avg_exec: f64,
+    pub avg_queue: f64,
+    pub kb_per_op: f64,
+    pub kb_per_sec: f64,
+    pub iops: f64,
+}
+
+#[derive(Error, Debug)]
+pub enum NfsGazeError {
+    #[error("Failed to read mountstats: {0}")]
+    MountstatsRead(#[from] std::io::Error),
+    #[error("Parse error: {0}")]
+    ParseError(String),
+    #[error("Mount point not found: {0}")]
+    MountNotFound(String),
+}
+
+pub type Result<T> = std::result::Result<T, NfsGazeError>;
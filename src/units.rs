@@ -0,0 +1,82 @@
+//! Human-readable scaling for byte rates and counts (`-H`), for reading
+//! tables at a glance during triage rather than comparing raw kB figures.
+
+use clap::Args;
+
+#[derive(Args, Debug, Clone)]
+pub struct HumanArgs {
+    /// Auto-scale sizes to K/M/G/T and counts to k/M/G
+    #[arg(short = 'H', long = "human")]
+    pub human: bool,
+}
+
+/// Scale `value` by `base` until it drops below it, returning the scaled
+/// value and the index of the unit reached.
+fn scale(mut value: f64, base: f64, units: usize) -> (f64, usize) {
+    let mut unit = 0;
+    while value.abs() >= base && unit < units - 1 {
+        value /= base;
+        unit += 1;
+    }
+    (value, unit)
+}
+
+/// Bytes with a binary unit suffix: `512B`, `1.5K`, `3.2G`.
+pub fn human_bytes(bytes: f64) -> String {
+    const UNITS: &[&str] = &["B", "K", "M", "G", "T", "P"];
+    let (value, unit) = scale(bytes, 1024.0, UNITS.len());
+    if unit == 0 {
+        format!("{:.0}{}", value, UNITS[0])
+    } else {
+        format!("{:.1}{}", value, UNITS[unit])
+    }
+}
+
+/// Counts and rates with a decimal suffix: `950`, `12.3k`, `4.1M`.
+pub fn human_count(count: f64) -> String {
+    const UNITS: &[&str] = &["", "k", "M", "G", "T"];
+    let (value, unit) = scale(count, 1000.0, UNITS.len());
+    if unit == 0 {
+        format!("{:.1}", value)
+    } else {
+        format!("{:.1}{}", value, UNITS[unit])
+    }
+}
+
+/// A kB/s figure as printed in tables: raw MB/s, or scaled with `/s`.
+pub fn format_kb_rate(kb_per_sec: f64, human: bool) -> String {
+    if human {
+        format!("{}/s", human_bytes(kb_per_sec * 1024.0))
+    } else {
+        format!("{:.2}", kb_per_sec / 1024.0)
+    }
+}
+
+/// An operation rate as printed in tables.
+pub fn format_ops(ops: f64, human: bool) -> String {
+    if human {
+        human_count(ops)
+    } else {
+        format!("{:.1}", ops)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_human_units() {
+        assert_eq!(human_bytes(512.0), "512B");
+        assert_eq!(human_bytes(1536.0), "1.5K");
+        assert_eq!(human_bytes(3.0 * 1024.0 * 1024.0 * 1024.0 * 1024.0), "3.0T");
+        assert_eq!(human_count(950.0), "950.0");
+        assert_eq!(human_count(12_345.0), "12.3k");
+        assert_eq!(human_count(4_100_000.0), "4.1M");
+
+        assert_eq!(format_kb_rate(2048.0, false), "2.00");
+        assert_eq!(format_kb_rate(2048.0, true), "2.0M/s");
+        assert_eq!(format_ops(1500.0, false), "1500.0");
+        assert_eq!(format_ops(1500.0, true), "1.5k");
+    }
+}
@@ -0,0 +1,236 @@
+//! A background monitoring loop for embedding programs: snapshots on a
+//! timer, deltas, reset detection and mount discovery, delivered over a
+//! channel.
+//!
+//! ```no_run
+//! use nfs_gaze::watcher::Watcher;
+//! use std::time::Duration;
+//!
+//! let watch = Watcher::new("/proc/self/mountstats", Duration::from_secs(5)).watch();
+//! for interval in watch.iter() {
+//!     let interval = interval?;
+//!     for mount in &interval.mounts {
+//!         println!("{}: {} ops", mount.mount.mount_point, mount.stats.len());
+//!     }
+//! }
+//! # Ok::<(), nfs_gaze::mountstats::NfsGazeError>(())
+//! ```
+
+use crate::parser::parse_mountstats;
+use crate::selection::MountSelector;
+use crate::tracker::{MountEvent, MountInterval, MountTracker};
+use crate::types::{NFSMount, Result};
+use chrono::{DateTime, Utc};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::mpsc::{self, Receiver, SyncSender};
+use std::sync::Arc;
+use std::thread::{self, JoinHandle};
+use std::time::{Duration, Instant};
+
+/// Intervals buffered before the sampler waits for the consumer.
+const CHANNEL_DEPTH: usize = 8;
+
+/// Granularity at which a sleeping sampler notices `stop`.
+const STOP_POLL: Duration = Duration::from_millis(100);
+
+/// Everything observed in one interval.
+#[derive(Debug, Clone)]
+pub struct IntervalStats {
+    pub timestamp: DateTime<Utc>,
+    pub interval_secs: f64,
+    /// Mounts with a baseline from the previous interval, in mount-point
+    /// order.
+    pub mounts: Vec<MountInterval>,
+    /// Mounts that appeared, went away or were remounted, and counters
+    /// that reset, since the previous interval.
+    pub events: Vec<MountEvent>,
+}
+
+type Source = Box<dyn FnMut() -> Result<Vec<NFSMount>> + Send>;
+
+pub struct Watcher {
+    source: Source,
+    interval: Duration,
+    selector: MountSelector,
+}
+
+impl Watcher {
+    /// Watch every NFS mount in the mountstats file at `path`.
+    pub fn new(path: &str, interval: Duration) -> Self {
+        let path = path.to_string();
+        Self::with_source(move || parse_mountstats(&path), interval)
+    }
+
+    /// Watch snapshots produced by `source`, e.g. a file inside a
+    /// container's mount namespace or a test fixture.
+    pub fn with_source<F>(source: F, interval: Duration) -> Self
+    where
+        F: FnMut() -> Result<Vec<NFSMount>> + Send + 'static,
+    {
+        Self {
+            source: Box::new(source),
+            interval,
+            selector: MountSelector::default(),
+        }
+    }
+
+    /// Only report mounts matched by `selector`.
+    pub fn selector(mut self, selector: MountSelector) -> Self {
+        self.selector = selector;
+        self
+    }
+
+    /// Start sampling on a background thread. The first snapshot is a
+    /// baseline; the first item arrives after one interval. A failed
+    /// snapshot is delivered as an error and ends the watch.
+    pub fn watch(self) -> Watch {
+        let stop = Arc::new(AtomicBool::new(false));
+        let (tx, rx) = mpsc::sync_channel(CHANNEL_DEPTH);
+        let thread_stop = stop.clone();
+        let handle = thread::spawn(move || self.run(tx, &thread_stop));
+        Watch {
+            rx,
+            stop,
+            handle: Some(handle),
+        }
+    }
+
+    fn run(mut self, tx: SyncSender<Result<IntervalStats>>, stop: &AtomicBool) {
+        let mut tracker = MountTracker::new(self.selector.clone());
+        let mut last = Instant::now();
+        match (self.source)() {
+            Ok(mounts) => {
+                tracker.observe(mounts, self.interval.as_secs_f64());
+            }
+            Err(e) => {
+                let _ = tx.send(Err(e));
+                return;
+            }
+        }
+        loop {
+            let due = last + self.interval;
+            while Instant::now() < due {
+                if stop.load(Ordering::SeqCst) {
+                    return;
+                }
+                thread::sleep(STOP_POLL.min(due.saturating_duration_since(Instant::now())));
+            }
+            let secs = last.elapsed().as_secs_f64();
+            last = Instant::now();
+            let item = (self.source)().map(|mounts| {
+                let update = tracker.observe(mounts, secs);
+                IntervalStats {
+                    timestamp: Utc::now(),
+                    interval_secs: secs,
+                    mounts: update.intervals,
+                    events: update.events,
+                }
+            });
+            let failed = item.is_err();
+            if tx.send(item).is_err() || failed {
+                return;
+            }
+        }
+    }
+}
+
+/// A running watch. Dropping it stops the sampler.
+pub struct Watch {
+    rx: Receiver<Result<IntervalStats>>,
+    stop: Arc<AtomicBool>,
+    handle: Option<JoinHandle<()>>,
+}
+
+impl Watch {
+    /// Wait for the next interval; `None` once the watch has ended.
+    pub fn recv(&self) -> Option<Result<IntervalStats>> {
+        self.rx.recv().ok()
+    }
+
+    pub fn recv_timeout(&self, timeout: Duration) -> Option<Result<IntervalStats>> {
+        self.rx.recv_timeout(timeout).ok()
+    }
+
+    pub fn iter(&self) -> impl Iterator<Item = Result<IntervalStats>> + '_ {
+        self.rx.iter()
+    }
+
+    pub fn stop(mut self) {
+        self.shutdown();
+    }
+
+    fn shutdown(&mut self) {
+        self.stop.store(true, Ordering::SeqCst);
+        if let Some(handle) = self.handle.take() {
+            // Unblock a sampler waiting on a full channel.
+            while self.rx.try_recv().is_ok() {}
+            let _ = handle.join();
+        }
+    }
+}
+
+impl Drop for Watch {
+    fn drop(&mut self) {
+        self.shutdown();
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil::mount;
+    use crate::types::{NFSOperation, NfsGazeError};
+
+    fn snapshot(mount_point: &str, ops: i64) -> NFSMount {
+        let mut m = mount(mount_point);
+        m.operations.insert(
+            "READ".to_string(),
+            NFSOperation {
+                name: "READ".to_string(),
+                ops,
+                ntrans: ops,
+                ..Default::default()
+            },
+        );
+        m
+    }
+
+    #[test]
+    fn test_watch_delivers_intervals() {
+        let mut n = 0;
+        let watch = Watcher::with_source(
+            move || {
+                n += 100;
+                Ok(vec![snapshot("/mnt/a", n)])
+            },
+            Duration::from_millis(10),
+        )
+        .watch();
+
+        let first = watch.recv().unwrap().unwrap();
+        assert_eq!(first.mounts.len(), 1);
+        assert_eq!(first.mounts[0].stats[0].delta_ops, 100);
+        assert!(watch.recv().unwrap().is_ok());
+        watch.stop();
+    }
+
+    #[test]
+    fn test_watch_ends_on_error() {
+        let mut calls = 0;
+        let watch = Watcher::with_source(
+            move || {
+                calls += 1;
+                if calls > 1 {
+                    Err(NfsGazeError::ParseError("gone".to_string()))
+                } else {
+                    Ok(vec![snapshot("/mnt/a", 1)])
+                }
+            },
+            Duration::from_millis(10),
+        )
+        .watch();
+
+        assert!(watch.recv().unwrap().is_err());
+        assert!(watch.recv().is_none());
+    }
+}
@@ -0,0 +1,259 @@
+//! `--watch-op`: every raw and derived field of one operation, across all
+//! monitored mounts.
+
+use crate::types::{NFSMount, NFSOperation};
+use clap::Args;
+use std::collections::HashMap;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct WatchOpArgs {
+    /// Dedicate the display to one operation (e.g. READ) across all mounts
+    #[arg(long = "watch-op", value_name = "OP")]
+    pub watch_op: Option<String>,
+}
+
+/// Raw counter deltas for one operation on one mount.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct WatchRow {
+    pub mount_point: String,
+    pub ops: i64,
+    pub ntrans: i64,
+    pub timeouts: i64,
+    pub bytes_sent: i64,
+    pub bytes_recv: i64,
+    pub queue_time: i64,
+    pub rtt: i64,
+    pub execute_time: i64,
+    pub errors: i64,
+    pub interval_secs: f64,
+}
+
+impl WatchRow {
+    fn per_op(&self, total: i64) -> f64 {
+        if self.ops > 0 {
+            total as f64 / self.ops as f64
+        } else {
+            0.0
+        }
+    }
+
+    pub fn retrans(&self) -> i64 {
+        (self.ntrans - self.ops).max(0)
+    }
+
+    pub fn avg_queue(&self) -> f64 {
+        self.per_op(self.queue_time)
+    }
+
+    pub fn avg_rtt(&self) -> f64 {
+        self.per_op(self.rtt)
+    }
+
+    pub fn avg_exec(&self) -> f64 {
+        self.per_op(self.execute_time)
+    }
+
+    pub fn sent_kb_per_op(&self) -> f64 {
+        self.per_op(self.bytes_sent) / 1024.0
+    }
+
+    pub fn recv_kb_per_op(&self) -> f64 {
+        self.per_op(self.bytes_recv) / 1024.0
+    }
+
+    pub fn iops(&self) -> f64 {
+        if self.interval_secs > 0.0 {
+            self.ops as f64 / self.interval_secs
+        } else {
+            0.0
+        }
+    }
+
+    pub fn kb_per_sec(&self) -> f64 {
+        if self.interval_secs > 0.0 {
+            (self.bytes_sent + self.bytes_recv) as f64 / 1024.0 / self.interval_secs
+        } else {
+            0.0
+        }
+    }
+
+    pub fn error_pct(&self) -> f64 {
+        self.per_op(self.errors) * 100.0
+    }
+}
+
+fn delta(mount_point: &str, prev: &NFSOperation, cur: &NFSOperation, secs: f64) -> WatchRow {
+    WatchRow {
+        mount_point: mount_point.to_string(),
+        ops: cur.ops - prev.ops,
+        ntrans: cur.ntrans - prev.ntrans,
+        timeouts: cur.timeouts - prev.timeouts,
+        bytes_sent: cur.bytes_sent - prev.bytes_sent,
+        bytes_recv: cur.bytes_recv - prev.bytes_recv,
+        queue_time: cur.queue_time - prev.queue_time,
+        rtt: cur.rtt - prev.rtt,
+        execute_time: cur.execute_time - prev.execute_time,
+        errors: cur.errors - prev.errors,
+        interval_secs: secs,
+    }
+}
+
+/// One row per mount that reports `operation` in both snapshots, in
+/// mount-point order. Mounts whose counters went backwards are skipped.
+pub fn watch_rows(
+    operation: &str,
+    before: &[NFSMount],
+    after: &[NFSMount],
+    interval_secs: f64,
+) -> Vec<WatchRow> {
+    let operation = operation.to_uppercase();
+    let previous: HashMap<&str, &NFSMount> =
+        before.iter().map(|m| (m.mount_point.as_str(), m)).collect();
+
+    let mut rows: Vec<WatchRow> = after
+        .iter()
+        .filter_map(|m| {
+            let prev = previous
+                .get(m.mount_point.as_str())?
+                .operations
+                .get(&operation)?;
+            let cur = m.operations.get(&operation)?;
+            let row = delta(&m.mount_point, prev, cur, interval_secs);
+            (row.ops >= 0).then_some(row)
+        })
+        .collect();
+    rows.sort_by(|a, b| a.mount_point.cmp(&b.mount_point));
+    rows
+}
+
+pub fn display_watch_op<W: Write>(
+    writer: &mut W,
+    operation: &str,
+    rows: &[WatchRow],
+) -> io::Result<()> {
+    writeln!(writer, "Watching {}", operation.to_uppercase())?;
+    if rows.is_empty() {
+        writeln!(writer, "  no mounts report this operation")?;
+        writeln!(writer)?;
+        return Ok(());
+    }
+
+    writeln!(
+        writer,
+        "{:<24} {:>8} {:>8} {:>7} {:>8} {:>12} {:>12} {:>9} {:>9} {:>9} {:>7}",
+        "MOUNT",
+        "OPS",
+        "NTRANS",
+        "TMOUT",
+        "ERRORS",
+        "SENT(B)",
+        "RECV(B)",
+        "QUEUE(ms)",
+        "RTT(ms)",
+        "EXEC(ms)",
+        "ERR%"
+    )?;
+    writeln!(
+        writer,
+        "{:<24} {:>8} {:>8} {:>7} {:>8} {:>12} {:>12} {:>9} {:>9} {:>9}",
+        "", "IOPS", "RETRANS", "", "", "KB/OP OUT", "KB/OP IN", "AVG Q", "AVG RTT", "AVG EXEC"
+    )?;
+    writeln!(writer, "{}", "-".repeat(130))?;
+    for row in rows {
+        writeln!(
+            writer,
+            "{:<24} {:>8} {:>8} {:>7} {:>8} {:>12} {:>12} {:>9} {:>9} {:>9} {:>7.2}",
+            row.mount_point,
+            row.ops,
+            row.ntrans,
+            row.timeouts,
+            row.errors,
+            row.bytes_sent,
+            row.bytes_recv,
+            row.queue_time,
+            row.rtt,
+            row.execute_time,
+            row.error_pct()
+        )?;
+        writeln!(
+            writer,
+            "{:<24} {:>8.1} {:>8} {:>7} {:>8} {:>12.2} {:>12.2} {:>9.3} {:>9.3} {:>9.3}   {:.1} KB/s",
+            "",
+            row.iops(),
+            row.retrans(),
+            "",
+            "",
+            row.sent_kb_per_op(),
+            row.recv_kb_per_op(),
+            row.avg_queue(),
+            row.avg_rtt(),
+            row.avg_exec(),
+            row.kb_per_sec()
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::testutil;
+
+    fn mount(mount_point: &str, read: NFSOperation) -> NFSMount {
+        testutil::with_ops(testutil::mount(mount_point), [read])
+    }
+
+    fn read(ops: i64, ntrans: i64, rtt: i64, recv: i64) -> NFSOperation {
+        NFSOperation {
+            ops,
+            ntrans,
+            rtt,
+            execute_time: rtt + ops,
+            bytes_sent: ops * 128,
+            bytes_recv: recv,
+            ..testutil::op("READ")
+        }
+    }
+
+    #[test]
+    fn test_watch_rows() {
+        let before = [
+            mount("/b", read(0, 0, 0, 0)),
+            mount("/a", read(10, 10, 10, 0)),
+        ];
+        let after = [
+            mount("/b", read(100, 105, 300, 100 * 65536)),
+            mount("/a", read(10, 10, 10, 0)),
+        ];
+        let rows = watch_rows("read", &before, &after, 10.0);
+        assert_eq!(rows.len(), 2);
+        assert_eq!(rows[0].mount_point, "/a");
+
+        let b = &rows[1];
+        assert_eq!(b.retrans(), 5);
+        assert!((b.avg_rtt() - 3.0).abs() < 1e-9);
+        assert!((b.avg_exec() - 4.0).abs() < 1e-9);
+        assert!((b.recv_kb_per_op() - 64.0).abs() < 1e-9);
+        assert!((b.iops() - 10.0).abs() < 1e-9);
+
+        assert!(watch_rows("WRITE", &before, &after, 10.0).is_empty());
+    }
+
+    #[test]
+    fn test_display() {
+        let rows = watch_rows(
+            "READ",
+            &[mount("/a", read(0, 0, 0, 0))],
+            &[mount("/a", read(4, 4, 8, 4096))],
+            1.0,
+        );
+        let mut out = Vec::new();
+        display_watch_op(&mut out, "read", &rows).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.starts_with("Watching READ\n"));
+        assert!(text.contains("/a"));
+        assert!(text.contains("4.5 KB/s"));
+    }
+}
@@ -0,0 +1,138 @@
+//! Minimal server-side WebSocket support for `serve`'s `/stream`
+//! endpoint: the opening handshake and unmasked text frames, which is all
+//! a push-only feed needs (RFC 6455). Frames sent by clients are never
+//! read; a client that goes away is dropped on the next failed write.
+
+use crate::exporter::Request;
+use base64::engine::general_purpose::STANDARD;
+use base64::Engine;
+use sha1::{Digest, Sha1};
+use std::io::{self, Write};
+use std::net::TcpStream;
+use std::sync::Mutex;
+use std::time::Duration;
+
+const ACCEPT_GUID: &str = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11";
+
+/// A client that stops reading long enough to fill its socket buffer is
+/// dropped rather than allowed to stall the sampler.
+const WRITE_TIMEOUT: Duration = Duration::from_secs(2);
+
+/// The `Sec-WebSocket-Accept` value for a client's key.
+pub fn accept_key(client_key: &str) -> String {
+    STANDARD.encode(Sha1::digest(format!(
+        "{}{}",
+        client_key.trim(),
+        ACCEPT_GUID
+    )))
+}
+
+/// Whether `request` asks to upgrade to a WebSocket.
+pub(crate) fn is_upgrade(request: &Request) -> bool {
+    request
+        .header("Upgrade")
+        .is_some_and(|v| v.eq_ignore_ascii_case("websocket"))
+        && request.header("Sec-WebSocket-Key").is_some()
+}
+
+/// Complete the handshake for an upgrade request.
+pub(crate) fn accept(stream: &mut TcpStream, request: &Request) -> io::Result<()> {
+    let key = request.header("Sec-WebSocket-Key").unwrap_or_default();
+    write!(
+        stream,
+        "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: {}\r\n\r\n",
+        accept_key(key)
+    )?;
+    stream.set_write_timeout(Some(WRITE_TIMEOUT))?;
+    stream.flush()
+}
+
+/// A single unmasked, unfragmented text frame.
+pub fn text_frame(payload: &str) -> Vec<u8> {
+    let bytes = payload.as_bytes();
+    let mut frame = Vec::with_capacity(bytes.len() + 10);
+    frame.push(0x81);
+    match bytes.len() {
+        len @ 0..=125 => frame.push(len as u8),
+        len @ 126..=0xffff => {
+            frame.push(126);
+            frame.extend_from_slice(&(len as u16).to_be_bytes());
+        }
+        len => {
+            frame.push(127);
+            frame.extend_from_slice(&(len as u64).to_be_bytes());
+        }
+    }
+    frame.extend_from_slice(bytes);
+    frame
+}
+
+/// Connected stream clients.
+#[derive(Default)]
+pub struct Hub {
+    clients: Mutex<Vec<TcpStream>>,
+}
+
+impl Hub {
+    pub fn add(&self, stream: TcpStream) {
+        if let Ok(mut clients) = self.clients.lock() {
+            clients.push(stream);
+        }
+    }
+
+    pub fn len(&self) -> usize {
+        self.clients.lock().map_or(0, |c| c.len())
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.len() == 0
+    }
+
+    /// Send `payload` to every client, dropping those that fail.
+    pub fn broadcast(&self, payload: &str) {
+        let frame = text_frame(payload);
+        if let Ok(mut clients) = self.clients.lock() {
+            clients.retain_mut(|c| c.write_all(&frame).and_then(|_| c.flush()).is_ok());
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_accept_key() {
+        // Example from RFC 6455 section 1.3.
+        assert_eq!(
+            accept_key("dGhlIHNhbXBsZSBub25jZQ=="),
+            "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+        );
+    }
+
+    #[test]
+    fn test_text_frame() {
+        assert_eq!(text_frame("hi"), vec![0x81, 2, b'h', b'i']);
+        let medium = "x".repeat(300);
+        let frame = text_frame(&medium);
+        assert_eq!(&frame[..4], &[0x81, 126, 1, 44]);
+        assert_eq!(frame.len(), 304);
+        let large = "x".repeat(70_000);
+        assert_eq!(text_frame(&large)[1], 127);
+    }
+
+    #[test]
+    fn test_upgrade_detection() {
+        let mut request = Request {
+            method: "GET".to_string(),
+            target: "/stream".to_string(),
+            headers: vec![("upgrade".to_string(), "WebSocket".to_string())],
+            ..Default::default()
+        };
+        assert!(!is_upgrade(&request));
+        request
+            .headers
+            .push(("Sec-WebSocket-Key".to_string(), "abc".to_string()));
+        assert!(is_upgrade(&request));
+    }
+}
@@ -0,0 +1,126 @@
+//! Wide-event output: one flat JSON object per mount per interval, with
+//! every operation's metrics as top-level fields, for Honeycomb- or
+//! ClickHouse-style backends.
+
+use crate::aggregate::total_stats;
+use crate::labels::Labels;
+use crate::types::{DeltaStats, NFSMount};
+use chrono::{DateTime, SecondsFormat, Utc};
+use clap::Args;
+use serde_json::{Map, Value};
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct WideEventArgs {
+    /// Write one wide JSON event per mount per interval to FILE ("-" for stdout)
+    #[arg(long = "wide-events", value_name = "FILE")]
+    pub wide_events: Option<String>,
+}
+
+fn insert_stat(fields: &mut Map<String, Value>, prefix: &str, stat: &DeltaStats) {
+    let mut put = |name: &str, value: Value| {
+        fields.insert(format!("{}.{}", prefix, name), value);
+    };
+    put("ops", stat.delta_ops.into());
+    put("bytes", stat.delta_bytes.into());
+    put("bytes_sent", stat.delta_sent.into());
+    put("bytes_recv", stat.delta_recv.into());
+    put("errors", stat.delta_errors.into());
+    put("retrans", stat.delta_retrans.into());
+    put("iops", stat.iops.into());
+    put("kb_per_sec", stat.kb_per_sec.into());
+    put("kb_per_op", stat.kb_per_op.into());
+    put("avg_rtt_ms", stat.avg_rtt.into());
+    put("avg_exec_ms", stat.avg_exec.into());
+    put("avg_queue_ms", stat.avg_queue.into());
+}
+
+/// Build the event for one mount's interval. Operations with no activity
+/// are left out to keep events compact; `total.*` is always present.
+pub fn wide_event(
+    mount: &NFSMount,
+    timestamp: DateTime<Utc>,
+    interval_secs: f64,
+    stats: &[DeltaStats],
+    labels: &Labels,
+) -> Value {
+    let mut fields = Map::new();
+    fields.insert(
+        "timestamp".to_string(),
+        timestamp
+            .to_rfc3339_opts(SecondsFormat::Millis, true)
+            .into(),
+    );
+    fields.insert("mount_point".to_string(), mount.mount_point.clone().into());
+    fields.insert("server".to_string(), mount.server.clone().into());
+    fields.insert("export".to_string(), mount.export.clone().into());
+    fields.insert("device".to_string(), mount.device.clone().into());
+    fields.insert("mount_age_secs".to_string(), mount.age.into());
+    fields.insert("interval_secs".to_string(), interval_secs.into());
+
+    insert_stat(&mut fields, "total", &total_stats("total", stats));
+    for stat in stats
+        .iter()
+        .filter(|s| s.delta_ops > 0 || s.delta_retrans > 0)
+    {
+        insert_stat(&mut fields, &stat.operation.to_lowercase(), stat);
+    }
+    labels.extend_json(&mut fields);
+    Value::Object(fields)
+}
+
+/// Write `event` as a single JSON line.
+pub fn write_wide_event<W: Write>(writer: &mut W, event: &Value) -> io::Result<()> {
+    serde_json::to_writer(&mut *writer, event)?;
+    writeln!(writer)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use chrono::TimeZone;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_wide_event() {
+        let mount = NFSMount {
+            device: "filer:/vol".to_string(),
+            mount_point: "/mnt/vol".to_string(),
+            server: "filer".to_string(),
+            export: "/vol".to_string(),
+            age: 42,
+            operations: HashMap::new(),
+            events: None,
+            bytes_read: 0,
+            bytes_write: 0,
+        };
+        let ts = Utc.with_ymd_and_hms(2024, 3, 1, 0, 0, 0).unwrap();
+        let mut labels = Labels::new();
+        labels.add("env=prod").unwrap();
+        let event = wide_event(
+            &mount,
+            ts,
+            1.0,
+            &[
+                stat("READ", 100, 2.0),
+                stat("WRITE", 300, 4.0),
+                stat("NULL", 0, 0.0),
+            ],
+            &labels,
+        );
+
+        assert_eq!(event["timestamp"], "2024-03-01T00:00:00.000Z");
+        assert_eq!(event["mount_point"], "/mnt/vol");
+        assert_eq!(event["read.ops"], 100);
+        assert_eq!(event["write.avg_rtt_ms"], 4.0);
+        assert_eq!(event["total.ops"], 400);
+        assert_eq!(event["total.avg_rtt_ms"], 3.5);
+        assert!(event.get("null.ops").is_none());
+        assert_eq!(event["env"], "prod");
+
+        let mut out = Vec::new();
+        write_wide_event(&mut out, &event).unwrap();
+        assert_eq!(out.iter().filter(|&&b| b == b'\n').count(), 1);
+    }
+}
@@ -0,0 +1,211 @@
+//! Per-mount writeback and dirty-page pressure from the NFS superblock's
+//! BDI statistics.
+//!
+//! Writeback throttling stalls writers in balance_dirty_pages() before a
+//! WRITE is ever sent, so it never shows up in the RPC latency columns.
+
+use crate::mountinfo::MountInfo;
+use crate::types::{NfsGazeError, Result};
+use clap::Args;
+use std::collections::HashMap;
+use std::fs;
+use std::io::{self, Write};
+use std::path::Path;
+
+#[derive(Args, Debug, Clone)]
+pub struct WritebackArgs {
+    /// Show dirty/writeback page levels per mount
+    #[arg(long = "writeback")]
+    pub writeback: bool,
+
+    /// debugfs mount point (per-BDI stats live under bdi/)
+    #[arg(long = "debugfs", default_value = "/sys/kernel/debug")]
+    pub debugfs: String,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct BdiStats {
+    pub writeback_kb: u64,
+    pub reclaimable_kb: u64,
+    pub bdi_dirty_thresh_kb: u64,
+    pub dirty_thresh_kb: u64,
+    pub background_thresh_kb: u64,
+    pub dirtied_kb: u64,
+    pub written_kb: u64,
+    pub write_bandwidth_kbps: u64,
+}
+
+/// Parse a debugfs `bdi/<dev>/stats` file.
+pub fn parse_bdi_stats(contents: &str) -> Result<BdiStats> {
+    let values: HashMap<&str, u64> = contents
+        .lines()
+        .filter_map(|line| {
+            let (key, rest) = line.split_once(':')?;
+            let value = rest.split_whitespace().next()?.parse().ok()?;
+            Some((key.trim(), value))
+        })
+        .collect();
+
+    let get = |key: &str| -> Result<u64> {
+        values
+            .get(key)
+            .copied()
+            .ok_or_else(|| NfsGazeError::ParseError(format!("bdi stats missing {}", key)))
+    };
+
+    Ok(BdiStats {
+        writeback_kb: get("BdiWriteback")?,
+        reclaimable_kb: get("BdiReclaimable")?,
+        bdi_dirty_thresh_kb: get("BdiDirtyThresh")?,
+        dirty_thresh_kb: get("DirtyThresh")?,
+        background_thresh_kb: get("BackgroundThresh")?,
+        dirtied_kb: get("BdiDirtied").unwrap_or(0),
+        written_kb: get("BdiWritten").unwrap_or(0),
+        write_bandwidth_kbps: get("BdiWriteBandwidth").unwrap_or(0),
+    })
+}
+
+pub fn read_bdi_stats(debugfs: &str, device: &str) -> Result<BdiStats> {
+    let path = Path::new(debugfs).join("bdi").join(device).join("stats");
+    parse_bdi_stats(&fs::read_to_string(path)?)
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct WritebackRow {
+    pub mount_point: String,
+    pub dirty_kb: u64,
+    pub writeback_kb: u64,
+    /// Dirty + writeback as a share of this BDI's dirty threshold.
+    pub thresh_pct: f64,
+    pub dirtied_kbps: f64,
+    pub written_kbps: f64,
+    /// WRITE average RTT for the same interval, if any WRITEs completed.
+    pub write_rtt_ms: Option<f64>,
+}
+
+impl WritebackRow {
+    /// Writers get throttled as the BDI approaches its threshold.
+    pub fn throttled(&self) -> bool {
+        self.thresh_pct >= 90.0
+    }
+}
+
+pub fn writeback_row(
+    mount_point: &str,
+    before: &BdiStats,
+    after: &BdiStats,
+    interval_secs: f64,
+    write_rtt_ms: Option<f64>,
+) -> WritebackRow {
+    let interval = interval_secs.max(f64::EPSILON);
+    let pending = after.reclaimable_kb + after.writeback_kb;
+    let thresh_pct = if after.bdi_dirty_thresh_kb > 0 {
+        pending as f64 * 100.0 / after.bdi_dirty_thresh_kb as f64
+    } else {
+        0.0
+    };
+    WritebackRow {
+        mount_point: mount_point.to_string(),
+        dirty_kb: after.reclaimable_kb,
+        writeback_kb: after.writeback_kb,
+        thresh_pct,
+        dirtied_kbps: after.dirtied_kb.saturating_sub(before.dirtied_kb) as f64 / interval,
+        written_kbps: after.written_kb.saturating_sub(before.written_kb) as f64 / interval,
+        write_rtt_ms,
+    }
+}
+
+/// Sample the BDI of every mount in `mounts`, keyed by mount point.
+/// Mounts whose stats cannot be read (no debugfs, no permission) are
+/// left out.
+pub fn sample(debugfs: &str, mounts: &HashMap<String, MountInfo>) -> HashMap<String, BdiStats> {
+    mounts
+        .iter()
+        .filter_map(|(mp, info)| {
+            read_bdi_stats(debugfs, &info.device)
+                .ok()
+                .map(|stats| (mp.clone(), stats))
+        })
+        .collect()
+}
+
+pub fn display_writeback<W: Write>(writer: &mut W, rows: &[WritebackRow]) -> io::Result<()> {
+    if rows.is_empty() {
+        return Ok(());
+    }
+
+    writeln!(
+        writer,
+        "{:<24} {:>10} {:>10} {:>8} {:>12} {:>12} {:>10}",
+        "MOUNT", "DIRTY(MB)", "WB(MB)", "THRESH%", "DIRTY MB/s", "WRITTEN MB/s", "WRITE RTT"
+    )?;
+    writeln!(writer, "{}", "-".repeat(90))?;
+    for row in rows {
+        let rtt = row
+            .write_rtt_ms
+            .map(|r| format!("{:.1}ms", r))
+            .unwrap_or_else(|| "-".to_string());
+        writeln!(
+            writer,
+            "{:<24} {:>10.1} {:>10.1} {:>7.0}% {:>12.2} {:>12.2} {:>10}{}",
+            row.mount_point,
+            row.dirty_kb as f64 / 1024.0,
+            row.writeback_kb as f64 / 1024.0,
+            row.thresh_pct,
+            row.dirtied_kbps / 1024.0,
+            row.written_kbps / 1024.0,
+            rtt,
+            if row.throttled() { "  THROTTLED" } else { "" }
+        )?;
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const STATS: &str = "\
+BdiWriteback:            2048 kB
+BdiReclaimable:         14336 kB
+BdiDirtyThresh:         16384 kB
+DirtyThresh:           409600 kB
+BackgroundThresh:      204800 kB
+BdiDirtied:           1000000 kB
+BdiWritten:            900000 kB
+BdiWriteBandwidth:     102400 kBps
+b_dirty:                    3
+b_io:                       0
+b_more_io:                  0
+b_dirty_time:               0
+bdi_list:                   1
+state:                      1
+";
+
+    #[test]
+    fn test_parse_bdi_stats() {
+        let stats = parse_bdi_stats(STATS).unwrap();
+        assert_eq!(stats.writeback_kb, 2048);
+        assert_eq!(stats.reclaimable_kb, 14336);
+        assert_eq!(stats.bdi_dirty_thresh_kb, 16384);
+        assert_eq!(stats.write_bandwidth_kbps, 102400);
+
+        assert!(parse_bdi_stats("BdiWriteback: 1 kB\n").is_err());
+    }
+
+    #[test]
+    fn test_writeback_row() {
+        let before = parse_bdi_stats(STATS).unwrap();
+        let mut after = before.clone();
+        after.dirtied_kb += 20480;
+        after.written_kb += 10240;
+
+        let row = writeback_row("/mnt/nfs", &before, &after, 2.0, Some(35.0));
+        assert_eq!(row.dirty_kb, 14336);
+        assert!((row.thresh_pct - 100.0).abs() < 1e-9);
+        assert!(row.throttled());
+        assert!((row.dirtied_kbps - 10240.0).abs() < 1e-9);
+        assert!((row.written_kbps - 5120.0).abs() < 1e-9);
+    }
+}
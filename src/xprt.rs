@@ -0,0 +1,453 @@
+//! RPC transport statistics from the `xprt:` lines.
+//!
+//! Layouts, after the protocol name (statvers 1.1; 1.0 lacks the last
+//! three of the common fields):
+//!
+//! - udp: port bind_count sends recvs bad_xids req_u bklog_u max_slots
+//!   sending_u pending_u
+//! - tcp: port bind_count connect_count connect_time idle_time sends recvs
+//!   bad_xids req_u bklog_u max_slots sending_u pending_u
+//! - rdma: as tcp up to bklog_u, followed by RDMA-specific counters
+//!
+//! With nconnect there is one line per connection.
+
+use crate::sections::MountSection;
+use clap::Args;
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::io::{self, Write};
+
+#[derive(Args, Debug, Clone)]
+pub struct TransportArgs {
+    /// Show RPC transport statistics for each mount's connections
+    #[arg(long = "transports")]
+    pub transports: bool,
+}
+
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize)]
+pub struct NFSTransport {
+    pub protocol: String,
+    pub port: u64,
+    pub bind_count: u64,
+    /// Zero for udp, which does not connect.
+    pub connect_count: u64,
+    /// Jiffies spent connecting.
+    pub connect_time: u64,
+    /// Seconds since the transport was last used.
+    pub idle_time: u64,
+    pub sends: u64,
+    pub recvs: u64,
+    pub bad_xids: u64,
+    /// Sum of requests in flight, sampled at each send.
+    pub req_u: u64,
+    /// Sum of the backlog queue length, sampled at each send.
+    pub bklog_u: u64,
+    pub max_slots: Option<u64>,
+    pub sending_u: Option<u64>,
+    pub pending_u: Option<u64>,
+    /// RDMA-only counters, in kernel order.
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub rdma: Vec<u64>,
+}
+
+impl NFSTransport {
+    /// Parse the value of an `xprt:` line.
+    pub fn parse(value: &str) -> Option<Self> {
+        let mut fields = value.split_whitespace();
+        let protocol = fields.next()?.to_string();
+        let nums: Vec<u64> = fields.map(|f| f.parse().ok()).collect::<Option<_>>()?;
+
+        let (connect, rest) = match protocol.as_str() {
+            "udp" | "udp6" => {
+                if nums.len() < 7 {
+                    return None;
+                }
+                ([0, 0, 0], &nums[2..])
+            }
+            _ => {
+                if nums.len() < 10 {
+                    return None;
+                }
+                ([nums[2], nums[3], nums[4]], &nums[5..])
+            }
+        };
+        let is_rdma = protocol.starts_with("rdma");
+        // rest: sends recvs bad_xids req_u bklog_u [max_slots sending_u pending_u | rdma...]
+        let (tail, rdma) = if is_rdma {
+            (&[][..], rest[5..].to_vec())
+        } else {
+            (&rest[5..], Vec::new())
+        };
+        Some(Self {
+            port: nums[0],
+            bind_count: nums[1],
+            connect_count: connect[0],
+            connect_time: connect[1],
+            idle_time: connect[2],
+            sends: rest[0],
+            recvs: rest[1],
+            bad_xids: rest[2],
+            req_u: rest[3],
+            bklog_u: rest[4],
+            max_slots: tail.first().copied(),
+            sending_u: tail.get(1).copied(),
+            pending_u: tail.get(2).copied(),
+            rdma,
+            protocol,
+        })
+    }
+
+    /// Counters accumulated since `prev`; gauges and identity are taken
+    /// from `self`.
+    pub fn delta(&self, prev: &NFSTransport) -> NFSTransport {
+        let d = |a: u64, b: u64| a.saturating_sub(b);
+        let dopt = |a: Option<u64>, b: Option<u64>| a.map(|a| d(a, b.unwrap_or(0)));
+        NFSTransport {
+            bind_count: d(self.bind_count, prev.bind_count),
+            connect_count: d(self.connect_count, prev.connect_count),
+            connect_time: d(self.connect_time, prev.connect_time),
+            sends: d(self.sends, prev.sends),
+            recvs: d(self.recvs, prev.recvs),
+            bad_xids: d(self.bad_xids, prev.bad_xids),
+            req_u: d(self.req_u, prev.req_u),
+            bklog_u: d(self.bklog_u, prev.bklog_u),
+            sending_u: dopt(self.sending_u, prev.sending_u),
+            pending_u: dopt(self.pending_u, prev.pending_u),
+            rdma: self
+                .rdma
+                .iter()
+                .enumerate()
+                .map(|(i, v)| d(*v, prev.rdma.get(i).copied().unwrap_or(0)))
+                .collect(),
+            ..self.clone()
+        }
+    }
+
+    /// For an interval delta: requests were transmitted but no reply came
+    /// back. Per-op counters only move when a request completes, so this
+    /// is how a server that stopped answering looks from the client. The
+    /// backlog and pending sums are sampled on send and add nothing here.
+    pub fn is_stalled(&self) -> bool {
+        self.sends > 0 && self.recvs == 0
+    }
+
+    /// Average requests in flight per send.
+    pub fn avg_slots_in_use(&self) -> f64 {
+        if self.sends == 0 {
+            0.0
+        } else {
+            self.req_u as f64 / self.sends as f64
+        }
+    }
+
+    /// Average slots in use as a share of the slot table.
+    pub fn slot_utilization_pct(&self) -> Option<f64> {
+        self.max_slots
+            .filter(|&m| m > 0)
+            .map(|m| self.avg_slots_in_use() * 100.0 / m as f64)
+    }
+}
+
+/// Every transport of a mount; more than one with nconnect.
+pub fn transports(section: &MountSection) -> Vec<NFSTransport> {
+    section
+        .values("xprt")
+        .filter_map(NFSTransport::parse)
+        .collect()
+}
+
+pub fn transports_by_mount(sections: &[MountSection]) -> BTreeMap<String, Vec<NFSTransport>> {
+    sections
+        .iter()
+        .map(|s| (s.mount_point.clone(), transports(s)))
+        .collect()
+}
+
+/// Pair transports by protocol and port to diff consecutive samples; a
+/// transport with no earlier match is reported with its full counters.
+pub fn delta_transports(prev: &[NFSTransport], cur: &[NFSTransport]) -> Vec<NFSTransport> {
+    cur.iter()
+        .map(|t| {
+            match prev
+                .iter()
+                .find(|p| p.protocol == t.protocol && p.port == t.port)
+            {
+                Some(p) => t.delta(p),
+                None => t.clone(),
+            }
+        })
+        .collect()
+}
+
+/// RPC sends per second across all of a mount's transports.
+pub fn rpc_ops_per_sec(deltas: &[NFSTransport], sample_secs: f64) -> f64 {
+    if sample_secs <= 0.0 {
+        return 0.0;
+    }
+    deltas.iter().map(|t| t.sends).sum::<u64>() as f64 / sample_secs
+}
+
+/// The `rpc bklog` column as upstream nfsiostat computes it: backlog
+/// utilization per send, divided by the sample time. `deltas` are the
+/// interval's transport deltas, summed across nconnect connections; for a
+/// cumulative report pass full counters and the mount's age.
+pub fn rpc_backlog(deltas: &[NFSTransport], sample_secs: f64) -> f64 {
+    let sends: u64 = deltas.iter().map(|t| t.sends).sum();
+    let bklog: u64 = deltas.iter().map(|t| t.bklog_u).sum();
+    if sends == 0 || sample_secs <= 0.0 {
+        return 0.0;
+    }
+    bklog as f64 / sends as f64 / sample_secs
+}
+
+/// The nfsiostat `ops/s  rpc bklog` header and value lines.
+pub fn display_rpc_summary<W: Write>(
+    writer: &mut W,
+    deltas: &[NFSTransport],
+    sample_secs: f64,
+) -> io::Result<()> {
+    writeln!(writer, "{:>16}{:>16}", "ops/s", "rpc bklog")?;
+    writeln!(
+        writer,
+        "{:>16.3}{:>16.3}",
+        rpc_ops_per_sec(deltas, sample_secs),
+        rpc_backlog(deltas, sample_secs)
+    )
+}
+
+/// A hottest connection carrying this multiple of its fair share of
+/// sends is reported as imbalanced.
+pub const IMBALANCE_FACTOR: f64 = 1.5;
+
+/// One row summing every connection of an nconnect mount. Slot tables
+/// add up; idle time is that of the most recently used connection.
+pub fn total_transport(transports: &[NFSTransport]) -> NFSTransport {
+    let sum = |f: fn(&NFSTransport) -> u64| transports.iter().map(f).sum();
+    let sum_opt = |f: fn(&NFSTransport) -> Option<u64>| transports.iter().map(f).sum();
+    NFSTransport {
+        protocol: "total".to_string(),
+        port: 0,
+        bind_count: sum(|t| t.bind_count),
+        connect_count: sum(|t| t.connect_count),
+        connect_time: sum(|t| t.connect_time),
+        idle_time: transports.iter().map(|t| t.idle_time).min().unwrap_or(0),
+        sends: sum(|t| t.sends),
+        recvs: sum(|t| t.recvs),
+        bad_xids: sum(|t| t.bad_xids),
+        req_u: sum(|t| t.req_u),
+        bklog_u: sum(|t| t.bklog_u),
+        max_slots: sum_opt(|t| t.max_slots),
+        sending_u: sum_opt(|t| t.sending_u),
+        pending_u: sum_opt(|t| t.pending_u),
+        rdma: Vec::new(),
+    }
+}
+
+/// The busiest connection and how many times its fair share of sends it
+/// carried; `None` with fewer than two connections or no traffic.
+pub fn send_imbalance(transports: &[NFSTransport]) -> Option<(&NFSTransport, f64)> {
+    let total: u64 = transports.iter().map(|t| t.sends).sum();
+    if transports.len() < 2 || total == 0 {
+        return None;
+    }
+    let hottest = transports.iter().max_by_key(|t| t.sends)?;
+    let fair = total as f64 / transports.len() as f64;
+    Some((hottest, hottest.sends as f64 / fair))
+}
+
+fn write_transport<W: Write>(writer: &mut W, t: &NFSTransport) -> io::Result<()> {
+    let avg_bklog = if t.sends == 0 {
+        0.0
+    } else {
+        t.bklog_u as f64 / t.sends as f64
+    };
+    let port = if t.port == 0 && t.protocol == "total" {
+        "-".to_string()
+    } else {
+        t.port.to_string()
+    };
+    writeln!(
+        writer,
+        "{:<6} {:>6} {:>8} {:>6} {:>10} {:>10} {:>7} {:>6} {:>9.2} {:>6} {:>9.2}",
+        t.protocol,
+        port,
+        t.connect_count,
+        t.idle_time,
+        t.sends,
+        t.recvs,
+        t.bad_xids,
+        t.max_slots
+            .map_or_else(|| "-".to_string(), |m| m.to_string()),
+        t.avg_slots_in_use(),
+        t.slot_utilization_pct()
+            .map_or_else(|| "-".to_string(), |p| format!("{:.1}", p)),
+        avg_bklog
+    )
+}
+
+/// One row per connection; with nconnect, a total row and a note when
+/// one connection carries a disproportionate share of the sends.
+pub fn display_transports<W: Write>(
+    writer: &mut W,
+    mount_point: &str,
+    transports: &[NFSTransport],
+) -> io::Result<()> {
+    if transports.is_empty() {
+        return Ok(());
+    }
+    writeln!(writer, "Transports for {}", mount_point)?;
+    writeln!(
+        writer,
+        "{:<6} {:>6} {:>8} {:>6} {:>10} {:>10} {:>7} {:>6} {:>9} {:>6} {:>9}",
+        "PROTO",
+        "PORT",
+        "CONNECTS",
+        "IDLE",
+        "SENDS",
+        "RECVS",
+        "BADXID",
+        "SLOTS",
+        "AVG USED",
+        "USE%",
+        "AVG BKLOG"
+    )?;
+    writeln!(writer, "{}", "-".repeat(93))?;
+    for t in transports {
+        write_transport(writer, t)?;
+    }
+    if transports.len() > 1 {
+        write_transport(writer, &total_transport(transports))?;
+        if let Some((hottest, factor)) = send_imbalance(transports) {
+            if factor >= IMBALANCE_FACTOR {
+                let total: u64 = transports.iter().map(|t| t.sends).sum();
+                writeln!(
+                    writer,
+                    "NOTE: connection on port {} carried {:.0}% of sends across {} connections",
+                    hottest.port,
+                    hottest.sends as f64 * 100.0 / total as f64,
+                    transports.len()
+                )?;
+            }
+        }
+    }
+    writeln!(writer)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::sections::{parse_sections, tests::MOUNTSTATS};
+
+    #[test]
+    fn test_parse_fixture_transports() {
+        let sections = parse_sections(MOUNTSTATS);
+        let tcp = transports(&sections[0]);
+        assert_eq!(tcp.len(), 2);
+        assert_eq!(tcp[0].protocol, "tcp");
+        assert_eq!(tcp[0].port, 869);
+        assert_eq!(tcp[0].connect_count, 1);
+        assert_eq!(tcp[0].idle_time, 5);
+        assert_eq!(tcp[0].sends, 1000);
+        assert_eq!(tcp[0].req_u, 2000);
+        assert_eq!(tcp[0].max_slots, Some(16));
+        assert_eq!(tcp[0].pending_u, Some(50));
+        assert!((tcp[0].avg_slots_in_use() - 2.0).abs() < 1e-9);
+        assert!((tcp[0].slot_utilization_pct().unwrap() - 12.5).abs() < 1e-9);
+
+        let udp = transports(&sections[1]);
+        assert_eq!(udp[0].protocol, "udp");
+        assert_eq!(udp[0].connect_count, 0);
+        assert_eq!(udp[0].sends, 200);
+        assert_eq!(udp[0].recvs, 10);
+        assert_eq!(udp[0].max_slots, Some(700));
+    }
+
+    #[test]
+    fn test_parse_old_and_rdma_layouts() {
+        let old = NFSTransport::parse("tcp 700 0 1 0 3 50 50 0 60 0").unwrap();
+        assert_eq!(old.sends, 50);
+        assert_eq!(old.max_slots, None);
+
+        let rdma =
+            NFSTransport::parse("rdma 0 0 1 10 0 500 500 0 800 4 1 2 3 4 5 6 7 8 9 10 11").unwrap();
+        assert_eq!(rdma.sends, 500);
+        assert_eq!(rdma.bklog_u, 4);
+        assert_eq!(rdma.max_slots, None);
+        assert_eq!(rdma.rdma.len(), 11);
+
+        assert!(NFSTransport::parse("tcp 1 2").is_none());
+        assert!(NFSTransport::parse("tcp x 1 1 0 5 1 1 0 2 0").is_none());
+    }
+
+    #[test]
+    fn test_delta_transports() {
+        let prev =
+            vec![NFSTransport::parse("tcp 869 1 1 0 5 1000 1000 0 2000 0 16 100 50").unwrap()];
+        let cur = vec![
+            NFSTransport::parse("tcp 869 1 2 3 0 1100 1100 1 2400 10 16 120 60").unwrap(),
+            NFSTransport::parse("tcp 870 1 1 0 0 10 10 0 10 0 16 1 1").unwrap(),
+        ];
+        let delta = delta_transports(&prev, &cur);
+        assert_eq!(delta[0].sends, 100);
+        assert_eq!(delta[0].connect_count, 1);
+        assert_eq!(delta[0].bklog_u, 10);
+        assert!((delta[0].avg_slots_in_use() - 4.0).abs() < 1e-9);
+        assert_eq!(delta[1].sends, 10);
+
+        let mut out = Vec::new();
+        display_transports(&mut out, "/mnt/nfs", &delta).unwrap();
+        assert!(String::from_utf8(out).unwrap().contains("tcp"));
+    }
+
+    #[test]
+    fn test_nconnect_total_and_imbalance() {
+        let transports = vec![
+            NFSTransport::parse("tcp 869 1 1 0 5 900 900 0 2000 0 16 100 50").unwrap(),
+            NFSTransport::parse("tcp 870 1 1 0 2 50 50 0 100 0 16 80 40").unwrap(),
+            NFSTransport::parse("tcp 871 1 1 0 9 50 50 0 100 0 16 80 40").unwrap(),
+        ];
+        let total = total_transport(&transports);
+        assert_eq!(total.sends, 1000);
+        assert_eq!(total.max_slots, Some(48));
+        assert_eq!(total.idle_time, 2);
+        assert_eq!(total.connect_count, 3);
+
+        let (hottest, factor) = send_imbalance(&transports).unwrap();
+        assert_eq!(hottest.port, 869);
+        assert!((factor - 2.7).abs() < 1e-9);
+        assert!(send_imbalance(&transports[..1]).is_none());
+
+        let mut out = Vec::new();
+        display_transports(&mut out, "/mnt/nfs", &transports).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        let lines: Vec<&str> = text.lines().collect();
+        assert!(lines[6].starts_with("total       -"));
+        assert_eq!(
+            lines[7],
+            "NOTE: connection on port 869 carried 90% of sends across 3 connections"
+        );
+
+        // A single connection gets no total row.
+        let mut out = Vec::new();
+        display_transports(&mut out, "/mnt/nfs", &transports[..1]).unwrap();
+        assert!(!String::from_utf8(out).unwrap().contains("total"));
+    }
+
+    #[test]
+    fn test_rpc_backlog() {
+        let deltas = [
+            NFSTransport::parse("tcp 869 0 0 0 0 100 100 0 200 50 16 0 0").unwrap(),
+            NFSTransport::parse("tcp 870 0 0 0 0 100 100 0 200 150 16 0 0").unwrap(),
+        ];
+        // 200 backlog over 200 sends in a 2 second sample.
+        assert!((rpc_backlog(&deltas, 2.0) - 0.5).abs() < 1e-9);
+        assert!((rpc_ops_per_sec(&deltas, 2.0) - 100.0).abs() < 1e-9);
+        assert_eq!(rpc_backlog(&[], 2.0), 0.0);
+
+        let mut out = Vec::new();
+        display_rpc_summary(&mut out, &deltas, 2.0).unwrap();
+        let text = String::from_utf8(out).unwrap();
+        assert!(text.ends_with("         100.000           0.500\n"));
+    }
+}
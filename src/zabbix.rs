@@ -0,0 +1,171 @@
+//! Zabbix integration: low-level discovery JSON for mounts (and their
+//! operations), and a value stream in `zabbix_sender -T -i` input format.
+//!
+//! Item keys take the mount and operation as parameters, e.g.
+//! `nfs.op.rtt[/mnt/data,READ]`, matching the LLD macros `{#MOUNT}` and
+//! `{#OP}` so item prototypes can be written as
+//! `nfs.op.rtt[{#MOUNT},{#OP}]`.
+
+use crate::ordering::sorted_operations;
+use crate::types::{DeltaStats, NFSMount};
+use chrono::{DateTime, Utc};
+use clap::{Args, ValueEnum};
+use serde_json::{json, Value};
+use std::io::{self, Write};
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub enum Discovery {
+    /// One entry per mount
+    Mounts,
+    /// One entry per mount and operation
+    Ops,
+}
+
+#[derive(Args, Debug, Clone)]
+pub struct ZabbixArgs {
+    /// Print Zabbix low-level discovery JSON and exit
+    #[arg(long = "zabbix-discovery", value_enum, value_name = "LEVEL")]
+    pub zabbix_discovery: Option<Discovery>,
+
+    /// Write interval values in zabbix_sender input format to FILE ("-" for stdout)
+    #[arg(long = "zabbix-sender", value_name = "FILE")]
+    pub zabbix_sender: Option<String>,
+
+    /// Host name for sender lines ("-" uses the agent's configured Hostname)
+    #[arg(long = "zabbix-host", default_value = "-")]
+    pub zabbix_host: String,
+}
+
+/// LLD document for `mounts`.
+pub fn discovery_json(mounts: &[NFSMount], level: Discovery) -> Value {
+    let mut data = Vec::new();
+    for mount in mounts {
+        let base = json!({
+            "{#MOUNT}": mount.mount_point,
+            "{#SERVER}": mount.server,
+            "{#EXPORT}": mount.export,
+        });
+        match level {
+            Discovery::Mounts => data.push(base),
+            Discovery::Ops => {
+                for op in sorted_operations(mount) {
+                    let mut entry = base.clone();
+                    entry["{#OP}"] = op.name.clone().into();
+                    data.push(entry);
+                }
+            }
+        }
+    }
+    json!({ "data": data })
+}
+
+/// Quote an item key parameter when Zabbix key syntax requires it.
+fn key_param(value: &str) -> String {
+    if value.contains([',', ']', '"', ' ', '[']) {
+        format!("\"{}\"", value.replace('"', "\\\""))
+    } else {
+        value.to_string()
+    }
+}
+
+/// Quote a sender field if it contains whitespace or quotes.
+fn sender_field(value: &str) -> String {
+    if value.contains([' ', '\t', '"', '\\']) {
+        format!("\"{}\"", value.replace('\\', "\\\\").replace('"', "\\\""))
+    } else {
+        value.to_string()
+    }
+}
+
+/// `zabbix_sender -T` lines for one mount's interval.
+pub fn sender_lines(
+    host: &str,
+    mount_point: &str,
+    timestamp: DateTime<Utc>,
+    stats: &[DeltaStats],
+) -> Vec<String> {
+    let ts = timestamp.timestamp();
+    let host = sender_field(host);
+    let mount = key_param(mount_point);
+    let mut lines = Vec::new();
+    for stat in stats {
+        let values = [
+            ("iops", stat.iops),
+            ("kbps", stat.kb_per_sec),
+            ("rtt", stat.avg_rtt),
+            ("exec", stat.avg_exec),
+            ("retrans", stat.delta_retrans as f64),
+            ("errors", stat.delta_errors as f64),
+        ];
+        let op = key_param(&stat.operation);
+        for (name, value) in values {
+            let key = format!("nfs.op.{}[{},{}]", name, mount, op);
+            lines.push(format!("{} {} {} {}", host, sender_field(&key), ts, value));
+        }
+    }
+    lines
+}
+
+pub fn write_sender_lines<W: Write>(writer: &mut W, lines: &[String]) -> io::Result<()> {
+    for line in lines {
+        writeln!(writer, "{}", line)?;
+    }
+    writer.flush()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::aggregate::tests::stat;
+    use crate::types::NFSOperation;
+    use chrono::TimeZone;
+    use std::collections::HashMap;
+
+    #[test]
+    fn test_discovery() {
+        let op = |name: &str| {
+            (
+                name.to_string(),
+                NFSOperation {
+                    name: name.to_string(),
+                    ..NFSOperation::default()
+                },
+            )
+        };
+        let mount = NFSMount {
+            device: "filer:/vol".to_string(),
+            mount_point: "/mnt/vol".to_string(),
+            server: "filer".to_string(),
+            export: "/vol".to_string(),
+            age: 1,
+            operations: HashMap::from([op("WRITE"), op("READ")]),
+            events: None,
+            bytes_read: 0,
+            bytes_write: 0,
+        };
+
+        let mounts = discovery_json(std::slice::from_ref(&mount), Discovery::Mounts);
+        assert_eq!(mounts["data"].as_array().unwrap().len(), 1);
+        assert_eq!(mounts["data"][0]["{#MOUNT}"], "/mnt/vol");
+
+        let ops = discovery_json(&[mount], Discovery::Ops);
+        assert_eq!(ops["data"][0]["{#OP}"], "READ");
+        assert_eq!(ops["data"][1]["{#OP}"], "WRITE");
+        assert_eq!(ops["data"][1]["{#SERVER}"], "filer");
+    }
+
+    #[test]
+    fn test_sender_lines() {
+        let ts = Utc.with_ymd_and_hms(2024, 3, 1, 0, 0, 0).unwrap();
+        let lines = sender_lines("-", "/mnt/my data", ts, &[stat("READ", 10, 2.5)]);
+        assert_eq!(lines.len(), 6);
+        assert_eq!(
+            lines[0],
+            r#"- "nfs.op.iops[\"/mnt/my data\",READ]" 1709251200 10"#
+        );
+        assert_eq!(
+            sender_lines("web01", "/mnt/a", ts, &[stat("READ", 10, 2.5)])[2],
+            "web01 nfs.op.rtt[/mnt/a,READ] 1709251200 2.5"
+        );
+    }
+}
@@ -5,7 +5,7 @@ use nfs_gaze::cli::Args;
 fn test_cli_default_flags() {
     let args = Args::try_parse_from(&["nfs-gaze"]).expect("Should parse default args");
 
-    assert_eq!(args.mount_point, None);
+    assert!(args.mount_point.is_empty());
     assert_eq!(args.operations, None);
     assert_eq!(args.interval, 1);
     assert_eq!(args.count, 0);
@@ -20,7 +20,7 @@ fn test_cli_with_mount_point() {
     let args = Args::try_parse_from(&["nfs-gaze", "-m", "/mnt/nfs"])
         .expect("Should parse with mount point");
 
-    assert_eq!(args.mount_point, Some("/mnt/nfs".to_string()));
+    assert_eq!(args.mount_point, ["/mnt/nfs"]);
     assert_eq!(args.operations, None);
     assert_eq!(args.interval, 1);
     assert_eq!(args.count, 0);
@@ -30,12 +30,20 @@ fn test_cli_with_mount_point() {
     assert_eq!(args.mountstats_path, "/proc/self/mountstats");
 }
 
+#[test]
+fn test_cli_with_several_mount_points() {
+    let args = Args::try_parse_from(&["nfs-gaze", "-m", "/mnt/a", "-m", "/mnt/b,/mnt/c"])
+        .expect("Should parse repeated mount points");
+
+    assert_eq!(args.mount_point, ["/mnt/a", "/mnt/b,/mnt/c"]);
+}
+
 #[test]
 fn test_cli_with_operations_filter() {
     let args = Args::try_parse_from(&["nfs-gaze", "--ops", "READ,WRITE"])
         .expect("Should parse with operations filter");
 
-    assert_eq!(args.mount_point, None);
+    assert!(args.mount_point.is_empty());
     assert_eq!(args.operations, Some("READ,WRITE".to_string()));
     assert_eq!(args.interval, 1);
     assert_eq!(args.count, 0);
@@ -50,7 +58,7 @@ fn test_cli_with_custom_interval() {
     let args =
         Args::try_parse_from(&["nfs-gaze", "-i", "5"]).expect("Should parse with custom interval");
 
-    assert_eq!(args.mount_point, None);
+    assert!(args.mount_point.is_empty());
     assert_eq!(args.operations, None);
     assert_eq!(args.interval, 5);
     assert_eq!(args.count, 0);
@@ -68,7 +76,7 @@ fn test_cli_with_all_flags() {
     ])
     .expect("Should parse with all flags");
 
-    assert_eq!(args.mount_point, Some("/mnt/nfs".to_string()));
+    assert_eq!(args.mount_point, ["/mnt/nfs"]);
     assert_eq!(args.operations, Some("READ".to_string()));
     assert_eq!(args.interval, 2);
     assert_eq!(args.count, 10);